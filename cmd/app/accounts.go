@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/mshan/go-tsk/internal/api"
+)
+
+// runAccounts prints the status of every account of the running daemon;
+// "accounts add" adds an account to a config file
+func runAccounts(args []string) error {
+	if len(args) > 0 && args[0] == "add" {
+		return runAccountsAdd(args[1:])
+	}
+	fs := flag.NewFlagSet("accounts", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to a JSON config file (defaults are used if empty)")
+	fs.Parse(args)
+
+	client, err := newAPIClient(*configPath)
+	if err != nil {
+		return err
+	}
+	statuses, err := client.Accounts(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to fetch accounts: %w", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATE\tLAST SYNC\tLAST ERROR")
+	for _, s := range statuses {
+		lastSync := "-"
+		if s.LastSync != nil {
+			lastSync = s.LastSync.Local().Format("2006-01-02 15:04:05")
+		}
+		lastError := "-"
+		if s.LastError != "" {
+			lastError = s.LastError
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.ID, accountState(s), lastSync, lastError)
+	}
+	return w.Flush()
+}
+
+// runPause silences an account of the running daemon until it is resumed
+func runPause(args []string) error {
+	return controlAccount("pause", args, (*api.Client).Pause)
+}
+
+// runResume restarts the polls of a paused account of the running daemon
+func runResume(args []string) error {
+	return controlAccount("resume", args, (*api.Client).Resume)
+}
+
+// controlAccount runs a pause or resume command
+func controlAccount(name string, args []string, action func(*api.Client, context.Context, string) (*api.AccountStatus, error)) error {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	configPath := fs.String("config", "", "path to a JSON config file (defaults are used if empty)")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: %s [--config file] <account>", name)
+	}
+
+	client, err := newAPIClient(*configPath)
+	if err != nil {
+		return err
+	}
+	s, err := action(client, context.Background(), fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to %s account %s: %w", name, fs.Arg(0), err)
+	}
+	fmt.Printf("account %s %s\n", s.ID, accountState(*s))
+	return nil
+}
+
+// accountState describes whether an account is polling
+func accountState(s api.AccountStatus) string {
+	switch {
+	case !s.Enabled:
+		return "disabled"
+	case s.Paused:
+		return "paused"
+	case !s.Running:
+		return "stopped"
+	case s.Degraded:
+		return "degraded"
+	case !s.Connected:
+		return "connecting"
+	}
+	return "polling"
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// agentLabel names the launchd agent and agentUnit the systemd user unit
+const (
+	agentLabel = "com.github.mshan.go-tsk"
+	agentUnit  = "go-tsk.service"
+)
+
+// runInstallAgent installs the daemon as an agent of the current user that
+// starts at login and restarts after crashes: a launchd agent on macOS, a
+// systemd user unit on Linux
+func runInstallAgent(args []string) error {
+	fs := flag.NewFlagSet("install-agent", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to the JSON config file the agent runs with")
+	remove := fs.Bool("remove", false, "stop the agent and remove it")
+	printOnly := fs.Bool("print", false, "print the agent definition instead of installing it")
+	fs.Parse(args)
+
+	var agent userAgent
+	switch runtime.GOOS {
+	case "darwin":
+		agent = launchdAgent{}
+	case "linux":
+		agent = systemdAgent{}
+	default:
+		return fmt.Errorf("install-agent supports macOS and Linux; on Windows, use service install")
+	}
+
+	path, err := agent.path()
+	if err != nil {
+		return err
+	}
+	if *remove {
+		if err := agent.unload(path); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		fmt.Printf("removed %s\n", path)
+		return nil
+	}
+
+	exe, err := installedExecutable()
+	if err != nil {
+		return err
+	}
+	cmd := []string{exe, "run"}
+	if *configPath != "" {
+		// The agent does not start in the current directory
+		abs, err := filepath.Abs(*configPath)
+		if err != nil {
+			return err
+		}
+		// Catch mistakes now rather than in a log after the next login
+		if _, err := loadConfig(abs); err != nil {
+			return err
+		}
+		cmd = append(cmd, "-config", abs)
+	}
+
+	definition, err := agent.definition(cmd)
+	if err != nil {
+		return err
+	}
+	if *printOnly {
+		fmt.Print(definition)
+		return nil
+	}
+
+	// Replace an agent installed before, e.g. with another config
+	if _, err := os.Stat(path); err == nil {
+		if err := agent.unload(path); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(definition), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := agent.load(path); err != nil {
+		return err
+	}
+	fmt.Printf("installed %s; go-tsk now starts at login\n", path)
+	return nil
+}
+
+// installedExecutable returns the path of the running binary, refusing the
+// temporary binaries of go run, which are deleted when it exits
+func installedExecutable() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the executable: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+	if strings.Contains(exe, string(filepath.Separator)+"go-build") {
+		return "", errors.New("install-agent needs an installed binary; run go install ./cmd/app and the installed binary instead of go run")
+	}
+	return exe, nil
+}
+
+// userAgent is how a platform starts programs at login
+type userAgent interface {
+	path() (string, error)                   // File the agent is defined in
+	definition(cmd []string) (string, error) // Contents of that file, running cmd
+	load(path string) error                  // Starts the agent now and at every login
+	unload(path string) error                // Stops the agent and keeps it from starting
+}
+
+// launchdAgent is a launchd agent in ~/Library/LaunchAgents
+type launchdAgent struct{}
+
+func (launchdAgent) path() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", agentLabel+".plist"), nil
+}
+
+func (launchdAgent) definition(cmd []string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	logPath := filepath.Join(home, "Library", "Logs", "go-tsk.log")
+
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>` + agentLabel + `</string>
+	<key>ProgramArguments</key>
+	<array>
+`)
+	for _, arg := range cmd {
+		b.WriteString("\t\t<string>" + xmlEscape(arg) + "</string>\n")
+	}
+	b.WriteString(`	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+	<key>StandardOutPath</key>
+	<string>` + xmlEscape(logPath) + `</string>
+	<key>StandardErrorPath</key>
+	<string>` + xmlEscape(logPath) + `</string>
+</dict>
+</plist>
+`)
+	return b.String(), nil
+}
+
+func (launchdAgent) load(path string) error {
+	return runTool("launchctl", "load", "-w", path)
+}
+
+func (launchdAgent) unload(path string) error {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return runTool("launchctl", "unload", "-w", path)
+}
+
+// systemdAgent is a systemd user unit in ~/.config/systemd/user
+type systemdAgent struct{}
+
+func (systemdAgent) path() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "systemd", "user", agentUnit), nil
+}
+
+func (systemdAgent) definition(cmd []string) (string, error) {
+	quoted := make([]string, len(cmd))
+	for i, arg := range cmd {
+		quoted[i] = systemdQuote(arg)
+	}
+	return `[Unit]
+Description=go-tsk mail rules
+
+[Service]
+Type=notify
+ExecStart=` + strings.Join(quoted, " ") + `
+ExecReload=/bin/kill -HUP $MAINPID
+Restart=on-failure
+RestartSec=30
+
+[Install]
+WantedBy=default.target
+`, nil
+}
+
+func (systemdAgent) load(path string) error {
+	if err := runTool("systemctl", "--user", "daemon-reload"); err != nil {
+		return err
+	}
+	return runTool("systemctl", "--user", "enable", "--now", agentUnit)
+}
+
+func (systemdAgent) unload(path string) error {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return runTool("systemctl", "--user", "disable", "--now", agentUnit)
+}
+
+// runTool runs a service manager command, returning its output on failure
+func runTool(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %w: %s", name, strings.Join(args, " "), err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// xmlEscape escapes s for XML character data
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// systemdQuote quotes an ExecStart argument if it needs it. Percent signs
+// are doubled, as systemd expands specifiers in unit files.
+func systemdQuote(s string) string {
+	s = strings.ReplaceAll(s, "%", "%%")
+	if !strings.ContainsAny(s, " \t\"'\\$;") {
+		return s
+	}
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `$$`).Replace(s)
+	return `"` + s + `"`
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSystemdQuote(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"/usr/local/bin/app", "/usr/local/bin/app"},
+		{"-config", "-config"},
+		{"/home/me/My Mail/config.json", `"/home/me/My Mail/config.json"`},
+		{"/home/me/100%/config.json", "/home/me/100%%/config.json"},
+		{"/home/me/%h and more", `"/home/me/%%h and more"`},
+		{"/home/$USER/config.json", `"/home/$$USER/config.json"`},
+		{`/home/me/"quoted"`, `"/home/me/\"quoted\""`},
+		{"/home/me/it's", `"/home/me/it's"`},
+		{`C:\config`, `"C:\\config"`},
+		{"a;b", `"a;b"`},
+	}
+
+	for _, tt := range tests {
+		if got := systemdQuote(tt.arg); got != tt.want {
+			t.Errorf("systemdQuote(%q) = %s; want %s", tt.arg, got, tt.want)
+		}
+	}
+}
+
+func TestXMLEscape(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"/Users/me/config.json", "/Users/me/config.json"},
+		{"/Users/me/My Mail/config.json", "/Users/me/My Mail/config.json"},
+		{"/Users/me/R&D/<new>.json", "/Users/me/R&amp;D/&lt;new&gt;.json"},
+		{`/Users/me/"it's"`, "/Users/me/&#34;it&#39;s&#34;"},
+		{"/Users/me/100% $HOME", "/Users/me/100% $HOME"},
+	}
+
+	for _, tt := range tests {
+		if got := xmlEscape(tt.s); got != tt.want {
+			t.Errorf("xmlEscape(%q) = %s; want %s", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestSystemdDefinition(t *testing.T) {
+	cmd := []string{"/opt/go tsk/app", "run", "-config", "/home/me/50% $off/config.json"}
+	unit, err := systemdAgent{}.definition(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `ExecStart="/opt/go tsk/app" run -config "/home/me/50%% $$off/config.json"` + "\n"
+	if !strings.Contains(unit, "\n"+want) {
+		t.Errorf("unit =\n%s\nwant the line %s", unit, want)
+	}
+	for _, line := range []string{"Type=notify\n", "Restart=on-failure\n", "WantedBy=default.target\n"} {
+		if !strings.Contains(unit, line) {
+			t.Errorf("unit =\n%s\nwant the line %s", unit, line)
+		}
+	}
+}
+
+func TestLaunchdDefinition(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	cmd := []string{"/Applications/Go TSK/app", "run", "-config", `/Users/me/R&D <"mail">/50% $off.json`}
+	plist, err := launchdAgent{}.definition(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The arguments and log path come back out of the XML unchanged
+	var strs []string
+	dec := xml.NewDecoder(strings.NewReader(plist))
+	dec.Strict = true
+	inString := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("plist is not well-formed: %v\n%s", err, plist)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			inString = tok.Name.Local == "string"
+		case xml.EndElement:
+			inString = false
+		case xml.CharData:
+			if inString {
+				strs = append(strs, string(tok))
+			}
+		}
+	}
+	logPath := filepath.Join(home, "Library", "Logs", "go-tsk.log")
+	want := append([]string{agentLabel}, cmd...)
+	want = append(want, logPath, logPath)
+	if !reflect.DeepEqual(strs, want) {
+		t.Errorf("plist strings = %q; want %q", strs, want)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/scheduler"
+	"github.com/mshan/go-tsk/internal/secrets"
+)
+
+// authTimeout bounds how long the auth command waits for the browser
+const authTimeout = 5 * time.Minute
+
+// runAuth signs an account in with its mail service in the browser and
+// stores its OAuth tokens in the token file, where the poller refreshes
+// them
+func runAuth(args []string) error {
+	fs := flag.NewFlagSet("auth", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to a JSON config file")
+	accountID := fs.String("account", "", "ID of the account to sign in")
+	fs.Parse(args)
+
+	if *accountID == "" {
+		return fmt.Errorf("--account is required")
+	}
+	cfg, err := loadConfigWithSecrets(*configPath)
+	if err != nil {
+		return err
+	}
+	if cfg.Secrets.TokenFile.Path == "" {
+		return fmt.Errorf("Secrets.TokenFile.Path is not set")
+	}
+	var account *config.EmailAccount
+	for i := range cfg.EmailAccounts {
+		if cfg.EmailAccounts[i].ID == *accountID {
+			account = &cfg.EmailAccounts[i]
+		}
+	}
+	if account == nil {
+		return fmt.Errorf("unknown account %q", *accountID)
+	}
+	return signIn(cfg, *account)
+}
+
+// signIn has account sign in with its mail service in the browser and
+// stores its OAuth tokens in the config's token file
+func signIn(cfg *config.Config, account config.EmailAccount) error {
+	// The service redirects the browser back to a listener on the
+	// loopback interface
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	conf := email.OAuthConfig(account)
+	conf.RedirectURL = "http://" + ln.Addr().String() + "/"
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	state := hex.EncodeToString(b)
+	verifier := oauth2.GenerateVerifier()
+	authURL := conf.AuthCodeURL(state,
+		oauth2.AccessTypeOffline,
+		oauth2.ApprovalForce, // Makes Google return a refresh token every time
+		oauth2.S256ChallengeOption(verifier),
+		oauth2.SetAuthURLParam("login_hint", account.Address),
+	)
+	fmt.Printf("Open this URL in a browser to sign in %s:\n\n  %s\n\n", account.ID, authURL)
+
+	codes := make(chan string, 1)
+	errs := make(chan error, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case q.Get("state") != state:
+			http.Error(w, "invalid state", http.StatusBadRequest)
+			return
+		case q.Get("error") != "":
+			http.Error(w, "sign-in failed", http.StatusBadRequest)
+			select {
+			case errs <- fmt.Errorf("sign-in failed: %s", q.Get("error")):
+			default:
+			}
+			return
+		}
+		fmt.Fprintln(w, "Signed in; you can close this window.")
+		select {
+		case codes <- q.Get("code"):
+		default:
+		}
+	})}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	var code string
+	select {
+	case code = <-codes:
+	case err := <-errs:
+		return err
+	case <-time.After(authTimeout):
+		return fmt.Errorf("timed out waiting for the sign-in")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	tok, err := conf.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return fmt.Errorf("failed to exchange the authorization code: %w", err)
+	}
+	if tok.RefreshToken == "" {
+		fmt.Println("warning: no refresh token was returned; the account must sign in again when the token expires")
+	}
+	if err := secrets.NewTokenFile(cfg.Secrets.TokenFile).Save(account.ID, tok); err != nil {
+		return fmt.Errorf("failed to store the token: %w", err)
+	}
+	fmt.Printf("stored the token of %s in %s\n", account.ID, cfg.Secrets.TokenFile.Path)
+	return nil
+}
+
+// tokenFileOptions makes a poller create providers with providerFactory if
+// there is a token file
+func tokenFileOptions(cfg *config.Config) []scheduler.Option {
+	if cfg.Secrets.TokenFile.Path == "" {
+		return nil
+	}
+	return []scheduler.Option{scheduler.WithProviderFactory(providerFactory(cfg))}
+}
+
+// providerFactory authenticates Gmail accounts, over IMAP or the API, and
+// OAuth accounts of presets with the tokens stored by the auth command, if
+// there is a token file. Accounts without a stored token use their
+// configured Token.
+func providerFactory(cfg *config.Config) scheduler.ProviderFactory {
+	if cfg.Secrets.TokenFile.Path == "" {
+		return email.NewProvider
+	}
+	tokens := secrets.NewTokenFile(cfg.Secrets.TokenFile)
+	return func(account config.EmailAccount) (email.Provider, error) {
+		_, preset := config.LookupPreset(account.Provider)
+		oauth := account.Provider == "gmail" || account.Provider == "gmailapi" || account.Provider == "" || preset && account.Password == ""
+		if !oauth {
+			return email.NewProvider(account)
+		}
+		ts, err := tokens.TokenSource(context.Background(), email.OAuthConfig(account), account.ID)
+		if errors.Is(err, secrets.ErrSecretNotFound) {
+			return email.NewProvider(account)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if preset {
+			return email.NewPresetClient(account, email.WithTokenSource(ts))
+		}
+		if account.Provider == "gmailapi" {
+			opts := []email.GmailAPIOption{email.WithMessageLimit(account.Limits.MaxMessages)}
+			if account.FetchBodies {
+				opts = append(opts, email.WithAPIBodies())
+			}
+			return email.NewGmailAPIClient(ts, opts...), nil
+		}
+		opts := []email.GmailOption{email.WithTokenSource(ts)}
+		if account.FetchBodies {
+			opts = append(opts, email.WithBodies())
+		}
+		return email.NewGmailClient(account.Address, account.ClientID, account.ClientSecret, "", opts...)
+	}
+}
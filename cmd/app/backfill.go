@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/scheduler"
+	"github.com/mshan/go-tsk/internal/store"
+)
+
+// runBackfill processes the mail that already exists in one mailbox of an
+// account. It is resumable when a state store is configured.
+func runBackfill(args []string) error {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to a JSON config file (defaults are used if empty)")
+	accountID := fs.String("account", "", "ID of the account to backfill")
+	mailbox := fs.String("mailbox", "INBOX", "mailbox to backfill")
+	batch := fs.Int("batch", 200, "messages fetched per batch")
+	pause := fs.Duration("pause", time.Second, "delay between batches")
+	restart := fs.Bool("restart", false, "discard saved progress and start from the oldest message")
+	fs.Parse(args)
+
+	if *accountID == "" {
+		return fmt.Errorf("--account is required")
+	}
+
+	cfg, err := loadConfigWithSecrets(*configPath)
+	if err != nil {
+		return err
+	}
+	if err := setupLogging(cfg); err != nil {
+		return err
+	}
+
+	var st store.Store
+	if cfg.Storage.Path != "" {
+		if st, err = store.Open(cfg.Storage); err != nil {
+			return fmt.Errorf("failed to open state store: %w", err)
+		}
+		defer st.Close()
+	}
+
+	poller, err := scheduler.NewEmailPoller(cfg, st, tokenFileOptions(cfg)...)
+	if err != nil {
+		return fmt.Errorf("failed to create email poller: %w", err)
+	}
+
+	// Stop between batches on a signal; progress up to there is kept
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	err = poller.Backfill(ctx, *accountID, scheduler.BackfillOptions{
+		Mailbox:   *mailbox,
+		BatchSize: *batch,
+		Pause:     *pause,
+		Restart:   *restart,
+		Progress:  printBackfillProgress,
+	})
+	if errors.Is(err, context.Canceled) {
+		log.Println("Backfill interrupted; run the command again to resume")
+		return nil
+	}
+	return err
+}
+
+// printBackfillProgress writes one progress line with rate and ETA
+func printBackfillProgress(p scheduler.BackfillProgress) {
+	line := fmt.Sprintf("processed %d", p.Processed)
+	if p.Total > 0 {
+		line += fmt.Sprintf("/%d (%.1f%%)", p.Total, 100*float64(p.Processed)/float64(p.Total))
+	}
+
+	if secs := p.Elapsed.Seconds(); secs > 0 && p.Processed > 0 {
+		rate := float64(p.Processed) / secs
+		line += fmt.Sprintf(", %.1f msg/s", rate)
+		if remaining := p.Total - p.Processed; remaining > 0 {
+			eta := time.Duration(float64(remaining) / rate * float64(time.Second))
+			line += fmt.Sprintf(", ETA %s", eta.Round(time.Second))
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, line)
+}
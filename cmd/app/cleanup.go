@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/scheduler"
+)
+
+// runCleanup runs a one-off maintenance job, either one defined under
+// Cleanup in the config or one described by flags
+func runCleanup(args []string) error {
+	fs := flag.NewFlagSet("cleanup", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to a JSON config file (defaults are used if empty)")
+	jobName := fs.String("job", "", "name of a cleanup job defined in the config")
+	accountID := fs.String("account", "", "ID of the account to clean")
+	mailbox := fs.String("mailbox", "", "mailbox to clean; required with --expunge, INBOX otherwise if empty")
+	removeLabel := fs.String("remove-label", "", "remove this label")
+	expunge := fs.Bool("expunge", false, "permanently delete messages")
+	olderThan := fs.String("older-than", "", "only touch mail older than this, e.g. 90d or 36h")
+	dryRun := fs.Bool("dry-run", false, "only count the messages that would be changed")
+	yes := fs.Bool("yes", false, "expunge every message in the mailbox when no --older-than is set")
+	batch := fs.Int("batch", 500, "messages changed per command")
+	pause := fs.Duration("pause", time.Second, "delay between batches")
+	fs.Parse(args)
+
+	cfg, err := loadConfigWithSecrets(*configPath)
+	if err != nil {
+		return err
+	}
+	if err := setupLogging(cfg); err != nil {
+		return err
+	}
+
+	var job config.CleanupJob
+	if *jobName != "" {
+		found := false
+		for _, j := range cfg.Cleanup {
+			if j.Name == *jobName {
+				job, found = j, true
+			}
+		}
+		if !found {
+			return fmt.Errorf("no cleanup job named %q in the config", *jobName)
+		}
+	} else {
+		if *accountID == "" {
+			return fmt.Errorf("--account is required without --job")
+		}
+		job = config.CleanupJob{Name: "command line", Account: *accountID, Mailbox: *mailbox}
+		switch {
+		case *removeLabel != "" && *expunge:
+			return fmt.Errorf("set --remove-label or --expunge, not both")
+		case *removeLabel != "":
+			job.Action, job.Label = "remove-label", *removeLabel
+		case *expunge:
+			if *mailbox == "" {
+				return fmt.Errorf("--expunge requires --mailbox")
+			}
+			job.Action = "expunge"
+		default:
+			return fmt.Errorf("set --job, --remove-label or --expunge")
+		}
+		if *olderThan != "" {
+			if job.OlderThan, err = parseDays(*olderThan); err != nil || job.OlderThan <= 0 {
+				return fmt.Errorf("invalid --older-than %q; use e.g. 90d or 36h", *olderThan)
+			}
+		}
+	}
+	if job.Action == "expunge" && job.OlderThan == 0 && !*dryRun && !*yes {
+		return fmt.Errorf("%s would permanently delete every message there; add --older-than, or --yes to confirm", describeJob(job))
+	}
+
+	poller, err := scheduler.NewEmailPoller(cfg, nil, tokenFileOptions(cfg)...)
+	if err != nil {
+		return fmt.Errorf("failed to create email poller: %w", err)
+	}
+
+	// Stop between batches on a signal; finished batches stay done
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	n, err := poller.Cleanup(ctx, job, scheduler.CleanupOptions{
+		DryRun:    *dryRun,
+		BatchSize: *batch,
+		Pause:     *pause,
+		Progress:  printCleanupProgress,
+	})
+	if errors.Is(err, context.Canceled) {
+		log.Printf("Cleanup interrupted after %d messages", n)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		fmt.Printf("dry run: %s would change %d messages\n", describeJob(job), n)
+	} else {
+		fmt.Printf("%s changed %d messages\n", describeJob(job), n)
+	}
+	return nil
+}
+
+// describeJob summarizes a cleanup job for output
+func describeJob(job config.CleanupJob) string {
+	mailbox := job.Mailbox
+	if mailbox == "" {
+		mailbox = "INBOX"
+	}
+	s := fmt.Sprintf("expunge in %s", mailbox)
+	if job.Action == "remove-label" {
+		s = fmt.Sprintf("remove label %q in %s", job.Label, mailbox)
+	}
+	if job.OlderThan > 0 {
+		s += fmt.Sprintf(" (older than %s)", job.OlderThan)
+	}
+	return s
+}
+
+// printCleanupProgress writes one progress line
+func printCleanupProgress(p scheduler.CleanupProgress) {
+	if p.Done == 0 {
+		fmt.Fprintf(os.Stderr, "found %d matching messages\n", p.Total)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "changed %d/%d (%.1f%%) in %s\n",
+		p.Done, p.Total, 100*float64(p.Done)/float64(p.Total), p.Elapsed.Round(time.Second))
+}
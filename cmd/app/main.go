@@ -2,39 +2,279 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/mshan/go-tsk/internal/api"
 	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/grpcapi"
+	"github.com/mshan/go-tsk/internal/logging"
+	"github.com/mshan/go-tsk/internal/metrics"
+	"github.com/mshan/go-tsk/internal/proxy"
 	"github.com/mshan/go-tsk/internal/scheduler"
+	"github.com/mshan/go-tsk/internal/secrets"
+	"github.com/mshan/go-tsk/internal/store"
+	"github.com/mshan/go-tsk/internal/systemd"
+	"github.com/mshan/go-tsk/internal/tracing"
 )
 
+// shutdownTimeout bounds how long in-flight polls may run after a signal
+const shutdownTimeout = 30 * time.Second
+
+// commands maps subcommand names to their entry points
+var commands = map[string]func(args []string) error{
+	"run":           runDaemon,
+	"soak":          runSoak,
+	"backfill":      runBackfill,
+	"validate":      runValidate,
+	"import-sieve":  runImportSieve,
+	"rules":         runRules,
+	"list":          runList,
+	"done":          runDone,
+	"snooze":        runSnooze,
+	"show":          runShow,
+	"search":        runSearch,
+	"cleanup":       runCleanup,
+	"accounts":      runAccounts,
+	"pause":         runPause,
+	"resume":        runResume,
+	"secret":        runSecret,
+	"auth":          runAuth,
+	"service":       runService,
+	"install-agent": runInstallAgent,
+}
+
 func main() {
+	// Without a subcommand, run the daemon
+	name, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+		os.Exit(2)
+	}
+
+	if err := cmd(args); err != nil {
+		log.Printf("Error: %v", err)
+		os.Exit(1)
+	}
+}
+
+// loadConfig loads the config file at path, or the defaults if path is empty
+func loadConfig(path string) (*config.Config, error) {
+	if path == "" {
+		return config.DefaultConfig(), nil
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	return cfg, nil
+}
+
+// setupLogging applies the config's log redaction to the standard logger
+func setupLogging(cfg *config.Config) error {
+	mode, err := logging.ParseMode(cfg.Log.Redact)
+	if err != nil {
+		return err
+	}
+	logging.Setup(mode, logOutput)
+	return nil
+}
+
+// logOutput is where the log goes once the config is loaded: the console,
+// or the event log when running as a Windows service
+var logOutput io.Writer = os.Stderr
+
+// keychain resolves "keyring:" secret references and stores secrets for
+// the secret command
+var keychain = secrets.NewKeyring()
+
+// vault resolves "vault://" secret references. It is set up from the
+// first config loaded, so its settings take effect on restart.
+var vault *secrets.Vault
+
+// loadConfigWithSecrets loads the config like loadConfig and resolves the
+// secrets it refers to
+func loadConfigWithSecrets(path string) (*config.Config, error) {
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if vault == nil {
+		// The proxy of HTTP calls is set up once, before the first of them
+		if err := proxy.Install(cfg.Proxy.URL); err != nil {
+			return nil, err
+		}
+		vault = secrets.NewVault(cfg.Secrets.Vault)
+	}
+	resolvers := map[string]secrets.Resolver{
+		secrets.KeyringScheme: keychain,
+		secrets.VaultScheme:   vault,
+	}
+	if err := secrets.Resolve(context.Background(), cfg, resolvers); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// notifySystemd sends state to systemd if it started the daemon
+func notifySystemd(state string) {
+	if _, err := systemd.Notify(state); err != nil {
+		log.Printf("Failed to notify systemd: %v", err)
+	}
+}
+
+// runDaemon starts the poller and blocks until it stops or a signal arrives
+func runDaemon(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to a JSON config file (defaults are used if empty)")
+	fs.Parse(args)
+
+	// Handle shutdown and reload signals
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	return serve(*configPath, sigChan, nil)
+}
+
+// serve runs the daemon with the config file at configPath until the
+// poller stops or SIGINT or SIGTERM arrive on sigChan. SIGHUP reloads the
+// config. started, if not nil, is called once setup succeeded and polling
+// starts.
+func serve(configPath string, sigChan <-chan os.Signal, started func()) error {
 	// Create configuration
-	cfg := config.DefaultConfig()
+	cfg, err := loadConfigWithSecrets(configPath)
+	if err != nil {
+		return err
+	}
+	if err := setupLogging(cfg); err != nil {
+		return err
+	}
+
+	// Open the state store if persistence is enabled
+	var st store.Store
+	if cfg.Storage.Path != "" {
+		var err error
+		if st, err = store.Open(cfg.Storage); err != nil {
+			return fmt.Errorf("failed to open state store: %w", err)
+		}
+		defer st.Close()
+	}
 
 	// Create email poller
-	poller := scheduler.NewEmailPoller(cfg)
+	opts := append(tokenFileOptions(cfg), scheduler.WithConfigLoader(loadConfigWithSecrets))
+	if configPath != "" {
+		opts = append(opts, scheduler.WithConfigPath(configPath))
+	}
+	if systemd.Available() {
+		notify := func(state string) error {
+			_, err := systemd.Notify(state)
+			return err
+		}
+		opts = append(opts, scheduler.WithServiceNotifier(notify, systemd.WatchdogInterval()))
+	}
+	poller, err := scheduler.NewEmailPoller(cfg, st, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to create email poller: %w", err)
+	}
+
+	// Export traces if configured
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Printf("Failed to flush traces: %v", err)
+		}
+	}()
+
+	// Expose metrics if configured
+	if cfg.Metrics.Addr != "" {
+		go func() {
+			if err := metrics.ListenAndServe(cfg.Metrics.Addr); err != nil {
+				log.Printf("Metrics server stopped: %v", err)
+			}
+		}()
+	}
 
-	// Create context that will be canceled on SIGINT or SIGTERM
+	// Serve message lookups and runtime control if configured
+	if cfg.API.Addr != "" {
+		go func() {
+			if err := api.ListenAndServe(cfg.API.Addr, cfg.API.Token, poller); err != nil {
+				log.Printf("API server stopped: %v", err)
+			}
+		}()
+	}
+	if cfg.API.GRPCAddr != "" {
+		go func() {
+			if err := grpcapi.ListenAndServe(cfg.API.GRPCAddr, cfg.API.Token, poller); err != nil {
+				log.Printf("gRPC server stopped: %v", err)
+			}
+		}()
+	}
+
+	// Create context that aborts in-flight work if shutdown takes too long
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Handle shutdown signals
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		<-sigChan
-		log.Println("Shutting down...")
-		cancel()
-	}()
+	// Keep the Vault leases of resolved secrets alive
+	go vault.Renew(ctx)
 
 	// Start polling
 	log.Println("Starting email poller...")
-	if err := poller.Start(ctx); err != nil {
-		log.Printf("Poller stopped with error: %v", err)
-		os.Exit(1)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- poller.Start(ctx)
+	}()
+	if started != nil {
+		started()
+	}
+
+	for {
+		select {
+		case err := <-errChan:
+			stopCtx, cancelStop := context.WithTimeout(context.Background(), shutdownTimeout)
+			if err := poller.Close(stopCtx); err != nil {
+				log.Printf("Shutdown incomplete: %v", err)
+			}
+			cancelStop()
+			if err != nil {
+				return fmt.Errorf("poller stopped with error: %w", err)
+			}
+			return nil
+		case sig := <-sigChan:
+			if sig == syscall.SIGHUP {
+				log.Println("Reloading config...")
+				notifySystemd(systemd.Reloading)
+				if err := poller.Reload(); err != nil {
+					log.Printf("Reload failed, keeping the current config: %v", err)
+				}
+				notifySystemd(systemd.Ready)
+				continue
+			}
+			log.Println("Shutting down...")
+			notifySystemd(systemd.Stopping)
+			shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+			if err := poller.Close(shutdownCtx); err != nil {
+				log.Printf("Shutdown incomplete: %v", err)
+			}
+			cancelShutdown()
+			cancel()
+			<-errChan
+			return nil
+		}
 	}
 }
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/gmailfilters"
+	"github.com/mshan/go-tsk/internal/scheduler"
+	"github.com/mshan/go-tsk/internal/store"
+)
+
+// rulesCommands maps the subcommands of rules to their entry points
+var rulesCommands = map[string]func(args []string) error{
+	"import-gmail": runImportGmail,
+	"export-gmail": runExportGmail,
+	"stats":        runRuleStats,
+	"replay":       runReplay,
+}
+
+// runRules runs a subcommand converting rules to and from other formats
+func runRules(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: rules import-gmail|export-gmail|stats|replay [flags]")
+	}
+	cmd, ok := rulesCommands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown rules command %q", args[0])
+	}
+	return cmd(args[1:])
+}
+
+// runImportGmail converts the filters of a Gmail export, or of an account
+// through the Gmail API, to rules and prints them as JSON for Poll.Rules
+func runImportGmail(args []string) error {
+	fs := flag.NewFlagSet("rules import-gmail", flag.ExitOnError)
+	file := fs.String("file", "", "path to the mailFilters.xml Gmail exported")
+	configPath := fs.String("config", "", "path to a JSON config file, to read filters through the API")
+	accountID := fs.String("account", "", "gmailapi account to read filters of, instead of --file")
+	fs.Parse(args)
+
+	var filters []email.GmailFilter
+	switch {
+	case (*file == "") == (*accountID == ""):
+		return fmt.Errorf("set either --file or --account")
+	case *file != "":
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if filters, err = gmailfilters.ReadXML(f); err != nil {
+			return err
+		}
+	default:
+		var err error
+		if filters, err = apiFilters(*configPath, *accountID); err != nil {
+			return err
+		}
+	}
+
+	converted, issues := gmailfilters.ToRules(filters)
+	for _, issue := range issues {
+		fmt.Fprintf(os.Stderr, "filter %s\n", issue)
+	}
+	if err := printRules(converted); err != nil {
+		return err
+	}
+	if len(issues) > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d filters converted, %d issues\n", len(converted), len(filters), len(issues))
+	}
+	return nil
+}
+
+// apiFilters reads the filters of an account of the gmailapi provider
+func apiFilters(configPath, accountID string) ([]email.GmailFilter, error) {
+	cfg, err := loadConfigWithSecrets(configPath)
+	if err != nil {
+		return nil, err
+	}
+	var account *config.EmailAccount
+	for i := range cfg.EmailAccounts {
+		if cfg.EmailAccounts[i].ID == accountID {
+			account = &cfg.EmailAccounts[i]
+		}
+	}
+	if account == nil {
+		return nil, fmt.Errorf("no account %q", accountID)
+	}
+	provider, err := providerFactory(cfg)(*account)
+	if err != nil {
+		return nil, err
+	}
+	defer provider.Close()
+	client, ok := provider.(*email.GmailAPIClient)
+	if !ok {
+		return nil, fmt.Errorf("account %s does not use the gmailapi provider", accountID)
+	}
+	return client.Filters(context.Background())
+}
+
+// runExportGmail converts the rules of a config to filters and prints
+// them as a Gmail export, for Settings > Filters > Import filters
+func runExportGmail(args []string) error {
+	fs := flag.NewFlagSet("rules export-gmail", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to the JSON config file whose rules to export")
+	fs.Parse(args)
+
+	if *configPath == "" {
+		return fmt.Errorf("--config is required")
+	}
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	filters, issues := gmailfilters.FromRules(cfg.Poll.Rules)
+	for _, issue := range issues {
+		fmt.Fprintf(os.Stderr, "rule %s\n", issue)
+	}
+	if err := gmailfilters.WriteXML(os.Stdout, filters, time.Now()); err != nil {
+		return err
+	}
+	if len(issues) > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d rules exported, %d issues\n", len(filters), len(cfg.Poll.Rules), len(issues))
+	}
+	return nil
+}
+
+// runRuleStats prints how often each rule of the running daemon matched
+// and acted, optionally only the rules that have not matched for a while
+func runRuleStats(args []string) error {
+	fs := flag.NewFlagSet("rules stats", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to a JSON config file (defaults are used if empty)")
+	stale := fs.Duration("stale", 0, "only list rules that have not matched for this long, e.g. 720h")
+	fs.Parse(args)
+
+	client, err := newAPIClient(*configPath)
+	if err != nil {
+		return err
+	}
+	stats, err := client.RuleStats(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to fetch rule statistics: %w", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "INDEX\tMATCHES\tACTIONS\tERRORS\tLAST MATCH\tRULE")
+	for _, s := range stats {
+		if *stale > 0 && s.LastMatch != nil && time.Since(*s.LastMatch) < *stale {
+			continue
+		}
+		lastMatch := "never"
+		if s.LastMatch != nil {
+			lastMatch = s.LastMatch.Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%s\t%s: %s\n", s.Index, s.Matches, s.Actions, s.Errors, lastMatch, s.Action, s.Rule)
+	}
+	return w.Flush()
+}
+
+// runReplay runs the rules of a config against past mail, archived or
+// fetched from an account, and prints which matched. Only with --apply do
+// their actions run.
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("rules replay", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to the JSON config file whose rules to replay")
+	accountID := fs.String("account", "", "only replay this account's mail")
+	from := fs.String("from", "", "only replay archived mail whose sender contains this")
+	subject := fs.String("subject", "", "only replay archived mail whose subject contains this")
+	since := fs.String("since", "", "only replay mail newer than this, e.g. 90d or 36h")
+	limit := fs.Int("limit", 0, "replay at most this many archived messages, newest first; 0 means all")
+	ruleList := fs.String("rules", "", "comma-separated indices of the rules to replay; empty replays all")
+	fetch := fs.Bool("fetch", false, "replay mail fetched from --account instead of the archive")
+	mailbox := fs.String("mailbox", "INBOX", "mailbox --fetch reads")
+	apply := fs.Bool("apply", false, "run the actions of matching rules instead of only listing the matches")
+	fs.Parse(args)
+
+	if *configPath == "" {
+		return fmt.Errorf("--config is required")
+	}
+	cfg, err := loadConfigWithSecrets(*configPath)
+	if err != nil {
+		return err
+	}
+	if err := setupLogging(cfg); err != nil {
+		return err
+	}
+	opts := scheduler.ReplayOptions{
+		Query:   store.ArchiveQuery{AccountID: *accountID, From: *from, Subject: *subject, Limit: *limit},
+		Fetch:   *fetch,
+		Mailbox: *mailbox,
+		Apply:   *apply,
+	}
+	switch {
+	case *fetch && *accountID == "":
+		return fmt.Errorf("--fetch needs --account")
+	case !*fetch && !cfg.Storage.Archive.Enabled:
+		return fmt.Errorf("replaying archived mail needs Storage.Archive enabled; use --fetch to replay mail from the provider")
+	}
+	if *since != "" {
+		age, err := parseDays(*since)
+		if err != nil || age <= 0 {
+			return fmt.Errorf("invalid --since %q; use e.g. 90d or 36h", *since)
+		}
+		opts.Query.Since = time.Now().Add(-age)
+	}
+	for _, s := range strings.Split(*ruleList, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid rule index %q", s)
+		}
+		opts.Rules = append(opts.Rules, i)
+	}
+
+	var st store.Store
+	if cfg.Storage.Path != "" {
+		if st, err = store.Open(cfg.Storage); err != nil {
+			return fmt.Errorf("failed to open state store: %w", err)
+		}
+		defer st.Close()
+	}
+	poller, err := scheduler.NewEmailPoller(cfg, st, tokenFileOptions(cfg)...)
+	if err != nil {
+		return fmt.Errorf("failed to create email poller: %w", err)
+	}
+
+	// Stop between messages on a signal
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DATE\tACCOUNT\tSUBJECT\tFROM\tINDEX\tRULE\tRESULT")
+	matches, failures := 0, 0
+	checked, err := poller.Replay(ctx, opts, func(m scheduler.ReplayMatch) {
+		matches++
+		result := "dry run"
+		switch {
+		case m.Failed:
+			failures++
+			result = "failed"
+		case *apply:
+			result = "applied"
+		}
+		msg := m.Message.Email
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s: %s\t%s\n", msg.Date.Local().Format("2006-01-02 15:04"),
+			m.Message.AccountID, msg.Subject, msg.From, m.RuleIndex, m.Action, m.Rule, result)
+	})
+	if flushErr := w.Flush(); flushErr != nil && err == nil {
+		err = flushErr
+	}
+	if errors.Is(err, context.Canceled) {
+		log.Printf("Replay interrupted after %d messages", checked)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%d matches in %d messages", matches, checked)
+	if failures > 0 {
+		fmt.Fprintf(os.Stderr, ", %d failed", failures)
+	}
+	fmt.Fprintln(os.Stderr)
+	return nil
+}
+
+// printRules prints rules as JSON, leaving out unset settings
+func printRules(ruleList []config.Rule) error {
+	data, err := json.Marshal(ruleList)
+	if err != nil {
+		return err
+	}
+	var decoded []interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	// Conditions are full of && and <
+	enc.SetEscapeHTML(false)
+	return enc.Encode(compact(decoded))
+}
+
+// compact removes zero values from decoded JSON
+func compact(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if value = compact(value); isZero(value) {
+				delete(v, key)
+			} else {
+				v[key] = value
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = compact(value)
+		}
+	}
+	return v
+}
+
+// isZero reports whether a decoded JSON value is null, empty or zero
+func isZero(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case float64:
+		return v == 0
+	case bool:
+		return !v
+	case map[string]interface{}:
+		return len(v) == 0
+	case []interface{}:
+		return len(v) == 0
+	}
+	return false
+}
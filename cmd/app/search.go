@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/store"
+)
+
+// runSearch searches the text of archived messages and prints the matches
+// with links opening them at their provider
+func runSearch(args []string) error {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to a JSON config file (defaults are used if empty)")
+	accountID := fs.String("account", "", "only search this account's mail")
+	limit := fs.Int("limit", 20, "print at most this many matches; 0 means all")
+	fs.Parse(args)
+
+	text := strings.Join(fs.Args(), " ")
+	if strings.TrimSpace(text) == "" {
+		return fmt.Errorf("usage: search [--config file] [--account id] <words>")
+	}
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	if !cfg.Storage.Archive.Enabled {
+		return fmt.Errorf("searching needs Storage.Archive enabled")
+	}
+	st, err := store.Open(cfg.Storage)
+	if err != nil {
+		return fmt.Errorf("failed to open state store: %w", err)
+	}
+	defer st.Close()
+
+	results, err := st.SearchMessages(*accountID, text, *limit)
+	if err != nil {
+		return err
+	}
+	accounts := make(map[string]config.EmailAccount, len(cfg.EmailAccounts))
+	for _, account := range cfg.EmailAccounts {
+		accounts[account.ID] = account
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DATE\tACCOUNT\tMAILBOX\tFROM\tSUBJECT\tLINK")
+	for _, r := range results {
+		link := "-"
+		if account, ok := accounts[r.AccountID]; ok && r.Email.MessageID != "" {
+			link = email.Link(account, r.Email.MessageID)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Email.Date.Local().Format("2006-01-02 15:04"),
+			r.AccountID, r.Email.Mailbox, r.Email.From, r.Email.Subject, link)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// runSecret stores secrets in or deletes them from the OS keychain, for
+// config values written as "keyring:<name>"
+func runSecret(args []string) error {
+	const usage = "usage: secret set <name> (reads the secret from stdin) | secret delete <name>"
+	if len(args) != 2 {
+		return errors.New(usage)
+	}
+	name := args[1]
+
+	switch args[0] {
+	case "set":
+		if term, err := os.Stdin.Stat(); err == nil && term.Mode()&os.ModeCharDevice != 0 {
+			fmt.Fprintf(os.Stderr, "Secret for %s: ", name)
+		}
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("failed to read the secret: %w", err)
+		}
+		secret := strings.TrimRight(line, "\r\n")
+		if secret == "" {
+			return fmt.Errorf("empty secret")
+		}
+		if err := keychain.Set(name, secret); err != nil {
+			return fmt.Errorf("failed to store secret %s: %w", name, err)
+		}
+		fmt.Printf("stored %s; refer to it as \"keyring:%s\"\n", name, name)
+	case "delete":
+		if err := keychain.Remove(name); err != nil {
+			return fmt.Errorf("failed to delete secret %s: %w", name, err)
+		}
+		fmt.Printf("deleted %s\n", name)
+	default:
+		return errors.New(usage)
+	}
+	return nil
+}
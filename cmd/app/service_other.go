@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// runService is only available on Windows; elsewhere the daemon runs
+// under the platform's service manager, e.g. as a systemd unit
+func runService(args []string) error {
+	return errors.New("service is only available on Windows; use run under systemd or launchd instead")
+}
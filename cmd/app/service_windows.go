@@ -0,0 +1,265 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceName is the name of the Windows service and its event source
+const serviceName = "go-tsk"
+
+// serviceStateTimeout bounds how long start and stop wait for the service
+const serviceStateTimeout = time.Minute
+
+// runService installs, removes, starts and stops the Windows service that
+// runs the daemon in the background. "service run" is what the service
+// control manager starts.
+func runService(args []string) error {
+	const usage = "usage: service install [-config <file>] | uninstall | start | stop"
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	switch args[0] {
+	case "install":
+		fs := flag.NewFlagSet("service install", flag.ExitOnError)
+		configPath := fs.String("config", "", "path to the JSON config file the service runs with")
+		fs.Parse(args[1:])
+		return installService(*configPath)
+	case "uninstall":
+		return uninstallService()
+	case "start":
+		return controlService(func(s *mgr.Service) error { return s.Start() }, svc.Running)
+	case "stop":
+		return controlService(func(s *mgr.Service) error {
+			_, err := s.Control(svc.Stop)
+			return err
+		}, svc.Stopped)
+	case "run":
+		fs := flag.NewFlagSet("service run", flag.ExitOnError)
+		configPath := fs.String("config", "", "path to a JSON config file")
+		fs.Parse(args[1:])
+		return runAsService(*configPath)
+	default:
+		return errors.New(usage)
+	}
+}
+
+// installService registers the service to start at boot with the config
+// at configPath, and the event source it logs to
+func installService(configPath string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the executable: %w", err)
+	}
+	args := []string{"service", "run"}
+	if configPath != "" {
+		// The service starts in the system directory, so relative paths
+		// would not be found
+		abs, err := filepath.Abs(configPath)
+		if err != nil {
+			return err
+		}
+		args = append(args, "-config", abs)
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s is already installed", serviceName)
+	}
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "go-tsk mail rules",
+		Description: "Applies go-tsk rules to new mail in the background",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return fmt.Errorf("failed to install service %s: %w", serviceName, err)
+	}
+	defer s.Close()
+
+	if err := eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		s.Delete()
+		return fmt.Errorf("failed to register the event source: %w", err)
+	}
+	fmt.Printf("installed service %s; start it with \"service start\"\n", serviceName)
+	return nil
+}
+
+// uninstallService removes the service and its event source
+func uninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", serviceName)
+	}
+	defer s.Close()
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("failed to uninstall service %s: %w", serviceName, err)
+	}
+	if err := eventlog.Remove(serviceName); err != nil {
+		return fmt.Errorf("failed to remove the event source: %w", err)
+	}
+	fmt.Printf("uninstalled service %s\n", serviceName)
+	return nil
+}
+
+// controlService sends a request to the service and waits until it is in
+// state
+func controlService(request func(s *mgr.Service) error, state svc.State) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", serviceName)
+	}
+	defer s.Close()
+	if err := request(s); err != nil {
+		return fmt.Errorf("service %s: %w", serviceName, err)
+	}
+
+	deadline := time.Now().Add(serviceStateTimeout)
+	for {
+		status, err := s.Query()
+		if err != nil {
+			return fmt.Errorf("failed to query service %s: %w", serviceName, err)
+		}
+		if status.State == state {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("service %s did not reach the requested state in %s", serviceName, serviceStateTimeout)
+		}
+		time.Sleep(300 * time.Millisecond)
+	}
+	fmt.Printf("service %s %s\n", serviceName, map[svc.State]string{svc.Running: "started", svc.Stopped: "stopped"}[state])
+	return nil
+}
+
+// runAsService runs the daemon under the service control manager, logging
+// to the event log
+func runAsService(configPath string) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return err
+	}
+	if !isService {
+		return errors.New("service run is started by the service manager; use run to start go-tsk in a console")
+	}
+
+	elog, err := eventlog.Open(serviceName)
+	if err != nil {
+		return fmt.Errorf("failed to open the event log: %w", err)
+	}
+	defer elog.Close()
+	logOutput = eventLogWriter{elog}
+	log.SetOutput(logOutput)
+
+	return svc.Run(serviceName, &daemonService{
+		serve: func(sigChan <-chan os.Signal, started func()) error {
+			return serve(configPath, sigChan, started)
+		},
+	})
+}
+
+// daemonService runs the daemon as a Windows service
+type daemonService struct {
+	// serve runs the daemon until a signal on sigChan stops it, calling
+	// started once it is polling
+	serve func(sigChan <-chan os.Signal, started func()) error
+}
+
+// Execute runs the daemon, reporting it running once it started, and turns
+// stop and shutdown requests into SIGTERM and parameter changes into SIGHUP
+func (d *daemonService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	const accepted = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptParamChange
+	status <- svc.Status{State: svc.StartPending}
+
+	sigChan := make(chan os.Signal, 1)
+	done := make(chan error, 1)
+	started := make(chan struct{})
+	go func() {
+		done <- d.serve(sigChan, func() { close(started) })
+	}()
+
+	for {
+		select {
+		case <-started:
+			status <- svc.Status{State: svc.Running, Accepts: accepted}
+			started = nil
+		case err := <-done:
+			if err != nil {
+				log.Printf("Error: %v", err)
+				return true, 1
+			}
+			return false, 0
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				signalDaemon(sigChan, syscall.SIGTERM)
+			case svc.ParamChange:
+				signalDaemon(sigChan, syscall.SIGHUP)
+			}
+		}
+	}
+}
+
+// signalDaemon passes sig to serve, dropping it if one is still pending,
+// as serve may be shutting down already
+func signalDaemon(sigChan chan<- os.Signal, sig os.Signal) {
+	select {
+	case sigChan <- sig:
+	default:
+	}
+}
+
+// eventLogWriter writes each log line as an event, as an error if it
+// reports one
+type eventLogWriter struct {
+	log *eventlog.Log
+}
+
+// eventID is the ID of every event go-tsk logs
+const eventID = 1
+
+func (w eventLogWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimRight(string(p), "\r\n")
+	lower := strings.ToLower(msg)
+	var err error
+	if strings.Contains(lower, "error") || strings.Contains(lower, "failed") {
+		err = w.log.Error(eventID, msg)
+	} else {
+		err = w.log.Info(eventID, msg)
+	}
+	return len(p), err
+}
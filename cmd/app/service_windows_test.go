@@ -0,0 +1,138 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// fakeDaemon is a serve that starts when start is closed and passes the
+// signals it receives to signals, stopping on SIGTERM
+type fakeDaemon struct {
+	start   chan struct{}
+	err     error
+	signals chan os.Signal
+}
+
+func newFakeDaemon() *fakeDaemon {
+	return &fakeDaemon{start: make(chan struct{}), signals: make(chan os.Signal, 10)}
+}
+
+func (f *fakeDaemon) serve(sigChan <-chan os.Signal, started func()) error {
+	<-f.start
+	if f.err != nil {
+		return f.err
+	}
+	started()
+	for sig := range sigChan {
+		f.signals <- sig
+		if sig == syscall.SIGTERM {
+			return nil
+		}
+	}
+	return nil
+}
+
+// executeResult is what Execute returned
+type executeResult struct {
+	specific bool
+	code     uint32
+}
+
+// runExecute runs Execute with fake request and status channels
+func runExecute(d *fakeDaemon) (chan svc.ChangeRequest, chan svc.Status, chan executeResult) {
+	requests := make(chan svc.ChangeRequest)
+	status := make(chan svc.Status, 10)
+	result := make(chan executeResult, 1)
+	go func() {
+		specific, code := (&daemonService{serve: d.serve}).Execute(nil, requests, status)
+		result <- executeResult{specific, code}
+	}()
+	return requests, status, result
+}
+
+func nextStatus(t *testing.T, status <-chan svc.Status) svc.Status {
+	t.Helper()
+	select {
+	case s := <-status:
+		return s
+	case <-time.After(5 * time.Second):
+		t.Fatal("no status reported")
+		return svc.Status{}
+	}
+}
+
+func nextSignal(t *testing.T, signals <-chan os.Signal) os.Signal {
+	t.Helper()
+	select {
+	case sig := <-signals:
+		return sig
+	case <-time.After(5 * time.Second):
+		t.Fatal("no signal passed to the daemon")
+		return nil
+	}
+}
+
+func TestExecuteRequests(t *testing.T) {
+	d := newFakeDaemon()
+	requests, status, result := runExecute(d)
+
+	if s := nextStatus(t, status); s.State != svc.StartPending {
+		t.Fatalf("first status = %v; want StartPending", s.State)
+	}
+	// Nothing is reported running before the daemon started
+	select {
+	case s := <-status:
+		t.Fatalf("status %v reported before the daemon started", s.State)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(d.start)
+	if s := nextStatus(t, status); s.State != svc.Running || s.Accepts&svc.AcceptStop == 0 {
+		t.Fatalf("status after start = %+v; want Running accepting stop", s)
+	}
+
+	current := svc.Status{State: svc.Running}
+	requests <- svc.ChangeRequest{Cmd: svc.Interrogate, CurrentStatus: current}
+	if s := nextStatus(t, status); s != current {
+		t.Errorf("Interrogate reported %+v; want %+v", s, current)
+	}
+
+	requests <- svc.ChangeRequest{Cmd: svc.ParamChange}
+	if sig := nextSignal(t, d.signals); sig != syscall.SIGHUP {
+		t.Errorf("ParamChange sent %v; want SIGHUP", sig)
+	}
+
+	requests <- svc.ChangeRequest{Cmd: svc.Stop}
+	if s := nextStatus(t, status); s.State != svc.StopPending {
+		t.Errorf("status after Stop = %v; want StopPending", s.State)
+	}
+	if sig := nextSignal(t, d.signals); sig != syscall.SIGTERM {
+		t.Errorf("Stop sent %v; want SIGTERM", sig)
+	}
+	if r := <-result; r.specific || r.code != 0 {
+		t.Errorf("Execute() = %v, %d; want false, 0", r.specific, r.code)
+	}
+}
+
+func TestExecuteStartupFails(t *testing.T) {
+	d := newFakeDaemon()
+	d.err = errors.New("invalid config")
+	close(d.start)
+	_, status, result := runExecute(d)
+
+	if r := <-result; !r.specific || r.code != 1 {
+		t.Errorf("Execute() = %v, %d; want true, 1", r.specific, r.code)
+	}
+	close(status)
+	for s := range status {
+		if s.State == svc.Running {
+			t.Error("reported Running although startup failed")
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/mshan/go-tsk/internal/api"
+)
+
+// runShow prints a message fetched through the running daemon's API
+func runShow(args []string) error {
+	fs := flag.NewFlagSet("show", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to a JSON config file (defaults are used if empty)")
+	accountID := fs.String("account", "", "account the message is in (defaults to the first account)")
+	mailbox := fs.String("mailbox", "", "mailbox the UID refers to (defaults to INBOX)")
+	uid := fs.Uint("uid", 0, "UID of the message")
+	messageID := fs.String("message-id", "", "Message-ID of the message, instead of --uid")
+	fs.Parse(args)
+
+	cfg, err := loadConfigWithSecrets(*configPath)
+	if err != nil {
+		return err
+	}
+	if cfg.API.Addr == "" {
+		return errNoAPI
+	}
+	if (*uid == 0) == (*messageID == "") {
+		return fmt.Errorf("set either --uid or --message-id")
+	}
+	if *accountID == "" && len(cfg.EmailAccounts) > 0 {
+		*accountID = cfg.EmailAccounts[0].ID
+	}
+
+	client := api.NewClient(apiURL(cfg.API.Addr), cfg.API.Token)
+	m, err := client.Message(context.Background(), api.Query{
+		Account:   *accountID,
+		Mailbox:   *mailbox,
+		UID:       uint32(*uid),
+		MessageID: *messageID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to fetch message: %w", err)
+	}
+	printMessage(m)
+	return nil
+}
+
+// errNoAPI is returned by commands that need the daemon's API when it is
+// not configured
+var errNoAPI = errors.New("the daemon's API is not enabled; set API.Addr and API.Token")
+
+// newAPIClient creates a client for the API of the daemon running with the
+// config file at path
+func newAPIClient(path string) (*api.Client, error) {
+	cfg, err := loadConfigWithSecrets(path)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.API.Addr == "" {
+		return nil, errNoAPI
+	}
+	return api.NewClient(apiURL(cfg.API.Addr), cfg.API.Token), nil
+}
+
+// apiURL turns a listen address such as ":8081" into a URL to reach it
+func apiURL(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "http://" + addr
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// printMessage prints the headers, body and attachment list of a message
+func printMessage(m *api.Message) {
+	fmt.Printf("Account: %s\nMailbox: %s\nUID: %d\n", m.Account, m.Mailbox, m.UID)
+	if len(m.Flags) > 0 {
+		fmt.Printf("Flags: %s\n", strings.Join(m.Flags, " "))
+	}
+
+	names := make([]string, 0, len(m.Headers))
+	for name := range m.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range m.Headers[name] {
+			fmt.Printf("%s: %s\n", name, v)
+		}
+	}
+
+	fmt.Println()
+	switch {
+	case m.TextBody != "":
+		fmt.Println(m.TextBody)
+	case m.HTMLBody != "":
+		fmt.Println("(HTML only)")
+		fmt.Println(m.HTMLBody)
+	default:
+		fmt.Println("(no text body)")
+	}
+
+	if len(m.Attachments) > 0 {
+		fmt.Println()
+		fmt.Println("Attachments:")
+		for _, a := range m.Attachments {
+			fmt.Printf("  %s (%s, %d bytes)\n", a.Filename, a.ContentType, a.Size)
+		}
+	}
+}
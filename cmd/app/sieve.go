@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/mshan/go-tsk/internal/sieve"
+)
+
+// runImportSieve converts a Sieve script to rules and prints them as JSON
+// for Poll.Rules, reporting what could not be converted on stderr
+func runImportSieve(args []string) error {
+	fs := flag.NewFlagSet("import-sieve", flag.ExitOnError)
+	file := fs.String("file", "", "path to the Sieve script to convert")
+	fs.Parse(args)
+
+	if *file == "" {
+		return fmt.Errorf("--file is required")
+	}
+	src, err := os.ReadFile(*file)
+	if err != nil {
+		return err
+	}
+	converted, issues, err := sieve.Convert(string(src))
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", *file, err)
+	}
+	for _, issue := range issues {
+		fmt.Fprintf(os.Stderr, "%s:%d: %s\n", *file, issue.Line, issue.Msg)
+	}
+
+	if err := printRules(converted); err != nil {
+		return err
+	}
+	if len(issues) > 0 {
+		fmt.Fprintf(os.Stderr, "%d rules converted, %d issues\n", len(converted), len(issues))
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/scheduler"
+)
+
+// soakViolationLimit is how many consecutive bad samples fail a soak run
+const soakViolationLimit = 3
+
+// soakSample is one measurement of process health during a soak run
+type soakSample struct {
+	elapsed    time.Duration
+	goroutines int
+	heapBytes  uint64
+	generated  int
+	labeled    int
+}
+
+// runSoak drives sustained synthetic load through the full polling pipeline
+// using fake providers, and fails if goroutines or heap keep growing
+func runSoak(args []string) error {
+	fs := flag.NewFlagSet("soak", flag.ExitOnError)
+	rateFlag := fs.String("rate", "50/s", "messages generated per account, e.g. 50/s or 600/m")
+	duration := fs.Duration("duration", 10*time.Minute, "how long to run")
+	accounts := fs.Int("accounts", 1, "number of fake accounts")
+	interval := fs.Duration("interval", time.Second, "poll interval")
+	sampleEvery := fs.Duration("sample", 10*time.Second, "how often to sample goroutines and heap")
+	warmup := fs.Duration("warmup", 30*time.Second, "how long to run before taking the baseline sample")
+	maxGoroutineGrowth := fs.Int("max-goroutine-growth", 10, "allowed goroutine increase over the baseline")
+	maxHeapGrowth := fs.Float64("max-heap-growth", 2, "allowed heap growth factor over the baseline")
+	verbose := fs.Bool("verbose", false, "keep pipeline logging")
+	fs.Parse(args)
+
+	rate, err := parseRate(*rateFlag)
+	if err != nil {
+		return err
+	}
+	if *accounts < 1 {
+		return fmt.Errorf("at least one account is required")
+	}
+
+	// Pipeline logging at this volume would dominate the run
+	report := log.New(os.Stderr, "soak: ", log.LstdFlags)
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	cfg := soakConfig(*accounts, *interval)
+	providers := make(map[string]*email.FakeProvider)
+	for _, account := range cfg.EmailAccounts {
+		providers[account.ID] = email.NewFakeProvider(rate)
+	}
+
+	poller, err := scheduler.NewEmailPoller(cfg, nil,
+		scheduler.WithProviderFactory(func(account config.EmailAccount) (email.Provider, error) {
+			return providers[account.ID], nil
+		}),
+	)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- poller.Start(ctx)
+	}()
+
+	report.Printf("running %d account(s) at %s each for %s", *accounts, *rateFlag, *duration)
+
+	start := time.Now()
+	ticker := time.NewTicker(*sampleEvery)
+	defer ticker.Stop()
+	deadline := time.NewTimer(*duration)
+	defer deadline.Stop()
+
+	var baseline *soakSample
+	violations := 0
+	var failure error
+
+loop:
+	for {
+		select {
+		case err := <-done:
+			failure = fmt.Errorf("poller exited early: %v", err)
+			break loop
+		case <-deadline.C:
+			break loop
+		case <-ticker.C:
+			s := takeSample(start, providers)
+			report.Printf("t=%s goroutines=%d heap=%.1fMB generated=%d labeled=%d",
+				s.elapsed.Round(time.Second), s.goroutines, float64(s.heapBytes)/(1<<20), s.generated, s.labeled)
+
+			if baseline == nil {
+				if s.elapsed >= *warmup {
+					baseline = &s
+					report.Printf("baseline: goroutines=%d heap=%.1fMB", s.goroutines, float64(s.heapBytes)/(1<<20))
+				}
+				continue
+			}
+
+			if err := checkSample(*baseline, s, *maxGoroutineGrowth, *maxHeapGrowth); err != nil {
+				violations++
+				report.Printf("warning: %v (%d/%d)", err, violations, soakViolationLimit)
+				if violations >= soakViolationLimit {
+					failure = err
+					break loop
+				}
+			} else {
+				violations = 0
+			}
+		}
+	}
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+	if err := poller.Close(shutdownCtx); err != nil {
+		report.Printf("shutdown incomplete: %v", err)
+	}
+	cancel()
+
+	final := takeSample(start, providers)
+	report.Printf("finished: generated=%d labeled=%d errors=%d", final.generated, final.labeled, len(poller.Errors()))
+
+	switch {
+	case failure != nil:
+		return fmt.Errorf("soak failed: %w", failure)
+	case final.generated == 0 || final.labeled == 0:
+		return fmt.Errorf("soak failed: pipeline processed no messages")
+	case baseline == nil:
+		return fmt.Errorf("soak failed: run ended before the %s warmup completed", *warmup)
+	}
+	report.Printf("passed")
+	return nil
+}
+
+// soakConfig builds a config with n fake accounts and rules that exercise
+// both the label and notify paths
+func soakConfig(n int, interval time.Duration) *config.Config {
+	cfg := config.DefaultConfig()
+	cfg.EmailAccounts = nil
+	for i := 1; i <= n; i++ {
+		cfg.EmailAccounts = append(cfg.EmailAccounts, config.EmailAccount{
+			ID:       fmt.Sprintf("soak-%d", i),
+			Name:     fmt.Sprintf("Soak %d", i),
+			Provider: "fake",
+			Enabled:  true,
+		})
+	}
+	cfg.Poll.Interval = interval
+	cfg.Poll.Rules = []config.Rule{
+		{SubjectContains: "job opportunity", Action: "label", Label: "imp"},
+		{SubjectContains: "invoice", Action: "notify"},
+	}
+	return cfg
+}
+
+// takeSample measures goroutines and live heap after a forced GC
+func takeSample(start time.Time, providers map[string]*email.FakeProvider) soakSample {
+	runtime.GC()
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	s := soakSample{
+		elapsed:    time.Since(start),
+		goroutines: runtime.NumGoroutine(),
+		heapBytes:  mem.HeapAlloc,
+	}
+	for _, p := range providers {
+		s.generated += p.Generated()
+		for _, n := range p.Labeled() {
+			s.labeled += n
+		}
+	}
+	return s
+}
+
+// checkSample compares a sample against the baseline. Heap growth is
+// allowed a fixed floor so tiny baselines don't cause false alarms.
+func checkSample(baseline, s soakSample, maxGoroutineGrowth int, maxHeapGrowth float64) error {
+	if s.goroutines > baseline.goroutines+maxGoroutineGrowth {
+		return fmt.Errorf("goroutines grew from %d to %d", baseline.goroutines, s.goroutines)
+	}
+
+	const heapFloor = 16 << 20
+	limit := uint64(float64(baseline.heapBytes) * maxHeapGrowth)
+	if limit < baseline.heapBytes+heapFloor {
+		limit = baseline.heapBytes + heapFloor
+	}
+	if s.heapBytes > limit {
+		return fmt.Errorf("heap grew from %.1fMB to %.1fMB", float64(baseline.heapBytes)/(1<<20), float64(s.heapBytes)/(1<<20))
+	}
+	return nil
+}
+
+// parseRate parses rates such as "50/s", "600/m" or "1000/h" into messages
+// per second
+func parseRate(s string) (float64, error) {
+	count, unit, ok := strings.Cut(s, "/")
+	if !ok {
+		unit = "s"
+	}
+	n, err := strconv.ParseFloat(count, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+
+	switch unit {
+	case "s":
+		return n, nil
+	case "m":
+		return n / 60, nil
+	case "h":
+		return n / 3600, nil
+	default:
+		return 0, fmt.Errorf("invalid rate unit %q in %q", unit, s)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/store"
+	"github.com/mshan/go-tsk/internal/tasks"
+)
+
+// runList prints the tasks created from matching mail
+func runList(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to a JSON config file (defaults are used if empty)")
+	accountID := fs.String("account", "", "only list this account's tasks")
+	all := fs.Bool("all", false, "include done and snoozed tasks")
+	fs.Parse(args)
+
+	st, err := openTaskStore(*configPath)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	list, err := st.Tasks(*accountID)
+	if err != nil {
+		return fmt.Errorf("failed to load tasks: %w", err)
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tDUE\tLABELS\tTITLE\tFROM")
+	for _, t := range list {
+		if !*all && !t.Visible(now) {
+			continue
+		}
+		status := string(t.Status)
+		if t.SnoozedUntil.After(now) && t.Status != tasks.StatusDone {
+			status = "snoozed until " + t.SnoozedUntil.Format("2006-01-02 15:04")
+		}
+		due := "-"
+		if !t.Due.IsZero() {
+			due = t.Due.Format("2006-01-02")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", t.ID, status, due, strings.Join(t.Labels, ","), t.Title, t.Source.From)
+	}
+	return w.Flush()
+}
+
+// runDone marks a task as done
+func runDone(args []string) error {
+	fs := flag.NewFlagSet("done", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to a JSON config file (defaults are used if empty)")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: done [--config file] <id>")
+	}
+	id, err := parseTaskID(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	st, err := openTaskStore(*configPath)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	if err := st.CompleteTask(id); err != nil {
+		return taskError(id, err)
+	}
+	fmt.Printf("task %d done\n", id)
+	return nil
+}
+
+// runSnooze hides a task from the list for a while
+func runSnooze(args []string) error {
+	fs := flag.NewFlagSet("snooze", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to a JSON config file (defaults are used if empty)")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: snooze [--config file] <id> <duration>")
+	}
+	id, err := parseTaskID(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	d, err := parseSnooze(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	st, err := openTaskStore(*configPath)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	until := time.Now().Add(d)
+	if err := st.SnoozeTask(id, until); err != nil {
+		return taskError(id, err)
+	}
+	fmt.Printf("task %d snoozed until %s\n", id, until.Format("2006-01-02 15:04"))
+	return nil
+}
+
+// openTaskStore opens the state store that holds the tasks
+func openTaskStore(configPath string) (store.Store, error) {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Storage.Path == "" {
+		return nil, fmt.Errorf("tasks are only kept when Storage.Path is set")
+	}
+	st, err := store.Open(cfg.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state store: %w", err)
+	}
+	return st, nil
+}
+
+// parseTaskID parses a task ID argument
+func parseTaskID(s string) (int64, error) {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid task ID %q", s)
+	}
+	return id, nil
+}
+
+// parseSnooze parses a snooze duration
+func parseSnooze(s string) (time.Duration, error) {
+	d, err := parseDays(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid snooze duration %q; use e.g. 4h or 3d", s)
+	}
+	return d, nil
+}
+
+// parseDays parses a Go duration such as "4h" or a number of days such as
+// "3d"
+func parseDays(s string) (time.Duration, error) {
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.Atoi(days)
+		return time.Duration(n) * 24 * time.Hour, err
+	}
+	return time.ParseDuration(s)
+}
+
+// taskError describes a failed task update
+func taskError(id int64, err error) error {
+	if errors.Is(err, store.ErrTaskNotFound) {
+		return fmt.Errorf("no task with ID %d", id)
+	}
+	return fmt.Errorf("failed to update task %d: %w", id, err)
+}
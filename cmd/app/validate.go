@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/mshan/go-tsk/internal/rules"
+)
+
+// runValidate checks a config file and runs the tests embedded in its rules
+func runValidate(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to the JSON config file to validate")
+	fs.Parse(args)
+
+	if *configPath == "" {
+		return fmt.Errorf("--config is required")
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+
+	results, err := rules.RunTests(cfg.Poll.Rules)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, r := range results {
+		if r.Passed() {
+			continue
+		}
+		failed++
+		fmt.Printf("FAIL rule %d (%s) test %s: want %s, got %s\n",
+			r.Rule, rules.Describe(cfg.Poll.Rules[r.Rule]), r.Name, outcome(r.Want), outcome(r.Got))
+	}
+
+	fmt.Printf("config valid; %d rule tests, %d failed\n", len(results), failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d rule tests failed", failed, len(results))
+	}
+	return nil
+}
+
+// outcome describes whether a rule matched
+func outcome(matched bool) string {
+	if matched {
+		return "match"
+	}
+	return "no match"
+}
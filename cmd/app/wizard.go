@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/secrets"
+)
+
+// wizardTestTimeout bounds the test connection of accounts add
+const wizardTestTimeout = time.Minute
+
+// wizardProvider is a choice of the provider menu of accounts add
+type wizardProvider struct {
+	name        string // Provider of the account
+	description string
+}
+
+// wizardProviders are the providers accounts add offers, in menu order
+var wizardProviders = []wizardProvider{
+	{"gmail", "Gmail over IMAP, signing in with Google"},
+	{"gmailapi", "Gmail over the Gmail API, signing in with Google"},
+	{"yahoo", "Yahoo Mail, with an app password"},
+	{"aol", "AOL Mail, with an app password"},
+	{"icloud", "iCloud Mail, with an app-specific password"},
+	{"jmap", "JMAP server such as Fastmail, with an API token"},
+	{"pop3", "POP3 server, with a password"},
+	{"maildir", "Maildir on this computer"},
+	{"mbox", "mbox file on this computer"},
+}
+
+// runAccountsAdd walks through adding an account to a config file: the
+// provider, its credentials, a test connection and the mailboxes to poll.
+// The file is created if it doesn't exist.
+func runAccountsAdd(args []string) error {
+	fs := flag.NewFlagSet("accounts add", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to the JSON config file to add the account to; created if missing")
+	fs.Parse(args)
+
+	if *configPath == "" {
+		return fmt.Errorf("--config is required")
+	}
+	cfg := &config.Config{}
+	var err error
+	if _, err = os.Stat(*configPath); err == nil {
+		if cfg, err = loadConfigWithSecrets(*configPath); err != nil {
+			return err
+		}
+	}
+
+	w := &wizard{in: bufio.NewReader(os.Stdin), out: os.Stdout, keychain: keychain}
+	account, secret := w.account(cfg)
+	if w.err != nil {
+		return w.err
+	}
+
+	// Test with the secret itself, which the file only refers to
+	test := account
+	if secret != "" {
+		test.Password = secret
+		if account.Provider == "jmap" {
+			test.Password, test.Token = "", secret
+		}
+	}
+	var mailboxes []string
+	if account.ClientID != "" && cfg.Secrets.TokenFile.Path == "" {
+		fmt.Fprintln(w.out, "Skipping the test connection: signing in needs Secrets.TokenFile.Path to keep the tokens.")
+	} else if mailboxes, err = w.testConnection(cfg, test); err != nil {
+		fmt.Fprintf(w.out, "The test connection failed: %v\n", err)
+		if !w.confirm("Save the account anyway?", false) {
+			return errors.New("account not saved")
+		}
+	}
+	account.Mailboxes = w.mailboxes(mailboxes)
+	if w.err != nil {
+		return w.err
+	}
+
+	if err := addAccount(*configPath, account); err != nil {
+		return err
+	}
+	fmt.Fprintf(w.out, "Added account %s to %s.\n", account.ID, *configPath)
+	if account.ClientID != "" && cfg.Secrets.TokenFile.Path == "" {
+		fmt.Fprintf(w.out, "Set Secrets.TokenFile.Path, then sign in with: auth --config %s --account %s\n", *configPath, account.ID)
+	}
+	return nil
+}
+
+// secretSetter stores secrets by name, as the OS keychain does
+type secretSetter interface {
+	Set(name, secret string) error
+}
+
+// wizard asks questions on a terminal. The first read error sticks: later
+// questions get their defaults and it is returned at the end.
+type wizard struct {
+	in       *bufio.Reader
+	out      io.Writer
+	keychain secretSetter
+	err      error
+}
+
+// ask prints prompt and returns the answer, or def if it is empty
+func (w *wizard) ask(prompt, def string) string {
+	if w.err != nil {
+		return def
+	}
+	if def != "" {
+		fmt.Fprintf(w.out, "%s [%s]: ", prompt, def)
+	} else {
+		fmt.Fprintf(w.out, "%s: ", prompt)
+	}
+	line, err := w.in.ReadString('\n')
+	if err != nil && (line == "" || !errors.Is(err, io.EOF)) {
+		w.err = errors.New("setup aborted")
+		return def
+	}
+	if answer := strings.TrimSpace(line); answer != "" {
+		return answer
+	}
+	return def
+}
+
+// require asks until the answer is not empty
+func (w *wizard) require(prompt string) string {
+	for w.err == nil {
+		if answer := w.ask(prompt, ""); answer != "" {
+			return answer
+		}
+	}
+	return ""
+}
+
+// confirm asks a yes or no question
+func (w *wizard) confirm(prompt string, def bool) bool {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	switch strings.ToLower(w.ask(prompt+" ("+hint+")", "")) {
+	case "y", "yes":
+		return true
+	case "n", "no":
+		return false
+	}
+	return def
+}
+
+// choose prints a numbered menu and returns the index of the choice
+func (w *wizard) choose(prompt string, options []string) int {
+	for i, option := range options {
+		fmt.Fprintf(w.out, "  %d) %s\n", i+1, option)
+	}
+	for w.err == nil {
+		n, err := strconv.Atoi(w.ask(prompt, "1"))
+		if err == nil && n >= 1 && n <= len(options) {
+			return n - 1
+		}
+		fmt.Fprintf(w.out, "Enter a number from 1 to %d.\n", len(options))
+	}
+	return 0
+}
+
+// account asks for the settings of a new account. It returns the account as
+// it is to be saved and the password or token, if one was stored in the
+// keychain.
+func (w *wizard) account(cfg *config.Config) (config.EmailAccount, string) {
+	ids := make(map[string]bool)
+	for _, a := range cfg.EmailAccounts {
+		ids[a.ID] = true
+	}
+
+	options := make([]string, len(wizardProviders))
+	for i, p := range wizardProviders {
+		options[i] = p.description
+	}
+	fmt.Fprintln(w.out, "Which kind of account is it?")
+	account := config.EmailAccount{
+		Provider: wizardProviders[w.choose("Provider", options)].name,
+		Enabled:  true,
+	}
+
+	for w.err == nil {
+		account.ID = w.require("Account ID, used in rules and commands (e.g. work)")
+		if !ids[account.ID] {
+			break
+		}
+		fmt.Fprintf(w.out, "There is already an account %s.\n", account.ID)
+	}
+	account.Name = w.ask("Name shown in notifications", account.ID)
+
+	var secret string
+	switch account.Provider {
+	case "gmail", "gmailapi":
+		account.Address = w.require("Email address")
+		fmt.Fprintln(w.out, "Signing in needs an OAuth client of a Google Cloud project with the Gmail API enabled.")
+		account.ClientID = w.require("OAuth client ID")
+		account.ClientSecret = w.require("OAuth client secret")
+	case "yahoo", "aol", "icloud":
+		preset, _ := config.LookupPreset(account.Provider)
+		account.Address = w.require("Email address")
+		fmt.Fprintf(w.out, "Create an app password under %s.\n", preset.AppPassword)
+		secret = w.require("App password")
+		account.Password = w.keychainRef(account.ID, secret)
+	case "pop3":
+		account.Server = w.require("Server as host:port (995 for TLS)")
+		account.Address = w.require("User name")
+		secret = w.require("Password")
+		account.Password = w.keychainRef(account.ID, secret)
+	case "jmap":
+		account.SessionURL = w.ask("Session URL", "https://api.fastmail.com/jmap/session")
+		account.Address = w.ask("Email address", "")
+		secret = w.require("API token")
+		account.Token = w.keychainRef(account.ID, secret)
+	case "maildir", "mbox":
+		account.Path = w.require("Path")
+		if abs, err := filepath.Abs(account.Path); err == nil {
+			account.Path = abs
+		}
+	}
+	// Secrets left in the file are not referenced, so need no resolving
+	if secret == account.Password || secret == account.Token {
+		secret = ""
+	}
+	return account, secret
+}
+
+// keychainRef offers to store secret in the OS keychain under the account's
+// ID, returning the "keyring:" reference to it, or secret itself if it is to
+// be written to the config file
+func (w *wizard) keychainRef(name, secret string) string {
+	if w.err != nil || !w.confirm("Store it in the OS keychain instead of the config file?", true) {
+		return secret
+	}
+	if err := w.keychain.Set(name, secret); err != nil {
+		fmt.Fprintf(w.out, "Could not store it in the keychain, so it goes in the config file: %v\n", err)
+		return secret
+	}
+	return secrets.KeyringScheme + ":" + name
+}
+
+// testConnection signs account in and returns its mailboxes. Gmail accounts
+// sign in in the browser first, saving the tokens to the token file.
+func (w *wizard) testConnection(cfg *config.Config, account config.EmailAccount) ([]string, error) {
+	if account.ClientID != "" {
+		if err := signIn(cfg, account); err != nil {
+			return nil, err
+		}
+	}
+
+	fmt.Fprintln(w.out, "Connecting...")
+	ctx, cancel := context.WithTimeout(context.Background(), wizardTestTimeout)
+	defer cancel()
+	provider, err := providerFactory(cfg)(account)
+	if err != nil {
+		return nil, err
+	}
+	if err := provider.Connect(ctx); err != nil {
+		return nil, err
+	}
+	defer provider.Close()
+	if err := provider.Authenticate(ctx); err != nil {
+		return nil, err
+	}
+	mailboxes, err := provider.ListMailboxes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(w.out, "Connected; the account has %d mailboxes.\n", len(mailboxes))
+	return mailboxes, nil
+}
+
+// mailboxes asks which of the account's mailboxes to poll, returning nil
+// for INBOX only
+func (w *wizard) mailboxes(available []string) []string {
+	if len(available) > 1 {
+		fmt.Fprintf(w.out, "Mailboxes: %s\n", strings.Join(available, ", "))
+	}
+	answer := w.ask("Mailboxes to poll, separated by commas; patterns such as Lists/* are allowed", "INBOX")
+	var mailboxes []string
+	for _, name := range strings.Split(answer, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			mailboxes = append(mailboxes, name)
+		}
+	}
+	if len(mailboxes) == 1 && mailboxes[0] == "INBOX" {
+		return nil
+	}
+	return mailboxes
+}
+
+// addAccount appends account to the EmailAccounts of the config file at
+// path, creating the file if needed. Other settings keep their values, but
+// the file is rewritten with its top-level keys sorted and two-space
+// indentation. The result must pass validation before the file is
+// replaced, which keeps its permissions.
+func addAccount(path string, account config.EmailAccount) error {
+	settings := make(map[string]json.RawMessage)
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+	// A new file may hold secrets, so only its owner may read it
+	mode := os.FileMode(0o600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, &settings); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+
+	var accounts []json.RawMessage
+	if raw, ok := settings["EmailAccounts"]; ok {
+		if err := json.Unmarshal(raw, &accounts); err != nil {
+			return fmt.Errorf("failed to parse the accounts of %s: %w", path, err)
+		}
+	}
+	entry, err := json.Marshal(accountSettings(account))
+	if err != nil {
+		return err
+	}
+	accounts = append(accounts, entry)
+	if settings["EmailAccounts"], err = json.Marshal(accounts); err != nil {
+		return err
+	}
+
+	out, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := config.Parse(out); err != nil {
+		return fmt.Errorf("the config with the new account is invalid: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(out, '\n'), mode); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	// WriteFile leaves the mode of an existing file, and umask narrows it
+	if err := os.Chmod(tmp, mode); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	return nil
+}
+
+// accountSettings returns the settings of account that are set, as they
+// are written to the config file
+func accountSettings(account config.EmailAccount) map[string]interface{} {
+	settings := map[string]interface{}{
+		"ID":       account.ID,
+		"Provider": account.Provider,
+		"Enabled":  account.Enabled,
+	}
+	for key, value := range map[string]string{
+		"Name":         account.Name,
+		"Address":      account.Address,
+		"ClientID":     account.ClientID,
+		"ClientSecret": account.ClientSecret,
+		"Token":        account.Token,
+		"Password":     account.Password,
+		"Server":       account.Server,
+		"SessionURL":   account.SessionURL,
+		"Path":         account.Path,
+	} {
+		if value != "" {
+			settings[key] = value
+		}
+	}
+	if len(account.Mailboxes) > 0 {
+		settings["Mailboxes"] = account.Mailboxes
+	}
+	return settings
+}
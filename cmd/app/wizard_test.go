@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+// fakeKeychain keeps secrets in memory, or fails to store them if err is
+// set
+type fakeKeychain struct {
+	secrets map[string]string
+	err     error
+}
+
+func (k *fakeKeychain) Set(name, secret string) error {
+	if k.err != nil {
+		return k.err
+	}
+	k.secrets[name] = secret
+	return nil
+}
+
+// newTestWizard returns a wizard answering with the lines of input
+func newTestWizard(input string) (*wizard, *bytes.Buffer, *fakeKeychain) {
+	out := &bytes.Buffer{}
+	kc := &fakeKeychain{secrets: make(map[string]string)}
+	return &wizard{in: bufio.NewReader(strings.NewReader(input)), out: out, keychain: kc}, out, kc
+}
+
+func TestWizardConfirm(t *testing.T) {
+	tests := []struct {
+		input string
+		def   bool
+		want  bool
+	}{
+		{"y\n", false, true},
+		{"YES\n", false, true},
+		{"n\n", true, false},
+		{"no\n", true, false},
+		{"\n", true, true},
+		{"\n", false, false},
+		{"maybe\n", true, true},
+		{"yes", false, true}, // Last line without a newline
+	}
+
+	for _, tt := range tests {
+		w, _, _ := newTestWizard(tt.input)
+		if got := w.confirm("Continue?", tt.def); got != tt.want || w.err != nil {
+			t.Errorf("confirm(%q, %v) = %v, err %v; want %v", tt.input, tt.def, got, w.err, tt.want)
+		}
+	}
+}
+
+func TestWizardChoose(t *testing.T) {
+	w, out, _ := newTestWizard("0\nthree\n\n")
+	if got := w.choose("Pick", []string{"a", "b", "c"}); got != 0 {
+		t.Errorf("choose() with the default = %d; want 0", got)
+	}
+	if n := strings.Count(out.String(), "Enter a number from 1 to 3."); n != 2 {
+		t.Errorf("output %q; want 2 retries", out.String())
+	}
+
+	w, _, _ = newTestWizard("3\n")
+	if got := w.choose("Pick", []string{"a", "b", "c"}); got != 2 {
+		t.Errorf("choose(3) = %d; want 2", got)
+	}
+}
+
+func TestWizardAborted(t *testing.T) {
+	// Input ends: the question gets its default and later ones are not
+	// asked
+	w, out, _ := newTestWizard("")
+	if got := w.ask("Name", "work"); got != "work" {
+		t.Errorf("ask() = %q; want the default", got)
+	}
+	if w.err == nil {
+		t.Fatal("err = nil; want the setup aborted")
+	}
+	out.Reset()
+	if got := w.require("Address"); got != "" || out.Len() != 0 {
+		t.Errorf("require() after the abort = %q, asked %q; want nothing", got, out.String())
+	}
+	if got := w.choose("Pick", []string{"a", "b"}); got != 0 {
+		t.Errorf("choose() after the abort = %d; want 0", got)
+	}
+}
+
+func TestWizardMailboxes(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"\n", nil},
+		{"INBOX\n", nil},
+		{"INBOX, Lists/*,,\n", []string{"INBOX", "Lists/*"}},
+		{"Receipts\n", []string{"Receipts"}},
+	}
+
+	for _, tt := range tests {
+		w, out, _ := newTestWizard(tt.input)
+		if got := w.mailboxes([]string{"INBOX", "Receipts"}); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mailboxes(%q) = %q; want %q", tt.input, got, tt.want)
+		}
+		if !strings.Contains(out.String(), "Mailboxes: INBOX, Receipts") {
+			t.Errorf("output %q; want the available mailboxes", out.String())
+		}
+	}
+}
+
+func TestWizardAccount(t *testing.T) {
+	abs, err := filepath.Abs("Maildir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		input       string
+		keychainErr error
+		want        config.EmailAccount
+		wantSecret  string
+		wantStored  map[string]string
+	}{
+		{
+			name:       "app password in the keychain",
+			input:      "3\nwork\n\nme@yahoo.com\nsecret-pw\n\n",
+			want:       config.EmailAccount{ID: "work", Name: "work", Provider: "yahoo", Enabled: true, Address: "me@yahoo.com", Password: "keyring:work"},
+			wantSecret: "secret-pw",
+			wantStored: map[string]string{"work": "secret-pw"},
+		},
+		{
+			name:       "app password in the file",
+			input:      "3\nwork\nWork mail\nme@yahoo.com\nsecret-pw\nn\n",
+			want:       config.EmailAccount{ID: "work", Name: "Work mail", Provider: "yahoo", Enabled: true, Address: "me@yahoo.com", Password: "secret-pw"},
+			wantStored: map[string]string{},
+		},
+		{
+			name:        "keychain unavailable",
+			input:       "7\nhome\n\npop.example.com:995\nme\nsecret-pw\ny\n",
+			keychainErr: errors.New("no keychain"),
+			want:        config.EmailAccount{ID: "home", Name: "home", Provider: "pop3", Enabled: true, Server: "pop.example.com:995", Address: "me", Password: "secret-pw"},
+			wantStored:  map[string]string{},
+		},
+		{
+			name:       "jmap token",
+			input:      "6\nfm\n\n\nme@fastmail.com\ntoken-1\n\n",
+			want:       config.EmailAccount{ID: "fm", Name: "fm", Provider: "jmap", Enabled: true, SessionURL: "https://api.fastmail.com/jmap/session", Address: "me@fastmail.com", Token: "keyring:fm"},
+			wantSecret: "token-1",
+			wantStored: map[string]string{"fm": "token-1"},
+		},
+		{
+			name:       "gmail client",
+			input:      "1\nprimary\n\nme@gmail.com\nclient-id\nclient-secret\n",
+			want:       config.EmailAccount{ID: "primary", Name: "primary", Provider: "gmail", Enabled: true, Address: "me@gmail.com", ClientID: "client-id", ClientSecret: "client-secret"},
+			wantStored: map[string]string{},
+		},
+		{
+			name:       "maildir path made absolute",
+			input:      "8\nlocal\n\nMaildir\n",
+			want:       config.EmailAccount{ID: "local", Name: "local", Provider: "maildir", Enabled: true, Path: abs},
+			wantStored: map[string]string{},
+		},
+		{
+			name:       "taken ID asked again",
+			input:      "8\nexisting\nlocal\n\n/mail\n",
+			want:       config.EmailAccount{ID: "local", Name: "local", Provider: "maildir", Enabled: true, Path: "/mail"},
+			wantStored: map[string]string{},
+		},
+	}
+
+	cfg := &config.Config{EmailAccounts: []config.EmailAccount{{ID: "existing"}}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, _, kc := newTestWizard(tt.input)
+			kc.err = tt.keychainErr
+			account, secret := w.account(cfg)
+			if w.err != nil {
+				t.Fatalf("account() error = %v", w.err)
+			}
+			if !reflect.DeepEqual(account, tt.want) || secret != tt.wantSecret {
+				t.Errorf("account() = %+v, %q; want %+v, %q", account, secret, tt.want, tt.wantSecret)
+			}
+			if !reflect.DeepEqual(kc.secrets, tt.wantStored) {
+				t.Errorf("keychain = %v; want %v", kc.secrets, tt.wantStored)
+			}
+		})
+	}
+}
+
+func TestAccountSettings(t *testing.T) {
+	got := accountSettings(config.EmailAccount{
+		ID: "work", Provider: "yahoo", Enabled: true, Address: "me@yahoo.com",
+		Password: "keyring:work", Mailboxes: []string{"INBOX", "Receipts"},
+	})
+	want := map[string]interface{}{
+		"ID": "work", "Provider": "yahoo", "Enabled": true, "Address": "me@yahoo.com",
+		"Password": "keyring:work", "Mailboxes": []string{"INBOX", "Receipts"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("accountSettings() = %v; want %v", got, want)
+	}
+}
+
+func TestAddAccount(t *testing.T) {
+	dir := t.TempDir()
+	local := config.EmailAccount{ID: "local", Provider: "maildir", Enabled: true, Path: "/mail"}
+
+	// An existing file keeps its other settings and its permissions
+	path := filepath.Join(dir, "config.json")
+	existing := `{"Poll": {"Interval": "10m"},
+  "EmailAccounts": [{"ID": "work", "Provider": "maildir", "Path": "/work", "Enabled": true}]}`
+	if err := os.WriteFile(path, []byte(existing), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := addAccount(path, local); err != nil {
+		t.Fatalf("addAccount() error = %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var settings struct {
+		Poll          map[string]string
+		EmailAccounts []map[string]interface{}
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		t.Fatalf("config written is invalid: %v\n%s", err, data)
+	}
+	if settings.Poll["Interval"] != "10m" || len(settings.EmailAccounts) != 2 ||
+		settings.EmailAccounts[0]["ID"] != "work" || settings.EmailAccounts[1]["ID"] != "local" {
+		t.Errorf("config written = %s", data)
+	}
+	if mode := fileMode(t, path); mode != 0o640 {
+		t.Errorf("mode = %v; want 0640 kept", mode)
+	}
+
+	// An invalid result leaves the file as it was
+	if err := addAccount(path, local); err == nil {
+		t.Error("addAccount() of a duplicate ID succeeded")
+	}
+	if after, _ := os.ReadFile(path); !bytes.Equal(after, data) {
+		t.Errorf("rejected account changed the file to %s", after)
+	}
+
+	// A new file is created readable by its owner only
+	path = filepath.Join(dir, "new.json")
+	if err := addAccount(path, local); err != nil {
+		t.Fatalf("addAccount() to a new file error = %v", err)
+	}
+	if mode := fileMode(t, path); mode != 0o600 {
+		t.Errorf("mode of new file = %v; want 0600", mode)
+	}
+}
+
+// fileMode returns the permissions of the file at path
+func fileMode(t *testing.T, path string) os.FileMode {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Mode().Perm()
+}
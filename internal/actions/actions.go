@@ -0,0 +1,102 @@
+// Package actions holds the actions rules run on the messages they match.
+// The poller registers the built-in actions in a Registry of its own;
+// extensions add theirs with Register, usually from an init function, and
+// rules name them in Action with their settings in Params.
+package actions
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+// Params describes the match an action runs for
+type Params struct {
+	Account   config.EmailAccount
+	Provider  email.Provider // Client of the account the message was fetched with
+	Rule      config.Rule
+	RuleIndex int    // Index of Rule in the configured rules
+	Key       string // Journal key of the message
+}
+
+// Action is something a rule does to a message it matched. Execute may run
+// concurrently for messages of different accounts.
+type Action interface {
+	Execute(ctx context.Context, msg *email.Email, params Params) error
+}
+
+// Func adapts a function to an Action
+type Func func(ctx context.Context, msg *email.Email, params Params) error
+
+// Execute calls f
+func (f Func) Execute(ctx context.Context, msg *email.Email, params Params) error {
+	return f(ctx, msg, params)
+}
+
+// Registry maps action names to actions
+type Registry struct {
+	mu      sync.RWMutex
+	actions map[string]Action
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{actions: make(map[string]Action)}
+}
+
+// Register adds an action under name. It fails if the name is empty or
+// taken.
+func (r *Registry) Register(name string, action Action) error {
+	if name == "" || action == nil {
+		return fmt.Errorf("action needs a name and an implementation")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.actions[name]; ok {
+		return fmt.Errorf("action %q is already registered", name)
+	}
+	r.actions[name] = action
+	return nil
+}
+
+// Lookup returns the action registered under name
+func (r *Registry) Lookup(name string) (Action, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	action, ok := r.actions[name]
+	return action, ok
+}
+
+// Names returns the names of the registered actions, sorted
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.actions))
+	for name := range r.actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// extensions holds the actions added with Register
+var extensions = NewRegistry()
+
+// Register makes an extension's action available to rules under name and
+// lets config validation accept rules naming it. It panics if the name is
+// taken, as registering twice is a programming error.
+func Register(name string, action Action) {
+	if err := extensions.Register(name, action); err != nil {
+		panic(err)
+	}
+	config.RegisterAction(name)
+}
+
+// Extensions returns the actions added with Register
+func Extensions() *Registry {
+	return extensions
+}
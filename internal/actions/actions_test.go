@@ -0,0 +1,79 @@
+package actions
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	var ran []string
+	record := func(name string) Func {
+		return func(ctx context.Context, msg *email.Email, params Params) error {
+			ran = append(ran, name+":"+msg.Subject)
+			return nil
+		}
+	}
+	if err := r.Register("b", record("b")); err != nil {
+		t.Fatalf("Register b: %v", err)
+	}
+	if err := r.Register("a", record("a")); err != nil {
+		t.Fatalf("Register a: %v", err)
+	}
+	if err := r.Register("a", record("again")); err == nil {
+		t.Error("Register of a taken name succeeded")
+	}
+	if err := r.Register("", record("")); err == nil {
+		t.Error("Register without a name succeeded")
+	}
+	if err := r.Register("c", nil); err == nil {
+		t.Error("Register without an action succeeded")
+	}
+	if got := r.Names(); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("Names = %v", got)
+	}
+
+	action, ok := r.Lookup("a")
+	if !ok {
+		t.Fatal("Lookup a failed")
+	}
+	if err := action.Execute(context.Background(), &email.Email{Subject: "Hi"}, Params{}); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if !reflect.DeepEqual(ran, []string{"a:Hi"}) {
+		t.Errorf("ran = %v", ran)
+	}
+	if _, ok := r.Lookup("missing"); ok {
+		t.Error("Lookup of an unregistered name succeeded")
+	}
+}
+
+func TestRegisterExtension(t *testing.T) {
+	const rules = `{"Poll": {"Rules": [{"SubjectContains": "x", "Action": "test-extension", "Params": {"channel": "#ops"}}]}}`
+	if _, err := config.Parse([]byte(rules)); err == nil {
+		t.Fatal("Parse accepted an unregistered action")
+	}
+
+	Register("test-extension", Func(func(ctx context.Context, msg *email.Email, params Params) error { return nil }))
+	cfg, err := config.Parse([]byte(rules))
+	if err != nil {
+		t.Fatalf("Parse after Register: %v", err)
+	}
+	if got := cfg.Poll.Rules[0].Params["channel"]; got != "#ops" {
+		t.Errorf("params channel = %q", got)
+	}
+	if _, ok := Extensions().Lookup("test-extension"); !ok {
+		t.Error("extension not registered")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice did not panic")
+		}
+	}()
+	Register("test-extension", Func(func(ctx context.Context, msg *email.Email, params Params) error { return nil }))
+}
@@ -0,0 +1,259 @@
+// Package api serves access to mail through the daemon's own IMAP
+// connections, so tools such as the dashboard and the approval UI can show
+// a message in full without IMAP credentials of their own, control of the
+// running daemon (account status, recent matches, the message archive,
+// pausing and resuming accounts, immediate polls and rule reloads) and a
+// live event stream.
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/scheduler"
+)
+
+// MessagesPath is the endpoint serving single messages
+const MessagesPath = "/api/v1/messages"
+
+// lookupTimeout bounds a single message lookup
+const lookupTimeout = 30 * time.Second
+
+// MessageSource looks messages up; *scheduler.EmailPoller implements it
+type MessageSource interface {
+	Message(ctx context.Context, accountID, mailbox string, uid uint32) (*email.Message, error)
+	FindMessage(ctx context.Context, accountID, messageID string) (*email.Message, error)
+}
+
+// Query selects one message: by UID within a mailbox (INBOX if empty), or
+// by Message-ID across the account's polled mailboxes
+type Query struct {
+	Account   string
+	Mailbox   string
+	UID       uint32
+	MessageID string
+}
+
+// values encodes q as URL query parameters
+func (q Query) values() url.Values {
+	v := url.Values{"account": {q.Account}}
+	if q.MessageID != "" {
+		v.Set("message_id", q.MessageID)
+		return v
+	}
+	if q.Mailbox != "" {
+		v.Set("mailbox", q.Mailbox)
+	}
+	v.Set("uid", strconv.FormatUint(uint64(q.UID), 10))
+	return v
+}
+
+// parseQuery decodes and checks the query parameters of a request
+func parseQuery(v url.Values) (Query, error) {
+	q := Query{
+		Account:   v.Get("account"),
+		Mailbox:   v.Get("mailbox"),
+		MessageID: v.Get("message_id"),
+	}
+	if q.Account == "" {
+		return q, errors.New("account is required")
+	}
+	uid := v.Get("uid")
+	switch {
+	case q.MessageID != "" && uid != "":
+		return q, errors.New("set uid or message_id, not both")
+	case q.MessageID != "":
+		return q, nil
+	case uid == "":
+		return q, errors.New("uid or message_id is required")
+	}
+	n, err := strconv.ParseUint(uid, 10, 32)
+	if err != nil || n == 0 {
+		return q, errors.New("invalid uid")
+	}
+	q.UID = uint32(n)
+	return q, nil
+}
+
+// Message is the JSON form of a message
+type Message struct {
+	Account     string              `json:"account"`
+	Mailbox     string              `json:"mailbox"`
+	UID         uint32              `json:"uid"`
+	MessageID   string              `json:"message_id,omitempty"`
+	Subject     string              `json:"subject"`
+	From        string              `json:"from"`
+	Date        time.Time           `json:"date"`
+	Flags       []string            `json:"flags"`
+	Headers     map[string][]string `json:"headers"`
+	TextBody    string              `json:"text_body,omitempty"`
+	HTMLBody    string              `json:"html_body,omitempty"`
+	Attachments []Attachment        `json:"attachments"`
+}
+
+// Attachment is the JSON form of an attachment
+type Attachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+// newMessage converts a fetched message to its JSON form
+func newMessage(accountID string, m *email.Message) Message {
+	out := Message{
+		Account:     accountID,
+		Mailbox:     m.Mailbox,
+		UID:         m.UID,
+		MessageID:   m.MessageID,
+		Subject:     m.Subject,
+		From:        m.From,
+		Date:        m.Date,
+		Flags:       m.Flags,
+		Headers:     m.Header,
+		TextBody:    m.TextBody,
+		HTMLBody:    m.HTMLBody,
+		Attachments: []Attachment{},
+	}
+	for _, a := range m.Attachments {
+		out.Attachments = append(out.Attachments, Attachment(a))
+	}
+	return out
+}
+
+// Source is everything the API serves; *scheduler.EmailPoller implements it
+type Source interface {
+	MessageSource
+	Controller
+	EventSource
+}
+
+// NewHandler returns the API handler, which also serves the dashboard.
+// Every API request must carry token as a bearer token; the dashboard's
+// static files hold no data and are public.
+func NewHandler(src Source, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(MessagesPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		serveMessage(w, r, src)
+	})
+	accounts := func(w http.ResponseWriter, r *http.Request) { serveAccounts(w, r, src) }
+	mux.HandleFunc(AccountsPath, accounts)
+	mux.HandleFunc(AccountsPath+"/", accounts)
+	mux.HandleFunc(MatchesPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		serveMatches(w, r, src)
+	})
+	mux.HandleFunc(ReloadPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		serveReload(w, r, src)
+	})
+	mux.HandleFunc(RuleStatsPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		serveRuleStats(w, r, src)
+	})
+	mux.HandleFunc(EventsPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		serveEvents(w, r, src)
+	})
+	mux.HandleFunc(ErrorsPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		serveErrors(w, r, src)
+	})
+	mux.HandleFunc(HistoryPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		serveHistory(w, r, src)
+	})
+	mux.HandleFunc(SearchPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		serveSearch(w, r, src)
+	})
+
+	root := http.NewServeMux()
+	root.Handle("/api/", requireToken(token, mux))
+	root.Handle("/", dashboardHandler())
+	return root
+}
+
+// ListenAndServe serves the API on addr
+func ListenAndServe(addr, token string, src Source) error {
+	return http.ListenAndServe(addr, NewHandler(src, token))
+}
+
+// requireToken rejects requests without the bearer token
+func requireToken(token string, next http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if token == "" || subtle.ConstantTimeCompare(got, want) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveMessage looks up the message a request selects
+func serveMessage(w http.ResponseWriter, r *http.Request, src MessageSource) {
+	q, err := parseQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), lookupTimeout)
+	defer cancel()
+
+	var m *email.Message
+	if q.MessageID != "" {
+		m, err = src.FindMessage(ctx, q.Account, q.MessageID)
+	} else {
+		m, err = src.Message(ctx, q.Account, q.Mailbox, q.UID)
+	}
+	switch {
+	case errors.Is(err, email.ErrMessageNotFound), errors.Is(err, scheduler.ErrUnknownAccount):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, scheduler.ErrNotConnected):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		log.Printf("API: failed to look up message in account %s: %v", q.Account, err)
+		http.Error(w, "failed to fetch message", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newMessage(q.Account, m))
+}
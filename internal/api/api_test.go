@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/events"
+	"github.com/mshan/go-tsk/internal/scheduler"
+)
+
+// fakeSource serves one message, UID 7 in INBOX of account "primary", and
+// streams the events of its emitter
+type fakeSource struct {
+	fakeControl
+	*events.Emitter
+}
+
+var testMessage = &email.Message{
+	Email: email.Email{
+		Mailbox: email.Inbox, UID: 7, MessageID: "<7@example.com>", Subject: "Invoice #7", TextBody: "Amount due",
+		Attachments: []email.Attachment{{Filename: "invoice.pdf", ContentType: "application/pdf", Size: 1024}},
+	},
+}
+
+func (fakeSource) Message(ctx context.Context, accountID, mailbox string, uid uint32) (*email.Message, error) {
+	switch {
+	case accountID == "offline":
+		return nil, scheduler.ErrNotConnected
+	case accountID != "primary":
+		return nil, scheduler.ErrUnknownAccount
+	case mailbox != "" && mailbox != email.Inbox, uid != 7:
+		return nil, email.ErrMessageNotFound
+	}
+	return testMessage, nil
+}
+
+func (s fakeSource) FindMessage(ctx context.Context, accountID, messageID string) (*email.Message, error) {
+	if messageID != "<7@example.com>" {
+		return nil, email.ErrMessageNotFound
+	}
+	return s.Message(ctx, accountID, "", 7)
+}
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		query  string
+		status int
+	}{
+		{"by uid", "secret", "account=primary&uid=7", http.StatusOK},
+		{"by uid in mailbox", "secret", "account=primary&mailbox=INBOX&uid=7", http.StatusOK},
+		{"by message id", "secret", "account=primary&message_id=%3C7%40example.com%3E", http.StatusOK},
+		{"no token", "", "account=primary&uid=7", http.StatusUnauthorized},
+		{"wrong token", "guess", "account=primary&uid=7", http.StatusUnauthorized},
+		{"no account", "secret", "uid=7", http.StatusBadRequest},
+		{"no selector", "secret", "account=primary", http.StatusBadRequest},
+		{"both selectors", "secret", "account=primary&uid=7&message_id=x", http.StatusBadRequest},
+		{"bad uid", "secret", "account=primary&uid=-1", http.StatusBadRequest},
+		{"missing message", "secret", "account=primary&uid=8", http.StatusNotFound},
+		{"unknown account", "secret", "account=other&uid=7", http.StatusNotFound},
+		{"not connected", "secret", "account=offline&uid=7", http.StatusServiceUnavailable},
+	}
+
+	h := NewHandler(fakeSource{}, "secret")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, MessagesPath+"?"+tt.query, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d; want %d (body %q)", rec.Code, tt.status, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestClient(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(fakeSource{}, "secret"))
+	defer srv.Close()
+	ctx := context.Background()
+
+	c := NewClient(srv.URL+"/", "secret")
+	m, err := c.Message(ctx, Query{Account: "primary", MessageID: "<7@example.com>"})
+	if err != nil {
+		t.Fatalf("Message() error = %v", err)
+	}
+	if m.Account != "primary" || m.UID != 7 || m.Subject != "Invoice #7" || m.TextBody != "Amount due" {
+		t.Errorf("Message() = %+v", m)
+	}
+	if len(m.Attachments) != 1 || m.Attachments[0] != (Attachment{"invoice.pdf", "application/pdf", 1024}) {
+		t.Errorf("attachments = %+v", m.Attachments)
+	}
+
+	if _, err := c.Message(ctx, Query{Account: "primary", UID: 8}); err == nil {
+		t.Error("Message(UID 8) error = nil; want not found")
+	}
+
+	statuses, err := c.Accounts(ctx)
+	if err != nil || len(statuses) != 2 || statuses[1].ID != "idle" || !statuses[1].Paused {
+		t.Errorf("Accounts() = %+v, %v; want primary and paused idle", statuses, err)
+	}
+	if s, err := c.Pause(ctx, "primary"); err != nil || s.ID != "primary" {
+		t.Errorf("Pause(primary) = %+v, %v", s, err)
+	}
+	if s, err := c.Resume(ctx, "idle"); err != nil || s.ID != "idle" {
+		t.Errorf("Resume(idle) = %+v, %v", s, err)
+	}
+	if _, err := c.Pause(ctx, "nosuch"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Pause(nosuch) error = %v; want 404", err)
+	}
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client talks to a running daemon's API
+type Client struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+// NewClient creates a client for the API at baseURL, e.g.
+// "http://localhost:8081"
+func NewClient(baseURL, token string) *Client {
+	return &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   token,
+		client:  &http.Client{Timeout: lookupTimeout + 5*time.Second},
+	}
+}
+
+// Message fetches the message q selects
+func (c *Client) Message(ctx context.Context, q Query) (*Message, error) {
+	var m Message
+	if err := c.do(ctx, http.MethodGet, MessagesPath+"?"+q.values().Encode(), &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Accounts fetches the status of every account
+func (c *Client) Accounts(ctx context.Context) ([]AccountStatus, error) {
+	var statuses []AccountStatus
+	if err := c.do(ctx, http.MethodGet, AccountsPath, &statuses); err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+// RuleStats fetches the statistics of every rule
+func (c *Client) RuleStats(ctx context.Context) ([]RuleStats, error) {
+	var stats []RuleStats
+	if err := c.do(ctx, http.MethodGet, RuleStatsPath, &stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// Pause stops an account's scheduled polls and returns its new status
+func (c *Client) Pause(ctx context.Context, accountID string) (*AccountStatus, error) {
+	return c.control(ctx, accountID, "pause")
+}
+
+// Resume restarts a paused account's polls and returns its new status
+func (c *Client) Resume(ctx context.Context, accountID string) (*AccountStatus, error) {
+	return c.control(ctx, accountID, "resume")
+}
+
+// control POSTs a control verb for an account
+func (c *Client) control(ctx context.Context, accountID, verb string) (*AccountStatus, error) {
+	var s AccountStatus
+	if err := c.do(ctx, http.MethodPost, AccountsPath+"/"+url.PathEscape(accountID)+"/"+verb, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// do sends an authenticated request and decodes the JSON response into out
+func (c *Client) do(ctx context.Context, method, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("api request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("api returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid api response: %w", err)
+	}
+	return nil
+}
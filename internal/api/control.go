@@ -0,0 +1,397 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/scheduler"
+	"github.com/mshan/go-tsk/internal/store"
+)
+
+// Runtime control endpoints
+const (
+	// AccountsPath lists accounts; AccountsPath/{id} shows one and
+	// AccountsPath/{id}/pause, /resume and /poll control it
+	AccountsPath = "/api/v1/accounts"
+	// MatchesPath lists recent rule matches
+	MatchesPath = "/api/v1/matches"
+	// ReloadPath reloads the rules from the config file
+	ReloadPath = "/api/v1/rules/reload"
+	// RuleStatsPath lists how often each rule matched and acted
+	RuleStatsPath = "/api/v1/rules/stats"
+	// ErrorsPath lists recent polling errors
+	ErrorsPath = "/api/v1/errors"
+	// HistoryPath lists archived messages by sender, subject and date
+	HistoryPath = "/api/v1/history"
+	// SearchPath searches the text of archived messages
+	SearchPath = "/api/v1/search"
+)
+
+// defaultMatchLimit is the number of matches listed when no limit is given
+const defaultMatchLimit = 20
+
+// defaultHistoryLimit is the number of archived messages listed when no
+// limit is given
+const defaultHistoryLimit = 50
+
+// Controller drives a running daemon; *scheduler.EmailPoller implements it
+type Controller interface {
+	Accounts() []scheduler.AccountStatus
+	Account(accountID string) (scheduler.AccountStatus, error)
+	RecentMatches(accountID string, limit int) ([]scheduler.Match, error)
+	Pause(accountID string) error
+	Resume(accountID string) error
+	PollNow(accountID string) error
+	ReloadRules() error
+	RuleStats() []scheduler.RuleStats
+	Errors() scheduler.Errors
+	History(q store.ArchiveQuery) ([]store.ArchivedMessage, error)
+	Search(accountID, text string, limit int) ([]scheduler.SearchResult, error)
+}
+
+// AccountStatus is the JSON form of an account's polling state
+type AccountStatus struct {
+	ID            string     `json:"id"`
+	Name          string     `json:"name"`
+	Enabled       bool       `json:"enabled"`
+	Running       bool       `json:"running"`
+	Connected     bool       `json:"connected"`
+	Paused        bool       `json:"paused"`
+	Degraded      bool       `json:"degraded"`
+	LastSync      *time.Time `json:"last_sync"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+}
+
+// newAccountStatus converts an account status to its JSON form
+func newAccountStatus(s scheduler.AccountStatus) AccountStatus {
+	out := AccountStatus{
+		ID:        s.ID,
+		Name:      s.Name,
+		Enabled:   s.Enabled,
+		Running:   s.Running,
+		Connected: s.Connected,
+		Paused:    s.Paused,
+		Degraded:  s.Degraded,
+	}
+	if !s.LastSync.IsZero() {
+		out.LastSync = &s.LastSync
+	}
+	if s.LastError != nil {
+		out.LastError = s.LastError.Err.Error()
+		out.LastErrorTime = &s.LastError.Time
+	}
+	return out
+}
+
+// Match is the JSON form of a rule match
+type Match struct {
+	Account   string    `json:"account"`
+	Mailbox   string    `json:"mailbox"`
+	UID       uint32    `json:"uid"`
+	MessageID string    `json:"message_id,omitempty"`
+	Subject   string    `json:"subject"`
+	From      string    `json:"from"`
+	Rule      string    `json:"rule"`
+	Action    string    `json:"action"`
+	Time      time.Time `json:"time"`
+}
+
+// RuleStats is the JSON form of a rule's statistics
+type RuleStats struct {
+	Index     int        `json:"index"`
+	Rule      string     `json:"rule"`
+	Action    string     `json:"action"`
+	Matches   int64      `json:"matches"`
+	Actions   int64      `json:"actions"`
+	Errors    int64      `json:"errors"`
+	LastMatch *time.Time `json:"last_match"`
+}
+
+// ArchivedMessage is the JSON form of an archived message
+type ArchivedMessage struct {
+	Account    string    `json:"account"`
+	Mailbox    string    `json:"mailbox"`
+	UID        uint32    `json:"uid"`
+	MessageID  string    `json:"message_id,omitempty"`
+	Subject    string    `json:"subject"`
+	From       string    `json:"from"`
+	Date       time.Time `json:"date"`
+	Flags      []string  `json:"flags"`
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+// SearchResult is the JSON form of a full-text search match
+type SearchResult struct {
+	Account   string    `json:"account"`
+	Mailbox   string    `json:"mailbox"`
+	UID       uint32    `json:"uid"`
+	MessageID string    `json:"message_id,omitempty"`
+	Subject   string    `json:"subject"`
+	From      string    `json:"from"`
+	Date      time.Time `json:"date"`
+	Snippet   string    `json:"snippet"`
+	Link      string    `json:"link,omitempty"`
+}
+
+// Error is the JSON form of a polling error
+type Error struct {
+	Account string    `json:"account"`
+	Error   string    `json:"error"`
+	Time    time.Time `json:"time"`
+}
+
+// serveErrors lists recent polling errors, newest first, optionally of one
+// account
+func serveErrors(w http.ResponseWriter, r *http.Request, ctl Controller) {
+	account := r.URL.Query().Get("account")
+	if account != "" {
+		if _, err := ctl.Account(account); err != nil {
+			writeControlError(w, err)
+			return
+		}
+	}
+
+	out := []Error{}
+	errs := ctl.Errors()
+	for i := len(errs) - 1; i >= 0; i-- {
+		if account != "" && errs[i].AccountID != account {
+			continue
+		}
+		out = append(out, Error{Account: errs[i].AccountID, Error: errs[i].Err.Error(), Time: errs[i].Time})
+	}
+	writeJSON(w, out)
+}
+
+// serveAccounts handles AccountsPath and the paths below it
+func serveAccounts(w http.ResponseWriter, r *http.Request, ctl Controller) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, AccountsPath), "/")
+	if rest == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		statuses := []AccountStatus{}
+		for _, s := range ctl.Accounts() {
+			statuses = append(statuses, newAccountStatus(s))
+		}
+		writeJSON(w, statuses)
+		return
+	}
+
+	id, verb, _ := strings.Cut(rest, "/")
+	if verb == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s, err := ctl.Account(id)
+		if err != nil {
+			writeControlError(w, err)
+			return
+		}
+		writeJSON(w, newAccountStatus(s))
+		return
+	}
+
+	var action func(string) error
+	switch verb {
+	case "pause":
+		action = ctl.Pause
+	case "resume":
+		action = ctl.Resume
+	case "poll":
+		action = ctl.PollNow
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := action(id); err != nil {
+		writeControlError(w, err)
+		return
+	}
+	log.Printf("API: %s account %s", verb, id)
+	s, err := ctl.Account(id)
+	if err != nil {
+		writeControlError(w, err)
+		return
+	}
+	writeJSON(w, newAccountStatus(s))
+}
+
+// serveMatches lists recent matches, optionally of one account
+func serveMatches(w http.ResponseWriter, r *http.Request, ctl Controller) {
+	limit := defaultMatchLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	matches, err := ctl.RecentMatches(r.URL.Query().Get("account"), limit)
+	if err != nil {
+		writeControlError(w, err)
+		return
+	}
+	out := []Match{}
+	for _, m := range matches {
+		out = append(out, Match(m))
+	}
+	writeJSON(w, out)
+}
+
+// serveHistory searches the message archive by account, sender, subject
+// and date (since and before, as RFC 3339 times)
+func serveHistory(w http.ResponseWriter, r *http.Request, ctl Controller) {
+	v := r.URL.Query()
+	q := store.ArchiveQuery{
+		AccountID: v.Get("account"),
+		From:      v.Get("from"),
+		Subject:   v.Get("subject"),
+		Limit:     defaultHistoryLimit,
+	}
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		q.Limit = n
+	}
+	for name, t := range map[string]*time.Time{"since": &q.Since, "before": &q.Before} {
+		if s := v.Get(name); s != "" {
+			var err error
+			if *t, err = time.Parse(time.RFC3339, s); err != nil {
+				http.Error(w, "invalid "+name, http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	messages, err := ctl.History(q)
+	if err != nil {
+		writeControlError(w, err)
+		return
+	}
+	out := []ArchivedMessage{}
+	for _, m := range messages {
+		out = append(out, ArchivedMessage{
+			Account:    m.AccountID,
+			Mailbox:    m.Email.Mailbox,
+			UID:        m.Email.UID,
+			MessageID:  m.Email.MessageID,
+			Subject:    m.Email.Subject,
+			From:       m.Email.From,
+			Date:       m.Email.Date,
+			Flags:      m.Email.Flags,
+			ArchivedAt: m.ArchivedAt,
+		})
+	}
+	writeJSON(w, out)
+}
+
+// serveSearch searches the text of archived messages for q, optionally of
+// one account
+func serveSearch(w http.ResponseWriter, r *http.Request, ctl Controller) {
+	v := r.URL.Query()
+	text := strings.TrimSpace(v.Get("q"))
+	if text == "" {
+		http.Error(w, "q is required", http.StatusBadRequest)
+		return
+	}
+	limit := defaultHistoryLimit
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	results, err := ctl.Search(v.Get("account"), text, limit)
+	if err != nil {
+		writeControlError(w, err)
+		return
+	}
+	out := []SearchResult{}
+	for _, r := range results {
+		out = append(out, SearchResult{
+			Account:   r.AccountID,
+			Mailbox:   r.Email.Mailbox,
+			UID:       r.Email.UID,
+			MessageID: r.Email.MessageID,
+			Subject:   r.Email.Subject,
+			From:      r.Email.From,
+			Date:      r.Email.Date,
+			Snippet:   r.Snippet,
+			Link:      r.Link,
+		})
+	}
+	writeJSON(w, out)
+}
+
+// serveReload reloads the rules
+func serveReload(w http.ResponseWriter, r *http.Request, ctl Controller) {
+	if err := ctl.ReloadRules(); err != nil {
+		writeControlError(w, err)
+		return
+	}
+	log.Printf("API: reloaded rules")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveRuleStats lists the statistics of every rule
+func serveRuleStats(w http.ResponseWriter, r *http.Request, ctl Controller) {
+	out := []RuleStats{}
+	for _, s := range ctl.RuleStats() {
+		rs := RuleStats{
+			Index:   s.Index,
+			Rule:    s.Rule,
+			Action:  s.Action,
+			Matches: s.Matches,
+			Actions: s.Actions,
+			Errors:  s.Errors,
+		}
+		if !s.LastMatch.IsZero() {
+			lastMatch := s.LastMatch
+			rs.LastMatch = &lastMatch
+		}
+		out = append(out, rs)
+	}
+	writeJSON(w, out)
+}
+
+// writeControlError maps a control error to its HTTP status. Any other
+// error is a config that failed to load.
+func writeControlError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, scheduler.ErrUnknownAccount):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, store.ErrSearchUnavailable):
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+	case errors.Is(err, scheduler.ErrPaused), errors.Is(err, scheduler.ErrNotRunning), errors.Is(err, scheduler.ErrNoConfigFile),
+		errors.Is(err, scheduler.ErrArchiveDisabled):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	}
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
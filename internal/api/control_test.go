@@ -0,0 +1,235 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/scheduler"
+	"github.com/mshan/go-tsk/internal/store"
+)
+
+// fakeControl knows account "primary", which is running, and "idle",
+// which is paused
+type fakeControl struct{}
+
+var matchTime = time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+
+func (fakeControl) Accounts() []scheduler.AccountStatus {
+	primary, _ := fakeControl{}.Account("primary")
+	idle, _ := fakeControl{}.Account("idle")
+	return []scheduler.AccountStatus{primary, idle}
+}
+
+func (fakeControl) Account(accountID string) (scheduler.AccountStatus, error) {
+	switch accountID {
+	case "primary":
+		return scheduler.AccountStatus{ID: "primary", Enabled: true, Running: true, Connected: true, LastSync: matchTime}, nil
+	case "idle":
+		return scheduler.AccountStatus{ID: "idle", Enabled: true, Running: true, Paused: true,
+			LastError: &scheduler.AccountError{AccountID: "idle", Err: errors.New("auth failed"), Time: matchTime}}, nil
+	}
+	return scheduler.AccountStatus{}, scheduler.ErrUnknownAccount
+}
+
+func (c fakeControl) RecentMatches(accountID string, limit int) ([]scheduler.Match, error) {
+	if _, err := c.Account(accountID); accountID != "" && err != nil {
+		return nil, err
+	}
+	matches := []scheduler.Match{
+		{Account: "primary", Mailbox: "INBOX", UID: 2, Subject: "Outage", Action: "notify", Time: matchTime},
+		{Account: "primary", Mailbox: "INBOX", UID: 1, Subject: "Invoice", Action: "label", Time: matchTime},
+	}
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+func (c fakeControl) Pause(accountID string) error {
+	_, err := c.Account(accountID)
+	return err
+}
+
+func (c fakeControl) Resume(accountID string) error {
+	_, err := c.Account(accountID)
+	return err
+}
+
+func (c fakeControl) PollNow(accountID string) error {
+	s, err := c.Account(accountID)
+	if err == nil && s.Paused {
+		err = scheduler.ErrPaused
+	}
+	return err
+}
+
+func (fakeControl) ReloadRules() error { return nil }
+
+func (fakeControl) RuleStats() []scheduler.RuleStats {
+	return []scheduler.RuleStats{
+		{Index: 0, Rule: "subject contains Invoice", Action: "label", Matches: 2, Actions: 2, LastMatch: matchTime},
+		{Index: 1, Rule: "subject contains Outage", Action: "notify"},
+	}
+}
+
+func (fakeControl) Errors() scheduler.Errors {
+	return scheduler.Errors{
+		{AccountID: "idle", Err: errors.New("connection reset"), Time: matchTime.Add(-time.Hour)},
+		{AccountID: "idle", Err: errors.New("auth failed"), Time: matchTime},
+	}
+}
+
+// History archives two messages of "primary"; "idle" has the archive
+// disabled
+func (c fakeControl) History(q store.ArchiveQuery) ([]store.ArchivedMessage, error) {
+	switch q.AccountID {
+	case "", "primary":
+	case "idle":
+		return nil, scheduler.ErrArchiveDisabled
+	default:
+		return nil, scheduler.ErrUnknownAccount
+	}
+	messages := []store.ArchivedMessage{
+		{AccountID: "primary", Key: "mid:<2@example.com>", Email: email.Email{UID: 2, Subject: "Outage", From: "ops@example.com", Date: matchTime}},
+		{AccountID: "primary", Key: "mid:<1@example.com>", Email: email.Email{UID: 1, Subject: "Invoice", From: "billing@example.com", Date: matchTime.Add(-time.Hour)}},
+	}
+	var out []store.ArchivedMessage
+	for _, m := range messages {
+		if strings.Contains(m.Email.From, q.From) && (q.Since.IsZero() || !m.Email.Date.Before(q.Since)) && len(out) < q.Limit {
+			out = append(out, m)
+		}
+	}
+	return out, nil
+}
+
+// Search finds the archived invoice of "primary"; "idle" was built without
+// FTS5
+func (c fakeControl) Search(accountID, text string, limit int) ([]scheduler.SearchResult, error) {
+	messages, err := c.History(store.ArchiveQuery{AccountID: accountID, Limit: limit})
+	if errors.Is(err, scheduler.ErrArchiveDisabled) {
+		return nil, store.ErrSearchUnavailable
+	} else if err != nil {
+		return nil, err
+	}
+	var out []scheduler.SearchResult
+	for _, m := range messages {
+		if strings.Contains(strings.ToLower(m.Email.Subject), strings.ToLower(text)) {
+			out = append(out, scheduler.SearchResult{SearchResult: store.SearchResult{ArchivedMessage: m, Snippet: "[" + text + "]"}})
+		}
+	}
+	return out, nil
+}
+
+func TestControl(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		count  int // Length of a JSON array response; -1 if not an array
+	}{
+		{"accounts", http.MethodGet, AccountsPath, http.StatusOK, 2},
+		{"account", http.MethodGet, AccountsPath + "/primary", http.StatusOK, -1},
+		{"unknown account", http.MethodGet, AccountsPath + "/nosuch", http.StatusNotFound, -1},
+		{"pause", http.MethodPost, AccountsPath + "/primary/pause", http.StatusOK, -1},
+		{"pause with get", http.MethodGet, AccountsPath + "/primary/pause", http.StatusMethodNotAllowed, -1},
+		{"resume", http.MethodPost, AccountsPath + "/idle/resume", http.StatusOK, -1},
+		{"poll", http.MethodPost, AccountsPath + "/primary/poll", http.StatusOK, -1},
+		{"poll paused", http.MethodPost, AccountsPath + "/idle/poll", http.StatusConflict, -1},
+		{"unknown verb", http.MethodPost, AccountsPath + "/primary/delete", http.StatusNotFound, -1},
+		{"matches", http.MethodGet, MatchesPath, http.StatusOK, 2},
+		{"matches limited", http.MethodGet, MatchesPath + "?account=primary&limit=1", http.StatusOK, 1},
+		{"matches bad limit", http.MethodGet, MatchesPath + "?limit=none", http.StatusBadRequest, -1},
+		{"matches unknown account", http.MethodGet, MatchesPath + "?account=nosuch", http.StatusNotFound, -1},
+		{"reload", http.MethodPost, ReloadPath, http.StatusNoContent, -1},
+		{"reload with get", http.MethodGet, ReloadPath, http.StatusMethodNotAllowed, -1},
+		{"rule stats", http.MethodGet, RuleStatsPath, http.StatusOK, 2},
+		{"rule stats with post", http.MethodPost, RuleStatsPath, http.StatusMethodNotAllowed, -1},
+		{"errors", http.MethodGet, ErrorsPath, http.StatusOK, 2},
+		{"errors of account", http.MethodGet, ErrorsPath + "?account=primary", http.StatusOK, 0},
+		{"errors of unknown account", http.MethodGet, ErrorsPath + "?account=nosuch", http.StatusNotFound, -1},
+		{"history", http.MethodGet, HistoryPath, http.StatusOK, 2},
+		{"history by sender", http.MethodGet, HistoryPath + "?account=primary&from=billing", http.StatusOK, 1},
+		{"history since", http.MethodGet, HistoryPath + "?since=2024-03-01T09:00:00Z", http.StatusOK, 1},
+		{"history limited", http.MethodGet, HistoryPath + "?limit=1", http.StatusOK, 1},
+		{"history bad since", http.MethodGet, HistoryPath + "?since=yesterday", http.StatusBadRequest, -1},
+		{"history disabled", http.MethodGet, HistoryPath + "?account=idle", http.StatusConflict, -1},
+		{"history of unknown account", http.MethodGet, HistoryPath + "?account=nosuch", http.StatusNotFound, -1},
+		{"search", http.MethodGet, SearchPath + "?q=invoice", http.StatusOK, 1},
+		{"search without match", http.MethodGet, SearchPath + "?account=primary&q=refund", http.StatusOK, 0},
+		{"search without text", http.MethodGet, SearchPath + "?q=+", http.StatusBadRequest, -1},
+		{"search unavailable", http.MethodGet, SearchPath + "?account=idle&q=invoice", http.StatusNotImplemented, -1},
+	}
+
+	h := NewHandler(fakeSource{}, "secret")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Authorization", "Bearer secret")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d; want %d (body %q)", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.count < 0 {
+				return
+			}
+			var items []json.RawMessage
+			if err := json.Unmarshal(rec.Body.Bytes(), &items); err != nil || len(items) != tt.count {
+				t.Errorf("response %s has %d items, %v; want %d", rec.Body.String(), len(items), err, tt.count)
+			}
+		})
+	}
+}
+
+func TestAccountStatusJSON(t *testing.T) {
+	s, _ := fakeControl{}.Account("idle")
+	got := newAccountStatus(s)
+	if got.LastSync != nil {
+		t.Errorf("LastSync = %v; want nil before the first sync", got.LastSync)
+	}
+	if got.LastError != "auth failed" || got.LastErrorTime == nil || !got.Paused {
+		t.Errorf("newAccountStatus() = %+v", got)
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	h := NewHandler(fakeSource{}, "secret")
+
+	tests := []struct {
+		path   string
+		status int
+		want   string // Substring of the body
+	}{
+		{"/", http.StatusOK, "<title>go-tsk</title>"},
+		{"/app.js", http.StatusOK, "/api/v1/accounts"},
+		{"/style.css", http.StatusOK, "table"},
+		{"/nosuch.html", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			// The static files are served without the token
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d; want %d", rec.Code, tt.status)
+			}
+			if !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("body does not contain %q", tt.want)
+			}
+		})
+	}
+
+	// The API behind it still is not
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, AccountsPath, nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("API status without token = %d; want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
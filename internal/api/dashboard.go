@@ -0,0 +1,32 @@
+package api
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// dashboardFiles are the dashboard's static assets. The page asks for the
+// API token and calls the API from the browser, so it needs no server-side
+// rendering.
+//
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// dashboardHandler serves the dashboard at the root path
+func dashboardHandler() http.Handler {
+	files, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		panic(err) // the embedded directory always exists
+	}
+	static := http.FileServer(http.FS(files))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Security-Policy", "default-src 'self'")
+		w.Header().Set("X-Frame-Options", "DENY")
+		static.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,238 @@
+// go-tsk dashboard: reads the daemon API with the token the user enters and
+// refreshes every few seconds.
+"use strict";
+
+const refreshInterval = 5000;
+const matchLimit = 50;
+const historyLimit = 50;
+const tokenKey = "go-tsk-token";
+
+let timer = null;
+
+function token() {
+  return sessionStorage.getItem(tokenKey);
+}
+
+async function api(method, path) {
+  const resp = await fetch(path, {
+    method: method,
+    headers: { Authorization: "Bearer " + token() },
+  });
+  if (resp.status === 401) {
+    sessionStorage.removeItem(tokenKey);
+    showLogin("The token was rejected.");
+    throw new Error("unauthorized");
+  }
+  if (!resp.ok) {
+    throw new Error((await resp.text()).trim() || resp.statusText);
+  }
+  return resp.status === 204 ? null : resp.json();
+}
+
+function formatTime(value) {
+  return value ? new Date(value).toLocaleString() : "never";
+}
+
+function cell(text, className) {
+  const td = document.createElement("td");
+  td.textContent = text;
+  if (className) {
+    td.className = className;
+  }
+  return td;
+}
+
+function fillTable(id, rows, columns, empty) {
+  const body = document.getElementById(id);
+  body.replaceChildren();
+  if (rows.length === 0) {
+    const tr = document.createElement("tr");
+    const td = cell(empty, "empty");
+    td.colSpan = columns;
+    tr.append(td);
+    body.append(tr);
+    return;
+  }
+  for (const row of rows) {
+    body.append(row);
+  }
+}
+
+// health summarises an account's state as a label and CSS class
+function health(account) {
+  if (!account.enabled) {
+    return ["disabled", "idle"];
+  }
+  if (account.paused) {
+    return ["paused", "paused"];
+  }
+  if (!account.running) {
+    return ["stopped", "stopped"];
+  }
+  if (account.last_error_time && (!account.last_sync || account.last_error_time > account.last_sync)) {
+    return ["failing", "failing"];
+  }
+  if (!account.connected) {
+    return ["connecting", "idle"];
+  }
+  return ["healthy", "ok"];
+}
+
+function actionButton(label, account, verb) {
+  const button = document.createElement("button");
+  button.type = "button";
+  button.textContent = label;
+  button.setAttribute("aria-label", label + " " + account.id);
+  button.addEventListener("click", async () => {
+    button.disabled = true;
+    try {
+      await api("POST", "/api/v1/accounts/" + encodeURIComponent(account.id) + "/" + verb);
+      await refresh();
+    } catch (err) {
+      setStatus(label + " failed for " + account.id + ": " + err.message);
+    } finally {
+      button.disabled = false;
+    }
+  });
+  return button;
+}
+
+function accountRow(account) {
+  const tr = document.createElement("tr");
+  const [label, className] = health(account);
+  tr.append(
+    cell(account.name ? account.name + " (" + account.id + ")" : account.id),
+    cell(label, "health " + className),
+    cell(formatTime(account.last_sync)),
+    cell(account.last_error ? account.last_error + " (" + formatTime(account.last_error_time) + ")" : ""),
+  );
+
+  const actions = document.createElement("td");
+  if (account.enabled) {
+    if (!account.paused) {
+      actions.append(actionButton("Poll now", account, "poll"));
+    }
+    actions.append(account.paused ? actionButton("Resume", account, "resume") : actionButton("Pause", account, "pause"));
+  }
+  tr.append(actions);
+  return tr;
+}
+
+function matchRow(match) {
+  const tr = document.createElement("tr");
+  tr.append(
+    cell(formatTime(match.time)),
+    cell(match.account),
+    cell(match.subject),
+    cell(match.from),
+    cell(match.rule),
+    cell(match.action),
+  );
+  return tr;
+}
+
+function historyRow(message) {
+  const tr = document.createElement("tr");
+  tr.append(
+    cell(formatTime(message.date)),
+    cell(message.account),
+    cell(message.mailbox),
+    cell(message.subject),
+    cell(message.from),
+  );
+  return tr;
+}
+
+// searchHistory lists the archived messages matching the search form. It
+// runs on demand rather than with every refresh, as the archive can be
+// large.
+async function searchHistory() {
+  const params = new URLSearchParams({ limit: historyLimit });
+  const from = document.getElementById("history-from").value.trim();
+  const subject = document.getElementById("history-subject").value.trim();
+  if (from) {
+    params.set("from", from);
+  }
+  if (subject) {
+    params.set("subject", subject);
+  }
+  try {
+    const messages = await api("GET", "/api/v1/history?" + params);
+    fillTable("history", messages.map(historyRow), 5, "No archived messages");
+  } catch (err) {
+    if (err.message !== "unauthorized") {
+      fillTable("history", [], 5, err.message);
+    }
+  }
+}
+
+function errorRow(error) {
+  const tr = document.createElement("tr");
+  tr.append(cell(formatTime(error.time)), cell(error.account), cell(error.error, "error"));
+  return tr;
+}
+
+function setStatus(text) {
+  document.getElementById("status").textContent = text;
+}
+
+async function refresh() {
+  try {
+    const [accounts, matches, errors] = await Promise.all([
+      api("GET", "/api/v1/accounts"),
+      api("GET", "/api/v1/matches?limit=" + matchLimit),
+      api("GET", "/api/v1/errors"),
+    ]);
+    fillTable("accounts", accounts.map(accountRow), 5, "No accounts configured");
+    fillTable("matches", matches.map(matchRow), 6, "No matches yet");
+    fillTable("errors", errors.map(errorRow), 3, "No errors");
+    document.getElementById("updated").textContent = "Updated " + new Date().toLocaleTimeString();
+    setStatus("");
+  } catch (err) {
+    if (err.message !== "unauthorized") {
+      setStatus("Failed to refresh: " + err.message);
+    }
+  }
+}
+
+function showLogin(message) {
+  clearInterval(timer);
+  document.getElementById("dashboard").hidden = true;
+  document.getElementById("logout").hidden = true;
+  document.getElementById("login").hidden = false;
+  document.getElementById("login-error").textContent = message || "";
+  document.getElementById("token").focus();
+}
+
+function showDashboard() {
+  document.getElementById("login").hidden = true;
+  document.getElementById("dashboard").hidden = false;
+  document.getElementById("logout").hidden = false;
+  refresh();
+  searchHistory();
+  clearInterval(timer);
+  timer = setInterval(refresh, refreshInterval);
+}
+
+document.getElementById("login").addEventListener("submit", (event) => {
+  event.preventDefault();
+  sessionStorage.setItem(tokenKey, document.getElementById("token").value);
+  document.getElementById("token").value = "";
+  showDashboard();
+});
+
+document.getElementById("history-search").addEventListener("submit", (event) => {
+  event.preventDefault();
+  searchHistory();
+});
+
+document.getElementById("logout").addEventListener("click", () => {
+  sessionStorage.removeItem(tokenKey);
+  showLogin();
+});
+
+if (token()) {
+  showDashboard();
+} else {
+  showLogin();
+}
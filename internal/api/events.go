@@ -0,0 +1,82 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/events"
+)
+
+// EventsPath streams the daemon's events as server-sent events
+const EventsPath = "/api/v1/events"
+
+// Event stream settings
+const (
+	eventBuffer       = 64               // Events buffered per stream; slower clients miss events
+	keepaliveInterval = 30 * time.Second // Comment lines keep proxies from closing idle streams
+)
+
+// EventSource streams the daemon's events; *scheduler.EmailPoller
+// implements it
+type EventSource interface {
+	Subscribe(buffer int) (<-chan events.Event, func())
+}
+
+// serveEvents streams events, optionally narrowed by the account and type
+// query parameters, until the client disconnects. Each event is sent with
+// its ID, its type as the SSE event name and its JSON form as data.
+func serveEvents(w http.ResponseWriter, r *http.Request, src Source) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	filter := events.Filter{Account: r.URL.Query().Get("account"), Types: r.URL.Query()["type"]}
+	if filter.Account != "" {
+		if _, err := src.Account(filter.Account); err != nil {
+			writeControlError(w, err)
+			return
+		}
+	}
+
+	ch, cancel := src.Subscribe(eventBuffer)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			if !filter.Match(ev) {
+				continue
+			}
+			data, err := json.Marshal(ev)
+			if err != nil {
+				log.Printf("API: failed to encode event %s: %v", ev.ID, err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/events"
+)
+
+func TestEventStream(t *testing.T) {
+	emitter := &events.Emitter{}
+	srv := httptest.NewServer(NewHandler(fakeSource{Emitter: emitter}, "secret"))
+	defer srv.Close()
+
+	get := func(query string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+EventsPath+query, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", query, err)
+		}
+		return resp
+	}
+
+	if resp := get("?account=nosuch"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("status for unknown account = %d; want %d", resp.StatusCode, http.StatusNotFound)
+	}
+
+	resp := get("?account=primary&type=" + events.TypeEmailFetched + "&type=" + events.TypePollFailed)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status = %d, Content-Type = %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	// The handler subscribed before sending the headers, so everything
+	// broadcast now reaches it; only the wanted events come through
+	emitter.Broadcast(events.NewEvent(events.TypeRuleMatched, "primary", "", nil))
+	emitter.Broadcast(events.NewEvent(events.TypeEmailFetched, "idle", "", nil))
+	want := events.NewEvent(events.TypeEmailFetched, "primary", "mid:<a@example.com>", events.MessageData{Subject: "Invoice"})
+	emitter.Broadcast(want)
+	emitter.Emit(context.Background(), events.NewEvent(events.TypePollFailed, "primary", "", events.ErrorData{Error: "timeout"}))
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	var got []string
+	timeout := time.After(5 * time.Second)
+	for len(got) < 8 {
+		select {
+		case line := <-lines:
+			got = append(got, line)
+		case <-timeout:
+			t.Fatalf("stream stalled after %q", got)
+		}
+	}
+
+	wantLines := []string{
+		"id: " + want.ID,
+		"event: " + events.TypeEmailFetched,
+		`data: {"id":"` + want.ID + `"`,
+		"",
+		"id: ",
+		"event: " + events.TypePollFailed,
+		`data: {"id":`,
+		"",
+	}
+	for i, prefix := range wantLines {
+		if !strings.HasPrefix(got[i], prefix) || (prefix == "" && got[i] != "") {
+			t.Errorf("line %d = %q; want prefix %q", i, got[i], prefix)
+		}
+	}
+	if !strings.Contains(got[2], `"subject":"Invoice"`) || !strings.Contains(got[6], `"error":"timeout"`) {
+		t.Errorf("data lines = %q, %q", got[2], got[6])
+	}
+}
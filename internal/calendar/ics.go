@@ -0,0 +1,237 @@
+// Package calendar reads the iCalendar (RFC 5545) invites mailed by
+// calendar applications.
+package calendar
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Methods of an invite (RFC 5546) that decide what happens to its events
+const (
+	MethodPublish = "PUBLISH"
+	MethodRequest = "REQUEST"
+	MethodCancel  = "CANCEL"
+)
+
+// ErrNoEvents is returned by Parse when the calendar holds no VEVENT
+var ErrNoEvents = errors.New("calendar has no events")
+
+// Calendar is a parsed iCalendar object
+type Calendar struct {
+	Method string // The invite's METHOD, upper case; empty for a plain .ics file
+	Events []Event
+	raw    []byte
+}
+
+// Event is one VEVENT of a calendar. A recurring event's exceptions are
+// further events with the same UID.
+type Event struct {
+	UID       string
+	Sequence  int
+	Summary   string
+	Location  string
+	Organizer string // Organizer address, without its mailto: scheme
+	Start     time.Time
+	End       time.Time // Zero if the event has no DTEND
+	AllDay    bool
+}
+
+// UID returns the UID of the calendar's events
+func (c *Calendar) UID() string {
+	return c.Events[0].UID
+}
+
+// Object returns the calendar as stored on a calendar server: the invite
+// without its METHOD, which RFC 4791 does not allow in stored objects
+func (c *Calendar) Object() []byte {
+	var b bytes.Buffer
+	for _, line := range splitLines(c.raw) {
+		upper := strings.ToUpper(line)
+		if strings.HasPrefix(upper, "METHOD:") || strings.HasPrefix(upper, "METHOD;") {
+			continue
+		}
+		b.WriteString(line)
+		b.WriteString("\r\n")
+	}
+	return b.Bytes()
+}
+
+// Filename returns the name an event with the given UID is stored under.
+// UIDs often contain characters that are awkward in URLs and file names, so
+// the name is derived from a hash of the UID.
+func Filename(uid string) string {
+	sum := sha256.Sum256([]byte(uid))
+	return hex.EncodeToString(sum[:16]) + ".ics"
+}
+
+// Parse reads an iCalendar object. It fails if the object is malformed, has
+// no events, or has events without a UID or with different UIDs.
+func Parse(data []byte) (*Calendar, error) {
+	c := &Calendar{raw: data}
+	var ev *Event
+	var depth []string
+	for _, line := range unfold(data) {
+		name, params, value := cutProperty(line)
+		name = strings.ToUpper(name)
+		switch name {
+		case "":
+			continue
+		case "BEGIN":
+			depth = append(depth, strings.ToUpper(value))
+			if strings.EqualFold(value, "VEVENT") {
+				ev = &Event{}
+			}
+			continue
+		case "END":
+			if len(depth) == 0 || !strings.EqualFold(depth[len(depth)-1], value) {
+				return nil, fmt.Errorf("unexpected END:%s", value)
+			}
+			depth = depth[:len(depth)-1]
+			if strings.EqualFold(value, "VEVENT") {
+				c.Events = append(c.Events, *ev)
+				ev = nil
+			}
+			continue
+		}
+		if len(depth) == 0 {
+			return nil, fmt.Errorf("property %s outside VCALENDAR", name)
+		}
+
+		switch current := depth[len(depth)-1]; {
+		case current == "VCALENDAR" && name == "METHOD":
+			c.Method = strings.ToUpper(value)
+		case current == "VEVENT" && ev != nil:
+			if err := ev.set(name, params, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(depth) != 0 {
+		return nil, fmt.Errorf("unterminated %s", depth[len(depth)-1])
+	}
+	if len(c.Events) == 0 {
+		return nil, ErrNoEvents
+	}
+	for _, e := range c.Events {
+		if e.UID == "" {
+			return nil, fmt.Errorf("event %q has no UID", e.Summary)
+		}
+		if e.UID != c.Events[0].UID {
+			return nil, fmt.Errorf("calendar holds events of different UIDs")
+		}
+	}
+	return c, nil
+}
+
+// set applies one property of a VEVENT
+func (e *Event) set(name string, params map[string]string, value string) error {
+	var err error
+	switch name {
+	case "UID":
+		e.UID = value
+	case "SEQUENCE":
+		if e.Sequence, err = strconv.Atoi(value); err != nil {
+			return fmt.Errorf("invalid SEQUENCE %q", value)
+		}
+	case "SUMMARY":
+		e.Summary = unescape(value)
+	case "LOCATION":
+		e.Location = unescape(value)
+	case "ORGANIZER":
+		if len(value) > len("mailto:") && strings.EqualFold(value[:len("mailto:")], "mailto:") {
+			value = value[len("mailto:"):]
+		}
+		e.Organizer = value
+	case "DTSTART":
+		if e.Start, e.AllDay, err = parseTime(params, value); err != nil {
+			return fmt.Errorf("invalid DTSTART: %w", err)
+		}
+	case "DTEND":
+		if e.End, _, err = parseTime(params, value); err != nil {
+			return fmt.Errorf("invalid DTEND: %w", err)
+		}
+	}
+	return nil
+}
+
+// parseTime reads a DATE or DATE-TIME value. Times in an unknown TZID,
+// such as a Windows zone name, and floating times are taken as local.
+func parseTime(params map[string]string, value string) (t time.Time, allDay bool, err error) {
+	if params["VALUE"] == "DATE" || len(value) == len("20060102") {
+		t, err = time.ParseInLocation("20060102", value, time.Local)
+		return t, true, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err = time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+	loc := time.Local
+	if tzid := params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(strings.Trim(tzid, "/")); err == nil {
+			loc = l
+		}
+	}
+	t, err = time.ParseInLocation("20060102T150405", value, loc)
+	return t, false, err
+}
+
+// unfold splits an iCalendar object into its logical lines, joining the
+// continuation lines that begin with a space or tab
+func unfold(data []byte) []string {
+	var lines []string
+	for _, line := range splitLines(data) {
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// splitLines splits data at CRLF or LF line ends
+func splitLines(data []byte) []string {
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	return strings.Split(strings.TrimRight(text, "\n"), "\n")
+}
+
+// cutProperty splits a content line into its name, parameters and value.
+// Parameter names are upper case and quoted parameter values are unquoted.
+func cutProperty(line string) (name string, params map[string]string, value string) {
+	// The value starts at the first colon outside a quoted parameter value
+	quoted := false
+	end := -1
+	for i, r := range line {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == ':' && !quoted {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return "", nil, ""
+	}
+	value = line[end+1:]
+	parts := strings.Split(line[:end], ";")
+	name = strings.TrimSpace(parts[0])
+	params = make(map[string]string)
+	for _, p := range parts[1:] {
+		if k, v, ok := strings.Cut(p, "="); ok {
+			params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+		}
+	}
+	return name, params, value
+}
+
+// unescape decodes the backslash escapes of a TEXT value
+func unescape(s string) string {
+	return strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(s)
+}
@@ -0,0 +1,96 @@
+package calendar
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const invite = "BEGIN:VCALENDAR\r\n" +
+	"PRODID:-//Example//Calendar//EN\r\n" +
+	"VERSION:2.0\r\n" +
+	"METHOD:REQUEST\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:abc123@example.com\r\n" +
+	"SEQUENCE:2\r\n" +
+	"DTSTART;TZID=Europe/Berlin:20240301T090000\r\n" +
+	"DTEND;TZID=Europe/Berlin:20240301T093000\r\n" +
+	"SUMMARY:Quarterly planning\\, part 2 with a summary long enough to be fol\r\n" +
+	" ded\r\n" +
+	"LOCATION:Room 4\\; 2nd floor\r\n" +
+	"ORGANIZER;CN=\"Boss: Big\":mailto:boss@example.com\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"TRIGGER:-PT15M\r\n" +
+	"SUMMARY:Alarm\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParse(t *testing.T) {
+	cal, err := Parse([]byte(invite))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if cal.Method != MethodRequest || len(cal.Events) != 1 {
+		t.Fatalf("Parse() = %+v", cal)
+	}
+	e := cal.Events[0]
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone database")
+	}
+	want := Event{
+		UID:       "abc123@example.com",
+		Sequence:  2,
+		Summary:   "Quarterly planning, part 2 with a summary long enough to be folded",
+		Location:  "Room 4; 2nd floor",
+		Organizer: "boss@example.com",
+		Start:     time.Date(2024, 3, 1, 9, 0, 0, 0, berlin),
+		End:       time.Date(2024, 3, 1, 9, 30, 0, 0, berlin),
+	}
+	if e.UID != want.UID || e.Sequence != want.Sequence || e.Summary != want.Summary || e.Location != want.Location ||
+		e.Organizer != want.Organizer || !e.Start.Equal(want.Start) || !e.End.Equal(want.End) || e.AllDay {
+		t.Errorf("event = %+v; want %+v", e, want)
+	}
+
+	object := string(cal.Object())
+	if strings.Contains(object, "METHOD") || !strings.Contains(object, "UID:abc123@example.com\r\n") {
+		t.Errorf("Object() = %q; want the invite without METHOD", object)
+	}
+}
+
+func TestParseAllDay(t *testing.T) {
+	cal, err := Parse([]byte("BEGIN:VCALENDAR\nBEGIN:VEVENT\nUID:1\nDTSTART;VALUE=DATE:20240301\nDTSTAMP:20240201T120000Z\nEND:VEVENT\nEND:VCALENDAR\n"))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if e := cal.Events[0]; !e.AllDay || e.Start.Day() != 1 || cal.Method != "" {
+		t.Errorf("Parse() = %+v", cal)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"no events", "BEGIN:VCALENDAR\r\nMETHOD:REQUEST\r\nEND:VCALENDAR\r\n"},
+		{"no UID", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nSUMMARY:Sync\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"},
+		{"different UIDs", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:1\r\nEND:VEVENT\r\nBEGIN:VEVENT\r\nUID:2\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"},
+		{"mismatched END", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:1\r\nEND:VCALENDAR\r\n"},
+		{"unterminated", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:1\r\nEND:VEVENT\r\n"},
+		{"bad start", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:1\r\nDTSTART:tomorrow\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"},
+		{"not a calendar", "Hello"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse([]byte(tt.data)); err == nil {
+				t.Error("Parse() succeeded")
+			}
+		})
+	}
+	if _, err := Parse([]byte("BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n")); !errors.Is(err, ErrNoEvents) {
+		t.Errorf("Parse() of an empty calendar = %v; want ErrNoEvents", err)
+	}
+}
@@ -1,35 +1,672 @@
 package config
 
-import "time"
+import (
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"time"
+)
 
 // Config holds the application configuration
 type Config struct {
 	EmailAccounts []EmailAccount
 	Poll          PollConfig
+	Notify        NotifyConfig
+	Events        EventsConfig
+	Loop          LoopConfig
+	Integrations  IntegrationsConfig
+	Metrics       MetricsConfig
+	Tracing       TracingConfig
+	Log           LogConfig
+	Errors        ErrorReportingConfig
+	Secrets       SecretsConfig
+	Proxy         ProxyConfig
+	API           APIConfig
+	Cleanup       []CleanupJob
+	Storage       StorageConfig
+	HA            HAConfig
+	Plugins       []PluginConfig
+	AddressLists  []AddressList
+}
+
+// AddressList is a named list of addresses that FromInList and
+// FromNotInList conditions check senders against. Its entries are
+// addresses, or "@example.com" for everyone at a domain, gathered from any
+// of its sources.
+type AddressList struct {
+	Name      string        // Name rules refer to the list by
+	Addresses []string      // Inline entries
+	File      string        // File of further entries, one per line; "#" starts a comment
+	CardDAV   AddressBook   // Address book whose contacts' addresses are entries
+	Refresh   time.Duration // How often File and CardDAV entries are reloaded; 0 uses 1h
+}
+
+// AddressBook is a CardDAV address book (RFC 6352), such as Google
+// Contacts at https://www.googleapis.com/carddav/v1/principals/<address>/lists/default/
+type AddressBook struct {
+	URL      string // Address book collection URL; empty means none
+	Username string // Basic auth user name
+	Password string // Basic auth password or app password
+	Token    string // OAuth2 access token, sent instead of basic auth
+}
+
+// PluginConfig loads a WebAssembly plugin, which rules use as an action
+// under its name or as a match through Rule.Plugin
+type PluginConfig struct {
+	Name     string        // Name rules refer to the plugin by; must not be a built-in action
+	Path     string        // .wasm file
+	Timeout  time.Duration // Longest a call may run; 0 uses 1s
+	MemoryMB int           // Memory the plugin may use, in MiB; 0 uses 16
 }
 
 // EmailAccount represents a single email account configuration
 type EmailAccount struct {
 	ID           string // Unique identifier for the account
 	Name         string // Friendly name for the account
-	Provider     string // "gmail" for now
+	Provider     string // "gmail" (IMAP), "gmailapi", "jmap", "pop3", "maildir", "mbox", "fake" or a preset such as "yahoo"
+	Address      string // Email address used to authenticate
 	ClientID     string // OAuth2 client ID
 	ClientSecret string // OAuth2 client secret
 	Token        string // OAuth2 access token
 	Enabled      bool   // Whether this account should be polled
+	FetchBodies  bool   // Whether full message bodies are fetched and decoded
+	SessionURL   string // JMAP session resource, e.g. https://api.fastmail.com/jmap/session
+	Server       string // host:port of the server, for providers without a fixed one (pop3); overrides a preset's
+	Password     string // Password, for providers that log in with one (pop3, app passwords of presets)
+	Path         string // Maildir directory or mbox file, for the maildir and mbox providers
+	Proxy        string // Proxy of the account's mail connections, overriding Config.Proxy; "direct" uses none
+
+	// OutgoingHeaders are added to every message actions send for this
+	// account, e.g. {"X-Ticket-Source": "go-tsk"}
+	OutgoingHeaders map[string]string
+
+	// Mailboxes lists the mailboxes to poll, as names or path.Match
+	// patterns such as "Lists/*"; empty polls INBOX only
+	Mailboxes []string
+
+	// Limits keeps the account under the server's throttling thresholds
+	Limits AccountLimits
+
+	// Fetch tunes how new mail is downloaded over IMAP
+	Fetch FetchConfig
+
+	// TLS tunes how IMAP and POP3 connections are secured
+	TLS TLSConfig
+
+	// Push has Gmail announce new mail through Cloud Pub/Sub; only for
+	// the gmailapi provider
+	Push GmailPushConfig
+}
+
+// GmailPushConfig makes Gmail publish new-mail notifications to a Pub/Sub
+// topic, which go-tsk pulls from a subscription to poll at once. Polling
+// goes on at FallbackInterval in case notifications are lost.
+type GmailPushConfig struct {
+	Topic            string        // projects/PROJECT/topics/TOPIC, publishable by Gmail
+	Subscription     string        // projects/PROJECT/subscriptions/SUBSCRIPTION of Topic
+	FallbackInterval time.Duration // Poll interval while notifications arrive; 0 uses 15m
+}
+
+// AccountLimits throttles the IMAP traffic of one account. Zero values
+// mean no limit.
+type AccountLimits struct {
+	MaxConcurrent int     // IMAP commands in flight at once; more wait for a free slot
+	MaxMessages   int     // Messages processed per mailbox per poll; the rest wait for the next poll
+	StoreRate     float64 // Flag changes and deletions (STORE/EXPUNGE) per second
+	StoreBurst    int     // Flag changes allowed back to back before StoreRate applies; 0 uses 1
+}
+
+// FetchConfig tunes how an IMAP account downloads new mail. Zero values use
+// the defaults.
+type FetchConfig struct {
+	Window      int // UIDs per FETCH command; 0 uses 500
+	Workers     int // Goroutines decoding fetched messages; 0 uses 4
+	MaxMessages int // Messages fetched per mailbox per poll, the rest on the next poll; 0 uses 10000
+}
+
+// TLSConfig tunes the TLS of an account's IMAP and POP3 connections. The
+// zero value verifies the server against the system's root CAs.
+type TLSConfig struct {
+	CAFile             string // PEM file of the root CAs to trust instead of the system's
+	CertFile           string // PEM client certificate, for servers that ask for one; needs KeyFile
+	KeyFile            string // PEM private key of CertFile
+	MinVersion         string // Oldest TLS version accepted: "1.0", "1.1", "1.2" or "1.3"; empty uses 1.2
+	InsecureSkipVerify bool   // Accept any server certificate; only for lab servers
+	StartTLS           bool   // Connect in the clear and upgrade with STARTTLS; implied on port 143 (IMAP)
+}
+
+// tlsVersions maps TLSConfig.MinVersion to the crypto/tls constants
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Version returns the crypto/tls constant of MinVersion, 1.2 if unset
+func (t TLSConfig) Version() (uint16, error) {
+	if t.MinVersion == "" {
+		return tls.VersionTLS12, nil
+	}
+	v, ok := tlsVersions[t.MinVersion]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q; use 1.0, 1.1, 1.2 or 1.3", t.MinVersion)
+	}
+	return v, nil
 }
 
 // PollConfig holds polling-related configuration
 type PollConfig struct {
 	Interval time.Duration
+	Backoff  BackoffConfig
 	Rules    []Rule
+
+	// InitialSyncLimit is the largest mailbox processed in full the first
+	// time an account is polled. Larger mailboxes start from now and must
+	// be processed with the backfill command. 0 always starts from now.
+	InitialSyncLimit int
+
+	// RuleBudget limits how long a single rule may take on one message
+	RuleBudget RuleBudgetConfig
+
+	// Watchdog logs polls still running after this long; 0 uses five
+	// times the interval
+	Watchdog time.Duration
+
+	// Adaptive adjusts each account's interval to its mail volume
+	Adaptive AdaptiveConfig
+
+	// ScriptLimits bound each load and call of a rule's script
+	ScriptLimits ScriptLimits
+
+	// Connections manages each account's pool of provider connections
+	Connections ConnectionConfig
+
+	// ConnectTimeout bounds getting a connection for a poll, including
+	// waiting for a free one; 0 uses 1m
+	ConnectTimeout time.Duration
+	// FetchTimeout bounds fetching one mailbox's new mail, including
+	// processing the chunks fetched so far; 0 uses 10m
+	FetchTimeout time.Duration
+	// ActionTimeout bounds each action, and each batched label or move
+	// command; 0 uses 2m
+	ActionTimeout time.Duration
+
+	// Circuit stops polling an account for a while once its polls keep
+	// failing
+	Circuit CircuitConfig
+
+	// Retry retries failed fetches and actions within a poll
+	Retry RetryConfig
+}
+
+// RetryConfig controls how a failed fetch or action is retried before the
+// poll gives up on it. Rejected logins, panics and other failures no retry
+// will fix are not retried.
+type RetryConfig struct {
+	Attempts int           // Tries in total; 0 tries once
+	Delay    time.Duration // Wait before the first retry, doubling after each; 0 uses 1s
+	MaxDelay time.Duration // Longest wait between tries; 0 leaves it unbounded
+}
+
+// CircuitConfig controls each account's circuit breaker. After Failures
+// polls in a row failed, the circuit opens and the account is not polled
+// for CoolDown; then a single probe poll closes it again or, failing,
+// reopens it.
+type CircuitConfig struct {
+	Failures int           // Failed polls in a row that open the circuit; 0 disables it
+	CoolDown time.Duration // How long an open circuit skips polls; 0 uses 15m
+}
+
+// ConnectionConfig manages the pool of provider connections each account
+// keeps for its polls, actions and message lookups
+type ConnectionConfig struct {
+	MaxOpen      int           // Connections open at once per account; 0 uses 1
+	MaxIdle      time.Duration // Close connections unused for this long; 0 keeps them open
+	CheckAfter   time.Duration // Check connections unused for this long with NOOP before reuse; 0 uses 1m
+	DialAttempts int           // Tries to connect before the poll fails; 0 uses 1
+	DialBackoff  time.Duration // Wait after the first failed try, doubling after each; 0 uses 1s
+}
+
+// ScriptLimits bound one run of a Starlark script; a script exceeding them
+// is cancelled
+type ScriptLimits struct {
+	Timeout  time.Duration // 0 uses 1s
+	MaxSteps uint64        // Starlark execution steps; 0 uses 1,000,000
+	MaxAlloc uint64        // Bytes allocated, counted across the process so approximate; 0 uses 64 MiB
+}
+
+// AdaptiveConfig controls the adaptive poll interval. Polls that find new
+// mail halve the interval down to Min; every EmptyPolls polls in a row
+// without mail double it up to Max.
+type AdaptiveConfig struct {
+	Enabled    bool
+	Min        time.Duration // Shortest interval; 0 uses a quarter of the poll interval
+	Max        time.Duration // Longest interval; 0 uses four times the poll interval
+	EmptyPolls int           // Empty polls in a row before the interval grows; 0 uses 3
+}
+
+// RuleBudgetConfig controls suspension of rules that are too slow
+type RuleBudgetConfig struct {
+	Limit   time.Duration // Longest a rule may take on one message; 0 uses 100ms
+	Strikes int           // Overruns within Window that suspend the rule; 0 uses 5
+	Window  time.Duration // Window for counting overruns; 0 uses 10m
+	Suspend time.Duration // How long a rule stays suspended; 0 uses 1h
+}
+
+// BackoffConfig controls retry delays after failed polls
+type BackoffConfig struct {
+	Initial    time.Duration // Delay after the first failure
+	Max        time.Duration // Upper bound for the delay
+	Multiplier float64       // Growth factor applied per consecutive failure
+	Jitter     float64       // Random spread as a fraction of the delay (0-1)
 }
 
 // Rule represents an email processing rule
 type Rule struct {
 	SubjectContains string
-	Action          string
+	ToContains      string // Text, ignoring case, that one To address must contain
+	CcContains      string // Text, ignoring case, that one Cc address must contain
+	FromInList      string // Name of an AddressList the sender must be on
+	FromNotInList   string // Name of an AddressList the sender must not be on
+	Condition       string // CEL expression over the email that must also hold, e.g. email.from.endsWith("@bank.com")
+	Action          string // "label", "notify", "digest", "snooze", "remind-if-no-reply", "add-to-calendar", "unsubscribe", "create-task", "create-issue", "create-jira", "webhook", "ntfy", "pushover", "notify-desktop", "archive", "exec", a plugin or one registered by an extension
 	Label           string
+	DueIn           time.Duration     // Due date of created tasks, relative to creation; 0 means none
+	TaskTarget      string            // Where create-task, and unsubscribe without one-click, put tasks: "local" (default) or "todoist"
+	TodoistProject  string            // Todoist project ID; empty uses the Inbox project
+	DueString       string            // Todoist natural-language due date, e.g. "tomorrow"; overrides DueIn
+	Repo            string            // GitHub repository for create-issue, as "owner/name"
+	IssueLabels     []string          // Labels of created GitHub issues
+	TitleTemplate   string            // Issue or notification title template over the email; empty uses the subject
+	BodyTemplate    string            // Issue or notification body template over the email; empty uses sender, date and text body for issues, the sender for notifications
+	JiraProject     string            // Jira project key for create-jira, e.g. "OPS"
+	JiraIssueType   string            // Jira issue type name; empty uses "Task"
+	JiraFields      map[string]string // Further Jira fields by ID, each a template over the email
+	WebhookURL      string            // Endpoint the webhook action POSTs to
+	WebhookPayload  string            // JSON payload template over the email; empty sends the envelope fields
+	WebhookHeaders  map[string]string // Extra request headers
+	WebhookSecrets  []string          // Sign requests with HMAC-SHA256, as event sinks do
+	WebhookRetries  int               // Retries after a failed delivery; 0 means none
+	WebhookTimeout  time.Duration     // Per-attempt timeout; 0 uses 10s
+	NtfyTopic       string            // ntfy topic the ntfy action publishes to
+	PushPriority    string            // ntfy/Pushover priority: "min", "low", "default" (empty), "high" or "urgent"
+	DesktopURL      string            // URL template opened by clicking a notify-desktop notification; may be empty
+	ArchiveDir      string            // Directory the archive action saves messages to, as .eml files
+	CalendarDir     string            // Directory add-to-calendar writes .ics files to instead of the CalDAV calendar
+	SnoozeFor       time.Duration     // How long the snooze action keeps a message out of INBOX
+	SnoozeMailbox   string            // Existing mailbox snoozed messages wait in; empty uses "Snoozed"
+	RemindAfter     time.Duration     // How long remind-if-no-reply waits for an answer to a message
+	RemindWith      string            // What remind-if-no-reply does without an answer: "notify" (default) or "create-task"
+	ExecCommand     []string          // Command and arguments the exec action runs with the email as JSON on stdin
+	ExecEnv         []string          // Environment variables passed to the command; others are withheld
+	ExecTimeout     time.Duration     // Kills the command after this long; 0 uses 30s
+	Params          map[string]string // Settings of an action registered by an extension
+	Mailbox         string            // Optional path.Match pattern restricting the rule to matching mailboxes
+	Plugin          string            // Name of a plugin whose tsk_match must also accept the email
+
+	// The attachment conditions hold if one attachment meets all of those
+	// set. AttachmentNameMatches is a case-insensitive path.Match pattern,
+	// e.g. "*.pdf"; AttachmentTypeIn lists content types, where "image/*"
+	// matches every image; AttachmentLargerThan is a size in bytes.
+	HasAttachment         bool
+	AttachmentNameMatches string
+	AttachmentTypeIn      []string
+	AttachmentLargerThan  int64
+
+	// Size conditions, in bytes, and age conditions, measured from the
+	// Date header; zero values are unset. Mail whose provider does not
+	// report its size never meets a size condition.
+	LargerThan  int64
+	SmallerThan int64
+	OlderThan   time.Duration
+	NewerThan   time.Duration
+
+	// HeaderMatches are conditions on header fields that must all hold
+	HeaderMatches []HeaderMatch
+
+	// ThreadAlreadyLabeled requires that go-tsk already gave an earlier
+	// message of the thread this label. ApplyToThread makes the rule's
+	// labels stick to the thread: later messages of it get them too, so
+	// replies inherit the labels of the original message. Both need a
+	// state store.
+	ThreadAlreadyLabeled string
+	ApplyToThread        bool
+
+	// SampleRate acts on only this fraction (0-1] of matches and
+	// SampleEvery on one in every N; both pick messages by a hash of the
+	// Message-ID, so the same messages are picked on every run. Zero
+	// processes every match.
+	SampleRate  float64
+	SampleEvery int
+
+	// Actions chains actions run in order in place of Action, e.g. a label
+	// followed by a push. Each takes its settings from the rule, except
+	// those it overrides.
+	Actions []RuleAction
+
+	// Script is a Starlark file whose on_message(email) returns the actions
+	// to run, as Actions does, in place of Action
+	Script string
+
+	// Tests are example messages the rule is checked against by the
+	// validate command
+	Tests []RuleTest
+}
+
+// RuleAction is one action of a rule's chain
+type RuleAction struct {
+	Action  string            // As Rule.Action
+	Label   string            // Overrides the rule's Label
+	Params  map[string]string // Overrides the rule's Params
+	OnError string            // "abort" (default) skips the rest of the chain if this action fails; "continue" runs it anyway
+}
+
+// Steps returns the actions a rule runs, in order, each as a copy of the
+// rule with just that action. A rule with a Script has none until its
+// script fills in Actions.
+func Steps(rule Rule) []Rule {
+	if len(rule.Actions) == 0 {
+		if rule.Script != "" {
+			return nil
+		}
+		return []Rule{rule}
+	}
+	steps := make([]Rule, len(rule.Actions))
+	for i, a := range rule.Actions {
+		step := rule
+		step.Actions, step.Script = nil, ""
+		step.Action = a.Action
+		if a.Label != "" {
+			step.Label = a.Label
+		}
+		if a.Params != nil {
+			step.Params = a.Params
+		}
+		steps[i] = step
+	}
+	return steps
+}
+
+// HeaderMatch is a condition on a header field. It holds if one of the
+// field's values contains Contains, ignoring case, and matches Regex; set
+// one or both. A HeaderMatch with neither holds if the field is present.
+type HeaderMatch struct {
+	Name     string // Header field name, e.g. "X-Priority"
+	Contains string
+	Regex    string // Go regular expression, e.g. "^[12]"
+}
+
+// RuleTest is an example message and whether its rule should match it
+type RuleTest struct {
+	Name    string // Shown when the test fails; defaults to its position
+	Subject string
+	From    string
+	Mailbox string // Mailbox the message is in; empty means INBOX
+	Body    string // Plain text body
+	Match   bool   // Whether the rule is expected to match
+}
+
+// LoopConfig holds the limits that keep rules from acting on go-tsk's own
+// mail or on each other's actions forever
+type LoopConfig struct {
+	MaxChain      int           // Longest chain of forwards/replies; 0 uses 3
+	MaxLabelFlips int           // Label add/remove flips per message tolerated within FlipWindow; 0 uses 4
+	FlipWindow    time.Duration // Window for counting label flips; 0 uses 1h
+}
+
+// IntegrationsConfig holds credentials for external task managers
+type IntegrationsConfig struct {
+	Todoist  TodoistConfig
+	GitHub   GitHubConfig
+	Jira     JiraConfig
+	Ntfy     NtfyConfig
+	Pushover PushoverConfig
+	CalDAV   CalDAVConfig
+	Exec     ExecConfig
+}
+
+// ExecConfig holds the settings of the exec action shared by all rules
+type ExecConfig struct {
+	MaxConcurrent int // Commands running at once; more wait for a free slot. 0 uses 4
+}
+
+// TodoistConfig holds the Todoist integration settings
+type TodoistConfig struct {
+	Token string // Todoist API token
+}
+
+// GitHubConfig holds the GitHub integration settings
+type GitHubConfig struct {
+	Token   string // Token allowed to create issues in the target repositories
+	BaseURL string // API base URL for GitHub Enterprise; empty uses api.github.com
+}
+
+// JiraConfig holds the Jira integration settings
+type JiraConfig struct {
+	BaseURL string // Site URL, e.g. "https://example.atlassian.net"
+	Email   string // Account email for Jira Cloud API tokens; empty sends Token as a bearer token (Data Center)
+	Token   string // API token or personal access token
+}
+
+// NtfyConfig holds the ntfy integration settings
+type NtfyConfig struct {
+	Server string // Server URL; empty uses https://ntfy.sh
+	Token  string // Access token for protected topics; may be empty
+}
+
+// PushoverConfig holds the Pushover integration settings
+type PushoverConfig struct {
+	AppToken string // Application API token
+	UserKey  string // User or group key notifications are sent to
+}
+
+// CalDAVConfig holds the CalDAV integration settings
+type CalDAVConfig struct {
+	URL      string // Calendar collection URL, e.g. "https://caldav.example.com/calendars/me/work/"
+	Username string // Basic auth user name; empty sends no credentials
+	Password string // Basic auth password or app password
+}
+
+// NotifyConfig holds notification-related configuration
+type NotifyConfig struct {
+	Channels []ChannelConfig
+
+	// Digest collects the matches of digest rules into one summary per
+	// account per window
+	Digest DigestConfig
+}
+
+// DigestConfig controls the summaries the digest action sends
+type DigestConfig struct {
+	Window time.Duration // How often each account's summary is sent; 0 uses 24h
+}
+
+// ChannelConfig represents a single notification channel
+type ChannelConfig struct {
+	Name      string // Unique name for the channel
+	Type      string // "log", "stdout", "smtp" or "slack"
+	Format    string // "html" (default), "plain" for screen-reader friendly text, or "json"
+	Verbosity string // "brief", "normal" or "verbose"; only used by the plain format
+	Enabled   bool   // Whether notifications are sent to this channel
+
+	// SchemaVersion pins the JSON payload version; 0 uses the latest
+	SchemaVersion int
+
+	// Settings of the smtp type, which emails notifications
+	SMTPServer   string   // host:port; STARTTLS is used when the server offers it
+	SMTPUsername string   // Empty sends without logging in
+	SMTPPassword string   // Password of SMTPUsername
+	From         string   // Sender address
+	To           []string // Recipient addresses
+
+	// SlackWebhookURL is the incoming webhook the slack type posts to
+	SlackWebhookURL string
+}
+
+// EventsConfig holds the sinks that receive machine-readable match and
+// action events
+type EventsConfig struct {
+	Sinks []SinkConfig
+	// QueueSize bounds the events waiting to be published to the sinks;
+	// further events are dropped. 0 uses 1000.
+	QueueSize int
+}
+
+// SinkConfig represents a single event sink
+type SinkConfig struct {
+	Name    string        // Unique name for the sink
+	Type    string        // "http", "nats", "kafka", "eventbridge" or "pubsub"
+	URL     string        // HTTP endpoint or NATS server, e.g. nats://localhost:4222
+	Brokers []string      // Kafka bootstrap brokers
+	Topic   string        // NATS subject, Kafka topic, EventBridge bus or projects/P/topics/T
+	Region  string        // AWS region for EventBridge; empty uses the SDK default
+	Format  string        // "json" (default) or "cloudevents"
+	Mode    string        // CloudEvents content mode: "structured" (default) or "binary"
+	Timeout time.Duration // Per-publish timeout; 0 uses 10s
+	Enabled bool          // Whether events are sent to this sink
+
+	// Secrets sign http requests with HMAC-SHA256, one signature per
+	// secret, so a new secret can be added before the old one is removed
+	Secrets []string
+}
+
+// MetricsConfig holds metrics-related configuration
+type MetricsConfig struct {
+	Addr string // Listen address for /debug/vars; empty disables the endpoint
+}
+
+// TracingConfig holds the OpenTelemetry trace export settings
+type TracingConfig struct {
+	Endpoint    string            // OTLP collector address, e.g. "localhost:4317"; empty disables tracing
+	Protocol    string            // "grpc" (default) or "http"
+	Insecure    bool              // Export without TLS, e.g. to a local collector
+	Headers     map[string]string // Extra export headers, e.g. collector credentials
+	ServiceName string            // Reported service name; empty uses "go-tsk"
+	SampleRatio float64           // Fraction (0-1] of polls traced; 0 traces every poll
+}
+
+// LogConfig holds logging configuration
+type LogConfig struct {
+	// Redact hides subjects, addresses and message text in the log:
+	// "none" (default) logs them verbatim, "hashed" as short hashes that
+	// still correlate, "full" as placeholders. Except with "none", tokens
+	// are always replaced.
+	Redact string
+}
+
+// ErrorReportingConfig holds where persistent failures are reported:
+// rejected logins, polls failing repeatedly and panics in rule actions
+type ErrorReportingConfig struct {
+	SentryDSN      string   // Sentry project DSN; empty disables Sentry
+	Environment    string   // Sentry environment, e.g. "production"; may be empty
+	WebhookURL     string   // Endpoint failures are POSTed to as JSON; empty disables it
+	WebhookSecrets []string // Sign webhook requests with HMAC-SHA256, as event sinks do
+	PollFailures   int      // Failed polls in a row before they are reported; 0 uses 3
+}
+
+// SecretsConfig holds the secret stores credentials in the config may
+// refer to
+type SecretsConfig struct {
+	Vault     VaultConfig
+	TokenFile TokenFileConfig
+}
+
+// TokenFileConfig holds the settings of the encrypted file the auth
+// command stores OAuth tokens in
+type TokenFileConfig struct {
+	Path string // Token file; empty disables it
+	Key  string // "machine" (default) derives the key from this machine and user, "passphrase" from $GO_TSK_PASSPHRASE
+}
+
+// VaultConfig holds the HashiCorp Vault settings for "vault://path#field"
+// references
+type VaultConfig struct {
+	Address   string // Server URL, e.g. "https://vault.example.com:8200"; empty disables vault references
+	Namespace string // Vault Enterprise namespace; may be empty
+	Token     string // Token to read secrets with; not needed with KubernetesRole
+
+	// KubernetesRole logs in with the pod's service account token under
+	// this role, at the auth mount KubernetesMount ("kubernetes" if empty)
+	KubernetesRole  string
+	KubernetesMount string
+}
+
+// ProxyConfig holds the egress proxy of the process
+type ProxyConfig struct {
+	// URL is a socks5://, http:// or https:// proxy, optionally with
+	// user:password, used by mail connections and outbound HTTP calls.
+	// Empty uses the ALL_PROXY environment variable, or HTTP_PROXY and
+	// HTTPS_PROXY for HTTP calls; "direct" uses none. One-click
+	// unsubscribe requests never use it: they connect directly so the
+	// address they reach can be checked to be public.
+	URL string
+}
+
+// proxySchemes are the schemes of proxy URLs
+var proxySchemes = map[string]bool{"socks5": true, "socks5h": true, "http": true, "https": true}
+
+// validateProxy checks a proxy URL; empty and "direct" are valid
+func validateProxy(raw string) error {
+	if raw == "" || raw == "direct" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || !proxySchemes[u.Scheme] || u.Host == "" {
+		return fmt.Errorf("invalid proxy %q; use socks5://host:port, http://host:port, https://host:port or direct", raw)
+	}
+	return nil
+}
+
+// CleanupJob is a one-off mailbox maintenance job run by the cleanup
+// command
+type CleanupJob struct {
+	Name      string        // Selects the job on the command line
+	Account   string        // ID of the account to clean
+	Mailbox   string        // Mailbox to clean; empty means INBOX, and is invalid for expunge
+	Action    string        // "remove-label" or "expunge"
+	Label     string        // Label taken off by remove-label
+	OlderThan time.Duration // Only touch mail received longer ago than this; 0 means all
+}
+
+// APIConfig holds the settings of the daemon's HTTP API
+type APIConfig struct {
+	Addr     string // Listen address; empty disables the API
+	GRPCAddr string // Listen address of the gRPC control service; empty disables it
+	Token    string // Bearer token clients must send; required with Addr or GRPCAddr
+}
+
+// StorageConfig holds state persistence configuration
+type StorageConfig struct {
+	Path      string // Database file, or connection URL for postgres; empty disables persistence
+	Backend   string // "sqlite" (default), "bolt", which needs no CGO but has no full-text search, or "postgres"
+	Archive   ArchiveConfig
+	Retention RetentionConfig
+}
+
+// HAConfig lets several go-tsk instances share a Postgres store and the
+// same accounts. Each account is processed by the instance holding its
+// lease; when that instance stops renewing it, another takes over.
+type HAConfig struct {
+	Enabled  bool
+	Instance string        // Name of this instance in leases; defaults to host name and process ID
+	LeaseTTL time.Duration // How long a lease lasts unless renewed; 0 uses 3 poll intervals
+}
+
+// RetentionConfig bounds how long go-tsk keeps its own state. A zero age
+// keeps that state forever.
+type RetentionConfig struct {
+	Archive   time.Duration // Archived messages, by date
+	Processed time.Duration // Entries of the processed-message journal
+	Events    time.Duration // Records of thread labels and of messages go-tsk sent
+	Interval  time.Duration // How often old state is pruned; 0 uses 24h
+}
+
+// ArchiveConfig keeps the messages go-tsk fetches in the database, for
+// searching past mail, the dashboard's history and replaying rules
+type ArchiveConfig struct {
+	Enabled bool
+	Bodies  bool // Also keep text and HTML bodies, of accounts with FetchBodies
 }
 
 // DefaultConfig returns a default configuration
@@ -42,6 +679,7 @@ func DefaultConfig() *Config {
 				Provider: "gmail",
 				Enabled:  true,
 				// OAuth2 credentials need to be set
+				Address:      "",
 				ClientID:     "",
 				ClientSecret: "",
 				Token:        "",
@@ -49,6 +687,12 @@ func DefaultConfig() *Config {
 		},
 		Poll: PollConfig{
 			Interval: 5 * time.Minute,
+			Backoff: BackoffConfig{
+				Initial:    30 * time.Second,
+				Max:        30 * time.Minute,
+				Multiplier: 2,
+				Jitter:     0.2,
+			},
 			Rules: []Rule{
 				{
 					SubjectContains: "job opportunity",
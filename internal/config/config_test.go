@@ -0,0 +1,248 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		interval time.Duration
+		initial  time.Duration
+		wantErr  bool
+	}{
+		{
+			"duration strings",
+			`{"Poll": {"Interval": "90s", "Backoff": {"Initial": "1m30s"}}}`,
+			90 * time.Second,
+			90 * time.Second,
+			false,
+		},
+		{
+			"nanoseconds",
+			`{"poll": {"interval": 60000000000}}`,
+			time.Minute,
+			0,
+			false,
+		},
+		{
+			"default interval",
+			`{}`,
+			5 * time.Minute,
+			0,
+			false,
+		},
+		{"invalid duration", `{"Poll": {"Interval": "5 minutes"}}`, 0, 0, true},
+		{"adaptive", `{"Poll": {"Interval": "5m", "Adaptive": {"Enabled": true, "Min": "30s", "Max": "1h"}}}`, 5 * time.Minute, 0, false},
+		{"tracing", `{"Tracing": {"Endpoint": "localhost:4318", "Protocol": "http", "SampleRatio": 0.1}}`, 5 * time.Minute, 0, false},
+		{"unknown tracing protocol", `{"Tracing": {"Endpoint": "localhost:4317", "Protocol": "zipkin"}}`, 0, 0, true},
+		{"error reporting", `{"Errors": {"SentryDSN": "https://abc123@o1.ingest.sentry.io/42", "WebhookURL": "https://hooks.example.com/errors"}}`, 5 * time.Minute, 0, false},
+		{"Sentry DSN without key", `{"Errors": {"SentryDSN": "https://o1.ingest.sentry.io/42"}}`, 0, 0, true},
+		{"invalid error webhook", `{"Errors": {"WebhookURL": "ftp://example.com"}}`, 0, 0, true},
+		{"log redaction", `{"Log": {"Redact": "hashed"}}`, 5 * time.Minute, 0, false},
+		{"unknown log redaction", `{"Log": {"Redact": "partial"}}`, 0, 0, true},
+		{"vault", `{"Secrets": {"Vault": {"Address": "https://vault:8200", "KubernetesRole": "go-tsk"}}}`, 5 * time.Minute, 0, false},
+		{"vault without credentials", `{"Secrets": {"Vault": {"Address": "https://vault:8200"}}}`, 0, 0, true},
+		{"token file", `{"Secrets": {"TokenFile": {"Path": "tokens.enc", "Key": "passphrase"}}}`, 5 * time.Minute, 0, false},
+		{"unknown token file key", `{"Secrets": {"TokenFile": {"Path": "tokens.enc", "Key": "tpm"}}}`, 0, 0, true},
+		{"adaptive min above max", `{"Poll": {"Adaptive": {"Enabled": true, "Min": "1h", "Max": "30s"}}}`, 0, 0, true},
+		{"unknown field", `{"Pol": {}}`, 0, 0, true},
+		{"duplicate account", `{"EmailAccounts": [{"ID": "a"}, {"ID": "a"}]}`, 0, 0, true},
+		{"unknown action", `{"Poll": {"Rules": [{"Action": "explode"}]}}`, 0, 0, true},
+		{"bad mailbox pattern", `{"EmailAccounts": [{"ID": "a", "Mailboxes": ["Lists/["]}]}`, 0, 0, true},
+		{"empty mailbox", `{"EmailAccounts": [{"ID": "a", "Mailboxes": [""]}]}`, 0, 0, true},
+		{"bad rule mailbox", `{"Poll": {"Rules": [{"Label": "x", "Mailbox": "[a-"}]}}`, 0, 0, true},
+		{"action chain", `{"Poll": {"Rules": [{"Label": "x", "Actions": [{}, {"Action": "ntfy", "OnError": "continue"}, {"Action": "label", "Label": "\\Seen"}], "NtfyTopic": "mail"}]}}`, 5 * time.Minute, 0, false},
+		{"action and chain", `{"Poll": {"Rules": [{"Action": "notify", "Actions": [{"Action": "notify"}]}]}}`, 0, 0, true},
+		{"chain step without settings", `{"Poll": {"Rules": [{"Label": "x", "Actions": [{}, {"Action": "ntfy"}]}]}}`, 0, 0, true},
+		{"script", `{"Poll": {"Rules": [{"SubjectContains": "x", "Script": "/etc/go-tsk/hook.star"}], "ScriptLimits": {"Timeout": "500ms", "MaxSteps": 100000}}}`, 5 * time.Minute, 0, false},
+		{"script and action", `{"Poll": {"Rules": [{"Script": "/etc/go-tsk/hook.star", "Action": "notify"}]}}`, 0, 0, true},
+		{"exec", `{"Poll": {"Rules": [{"SubjectContains": "x", "Action": "exec", "ExecCommand": ["/usr/local/bin/on-mail", "--json"], "ExecEnv": ["HOME"], "ExecTimeout": "1m"}]}, "Integrations": {"Exec": {"MaxConcurrent": 2}}}`, 5 * time.Minute, 0, false},
+		{"exec without command", `{"Poll": {"Rules": [{"Action": "exec"}]}}`, 0, 0, true},
+		{"digest", `{"Poll": {"Rules": [{"SubjectContains": "x", "Action": "digest"}]}, "Notify": {"Digest": {"Window": "12h"}, "Channels": [{"Name": "mail", "Type": "smtp", "SMTPServer": "smtp.example.com:587", "From": "tsk@example.com", "To": ["me@example.com"], "Enabled": true}]}}`, 5 * time.Minute, 0, false},
+		{"snooze", `{"Poll": {"Rules": [{"SubjectContains": "x", "Action": "snooze", "SnoozeFor": "72h"}]}, "Storage": {"Path": "/var/lib/go-tsk/state.db"}}`, 5 * time.Minute, 0, false},
+		{"snooze without duration", `{"Poll": {"Rules": [{"Action": "snooze"}]}, "Storage": {"Path": "/var/lib/go-tsk/state.db"}}`, 0, 0, true},
+		{"snooze without storage", `{"Poll": {"Rules": [{"Action": "snooze", "SnoozeFor": "1h"}]}}`, 0, 0, true},
+		{"calendar file", `{"Poll": {"Rules": [{"Action": "add-to-calendar", "CalendarDir": "/var/lib/go-tsk/calendar"}]}}`, 5 * time.Minute, 0, false},
+		{"caldav", `{"Poll": {"Rules": [{"Action": "add-to-calendar"}]}, "Integrations": {"CalDAV": {"URL": "https://caldav.example.com/calendars/me/work/"}}}`, 5 * time.Minute, 0, false},
+		{"calendar without target", `{"Poll": {"Rules": [{"Action": "add-to-calendar"}]}}`, 0, 0, true},
+		{"invalid caldav URL", `{"Integrations": {"CalDAV": {"URL": "caldav.example.com"}}}`, 0, 0, true},
+		{"unsubscribe", `{"Poll": {"Rules": [{"Action": "unsubscribe"}]}, "Storage": {"Path": "/var/lib/go-tsk/state.db"}}`, 5 * time.Minute, 0, false},
+		{"unsubscribe without task target", `{"Poll": {"Rules": [{"Action": "unsubscribe"}]}}`, 0, 0, true},
+		{"reminder", `{"Poll": {"Rules": [{"Action": "remind-if-no-reply", "RemindAfter": "72h", "RemindWith": "create-task"}]}, "Storage": {"Path": "/var/lib/go-tsk/state.db"}}`, 5 * time.Minute, 0, false},
+		{"reminder without delay", `{"Poll": {"Rules": [{"Action": "remind-if-no-reply"}]}, "Storage": {"Path": "/var/lib/go-tsk/state.db"}}`, 0, 0, true},
+		{"reminder with unknown follow-up", `{"Poll": {"Rules": [{"Action": "remind-if-no-reply", "RemindAfter": "1h", "RemindWith": "ntfy"}]}, "Storage": {"Path": "/var/lib/go-tsk/state.db"}}`, 0, 0, true},
+		{"reminder task without token", `{"Poll": {"Rules": [{"Action": "remind-if-no-reply", "RemindAfter": "1h", "RemindWith": "create-task", "TaskTarget": "todoist"}]}, "Storage": {"Path": "/var/lib/go-tsk/state.db"}}`, 0, 0, true},
+		{"negative digest window", `{"Notify": {"Digest": {"Window": "-1h"}}}`, 0, 0, true},
+		{"plugin", `{"Plugins": [{"Name": "spam", "Path": "/etc/go-tsk/spam.wasm", "Timeout": "200ms"}], "Poll": {"Rules": [{"SubjectContains": "x", "Plugin": "spam", "Action": "spam"}]}}`, 5 * time.Minute, 0, false},
+		{"unknown plugin", `{"Poll": {"Rules": [{"Label": "x", "Plugin": "spam"}]}}`, 0, 0, true},
+		{"duplicate plugin", `{"Plugins": [{"Name": "spam", "Path": "a.wasm"}, {"Name": "spam", "Path": "b.wasm"}]}`, 0, 0, true},
+		{"plugin named as action", `{"Plugins": [{"Name": "label", "Path": "a.wasm"}]}`, 0, 0, true},
+		{"unknown chain error policy", `{"Poll": {"Rules": [{"Actions": [{"Action": "notify", "OnError": "retry"}]}]}}`, 0, 0, true},
+		{"outgoing header", `{"EmailAccounts": [{"ID": "a", "OutgoingHeaders": {"X-Ticket-Source": "tsk"}}]}`, 5 * time.Minute, 0, false},
+		{"reserved outgoing header", `{"EmailAccounts": [{"ID": "a", "OutgoingHeaders": {"subject": "x"}}]}`, 0, 0, true},
+		{"outgoing header injection", `{"EmailAccounts": [{"ID": "a", "OutgoingHeaders": {"X-A": "1\r\nBcc: x@example.com"}}]}`, 0, 0, true},
+		{"account limits", `{"EmailAccounts": [{"ID": "a", "Limits": {"MaxConcurrent": 1, "MaxMessages": 500, "StoreRate": 2.5, "StoreBurst": 5}}]}`, 5 * time.Minute, 0, false},
+		{"negative limit", `{"EmailAccounts": [{"ID": "a", "Limits": {"MaxMessages": -1}}]}`, 0, 0, true},
+		{"fetch settings", `{"EmailAccounts": [{"ID": "a", "Fetch": {"Window": 200, "Workers": 8, "MaxMessages": 5000}}]}`, 5 * time.Minute, 0, false},
+		{"negative fetch window", `{"EmailAccounts": [{"ID": "a", "Fetch": {"Window": -1}}]}`, 0, 0, true},
+		{"tls settings", `{"EmailAccounts": [{"ID": "a", "TLS": {"CAFile": "ca.pem", "CertFile": "c.pem", "KeyFile": "k.pem", "MinVersion": "1.3", "StartTLS": true}}]}`, 5 * time.Minute, 0, false},
+		{"unknown tls version", `{"EmailAccounts": [{"ID": "a", "TLS": {"MinVersion": "1.4"}}]}`, 0, 0, true},
+		{"proxies", `{"Proxy": {"URL": "socks5://user:pw@proxy:1080"}, "EmailAccounts": [{"ID": "a", "Proxy": "http://proxy:3128"}, {"ID": "b", "Proxy": "direct"}]}`, 5 * time.Minute, 0, false},
+		{"unsupported proxy scheme", `{"Proxy": {"URL": "ftp://proxy:21"}}`, 0, 0, true},
+		{"account proxy without host", `{"EmailAccounts": [{"ID": "a", "Proxy": "socks5://"}]}`, 0, 0, true},
+		{"client cert without key", `{"EmailAccounts": [{"ID": "a", "TLS": {"CertFile": "c.pem"}}]}`, 0, 0, true},
+		{"store burst without rate", `{"EmailAccounts": [{"ID": "a", "Limits": {"StoreBurst": 5}}]}`, 0, 0, true},
+		{"gmail push", `{"EmailAccounts": [{"ID": "a", "Provider": "gmailapi", "Push": {"Topic": "projects/p/topics/gmail", "Subscription": "projects/p/subscriptions/go-tsk", "FallbackInterval": "30m"}}]}`, 5 * time.Minute, 0, false},
+		{"gmail push over imap", `{"EmailAccounts": [{"ID": "a", "Push": {"Topic": "projects/p/topics/gmail", "Subscription": "projects/p/subscriptions/go-tsk"}}]}`, 0, 0, true},
+		{"gmail push without subscription", `{"EmailAccounts": [{"ID": "a", "Provider": "gmailapi", "Push": {"Topic": "projects/p/topics/gmail"}}]}`, 0, 0, true},
+		{"jmap", `{"EmailAccounts": [{"ID": "a", "Provider": "jmap", "SessionURL": "https://api.fastmail.com/jmap/session"}]}`, 5 * time.Minute, 0, false},
+		{"jmap without session url", `{"EmailAccounts": [{"ID": "a", "Provider": "jmap"}]}`, 0, 0, true},
+		{"pop3", `{"EmailAccounts": [{"ID": "a", "Provider": "pop3", "Server": "pop.example.com:995"}], "Poll": {"Rules": [{"SubjectContains": "x", "Action": "archive", "ArchiveDir": "/var/mail/archive"}]}}`, 5 * time.Minute, 0, false},
+		{"pop3 without port", `{"EmailAccounts": [{"ID": "a", "Provider": "pop3", "Server": "pop.example.com"}]}`, 0, 0, true},
+		{"pop3 with label rule", `{"EmailAccounts": [{"ID": "a", "Provider": "pop3", "Server": "pop.example.com:995"}], "Poll": {"Rules": [{"SubjectContains": "x", "Label": "y"}]}}`, 0, 0, true},
+		{"pop3 with other mailbox", `{"EmailAccounts": [{"ID": "a", "Provider": "pop3", "Server": "pop.example.com:995", "Mailboxes": ["Sent"]}]}`, 0, 0, true},
+		{"pop3 cleanup", `{"EmailAccounts": [{"ID": "a", "Provider": "pop3", "Server": "pop.example.com:995"}], "Cleanup": [{"Name": "old", "Account": "a", "Action": "expunge"}]}`, 0, 0, true},
+		{"maildir", `{"EmailAccounts": [{"ID": "a", "Provider": "maildir", "Path": "/home/me/Maildir", "Mailboxes": ["INBOX", "Lists/go"]}], "Poll": {"Rules": [{"SubjectContains": "x", "Label": "y"}]}}`, 5 * time.Minute, 0, false},
+		{"maildir without path", `{"EmailAccounts": [{"ID": "a", "Provider": "maildir"}]}`, 0, 0, true},
+		{"mbox", `{"EmailAccounts": [{"ID": "a", "Provider": "mbox", "Path": "/var/mail/me"}], "Poll": {"Rules": [{"SubjectContains": "x", "Action": "notify"}]}}`, 5 * time.Minute, 0, false},
+		{"yahoo app password", `{"EmailAccounts": [{"ID": "a", "Provider": "yahoo", "Address": "me@yahoo.com", "Password": "keyring:yahoo"}]}`, 5 * time.Minute, 0, false},
+		{"aol oauth", `{"EmailAccounts": [{"ID": "a", "Provider": "aol", "Address": "me@aol.com", "ClientID": "id", "Token": "t"}]}`, 5 * time.Minute, 0, false},
+		{"yahoo without credentials", `{"EmailAccounts": [{"ID": "a", "Provider": "yahoo", "Address": "me@yahoo.com"}]}`, 0, 0, true},
+		{"yahoo oauth without token", `{"EmailAccounts": [{"ID": "a", "Provider": "yahoo", "Address": "me@yahoo.com", "ClientID": "id"}]}`, 0, 0, true},
+		{"aol without address", `{"EmailAccounts": [{"ID": "a", "Provider": "aol", "Password": "p"}]}`, 0, 0, true},
+		{"icloud", `{"EmailAccounts": [{"ID": "a", "Provider": "icloud", "Address": "me@icloud.com", "Password": "abcd-efgh-ijkl-mnop"}]}`, 5 * time.Minute, 0, false},
+		{"icloud oauth", `{"EmailAccounts": [{"ID": "a", "Provider": "icloud", "Address": "me@icloud.com", "ClientID": "id", "Token": "t"}]}`, 0, 0, true},
+		{"mbox with label rule", `{"EmailAccounts": [{"ID": "a", "Provider": "mbox", "Path": "/var/mail/me"}], "Poll": {"Rules": [{"SubjectContains": "x", "Label": "y"}]}}`, 0, 0, true},
+		{"archive without dir", `{"Poll": {"Rules": [{"SubjectContains": "x", "Action": "archive"}]}}`, 0, 0, true},
+		{"gmail push with short topic", `{"EmailAccounts": [{"ID": "a", "Provider": "gmailapi", "Push": {"Topic": "gmail", "Subscription": "projects/p/subscriptions/go-tsk"}}]}`, 0, 0, true},
+		{"create-task", `{"Storage": {"Path": "tsk.db"}, "Poll": {"Rules": [{"Action": "create-task", "DueIn": "48h"}]}}`, 5 * time.Minute, 0, false},
+		{"create-task without store", `{"Poll": {"Rules": [{"Action": "create-task"}]}}`, 0, 0, true},
+		{"todoist target", `{"Integrations": {"Todoist": {"Token": "t"}}, "Poll": {"Rules": [{"Action": "create-task", "TaskTarget": "todoist"}]}}`, 5 * time.Minute, 0, false},
+		{"todoist without token", `{"Poll": {"Rules": [{"Action": "create-task", "TaskTarget": "todoist"}]}}`, 0, 0, true},
+		{"unknown task target", `{"Storage": {"Path": "x"}, "Poll": {"Rules": [{"Action": "create-task", "TaskTarget": "jira"}]}}`, 0, 0, true},
+		{"create-issue", `{"Integrations": {"GitHub": {"Token": "t"}}, "Poll": {"Rules": [{"Action": "create-issue", "Repo": "octo/app"}]}}`, 5 * time.Minute, 0, false},
+		{"create-jira", `{"Integrations": {"Jira": {"BaseURL": "https://x.atlassian.net", "Token": "t"}}, "Poll": {"Rules": [{"Action": "create-jira", "JiraProject": "OPS"}]}}`, 5 * time.Minute, 0, false},
+		{"create-jira without project", `{"Integrations": {"Jira": {"BaseURL": "https://x.atlassian.net", "Token": "t"}}, "Poll": {"Rules": [{"Action": "create-jira"}]}}`, 0, 0, true},
+		{"create-jira reserved field", `{"Integrations": {"Jira": {"BaseURL": "https://x.atlassian.net", "Token": "t"}}, "Poll": {"Rules": [{"Action": "create-jira", "JiraProject": "OPS", "JiraFields": {"summary": "x"}}]}}`, 0, 0, true},
+		{"create-issue bad repo", `{"Integrations": {"GitHub": {"Token": "t"}}, "Poll": {"Rules": [{"Action": "create-issue", "Repo": "app"}]}}`, 0, 0, true},
+		{"sample rate", `{"Poll": {"Rules": [{"Label": "x", "SampleRate": 0.1}]}}`, 5 * time.Minute, 0, false},
+		{"sample rate too high", `{"Poll": {"Rules": [{"Label": "x", "SampleRate": 1.5}]}}`, 0, 0, true},
+		{"sample rate and every", `{"Poll": {"Rules": [{"Label": "x", "SampleRate": 0.5, "SampleEvery": 10}]}}`, 0, 0, true},
+		{"attachment conditions", `{"Poll": {"Rules": [{"Label": "x", "AttachmentNameMatches": "*.pdf", "AttachmentTypeIn": ["application/pdf", "image/*"], "AttachmentLargerThan": 1000}]}}`, 5 * time.Minute, 0, false},
+		{"attachment bad pattern", `{"Poll": {"Rules": [{"Label": "x", "AttachmentNameMatches": "[a"}]}}`, 0, 0, true},
+		{"attachment bad type", `{"Poll": {"Rules": [{"Label": "x", "AttachmentTypeIn": ["pdf"]}]}}`, 0, 0, true},
+		{"attachment negative size", `{"Poll": {"Rules": [{"Label": "x", "AttachmentLargerThan": -1}]}}`, 0, 0, true},
+		{"size and age", `{"Poll": {"Rules": [{"Label": "\\Deleted", "LargerThan": 5000000, "OlderThan": "720h"}]}}`, 5 * time.Minute, 0, false},
+		{"negative age", `{"Poll": {"Rules": [{"Label": "x", "NewerThan": "-1h"}]}}`, 0, 0, true},
+		{"empty size range", `{"Poll": {"Rules": [{"Label": "x", "LargerThan": 2000, "SmallerThan": 1000}]}}`, 0, 0, true},
+		{"empty age range", `{"Poll": {"Rules": [{"Label": "x", "OlderThan": "48h", "NewerThan": "24h"}]}}`, 0, 0, true},
+		{"header match", `{"Poll": {"Rules": [{"Label": "x", "HeaderMatches": [{"Name": "X-Priority", "Regex": "^[12]"}, {"Name": "List-Id"}]}]}}`, 5 * time.Minute, 0, false},
+		{"header match without name", `{"Poll": {"Rules": [{"Label": "x", "HeaderMatches": [{"Contains": "x"}]}]}}`, 0, 0, true},
+		{"header match bad regex", `{"Poll": {"Rules": [{"Label": "x", "HeaderMatches": [{"Name": "X-Priority", "Regex": "("}]}]}}`, 0, 0, true},
+		{"thread labels", `{"Storage": {"Path": "x"}, "Poll": {"Rules": [{"Label": "Project", "ApplyToThread": true}, {"Action": "ntfy", "NtfyTopic": "t", "ThreadAlreadyLabeled": "Project"}]}}`, 5 * time.Minute, 0, false},
+		{"thread labels without store", `{"Poll": {"Rules": [{"Label": "Project", "ApplyToThread": true}]}}`, 0, 0, true},
+		{"address lists", `{"AddressLists": [{"Name": "vips", "Addresses": ["boss@example.com", "@partner.example"]}, {"Name": "pests", "File": "pests.txt", "Refresh": 600000000000}, {"Name": "contacts", "CardDAV": {"URL": "https://dav.example.com/contacts/", "Token": "t"}}], "Poll": {"Rules": [{"FromInList": "vips", "Label": "VIP"}, {"FromNotInList": "contacts", "Label": "Unknown"}]}}`, 5 * time.Minute, 0, false},
+		{"archive", `{"Storage": {"Path": "x", "Archive": {"Enabled": true, "Bodies": true}}}`, 5 * time.Minute, 0, false},
+		{"archive without store", `{"Storage": {"Archive": {"Enabled": true}}}`, 0, 0, true},
+		{"archive bodies without archive", `{"Storage": {"Path": "x", "Archive": {"Bodies": true}}}`, 0, 0, true},
+		{"retention", `{"Storage": {"Path": "x", "Retention": {"Archive": "8760h", "Processed": "2160h", "Interval": "1h"}}}`, 5 * time.Minute, 0, false},
+		{"bolt backend", `{"Storage": {"Path": "x", "Backend": "bolt"}}`, 5 * time.Minute, 0, false},
+		{"postgres backend", `{"Storage": {"Path": "postgres://tsk@db/tsk", "Backend": "postgres"}}`, 5 * time.Minute, 0, false},
+		{"ha", `{"Storage": {"Path": "postgres://db/tsk", "Backend": "postgres"}, "HA": {"Enabled": true, "LeaseTTL": "15m"}}`, 5 * time.Minute, 0, false},
+		{"ha without postgres", `{"Storage": {"Path": "x"}, "HA": {"Enabled": true}}`, 0, 0, true},
+		{"ha lease under a second", `{"Storage": {"Path": "postgres://db/tsk", "Backend": "postgres"}, "Poll": {"Interval": "100ms"}, "HA": {"Enabled": true, "LeaseTTL": "500ms"}}`, 0, 0, true},
+		{"ha lease shorter than poll", `{"Storage": {"Path": "postgres://db/tsk", "Backend": "postgres"}, "HA": {"Enabled": true, "LeaseTTL": "1m"}}`, 0, 0, true},
+		{"connection pool", `{"Poll": {"Connections": {"MaxOpen": 2, "MaxIdle": "20m", "CheckAfter": "30s", "DialAttempts": 3}}}`, 5 * time.Minute, 0, false},
+		{"negative connection pool", `{"Poll": {"Connections": {"MaxOpen": -1}}}`, 0, 0, true},
+		{"timeouts", `{"Poll": {"ConnectTimeout": "30s", "FetchTimeout": "5m", "ActionTimeout": "1m"}}`, 5 * time.Minute, 0, false},
+		{"negative fetch timeout", `{"Poll": {"FetchTimeout": "-1s"}}`, 0, 0, true},
+		{"circuit breaker", `{"Poll": {"Circuit": {"Failures": 5, "CoolDown": "10m"}}}`, 5 * time.Minute, 0, false},
+		{"negative circuit cool-down", `{"Poll": {"Circuit": {"Failures": 5, "CoolDown": "-1m"}}}`, 0, 0, true},
+		{"retries", `{"Poll": {"Retry": {"Attempts": 3, "Delay": "2s", "MaxDelay": "10s"}}}`, 5 * time.Minute, 0, false},
+		{"negative retries", `{"Poll": {"Retry": {"Attempts": -1}}}`, 0, 0, true},
+		{"unknown backend", `{"Storage": {"Path": "x", "Backend": "mysql"}}`, 0, 0, true},
+		{"negative retention", `{"Storage": {"Path": "x", "Retention": {"Events": -1}}}`, 0, 0, true},
+		{"unknown address list", `{"Poll": {"Rules": [{"FromInList": "vips", "Label": "VIP"}]}}`, 0, 0, true},
+		{"address list without name", `{"AddressLists": [{"Addresses": ["boss@example.com"]}]}`, 0, 0, true},
+		{"duplicate address list", `{"AddressLists": [{"Name": "vips", "File": "a"}, {"Name": "vips", "File": "b"}]}`, 0, 0, true},
+		{"address list without source", `{"AddressLists": [{"Name": "vips"}]}`, 0, 0, true},
+		{"address list with invalid CardDAV URL", `{"AddressLists": [{"Name": "vips", "CardDAV": {"URL": "dav.example.com"}}]}`, 0, 0, true},
+		{"apply to thread without label", `{"Storage": {"Path": "x"}, "Poll": {"Rules": [{"Action": "ntfy", "NtfyTopic": "t", "ApplyToThread": true}]}}`, 0, 0, true},
+		{"api", `{"API": {"Addr": ":8081", "Token": "t"}}`, 5 * time.Minute, 0, false},
+		{"api without token", `{"API": {"Addr": ":8081"}}`, 0, 0, true},
+		{"grpc without token", `{"API": {"GRPCAddr": ":9090"}}`, 0, 0, true},
+		{"cleanup job", `{"EmailAccounts": [{"ID": "a"}], "Cleanup": [{"Name": "old-imp", "Account": "a", "Action": "remove-label", "Label": "imp", "OlderThan": "2160h"}]}`, 5 * time.Minute, 0, false},
+		{"cleanup unknown account", `{"Cleanup": [{"Name": "trash", "Account": "nosuch", "Action": "expunge"}]}`, 0, 0, true},
+		{"cleanup expunge", `{"EmailAccounts": [{"ID": "a"}], "Cleanup": [{"Name": "trash", "Account": "a", "Mailbox": "Trash", "Action": "expunge"}]}`, 5 * time.Minute, 0, false},
+		{"cleanup expunge without mailbox", `{"EmailAccounts": [{"ID": "a"}], "Cleanup": [{"Name": "trash", "Account": "a", "Action": "expunge"}]}`, 0, 0, true},
+		{"cleanup without label", `{"EmailAccounts": [{"ID": "a"}], "Cleanup": [{"Name": "x", "Account": "a", "Action": "remove-label"}]}`, 0, 0, true},
+		{"webhook", `{"Poll": {"Rules": [{"Action": "webhook", "WebhookURL": "https://hooks.example.com/in", "WebhookRetries": 3, "WebhookTimeout": "5s"}]}}`, 5 * time.Minute, 0, false},
+		{"webhook bad url", `{"Poll": {"Rules": [{"Action": "webhook", "WebhookURL": "ftp://example.com"}]}}`, 0, 0, true},
+		{"webhook bad header", `{"Poll": {"Rules": [{"Action": "webhook", "WebhookURL": "https://example.com", "WebhookHeaders": {"X-A": "b\r\nc"}}]}}`, 0, 0, true},
+		{"ntfy", `{"Poll": {"Rules": [{"Action": "ntfy", "NtfyTopic": "mail-alerts", "PushPriority": "high"}]}}`, 5 * time.Minute, 0, false},
+		{"ntfy bad priority", `{"Poll": {"Rules": [{"Action": "ntfy", "NtfyTopic": "mail-alerts", "PushPriority": "loud"}]}}`, 0, 0, true},
+		{"pushover without keys", `{"Poll": {"Rules": [{"Action": "pushover"}]}}`, 0, 0, true},
+		{"notify-desktop", `{"Poll": {"Rules": [{"Action": "notify-desktop", "DesktopURL": "https://mail.google.com/mail/#search/rfc822msgid:{{.MessageID}}"}]}}`, 5 * time.Minute, 0, false},
+		{"not json", `Poll = 5m`, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := Parse([]byte(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v; wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if cfg.Poll.Interval != tt.interval {
+				t.Errorf("Poll.Interval = %s; want %s", cfg.Poll.Interval, tt.interval)
+			}
+			if cfg.Poll.Backoff.Initial != tt.initial {
+				t.Errorf("Poll.Backoff.Initial = %s; want %s", cfg.Poll.Backoff.Initial, tt.initial)
+			}
+		})
+	}
+}
+
+func TestPresetMailboxes(t *testing.T) {
+	cfg, err := Parse([]byte(`{"EmailAccounts": [
+		{"ID": "icloud", "Provider": "icloud", "Address": "me@icloud.com", "Password": "p", "Mailboxes": ["INBOX", "Sent", "Work"]},
+		{"ID": "gmail", "Mailboxes": ["Sent"]}],
+		"Cleanup": [{"Name": "trash", "Account": "icloud", "Mailbox": "Trash", "Action": "expunge"}]}`))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got := cfg.EmailAccounts[0].Mailboxes; !reflect.DeepEqual(got, []string{"INBOX", "Sent Messages", "Work"}) {
+		t.Errorf("iCloud mailboxes = %v", got)
+	}
+	if got := cfg.EmailAccounts[1].Mailboxes; !reflect.DeepEqual(got, []string{"Sent"}) {
+		t.Errorf("Gmail mailboxes = %v", got)
+	}
+	if got := cfg.Cleanup[0].Mailbox; got != "Deleted Messages" {
+		t.Errorf("cleanup mailbox = %q", got)
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add([]byte(`{}`))
+	f.Add([]byte(`{"Poll": {"Interval": "90s", "Backoff": {"Initial": "1m", "Max": "1h", "Jitter": 0.2}}}`))
+	f.Add([]byte(`{"EmailAccounts": [{"ID": "primary", "Provider": "gmail", "Enabled": true}]}`))
+	f.Add([]byte(`{"Poll": {"Rules": [{"SubjectContains": "job", "Action": "label", "Label": "imp"}]}}`))
+	f.Add([]byte(`{"Notify": {"Channels": [{"Name": "sr", "Format": "plain", "Verbosity": "brief"}]}}`))
+	f.Add([]byte(`{"Poll": {"Interval": 1e400}}`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		cfg, err := Parse(data)
+		if err != nil {
+			return
+		}
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("Parse() returned a config that fails validation: %v", err)
+		}
+	})
+}
@@ -0,0 +1,874 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxWebhookRetries bounds WebhookRetries, as retries hold up the poll
+const maxWebhookRetries = 10
+
+// durationType is used to find time.Duration fields while decoding
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Load reads and validates a JSON configuration file
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	cfg, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Parse decodes and validates a JSON configuration. Durations may be given
+// as strings such as "5m" or "1h30m".
+func Parse(data []byte) (*Config, error) {
+	// Decode numbers as json.Number so re-encoding doesn't lose precision
+	var raw interface{}
+	rawDec := json.NewDecoder(bytes.NewReader(data))
+	rawDec.UseNumber()
+	if err := rawDec.Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	raw, err := normalizeDurations(raw, reflect.TypeOf(Config{}), "")
+	if err != nil {
+		return nil, err
+	}
+
+	normalized, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	dec := json.NewDecoder(bytes.NewReader(normalized))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return nil, err
+	}
+
+	applyDefaults(cfg)
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyDefaults fills in settings left unset in the file, including the
+// global proxy of accounts without their own, and translates common
+// mailbox names of preset accounts to the service's names
+func applyDefaults(cfg *Config) {
+	if cfg.Poll.Interval == 0 {
+		cfg.Poll.Interval = DefaultConfig().Poll.Interval
+	}
+	for i, account := range cfg.EmailAccounts {
+		if account.Proxy == "" {
+			cfg.EmailAccounts[i].Proxy = cfg.Proxy.URL
+		}
+		preset, ok := LookupPreset(account.Provider)
+		if !ok {
+			continue
+		}
+		for j, mailbox := range account.Mailboxes {
+			if name, ok := preset.Mailboxes[mailbox]; ok {
+				cfg.EmailAccounts[i].Mailboxes[j] = name
+			}
+		}
+		for j, job := range cfg.Cleanup {
+			if name, ok := preset.Mailboxes[job.Mailbox]; ok && job.Account == account.ID {
+				cfg.Cleanup[j].Mailbox = name
+			}
+		}
+	}
+}
+
+// Validate checks the configuration for errors
+func (c *Config) Validate() error {
+	ids := make(map[string]bool)
+	for i, account := range c.EmailAccounts {
+		if account.ID == "" {
+			return fmt.Errorf("account %d: missing ID", i)
+		}
+		if ids[account.ID] {
+			return fmt.Errorf("account %s: duplicate ID", account.ID)
+		}
+		ids[account.ID] = true
+		for _, pattern := range account.Mailboxes {
+			if err := validatePattern(pattern); err != nil {
+				return fmt.Errorf("account %s: %w", account.ID, err)
+			}
+		}
+		for name, value := range account.OutgoingHeaders {
+			if err := validateHeader(name, value); err != nil {
+				return fmt.Errorf("account %s: %w", account.ID, err)
+			}
+		}
+		if l := account.Limits; l.MaxConcurrent < 0 || l.MaxMessages < 0 || l.StoreRate < 0 || l.StoreBurst < 0 {
+			return fmt.Errorf("account %s: limits must not be negative", account.ID)
+		}
+		if f := account.Fetch; f.Window < 0 || f.Workers < 0 || f.MaxMessages < 0 {
+			return fmt.Errorf("account %s: fetch settings must not be negative", account.ID)
+		}
+		if account.Limits.StoreBurst > 0 && account.Limits.StoreRate == 0 {
+			return fmt.Errorf("account %s: StoreBurst requires StoreRate", account.ID)
+		}
+		if err := validatePush(account); err != nil {
+			return fmt.Errorf("account %s: %w", account.ID, err)
+		}
+		if account.Provider == "pop3" {
+			if _, port, err := net.SplitHostPort(account.Server); err != nil || port == "" {
+				return fmt.Errorf("account %s: the pop3 provider needs Server as host:port, got %q", account.ID, account.Server)
+			}
+		}
+		if (account.Provider == "maildir" || account.Provider == "mbox") && account.Path == "" {
+			return fmt.Errorf("account %s: the %s provider needs a Path", account.ID, account.Provider)
+		}
+		if readOnlyProviders[account.Provider] {
+			if err := validateReadOnly(account, c); err != nil {
+				return fmt.Errorf("account %s: %w", account.ID, err)
+			}
+		}
+		if preset, ok := LookupPreset(account.Provider); ok {
+			if err := validatePreset(account, preset, c); err != nil {
+				return fmt.Errorf("account %s: %w", account.ID, err)
+			}
+		}
+		if _, err := account.TLS.Version(); err != nil {
+			return fmt.Errorf("account %s: %w", account.ID, err)
+		}
+		if (account.TLS.CertFile == "") != (account.TLS.KeyFile == "") {
+			return fmt.Errorf("account %s: TLS.CertFile and TLS.KeyFile must be set together", account.ID)
+		}
+		if err := validateProxy(account.Proxy); err != nil {
+			return fmt.Errorf("account %s: %w", account.ID, err)
+		}
+		if account.Provider == "jmap" {
+			if u, err := url.Parse(account.SessionURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				return fmt.Errorf("account %s: the jmap provider needs an http(s) SessionURL", account.ID)
+			}
+		}
+	}
+
+	if cc := c.Poll.Connections; cc.MaxOpen < 0 || cc.MaxIdle < 0 || cc.CheckAfter < 0 || cc.DialAttempts < 0 || cc.DialBackoff < 0 {
+		return fmt.Errorf("Poll.Connections settings must not be negative")
+	}
+	if r := c.Poll.Retry; r.Attempts < 0 || r.Delay < 0 || r.MaxDelay < 0 {
+		return fmt.Errorf("Poll.Retry settings must not be negative")
+	}
+	if c.Poll.Circuit.Failures < 0 || c.Poll.Circuit.CoolDown < 0 {
+		return fmt.Errorf("Poll.Circuit settings must not be negative")
+	}
+	if c.Poll.ConnectTimeout < 0 || c.Poll.FetchTimeout < 0 || c.Poll.ActionTimeout < 0 {
+		return fmt.Errorf("poll timeouts must not be negative")
+	}
+	if c.Poll.Interval < 0 {
+		return fmt.Errorf("poll interval must not be negative")
+	}
+	if a := c.Poll.Adaptive; a.Min < 0 || a.Max < 0 || a.EmptyPolls < 0 {
+		return fmt.Errorf("adaptive polling settings must not be negative")
+	}
+	if a := c.Poll.Adaptive; a.Min > 0 && a.Max > 0 && a.Min > a.Max {
+		return fmt.Errorf("adaptive polling Min must not exceed Max")
+	}
+
+	jobs := make(map[string]bool)
+	for i, job := range c.Cleanup {
+		if job.Name == "" {
+			return fmt.Errorf("cleanup job %d: missing Name", i)
+		}
+		if jobs[job.Name] {
+			return fmt.Errorf("cleanup job %s: duplicate name", job.Name)
+		}
+		jobs[job.Name] = true
+		if !ids[job.Account] {
+			return fmt.Errorf("cleanup job %s: unknown account %q", job.Name, job.Account)
+		}
+		if provider := c.account(job.Account).Provider; readOnlyProviders[provider] {
+			return fmt.Errorf("cleanup job %s: account %s uses %s, which cannot search or change mail", job.Name, job.Account, provider)
+		}
+		switch job.Action {
+		case "remove-label":
+			if job.Label == "" {
+				return fmt.Errorf("cleanup job %s: remove-label requires a Label", job.Name)
+			}
+		case "expunge":
+			// An expunge must name its mailbox, so a forgotten one cannot
+			// empty INBOX
+			if job.Mailbox == "" {
+				return fmt.Errorf("cleanup job %s: expunge requires a Mailbox", job.Name)
+			}
+		default:
+			return fmt.Errorf("cleanup job %s: unknown action %q", job.Name, job.Action)
+		}
+		if job.OlderThan < 0 {
+			return fmt.Errorf("cleanup job %s: OlderThan must not be negative", job.Name)
+		}
+	}
+
+	switch c.Tracing.Protocol {
+	case "", "grpc", "http":
+	default:
+		return fmt.Errorf("unknown tracing protocol %q", c.Tracing.Protocol)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		return fmt.Errorf("tracing SampleRatio must be between 0 and 1")
+	}
+
+	if dsn := c.Errors.SentryDSN; dsn != "" {
+		u, err := url.Parse(dsn)
+		if err != nil || u.User == nil || u.User.Username() == "" || strings.Trim(u.Path, "/") == "" {
+			return fmt.Errorf("invalid Sentry DSN")
+		}
+	}
+	if c.Errors.WebhookURL != "" {
+		if u, err := url.Parse(c.Errors.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid error webhook URL %q", c.Errors.WebhookURL)
+		}
+	}
+	if c.Integrations.CalDAV.URL != "" {
+		if u, err := url.Parse(c.Integrations.CalDAV.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid CalDAV URL %q", c.Integrations.CalDAV.URL)
+		}
+	}
+	if c.Errors.PollFailures < 0 {
+		return fmt.Errorf("Errors.PollFailures must not be negative")
+	}
+
+	switch c.Log.Redact {
+	case "", "none", "hashed", "full":
+	default:
+		return fmt.Errorf("unknown log redaction mode %q", c.Log.Redact)
+	}
+
+	if c.Secrets.Vault.Address != "" && c.Secrets.Vault.Token == "" && c.Secrets.Vault.KubernetesRole == "" {
+		return fmt.Errorf("Secrets.Vault.Address requires a Token or KubernetesRole")
+	}
+
+	switch c.Secrets.TokenFile.Key {
+	case "", "machine", "passphrase":
+	default:
+		return fmt.Errorf("unknown token file key %q", c.Secrets.TokenFile.Key)
+	}
+
+	// The APIs serve message contents and control the daemon, so they are
+	// never open
+	if err := validateProxy(c.Proxy.URL); err != nil {
+		return fmt.Errorf("Proxy: %w", err)
+	}
+	if c.API.Addr != "" && c.API.Token == "" {
+		return fmt.Errorf("API.Addr requires API.Token")
+	}
+	if c.API.GRPCAddr != "" && c.API.Token == "" {
+		return fmt.Errorf("API.GRPCAddr requires API.Token")
+	}
+
+	plugins := make(map[string]bool, len(c.Plugins))
+	for i, plugin := range c.Plugins {
+		if plugin.Name == "" || plugin.Path == "" {
+			return fmt.Errorf("plugin %d: Name and Path are required", i)
+		}
+		if plugins[plugin.Name] {
+			return fmt.Errorf("plugin %d: duplicate name %q", i, plugin.Name)
+		}
+		if builtinActions[plugin.Name] || extensionAction(plugin.Name) {
+			return fmt.Errorf("plugin %d: name %q is taken by an action", i, plugin.Name)
+		}
+		if plugin.Timeout < 0 || plugin.MemoryMB < 0 {
+			return fmt.Errorf("plugin %d: Timeout and MemoryMB must not be negative", i)
+		}
+		plugins[plugin.Name] = true
+	}
+
+	lists := make(map[string]bool, len(c.AddressLists))
+	for i, list := range c.AddressLists {
+		if list.Name == "" {
+			return fmt.Errorf("address list %d: Name is required", i)
+		}
+		if lists[list.Name] {
+			return fmt.Errorf("address list %d: duplicate name %q", i, list.Name)
+		}
+		if len(list.Addresses) == 0 && list.File == "" && list.CardDAV.URL == "" {
+			return fmt.Errorf("address list %s: set Addresses, File or CardDAV.URL", list.Name)
+		}
+		if dav := list.CardDAV.URL; dav != "" {
+			if u, err := url.Parse(dav); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("address list %s: invalid CardDAV URL %q", list.Name, dav)
+			}
+		}
+		if list.Refresh < 0 {
+			return fmt.Errorf("address list %s: Refresh must not be negative", list.Name)
+		}
+		lists[list.Name] = true
+	}
+
+	switch c.Storage.Backend {
+	case "", "sqlite", "bolt", "postgres":
+	default:
+		return fmt.Errorf("unknown Storage.Backend %q", c.Storage.Backend)
+	}
+	if c.HA.Enabled && (c.Storage.Backend != "postgres" || c.Storage.Path == "") {
+		return fmt.Errorf("HA requires the postgres Storage.Backend")
+	}
+	if c.HA.LeaseTTL < 0 {
+		return fmt.Errorf("HA.LeaseTTL must not be negative")
+	}
+	if c.HA.LeaseTTL > 0 && c.HA.LeaseTTL < time.Second {
+		// Leases expire by the database clock, in whole seconds
+		return fmt.Errorf("HA.LeaseTTL must be at least 1s")
+	}
+	if c.HA.LeaseTTL > 0 && c.HA.LeaseTTL <= c.Poll.Interval {
+		return fmt.Errorf("HA.LeaseTTL must be longer than Poll.Interval")
+	}
+	if c.Storage.Archive.Enabled && c.Storage.Path == "" {
+		return fmt.Errorf("Storage.Archive requires Storage.Path")
+	}
+	if c.Storage.Archive.Bodies && !c.Storage.Archive.Enabled {
+		return fmt.Errorf("Storage.Archive.Bodies requires Storage.Archive.Enabled")
+	}
+	if r := c.Storage.Retention; r.Archive < 0 || r.Processed < 0 || r.Events < 0 || r.Interval < 0 {
+		return fmt.Errorf("Storage.Retention ages and Interval must not be negative")
+	}
+
+	if c.Notify.Digest.Window < 0 {
+		return fmt.Errorf("Notify.Digest.Window must not be negative")
+	}
+
+	if c.Integrations.Exec.MaxConcurrent < 0 {
+		return fmt.Errorf("Integrations.Exec.MaxConcurrent must not be negative")
+	}
+	for i, rule := range c.Poll.Rules {
+		if err := c.ValidateRule(i, rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	// pubSubTopic and pubSubSubscription match fully qualified Pub/Sub
+	// resource names
+	pubSubTopic        = regexp.MustCompile(`^projects/[^/]+/topics/[^/]+$`)
+	pubSubSubscription = regexp.MustCompile(`^projects/[^/]+/subscriptions/[^/]+$`)
+)
+
+var (
+	extensionsMu sync.RWMutex
+	extensions   = make(map[string]bool) // Actions registered by extensions
+)
+
+// RegisterAction makes Validate accept rules with an action an extension
+// registered with the actions package. Validate leaves the action's Params
+// to the extension.
+func RegisterAction(name string) {
+	extensionsMu.Lock()
+	defer extensionsMu.Unlock()
+	extensions[name] = true
+}
+
+// extensionAction reports whether an extension registered the action
+func extensionAction(name string) bool {
+	extensionsMu.RLock()
+	defer extensionsMu.RUnlock()
+	return extensions[name]
+}
+
+// labels reports whether any step of the rule is a label action
+func labels(rule Rule) bool {
+	for _, step := range Steps(rule) {
+		if step.Action == "label" || step.Action == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateRule checks rule i against the rest of the config
+func (c *Config) ValidateRule(i int, rule Rule) error {
+	if len(rule.Actions) > 0 && rule.Action != "" {
+		return fmt.Errorf("rule %d: set Action or Actions, not both", i)
+	}
+	if rule.Script != "" && (rule.Action != "" || len(rule.Actions) > 0) {
+		return fmt.Errorf("rule %d: a rule with a Script takes its actions from it, not Action or Actions", i)
+	}
+	for _, a := range rule.Actions {
+		switch a.OnError {
+		case "", "abort", "continue":
+		default:
+			return fmt.Errorf("rule %d: unknown OnError %q", i, a.OnError)
+		}
+	}
+	for _, step := range Steps(rule) {
+		if err := c.validateAction(i, step); err != nil {
+			return err
+		}
+	}
+	switch rule.PushPriority {
+	case "", "min", "low", "default", "high", "urgent":
+	default:
+		return fmt.Errorf("rule %d: unknown PushPriority %q", i, rule.PushPriority)
+	}
+	if rule.SampleRate < 0 || rule.SampleRate > 1 {
+		return fmt.Errorf("rule %d: SampleRate must be between 0 and 1", i)
+	}
+	if rule.SampleEvery < 0 {
+		return fmt.Errorf("rule %d: SampleEvery must not be negative", i)
+	}
+	if rule.SampleRate > 0 && rule.SampleEvery > 0 {
+		return fmt.Errorf("rule %d: set SampleRate or SampleEvery, not both", i)
+	}
+	if rule.Mailbox != "" {
+		if err := validatePattern(rule.Mailbox); err != nil {
+			return fmt.Errorf("rule %d: %w", i, err)
+		}
+	}
+	if rule.Plugin != "" && !c.hasPlugin(rule.Plugin) {
+		return fmt.Errorf("rule %d: unknown plugin %q", i, rule.Plugin)
+	}
+	for _, name := range []string{rule.FromInList, rule.FromNotInList} {
+		if name != "" && !c.hasAddressList(name) {
+			return fmt.Errorf("rule %d: unknown address list %q", i, name)
+		}
+	}
+	if _, err := path.Match(rule.AttachmentNameMatches, ""); err != nil {
+		return fmt.Errorf("rule %d: invalid AttachmentNameMatches %q: %w", i, rule.AttachmentNameMatches, err)
+	}
+	for _, t := range rule.AttachmentTypeIn {
+		if !strings.Contains(t, "/") {
+			return fmt.Errorf("rule %d: AttachmentTypeIn entry %q is not a content type", i, t)
+		}
+	}
+	if rule.AttachmentLargerThan < 0 {
+		return fmt.Errorf("rule %d: AttachmentLargerThan must not be negative", i)
+	}
+	if rule.LargerThan < 0 || rule.SmallerThan < 0 || rule.OlderThan < 0 || rule.NewerThan < 0 {
+		return fmt.Errorf("rule %d: size and age conditions must not be negative", i)
+	}
+	if (rule.ThreadAlreadyLabeled != "" || rule.ApplyToThread) && c.Storage.Path == "" {
+		return fmt.Errorf("rule %d: ThreadAlreadyLabeled and ApplyToThread require Storage.Path", i)
+	}
+	if rule.ApplyToThread && rule.Script == "" && !labels(rule) {
+		return fmt.Errorf("rule %d: ApplyToThread requires a label action", i)
+	}
+	for _, h := range rule.HeaderMatches {
+		if h.Name == "" || strings.ContainsAny(h.Name, ": \t") {
+			return fmt.Errorf("rule %d: invalid HeaderMatches name %q", i, h.Name)
+		}
+		if _, err := regexp.Compile(h.Regex); err != nil {
+			return fmt.Errorf("rule %d: invalid HeaderMatches regex for %s: %w", i, h.Name, err)
+		}
+	}
+	if rule.SmallerThan > 0 && rule.LargerThan >= rule.SmallerThan {
+		return fmt.Errorf("rule %d: LargerThan must be below SmallerThan", i)
+	}
+	if rule.NewerThan > 0 && rule.OlderThan >= rule.NewerThan {
+		return fmt.Errorf("rule %d: OlderThan must be below NewerThan", i)
+	}
+	return nil
+}
+
+// builtinActions are the actions go-tsk implements itself
+var builtinActions = map[string]bool{
+	"label": true, "notify": true, "digest": true, "snooze": true, "remind-if-no-reply": true, "add-to-calendar": true, "unsubscribe": true,
+	"create-task": true, "create-issue": true, "create-jira": true, "webhook": true, "ntfy": true, "pushover": true,
+	"notify-desktop": true, "archive": true, "exec": true,
+}
+
+// hasPlugin reports whether a plugin of the name is configured
+func (c *Config) hasPlugin(name string) bool {
+	for _, plugin := range c.Plugins {
+		if plugin.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAddressList reports whether an address list of the name is configured
+func (c *Config) hasAddressList(name string) bool {
+	for _, list := range c.AddressLists {
+		if list.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// validateAction checks the settings of the action of rule i, or of one
+// step of its chain
+func (c *Config) validateAction(i int, rule Rule) error {
+	switch rule.Action {
+	case "label", "":
+		if rule.Label == "" {
+			return fmt.Errorf("rule %d: label action requires a label", i)
+		}
+	case "notify", "digest":
+	case "create-task":
+		switch rule.TaskTarget {
+		case "local", "":
+			if c.Storage.Path == "" {
+				return fmt.Errorf("rule %d: create-task action requires Storage.Path", i)
+			}
+		case "todoist":
+			if c.Integrations.Todoist.Token == "" {
+				return fmt.Errorf("rule %d: todoist task target requires Integrations.Todoist.Token", i)
+			}
+		default:
+			return fmt.Errorf("rule %d: unknown task target %q", i, rule.TaskTarget)
+		}
+		if rule.DueIn < 0 {
+			return fmt.Errorf("rule %d: DueIn must not be negative", i)
+		}
+	case "create-issue":
+		if c.Integrations.GitHub.Token == "" {
+			return fmt.Errorf("rule %d: create-issue action requires Integrations.GitHub.Token", i)
+		}
+		if owner, name, ok := strings.Cut(rule.Repo, "/"); !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+			return fmt.Errorf("rule %d: Repo must be owner/name, got %q", i, rule.Repo)
+		}
+	case "create-jira":
+		if c.Integrations.Jira.BaseURL == "" || c.Integrations.Jira.Token == "" {
+			return fmt.Errorf("rule %d: create-jira action requires Integrations.Jira.BaseURL and Token", i)
+		}
+		if rule.JiraProject == "" {
+			return fmt.Errorf("rule %d: create-jira action requires JiraProject", i)
+		}
+		for field := range rule.JiraFields {
+			if reservedJiraFields[field] {
+				return fmt.Errorf("rule %d: Jira field %q is set by the action; use TitleTemplate or BodyTemplate", i, field)
+			}
+		}
+	case "webhook":
+		if u, err := url.Parse(rule.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("rule %d: webhook action requires an http(s) WebhookURL, got %q", i, rule.WebhookURL)
+		}
+		for name, value := range rule.WebhookHeaders {
+			if name == "" || strings.ContainsAny(name, " :\r\n") || strings.ContainsAny(value, "\r\n") {
+				return fmt.Errorf("rule %d: invalid webhook header %q", i, name)
+			}
+		}
+		for _, secret := range rule.WebhookSecrets {
+			if secret == "" {
+				return fmt.Errorf("rule %d: webhook secrets must not be empty", i)
+			}
+		}
+		if rule.WebhookRetries < 0 || rule.WebhookRetries > maxWebhookRetries {
+			return fmt.Errorf("rule %d: WebhookRetries must be between 0 and %d", i, maxWebhookRetries)
+		}
+		if rule.WebhookTimeout < 0 {
+			return fmt.Errorf("rule %d: WebhookTimeout must not be negative", i)
+		}
+	case "ntfy":
+		if rule.NtfyTopic == "" {
+			return fmt.Errorf("rule %d: ntfy action requires NtfyTopic", i)
+		}
+	case "pushover":
+		if c.Integrations.Pushover.AppToken == "" || c.Integrations.Pushover.UserKey == "" {
+			return fmt.Errorf("rule %d: pushover action requires Integrations.Pushover.AppToken and UserKey", i)
+		}
+	case "notify-desktop":
+	case "snooze":
+		if rule.SnoozeFor <= 0 {
+			return fmt.Errorf("rule %d: snooze action requires a positive SnoozeFor", i)
+		}
+		if c.Storage.Path == "" {
+			return fmt.Errorf("rule %d: snooze action requires Storage.Path", i)
+		}
+	case "remind-if-no-reply":
+		if rule.RemindAfter <= 0 {
+			return fmt.Errorf("rule %d: remind-if-no-reply action requires a positive RemindAfter", i)
+		}
+		if c.Storage.Path == "" {
+			return fmt.Errorf("rule %d: remind-if-no-reply action requires Storage.Path", i)
+		}
+		switch rule.RemindWith {
+		case "", "notify":
+		case "create-task":
+			reminder := rule
+			reminder.Action = rule.RemindWith
+			if err := c.validateAction(i, reminder); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("rule %d: unknown RemindWith %q", i, rule.RemindWith)
+		}
+	case "unsubscribe":
+		// Lists without one-click unsubscription get a task instead
+		fallback := rule
+		fallback.Action = "create-task"
+		if err := c.validateAction(i, fallback); err != nil {
+			return err
+		}
+	case "add-to-calendar":
+		if rule.CalendarDir == "" && c.Integrations.CalDAV.URL == "" {
+			return fmt.Errorf("rule %d: add-to-calendar action requires CalendarDir or Integrations.CalDAV.URL", i)
+		}
+	case "archive":
+		if rule.ArchiveDir == "" {
+			return fmt.Errorf("rule %d: archive action requires ArchiveDir", i)
+		}
+	case "exec":
+		if len(rule.ExecCommand) == 0 || rule.ExecCommand[0] == "" {
+			return fmt.Errorf("rule %d: exec action requires ExecCommand", i)
+		}
+		if rule.ExecTimeout < 0 {
+			return fmt.Errorf("rule %d: ExecTimeout must not be negative", i)
+		}
+	default:
+		if !extensionAction(rule.Action) && !c.hasPlugin(rule.Action) {
+			return fmt.Errorf("rule %d: unknown action %q", i, rule.Action)
+		}
+	}
+	return nil
+}
+
+// serverActions are the rule actions that change mail in the mailbox
+var serverActions = map[string]bool{"label": true, "snooze": true, "": true}
+
+// changesMail reports whether any action of a rule changes mail. Scripts
+// are given the benefit of the doubt, as their actions are only known when
+// they run.
+func changesMail(rule Rule) bool {
+	for _, step := range Steps(rule) {
+		if serverActions[step.Action] {
+			return true
+		}
+	}
+	return false
+}
+
+// readOnlyProviders only read the inbox and cannot change mail: pop3
+// downloads from a server and mbox reads a local file other programs
+// deliver to
+var readOnlyProviders = map[string]bool{"pop3": true, "mbox": true}
+
+// validateReadOnly checks an account of a read-only provider. Rules that
+// apply to its inbox must stick to local actions.
+func validateReadOnly(account EmailAccount, c *Config) error {
+	for _, mailbox := range account.Mailboxes {
+		if mailbox != "INBOX" {
+			return fmt.Errorf("%s only has INBOX, not %q", account.Provider, mailbox)
+		}
+	}
+	for i, rule := range c.Poll.Rules {
+		if !changesMail(rule) {
+			continue
+		}
+		if matched, _ := path.Match(rule.Mailbox, "INBOX"); rule.Mailbox == "" || matched {
+			return fmt.Errorf("rule %d labels or moves messages, which %s cannot; "+
+				"restrict it to other mailboxes or use local actions such as create-task, notify or archive", i, account.Provider)
+		}
+	}
+	return nil
+}
+
+// validatePreset checks the credentials of an account of a preset
+// provider. These services refuse the account password over IMAP, and
+// users rarely know that, so the errors say where to get an app password.
+func validatePreset(account EmailAccount, preset Preset, c *Config) error {
+	if account.Address == "" {
+		return fmt.Errorf("%s needs the account's Address to log in", preset.Name)
+	}
+	switch {
+	case account.ClientID != "" && preset.AuthURL == "":
+		return fmt.Errorf("%s has no OAuth sign-in for IMAP; remove ClientID and put an app password "+
+			"in Password, created under %s", preset.Name, preset.AppPassword)
+	case account.Password != "" && account.ClientID != "":
+		return fmt.Errorf("set Password for an app password or ClientID for OAuth, not both")
+	case account.Password == "" && account.ClientID == "":
+		return fmt.Errorf("%s needs an app password in Password; create one under %s, "+
+			"as the account password does not work over IMAP", preset.Name, preset.AppPassword)
+	case account.ClientID != "" && account.Token == "" && c.Secrets.TokenFile.Path == "":
+		return fmt.Errorf("%s OAuth needs a Token, or Secrets.TokenFile and the auth command; "+
+			"without an OAuth client approved for mail, use an app password from %s instead",
+			preset.Name, preset.AppPassword)
+	}
+	return nil
+}
+
+// account returns the account with the given ID
+func (c *Config) account(id string) EmailAccount {
+	for _, account := range c.EmailAccounts {
+		if account.ID == id {
+			return account
+		}
+	}
+	return EmailAccount{}
+}
+
+// validatePush checks the Gmail push settings of an account
+func validatePush(account EmailAccount) error {
+	push := account.Push
+	if push.Topic == "" && push.Subscription == "" {
+		return nil
+	}
+	if account.Provider != "gmailapi" {
+		return fmt.Errorf("Push requires the gmailapi provider")
+	}
+	if !pubSubTopic.MatchString(push.Topic) {
+		return fmt.Errorf("Push topic must look like projects/PROJECT/topics/TOPIC, got %q", push.Topic)
+	}
+	if !pubSubSubscription.MatchString(push.Subscription) {
+		return fmt.Errorf("Push subscription must look like projects/PROJECT/subscriptions/SUBSCRIPTION, got %q", push.Subscription)
+	}
+	if push.FallbackInterval < 0 {
+		return fmt.Errorf("Push fallback interval must not be negative")
+	}
+	return nil
+}
+
+// validatePattern checks that a mailbox name or pattern is usable with
+// path.Match
+func validatePattern(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("empty mailbox name")
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid mailbox pattern %q: %w", pattern, err)
+	}
+	return nil
+}
+
+// reservedHeaders are set by the code composing a message and cannot be
+// overridden from the config
+var reservedHeaders = map[string]bool{
+	"From": true, "To": true, "Cc": true, "Bcc": true, "Subject": true, "Date": true,
+	"Message-Id": true, "In-Reply-To": true, "References": true, "Mime-Version": true,
+	"Content-Type": true, "Content-Transfer-Encoding": true,
+}
+
+// reservedJiraFields are filled in by the create-jira action itself
+var reservedJiraFields = map[string]bool{
+	"project": true, "issuetype": true, "summary": true, "description": true,
+}
+
+// validateHeader checks that an outgoing header is a well-formed field that
+// the config may set
+func validateHeader(name, value string) error {
+	if name == "" {
+		return fmt.Errorf("empty header name")
+	}
+	for _, c := range name {
+		if c < '!' || c > '~' || c == ':' {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+	}
+	if reservedHeaders[textproto.CanonicalMIMEHeaderKey(name)] {
+		return fmt.Errorf("header %s cannot be set in the config", name)
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("header %s: value must be a single line", name)
+	}
+	return nil
+}
+
+// normalizeDurations walks a decoded JSON value alongside the Go type it
+// will be decoded into and converts duration strings to nanoseconds
+func normalizeDurations(v interface{}, t reflect.Type, path string) (interface{}, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == durationType {
+		s, ok := v.(string)
+		if !ok {
+			return v, nil
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid duration %q", path, s)
+		}
+		return int64(d), nil
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return v, nil
+		}
+		for key, value := range obj {
+			field, ok := fieldByJSONName(t, key)
+			if !ok {
+				continue
+			}
+			normalized, err := normalizeDurations(value, field.Type, joinPath(path, field.Name))
+			if err != nil {
+				return nil, err
+			}
+			obj[key] = normalized
+		}
+	case reflect.Slice, reflect.Array:
+		arr, ok := v.([]interface{})
+		if !ok {
+			return v, nil
+		}
+		for i, value := range arr {
+			normalized, err := normalizeDurations(value, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			arr[i] = normalized
+		}
+	case reflect.Map:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return v, nil
+		}
+		for key, value := range obj {
+			normalized, err := normalizeDurations(value, t.Elem(), joinPath(path, key))
+			if err != nil {
+				return nil, err
+			}
+			obj[key] = normalized
+		}
+	}
+	return v, nil
+}
+
+// fieldByJSONName finds the struct field encoding/json would decode key into
+func fieldByJSONName(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := field.Name
+		if tag := field.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if n := strings.Split(tag, ",")[0]; n != "" {
+				name = n
+			}
+		}
+		if strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// joinPath builds a dotted path for error messages
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,53 @@
+package config
+
+// Preset holds what go-tsk knows about a mail service, so its accounts only
+// need Provider set to the preset's name and their credentials
+type Preset struct {
+	Name        string   // Name of the service in messages
+	Server      string   // IMAP server as host:port, with implicit TLS
+	AuthURL     string   // OAuth2 authorization endpoint
+	TokenURL    string   // OAuth2 token endpoint
+	Scopes      []string // OAuth2 scopes granting IMAP access
+	AppPassword string   // Where users create app passwords, for error messages
+
+	// Mailboxes maps common mailbox names to the service's names for them,
+	// for accounts that configure the common ones
+	Mailboxes map[string]string
+}
+
+// presets are the services accounts can name as their Provider
+var presets = map[string]Preset{
+	"yahoo": {
+		Name:        "Yahoo Mail",
+		Server:      "imap.mail.yahoo.com:993",
+		AuthURL:     "https://api.login.yahoo.com/oauth2/request_auth",
+		TokenURL:    "https://api.login.yahoo.com/oauth2/get_token",
+		Scopes:      []string{"mail-w"},
+		AppPassword: `"Generate app password" at https://login.yahoo.com/account/security`,
+	},
+	"aol": {
+		Name:        "AOL Mail",
+		Server:      "imap.aol.com:993",
+		AuthURL:     "https://api.login.aol.com/oauth2/request_auth",
+		TokenURL:    "https://api.login.aol.com/oauth2/get_token",
+		Scopes:      []string{"mail-w"},
+		AppPassword: `"Generate app password" at https://login.aol.com/account/security`,
+	},
+	"icloud": {
+		// iCloud has no OAuth for IMAP, only app-specific passwords
+		Name:        "iCloud Mail",
+		Server:      "imap.mail.me.com:993",
+		AppPassword: `"App-Specific Passwords" at https://account.apple.com`,
+		Mailboxes: map[string]string{
+			"Sent":  "Sent Messages",
+			"Trash": "Deleted Messages",
+			"Spam":  "Junk",
+		},
+	},
+}
+
+// LookupPreset returns the preset named by an account's Provider
+func LookupPreset(provider string) (Preset, bool) {
+	p, ok := presets[provider]
+	return p, ok
+}
@@ -0,0 +1,81 @@
+// Package contacts holds the address lists that rules check senders
+// against, such as allowlists of VIPs or denylists of pests.
+package contacts
+
+import (
+	"bufio"
+	"net/mail"
+	"os"
+	"strings"
+)
+
+// List is a set of addresses and domains. Matching ignores case.
+type List struct {
+	addresses map[string]bool
+	domains   map[string]bool
+}
+
+// NewList creates a list of entries, each an address or "@domain" for
+// everyone at the domain. Blank entries are skipped.
+func NewList(entries []string) *List {
+	l := &List{addresses: make(map[string]bool), domains: make(map[string]bool)}
+	for _, e := range entries {
+		e = strings.ToLower(strings.TrimSpace(e))
+		switch {
+		case e == "" || e == "@":
+		case strings.HasPrefix(e, "@"):
+			l.domains[e[1:]] = true
+		default:
+			l.addresses[Address(e)] = true
+		}
+	}
+	return l
+}
+
+// Len returns the number of entries
+func (l *List) Len() int {
+	return len(l.addresses) + len(l.domains)
+}
+
+// Contains reports whether the list has the sender of a From value, such
+// as "Jane Doe <jane@example.com>", or its domain
+func (l *List) Contains(from string) bool {
+	addr := Address(from)
+	if addr == "" {
+		return false
+	}
+	if l.addresses[addr] {
+		return true
+	}
+	_, domain, ok := strings.Cut(addr, "@")
+	return ok && l.domains[domain]
+}
+
+// Address returns the bare address of a From value in lower case, or the
+// trimmed value itself if it does not parse
+func Address(from string) string {
+	if a, err := mail.ParseAddress(from); err == nil {
+		return strings.ToLower(a.Address)
+	}
+	return strings.ToLower(strings.Trim(strings.TrimSpace(from), "<>"))
+}
+
+// ReadFile reads the entries of a list file: one per line, with blank
+// lines and everything after a "#" ignored
+func ReadFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if line = strings.TrimSpace(line); line != "" {
+			entries = append(entries, line)
+		}
+	}
+	return entries, scanner.Err()
+}
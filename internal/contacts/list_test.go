@@ -0,0 +1,50 @@
+package contacts
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestListContains(t *testing.T) {
+	l := NewList([]string{"Boss@Example.com", "@vip.example.org", " ", "Jane Doe <jane@example.net>"})
+	if l.Len() != 3 {
+		t.Errorf("Len() = %d; want 3", l.Len())
+	}
+	tests := []struct {
+		from string
+		want bool
+	}{
+		{"boss@example.com", true},
+		{"The Boss <BOSS@example.com>", true},
+		{"jane@example.net", true},
+		{"anyone@vip.example.org", true},
+		{"someone@sub.vip.example.org", false},
+		{"intern@example.com", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := l.Contains(tt.from); got != tt.want {
+			t.Errorf("Contains(%q) = %v; want %v", tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vips.txt")
+	data := "# Management\nboss@example.com\n\n@vip.example.org  # partners\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if want := []string{"boss@example.com", "@vip.example.org"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadFile() = %q; want %q", got, want)
+	}
+	if _, err := ReadFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("ReadFile() of a missing file succeeded")
+	}
+}
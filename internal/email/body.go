@@ -0,0 +1,203 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	netmail "net/mail"
+	"net/textproto"
+	"strings"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+
+	// Register decoders for non-UTF-8 charsets
+	_ "github.com/emersion/go-message/charset"
+)
+
+// maxBodyPart bounds how much of each text part is kept, so one huge
+// message cannot exhaust memory
+const maxBodyPart = 1 << 20
+
+// parseBody decodes a full RFC 5322 message and returns its first
+// text/plain and text/html parts
+func parseBody(r io.Reader) (text, html string, err error) {
+	m, err := parseMessage(r)
+	return m.TextBody, m.HTMLBody, err
+}
+
+// readMessage decodes a raw message downloaded whole, with parseMessage if
+// full is set and its header only otherwise, and fills in the Email header
+// fields. A malformed body must not hide the message from the rules, so
+// decoding errors are logged against name and the message is returned
+// anyway.
+func readMessage(r io.Reader, full bool, name string) *Message {
+	msg := &Message{}
+	if full {
+		var err error
+		if msg, err = parseMessage(r); err != nil {
+			log.Printf("Failed to decode message %s: %v", name, err)
+		}
+	} else {
+		msg.Header = readHeader(r)
+	}
+	setHeaderFields(msg)
+	return msg
+}
+
+// setHeaderFields fills in the Email fields taken from a message's decoded
+// header
+func setHeaderFields(msg *Message) {
+	msg.MessageID = strings.TrimSpace(msg.Header.Get("Message-Id"))
+	msg.Subject = msg.Header.Get("Subject")
+	msg.From = msg.Header.Get("From")
+	msg.To, msg.Cc = addressList(msg.Header.Get("To")), addressList(msg.Header.Get("Cc"))
+	msg.Date, _ = netmail.ParseDate(msg.Header.Get("Date"))
+	msg.References = references(msg.Header.Get("In-Reply-To"), msg.Header.Get("References"))
+	msg.ThreadID = threadID(msg.MessageID, msg.Header)
+	classify(&msg.Email, msg.Header)
+}
+
+// readHeader reads and decodes a message header, keeping the fields read
+// before any syntax error
+func readHeader(r io.Reader) textproto.MIMEHeader {
+	raw, _ := textproto.NewReader(bufio.NewReader(r)).ReadMIMEHeader()
+	header := make(textproto.MIMEHeader, len(raw))
+	for key, values := range raw {
+		for _, v := range values {
+			header.Add(key, decodeHeader(v))
+		}
+	}
+	return header
+}
+
+// parseMessage decodes a full RFC 5322 message into its header fields, its
+// first text/plain and text/html parts and its attachment list. Transfer
+// encodings and charsets are decoded. Parts in a charset without a decoder
+// are kept undecoded rather than failing the whole message. The returned
+// message is never nil and holds whatever was decoded before an error.
+func parseMessage(r io.Reader) (*Message, error) {
+	m := &Message{Email: Email{Header: make(textproto.MIMEHeader)}}
+	mr, err := mail.CreateReader(r)
+	if err != nil && !message.IsUnknownCharset(err) {
+		return m, fmt.Errorf("failed to parse message: %w", err)
+	}
+	defer mr.Close()
+
+	fields := mr.Header.Fields()
+	for fields.Next() {
+		m.Header.Add(fields.Key(), decodeHeader(fields.Value()))
+	}
+
+	for {
+		p, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil && !message.IsUnknownCharset(err) {
+			return m, fmt.Errorf("failed to read message part: %w", err)
+		}
+
+		switch h := p.Header.(type) {
+		case *mail.InlineHeader:
+			contentType, _, err := h.ContentType()
+			if err != nil {
+				continue
+			}
+			switch strings.ToLower(contentType) {
+			case calendarType:
+				m.HasCalendar = true
+			case "text/plain":
+				if m.TextBody == "" {
+					if m.TextBody, err = readPart(p.Body); err != nil {
+						return m, err
+					}
+				}
+			case "text/html":
+				if m.HTMLBody == "" {
+					if m.HTMLBody, err = readPart(p.Body); err != nil {
+						return m, err
+					}
+				}
+			}
+		case *mail.AttachmentHeader:
+			filename, _ := h.Filename()
+			contentType, _, _ := h.ContentType()
+			if strings.EqualFold(contentType, calendarType) {
+				m.HasCalendar = true
+			}
+			// A damaged attachment is still listed, with the size decoded
+			// before the damage
+			size, _ := io.Copy(io.Discard, p.Body)
+			m.Attachments = append(m.Attachments, Attachment{
+				Filename:    filename,
+				ContentType: strings.ToLower(contentType),
+				Size:        size,
+			})
+		}
+	}
+	return m, nil
+}
+
+// decodeHeader decodes RFC 2047 encoded words in a header value, keeping
+// the raw value if they cannot be decoded
+func decodeHeader(v string) string {
+	dec := mime.WordDecoder{CharsetReader: message.CharsetReader}
+	decoded, err := dec.DecodeHeader(v)
+	if err != nil {
+		return v
+	}
+	return decoded
+}
+
+// readPart reads up to maxBodyPart bytes of a decoded part
+func readPart(r io.Reader) (string, error) {
+	b, err := io.ReadAll(io.LimitReader(r, maxBodyPart))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode message part: %w", err)
+	}
+	return string(b), nil
+}
+
+// calendarType is the content type of calendar invites
+const calendarType = "text/calendar"
+
+// CalendarParts returns the decoded text/calendar parts of a raw message,
+// inline or attached, in message order
+func CalendarParts(raw []byte) ([][]byte, error) {
+	mr, err := mail.CreateReader(bytes.NewReader(raw))
+	if err != nil && !message.IsUnknownCharset(err) {
+		return nil, fmt.Errorf("failed to parse message: %w", err)
+	}
+	defer mr.Close()
+
+	var parts [][]byte
+	for {
+		p, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return parts, nil
+		}
+		if err != nil && !message.IsUnknownCharset(err) {
+			return parts, fmt.Errorf("failed to read message part: %w", err)
+		}
+		var contentType string
+		switch h := p.Header.(type) {
+		case *mail.InlineHeader:
+			contentType, _, _ = h.ContentType()
+		case *mail.AttachmentHeader:
+			contentType, _, _ = h.ContentType()
+		}
+		if !strings.EqualFold(contentType, calendarType) {
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(p.Body, maxBodyPart))
+		if err != nil {
+			return parts, fmt.Errorf("failed to decode calendar part: %w", err)
+		}
+		parts = append(parts, data)
+	}
+}
@@ -0,0 +1,169 @@
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBody(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		text string
+		html string
+	}{
+		{
+			"plain",
+			"Subject: hi\r\nContent-Type: text/plain; charset=utf-8\r\n\r\nHello there",
+			"Hello there",
+			"",
+		},
+		{
+			"no content type",
+			"Subject: hi\r\n\r\nJust text",
+			"Just text",
+			"",
+		},
+		{
+			"quoted-printable latin1",
+			"Content-Type: text/plain; charset=iso-8859-1\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nCaf=E9 =\r\nau lait",
+			"Café au lait",
+			"",
+		},
+		{
+			"alternative",
+			"Content-Type: multipart/alternative; boundary=b1\r\n\r\n" +
+				"--b1\r\nContent-Type: text/plain\r\n\r\nplain part\r\n" +
+				"--b1\r\nContent-Type: text/html\r\nContent-Transfer-Encoding: base64\r\n\r\nPHA+aHRtbCBwYXJ0PC9wPg==\r\n" +
+				"--b1--\r\n",
+			"plain part",
+			"<p>html part</p>",
+		},
+		{
+			"nested with attachment",
+			"Content-Type: multipart/mixed; boundary=outer\r\n\r\n" +
+				"--outer\r\nContent-Type: multipart/alternative; boundary=inner\r\n\r\n" +
+				"--inner\r\nContent-Type: text/plain\r\n\r\nbody text\r\n" +
+				"--inner--\r\n" +
+				"--outer\r\nContent-Type: text/plain\r\nContent-Disposition: attachment; filename=notes.txt\r\n\r\nattached text\r\n" +
+				"--outer--\r\n",
+			"body text",
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, html, err := parseBody(strings.NewReader(tt.raw))
+			if err != nil {
+				t.Fatalf("parseBody() error = %v", err)
+			}
+			if text != tt.text {
+				t.Errorf("text = %q; want %q", text, tt.text)
+			}
+			if html != tt.html {
+				t.Errorf("html = %q; want %q", html, tt.html)
+			}
+		})
+	}
+}
+
+func TestParseMessage(t *testing.T) {
+	raw := "From: b@example.com\r\nSubject: =?utf-8?q?R=C3=A9sum=C3=A9?=\r\nX-Priority: 1\r\n" +
+		"Content-Type: multipart/mixed; boundary=outer\r\n\r\n" +
+		"--outer\r\nContent-Type: text/plain\r\n\r\nSee attached\r\n" +
+		"--outer\r\nContent-Type: application/pdf\r\nContent-Disposition: attachment; filename=cv.pdf\r\nContent-Transfer-Encoding: base64\r\n\r\nJVBERi0xLjQ=\r\n" +
+		"--outer--\r\n"
+
+	m, err := parseMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("parseMessage() error = %v", err)
+	}
+	if got := m.Header.Get("Subject"); got != "Résumé" {
+		t.Errorf("Subject header = %q; want decoded %q", got, "Résumé")
+	}
+	if got := m.Header.Get("X-Priority"); got != "1" {
+		t.Errorf("X-Priority header = %q", got)
+	}
+	if m.TextBody != "See attached" {
+		t.Errorf("TextBody = %q", m.TextBody)
+	}
+	want := []Attachment{{Filename: "cv.pdf", ContentType: "application/pdf", Size: 8}}
+	if !reflect.DeepEqual(m.Attachments, want) {
+		t.Errorf("Attachments = %+v; want %+v", m.Attachments, want)
+	}
+}
+
+func TestCalendarParts(t *testing.T) {
+	invite := "BEGIN:VCALENDAR\r\nMETHOD:REQUEST\r\nEND:VCALENDAR\r\n"
+	raw := "From: a@example.com\r\nSubject: Invitation: Sync\r\n" +
+		"Content-Type: multipart/mixed; boundary=outer\r\n\r\n" +
+		"--outer\r\nContent-Type: multipart/alternative; boundary=inner\r\n\r\n" +
+		"--inner\r\nContent-Type: text/plain\r\n\r\nYou are invited\r\n" +
+		"--inner\r\nContent-Type: text/calendar; charset=utf-8; method=REQUEST\r\n\r\n" + invite +
+		"--inner--\r\n" +
+		"--outer\r\nContent-Type: text/calendar; name=invite.ics\r\nContent-Disposition: attachment; filename=invite.ics\r\n" +
+		"Content-Transfer-Encoding: base64\r\n\r\n" + base64.StdEncoding.EncodeToString([]byte(invite)) + "\r\n" +
+		"--outer--\r\n"
+
+	parts, err := CalendarParts([]byte(raw))
+	if err != nil {
+		t.Fatalf("CalendarParts() error = %v", err)
+	}
+	// The line break before a boundary belongs to the boundary
+	if len(parts) != 2 || string(parts[0]) != strings.TrimSpace(invite) || string(parts[1]) != invite {
+		t.Errorf("CalendarParts() = %q; want the inline and the attached invite", parts)
+	}
+	if m, _ := parseMessage(strings.NewReader(raw)); !m.HasCalendar {
+		t.Error("HasCalendar = false for a message with an invite")
+	}
+
+	parts, err = CalendarParts([]byte("Subject: hi\r\n\r\nHello"))
+	if err != nil || len(parts) != 0 {
+		t.Errorf("CalendarParts() of a plain message = %q, %v; want none", parts, err)
+	}
+}
+
+func FuzzParseBody(f *testing.F) {
+	f.Add([]byte("Subject: hi\r\n\r\nHello"))
+	f.Add([]byte("Content-Type: text/plain; charset=iso-8859-1\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nCaf=E9"))
+	f.Add([]byte("Content-Type: multipart/alternative; boundary=b\r\n\r\n--b\r\nContent-Type: text/html\r\nContent-Transfer-Encoding: base64\r\n\r\nPHA+PC9wPg==\r\n--b--\r\n"))
+	f.Add([]byte("Content-Type: multipart/mixed; boundary=\"\"\r\n\r\n--\r\n"))
+	f.Add([]byte("Content-Type: text/plain; charset=x-unknown\r\n\r\n\xff\xfe"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		text, html, _ := parseBody(strings.NewReader(string(data)))
+		if len(text) > maxBodyPart || len(html) > maxBodyPart {
+			t.Errorf("parseBody() kept %d/%d bytes; want at most %d", len(text), len(html), maxBodyPart)
+		}
+	})
+}
+
+func FuzzReadMessage(f *testing.F) {
+	f.Add([]byte("Message-Id: <a@b>\r\nSubject: =?iso-8859-1?q?Caf=E9?=\r\nTo: a@b, \"C\" <c@d>\r\nReferences: <x@y> <z@w>\r\n\r\nHello"), true)
+	f.Add([]byte("Subject: =?x-unknown?b?////?=\r\nDate: not a date\r\nAuthentication-Results: mx; dkim=pass; spf=\r\n\r\n"), false)
+	f.Add([]byte("To: <<<\r\nCc: ,,\r\nIn-Reply-To: <\r\n"), true)
+
+	f.Fuzz(func(t *testing.T, data []byte, full bool) {
+		if msg := readMessage(bytes.NewReader(data), full, "fuzz"); msg == nil {
+			t.Error("readMessage() = nil")
+		}
+	})
+}
+
+func FuzzCalendarParts(f *testing.F) {
+	f.Add([]byte("Content-Type: text/calendar; method=REQUEST\r\n\r\nBEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n"))
+	f.Add([]byte("Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\nContent-Type: text/calendar\r\nContent-Disposition: attachment; filename=invite.ics\r\nContent-Transfer-Encoding: base64\r\n\r\n!!!\r\n--b--\r\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parts, _ := CalendarParts(data)
+		for _, p := range parts {
+			if len(p) > maxBodyPart {
+				t.Errorf("CalendarParts() kept %d bytes; want at most %d", len(p), maxBodyPart)
+			}
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package email
+
+import (
+	"net/textproto"
+	"strings"
+)
+
+// classifyingFields are the header fields fetched with every message, so
+// that rules can tell bulk, automatic and unauthenticated mail apart
+var classifyingFields = []string{"List-Id", "List-Unsubscribe", "Precedence", "Auto-Submitted", "Authentication-Results"}
+
+// AuthResults holds the verdicts of the receiving server's sender
+// authentication checks, in lower case, e.g. "pass", "fail", "softfail" or
+// "none". A check the server did not report is empty.
+type AuthResults struct {
+	SPF   string
+	DKIM  string
+	DMARC string
+}
+
+// Failed reports whether any check failed. A soft SPF failure counts, as
+// it marks a sender the domain does not vouch for.
+func (a AuthResults) Failed() bool {
+	return a.SPF == "fail" || a.SPF == "softfail" || a.DKIM == "fail" || a.DMARC == "fail"
+}
+
+// classify sets the fields of e derived from its classifying header fields
+func classify(e *Email, h textproto.MIMEHeader) {
+	precedence := strings.ToLower(strings.TrimSpace(h.Get("Precedence")))
+	e.Bulk = h.Get("List-Id") != "" || h.Get("List-Unsubscribe") != "" ||
+		precedence == "bulk" || precedence == "list" || precedence == "junk"
+
+	// RFC 3834: anything but "no" is automatic; the value may carry parameters
+	submitted, _, _ := strings.Cut(h.Get("Auto-Submitted"), ";")
+	submitted = strings.ToLower(strings.TrimSpace(submitted))
+	e.AutoGenerated = submitted != "" && submitted != "no"
+
+	// Only the topmost Authentication-Results was added by the receiving
+	// server; senders can forge those below it
+	e.Auth = parseAuthResults(h.Get("Authentication-Results"))
+}
+
+// parseAuthResults reads an Authentication-Results header field (RFC 8601).
+// A message with several DKIM signatures passes DKIM if any of them does.
+func parseAuthResults(value string) AuthResults {
+	var a AuthResults
+	// The first item is the server's ID; the others are one result each
+	items := strings.Split(stripComments(value), ";")
+	for _, item := range items[1:] {
+		method, rest, ok := strings.Cut(strings.TrimSpace(item), "=")
+		fields := strings.Fields(rest)
+		if !ok || len(fields) == 0 {
+			continue
+		}
+		result := strings.ToLower(fields[0])
+		switch strings.ToLower(strings.TrimSpace(method)) {
+		case "spf":
+			if a.SPF == "" {
+				a.SPF = result
+			}
+		case "dkim":
+			if a.DKIM == "" || result == "pass" {
+				a.DKIM = result
+			}
+		case "dmarc":
+			if a.DMARC == "" {
+				a.DMARC = result
+			}
+		}
+	}
+	return a
+}
+
+// stripComments removes the parenthesized comments of a structured header
+// value, which may contain semicolons
+func stripComments(value string) string {
+	var b strings.Builder
+	depth := 0
+	for _, r := range value {
+		switch {
+		case r == '(':
+			depth++
+		case r == ')' && depth > 0:
+			depth--
+		case depth == 0:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
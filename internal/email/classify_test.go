@@ -0,0 +1,66 @@
+package email
+
+import (
+	"net/textproto"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name   string
+		header textproto.MIMEHeader
+		want   Email
+	}{
+		{"personal", textproto.MIMEHeader{}, Email{}},
+		{"mailing list", textproto.MIMEHeader{"List-Id": {"Go Nuts <golang-nuts.googlegroups.com>"}}, Email{Bulk: true}},
+		{"newsletter", textproto.MIMEHeader{"List-Unsubscribe": {"<https://example.com/u>"}}, Email{Bulk: true}},
+		{"precedence", textproto.MIMEHeader{"Precedence": {" Bulk "}}, Email{Bulk: true}},
+		{"first class", textproto.MIMEHeader{"Precedence": {"first-class"}}, Email{}},
+		{"auto-reply", textproto.MIMEHeader{"Auto-Submitted": {"auto-replied"}}, Email{AutoGenerated: true}},
+		{"auto-generated with parameters", textproto.MIMEHeader{"Auto-Submitted": {"auto-generated; owner-email=a@example.com"}}, Email{AutoGenerated: true}},
+		{"explicitly manual", textproto.MIMEHeader{"Auto-Submitted": {"no"}}, Email{}},
+		{
+			"authentication",
+			textproto.MIMEHeader{"Authentication-Results": {
+				"mx.google.com; dkim=pass header.i=@example.com; spf=softfail (google.com: domain of a@example.com does not designate 1.2.3.4; as permitted sender) smtp.mailfrom=a@example.com; dmarc=fail (p=REJECT) header.from=example.com",
+				"forged.example.com; spf=pass; dkim=pass; dmarc=pass",
+			}},
+			Email{Auth: AuthResults{SPF: "softfail", DKIM: "pass", DMARC: "fail"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Email
+			classify(&got, tt.header)
+			if got.Bulk != tt.want.Bulk || got.AutoGenerated != tt.want.AutoGenerated || got.Auth != tt.want.Auth {
+				t.Errorf("classify() = bulk %v, auto %v, auth %+v; want bulk %v, auto %v, auth %+v",
+					got.Bulk, got.AutoGenerated, got.Auth, tt.want.Bulk, tt.want.AutoGenerated, tt.want.Auth)
+			}
+		})
+	}
+}
+
+func TestParseAuthResults(t *testing.T) {
+	tests := []struct {
+		value  string
+		want   AuthResults
+		failed bool
+	}{
+		{"", AuthResults{}, false},
+		{"mx.example.com; none", AuthResults{}, false},
+		{"mx.example.com; spf=pass smtp.mailfrom=example.com", AuthResults{SPF: "pass"}, false},
+		{"mx.example.com; spf=fail", AuthResults{SPF: "fail"}, true},
+		{"mx.example.com; dkim=fail (bad signature); dkim=pass header.d=example.com", AuthResults{DKIM: "pass"}, false},
+		{"mx.example.com; dkim=neutral; dkim=fail", AuthResults{DKIM: "neutral"}, false},
+		{"mx.example.com; DMARC=FAIL; dkim=", AuthResults{DMARC: "fail"}, true},
+	}
+	for _, tt := range tests {
+		got := parseAuthResults(tt.value)
+		if got != tt.want {
+			t.Errorf("parseAuthResults(%q) = %+v; want %+v", tt.value, got, tt.want)
+		}
+		if got.Failed() != tt.failed {
+			t.Errorf("parseAuthResults(%q).Failed() = %v; want %v", tt.value, got.Failed(), tt.failed)
+		}
+	}
+}
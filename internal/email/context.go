@@ -0,0 +1,136 @@
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/emersion/go-imap/client"
+
+	"github.com/mshan/go-tsk/internal/proxy"
+)
+
+// Default time limits applied when the caller's context has no earlier
+// deadline
+const (
+	dialTimeout    = 30 * time.Second
+	commandTimeout = 2 * time.Minute
+	logoutTimeout  = 10 * time.Second
+)
+
+// dialTLS connects to addr over TLS and reads the server greeting, honoring
+// ctx for both the dial and the greeting. With startTLS, the connection
+// starts in the clear and is upgraded with STARTTLS before anything else is
+// sent. A nil config uses the defaults, a nil dialer connects directly.
+func dialTLS(ctx context.Context, dialer proxy.Dialer, addr string, config *tls.Config, startTLS bool) (*client.Client, error) {
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if !startTLS {
+		tlsConn := tls.Client(conn, withServerName(config, addr))
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	// client.New blocks reading the greeting, so bound it with the
+	// context's deadline and clear the deadline once connected
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	c, err := client.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if startTLS {
+		if err := upgradeTLS(c, addr, config); err != nil {
+			c.Terminate()
+			return nil, err
+		}
+	}
+
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		c.Terminate()
+		return nil, err
+	}
+	return c, nil
+}
+
+// upgradeTLS switches a connection in the clear to TLS with STARTTLS,
+// failing if the server doesn't offer it rather than logging in without
+func upgradeTLS(c *client.Client, addr string, config *tls.Config) error {
+	ok, err := c.SupportStartTLS()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("server does not offer STARTTLS")
+	}
+	if err := c.StartTLS(withServerName(config, addr)); err != nil {
+		return fmt.Errorf("STARTTLS failed: %w", err)
+	}
+	return nil
+}
+
+// withServerName returns config, or a copy naming the host of addr as the
+// server to verify if it names none
+func withServerName(config *tls.Config, addr string) *tls.Config {
+	if config != nil && config.ServerName != "" {
+		return config
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	if config == nil {
+		config = &tls.Config{}
+	} else {
+		config = config.Clone()
+	}
+	config.ServerName = host
+	return config
+}
+
+// run executes op against the current connection, aborting it when ctx is
+// done or the command timeout elapses. go-imap v1 commands cannot be
+// canceled, so aborting closes the connection, which unblocks op; the next
+// operation will then reconnect.
+func (g *GmailClient) run(ctx context.Context, timeout time.Duration, op func(c *client.Client) error) error {
+	if g.client == nil {
+		return fmt.Errorf("client not connected")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	c := g.client
+	done := make(chan error, 1)
+	go func() {
+		done <- op(c)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		_ = c.Terminate()
+		<-done
+		return fmt.Errorf("imap command aborted: %w", ctx.Err())
+	}
+}
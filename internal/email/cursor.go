@@ -0,0 +1,113 @@
+package email
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrUIDValidityChanged is returned by FetchNewEmails when the mailbox's
+// UIDVALIDITY no longer matches the cursor, so previously seen UIDs are
+// meaningless and the mailbox must be resynced from scratch
+var ErrUIDValidityChanged = errors.New("mailbox UIDVALIDITY changed")
+
+// Cursor marks the newest message seen in a mailbox. UIDs are only
+// comparable while UIDValidity stays the same; a zero UIDValidity accepts
+// whatever the server reports.
+type Cursor struct {
+	UIDValidity uint32
+	LastUID     uint32
+
+	// ModSeq is the mailbox's HIGHESTMODSEQ when every message up to
+	// LastUID was fetched, on servers with CONDSTORE; 0 if unknown
+	ModSeq uint64
+}
+
+// IsZero reports whether no message has been seen yet
+func (c Cursor) IsZero() bool {
+	return c.UIDValidity == 0 && c.LastUID == 0
+}
+
+// advance returns the cursor moved past the given messages
+func (c Cursor) advance(emails []*Email) Cursor {
+	for _, msg := range emails {
+		if msg.UID > c.LastUID {
+			c.LastUID = msg.UID
+		}
+	}
+	return c
+}
+
+// MailboxStatus describes the current state of a mailbox
+type MailboxStatus struct {
+	Messages    int
+	UIDValidity uint32
+	UIDNext     uint32
+	// BatchEnd is the UID FetchBatch gives the newest message, so a
+	// backfill can stop there; 0 if those UIDs don't follow arrival
+	BatchEnd uint32
+}
+
+// Head returns a cursor positioned after the newest message in the mailbox
+func (s MailboxStatus) Head() Cursor {
+	c := Cursor{UIDValidity: s.UIDValidity}
+	if s.UIDNext > 0 {
+		c.LastUID = s.UIDNext - 1
+	}
+	return c
+}
+
+// positionTracker finds new mail in mailboxes that only number messages by
+// position, such as POP3 maildrops and mbox files, whose UIDs are hashes in
+// no particular order. Cursors hold the number of messages after the last
+// fetch; within a process the UIDs already returned are skipped instead,
+// which survives messages being removed by other programs.
+type positionTracker struct {
+	mu   sync.Mutex
+	seen map[uint32]bool // UIDs returned; nil until the first fetch
+}
+
+// pick returns the indexes into uids, oldest first, of the messages to
+// return after cursor, at most limit of them if limit is positive, and the
+// cursor to return with them
+func (t *positionTracker) pick(uids []uint32, cursor Cursor, validity uint32, limit int) ([]int, Cursor) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var fresh []int
+	for i, uid := range uids {
+		switch {
+		case t.seen != nil:
+			if !t.seen[uid] {
+				fresh = append(fresh, i)
+			}
+		case cursor.LastUID > uint32(len(uids)):
+			// Messages were removed since the cursor was taken, so
+			// positions are meaningless; the journal skips repeats
+			fresh = append(fresh, i)
+		case uint32(i) >= cursor.LastUID:
+			fresh = append(fresh, i)
+		}
+	}
+	next := Cursor{UIDValidity: validity, LastUID: uint32(len(uids))}
+	if limit > 0 && len(fresh) > limit {
+		fresh = fresh[:limit]
+		next.LastUID = uint32(fresh[len(fresh)-1] + 1)
+	}
+	return fresh, next
+}
+
+// mark records the messages returned by a fetch that picked fresh from
+// uids and moved the cursor to next
+func (t *positionTracker) mark(uids []uint32, fresh []int, next Cursor) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.seen == nil {
+		// Everything up to the cursor counts as seen from now on
+		t.seen = make(map[uint32]bool)
+		for i := 0; i < len(uids) && uint32(i) < next.LastUID; i++ {
+			t.seen[uids[i]] = true
+		}
+	}
+	for _, i := range fresh {
+		t.seen[uids[i]] = true
+	}
+}
@@ -0,0 +1,68 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMailboxStatusHead(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   MailboxStatus
+		expected Cursor
+	}{
+		{"empty mailbox", MailboxStatus{UIDValidity: 7, UIDNext: 1}, Cursor{UIDValidity: 7, LastUID: 0}},
+		{"non-empty mailbox", MailboxStatus{Messages: 3, UIDValidity: 7, UIDNext: 42}, Cursor{UIDValidity: 7, LastUID: 41}},
+		{"UIDNEXT not reported", MailboxStatus{UIDValidity: 7}, Cursor{UIDValidity: 7}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.status.Head(); got != tt.expected {
+				t.Errorf("Head() = %+v; want %+v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCursorAdvance(t *testing.T) {
+	tests := []struct {
+		name     string
+		uids     []uint32
+		expected uint32
+	}{
+		{"no messages", nil, 10},
+		{"ascending", []uint32{11, 12, 13}, 13},
+		{"unordered", []uint32{15, 11, 13}, 15},
+		{"older messages only", []uint32{3, 4}, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			emails := make([]*Email, len(tt.uids))
+			for i, uid := range tt.uids {
+				emails[i] = &Email{UID: uid}
+			}
+			got := Cursor{UIDValidity: 1, LastUID: 10}.advance(emails)
+			if got.LastUID != tt.expected || got.UIDValidity != 1 {
+				t.Errorf("advance(%v) = %+v; want LastUID %d", tt.uids, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFakeProviderUIDValidityChange(t *testing.T) {
+	f := NewFakeProvider(1)
+	if err := f.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	_, next, err := f.FetchNewEmails(context.Background(), Inbox, Cursor{UIDValidity: fakeUIDValidity + 1, LastUID: 5})
+	if !errors.Is(err, ErrUIDValidityChanged) {
+		t.Fatalf("FetchNewEmails error = %v; want ErrUIDValidityChanged", err)
+	}
+	if want := (Cursor{UIDValidity: fakeUIDValidity}); next != want {
+		t.Errorf("reset cursor = %+v; want %+v", next, want)
+	}
+}
@@ -0,0 +1,155 @@
+package email
+
+import (
+	"fmt"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Email represents an email message
+type Email struct {
+	Mailbox   string
+	UID       uint32
+	MessageID string
+	Subject   string
+	From      string
+	To        []string // Recipients, formatted as From is
+	Cc        []string
+	Date      time.Time
+	Flags     []string
+	Size      uint32 // Size of the message in bytes; 0 if the provider does not report it
+
+	// ProviderID is the provider's own ID of the message, set by providers
+	// whose UIDs only last as long as the client (JMAP, the Gmail API)
+	ProviderID string
+
+	// References lists the Message-IDs of the messages this one answers,
+	// from its In-Reply-To header and, where the provider reads headers,
+	// its References header
+	References []string
+
+	// ThreadID identifies the message's conversation: Gmail's thread ID,
+	// JMAP's threadId, or else the Message-ID of the thread's first
+	// message as its References name it. Empty if the message has no
+	// Message-ID and answers none.
+	ThreadID string
+
+	// TextBody and HTMLBody hold the decoded text/plain and text/html
+	// parts; they are only set when the provider fetches bodies
+	TextBody string
+	HTMLBody string
+
+	// Header holds every header field, decoded
+	Header textproto.MIMEHeader
+
+	// Attachments lists the attachments. IMAP and JMAP providers always
+	// list them; other providers only when fetching bodies.
+	Attachments []Attachment
+
+	// Bulk reports whether the message was sent to a list or in bulk,
+	// going by its List-Id, List-Unsubscribe and Precedence headers
+	Bulk bool
+	// AutoGenerated reports whether the message was sent by a program,
+	// such as an auto-responder, going by its Auto-Submitted header
+	AutoGenerated bool
+	// Auth holds the receiving server's sender authentication results
+	Auth AuthResults
+
+	// HasCalendar reports whether the message carries a calendar invite,
+	// a text/calendar part; it is only set when the provider fetches
+	// bodies
+	HasCalendar bool
+}
+
+// Key identifies the message for deduplication: its Message-ID when it has
+// one, so a message seen in several mailboxes is processed once, otherwise
+// its ProviderID, or else its UID within its mailbox and the given
+// UIDVALIDITY. UIDs that don't outlive the client would name other
+// messages after a restart.
+func (e *Email) Key(uidValidity uint32) string {
+	if id := strings.TrimSpace(e.MessageID); id != "" {
+		return "mid:" + id
+	}
+	if e.ProviderID != "" {
+		return "id:" + e.ProviderID
+	}
+	return fmt.Sprintf("uid:%s:%d:%d", e.Mailbox, uidValidity, e.UID)
+}
+
+// LegacyKey returns the key the message was journaled under before keys
+// named the mailbox, or "" if it had none: only INBOX was polled then, so
+// only its messages without a Message-ID or ProviderID have one
+func (e *Email) LegacyKey(uidValidity uint32) string {
+	if strings.TrimSpace(e.MessageID) != "" || e.ProviderID != "" || !strings.EqualFold(e.Mailbox, "INBOX") {
+		return ""
+	}
+	return fmt.Sprintf("uid:%d:%d", uidValidity, e.UID)
+}
+
+// addressList formats the addresses of a header value as From is. An
+// unparsable value is kept whole rather than losing the recipients.
+func addressList(value string) []string {
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+	addrs, err := mail.ParseAddressList(value)
+	if err != nil {
+		return []string{strings.TrimSpace(value)}
+	}
+	list := make([]string, len(addrs))
+	for i, a := range addrs {
+		if a.Name != "" {
+			list[i] = fmt.Sprintf("%s <%s>", a.Name, a.Address)
+		} else {
+			list[i] = a.Address
+		}
+	}
+	return list
+}
+
+// threadID derives a thread ID for providers without one: the first
+// Message-ID of the References header, which names the thread's first
+// message, else the message answered, else the message's own Message-ID
+func threadID(messageID string, h textproto.MIMEHeader) string {
+	if refs := bracketed(h.Get("References")); len(refs) > 0 {
+		return refs[0]
+	}
+	if ids := bracketed(h.Get("In-Reply-To")); len(ids) > 0 {
+		return ids[0]
+	}
+	return strings.TrimSpace(messageID)
+}
+
+// bracketed returns the <...> items of a header value, such as the
+// Message-IDs of an In-Reply-To or References header
+func bracketed(value string) []string {
+	var ids []string
+	for {
+		start := strings.IndexByte(value, '<')
+		if start < 0 {
+			return ids
+		}
+		end := strings.IndexByte(value[start:], '>')
+		if end < 0 {
+			return ids
+		}
+		ids = append(ids, value[start:start+end+1])
+		value = value[start+end+1:]
+	}
+}
+
+// references returns the Message-IDs of a message's In-Reply-To and
+// References header fields, without duplicates
+func references(inReplyTo, refs string) []string {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, id := range append(bracketed(inReplyTo), bracketed(refs)...) {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
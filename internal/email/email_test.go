@@ -0,0 +1,124 @@
+package email
+
+import (
+	"net/textproto"
+	"reflect"
+	"testing"
+
+	"github.com/emersion/go-imap"
+)
+
+func TestEmailKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		email    Email
+		expected string
+	}{
+		{"message id", Email{UID: 5, MessageID: "<abc@example.com>"}, "mid:<abc@example.com>"},
+		{"message id with whitespace", Email{UID: 5, MessageID: " <abc@example.com>\r\n"}, "mid:<abc@example.com>"},
+		{"no message id", Email{Mailbox: "INBOX", UID: 5}, "uid:INBOX:9:5"},
+		{"blank message id", Email{Mailbox: "INBOX", UID: 5, MessageID: "  "}, "uid:INBOX:9:5"},
+		{"other mailbox", Email{Mailbox: "Receipts", UID: 5}, "uid:Receipts:9:5"},
+		{"provider id", Email{Mailbox: "INBOX", UID: 5, ProviderID: "M42"}, "id:M42"},
+		{"message id over provider id", Email{UID: 5, MessageID: "<abc@example.com>", ProviderID: "M42"}, "mid:<abc@example.com>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.email.Key(9); got != tt.expected {
+				t.Errorf("Key(9) = %q; want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEmailLegacyKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		email    Email
+		expected string
+	}{
+		{"inbox", Email{Mailbox: "INBOX", UID: 5}, "uid:9:5"},
+		{"inbox in lower case", Email{Mailbox: "inbox", UID: 5}, "uid:9:5"},
+		{"other mailbox", Email{Mailbox: "Receipts", UID: 5}, ""},
+		{"message id", Email{Mailbox: "INBOX", UID: 5, MessageID: "<abc@example.com>"}, ""},
+		{"provider id", Email{Mailbox: "INBOX", UID: 5, ProviderID: "M42"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.email.LegacyKey(9); got != tt.expected {
+				t.Errorf("LegacyKey(9) = %q; want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAddressList(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"", nil},
+		{"me@example.com", []string{"me@example.com"}},
+		{`"Doe, Jane" <jane@example.com>, team@example.com`, []string{"Doe, Jane <jane@example.com>", "team@example.com"}},
+		{"not an address", []string{"not an address"}},
+	}
+
+	for _, tt := range tests {
+		if got := addressList(tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("addressList(%q) = %q; want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestThreadID(t *testing.T) {
+	tests := []struct {
+		name   string
+		header textproto.MIMEHeader
+		want   string
+	}{
+		{"first message", textproto.MIMEHeader{}, "<c@example.com>"},
+		{"reply", textproto.MIMEHeader{"In-Reply-To": {"<b@example.com>"}}, "<b@example.com>"},
+		{"references", textproto.MIMEHeader{"In-Reply-To": {"<b@example.com>"}, "References": {"<a@example.com> <b@example.com>"}}, "<a@example.com>"},
+	}
+	for _, tt := range tests {
+		if got := threadID(" <c@example.com> ", tt.header); got != tt.want {
+			t.Errorf("%s: threadID() = %q; want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGmailThreadID(t *testing.T) {
+	msg := imap.NewMessage(1, nil)
+	if got := gmailThreadID(msg); got != "" {
+		t.Errorf("gmailThreadID() without X-GM-THRID = %q", got)
+	}
+	msg.Items[fetchThreadID] = "1278455344230334865"
+	if got := gmailThreadID(msg); got != "1278455344230334865" {
+		t.Errorf("gmailThreadID() = %q", got)
+	}
+}
+
+func TestReferences(t *testing.T) {
+	tests := []struct {
+		name      string
+		inReplyTo string
+		refs      string
+		want      []string
+	}{
+		{"none", "", "", nil},
+		{"in-reply-to", "<a@example.com>", "", []string{"<a@example.com>"}},
+		{"thread", "<c@example.com>", "<a@example.com>\r\n <b@example.com> <c@example.com>", []string{"<c@example.com>", "<a@example.com>", "<b@example.com>"}},
+		{"comment", "<a@example.com> (sent by Alice)", "", []string{"<a@example.com>"}},
+		{"unterminated", "<a@example.com", "garbage", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := references(tt.inReplyTo, tt.refs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("references(%q, %q) = %q; want %q", tt.inReplyTo, tt.refs, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeSubjects are cycled through by the fake provider
+var fakeSubjects = []string{
+	"Job opportunity at Example Corp",
+	"Your weekly newsletter",
+	"Re: Quarterly report",
+	"Invoice #%d",
+	"Meeting notes",
+}
+
+// FakeProvider generates synthetic messages at a fixed rate without any
+// network access. It is used for soak tests and local development.
+type FakeProvider struct {
+	rate    float64 // messages per second
+	started time.Time
+
+	mu        sync.Mutex
+	connected bool
+	lastFetch time.Time
+	carry     float64
+	nextUID   uint32
+	labeled   map[string]int
+}
+
+// NewFakeProvider creates a fake provider producing rate messages per second
+func NewFakeProvider(rate float64) *FakeProvider {
+	return &FakeProvider{
+		rate:    rate,
+		started: time.Now(),
+		nextUID: 1,
+		labeled: make(map[string]int),
+	}
+}
+
+// Connect marks the provider as connected
+func (f *FakeProvider) Connect(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.connected = true
+	f.lastFetch = time.Now()
+	return nil
+}
+
+// Authenticate always succeeds
+func (f *FakeProvider) Authenticate(ctx context.Context) error {
+	return nil
+}
+
+// fakeUIDValidity is the UIDVALIDITY reported by the fake mailbox
+const fakeUIDValidity = 1
+
+// ListMailboxes returns INBOX, the only mailbox mail arrives in
+func (f *FakeProvider) ListMailboxes(ctx context.Context) ([]string, error) {
+	return []string{Inbox}, nil
+}
+
+// FetchNewEmails returns the messages that "arrived" in INBOX since the
+// previous fetch and are after cursor. Other mailboxes stay empty.
+func (f *FakeProvider) FetchNewEmails(ctx context.Context, mailbox string, cursor Cursor) ([]*Email, Cursor, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.connected {
+		return nil, cursor, fmt.Errorf("client not connected")
+	}
+	if mailbox != Inbox {
+		return nil, Cursor{UIDValidity: fakeUIDValidity}, nil
+	}
+	if cursor.UIDValidity != 0 && cursor.UIDValidity != fakeUIDValidity {
+		return nil, Cursor{UIDValidity: fakeUIDValidity}, ErrUIDValidityChanged
+	}
+	cursor.UIDValidity = fakeUIDValidity
+
+	now := time.Now()
+	f.carry += now.Sub(f.lastFetch).Seconds() * f.rate
+	f.lastFetch = now
+
+	n := int(f.carry)
+	f.carry -= float64(n)
+
+	emails := make([]*Email, 0, n)
+	for i := 0; i < n; i++ {
+		uid := f.nextUID
+		f.nextUID++
+		if uid <= cursor.LastUID {
+			continue
+		}
+
+		emails = append(emails, f.email(uid, now))
+	}
+	return emails, cursor.advance(emails), nil
+}
+
+// email returns the synthetic message with the given UID
+func (f *FakeProvider) email(uid uint32, date time.Time) *Email {
+	subject := fakeSubjects[int(uid)%len(fakeSubjects)]
+	if strings.Contains(subject, "%d") {
+		subject = fmt.Sprintf(subject, uid)
+	}
+	return &Email{
+		Mailbox:   Inbox,
+		UID:       uid,
+		MessageID: f.messageID(uid),
+		Subject:   subject,
+		From:      fmt.Sprintf("sender%d@example.com", uid%100),
+		Date:      date,
+	}
+}
+
+// messageID returns the Message-ID of the synthetic message with the given UID
+func (f *FakeProvider) messageID(uid uint32) string {
+	return fmt.Sprintf("<%d.%d@fake.invalid>", f.started.UnixNano(), uid)
+}
+
+// FetchBatch returns nothing; the fake mailbox has no pre-existing mail
+func (f *FakeProvider) FetchBatch(ctx context.Context, mailbox string, afterUID uint32, limit int) ([]*Email, error) {
+	return nil, nil
+}
+
+// Status reports the messages generated so far
+func (f *FakeProvider) Status(ctx context.Context, mailbox string) (MailboxStatus, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if mailbox != Inbox {
+		return MailboxStatus{UIDValidity: fakeUIDValidity, UIDNext: 1}, nil
+	}
+	return MailboxStatus{
+		Messages:    int(f.nextUID - 1),
+		UIDValidity: fakeUIDValidity,
+		UIDNext:     f.nextUID,
+	}, nil
+}
+
+// ApplyLabel counts the label application
+func (f *FakeProvider) ApplyLabel(ctx context.Context, mailbox string, uid uint32, label string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.labeled[label]++
+	return nil
+}
+
+// FetchMessage returns a message generated so far. Its date is the time
+// of the lookup, as the fake keeps no per-message state.
+func (f *FakeProvider) FetchMessage(ctx context.Context, mailbox string, uid uint32) (*Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if mailbox != Inbox || uid == 0 || uid >= f.nextUID {
+		return nil, ErrMessageNotFound
+	}
+	return &Message{Email: *f.email(uid, time.Now())}, nil
+}
+
+// SearchMessageID returns the UID of the generated message with messageID
+func (f *FakeProvider) SearchMessageID(ctx context.Context, mailbox, messageID string) ([]uint32, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if mailbox != Inbox {
+		return nil, nil
+	}
+	var started int64
+	var uid uint32
+	if _, err := fmt.Sscanf(messageID, "<%d.%d@fake.invalid>", &started, &uid); err != nil {
+		return nil, nil
+	}
+	if uid == 0 || uid >= f.nextUID || messageID != f.messageID(uid) {
+		return nil, nil
+	}
+	return []uint32{uid}, nil
+}
+
+// Search returns nothing; the fake keeps no per-message labels or dates
+func (f *FakeProvider) Search(ctx context.Context, mailbox string, filter Filter) ([]uint32, error) {
+	return nil, nil
+}
+
+// RemoveLabel does nothing
+func (f *FakeProvider) RemoveLabel(ctx context.Context, mailbox string, uids []uint32, label string) error {
+	return nil
+}
+
+// DeleteMessages does nothing
+func (f *FakeProvider) DeleteMessages(ctx context.Context, mailbox string, uids []uint32) error {
+	return nil
+}
+
+// Labeled returns how many times each label was applied
+func (f *FakeProvider) Labeled() map[string]int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	counts := make(map[string]int, len(f.labeled))
+	for label, n := range f.labeled {
+		counts[label] = n
+	}
+	return counts
+}
+
+// Generated returns the total number of messages produced so far
+func (f *FakeProvider) Generated() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return int(f.nextUID - 1)
+}
+
+// Close marks the provider as disconnected
+func (f *FakeProvider) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.connected = false
+	return nil
+}
@@ -0,0 +1,845 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// gmailAPIURL is the Gmail REST API endpoint of the signed-in user
+const gmailAPIURL = "https://gmail.googleapis.com/gmail/v1/users/me"
+
+// gmailAPIPageSize is the largest page messages.list returns
+const gmailAPIPageSize = 500
+
+// errGmailNotFound is returned for API resources that do not exist, such
+// as a deleted message or a history ID that is too old
+var errGmailNotFound = errors.New("not found")
+
+// GmailAPIClient works on Gmail through its REST API instead of IMAP.
+// Mailboxes are Gmail labels, labels are applied natively rather than as
+// IMAP keywords, and new mail is found with history.list.
+//
+// Gmail identifies messages by string IDs, so the client hands out UIDs of
+// its own, valid for as long as the client lives, and sets
+// Email.ProviderID to the message ID, which outlives them. Cursors hold the history
+// ID of the mailbox rather than a UID: UIDValidity its upper 32 bits plus
+// one and LastUID its lower 32 bits. A LastUID of 0 lists the whole
+// mailbox.
+type GmailAPIClient struct {
+	baseURL     string
+	tokens      oauth2.TokenSource
+	client      *http.Client
+	fetchBodies bool
+	maxMessages int
+
+	mu     sync.Mutex
+	labels map[string]string    // Label IDs by name; nil until listed
+	tables map[string]*uidTable // By mailbox
+}
+
+// GmailAPIOption customizes a GmailAPIClient
+type GmailAPIOption func(*GmailAPIClient)
+
+// WithGmailAPIURL points the client at another API endpoint, such as a
+// test server
+func WithGmailAPIURL(baseURL string) GmailAPIOption {
+	return func(g *GmailAPIClient) {
+		g.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithAPIBodies makes the client fetch and decode full message bodies
+// into Email.TextBody and Email.HTMLBody
+func WithAPIBodies() GmailAPIOption {
+	return func(g *GmailAPIClient) {
+		g.fetchBodies = true
+	}
+}
+
+// WithMessageLimit makes FetchNewEmails return at most n messages, oldest
+// first, leaving the rest for the next fetch. The client applies the limit
+// itself because its cursors are not UIDs.
+func WithMessageLimit(n int) GmailAPIOption {
+	return func(g *GmailAPIClient) {
+		g.maxMessages = n
+	}
+}
+
+// WithAPITransport sends the client's requests through transport, such as
+// one using the account's proxy
+func WithAPITransport(transport http.RoundTripper) GmailAPIOption {
+	return func(g *GmailAPIClient) {
+		g.client.Transport = transport
+	}
+}
+
+// NewGmailAPIClient creates a Gmail API client authenticating with the
+// tokens of ts
+func NewGmailAPIClient(ts oauth2.TokenSource, opts ...GmailAPIOption) *GmailAPIClient {
+	g := &GmailAPIClient{
+		baseURL: gmailAPIURL,
+		tokens:  ts,
+		client:  &http.Client{Timeout: commandTimeout},
+		tables:  make(map[string]*uidTable),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+// uidTable maps the UIDs a client handed out in one mailbox to message IDs
+type uidTable struct {
+	ids  map[uint32]string
+	uids map[string]uint32
+	next uint32
+
+	// returned holds the messages FetchNewEmails returned already, with
+	// the position in the mailbox's history they were returned at: a
+	// history ID or a receipt time. A fetch from a later position cannot
+	// return them again, so prune drops them then.
+	returned map[string]uint64
+}
+
+// newUIDTable returns an empty UID table
+func newUIDTable() *uidTable {
+	return &uidTable{ids: make(map[uint32]string), uids: make(map[string]uint32), returned: make(map[string]uint64)}
+}
+
+// table returns the UID table of a mailbox; g.mu must be held
+func (g *GmailAPIClient) table(mailbox string) *uidTable {
+	t, ok := g.tables[mailbox]
+	if !ok {
+		t = newUIDTable()
+		g.tables[mailbox] = t
+	}
+	return t
+}
+
+// assign returns the UID of a message, handing out the next one if it has
+// none
+func (t *uidTable) assign(id string) uint32 {
+	if uid, ok := t.uids[id]; ok {
+		return uid
+	}
+	t.next++
+	t.set(id, t.next)
+	return t.next
+}
+
+// prune forgets the messages returned at positions before pos, along with
+// their UIDs. The rules have run on them, and a fetch from pos on does not
+// return them, so their entries would only grow the table.
+func (t *uidTable) prune(pos uint64) {
+	for id, at := range t.returned {
+		if at >= pos {
+			continue
+		}
+		delete(t.returned, id)
+		if uid, ok := t.uids[id]; ok {
+			delete(t.uids, id)
+			delete(t.ids, uid)
+		}
+	}
+}
+
+// set gives a message a UID, such as its position in the mailbox
+func (t *uidTable) set(id string, uid uint32) {
+	if old, ok := t.ids[uid]; ok {
+		delete(t.uids, old)
+	}
+	t.ids[uid] = id
+	t.uids[id] = uid
+	if uid > t.next {
+		t.next = uid
+	}
+}
+
+// historyCursor is the cursor of a history ID
+func historyCursor(id uint64) Cursor {
+	return Cursor{UIDValidity: uint32(id>>32) + 1, LastUID: uint32(id)}
+}
+
+// historyID is the history ID of a non-zero cursor
+func historyID(c Cursor) uint64 {
+	return uint64(c.UIDValidity-1)<<32 | uint64(c.LastUID)
+}
+
+// Connect does nothing; every call is a separate HTTP request
+func (g *GmailAPIClient) Connect(ctx context.Context) error {
+	return nil
+}
+
+// Authenticate checks that the token is accepted
+func (g *GmailAPIClient) Authenticate(ctx context.Context) error {
+	if _, err := g.profile(ctx); err != nil {
+		return fmt.Errorf("authentication failed: %w", err)
+	}
+	return nil
+}
+
+// profile returns the current history ID of the account
+func (g *GmailAPIClient) profile(ctx context.Context) (uint64, error) {
+	var resp struct {
+		HistoryID string `json:"historyId"`
+	}
+	if err := g.do(ctx, http.MethodGet, "/profile", nil, nil, &resp); err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(resp.HistoryID, 10, 64)
+}
+
+// ListMailboxes returns the names of all labels
+func (g *GmailAPIClient) ListMailboxes(ctx context.Context) ([]string, error) {
+	labels, err := g.loadLabels(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// loadLabels returns the label IDs by name, listing them if they were not
+// listed yet or reload is set
+func (g *GmailAPIClient) loadLabels(ctx context.Context, reload bool) (map[string]string, error) {
+	g.mu.Lock()
+	labels := g.labels
+	g.mu.Unlock()
+	if labels != nil && !reload {
+		return labels, nil
+	}
+
+	var resp struct {
+		Labels []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"labels"`
+	}
+	if err := g.do(ctx, http.MethodGet, "/labels", nil, nil, &resp); err != nil {
+		return nil, fmt.Errorf("failed to list labels: %w", err)
+	}
+	labels = make(map[string]string, len(resp.Labels))
+	for _, l := range resp.Labels {
+		labels[l.Name] = l.ID
+	}
+	g.mu.Lock()
+	g.labels = labels
+	g.mu.Unlock()
+	return labels, nil
+}
+
+// labelID returns the ID of the label with the given name, creating the
+// label if create is set and it does not exist. It returns "" for a label
+// that does not exist and is not created.
+func (g *GmailAPIClient) labelID(ctx context.Context, name string, create bool) (string, error) {
+	labels, err := g.loadLabels(ctx, false)
+	if err != nil {
+		return "", err
+	}
+	if id, ok := labels[name]; ok {
+		return id, nil
+	}
+	// The label may have been created since the list was loaded
+	if labels, err = g.loadLabels(ctx, true); err != nil {
+		return "", err
+	}
+	if id, ok := labels[name]; ok || !create {
+		return id, nil
+	}
+
+	var created struct {
+		ID string `json:"id"`
+	}
+	body := map[string]string{"name": name, "labelListVisibility": "labelShow", "messageListVisibility": "show"}
+	if err := g.do(ctx, http.MethodPost, "/labels", nil, body, &created); err != nil {
+		return "", fmt.Errorf("failed to create label %s: %w", name, err)
+	}
+	g.mu.Lock()
+	g.labels[name] = created.ID
+	g.mu.Unlock()
+	return created.ID, nil
+}
+
+// mailboxLabel returns the label ID of a mailbox, which must exist
+func (g *GmailAPIClient) mailboxLabel(ctx context.Context, mailbox string) (string, error) {
+	id, err := g.labelID(ctx, mailbox, false)
+	if err != nil {
+		return "", err
+	}
+	if id == "" {
+		return "", fmt.Errorf("no label %q", mailbox)
+	}
+	return id, nil
+}
+
+// FetchNewEmails retrieves the messages added to mailbox since cursor,
+// oldest first, and returns the cursor after them. If the cursor's history
+// ID has expired, it returns ErrUIDValidityChanged along with a cursor
+// that lists the whole mailbox.
+func (g *GmailAPIClient) FetchNewEmails(ctx context.Context, mailbox string, cursor Cursor) ([]*Email, Cursor, error) {
+	labelID, err := g.mailboxLabel(ctx, mailbox)
+	if err != nil {
+		return nil, cursor, err
+	}
+
+	var added []historyMessage
+	var next Cursor
+	var listedAt uint64 // History ID a listing was taken at
+	if cursor.LastUID == 0 {
+		// Take the history ID first, so mail arriving during the listing
+		// is found by the next fetch
+		head, err := g.profile(ctx)
+		if err != nil {
+			return nil, cursor, err
+		}
+		ids, err := g.list(ctx, []string{labelID}, "")
+		if err != nil {
+			return nil, cursor, err
+		}
+		for i := len(ids) - 1; i >= 0; i-- {
+			added = append(added, historyMessage{id: ids[i]})
+		}
+		next = historyCursor(head)
+		listedAt = head
+	} else {
+		var head uint64
+		added, head, err = g.history(ctx, labelID, historyID(cursor))
+		if errors.Is(err, errGmailNotFound) {
+			// Gmail keeps about a week of history
+			head, err := g.profile(ctx)
+			if err != nil {
+				return nil, cursor, err
+			}
+			return nil, Cursor{UIDValidity: historyCursor(head).UIDValidity}, ErrUIDValidityChanged
+		}
+		if err != nil {
+			return nil, cursor, err
+		}
+		next = historyCursor(head)
+	}
+
+	// Skip messages returned before, e.g. when a limited fetch is resumed.
+	// Those added up to the cursor's history ID are not read again.
+	g.mu.Lock()
+	t := g.table(mailbox)
+	if cursor.LastUID != 0 {
+		t.prune(historyID(cursor) + 1)
+	}
+	fresh := added[:0]
+	for _, m := range added {
+		if _, ok := t.returned[m.id]; !ok {
+			fresh = append(fresh, m)
+		}
+	}
+	g.mu.Unlock()
+	added = fresh
+
+	if g.maxMessages > 0 && len(added) > g.maxMessages {
+		added, next = limitHistory(added, g.maxMessages, cursor)
+	}
+
+	var emails []*Email
+	for _, m := range added {
+		e, err := g.fetch(ctx, mailbox, m.id, g.fetchBodies)
+		if errors.Is(err, errGmailNotFound) {
+			continue // Deleted since it was added
+		}
+		if err != nil {
+			return nil, cursor, err
+		}
+		emails = append(emails, &e.Email)
+	}
+
+	g.mu.Lock()
+	for _, m := range added {
+		if m.record != 0 {
+			t.returned[m.id] = m.record
+		} else {
+			// Mail added during the listing is also found in the history
+			// after it, so listed messages are kept until that was read
+			t.returned[m.id] = listedAt + 1
+		}
+	}
+	g.mu.Unlock()
+	return emails, next, nil
+}
+
+// limitHistory cuts added down to limit messages and returns the cursor to
+// resume from. The cut falls after the last history record kept; if that
+// record added more messages, it is read again and the messages already
+// returned are skipped.
+func limitHistory(added []historyMessage, limit int, cursor Cursor) ([]historyMessage, Cursor) {
+	last := added[limit-1]
+	if last.record == 0 {
+		// A listing of the whole mailbox resumes by listing it again
+		return added[:limit], Cursor{UIDValidity: cursor.UIDValidity}
+	}
+	if added[limit].record == last.record {
+		return added[:limit], historyCursor(last.record - 1)
+	}
+	return added[:limit], historyCursor(last.record)
+}
+
+// historyMessage is a message added to a mailbox by a history record
+type historyMessage struct {
+	id     string
+	record uint64 // ID of the history record; 0 for messages listed
+}
+
+// history returns the messages added to a label after the history ID
+// start, in the order they were added, and the latest history ID
+func (g *GmailAPIClient) history(ctx context.Context, labelID string, start uint64) ([]historyMessage, uint64, error) {
+	var added []historyMessage
+	seen := make(map[string]bool)
+	var head uint64
+	query := url.Values{
+		"startHistoryId": {strconv.FormatUint(start, 10)},
+		"labelId":        {labelID},
+		"historyTypes":   {"messageAdded", "labelAdded"},
+	}
+	for {
+		var resp struct {
+			History []struct {
+				ID            string `json:"id"`
+				MessagesAdded []struct {
+					Message gmailMessageRef `json:"message"`
+				} `json:"messagesAdded"`
+				LabelsAdded []struct {
+					Message  gmailMessageRef `json:"message"`
+					LabelIDs []string        `json:"labelIds"`
+				} `json:"labelsAdded"`
+			} `json:"history"`
+			HistoryID     string `json:"historyId"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := g.do(ctx, http.MethodGet, "/history", query, nil, &resp); err != nil {
+			return nil, 0, err
+		}
+
+		for _, h := range resp.History {
+			record, _ := strconv.ParseUint(h.ID, 10, 64)
+			add := func(m gmailMessageRef) {
+				if !seen[m.ID] && hasLabel(m.LabelIDs, labelID) {
+					seen[m.ID] = true
+					added = append(added, historyMessage{id: m.ID, record: record})
+				}
+			}
+			for _, a := range h.MessagesAdded {
+				add(a.Message)
+			}
+			for _, a := range h.LabelsAdded {
+				if hasLabel(a.LabelIDs, labelID) {
+					add(a.Message)
+				}
+			}
+		}
+		if id, err := strconv.ParseUint(resp.HistoryID, 10, 64); err == nil {
+			head = id
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		query.Set("pageToken", resp.NextPageToken)
+	}
+	if head == 0 {
+		head = start
+	}
+	return added, head, nil
+}
+
+// gmailMessageRef is a message as history records refer to it
+type gmailMessageRef struct {
+	ID       string   `json:"id"`
+	LabelIDs []string `json:"labelIds"`
+}
+
+// hasLabel reports whether labels holds id; messages in history records
+// without labels are taken to hold it
+func hasLabel(labels []string, id string) bool {
+	if labels == nil {
+		return true
+	}
+	for _, l := range labels {
+		if l == id {
+			return true
+		}
+	}
+	return false
+}
+
+// list returns the IDs of the messages holding every label in labelIDs
+// and matching the search query q, newest first
+func (g *GmailAPIClient) list(ctx context.Context, labelIDs []string, q string) ([]string, error) {
+	query := url.Values{"maxResults": {strconv.Itoa(gmailAPIPageSize)}}
+	for _, id := range labelIDs {
+		query.Add("labelIds", id)
+	}
+	if q != "" {
+		query.Set("q", q)
+	}
+
+	var ids []string
+	for {
+		var resp struct {
+			Messages []struct {
+				ID string `json:"id"`
+			} `json:"messages"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := g.do(ctx, http.MethodGet, "/messages", query, nil, &resp); err != nil {
+			return nil, fmt.Errorf("failed to list messages: %w", err)
+		}
+		for _, m := range resp.Messages {
+			ids = append(ids, m.ID)
+		}
+		if resp.NextPageToken == "" {
+			return ids, nil
+		}
+		query.Set("pageToken", resp.NextPageToken)
+	}
+}
+
+// fetch retrieves a message and gives it a UID in mailbox. With full set
+// the whole message is fetched and decoded, otherwise only its headers.
+func (g *GmailAPIClient) fetch(ctx context.Context, mailbox, id string, full bool) (*Message, error) {
+	var resp struct {
+		ThreadID string   `json:"threadId"`
+		LabelIDs []string `json:"labelIds"`
+		Raw      string   `json:"raw"`
+		Payload  struct {
+			Headers []struct {
+				Name  string `json:"name"`
+				Value string `json:"value"`
+			} `json:"headers"`
+		} `json:"payload"`
+	}
+	query := url.Values{"format": {"metadata"}}
+	if full {
+		query = url.Values{"format": {"raw"}}
+	}
+	if err := g.do(ctx, http.MethodGet, "/messages/"+url.PathEscape(id), query, nil, &resp); err != nil {
+		return nil, fmt.Errorf("failed to fetch message %s: %w", id, err)
+	}
+
+	var m *Message
+	if full {
+		raw, err := base64.URLEncoding.DecodeString(resp.Raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid message %s: %w", id, err)
+		}
+		m = readMessage(bytes.NewReader(raw), true, id)
+	} else {
+		m = &Message{Email: Email{Header: make(map[string][]string)}}
+		for _, h := range resp.Payload.Headers {
+			m.Header.Add(h.Name, decodeHeader(h.Value))
+		}
+		setHeaderFields(m)
+	}
+
+	m.Mailbox = mailbox
+	if resp.ThreadID != "" {
+		m.ThreadID = resp.ThreadID
+	}
+	m.Flags = g.labelNames(resp.LabelIDs)
+
+	g.mu.Lock()
+	m.UID = g.table(mailbox).assign(id)
+	g.mu.Unlock()
+	m.ProviderID = id
+	return m, nil
+}
+
+// labelNames returns the names of labels given by ID; labels not listed
+// yet keep their ID
+func (g *GmailAPIClient) labelNames(ids []string) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	byID := make(map[string]string, len(g.labels))
+	for name, id := range g.labels {
+		byID[id] = name
+	}
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		if name, ok := byID[id]; ok {
+			names[i] = name
+		} else {
+			names[i] = id
+		}
+	}
+	return names
+}
+
+// messageID returns the message ID behind a UID handed out in mailbox
+func (g *GmailAPIClient) messageID(mailbox string, uid uint32) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	id, ok := g.table(mailbox).ids[uid]
+	if !ok {
+		return "", ErrMessageNotFound
+	}
+	return id, nil
+}
+
+// messageIDs returns the message IDs behind UIDs handed out in mailbox,
+// skipping unknown ones
+func (g *GmailAPIClient) messageIDs(mailbox string, uids []uint32) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	t := g.table(mailbox)
+	ids := make([]string, 0, len(uids))
+	for _, uid := range uids {
+		if id, ok := t.ids[uid]; ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// FetchBatch retrieves up to limit messages of mailbox, oldest first,
+// after the first afterUID. The UID of each message is its position in the
+// mailbox, so a backfill resumes where it stopped.
+func (g *GmailAPIClient) FetchBatch(ctx context.Context, mailbox string, afterUID uint32, limit int) ([]*Email, error) {
+	labelID, err := g.mailboxLabel(ctx, mailbox)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := g.list(ctx, []string{labelID}, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var emails []*Email
+	for pos := int(afterUID); pos < len(ids) && len(emails) < limit; pos++ {
+		id := ids[len(ids)-1-pos]
+		m, err := g.fetch(ctx, mailbox, id, g.fetchBodies)
+		if errors.Is(err, errGmailNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		m.UID = uint32(pos + 1)
+		g.mu.Lock()
+		g.table(mailbox).set(id, m.UID)
+		g.mu.Unlock()
+		emails = append(emails, &m.Email)
+	}
+	return emails, nil
+}
+
+// Status returns the size of a mailbox and the current history ID as its
+// UIDVALIDITY and UIDNEXT. FetchBatch numbers messages by position, so the
+// size is also where a batch ends.
+func (g *GmailAPIClient) Status(ctx context.Context, mailbox string) (MailboxStatus, error) {
+	labelID, err := g.mailboxLabel(ctx, mailbox)
+	if err != nil {
+		return MailboxStatus{}, err
+	}
+	var label struct {
+		MessagesTotal int `json:"messagesTotal"`
+	}
+	if err := g.do(ctx, http.MethodGet, "/labels/"+url.PathEscape(labelID), nil, nil, &label); err != nil {
+		return MailboxStatus{}, fmt.Errorf("failed to get label %s: %w", mailbox, err)
+	}
+	head, err := g.profile(ctx)
+	if err != nil {
+		return MailboxStatus{}, err
+	}
+	c := historyCursor(head)
+	return MailboxStatus{
+		Messages:    label.MessagesTotal,
+		UIDValidity: c.UIDValidity,
+		UIDNext:     c.LastUID + 1,
+		BatchEnd:    uint32(label.MessagesTotal),
+	}, nil
+}
+
+// ApplyLabel adds a label to a message, creating the label if needed
+func (g *GmailAPIClient) ApplyLabel(ctx context.Context, mailbox string, uid uint32, label string) error {
+	id, err := g.messageID(mailbox, uid)
+	if err != nil {
+		return err
+	}
+	labelID, err := g.labelID(ctx, label, true)
+	if err != nil {
+		return err
+	}
+	body := map[string][]string{"addLabelIds": {labelID}}
+	if err := g.do(ctx, http.MethodPost, "/messages/"+url.PathEscape(id)+"/modify", nil, body, nil); err != nil {
+		return fmt.Errorf("failed to apply label: %w", err)
+	}
+	return nil
+}
+
+// FetchMessage retrieves one message with all its headers, decoded bodies
+// and attachment list. It returns ErrMessageNotFound for a UID the client
+// did not hand out.
+func (g *GmailAPIClient) FetchMessage(ctx context.Context, mailbox string, uid uint32) (*Message, error) {
+	id, err := g.messageID(mailbox, uid)
+	if err != nil {
+		return nil, err
+	}
+	m, err := g.fetch(ctx, mailbox, id, true)
+	if errors.Is(err, errGmailNotFound) {
+		return nil, ErrMessageNotFound
+	}
+	return m, err
+}
+
+// SearchMessageID returns the UIDs of the messages in mailbox with the
+// given Message-ID
+func (g *GmailAPIClient) SearchMessageID(ctx context.Context, mailbox, messageID string) ([]uint32, error) {
+	labelID, err := g.mailboxLabel(ctx, mailbox)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := g.list(ctx, []string{labelID}, "rfc822msgid:"+strings.Trim(messageID, "<> "))
+	if err != nil {
+		return nil, err
+	}
+	return g.assignAll(mailbox, ids), nil
+}
+
+// Search returns the UIDs of the messages in mailbox matching filter
+func (g *GmailAPIClient) Search(ctx context.Context, mailbox string, filter Filter) ([]uint32, error) {
+	labelID, err := g.mailboxLabel(ctx, mailbox)
+	if err != nil {
+		return nil, err
+	}
+	labelIDs := []string{labelID}
+	if filter.Label != "" {
+		id, err := g.labelID(ctx, filter.Label, false)
+		if err != nil {
+			return nil, err
+		}
+		if id == "" {
+			return nil, nil
+		}
+		labelIDs = append(labelIDs, id)
+	}
+	var q string
+	if !filter.Before.IsZero() {
+		q = "before:" + filter.Before.Format("2006/01/02")
+	}
+	ids, err := g.list(ctx, labelIDs, q)
+	if err != nil {
+		return nil, err
+	}
+	return g.assignAll(mailbox, ids), nil
+}
+
+// assignAll gives each message a UID in mailbox
+func (g *GmailAPIClient) assignAll(mailbox string, ids []string) []uint32 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	t := g.table(mailbox)
+	uids := make([]uint32, len(ids))
+	for i, id := range ids {
+		uids[i] = t.assign(id)
+	}
+	return uids
+}
+
+// RemoveLabel takes a label off messages
+func (g *GmailAPIClient) RemoveLabel(ctx context.Context, mailbox string, uids []uint32, label string) error {
+	labelID, err := g.labelID(ctx, label, false)
+	if err != nil || labelID == "" {
+		return err
+	}
+	return g.batchModify(ctx, g.messageIDs(mailbox, uids), nil, []string{labelID})
+}
+
+// DeleteMessages moves messages to the trash, from which Gmail deletes
+// them after 30 days
+func (g *GmailAPIClient) DeleteMessages(ctx context.Context, mailbox string, uids []uint32) error {
+	return g.batchModify(ctx, g.messageIDs(mailbox, uids), []string{"TRASH"}, nil)
+}
+
+// batchModify changes the labels of messages, up to 1000 per request
+func (g *GmailAPIClient) batchModify(ctx context.Context, ids, add, remove []string) error {
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > 1000 {
+			n = 1000
+		}
+		body := map[string][]string{"ids": ids[:n], "addLabelIds": add, "removeLabelIds": remove}
+		if err := g.do(ctx, http.MethodPost, "/messages/batchModify", nil, body, nil); err != nil {
+			return fmt.Errorf("failed to modify messages: %w", err)
+		}
+		ids = ids[n:]
+	}
+	return nil
+}
+
+// Close does nothing; there is no connection to close
+func (g *GmailAPIClient) Close() error {
+	return nil
+}
+
+// do sends an API request and decodes the response into out, which may be
+// nil
+func (g *GmailAPIClient) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	u := g.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	tok, err := g.tokens.Token()
+	if err != nil {
+		return fmt.Errorf("failed to get OAuth2 token: %w", err)
+	}
+	tok.SetAuthHeader(req)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("gmail request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errGmailNotFound
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("gmail returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		return nil
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<20)).Decode(out); err != nil {
+		return fmt.Errorf("invalid gmail response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,413 @@
+package email
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+// fakeGmail serves the parts of the Gmail API the client uses
+type fakeGmail struct {
+	mu       sync.Mutex
+	labels   map[string]string // Names by ID
+	messages []*fakeGmailMessage
+	history  []fakeHistory
+	oldest   uint64 // Oldest history ID still kept
+	nextID   uint64
+}
+
+type fakeGmailMessage struct {
+	id     string
+	labels []string
+	raw    string
+}
+
+type fakeHistory struct {
+	id    uint64
+	added []string
+}
+
+func newFakeGmail() *fakeGmail {
+	return &fakeGmail{labels: map[string]string{"INBOX": "INBOX", "TRASH": "TRASH"}, nextID: 100, oldest: 100}
+}
+
+// add delivers messages to the inbox in one history record
+func (f *fakeGmail) add(subjects ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	h := fakeHistory{id: f.nextID}
+	for _, s := range subjects {
+		id := fmt.Sprintf("m%d", len(f.messages)+1)
+		raw := fmt.Sprintf("Subject: %s\r\nFrom: a@example.com\r\nMessage-ID: <%s@example.com>\r\n\r\nbody of %s", s, id, s)
+		f.messages = append(f.messages, &fakeGmailMessage{id: id, labels: []string{"INBOX"}, raw: raw})
+		h.added = append(h.added, id)
+	}
+	f.history = append(f.history, h)
+}
+
+func (f *fakeGmail) message(id string) *fakeGmailMessage {
+	for _, m := range f.messages {
+		if m.id == id {
+			return m
+		}
+	}
+	return nil
+}
+
+func (f *fakeGmail) labelsOf(id string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var names []string
+	for _, l := range f.message(id).labels {
+		names = append(names, f.labels[l])
+	}
+	return names
+}
+
+func (f *fakeGmail) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer ya29.test" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	reply := func(v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+	var body struct {
+		Name           string   `json:"name"`
+		IDs            []string `json:"ids"`
+		AddLabelIDs    []string `json:"addLabelIds"`
+		RemoveLabelIDs []string `json:"removeLabelIds"`
+	}
+	if r.Method == http.MethodPost {
+		json.NewDecoder(r.Body).Decode(&body)
+	}
+	modify := func(m *fakeGmailMessage) {
+		for _, l := range body.AddLabelIDs {
+			if !contains(m.labels, l) {
+				m.labels = append(m.labels, l)
+			}
+		}
+		kept := m.labels[:0]
+		for _, l := range m.labels {
+			if !contains(body.RemoveLabelIDs, l) {
+				kept = append(kept, l)
+			}
+		}
+		m.labels = kept
+	}
+
+	path := r.URL.Path
+	switch {
+	case path == "/profile":
+		reply(map[string]string{"historyId": strconv.FormatUint(f.nextID, 10)})
+	case path == "/labels" && r.Method == http.MethodGet:
+		var labels []map[string]string
+		for id, name := range f.labels {
+			labels = append(labels, map[string]string{"id": id, "name": name})
+		}
+		reply(map[string]interface{}{"labels": labels})
+	case path == "/labels":
+		id := fmt.Sprintf("Label_%d", len(f.labels))
+		f.labels[id] = body.Name
+		reply(map[string]string{"id": id, "name": body.Name})
+	case strings.HasPrefix(path, "/labels/"):
+		n := 0
+		for _, m := range f.messages {
+			if contains(m.labels, strings.TrimPrefix(path, "/labels/")) {
+				n++
+			}
+		}
+		reply(map[string]int{"messagesTotal": n})
+	case path == "/history":
+		start, _ := strconv.ParseUint(r.URL.Query().Get("startHistoryId"), 10, 64)
+		if start < f.oldest {
+			http.Error(w, "history expired", http.StatusNotFound)
+			return
+		}
+		var records []interface{}
+		for _, h := range f.history {
+			if h.id <= start {
+				continue
+			}
+			var added []interface{}
+			for _, id := range h.added {
+				added = append(added, map[string]interface{}{"message": map[string]interface{}{"id": id, "labelIds": []string{"INBOX"}}})
+			}
+			records = append(records, map[string]interface{}{"id": strconv.FormatUint(h.id, 10), "messagesAdded": added})
+		}
+		reply(map[string]interface{}{"history": records, "historyId": strconv.FormatUint(f.nextID, 10)})
+	case path == "/messages":
+		q := r.URL.Query()
+		var list []map[string]string
+		for i := len(f.messages) - 1; i >= 0; i-- {
+			m := f.messages[i]
+			match := true
+			for _, l := range q["labelIds"] {
+				match = match && contains(m.labels, l)
+			}
+			if id := strings.TrimPrefix(q.Get("q"), "rfc822msgid:"); id != q.Get("q") {
+				match = match && strings.Contains(m.raw, "<"+id+">")
+			}
+			if match {
+				list = append(list, map[string]string{"id": m.id})
+			}
+		}
+		reply(map[string]interface{}{"messages": list})
+	case path == "/settings/filters":
+		reply(map[string]interface{}{"filter": []interface{}{map[string]interface{}{
+			"id":       "f1",
+			"criteria": map[string]interface{}{"from": "billing@example.com", "size": 1024, "sizeComparison": "larger"},
+			"action":   map[string]interface{}{"addLabelIds": []string{"Label_7", "STARRED"}, "removeLabelIds": []string{"INBOX"}},
+		}}})
+	case path == "/messages/batchModify":
+		for _, id := range body.IDs {
+			modify(f.message(id))
+		}
+	case strings.HasSuffix(path, "/modify"):
+		modify(f.message(strings.TrimSuffix(strings.TrimPrefix(path, "/messages/"), "/modify")))
+		reply(map[string]string{})
+	case strings.HasPrefix(path, "/messages/"):
+		m := f.message(strings.TrimPrefix(path, "/messages/"))
+		if m == nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		resp := map[string]interface{}{"id": m.id, "labelIds": m.labels}
+		if r.URL.Query().Get("format") == "raw" {
+			resp["raw"] = base64.URLEncoding.EncodeToString([]byte(m.raw))
+		} else {
+			head, _, _ := strings.Cut(m.raw, "\r\n\r\n")
+			var headers []map[string]string
+			for _, line := range strings.Split(head, "\r\n") {
+				name, value, _ := strings.Cut(line, ": ")
+				headers = append(headers, map[string]string{"name": name, "value": value})
+			}
+			resp["payload"] = map[string]interface{}{"headers": headers}
+		}
+		reply(resp)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func newTestGmailAPI(t *testing.T, f *fakeGmail, opts ...GmailAPIOption) *GmailAPIClient {
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "ya29.test"})
+	return NewGmailAPIClient(ts, append([]GmailAPIOption{WithGmailAPIURL(srv.URL)}, opts...)...)
+}
+
+func subjects(emails []*Email) []string {
+	var s []string
+	for _, e := range emails {
+		s = append(s, e.Subject)
+	}
+	return s
+}
+
+func TestGmailAPIFetchNewEmails(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeGmail()
+	f.add("one", "two")
+	g := newTestGmailAPI(t, f, WithAPIBodies())
+
+	if err := g.Authenticate(ctx); err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	status, err := g.Status(ctx, Inbox)
+	if err != nil || status.Messages != 2 {
+		t.Fatalf("Status() = %+v, %v; want 2 messages", status, err)
+	}
+
+	// A cursor without a history ID lists the whole mailbox
+	emails, cursor, err := g.FetchNewEmails(ctx, Inbox, Cursor{UIDValidity: status.UIDValidity})
+	if err != nil {
+		t.Fatalf("FetchNewEmails() error = %v", err)
+	}
+	if got := subjects(emails); !reflect.DeepEqual(got, []string{"one", "two"}) {
+		t.Errorf("first fetch = %v; want [one two]", got)
+	}
+	if emails[0].TextBody != "body of one" || emails[0].MessageID != "<m1@example.com>" {
+		t.Errorf("first message = %+v; want its body and Message-ID", emails[0])
+	}
+	if cursor != status.Head() {
+		t.Errorf("cursor = %+v; want the history ID %+v", cursor, status.Head())
+	}
+
+	f.add("three")
+	emails, cursor, err = g.FetchNewEmails(ctx, Inbox, cursor)
+	if err != nil || !reflect.DeepEqual(subjects(emails), []string{"three"}) {
+		t.Fatalf("incremental fetch = %v, %v; want [three]", subjects(emails), err)
+	}
+	if emails[0].ProviderID != "m3" {
+		t.Errorf("ProviderID = %q; want the message ID m3", emails[0].ProviderID)
+	}
+
+	// The message can be fetched again by the UID it was returned with
+	uid := uidOf(t, g, "m3")
+	m, err := g.FetchMessage(ctx, Inbox, uid)
+	if err != nil || m.Subject != "three" {
+		t.Errorf("FetchMessage() = %v, %v; want message three", m, err)
+	}
+	if _, err := g.FetchMessage(ctx, Inbox, 999); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("FetchMessage() of an unknown UID error = %v; want ErrMessageNotFound", err)
+	}
+
+	// Until the cursor has moved past it
+	if emails, _, err = g.FetchNewEmails(ctx, Inbox, cursor); err != nil || len(emails) != 0 {
+		t.Errorf("fetch without new mail = %v, %v; want none", subjects(emails), err)
+	}
+	g.mu.Lock()
+	returned, uids := len(g.table(Inbox).returned), len(g.table(Inbox).uids)
+	g.mu.Unlock()
+	if returned != 0 || uids != 0 {
+		t.Errorf("table holds %d returned messages and %d UIDs; want none", returned, uids)
+	}
+	if _, err := g.FetchMessage(ctx, Inbox, uid); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("FetchMessage() of a forgotten UID error = %v; want ErrMessageNotFound", err)
+	}
+
+	// Once the history is gone the mailbox is listed again
+	f.oldest = f.nextID + 1
+	_, reset, err := g.FetchNewEmails(ctx, Inbox, cursor)
+	if !errors.Is(err, ErrUIDValidityChanged) || reset.LastUID != 0 || reset.UIDValidity == 0 {
+		t.Errorf("fetch with expired history = %+v, %v; want ErrUIDValidityChanged and a listing cursor", reset, err)
+	}
+}
+
+// uidOf returns the UID the client handed out for a message ID
+func uidOf(t *testing.T, g *GmailAPIClient, id string) uint32 {
+	t.Helper()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	uid, ok := g.table(Inbox).uids[id]
+	if !ok {
+		t.Fatalf("no UID for %s", id)
+	}
+	return uid
+}
+
+func TestGmailAPIMessageLimit(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeGmail()
+	g := newTestGmailAPI(t, f, WithMessageLimit(2))
+	status, err := g.Status(ctx, Inbox)
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	cursor := status.Head()
+
+	f.add("one", "two", "three")
+	f.add("four")
+	var got []string
+	for i := 0; i < 4; i++ {
+		emails, next, err := g.FetchNewEmails(ctx, Inbox, cursor)
+		if err != nil {
+			t.Fatalf("FetchNewEmails() error = %v", err)
+		}
+		if len(emails) > 2 {
+			t.Fatalf("fetch returned %d messages; want at most 2", len(emails))
+		}
+		got = append(got, subjects(emails)...)
+		cursor = next
+	}
+	if want := []string{"one", "two", "three", "four"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("limited fetches = %v; want %v", got, want)
+	}
+}
+
+func TestGmailAPILabels(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeGmail()
+	f.add("one", "two")
+	g := newTestGmailAPI(t, f)
+
+	if _, _, err := g.FetchNewEmails(ctx, Inbox, Cursor{UIDValidity: 1}); err != nil {
+		t.Fatalf("FetchNewEmails() error = %v", err)
+	}
+	uid := uidOf(t, g, "m1")
+	if err := g.ApplyLabel(ctx, Inbox, uid, "go-tsk/done"); err != nil {
+		t.Fatalf("ApplyLabel() error = %v", err)
+	}
+	if got := f.labelsOf("m1"); !reflect.DeepEqual(got, []string{"INBOX", "go-tsk/done"}) {
+		t.Errorf("labels after ApplyLabel() = %v; want the new label", got)
+	}
+	if names, err := g.ListMailboxes(ctx); err != nil || !contains(names, "go-tsk/done") {
+		t.Errorf("ListMailboxes() = %v, %v; want the created label", names, err)
+	}
+
+	uids, err := g.Search(ctx, Inbox, Filter{Label: "go-tsk/done"})
+	if err != nil || !reflect.DeepEqual(uids, []uint32{uid}) {
+		t.Errorf("Search() = %v, %v; want [%d]", uids, err, uid)
+	}
+	if uids, err = g.SearchMessageID(ctx, Inbox, "<m2@example.com>"); err != nil || len(uids) != 1 {
+		t.Errorf("SearchMessageID() = %v, %v; want one message", uids, err)
+	}
+
+	if err := g.RemoveLabel(ctx, Inbox, []uint32{uid}, "go-tsk/done"); err != nil {
+		t.Fatalf("RemoveLabel() error = %v", err)
+	}
+	if got := f.labelsOf("m1"); !reflect.DeepEqual(got, []string{"INBOX"}) {
+		t.Errorf("labels after RemoveLabel() = %v; want [INBOX]", got)
+	}
+	if err := g.DeleteMessages(ctx, Inbox, []uint32{uid}); err != nil {
+		t.Fatalf("DeleteMessages() error = %v", err)
+	}
+	if got := f.labelsOf("m1"); !contains(got, "TRASH") {
+		t.Errorf("labels after DeleteMessages() = %v; want TRASH", got)
+	}
+}
+
+func TestGmailAPIAuthenticationRejected(t *testing.T) {
+	srv := httptest.NewServer(newFakeGmail())
+	defer srv.Close()
+	g := NewGmailAPIClient(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "ya29.revoked"}), WithGmailAPIURL(srv.URL))
+	if err := g.Authenticate(context.Background()); err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Errorf("Authenticate() with a revoked token error = %v; want authentication failed", err)
+	}
+}
+
+func TestGmailAPIFilters(t *testing.T) {
+	f := newFakeGmail()
+	f.labels["Label_7"] = "Bills"
+	g := newTestGmailAPI(t, f)
+
+	filters, err := g.Filters(context.Background())
+	if err != nil {
+		t.Fatalf("Filters() error = %v", err)
+	}
+	want := []GmailFilter{{
+		From:           "billing@example.com",
+		Size:           1024,
+		SizeComparison: "larger",
+		AddLabels:      []string{"Bills", "STARRED"},
+		RemoveLabels:   []string{"INBOX"},
+	}}
+	if !reflect.DeepEqual(filters, want) {
+		t.Errorf("Filters() = %+v; want %+v", filters, want)
+	}
+}
@@ -1,26 +1,131 @@
 package email
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"time"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/commands"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/proxy"
 )
 
+// gmailIMAPAddr is Gmail's IMAP endpoint
+const gmailIMAPAddr = "imap.gmail.com:993"
+
 // GmailClient handles Gmail IMAP operations
 type GmailClient struct {
-	client     *client.Client
-	oauth2Conf *oauth2.Config
-	token      *oauth2.Token
+	client      *client.Client
+	addr        string
+	tlsConfig   *tls.Config
+	startTLS    bool         // Upgrades a connection in the clear with STARTTLS
+	dialer      proxy.Dialer // Connects through a proxy; nil dials directly
+	fetchBodies bool
+	username    string
+	oauth2Conf  *oauth2.Config
+	token       *oauth2.Token
+	tokens      oauth2.TokenSource // Supplies the access token instead of token when set
+	password    string             // Logs in with LOGIN instead of XOAUTH2 when set
+	fetch       config.FetchConfig // Chunking of FetchNewEmails; zero fields use the defaults
+	mu          sync.Mutex         // serializes operations on client
 }
 
-// NewGmailClient creates a new Gmail client
-func NewGmailClient(clientID, clientSecret, token string) (*GmailClient, error) {
-	oauth2Conf := &oauth2.Config{
+// Defaults of config.FetchConfig
+const (
+	defaultFetchWindow  = 500
+	defaultFetchWorkers = 4
+	defaultFetchLimit   = 10000
+)
+
+// GmailOption customizes a GmailClient
+type GmailOption func(*GmailClient)
+
+// WithServer points the client at another IMAP server, such as an
+// imaptest server in integration tests. tlsConfig may be nil to use the
+// system roots.
+func WithServer(addr string, tlsConfig *tls.Config) GmailOption {
+	return func(g *GmailClient) {
+		g.addr = addr
+		g.tlsConfig = tlsConfig
+	}
+}
+
+// WithStartTLS makes the client connect in the clear and upgrade the
+// connection with STARTTLS, as servers on port 143 expect. Connecting fails
+// if the server doesn't offer STARTTLS.
+func WithStartTLS() GmailOption {
+	return func(g *GmailClient) {
+		g.startTLS = true
+	}
+}
+
+// WithDialer makes the client connect with dialer, such as a proxy.Dialer
+func WithDialer(dialer proxy.Dialer) GmailOption {
+	return func(g *GmailClient) {
+		g.dialer = dialer
+	}
+}
+
+// WithTLS sets the TLS settings of the connection without changing the
+// server
+func WithTLS(tlsConfig *tls.Config) GmailOption {
+	return func(g *GmailClient) {
+		g.tlsConfig = tlsConfig
+	}
+}
+
+// WithBodies makes the client fetch and decode full message bodies into
+// Email.TextBody and Email.HTMLBody. Bodies are fetched with BODY.PEEK, so
+// messages are not marked as read.
+func WithBodies() GmailOption {
+	return func(g *GmailClient) {
+		g.fetchBodies = true
+	}
+}
+
+// WithTokenSource makes the client authenticate with the tokens of ts,
+// such as a stored token that is refreshed when it expires, instead of a
+// fixed access token
+func WithTokenSource(ts oauth2.TokenSource) GmailOption {
+	return func(g *GmailClient) {
+		g.tokens = ts
+	}
+}
+
+// WithPassword makes the client log in with a password, such as an app
+// password, instead of an OAuth2 token
+func WithPassword(password string) GmailOption {
+	return func(g *GmailClient) {
+		g.password = password
+	}
+}
+
+// WithFetch sets how new mail is fetched: in chunks of cfg.Window UIDs,
+// decoded by cfg.Workers goroutines, at most cfg.MaxMessages per mailbox
+// per call
+func WithFetch(cfg config.FetchConfig) GmailOption {
+	return func(g *GmailClient) {
+		g.fetch = cfg
+	}
+}
+
+// GmailOAuthConfig returns the OAuth2 config for Gmail IMAP access of an
+// OAuth client
+func GmailOAuthConfig(clientID, clientSecret string) *oauth2.Config {
+	return &oauth2.Config{
 		ClientID:     clientID,
 		ClientSecret: clientSecret,
 		Endpoint:     google.Endpoint,
@@ -28,6 +133,11 @@ func NewGmailClient(clientID, clientSecret, token string) (*GmailClient, error)
 			"https://mail.google.com/",
 		},
 	}
+}
+
+// NewGmailClient creates a new Gmail client
+func NewGmailClient(username, clientID, clientSecret, token string, opts ...GmailOption) (*GmailClient, error) {
+	oauth2Conf := GmailOAuthConfig(clientID, clientSecret)
 
 	// Parse the token
 	// In production, you'd want to implement proper token management
@@ -35,16 +145,22 @@ func NewGmailClient(clientID, clientSecret, token string) (*GmailClient, error)
 		AccessToken: token,
 	}
 
-	return &GmailClient{
+	g := &GmailClient{
+		addr:       gmailIMAPAddr,
+		username:   username,
 		oauth2Conf: oauth2Conf,
 		token:      tok,
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g, nil
 }
 
 // Connect establishes a connection to Gmail's IMAP server
-func (g *GmailClient) Connect() error {
+func (g *GmailClient) Connect(ctx context.Context) error {
 	// Connect to Gmail IMAP server
-	c, err := client.DialTLS("imap.gmail.com:993", nil)
+	c, err := dialTLS(ctx, g.dialer, g.addr, g.tlsConfig, g.startTLS)
 	if err != nil {
 		return fmt.Errorf("failed to connect to IMAP server: %w", err)
 	}
@@ -53,42 +169,291 @@ func (g *GmailClient) Connect() error {
 	return nil
 }
 
-// Authenticate performs OAuth2 authentication
-func (g *GmailClient) Authenticate() error {
-	if g.client == nil {
-		return fmt.Errorf("client not connected")
+// Authenticate performs OAuth2 authentication, or logs in with the
+// password of WithPassword
+func (g *GmailClient) Authenticate(ctx context.Context) error {
+	if g.password != "" {
+		err := g.run(ctx, commandTimeout, func(c *client.Client) error {
+			return c.Login(g.username, g.password)
+		})
+		if err != nil {
+			return fmt.Errorf("authentication failed: %w", err)
+		}
+		return nil
 	}
 
 	// Use OAuth2 token for authentication
-	if err := g.client.Authenticate(g.token); err != nil {
+	accessToken := g.token.AccessToken
+	if g.tokens != nil {
+		tok, err := g.tokens.Token()
+		if err != nil {
+			return fmt.Errorf("failed to get OAuth2 token: %w", err)
+		}
+		accessToken = tok.AccessToken
+	}
+	auth := &xoauth2Client{username: g.username, accessToken: accessToken}
+	err := g.run(ctx, commandTimeout, func(c *client.Client) error {
+		return c.Authenticate(auth)
+	})
+	if err != nil {
 		return fmt.Errorf("authentication failed: %w", err)
 	}
 
 	return nil
 }
 
-// FetchNewEmails retrieves emails newer than the given time
-func (g *GmailClient) FetchNewEmails(ctx context.Context, since time.Time) ([]*Email, error) {
-	if g.client == nil {
-		return nil, fmt.Errorf("client not connected")
+// ListMailboxes returns the names of all selectable mailboxes
+func (g *GmailClient) ListMailboxes(ctx context.Context) ([]string, error) {
+	var names []string
+	err := g.withReconnect(ctx, func() error {
+		return g.run(ctx, commandTimeout, func(c *client.Client) error {
+			names = nil
+			ch := make(chan *imap.MailboxInfo, 10)
+			done := make(chan error, 1)
+			go func() {
+				done <- c.List("", "*", ch)
+			}()
+
+			for info := range ch {
+				selectable := true
+				for _, attr := range info.Attributes {
+					if attr == imap.NoSelectAttr {
+						selectable = false
+					}
+				}
+				if selectable {
+					names = append(names, info.Name)
+				}
+			}
+			if err := <-done; err != nil {
+				return fmt.Errorf("list failed: %w", err)
+			}
+			return nil
+		})
+	})
+	return names, err
+}
+
+// FetchNewEmails retrieves the messages in mailbox after cursor and returns
+// the advanced cursor, reconnecting once if the connection was dropped. If
+// the mailbox's UIDVALIDITY differs from the cursor's, it returns
+// ErrUIDValidityChanged along with a cursor reset to the new validity.
+// Only the oldest Fetch.MaxMessages are returned; the cursor stops after
+// them, so the next call picks up the rest.
+func (g *GmailClient) FetchNewEmails(ctx context.Context, mailbox string, cursor Cursor) ([]*Email, Cursor, error) {
+	var emails []*Email
+	next, err := g.StreamNewEmails(ctx, mailbox, cursor, func(chunk []*Email, _ Cursor) error {
+		emails = append(emails, chunk...)
+		return nil
+	})
+	if err != nil {
+		return nil, next, err
 	}
+	return emails, next, nil
+}
 
-	// Select INBOX
-	mbox, err := g.client.Select("INBOX", false)
+// StreamNewEmails fetches the messages in mailbox after cursor like
+// FetchNewEmails, but hands them to fn one chunk of Fetch.Window at a
+// time, in ascending UID order, along with the cursor after the chunk. The
+// connection is free while fn runs, so fn may use the client. If fn fails,
+// the stream stops with its error. The returned cursor is the one after
+// the last chunk fn accepted.
+func (g *GmailClient) StreamNewEmails(ctx context.Context, mailbox string, cursor Cursor, fn func(emails []*Email, next Cursor) error) (Cursor, error) {
+	var uids []uint32
+	next := cursor
+	err := g.withReconnect(ctx, func() error {
+		return g.run(ctx, commandTimeout, func(c *client.Client) error {
+			var err error
+			uids, next, err = searchNew(c, mailbox, cursor)
+			return err
+		})
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to select inbox: %w", err)
+		if errors.Is(err, ErrUIDValidityChanged) {
+			return next, err
+		}
+		return cursor, err
 	}
+	if limit := g.fetchLimit(); len(uids) > limit {
+		log.Printf("%d new messages in %s; fetching the oldest %d, the rest on the next poll", len(uids), mailbox, limit)
+		uids = uids[:limit]
+		// The mod-sequence covers the messages left, too
+		next.ModSeq = 0
+	}
+
+	// The mod-sequence only holds once every message is handled
+	modSeq := next.ModSeq
+	next.ModSeq = 0
+	handled := cursor
+	window := g.fetchWindow()
+	for start := 0; start < len(uids); start += window {
+		end := start + window
+		if end > len(uids) {
+			end = len(uids)
+		}
+		chunk, err := g.fetchChunk(ctx, mailbox, next.UIDValidity, uids[start:end])
+		if err != nil {
+			return handled, err
+		}
+		next = next.advance(chunk)
+		if end == len(uids) {
+			next.ModSeq = modSeq
+		}
+		if err := fn(chunk, next); err != nil {
+			return handled, err
+		}
+		handled = next
+	}
+	next.ModSeq = modSeq
+	return next, nil
+}
+
+// fetchChunk fetches the given UIDs of mailbox, selecting it again if
+// another command, such as fn of StreamNewEmails, selected another one
+// meanwhile
+func (g *GmailClient) fetchChunk(ctx context.Context, mailbox string, uidValidity uint32, uids []uint32) ([]*Email, error) {
+	var emails []*Email
+	err := g.withReconnect(ctx, func() error {
+		return g.run(ctx, commandTimeout, func(c *client.Client) error {
+			mbox := c.Mailbox()
+			if mbox == nil || mbox.Name != mailbox {
+				var err error
+				if mbox, err = c.Select(mailbox, false); err != nil {
+					return fmt.Errorf("failed to select %s: %w", mailbox, err)
+				}
+			}
+			if mbox.UidValidity != uidValidity {
+				return fmt.Errorf("UIDVALIDITY of %s changed during the fetch", mailbox)
+			}
+
+			var err error
+			emails, err = fetchUIDs(c, uids, g.fetchBodies, g.fetchWorkers())
+			return err
+		})
+	})
+	return emails, err
+}
+
+// searchNew selects mailbox and returns the UIDs after cursor, in
+// ascending order, and the cursor with the mailbox's UIDVALIDITY and, on
+// servers with CONDSTORE, its HIGHESTMODSEQ. When the mod-sequence shows
+// that nothing changed since cursor, or that only flags changed or
+// messages went away, it returns without selecting or searching the
+// mailbox. Other servers are always searched. Flag changes on mail already
+// fetched are not looked at, as rules only act on new mail.
+func searchNew(c *client.Client, mailbox string, cursor Cursor) ([]uint32, Cursor, error) {
+	var modSeq uint64
+	if hasCondStore(c) {
+		status, err := c.Status(mailbox, []imap.StatusItem{imap.StatusUidNext, imap.StatusUidValidity, statusHighestModSeq})
+		if err != nil {
+			return nil, cursor, fmt.Errorf("failed to get status of %s: %w", mailbox, err)
+		}
+		modSeq = highestModSeq(status)
+		if cursor.ModSeq != 0 && modSeq != 0 && status.UidValidity == cursor.UIDValidity &&
+			(modSeq == cursor.ModSeq || status.UidNext <= cursor.LastUID+1) {
+			cursor.ModSeq = modSeq
+			return nil, cursor, nil
+		}
+	}
+
+	mbox, err := c.Select(mailbox, false)
+	if err != nil {
+		return nil, cursor, fmt.Errorf("failed to select %s: %w", mailbox, err)
+	}
+
+	if cursor.UIDValidity != 0 && cursor.UIDValidity != mbox.UidValidity {
+		return nil, Cursor{UIDValidity: mbox.UidValidity}, ErrUIDValidityChanged
+	}
+	cursor.UIDValidity = mbox.UidValidity
+	cursor.ModSeq = modSeq
+
+	uids, err := searchAfter(c, cursor.LastUID)
+	if err != nil {
+		return nil, cursor, err
+	}
+	return uids, cursor, nil
+}
 
-	// Search criteria
+// statusHighestModSeq is the STATUS item of CONDSTORE (RFC 7162) holding a
+// mailbox's highest mod-sequence, which grows with every change to it
+const statusHighestModSeq imap.StatusItem = "HIGHESTMODSEQ"
+
+// hasCondStore reports whether the server keeps mod-sequences. QRESYNC
+// implies CONDSTORE.
+func hasCondStore(c *client.Client) bool {
+	for _, capability := range []string{"CONDSTORE", "QRESYNC"} {
+		if ok, _ := c.Support(capability); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// highestModSeq returns the HIGHESTMODSEQ of a STATUS response, or 0 if the
+// mailbox has none, such as on servers that keep no mod-sequences for it
+func highestModSeq(status *imap.MailboxStatus) uint64 {
+	v, ok := status.Items[statusHighestModSeq].(string)
+	if !ok {
+		return 0
+	}
+	modSeq, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return modSeq
+}
+
+// fetchWindow returns the number of UIDs fetched per FETCH command
+func (g *GmailClient) fetchWindow() int {
+	if g.fetch.Window > 0 {
+		return g.fetch.Window
+	}
+	return defaultFetchWindow
+}
+
+// fetchWorkers returns the number of goroutines decoding fetched messages
+func (g *GmailClient) fetchWorkers() int {
+	if g.fetch.Workers > 0 {
+		return g.fetch.Workers
+	}
+	return defaultFetchWorkers
+}
+
+// fetchLimit returns the most messages FetchNewEmails returns at once
+func (g *GmailClient) fetchLimit() int {
+	if g.fetch.MaxMessages > 0 {
+		return g.fetch.MaxMessages
+	}
+	return defaultFetchLimit
+}
+
+// searchAfter returns the UIDs above afterUID in the selected mailbox, in
+// ascending order
+func searchAfter(c *client.Client, afterUID uint32) ([]uint32, error) {
 	criteria := imap.NewSearchCriteria()
-	criteria.Since = since
+	criteria.Uid = new(imap.SeqSet)
+	criteria.Uid.AddRange(afterUID+1, 0)
 
-	// Search for messages
-	uids, err := g.client.Search(criteria)
+	uids, err := c.UidSearch(criteria)
 	if err != nil {
 		return nil, fmt.Errorf("search failed: %w", err)
 	}
 
+	// "n:*" always matches the highest UID, even if it is below n
+	after := make([]uint32, 0, len(uids))
+	for _, uid := range uids {
+		if uid > afterUID {
+			after = append(after, uid)
+		}
+	}
+	sort.Slice(after, func(i, j int) bool { return after[i] < after[j] })
+	return after, nil
+}
+
+// fetchUIDs fetches envelopes, and optionally decoded bodies, for the given
+// UIDs in the selected mailbox and returns them in ascending UID order.
+// Messages are decoded by workers goroutines while the rest arrive.
+func fetchUIDs(c *client.Client, uids []uint32, bodies bool, workers int) ([]*Email, error) {
 	if len(uids) == 0 {
 		return nil, nil
 	}
@@ -98,72 +463,515 @@ func (g *GmailClient) FetchNewEmails(ctx context.Context, since time.Time) ([]*E
 	seqSet.AddNum(uids...)
 
 	// Define items to fetch
-	items := []imap.FetchItem{imap.FetchEnvelope, imap.FetchFlags, imap.FetchUid}
+	// The envelope lacks most header fields, such as References
+	headerSection := &imap.BodySectionName{BodyPartName: imap.BodyPartName{Specifier: imap.HeaderSpecifier}, Peek: true}
+	items := []imap.FetchItem{imap.FetchEnvelope, imap.FetchFlags, imap.FetchUid, imap.FetchRFC822Size, imap.FetchBodyStructure, headerSection.FetchItem()}
+	if ok, _ := c.Support(gmailExtension); ok {
+		items = append(items, fetchThreadID)
+	}
+	section := &imap.BodySectionName{Peek: true}
+	if bodies {
+		items = append(items, section.FetchItem())
+	}
+
+	if workers <= 0 {
+		workers = 1
+	}
 
 	// Fetch messages
-	messages := make(chan *imap.Message, 10)
+	messages := make(chan *imap.Message, 2*workers)
 	done := make(chan error, 1)
 
 	go func() {
-		done <- g.client.Fetch(seqSet, items, messages)
+		done <- c.UidFetch(seqSet, items, messages)
 	}()
 
-	// Process messages
-	var emails []*Email
-	for msg := range messages {
-		email := &Email{
-			UID:     msg.Uid,
-			Subject: msg.Envelope.Subject,
-			From:    formatAddresses(msg.Envelope.From),
-			Date:    msg.Envelope.Date,
-			Flags:   msg.Flags,
-		}
-		emails = append(emails, email)
+	// Decode messages
+	mailbox := c.Mailbox().Name
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	emails := make([]*Email, 0, len(uids))
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for msg := range messages {
+				email := decodeMessage(mailbox, msg, headerSection, section)
+				mu.Lock()
+				emails = append(emails, email)
+				mu.Unlock()
+			}
+		}()
 	}
+	wg.Wait()
 
 	if err := <-done; err != nil {
 		return nil, fmt.Errorf("fetch failed: %w", err)
 	}
 
+	sort.Slice(emails, func(i, j int) bool { return emails[i].UID < emails[j].UID })
 	return emails, nil
 }
 
-// ApplyLabel adds a label to an email
-func (g *GmailClient) ApplyLabel(uid uint32, label string) error {
-	if g.client == nil {
-		return fmt.Errorf("client not connected")
+// decodeMessage builds an Email from a fetched message and its header and
+// body sections
+func decodeMessage(mailbox string, msg *imap.Message, headerSection, section *imap.BodySectionName) *Email {
+	email := envelopeEmail(mailbox, msg)
+	if msg.BodyStructure != nil {
+		email.Attachments = structureAttachments(msg.BodyStructure)
 	}
+	if fields := msg.GetBody(headerSection); fields != nil {
+		email.Header = readHeader(fields)
+		if msg.Envelope != nil {
+			email.References = references(msg.Envelope.InReplyTo, email.Header.Get("References"))
+		}
+		email.ThreadID = threadID(email.MessageID, email.Header)
+		classify(email, email.Header)
+	}
+	if id := gmailThreadID(msg); id != "" {
+		email.ThreadID = id
+	}
+	if body := msg.GetBody(section); body != nil {
+		// A malformed body must not hide the message from the rules
+		var err error
+		if email.TextBody, email.HTMLBody, err = parseBody(body); err != nil {
+			log.Printf("Failed to decode body of message %d in %s: %v", msg.Uid, mailbox, err)
+		}
+	}
+	return email
+}
 
-	seqSet := new(imap.SeqSet)
-	seqSet.AddNum(uid)
+// envelopeEmail builds an Email from a fetched message's envelope
+func envelopeEmail(mailbox string, msg *imap.Message) *Email {
+	e := &Email{
+		Mailbox: mailbox,
+		UID:     msg.Uid,
+		Flags:   msg.Flags,
+		Size:    msg.Size,
+	}
+	if msg.Envelope != nil {
+		e.MessageID = msg.Envelope.MessageId
+		e.Subject = msg.Envelope.Subject
+		e.From = formatAddresses(msg.Envelope.From)
+		e.To, e.Cc = formatAddressList(msg.Envelope.To), formatAddressList(msg.Envelope.Cc)
+		e.Date = msg.Envelope.Date
+		e.References = bracketed(msg.Envelope.InReplyTo)
+	}
+	return e
+}
 
-	// In Gmail, labels are implemented as IMAP flags
-	return g.client.Store(seqSet, imap.AddFlags, []interface{}{label}, nil)
+// gmailExtension is the capability of servers with Gmail's IMAP
+// extensions, which give every message its thread ID
+const gmailExtension = "X-GM-EXT-1"
+
+// fetchThreadID fetches the Gmail thread ID of a message
+const fetchThreadID imap.FetchItem = "X-GM-THRID"
+
+// gmailThreadID returns the Gmail thread ID fetched with a message, or ""
+func gmailThreadID(msg *imap.Message) string {
+	v, ok := msg.Items[fetchThreadID]
+	if !ok || v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
 }
 
-// Close closes the IMAP connection
-func (g *GmailClient) Close() error {
-	if g.client == nil {
+// structureAttachments lists the attachments of a message's BODYSTRUCTURE:
+// the parts that are marked as attachments or have a file name. Their
+// decoded size is estimated, as the structure only has the encoded size.
+func structureAttachments(bs *imap.BodyStructure) []Attachment {
+	var attachments []Attachment
+	bs.Walk(func(path []int, part *imap.BodyStructure) bool {
+		if strings.EqualFold(part.MIMEType, "multipart") {
+			return true
+		}
+		filename, _ := part.Filename()
+		if filename == "" && !strings.EqualFold(part.Disposition, "attachment") {
+			return false
+		}
+		size := int64(part.Size)
+		if strings.EqualFold(part.Encoding, "base64") {
+			size = size * 3 / 4
+		}
+		attachments = append(attachments, Attachment{
+			Filename:    filename,
+			ContentType: strings.ToLower(part.MIMEType + "/" + part.MIMESubType),
+			Size:        size,
+		})
+		// An attached message's own parts are not attachments of this one
+		return false
+	})
+	return attachments
+}
+
+// FetchMessage retrieves one message in mailbox with all its headers,
+// decoded bodies and attachment list, whether or not the client fetches
+// bodies while polling. It returns ErrMessageNotFound if there is no
+// message with that UID.
+func (g *GmailClient) FetchMessage(ctx context.Context, mailbox string, uid uint32) (*Message, error) {
+	var m *Message
+	err := g.withReconnect(ctx, func() error {
+		return g.run(ctx, commandTimeout, func(c *client.Client) error {
+			if _, err := c.Select(mailbox, false); err != nil {
+				return fmt.Errorf("failed to select %s: %w", mailbox, err)
+			}
+
+			seqSet := new(imap.SeqSet)
+			seqSet.AddNum(uid)
+			section := &imap.BodySectionName{Peek: true}
+			items := []imap.FetchItem{imap.FetchEnvelope, imap.FetchFlags, imap.FetchUid, section.FetchItem()}
+
+			messages := make(chan *imap.Message, 1)
+			done := make(chan error, 1)
+			go func() {
+				done <- c.UidFetch(seqSet, items, messages)
+			}()
+
+			for msg := range messages {
+				if msg.Uid != uid || m != nil {
+					continue
+				}
+				parsed := &Message{}
+				if body := msg.GetBody(section); body != nil {
+					var err error
+					if parsed, err = parseMessage(body); err != nil {
+						log.Printf("Failed to decode message %d in %s: %v", uid, mailbox, err)
+					}
+				}
+				decoded := parsed.Email
+				parsed.Email = *envelopeEmail(mailbox, msg)
+				parsed.TextBody, parsed.HTMLBody = decoded.TextBody, decoded.HTMLBody
+				parsed.Header, parsed.Attachments, parsed.HasCalendar = decoded.Header, decoded.Attachments, decoded.HasCalendar
+				if msg.Envelope != nil {
+					parsed.References = references(msg.Envelope.InReplyTo, parsed.Header.Get("References"))
+				}
+				parsed.ThreadID = threadID(parsed.MessageID, parsed.Header)
+				classify(&parsed.Email, parsed.Header)
+				m = parsed
+			}
+
+			if err := <-done; err != nil {
+				return fmt.Errorf("fetch failed: %w", err)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, ErrMessageNotFound
+	}
+	return m, nil
+}
+
+// FetchRaw downloads one message as it was received. It returns
+// ErrMessageNotFound if the message is gone.
+func (g *GmailClient) FetchRaw(ctx context.Context, mailbox string, uid uint32) ([]byte, error) {
+	var raw []byte
+	err := g.withReconnect(ctx, func() error {
+		return g.run(ctx, commandTimeout, func(c *client.Client) error {
+			if _, err := c.Select(mailbox, false); err != nil {
+				return fmt.Errorf("failed to select %s: %w", mailbox, err)
+			}
+
+			seqSet := new(imap.SeqSet)
+			seqSet.AddNum(uid)
+			section := &imap.BodySectionName{Peek: true}
+			items := []imap.FetchItem{imap.FetchUid, section.FetchItem()}
+
+			messages := make(chan *imap.Message, 1)
+			done := make(chan error, 1)
+			go func() {
+				done <- c.UidFetch(seqSet, items, messages)
+			}()
+
+			for msg := range messages {
+				if msg.Uid != uid || raw != nil {
+					continue
+				}
+				if body := msg.GetBody(section); body != nil {
+					var buf bytes.Buffer
+					if _, err := io.Copy(&buf, body); err == nil {
+						raw = buf.Bytes()
+					}
+				}
+			}
+
+			if err := <-done; err != nil {
+				return fmt.Errorf("fetch failed: %w", err)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, ErrMessageNotFound
+	}
+	return raw, nil
+}
+
+// SearchMessageID returns the UIDs of the messages in mailbox with the
+// given Message-ID, in ascending order
+func (g *GmailClient) SearchMessageID(ctx context.Context, mailbox, messageID string) ([]uint32, error) {
+	var uids []uint32
+	err := g.withReconnect(ctx, func() error {
+		return g.run(ctx, commandTimeout, func(c *client.Client) error {
+			if _, err := c.Select(mailbox, false); err != nil {
+				return fmt.Errorf("failed to select %s: %w", mailbox, err)
+			}
+
+			criteria := imap.NewSearchCriteria()
+			criteria.Header.Add("Message-Id", messageID)
+			var err error
+			if uids, err = c.UidSearch(criteria); err != nil {
+				return fmt.Errorf("search failed: %w", err)
+			}
+			sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+			return nil
+		})
+	})
+	return uids, err
+}
+
+// Status returns the message count, UIDVALIDITY and UIDNEXT of a mailbox
+func (g *GmailClient) Status(ctx context.Context, mailbox string) (MailboxStatus, error) {
+	var status MailboxStatus
+	err := g.withReconnect(ctx, func() error {
+		return g.run(ctx, commandTimeout, func(c *client.Client) error {
+			items := []imap.StatusItem{imap.StatusMessages, imap.StatusUidValidity, imap.StatusUidNext}
+			mbox, err := c.Status(mailbox, items)
+			if err != nil {
+				return fmt.Errorf("status failed: %w", err)
+			}
+			status = MailboxStatus{
+				Messages:    int(mbox.Messages),
+				UIDValidity: mbox.UidValidity,
+				UIDNext:     mbox.UidNext,
+			}
+			if mbox.UidNext > 0 {
+				status.BatchEnd = mbox.UidNext - 1
+			}
+			return nil
+		})
+	})
+	return status, err
+}
+
+// FetchBatch retrieves up to limit messages in mailbox with UIDs above
+// afterUID, in ascending UID order
+func (g *GmailClient) FetchBatch(ctx context.Context, mailbox string, afterUID uint32, limit int) ([]*Email, error) {
+	var uids []uint32
+	var uidValidity uint32
+	err := g.withReconnect(ctx, func() error {
+		return g.run(ctx, commandTimeout, func(c *client.Client) error {
+			mbox, err := c.Select(mailbox, false)
+			if err != nil {
+				return fmt.Errorf("failed to select %s: %w", mailbox, err)
+			}
+			uidValidity = mbox.UidValidity
+			uids, err = searchAfter(c, afterUID)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(uids) > limit {
+		uids = uids[:limit]
+	}
+
+	emails := make([]*Email, 0, len(uids))
+	window := g.fetchWindow()
+	for start := 0; start < len(uids); start += window {
+		end := start + window
+		if end > len(uids) {
+			end = len(uids)
+		}
+		chunk, err := g.fetchChunk(ctx, mailbox, uidValidity, uids[start:end])
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, chunk...)
+	}
+	return emails, nil
+}
+
+// ApplyLabel adds a label to an email in mailbox, reconnecting once if the
+// connection was dropped
+func (g *GmailClient) ApplyLabel(ctx context.Context, mailbox string, uid uint32, label string) error {
+	return g.ApplyLabels(ctx, mailbox, []uint32{uid}, label)
+}
+
+// ApplyLabels adds a label to the given messages in mailbox with a single
+// UID STORE, reconnecting once if the connection was dropped
+func (g *GmailClient) ApplyLabels(ctx context.Context, mailbox string, uids []uint32, label string) error {
+	if len(uids) == 0 {
 		return nil
 	}
-	return g.client.Logout()
+	return g.withReconnect(ctx, func() error {
+		return g.run(ctx, commandTimeout, func(c *client.Client) error {
+			// A reconnect or another mailbox's fetch may have happened since
+			// this message was fetched
+			if c.Mailbox() == nil || c.Mailbox().Name != mailbox {
+				if _, err := c.Select(mailbox, false); err != nil {
+					return fmt.Errorf("failed to select %s: %w", mailbox, err)
+				}
+			}
+
+			seqSet := new(imap.SeqSet)
+			seqSet.AddNum(uids...)
+
+			// In Gmail, labels are implemented as IMAP flags
+			return c.UidStore(seqSet, imap.AddFlags, []interface{}{label}, nil)
+		})
+	})
+}
+
+// MoveMessage moves an email from mailbox to dest, which must exist. In
+// Gmail this swaps the mailbox's label for dest's.
+func (g *GmailClient) MoveMessage(ctx context.Context, mailbox string, uid uint32, dest string) error {
+	return g.MoveMessages(ctx, mailbox, []uint32{uid}, dest)
+}
+
+// MoveMessages moves the given messages from mailbox to dest with a single
+// UID MOVE
+func (g *GmailClient) MoveMessages(ctx context.Context, mailbox string, uids []uint32, dest string) error {
+	if len(uids) == 0 {
+		return nil
+	}
+	return g.withReconnect(ctx, func() error {
+		return g.run(ctx, commandTimeout, func(c *client.Client) error {
+			if _, err := c.Select(mailbox, false); err != nil {
+				return fmt.Errorf("failed to select %s: %w", mailbox, err)
+			}
+
+			seqSet := new(imap.SeqSet)
+			seqSet.AddNum(uids...)
+			if err := c.UidMove(seqSet, dest); err != nil {
+				return fmt.Errorf("failed to move to %s: %w", dest, err)
+			}
+			return nil
+		})
+	})
 }
 
-// Email represents an email message
-type Email struct {
-	UID     uint32
-	Subject string
-	From    string
-	Date    time.Time
-	Flags   []string
+// Search returns the UIDs of the messages in mailbox that match filter, in
+// ascending order
+func (g *GmailClient) Search(ctx context.Context, mailbox string, filter Filter) ([]uint32, error) {
+	var uids []uint32
+	err := g.withReconnect(ctx, func() error {
+		return g.run(ctx, commandTimeout, func(c *client.Client) error {
+			if _, err := c.Select(mailbox, false); err != nil {
+				return fmt.Errorf("failed to select %s: %w", mailbox, err)
+			}
+
+			criteria := imap.NewSearchCriteria()
+			criteria.Before = filter.Before
+			if filter.Label != "" {
+				criteria.WithFlags = []string{filter.Label}
+			}
+			var err error
+			if uids, err = c.UidSearch(criteria); err != nil {
+				return fmt.Errorf("search failed: %w", err)
+			}
+			sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+			return nil
+		})
+	})
+	return uids, err
 }
 
-// formatAddresses formats email addresses for display
+// RemoveLabel removes a label from the given messages in mailbox
+func (g *GmailClient) RemoveLabel(ctx context.Context, mailbox string, uids []uint32, label string) error {
+	if len(uids) == 0 {
+		return nil
+	}
+	return g.withReconnect(ctx, func() error {
+		return g.run(ctx, commandTimeout, func(c *client.Client) error {
+			if _, err := c.Select(mailbox, false); err != nil {
+				return fmt.Errorf("failed to select %s: %w", mailbox, err)
+			}
+
+			seqSet := new(imap.SeqSet)
+			seqSet.AddNum(uids...)
+			return c.UidStore(seqSet, imap.RemoveFlags, []interface{}{label}, nil)
+		})
+	})
+}
+
+// DeleteMessages permanently deletes the given messages in mailbox with
+// UID EXPUNGE. Servers without UIDPLUS are refused, as their EXPUNGE would
+// also remove every other message marked as deleted there.
+func (g *GmailClient) DeleteMessages(ctx context.Context, mailbox string, uids []uint32) error {
+	if len(uids) == 0 {
+		return nil
+	}
+	return g.withReconnect(ctx, func() error {
+		return g.run(ctx, commandTimeout, func(c *client.Client) error {
+			if ok, _ := c.Support("UIDPLUS"); !ok {
+				return fmt.Errorf("%w: the server lacks UID EXPUNGE (UIDPLUS)", ErrNotSupported)
+			}
+			if _, err := c.Select(mailbox, false); err != nil {
+				return fmt.Errorf("failed to select %s: %w", mailbox, err)
+			}
+
+			seqSet := new(imap.SeqSet)
+			seqSet.AddNum(uids...)
+			if err := c.UidStore(seqSet, imap.AddFlags, []interface{}{imap.DeletedFlag}, nil); err != nil {
+				return fmt.Errorf("failed to mark messages deleted: %w", err)
+			}
+			cmd := &commands.Uid{Cmd: &imap.Command{Name: "EXPUNGE", Arguments: []interface{}{seqSet}}}
+			status, err := c.Execute(cmd, nil)
+			if err == nil {
+				err = status.Err()
+			}
+			if err != nil {
+				return fmt.Errorf("expunge failed: %w", err)
+			}
+			return nil
+		})
+	})
+}
+
+// Close logs out and closes the IMAP connection
+func (g *GmailClient) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.client == nil {
+		return nil
+	}
+	return g.run(context.Background(), logoutTimeout, func(c *client.Client) error {
+		return c.Logout()
+	})
+}
+
+// formatAddresses formats the first of a list of email addresses for
+// display
 func formatAddresses(addrs []*imap.Address) string {
 	if len(addrs) == 0 {
 		return ""
 	}
-	addr := addrs[0]
+	return formatAddress(addrs[0])
+}
+
+// formatAddressList formats every address of a list. The group markers of
+// an address list with groups are left out.
+func formatAddressList(addrs []*imap.Address) []string {
+	var list []string
+	for _, addr := range addrs {
+		if addr.HostName != "" {
+			list = append(list, formatAddress(addr))
+		}
+	}
+	return list
+}
+
+// formatAddress formats one email address for display
+func formatAddress(addr *imap.Address) string {
 	if addr.PersonalName != "" {
 		return fmt.Sprintf("%s <%s@%s>", addr.PersonalName, addr.MailboxName, addr.HostName)
 	}
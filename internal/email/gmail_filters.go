@@ -0,0 +1,77 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// GmailFilter is a filter of a Gmail account, with its labels by name.
+// System labels keep their IDs, such as INBOX, UNREAD, STARRED and TRASH.
+type GmailFilter struct {
+	From           string
+	To             string
+	Subject        string
+	Query          string // Has the words
+	NegatedQuery   string // Doesn't have
+	HasAttachment  bool
+	Size           int    // Bytes; 0 means any size
+	SizeComparison string // "larger" or "smaller"
+
+	AddLabels    []string
+	RemoveLabels []string
+	Forward      string // Address matches are forwarded to
+
+	// Unknown names the settings of an exported filter go-tsk does not
+	// know
+	Unknown []string
+}
+
+// Filters returns the filters of the account
+func (g *GmailAPIClient) Filters(ctx context.Context) ([]GmailFilter, error) {
+	var resp struct {
+		Filter []struct {
+			Criteria struct {
+				From           string `json:"from"`
+				To             string `json:"to"`
+				Subject        string `json:"subject"`
+				Query          string `json:"query"`
+				NegatedQuery   string `json:"negatedQuery"`
+				HasAttachment  bool   `json:"hasAttachment"`
+				Size           int    `json:"size"`
+				SizeComparison string `json:"sizeComparison"`
+			} `json:"criteria"`
+			Action struct {
+				AddLabelIDs    []string `json:"addLabelIds"`
+				RemoveLabelIDs []string `json:"removeLabelIds"`
+				Forward        string   `json:"forward"`
+			} `json:"action"`
+		} `json:"filter"`
+	}
+	if err := g.do(ctx, http.MethodGet, "/settings/filters", nil, nil, &resp); err != nil {
+		return nil, fmt.Errorf("failed to list filters: %w", err)
+	}
+	// Filters refer to user labels by ID
+	if _, err := g.loadLabels(ctx, true); err != nil {
+		return nil, err
+	}
+
+	filters := make([]GmailFilter, len(resp.Filter))
+	for i, f := range resp.Filter {
+		c, a := f.Criteria, f.Action
+		filters[i] = GmailFilter{
+			From:           c.From,
+			To:             c.To,
+			Subject:        c.Subject,
+			Query:          c.Query,
+			NegatedQuery:   c.NegatedQuery,
+			HasAttachment:  c.HasAttachment,
+			Size:           c.Size,
+			SizeComparison: c.SizeComparison,
+			AddLabels:      g.labelNames(a.AddLabelIDs),
+			RemoveLabels:   g.labelNames(a.RemoveLabelIDs),
+			Forward:        a.Forward,
+		}
+	}
+	return filters, nil
+}
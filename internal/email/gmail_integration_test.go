@@ -0,0 +1,593 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/imaptest"
+)
+
+func fixtures() []imaptest.Message {
+	date := time.Date(2024, 3, 5, 9, 0, 0, 0, time.UTC)
+	return []imaptest.Message{
+		{MessageID: "<1@example.com>", Subject: "Job opportunity at Example Corp", From: "Recruiter <jobs@example.com>", Date: date},
+		{MessageID: "<2@example.com>", Subject: "Weekly newsletter", From: "news@example.com", Date: date.Add(time.Hour)},
+		{MessageID: "<3@example.com>", Subject: "Invoice #42", From: "billing@example.com", Date: date.Add(2 * time.Hour)},
+	}
+}
+
+// connectTestClient returns a client logged in to srv
+func connectTestClient(t *testing.T, srv *imaptest.Server, opts ...GmailOption) *GmailClient {
+	t.Helper()
+
+	opts = append([]GmailOption{WithServer(srv.Addr(), srv.TLSConfig())}, opts...)
+	g, err := NewGmailClient(imaptest.Username, "", "", imaptest.Token, opts...)
+	if err != nil {
+		t.Fatalf("NewGmailClient() error = %v", err)
+	}
+	ctx := context.Background()
+	if err := g.Connect(ctx); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	if err := g.Authenticate(ctx); err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	t.Cleanup(func() { g.Close() })
+	return g
+}
+
+func TestGmailClientFetchNewEmails(t *testing.T) {
+	srv := imaptest.New(t, fixtures()...)
+	g := connectTestClient(t, srv)
+	ctx := context.Background()
+
+	emails, cursor, err := g.FetchNewEmails(ctx, Inbox, Cursor{})
+	if err != nil {
+		t.Fatalf("FetchNewEmails() error = %v", err)
+	}
+	if len(emails) != 3 {
+		t.Fatalf("got %d emails; want 3", len(emails))
+	}
+	if cursor != (Cursor{UIDValidity: 1, LastUID: 3}) {
+		t.Errorf("cursor = %+v; want {1 3}", cursor)
+	}
+	first := emails[0]
+	if first.MessageID != "<1@example.com>" || first.From != "Recruiter <jobs@example.com>" {
+		t.Errorf("first email = %+v", first)
+	}
+
+	// Only mail that arrived after the cursor is returned
+	srv.Append("INBOX", imaptest.Message{MessageID: "<4@example.com>", InReplyTo: "<1@example.com>", Subject: "Re: Job offer", From: "boss@example.com"})
+	emails, cursor, err = g.FetchNewEmails(ctx, Inbox, cursor)
+	if err != nil {
+		t.Fatalf("FetchNewEmails() error = %v", err)
+	}
+	if len(emails) != 1 || emails[0].UID != 4 || cursor.LastUID != 4 {
+		t.Errorf("incremental fetch = %d emails, cursor %+v; want UID 4 only", len(emails), cursor)
+	}
+	if len(emails) == 1 && !reflect.DeepEqual(emails[0].References, []string{"<1@example.com>"}) {
+		t.Errorf("References = %q; want the answered message", emails[0].References)
+	}
+
+	// Nothing new: the "n:*" quirk must not return the newest message again
+	emails, _, err = g.FetchNewEmails(ctx, Inbox, cursor)
+	if err != nil {
+		t.Fatalf("FetchNewEmails() error = %v", err)
+	}
+	if len(emails) != 0 {
+		t.Errorf("got %d emails with nothing new; want 0", len(emails))
+	}
+}
+
+func TestGmailClientFetchChunks(t *testing.T) {
+	var messages []imaptest.Message
+	for i := 0; i < 7; i++ {
+		messages = append(messages, imaptest.Message{Subject: fmt.Sprintf("Message %d", i+1), From: "news@example.com"})
+	}
+	srv := imaptest.New(t, messages...)
+	g := connectTestClient(t, srv, WithFetch(config.FetchConfig{Window: 2, Workers: 3, MaxMessages: 5}))
+	ctx := context.Background()
+
+	// Past MaxMessages, the oldest are returned and the rest on the next call
+	var pages [][]uint32
+	cursor := Cursor{}
+	for {
+		emails, next, err := g.FetchNewEmails(ctx, Inbox, cursor)
+		if err != nil {
+			t.Fatalf("FetchNewEmails() error = %v", err)
+		}
+		if len(emails) == 0 {
+			break
+		}
+		var uids []uint32
+		for _, msg := range emails {
+			uids = append(uids, msg.UID)
+		}
+		pages = append(pages, uids)
+		cursor = next
+	}
+	want := [][]uint32{{1, 2, 3, 4, 5}, {6, 7}}
+	if !reflect.DeepEqual(pages, want) || cursor.LastUID != 7 {
+		t.Errorf("pages = %v, cursor %+v; want %v ending at UID 7", pages, cursor, want)
+	}
+}
+
+func TestGmailClientStreamNewEmails(t *testing.T) {
+	var messages []imaptest.Message
+	for i := 0; i < 5; i++ {
+		messages = append(messages, imaptest.Message{Subject: fmt.Sprintf("Message %d", i+1), From: "news@example.com"})
+	}
+	srv := imaptest.New(t, messages...)
+	srv.Append("Archive")
+	g := connectTestClient(t, srv, WithFetch(config.FetchConfig{Window: 2}))
+	ctx := context.Background()
+
+	// fn may use the connection between chunks, even on another mailbox
+	var chunks [][]uint32
+	next, err := g.StreamNewEmails(ctx, Inbox, Cursor{}, func(emails []*Email, next Cursor) error {
+		var uids []uint32
+		for _, msg := range emails {
+			uids = append(uids, msg.UID)
+		}
+		chunks = append(chunks, uids)
+		if next.LastUID != uids[len(uids)-1] {
+			t.Errorf("cursor after chunk %v = %+v", uids, next)
+		}
+		_, err := g.Search(ctx, "Archive", Filter{})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("StreamNewEmails() error = %v", err)
+	}
+	want := [][]uint32{{1, 2}, {3, 4}, {5}}
+	if !reflect.DeepEqual(chunks, want) || next.LastUID != 5 {
+		t.Errorf("chunks = %v, cursor %+v; want %v ending at UID 5", chunks, next, want)
+	}
+
+	// A failing fn stops the stream at the last chunk it accepted
+	stop := errors.New("stop")
+	calls := 0
+	next, err = g.StreamNewEmails(ctx, Inbox, Cursor{}, func(emails []*Email, next Cursor) error {
+		if calls++; calls == 2 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) || calls != 2 || next.LastUID != 2 {
+		t.Errorf("StreamNewEmails() = %+v, %v after %d chunks; want the stop error at UID 2", next, err, calls)
+	}
+}
+
+func TestGmailClientCondStore(t *testing.T) {
+	srv := imaptest.NewWithOptions(t, imaptest.Options{CondStore: true}, fixtures()...)
+	g := connectTestClient(t, srv)
+	ctx := context.Background()
+
+	emails, cursor, err := g.FetchNewEmails(ctx, Inbox, Cursor{})
+	if err != nil {
+		t.Fatalf("FetchNewEmails() error = %v", err)
+	}
+	if len(emails) != 3 || cursor.ModSeq == 0 {
+		t.Fatalf("first fetch = %d emails, cursor %+v; want 3 and a mod-sequence", len(emails), cursor)
+	}
+
+	// Neither an idle mailbox nor a flag change needs a search
+	searches := srv.Searches()
+	emails, cursor, err = g.FetchNewEmails(ctx, Inbox, cursor)
+	if err != nil || len(emails) != 0 {
+		t.Fatalf("idle fetch = %d emails, %v; want none", len(emails), err)
+	}
+	modSeq := cursor.ModSeq
+	if err := g.ApplyLabel(ctx, Inbox, 1, "imp"); err != nil {
+		t.Fatalf("ApplyLabel() error = %v", err)
+	}
+	emails, cursor, err = g.FetchNewEmails(ctx, Inbox, cursor)
+	if err != nil || len(emails) != 0 || cursor.ModSeq <= modSeq {
+		t.Fatalf("fetch after a flag change = %d emails, cursor %+v, %v; want none and a newer mod-sequence", len(emails), cursor, err)
+	}
+	if n := srv.Searches(); n != searches {
+		t.Errorf("searched %d times without new mail; want no searches", n-searches)
+	}
+
+	// New mail is searched for and fetched
+	srv.Append("INBOX", imaptest.Message{Subject: "Invoice #43", From: "billing@example.com"})
+	emails, cursor, err = g.FetchNewEmails(ctx, Inbox, cursor)
+	if err != nil || len(emails) != 1 || emails[0].UID != 4 || cursor.LastUID != 4 {
+		t.Errorf("fetch of new mail = %d emails, cursor %+v, %v; want UID 4", len(emails), cursor, err)
+	}
+}
+
+func TestGmailClientClassifyingHeaders(t *testing.T) {
+	srv := imaptest.New(t,
+		imaptest.Message{MessageID: "<1@example.com>", Subject: "Hello", From: "friend@example.com",
+			To: "Me <me@example.com>", Cc: "team@example.com, boss@example.com"},
+		imaptest.Message{MessageID: "<2@example.com>", InReplyTo: "<0@example.com>", Subject: "Weekly news", From: "news@example.com", Headers: []string{
+			"References: <a@example.com>\r\n <0@example.com>",
+			"List-Id: <news.example.com>",
+			"Auto-Submitted: auto-generated",
+			"Authentication-Results: mx.example.com; spf=fail smtp.mailfrom=example.com",
+			"X-Ticket: OPS-12",
+		}},
+	)
+	g := connectTestClient(t, srv)
+
+	emails, _, err := g.FetchNewEmails(context.Background(), Inbox, Cursor{})
+	if err != nil {
+		t.Fatalf("FetchNewEmails() error = %v", err)
+	}
+	if len(emails) != 2 {
+		t.Fatalf("got %d emails; want 2", len(emails))
+	}
+	if e := emails[0]; e.Bulk || e.AutoGenerated || e.Auth != (AuthResults{}) || e.References != nil {
+		t.Errorf("personal email = %+v; want it unclassified", e)
+	}
+	if e := emails[0]; !reflect.DeepEqual(e.To, []string{"Me <me@example.com>"}) || !reflect.DeepEqual(e.Cc, []string{"team@example.com", "boss@example.com"}) {
+		t.Errorf("recipients = %q, cc %q", e.To, e.Cc)
+	}
+	e := emails[1]
+	if !e.Bulk || !e.AutoGenerated || e.Auth.SPF != "fail" {
+		t.Errorf("newsletter = %+v; want bulk, automatic, failing SPF", e)
+	}
+	if want := []string{"<0@example.com>", "<a@example.com>"}; !reflect.DeepEqual(e.References, want) {
+		t.Errorf("References = %q; want %q", e.References, want)
+	}
+	if e.ThreadID != "<a@example.com>" || emails[0].ThreadID != "<1@example.com>" {
+		t.Errorf("thread IDs = %q, %q; want the first References entry or the Message-ID", emails[0].ThreadID, e.ThreadID)
+	}
+	if got := e.Header.Get("X-Ticket"); got != "OPS-12" {
+		t.Errorf("X-Ticket header = %q; want every header field", got)
+	}
+}
+
+func TestGmailClientAttachments(t *testing.T) {
+	structure := &imap.BodyStructure{MIMEType: "multipart", MIMESubType: "mixed", Parts: []*imap.BodyStructure{
+		{MIMEType: "text", MIMESubType: "plain", Size: 120},
+		{MIMEType: "image", MIMESubType: "PNG", Encoding: "base64", Size: 4000,
+			Disposition: "inline", DispositionParams: map[string]string{"filename": "logo.png"}},
+		{MIMEType: "application", MIMESubType: "octet-stream", Encoding: "7bit", Size: 500, Disposition: "attachment"},
+	}}
+	srv := imaptest.New(t,
+		imaptest.Message{MessageID: "<1@example.com>", Subject: "Plain", From: "friend@example.com", Body: "Hi"},
+		imaptest.Message{MessageID: "<2@example.com>", Subject: "Files", From: "friend@example.com", Structure: structure},
+	)
+	g := connectTestClient(t, srv)
+
+	emails, _, err := g.FetchNewEmails(context.Background(), Inbox, Cursor{})
+	if err != nil {
+		t.Fatalf("FetchNewEmails() error = %v", err)
+	}
+	if len(emails) != 2 {
+		t.Fatalf("got %d emails; want 2", len(emails))
+	}
+	if emails[0].Attachments != nil {
+		t.Errorf("plain email attachments = %+v; want none", emails[0].Attachments)
+	}
+	want := []Attachment{
+		{Filename: "logo.png", ContentType: "image/png", Size: 3000},
+		{ContentType: "application/octet-stream", Size: 500},
+	}
+	if !reflect.DeepEqual(emails[1].Attachments, want) {
+		t.Errorf("Attachments = %+v; want %+v", emails[1].Attachments, want)
+	}
+}
+
+func TestGmailClientUIDValidityChange(t *testing.T) {
+	srv := imaptest.New(t, fixtures()...)
+	g := connectTestClient(t, srv)
+	ctx := context.Background()
+
+	_, cursor, err := g.FetchNewEmails(ctx, Inbox, Cursor{})
+	if err != nil {
+		t.Fatalf("FetchNewEmails() error = %v", err)
+	}
+
+	srv.ResetUIDValidity("INBOX", 99)
+	_, next, err := g.FetchNewEmails(ctx, Inbox, cursor)
+	if !errors.Is(err, ErrUIDValidityChanged) {
+		t.Fatalf("FetchNewEmails() error = %v; want ErrUIDValidityChanged", err)
+	}
+	if next != (Cursor{UIDValidity: 99}) {
+		t.Errorf("reset cursor = %+v; want {99 0}", next)
+	}
+}
+
+func TestGmailClientApplyLabel(t *testing.T) {
+	srv := imaptest.New(t, fixtures()...)
+	g := connectTestClient(t, srv)
+
+	if err := g.ApplyLabel(context.Background(), Inbox, 2, "imp"); err != nil {
+		t.Fatalf("ApplyLabel() error = %v", err)
+	}
+	if flags := srv.Flags("INBOX", 2); len(flags) != 1 || flags[0] != "imp" {
+		t.Errorf("flags of UID 2 = %v; want [imp]", flags)
+	}
+	if flags := srv.Flags("INBOX", 1); len(flags) != 0 {
+		t.Errorf("flags of UID 1 = %v; want none", flags)
+	}
+}
+
+func TestGmailClientDeleteMessages(t *testing.T) {
+	msgs := fixtures()
+	msgs[2].Flags = []string{imap.DeletedFlag} // Marked by another client
+	srv := imaptest.New(t, msgs...)
+	g := connectTestClient(t, srv)
+
+	// Only the given message goes, not the other one marked deleted
+	if err := g.DeleteMessages(context.Background(), Inbox, []uint32{1}); err != nil {
+		t.Fatalf("DeleteMessages() error = %v", err)
+	}
+	left := srv.Messages("INBOX")
+	if len(left) != 2 || left[0].UID != 2 || left[1].UID != 3 || len(left[1].Flags) != 1 {
+		t.Errorf("messages left = %+v; want UIDs 2 and 3", left)
+	}
+
+	// Without UID EXPUNGE nothing is deleted
+	srv = imaptest.NewWithOptions(t, imaptest.Options{NoUIDPlus: true}, fixtures()...)
+	g = connectTestClient(t, srv)
+	if err := g.DeleteMessages(context.Background(), Inbox, []uint32{1}); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("DeleteMessages() without UIDPLUS error = %v; want ErrNotSupported", err)
+	}
+	if flags := srv.Flags("INBOX", 1); len(flags) != 0 || len(srv.Messages("INBOX")) != 3 {
+		t.Errorf("flags of UID 1 = %v; want the mailbox untouched", flags)
+	}
+}
+
+func TestGmailClientMoveMessage(t *testing.T) {
+	srv := imaptest.New(t, fixtures()...)
+	srv.Append("Snoozed")
+	g := connectTestClient(t, srv)
+
+	if err := g.MoveMessage(context.Background(), Inbox, 2, "Snoozed"); err != nil {
+		t.Fatalf("MoveMessage() error = %v", err)
+	}
+	if msgs := srv.Messages("INBOX"); len(msgs) != 2 {
+		t.Errorf("INBOX has %d messages after the move; want 2", len(msgs))
+	}
+	if msgs := srv.Messages("Snoozed"); len(msgs) != 1 || msgs[0].MessageID != "<2@example.com>" {
+		t.Errorf("Snoozed = %+v; want the moved message", msgs)
+	}
+	if err := g.MoveMessage(context.Background(), Inbox, 1, "Missing"); err == nil {
+		t.Error("MoveMessage() to a missing mailbox succeeded")
+	}
+}
+
+func TestGmailClientStatusAndBatches(t *testing.T) {
+	srv := imaptest.New(t, fixtures()...)
+	g := connectTestClient(t, srv)
+	ctx := context.Background()
+
+	status, err := g.Status(ctx, Inbox)
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	if status != (MailboxStatus{Messages: 3, UIDValidity: 1, UIDNext: 4, BatchEnd: 3}) {
+		t.Errorf("Status() = %+v", status)
+	}
+
+	var uids []uint32
+	var after uint32
+	for {
+		batch, err := g.FetchBatch(ctx, Inbox, after, 2)
+		if err != nil {
+			t.Fatalf("FetchBatch() error = %v", err)
+		}
+		if len(batch) == 0 {
+			break
+		}
+		for _, msg := range batch {
+			uids = append(uids, msg.UID)
+		}
+		after = batch[len(batch)-1].UID
+	}
+	if len(uids) != 3 || uids[0] != 1 || uids[2] != 3 {
+		t.Errorf("batched UIDs = %v; want [1 2 3]", uids)
+	}
+}
+
+func TestGmailClientReconnect(t *testing.T) {
+	srv := imaptest.New(t, fixtures()...)
+	g := connectTestClient(t, srv)
+
+	srv.DropConnections()
+
+	emails, _, err := g.FetchNewEmails(context.Background(), Inbox, Cursor{})
+	if err != nil {
+		t.Fatalf("FetchNewEmails() after dropped connection error = %v", err)
+	}
+	if len(emails) != 3 {
+		t.Errorf("got %d emails after reconnect; want 3", len(emails))
+	}
+}
+
+func TestGmailClientBadToken(t *testing.T) {
+	srv := imaptest.New(t)
+
+	g, err := NewGmailClient(imaptest.Username, "", "", "wrong", WithServer(srv.Addr(), srv.TLSConfig()))
+	if err != nil {
+		t.Fatalf("NewGmailClient() error = %v", err)
+	}
+	ctx := context.Background()
+	if err := g.Connect(ctx); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer g.Close()
+
+	if err := g.Authenticate(ctx); err == nil {
+		t.Error("Authenticate() with wrong token succeeded")
+	}
+}
+
+func TestGmailClientOtherMailboxes(t *testing.T) {
+	srv := imaptest.New(t, fixtures()...)
+	srv.Append("Receipts", imaptest.Message{MessageID: "<r1@example.com>", Subject: "Your receipt", From: "shop@example.com"})
+	srv.Append("Lists/golang", imaptest.Message{Subject: "Go 1.22 released", From: "golang-announce@example.com"})
+	g := connectTestClient(t, srv)
+	ctx := context.Background()
+
+	names, err := g.ListMailboxes(ctx)
+	if err != nil {
+		t.Fatalf("ListMailboxes() error = %v", err)
+	}
+	resolved := ResolveMailboxes([]string{"INBOX", "Lists/*"}, names)
+	if len(resolved) != 2 || resolved[1] != "Lists/golang" {
+		t.Errorf("resolved mailboxes = %v (listed %v); want [INBOX Lists/golang]", resolved, names)
+	}
+
+	emails, cursor, err := g.FetchNewEmails(ctx, "Receipts", Cursor{})
+	if err != nil {
+		t.Fatalf("FetchNewEmails(Receipts) error = %v", err)
+	}
+	if len(emails) != 1 || emails[0].Mailbox != "Receipts" || cursor.LastUID != 1 {
+		t.Fatalf("Receipts fetch = %+v, cursor %+v", emails, cursor)
+	}
+
+	// Labeling in Receipts after INBOX was selected must hit the right UID 1
+	if _, _, err := g.FetchNewEmails(ctx, Inbox, Cursor{}); err != nil {
+		t.Fatalf("FetchNewEmails(INBOX) error = %v", err)
+	}
+	if err := g.ApplyLabel(ctx, "Receipts", 1, "paid"); err != nil {
+		t.Fatalf("ApplyLabel() error = %v", err)
+	}
+	if flags := srv.Flags("Receipts", 1); len(flags) != 1 || flags[0] != "paid" {
+		t.Errorf("Receipts UID 1 flags = %v; want [paid]", flags)
+	}
+	if flags := srv.Flags("INBOX", 1); len(flags) != 0 {
+		t.Errorf("INBOX UID 1 flags = %v; want none", flags)
+	}
+}
+
+func TestGmailClientFetchBodies(t *testing.T) {
+	srv := imaptest.New(t,
+		imaptest.Message{Subject: "Plain", From: "a@example.com", Body: "Hello from the body"},
+		imaptest.Message{Raw: []byte("From: b@example.com\r\nSubject: Rich\r\n" +
+			"Content-Type: multipart/alternative; boundary=b1\r\n\r\n" +
+			"--b1\r\nContent-Type: text/plain; charset=iso-8859-1\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nR=E9sum=E9 attached\r\n" +
+			"--b1\r\nContent-Type: text/html\r\n\r\n<p>R&eacute;sum&eacute;</p>\r\n" +
+			"--b1--\r\n")},
+	)
+	g := connectTestClient(t, srv)
+	WithBodies()(g)
+
+	emails, _, err := g.FetchNewEmails(context.Background(), Inbox, Cursor{})
+	if err != nil {
+		t.Fatalf("FetchNewEmails() error = %v", err)
+	}
+	if len(emails) != 2 {
+		t.Fatalf("got %d emails; want 2", len(emails))
+	}
+	if emails[0].TextBody != "Hello from the body" {
+		t.Errorf("TextBody = %q", emails[0].TextBody)
+	}
+	if emails[1].TextBody != "Résumé attached" || emails[1].HTMLBody != "<p>R&eacute;sum&eacute;</p>" {
+		t.Errorf("bodies = %q, %q", emails[1].TextBody, emails[1].HTMLBody)
+	}
+
+	// Bodies are fetched with BODY.PEEK, so nothing is marked as read
+	if flags := srv.Flags("INBOX", 1); len(flags) != 0 {
+		t.Errorf("flags after fetch = %v; want none", flags)
+	}
+}
+
+func TestGmailClientFetchMessage(t *testing.T) {
+	srv := imaptest.New(t, fixtures()...)
+	g := connectTestClient(t, srv)
+	ctx := context.Background()
+
+	// Messages are fetched in full even without WithBodies
+	uids, err := g.SearchMessageID(ctx, Inbox, "<2@example.com>")
+	if err != nil {
+		t.Fatalf("SearchMessageID() error = %v", err)
+	}
+	if len(uids) != 1 || uids[0] != 2 {
+		t.Fatalf("SearchMessageID() = %v; want [2]", uids)
+	}
+
+	m, err := g.FetchMessage(ctx, Inbox, uids[0])
+	if err != nil {
+		t.Fatalf("FetchMessage() error = %v", err)
+	}
+	if m.Subject != "Weekly newsletter" || m.MessageID != "<2@example.com>" || m.Mailbox != Inbox {
+		t.Errorf("message = %+v", m.Email)
+	}
+	if got := m.Header.Get("From"); got != "news@example.com" {
+		t.Errorf("From header = %q", got)
+	}
+
+	if _, err := g.FetchMessage(ctx, Inbox, 99); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("FetchMessage(99) error = %v; want ErrMessageNotFound", err)
+	}
+	if uids, err := g.SearchMessageID(ctx, Inbox, "<missing@example.com>"); err != nil || len(uids) != 0 {
+		t.Errorf("SearchMessageID(missing) = %v, %v; want none", uids, err)
+	}
+}
+
+func TestGmailClientTLSSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		startTLS bool
+		tls      func(srv *imaptest.Server) config.TLSConfig
+		wantErr  bool
+	}{
+		{
+			name: "custom CA",
+			tls:  func(srv *imaptest.Server) config.TLSConfig { return config.TLSConfig{CAFile: srv.CAFile(t)} },
+		},
+		{
+			name:     "STARTTLS with custom CA",
+			startTLS: true,
+			tls: func(srv *imaptest.Server) config.TLSConfig {
+				return config.TLSConfig{CAFile: srv.CAFile(t), StartTLS: true, MinVersion: "1.3"}
+			},
+		},
+		{
+			name:    "untrusted server",
+			tls:     func(srv *imaptest.Server) config.TLSConfig { return config.TLSConfig{} },
+			wantErr: true,
+		},
+		{
+			name: "skip verification",
+			tls:  func(srv *imaptest.Server) config.TLSConfig { return config.TLSConfig{InsecureSkipVerify: true} },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := imaptest.New
+			if tt.startTLS {
+				start = imaptest.NewStartTLS
+			}
+			srv := start(t, fixtures()...)
+			account := config.EmailAccount{ID: "lab", TLS: tt.tls(srv)}
+			opts, err := imapServerOptions(account, srv.Addr())
+			if err != nil {
+				t.Fatalf("imapServerOptions() error = %v", err)
+			}
+			g, err := NewGmailClient(imaptest.Username, "", "", imaptest.Token, opts...)
+			if err != nil {
+				t.Fatalf("NewGmailClient() error = %v", err)
+			}
+			defer g.Close()
+
+			ctx := context.Background()
+			err = g.Connect(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Connect() error = %v; wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if err := g.Authenticate(ctx); err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if _, _, err := g.FetchNewEmails(ctx, Inbox, Cursor{}); err != nil {
+				t.Errorf("FetchNewEmails() error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2/google"
+)
+
+// pubSubEndpoint is the Pub/Sub REST API root
+const pubSubEndpoint = "https://pubsub.googleapis.com/v1/"
+
+// pubSubPullTimeout bounds a pull, which the server holds open until
+// messages arrive
+const pubSubPullTimeout = 2 * time.Minute
+
+// Watch asks Gmail to publish a notification to the Pub/Sub topic whenever
+// mail arrives in one of mailboxes, or in any mailbox if mailboxes is
+// empty. The watch lasts until the returned time and must be renewed
+// before then.
+func (g *GmailAPIClient) Watch(ctx context.Context, topic string, mailboxes []string) (time.Time, error) {
+	req := struct {
+		TopicName           string   `json:"topicName"`
+		LabelIDs            []string `json:"labelIds,omitempty"`
+		LabelFilterBehavior string   `json:"labelFilterBehavior,omitempty"`
+	}{TopicName: topic}
+	for _, mailbox := range mailboxes {
+		id, err := g.mailboxLabel(ctx, mailbox)
+		if err != nil {
+			return time.Time{}, err
+		}
+		req.LabelIDs = append(req.LabelIDs, id)
+	}
+	if len(req.LabelIDs) > 0 {
+		req.LabelFilterBehavior = "include"
+	}
+
+	var resp struct {
+		Expiration string `json:"expiration"` // Milliseconds since the epoch
+	}
+	if err := g.do(ctx, http.MethodPost, "/watch", nil, req, &resp); err != nil {
+		return time.Time{}, fmt.Errorf("failed to watch mailbox: %w", err)
+	}
+	ms, err := strconv.ParseInt(resp.Expiration, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid watch expiration %q", resp.Expiration)
+	}
+	return time.UnixMilli(ms), nil
+}
+
+// StopWatch stops the notifications started by Watch
+func (g *GmailAPIClient) StopWatch(ctx context.Context) error {
+	if err := g.do(ctx, http.MethodPost, "/stop", nil, struct{}{}, nil); err != nil {
+		return fmt.Errorf("failed to stop watching mailbox: %w", err)
+	}
+	return nil
+}
+
+// GmailNotification is the notification Gmail publishes when mail arrives
+// in a watched mailbox
+type GmailNotification struct {
+	EmailAddress string      `json:"emailAddress"`
+	HistoryID    json.Number `json:"historyId"`
+}
+
+// PushSubscription pulls Gmail notifications from a Pub/Sub subscription.
+// Credentials come from Application Default Credentials, like those of the
+// Pub/Sub event sink.
+type PushSubscription struct {
+	name     string
+	endpoint string
+	client   *http.Client
+}
+
+// NewPushSubscription creates a client for the subscription with the
+// given fully qualified name
+func NewPushSubscription(ctx context.Context, name string) (*PushSubscription, error) {
+	client, err := google.DefaultClient(ctx, "https://www.googleapis.com/auth/pubsub")
+	if err != nil {
+		return nil, fmt.Errorf("failed to find Google credentials: %w", err)
+	}
+	client.Timeout = pubSubPullTimeout
+	return &PushSubscription{name: name, endpoint: pubSubEndpoint, client: client}, nil
+}
+
+// Pull waits for notifications and acknowledges them. Notifications that
+// cannot be decoded are acknowledged and dropped, so they do not come back
+// forever.
+func (s *PushSubscription) Pull(ctx context.Context) ([]GmailNotification, error) {
+	var resp struct {
+		ReceivedMessages []struct {
+			AckID   string `json:"ackId"`
+			Message struct {
+				Data string `json:"data"`
+			} `json:"message"`
+		} `json:"receivedMessages"`
+	}
+	if err := s.call(ctx, "pull", map[string]int{"maxMessages": 100}, &resp); err != nil {
+		return nil, fmt.Errorf("failed to pull %s: %w", s.name, err)
+	}
+	if len(resp.ReceivedMessages) == 0 {
+		return nil, nil
+	}
+
+	var notes []GmailNotification
+	ackIDs := make([]string, 0, len(resp.ReceivedMessages))
+	for _, m := range resp.ReceivedMessages {
+		ackIDs = append(ackIDs, m.AckID)
+		data, err := base64.StdEncoding.DecodeString(m.Message.Data)
+		if err != nil {
+			continue
+		}
+		var n GmailNotification
+		if err := json.Unmarshal(data, &n); err != nil || n.EmailAddress == "" {
+			continue
+		}
+		notes = append(notes, n)
+	}
+	if err := s.call(ctx, "acknowledge", map[string][]string{"ackIds": ackIDs}, nil); err != nil {
+		// Unacknowledged notifications are delivered again, which only
+		// costs an extra poll
+		return notes, fmt.Errorf("failed to acknowledge %s: %w", s.name, err)
+	}
+	return notes, nil
+}
+
+// call invokes a subscription method and decodes the response into out,
+// which may be nil
+func (s *PushSubscription) call(ctx context.Context, method string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint+s.name+":"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	if out == nil {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		return nil
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 16<<20)).Decode(out)
+}
@@ -0,0 +1,91 @@
+package email
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestGmailAPIWatch(t *testing.T) {
+	f := newFakeGmail()
+	var watch struct {
+		TopicName           string   `json:"topicName"`
+		LabelIDs            []string `json:"labelIds"`
+		LabelFilterBehavior string   `json:"labelFilterBehavior"`
+	}
+	stopped := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("/watch", func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&watch)
+		w.Write([]byte(`{"historyId": "1234", "expiration": "1700000000000"}`))
+	})
+	mux.HandleFunc("/stop", func(w http.ResponseWriter, r *http.Request) {
+		stopped = true
+	})
+	mux.Handle("/", f)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	g := NewGmailAPIClient(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "ya29.test"}), WithGmailAPIURL(srv.URL))
+	expires, err := g.Watch(context.Background(), "projects/p/topics/gmail", []string{Inbox})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	if want := time.UnixMilli(1700000000000); !expires.Equal(want) {
+		t.Errorf("Watch() expires %s; want %s", expires, want)
+	}
+	if watch.TopicName != "projects/p/topics/gmail" || !reflect.DeepEqual(watch.LabelIDs, []string{"INBOX"}) || watch.LabelFilterBehavior != "include" {
+		t.Errorf("watch request = %+v; want the topic and INBOX", watch)
+	}
+	if _, err := g.Watch(context.Background(), "projects/p/topics/gmail", []string{"Nosuch"}); err == nil {
+		t.Error("Watch() of an unknown mailbox succeeded")
+	}
+
+	if err := g.StopWatch(context.Background()); err != nil || !stopped {
+		t.Errorf("StopWatch() = %v, stopped %v; want the watch stopped", err, stopped)
+	}
+}
+
+func TestPushSubscriptionPull(t *testing.T) {
+	data := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	var acked []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/projects/p/subscriptions/go-tsk:pull":
+			json.NewEncoder(w).Encode(map[string]interface{}{"receivedMessages": []interface{}{
+				map[string]interface{}{"ackId": "a1", "message": map[string]string{"data": data(`{"emailAddress": "me@example.com", "historyId": 1234}`)}},
+				map[string]interface{}{"ackId": "a2", "message": map[string]string{"data": data(`{"emailAddress": "me@example.com", "historyId": "1240"}`)}},
+				map[string]interface{}{"ackId": "a3", "message": map[string]string{"data": data(`not json`)}},
+			}})
+		case "/projects/p/subscriptions/go-tsk:acknowledge":
+			var req struct {
+				AckIDs []string `json:"ackIds"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			acked = req.AckIDs
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	s := &PushSubscription{name: "projects/p/subscriptions/go-tsk", endpoint: srv.URL + "/", client: srv.Client()}
+	notes, err := s.Pull(context.Background())
+	if err != nil {
+		t.Fatalf("Pull() error = %v", err)
+	}
+	want := []GmailNotification{{"me@example.com", "1234"}, {"me@example.com", "1240"}}
+	if !reflect.DeepEqual(notes, want) {
+		t.Errorf("Pull() = %+v; want %+v", notes, want)
+	}
+	if !reflect.DeepEqual(acked, []string{"a1", "a2", "a3"}) {
+		t.Errorf("acknowledged %v; want every message, including the undecodable one", acked)
+	}
+}
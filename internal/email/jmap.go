@@ -0,0 +1,905 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jmapUsing lists the capabilities every JMAP request uses
+var jmapUsing = []string{"urn:ietf:params:jmap:core", "urn:ietf:params:jmap:mail"}
+
+// jmapEmailProperties are the Email properties fetched for polling
+var jmapEmailProperties = []string{"id", "blobId", "threadId", "messageId", "inReplyTo", "references", "headers", "attachments", "subject", "from", "to", "cc", "receivedAt", "keywords"}
+
+// errCannotCalculateChanges is returned when the server no longer has the
+// changes since a query state
+var errCannotCalculateChanges = errors.New("cannot calculate changes")
+
+// JMAPClient works on a JMAP server (RFC 8620, 8621) such as Fastmail or
+// Stalwart. Labels are JMAP keywords and new mail is found with
+// Email/queryChanges against the state of the previous fetch.
+//
+// JMAP identifies messages by string IDs, so the client hands out UIDs of
+// its own, valid for as long as the client lives, and sets
+// Email.ProviderID to the Email ID, which outlives them. Cursors hold the receipt
+// time of the newest message seen, in seconds since the epoch, so a
+// restarted client resumes with Email/query from that time; UIDValidity is
+// derived from the mailbox ID and changes if the mailbox is recreated.
+type JMAPClient struct {
+	sessionURL  string
+	token       string
+	client      *http.Client
+	fetchBodies bool
+	maxMessages int
+
+	mu        sync.Mutex
+	session   *jmapSession
+	mailboxes map[string]string // Mailbox IDs by name; nil until listed
+	tables    map[string]*uidTable
+	synced    map[string]jmapSync // By mailbox
+}
+
+// jmapSync is where the last fetch of a mailbox left off
+type jmapSync struct {
+	cursor     Cursor // Cursor the fetch returned
+	queryState string // State of the mailbox query after the fetch
+}
+
+// jmapSession holds what the session resource tells about the account
+type jmapSession struct {
+	APIURL          string            `json:"apiUrl"`
+	DownloadURL     string            `json:"downloadUrl"`
+	PrimaryAccounts map[string]string `json:"primaryAccounts"`
+	accountID       string
+}
+
+// JMAPOption customizes a JMAPClient
+type JMAPOption func(*JMAPClient)
+
+// WithJMAPBodies makes the client download and decode full message bodies
+// into Email.TextBody and Email.HTMLBody
+func WithJMAPBodies() JMAPOption {
+	return func(j *JMAPClient) {
+		j.fetchBodies = true
+	}
+}
+
+// WithJMAPMessageLimit makes FetchNewEmails return at most n messages,
+// oldest first, leaving the rest for the next fetch
+func WithJMAPMessageLimit(n int) JMAPOption {
+	return func(j *JMAPClient) {
+		j.maxMessages = n
+	}
+}
+
+// WithJMAPTransport sends the client's requests through transport, such
+// as one using the account's proxy
+func WithJMAPTransport(transport http.RoundTripper) JMAPOption {
+	return func(j *JMAPClient) {
+		j.client.Transport = transport
+	}
+}
+
+// NewJMAPClient creates a client for the JMAP server with the given
+// session URL, authenticating with a bearer token
+func NewJMAPClient(sessionURL, token string, opts ...JMAPOption) *JMAPClient {
+	j := &JMAPClient{
+		sessionURL: sessionURL,
+		token:      token,
+		client:     &http.Client{Timeout: commandTimeout},
+		tables:     make(map[string]*uidTable),
+		synced:     make(map[string]jmapSync),
+	}
+	for _, opt := range opts {
+		opt(j)
+	}
+	return j
+}
+
+// Connect does nothing; every call is a separate HTTP request
+func (j *JMAPClient) Connect(ctx context.Context) error {
+	return nil
+}
+
+// Authenticate fetches the session resource, which fails unless the token
+// is accepted
+func (j *JMAPClient) Authenticate(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.sessionURL, nil)
+	if err != nil {
+		return err
+	}
+	var s jmapSession
+	if err := j.send(req, &s); err != nil {
+		return fmt.Errorf("authentication failed: %w", err)
+	}
+	s.accountID = s.PrimaryAccounts["urn:ietf:params:jmap:mail"]
+	if s.accountID == "" || s.APIURL == "" {
+		return fmt.Errorf("JMAP session has no mail account")
+	}
+	s.APIURL = j.resolve(s.APIURL)
+
+	j.mu.Lock()
+	j.session = &s
+	j.mu.Unlock()
+	return nil
+}
+
+// resolve resolves a URL given by the session resource against it
+func (j *JMAPClient) resolve(ref string) string {
+	base, err := url.Parse(j.sessionURL)
+	if err != nil {
+		return ref
+	}
+	u, err := url.Parse(ref)
+	if err != nil || u.IsAbs() {
+		return ref
+	}
+	return base.ResolveReference(u).String()
+}
+
+// call invokes one JMAP method and decodes its response arguments into
+// out, which may be nil. A method error is returned as an error; the
+// cannotCalculateChanges error as errCannotCalculateChanges.
+func (j *JMAPClient) call(ctx context.Context, method string, args map[string]interface{}, out interface{}) error {
+	j.mu.Lock()
+	s := j.session
+	j.mu.Unlock()
+	if s == nil {
+		if err := j.Authenticate(ctx); err != nil {
+			return err
+		}
+		j.mu.Lock()
+		s = j.session
+		j.mu.Unlock()
+	}
+
+	args["accountId"] = s.accountID
+	body, err := json.Marshal(map[string]interface{}{
+		"using":       jmapUsing,
+		"methodCalls": []interface{}{[]interface{}{method, args, "0"}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.APIURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var resp struct {
+		MethodResponses [][3]json.RawMessage `json:"methodResponses"`
+	}
+	if err := j.send(req, &resp); err != nil {
+		return fmt.Errorf("%s failed: %w", method, err)
+	}
+	if len(resp.MethodResponses) != 1 {
+		return fmt.Errorf("%s: got %d responses", method, len(resp.MethodResponses))
+	}
+	var name string
+	if err := json.Unmarshal(resp.MethodResponses[0][0], &name); err != nil {
+		return fmt.Errorf("%s: invalid response: %w", method, err)
+	}
+	if name == "error" {
+		var e struct {
+			Type        string `json:"type"`
+			Description string `json:"description"`
+		}
+		json.Unmarshal(resp.MethodResponses[0][1], &e)
+		if e.Type == "cannotCalculateChanges" {
+			return errCannotCalculateChanges
+		}
+		return fmt.Errorf("%s failed: %s %s", method, e.Type, e.Description)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(resp.MethodResponses[0][1], out); err != nil {
+		return fmt.Errorf("%s: invalid response: %w", method, err)
+	}
+	return nil
+}
+
+// send sends an authenticated request and decodes the JSON response into
+// out, which may be nil
+func (j *JMAPClient) send(req *http.Request, out interface{}) error {
+	req.Header.Set("Authorization", "Bearer "+j.token)
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		return nil
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<20)).Decode(out); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	return nil
+}
+
+// ListMailboxes returns the names of all mailboxes, with child mailboxes
+// named by their path such as "Lists/go". The mailbox with the inbox role
+// is INBOX.
+func (j *JMAPClient) ListMailboxes(ctx context.Context) ([]string, error) {
+	mailboxes, err := j.loadMailboxes(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(mailboxes))
+	for name := range mailboxes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// loadMailboxes returns the mailbox IDs by name, listing them if they were
+// not listed yet or reload is set
+func (j *JMAPClient) loadMailboxes(ctx context.Context, reload bool) (map[string]string, error) {
+	j.mu.Lock()
+	mailboxes := j.mailboxes
+	j.mu.Unlock()
+	if mailboxes != nil && !reload {
+		return mailboxes, nil
+	}
+
+	var resp struct {
+		List []struct {
+			ID       string `json:"id"`
+			Name     string `json:"name"`
+			ParentID string `json:"parentId"`
+			Role     string `json:"role"`
+		} `json:"list"`
+	}
+	args := map[string]interface{}{"ids": nil, "properties": []string{"id", "name", "parentId", "role"}}
+	if err := j.call(ctx, "Mailbox/get", args, &resp); err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]int, len(resp.List))
+	for i, m := range resp.List {
+		byID[m.ID] = i
+	}
+	mailboxes = make(map[string]string, len(resp.List))
+	for _, m := range resp.List {
+		if m.Role == "inbox" {
+			mailboxes[Inbox] = m.ID
+			continue
+		}
+		path := m.Name
+		for parent, depth := m.ParentID, 0; parent != "" && depth < 32; depth++ {
+			i, ok := byID[parent]
+			if !ok {
+				break
+			}
+			path = resp.List[i].Name + "/" + path
+			parent = resp.List[i].ParentID
+		}
+		mailboxes[path] = m.ID
+	}
+
+	j.mu.Lock()
+	j.mailboxes = mailboxes
+	j.mu.Unlock()
+	return mailboxes, nil
+}
+
+// mailboxID returns the ID of a mailbox, which must exist
+func (j *JMAPClient) mailboxID(ctx context.Context, mailbox string) (string, error) {
+	for _, reload := range []bool{false, true} {
+		mailboxes, err := j.loadMailboxes(ctx, reload)
+		if err != nil {
+			return "", err
+		}
+		if id, ok := mailboxes[mailbox]; ok {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("no mailbox %q", mailbox)
+}
+
+// validity derives the UIDValidity of a mailbox from its ID
+func validity(mailboxID string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(mailboxID))
+	return h.Sum32() | 1
+}
+
+// jmapEmail is an Email object as fetched for polling
+type jmapEmail struct {
+	ID         string   `json:"id"`
+	BlobID     string   `json:"blobId"`
+	ThreadID   string   `json:"threadId"`
+	MessageID  []string `json:"messageId"`
+	InReplyTo  []string `json:"inReplyTo"`
+	References []string `json:"references"`
+	Headers    []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"headers"`
+	Attachments []struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+		Size int64  `json:"size"`
+	} `json:"attachments"`
+	Subject    string          `json:"subject"`
+	From       []jmapAddress   `json:"from"`
+	To         []jmapAddress   `json:"to"`
+	Cc         []jmapAddress   `json:"cc"`
+	ReceivedAt time.Time       `json:"receivedAt"`
+	Keywords   map[string]bool `json:"keywords"`
+}
+
+type jmapAddress struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// format formats the address as From is
+func (a jmapAddress) format() string {
+	if a.Name != "" {
+		return fmt.Sprintf("%s <%s>", a.Name, a.Email)
+	}
+	return a.Email
+}
+
+// formatJMAPAddresses formats every address of a list
+func formatJMAPAddresses(addrs []jmapAddress) []string {
+	var list []string
+	for _, a := range addrs {
+		list = append(list, a.format())
+	}
+	return list
+}
+
+// mailboxQuery returns the arguments of the Email/query whose changes are
+// followed for a mailbox, oldest first
+func mailboxQuery(mailboxID string) map[string]interface{} {
+	return map[string]interface{}{
+		"filter": map[string]interface{}{"inMailbox": mailboxID},
+		"sort":   []map[string]interface{}{{"property": "receivedAt", "isAscending": true}},
+	}
+}
+
+// FetchNewEmails retrieves the messages added to mailbox since cursor,
+// oldest first, and returns the cursor after them. If the mailbox was
+// recreated, it returns ErrUIDValidityChanged along with a cursor that
+// lists the whole mailbox.
+func (j *JMAPClient) FetchNewEmails(ctx context.Context, mailbox string, cursor Cursor) ([]*Email, Cursor, error) {
+	mailboxID, err := j.mailboxID(ctx, mailbox)
+	if err != nil {
+		return nil, cursor, err
+	}
+	v := validity(mailboxID)
+	if cursor.UIDValidity != 0 && cursor.UIDValidity != v {
+		return nil, Cursor{UIDValidity: v}, ErrUIDValidityChanged
+	}
+
+	j.mu.Lock()
+	last, ok := j.synced[mailbox]
+	j.mu.Unlock()
+
+	var ids []string
+	var queryState string
+	if ok && last.cursor == cursor {
+		ids, queryState, err = j.queryChanges(ctx, mailboxID, last.queryState)
+	}
+	if !ok || last.cursor != cursor || errors.Is(err, errCannotCalculateChanges) {
+		ids, queryState, err = j.querySince(ctx, mailboxID, cursor.LastUID)
+	}
+	if err != nil {
+		return nil, cursor, err
+	}
+
+	// Skip messages returned before, e.g. those received in the second
+	// the cursor points at. Those received earlier are not queried again.
+	j.mu.Lock()
+	t := j.table(mailbox)
+	t.prune(uint64(cursor.LastUID))
+	fresh := ids[:0]
+	for _, id := range ids {
+		if _, ok := t.returned[id]; !ok {
+			fresh = append(fresh, id)
+		}
+	}
+	j.mu.Unlock()
+	ids = fresh
+
+	limited := j.maxMessages > 0 && len(ids) > j.maxMessages
+	if limited {
+		ids = ids[:j.maxMessages]
+	}
+	fetched, err := j.get(ctx, ids)
+	if err != nil {
+		return nil, cursor, err
+	}
+
+	next := Cursor{UIDValidity: v, LastUID: cursor.LastUID}
+	var emails []*Email
+	received := make(map[string]uint32, len(fetched))
+	for _, m := range fetched {
+		e, err := j.email(ctx, mailbox, m, j.fetchBodies)
+		if err != nil {
+			return nil, cursor, err
+		}
+		at := uint32(m.ReceivedAt.Unix())
+		if at > next.LastUID {
+			next.LastUID = at
+		}
+		received[m.ID] = at
+		emails = append(emails, e)
+	}
+	if next.LastUID == 0 {
+		// An empty mailbox starts from now, so LastUID 0 keeps meaning a
+		// whole listing
+		next.LastUID = uint32(time.Now().Unix())
+	}
+
+	j.mu.Lock()
+	for _, id := range ids {
+		// Messages get returned no earlier than their receipt time
+		t.returned[id] = uint64(received[id])
+	}
+	if limited {
+		// The rest is found by time, as the query state is past it
+		delete(j.synced, mailbox)
+	} else {
+		j.synced[mailbox] = jmapSync{cursor: next, queryState: queryState}
+	}
+	j.mu.Unlock()
+	return emails, next, nil
+}
+
+// queryChanges returns the IDs of the messages added to a mailbox since
+// the query state, oldest first, and the new query state
+func (j *JMAPClient) queryChanges(ctx context.Context, mailboxID, state string) ([]string, string, error) {
+	args := mailboxQuery(mailboxID)
+	args["sinceQueryState"] = state
+	var resp struct {
+		NewQueryState string `json:"newQueryState"`
+		Added         []struct {
+			ID    string `json:"id"`
+			Index int    `json:"index"`
+		} `json:"added"`
+	}
+	if err := j.call(ctx, "Email/queryChanges", args, &resp); err != nil {
+		return nil, "", err
+	}
+	sort.Slice(resp.Added, func(a, b int) bool { return resp.Added[a].Index < resp.Added[b].Index })
+	ids := make([]string, len(resp.Added))
+	for i, a := range resp.Added {
+		ids[i] = a.ID
+	}
+	return ids, resp.NewQueryState, nil
+}
+
+// querySince returns the IDs of the messages in a mailbox received in or
+// after the second since (all of them if since is 0), oldest first, and the
+// state of the mailbox query
+func (j *JMAPClient) querySince(ctx context.Context, mailboxID string, since uint32) ([]string, string, error) {
+	var state string
+	filter := map[string]interface{}{"inMailbox": mailboxID}
+	if since > 0 {
+		// The state to follow is that of the unfiltered mailbox query;
+		// taking it first means nothing added during the listing is missed
+		args := mailboxQuery(mailboxID)
+		args["limit"] = 0
+		var resp struct {
+			QueryState string `json:"queryState"`
+		}
+		if err := j.call(ctx, "Email/query", args, &resp); err != nil {
+			return nil, "", err
+		}
+		state = resp.QueryState
+
+		// after is exclusive, and several messages may share a second
+		filter["after"] = time.Unix(int64(since)-1, 0).UTC().Format(time.RFC3339)
+	}
+
+	var ids []string
+	for {
+		args := mailboxQuery(mailboxID)
+		args["filter"] = filter
+		args["position"] = len(ids)
+		var resp struct {
+			IDs        []string `json:"ids"`
+			QueryState string   `json:"queryState"`
+		}
+		if err := j.call(ctx, "Email/query", args, &resp); err != nil {
+			return nil, "", err
+		}
+		if state == "" {
+			state = resp.QueryState
+		}
+		if len(resp.IDs) == 0 {
+			return ids, state, nil
+		}
+		ids = append(ids, resp.IDs...)
+	}
+}
+
+// get fetches the polling properties of messages, keeping their order and
+// leaving out those that no longer exist
+func (j *JMAPClient) get(ctx context.Context, ids []string) ([]jmapEmail, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	var resp struct {
+		List []jmapEmail `json:"list"`
+	}
+	args := map[string]interface{}{"ids": ids, "properties": jmapEmailProperties}
+	if err := j.call(ctx, "Email/get", args, &resp); err != nil {
+		return nil, err
+	}
+	byID := make(map[string]jmapEmail, len(resp.List))
+	for _, m := range resp.List {
+		byID[m.ID] = m
+	}
+	list := make([]jmapEmail, 0, len(resp.List))
+	for _, id := range ids {
+		if m, ok := byID[id]; ok {
+			list = append(list, m)
+		}
+	}
+	return list, nil
+}
+
+// email converts a fetched message into an Email with a UID in mailbox,
+// downloading and decoding its body if full is set
+func (j *JMAPClient) email(ctx context.Context, mailbox string, m jmapEmail, full bool) (*Email, error) {
+	msg, err := j.message(ctx, mailbox, m, full)
+	if err != nil {
+		return nil, err
+	}
+	return &msg.Email, nil
+}
+
+// message converts a fetched message into a Message with a UID in
+// mailbox, downloading and decoding it if full is set
+func (j *JMAPClient) message(ctx context.Context, mailbox string, m jmapEmail, full bool) (*Message, error) {
+	msg := &Message{}
+	if full {
+		raw, err := j.download(ctx, m.BlobID)
+		if err != nil {
+			return nil, err
+		}
+		msg = readMessage(bytes.NewReader(raw), true, m.ID)
+	}
+
+	// The server's parsed properties replace the fields readMessage took
+	// from the header, so a message gets the same fields fetched either way
+	msg.Mailbox = mailbox
+	msg.MessageID = ""
+	if len(m.MessageID) > 0 {
+		msg.MessageID = "<" + m.MessageID[0] + ">"
+	}
+	msg.Subject = m.Subject
+	if len(m.From) > 0 {
+		msg.From = m.From[0].format()
+	}
+	msg.To, msg.Cc = formatJMAPAddresses(m.To), formatJMAPAddresses(m.Cc)
+	msg.Date = m.ReceivedAt
+	// JMAP lists Message-IDs without their angle brackets
+	var refs []string
+	for _, ids := range [][]string{m.InReplyTo, m.References} {
+		for _, id := range ids {
+			refs = append(refs, "<"+id+">")
+		}
+	}
+	msg.References = references(strings.Join(refs, " "), "")
+	msg.ThreadID = m.ThreadID
+	header := make(textproto.MIMEHeader)
+	for _, h := range m.Headers {
+		header.Add(h.Name, decodeHeader(strings.TrimSpace(h.Value)))
+	}
+	if !full {
+		msg.Header = header
+	}
+	classify(&msg.Email, header)
+	if !full {
+		for _, a := range m.Attachments {
+			msg.Attachments = append(msg.Attachments, Attachment{Filename: a.Name, ContentType: strings.ToLower(a.Type), Size: a.Size})
+		}
+	}
+	for k := range m.Keywords {
+		msg.Flags = append(msg.Flags, k)
+	}
+	sort.Strings(msg.Flags)
+
+	j.mu.Lock()
+	msg.UID = j.table(mailbox).assign(m.ID)
+	msg.ProviderID = m.ID
+	j.mu.Unlock()
+	return msg, nil
+}
+
+// download fetches the raw message with the given blob ID
+func (j *JMAPClient) download(ctx context.Context, blobID string) ([]byte, error) {
+	j.mu.Lock()
+	s := j.session
+	j.mu.Unlock()
+	u := strings.NewReplacer(
+		"{accountId}", url.PathEscape(s.accountID),
+		"{blobId}", url.PathEscape(blobID),
+		"{type}", url.QueryEscape("message/rfc822"),
+		"{name}", "message.eml",
+	).Replace(s.DownloadURL)
+	u = j.resolve(u)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+j.token)
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download message: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("failed to download message: server returned %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+}
+
+// table returns the UID table of a mailbox; j.mu must be held
+func (j *JMAPClient) table(mailbox string) *uidTable {
+	t, ok := j.tables[mailbox]
+	if !ok {
+		t = newUIDTable()
+		j.tables[mailbox] = t
+	}
+	return t
+}
+
+// emailID returns the Email ID behind a UID handed out in mailbox
+func (j *JMAPClient) emailID(mailbox string, uid uint32) (string, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	id, ok := j.table(mailbox).ids[uid]
+	if !ok {
+		return "", ErrMessageNotFound
+	}
+	return id, nil
+}
+
+// emailIDs returns the Email IDs behind UIDs handed out in mailbox,
+// skipping unknown ones
+func (j *JMAPClient) emailIDs(mailbox string, uids []uint32) []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	t := j.table(mailbox)
+	ids := make([]string, 0, len(uids))
+	for _, uid := range uids {
+		if id, ok := t.ids[uid]; ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// FetchBatch retrieves up to limit messages of mailbox, oldest first,
+// after the first afterUID. The UID of each message is its position in the
+// mailbox, so a backfill resumes where it stopped.
+func (j *JMAPClient) FetchBatch(ctx context.Context, mailbox string, afterUID uint32, limit int) ([]*Email, error) {
+	mailboxID, err := j.mailboxID(ctx, mailbox)
+	if err != nil {
+		return nil, err
+	}
+	args := mailboxQuery(mailboxID)
+	args["position"] = afterUID
+	args["limit"] = limit
+	var resp struct {
+		IDs []string `json:"ids"`
+	}
+	if err := j.call(ctx, "Email/query", args, &resp); err != nil {
+		return nil, err
+	}
+	fetched, err := j.get(ctx, resp.IDs)
+	if err != nil {
+		return nil, err
+	}
+
+	position := make(map[string]uint32, len(resp.IDs))
+	for i, id := range resp.IDs {
+		position[id] = afterUID + uint32(i) + 1
+	}
+	var emails []*Email
+	for _, m := range fetched {
+		e, err := j.email(ctx, mailbox, m, j.fetchBodies)
+		if err != nil {
+			return nil, err
+		}
+		e.UID = position[m.ID]
+		j.mu.Lock()
+		j.table(mailbox).set(m.ID, e.UID)
+		j.mu.Unlock()
+		emails = append(emails, e)
+	}
+	return emails, nil
+}
+
+// Status returns the size of a mailbox. Its UIDVALIDITY is derived from
+// the mailbox ID and its UIDNEXT is the current time, so a poll starting
+// from the head skips the mail received so far. FetchBatch numbers
+// messages by position, so the size is also where a batch ends.
+func (j *JMAPClient) Status(ctx context.Context, mailbox string) (MailboxStatus, error) {
+	mailboxID, err := j.mailboxID(ctx, mailbox)
+	if err != nil {
+		return MailboxStatus{}, err
+	}
+	var resp struct {
+		List []struct {
+			TotalEmails int `json:"totalEmails"`
+		} `json:"list"`
+	}
+	args := map[string]interface{}{"ids": []string{mailboxID}, "properties": []string{"totalEmails"}}
+	if err := j.call(ctx, "Mailbox/get", args, &resp); err != nil {
+		return MailboxStatus{}, err
+	}
+	if len(resp.List) == 0 {
+		return MailboxStatus{}, fmt.Errorf("no mailbox %q", mailbox)
+	}
+	return MailboxStatus{
+		Messages:    resp.List[0].TotalEmails,
+		UIDValidity: validity(mailboxID),
+		UIDNext:     uint32(time.Now().Unix()) + 1,
+		BatchEnd:    uint32(resp.List[0].TotalEmails),
+	}, nil
+}
+
+// keywordPatch returns the Email/set patch path of a keyword
+func keywordPatch(label string) string {
+	return "keywords/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(label)
+}
+
+// ApplyLabel sets a label as a keyword on a message
+func (j *JMAPClient) ApplyLabel(ctx context.Context, mailbox string, uid uint32, label string) error {
+	id, err := j.emailID(mailbox, uid)
+	if err != nil {
+		return err
+	}
+	return j.set(ctx, map[string]interface{}{"update": map[string]interface{}{id: map[string]interface{}{keywordPatch(label): true}}})
+}
+
+// RemoveLabel clears a keyword on messages
+func (j *JMAPClient) RemoveLabel(ctx context.Context, mailbox string, uids []uint32, label string) error {
+	update := make(map[string]interface{})
+	for _, id := range j.emailIDs(mailbox, uids) {
+		update[id] = map[string]interface{}{keywordPatch(label): nil}
+	}
+	if len(update) == 0 {
+		return nil
+	}
+	return j.set(ctx, map[string]interface{}{"update": update})
+}
+
+// DeleteMessages destroys messages
+func (j *JMAPClient) DeleteMessages(ctx context.Context, mailbox string, uids []uint32) error {
+	ids := j.emailIDs(mailbox, uids)
+	if len(ids) == 0 {
+		return nil
+	}
+	return j.set(ctx, map[string]interface{}{"destroy": ids})
+}
+
+// set calls Email/set and fails if any change was refused
+func (j *JMAPClient) set(ctx context.Context, args map[string]interface{}) error {
+	var resp struct {
+		NotUpdated   map[string]jmapSetError `json:"notUpdated"`
+		NotDestroyed map[string]jmapSetError `json:"notDestroyed"`
+	}
+	if err := j.call(ctx, "Email/set", args, &resp); err != nil {
+		return err
+	}
+	for id, e := range resp.NotUpdated {
+		return fmt.Errorf("failed to update message %s: %s", id, e)
+	}
+	for id, e := range resp.NotDestroyed {
+		return fmt.Errorf("failed to destroy message %s: %s", id, e)
+	}
+	return nil
+}
+
+type jmapSetError struct {
+	Type        string `json:"type"`
+	Description string `json:"description"`
+}
+
+func (e jmapSetError) String() string {
+	return strings.TrimSpace(e.Type + " " + e.Description)
+}
+
+// FetchMessage downloads one message with all its headers, decoded bodies
+// and attachment list. It returns ErrMessageNotFound for a UID the client
+// did not hand out or a message that no longer exists.
+func (j *JMAPClient) FetchMessage(ctx context.Context, mailbox string, uid uint32) (*Message, error) {
+	id, err := j.emailID(mailbox, uid)
+	if err != nil {
+		return nil, err
+	}
+	fetched, err := j.get(ctx, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	if len(fetched) == 0 {
+		return nil, ErrMessageNotFound
+	}
+	return j.message(ctx, mailbox, fetched[0], true)
+}
+
+// SearchMessageID returns the UIDs of the messages in mailbox with the
+// given Message-ID
+func (j *JMAPClient) SearchMessageID(ctx context.Context, mailbox, messageID string) ([]uint32, error) {
+	return j.search(ctx, mailbox, map[string]interface{}{"header": []string{"Message-ID", strings.Trim(messageID, "<> ")}})
+}
+
+// Search returns the UIDs of the messages in mailbox matching filter
+func (j *JMAPClient) Search(ctx context.Context, mailbox string, filter Filter) ([]uint32, error) {
+	conditions := make(map[string]interface{})
+	if !filter.Before.IsZero() {
+		conditions["before"] = filter.Before.UTC().Format(time.RFC3339)
+	}
+	if filter.Label != "" {
+		conditions["hasKeyword"] = filter.Label
+	}
+	return j.search(ctx, mailbox, conditions)
+}
+
+// search returns the UIDs of the messages in mailbox matching conditions
+func (j *JMAPClient) search(ctx context.Context, mailbox string, conditions map[string]interface{}) ([]uint32, error) {
+	mailboxID, err := j.mailboxID(ctx, mailbox)
+	if err != nil {
+		return nil, err
+	}
+	conditions["inMailbox"] = mailboxID
+	var ids []string
+	for {
+		args := mailboxQuery(mailboxID)
+		args["filter"] = conditions
+		args["position"] = len(ids)
+		var resp struct {
+			IDs []string `json:"ids"`
+		}
+		if err := j.call(ctx, "Email/query", args, &resp); err != nil {
+			return nil, err
+		}
+		if len(resp.IDs) == 0 {
+			break
+		}
+		ids = append(ids, resp.IDs...)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	t := j.table(mailbox)
+	uids := make([]uint32, len(ids))
+	for i, id := range ids {
+		uids[i] = t.assign(id)
+	}
+	return uids, nil
+}
+
+// Close does nothing; there is no connection to close
+func (j *JMAPClient) Close() error {
+	return nil
+}
@@ -0,0 +1,365 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeJMAP serves the parts of JMAP mail the client uses
+type fakeJMAP struct {
+	mu      sync.Mutex
+	emails  []*fakeJMAPEmail
+	version int // Bumped by every added message
+	oldest  int // Oldest query state changes can be calculated from
+}
+
+type fakeJMAPEmail struct {
+	id       string
+	mailbox  string
+	received time.Time
+	keywords map[string]bool
+	added    int // Version that added the message
+}
+
+// add delivers a message to a mailbox
+func (f *fakeJMAP) add(mailbox, subject string, received time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.version++
+	f.emails = append(f.emails, &fakeJMAPEmail{
+		id: subject, mailbox: mailbox, received: received, keywords: map[string]bool{"$seen": true}, added: f.version,
+	})
+}
+
+func (f *fakeJMAP) keywords(id string) map[string]bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, e := range f.emails {
+		if e.id == id {
+			return e.keywords
+		}
+	}
+	return nil
+}
+
+// query lists the messages matching a filter, oldest first
+func (f *fakeJMAP) query(filter map[string]interface{}) []*fakeJMAPEmail {
+	var list []*fakeJMAPEmail
+	for _, e := range f.emails {
+		if e.mailbox != filter["inMailbox"] {
+			continue
+		}
+		if after, ok := filter["after"].(string); ok {
+			if t, _ := time.Parse(time.RFC3339, after); !e.received.After(t) {
+				continue
+			}
+		}
+		if before, ok := filter["before"].(string); ok {
+			if t, _ := time.Parse(time.RFC3339, before); !e.received.Before(t) {
+				continue
+			}
+		}
+		if kw, ok := filter["hasKeyword"].(string); ok && !e.keywords[kw] {
+			continue
+		}
+		if h, ok := filter["header"].([]interface{}); ok && h[1] != e.id+"@example.com" {
+			continue
+		}
+		list = append(list, e)
+	}
+	return list
+}
+
+func (f *fakeJMAP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer fm-token" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/session":
+		fmt.Fprint(w, `{"apiUrl": "/api", "downloadUrl": "/download/{accountId}/{blobId}/{name}?type={type}",
+			"primaryAccounts": {"urn:ietf:params:jmap:mail": "u1"}}`)
+		return
+	case strings.HasPrefix(r.URL.Path, "/download/u1/"):
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/download/u1/"), "/message.eml")
+		fmt.Fprintf(w, "Subject: %s\r\nMessage-ID: <%s@example.com>\r\n\r\nbody of %s", id, id, id)
+		return
+	}
+
+	var req struct {
+		MethodCalls [][3]json.RawMessage `json:"methodCalls"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	var method string
+	var args map[string]interface{}
+	json.Unmarshal(req.MethodCalls[0][0], &method)
+	json.Unmarshal(req.MethodCalls[0][1], &args)
+
+	var result interface{}
+	switch method {
+	case "Mailbox/get":
+		list := []map[string]interface{}{
+			{"id": "mb-inbox", "name": "Inbox", "role": "inbox", "totalEmails": len(f.query(map[string]interface{}{"inMailbox": "mb-inbox"}))},
+			{"id": "mb-lists", "name": "Lists"},
+			{"id": "mb-go", "name": "go", "parentId": "mb-lists"},
+		}
+		if ids, ok := args["ids"].([]interface{}); ok {
+			list = list[:1]
+			if ids[0] != "mb-inbox" {
+				list = nil
+			}
+		}
+		result = map[string]interface{}{"list": list}
+	case "Email/query":
+		list := f.query(args["filter"].(map[string]interface{}))
+		position, _ := args["position"].(float64)
+		ids := []string{}
+		for i := int(position); i < len(list) && i < int(position)+2; i++ { // Pages of 2
+			ids = append(ids, list[i].id)
+		}
+		if limit, ok := args["limit"].(float64); ok && int(limit) < len(ids) {
+			ids = ids[:int(limit)]
+		}
+		result = map[string]interface{}{"ids": ids, "queryState": strconv.Itoa(f.version)}
+	case "Email/queryChanges":
+		since, _ := strconv.Atoi(args["sinceQueryState"].(string))
+		if since < f.oldest {
+			result = map[string]string{"type": "cannotCalculateChanges"}
+			method = "error"
+			break
+		}
+		var added []map[string]interface{}
+		for i, e := range f.query(args["filter"].(map[string]interface{})) {
+			if e.added > since {
+				added = append(added, map[string]interface{}{"id": e.id, "index": i})
+			}
+		}
+		result = map[string]interface{}{"added": added, "newQueryState": strconv.Itoa(f.version)}
+	case "Email/get":
+		var list []map[string]interface{}
+		for _, id := range args["ids"].([]interface{}) {
+			for _, e := range f.emails {
+				if e.id == id {
+					list = append(list, map[string]interface{}{
+						"id": e.id, "blobId": e.id, "threadId": "T" + e.id, "messageId": []string{e.id + "@example.com"}, "subject": e.id,
+						"from":       []map[string]string{{"name": "Alice", "email": "alice@example.com"}},
+						"receivedAt": e.received.Format(time.RFC3339), "keywords": e.keywords,
+						"attachments": []map[string]interface{}{{"name": e.id + ".pdf", "type": "application/PDF", "size": 100}},
+					})
+				}
+			}
+		}
+		result = map[string]interface{}{"list": list}
+	case "Email/set":
+		for id, patch := range args["update"].(map[string]interface{}) {
+			for _, e := range f.emails {
+				if e.id != id {
+					continue
+				}
+				for path, v := range patch.(map[string]interface{}) {
+					kw := strings.NewReplacer("~1", "/", "~0", "~").Replace(strings.TrimPrefix(path, "keywords/"))
+					if v == nil {
+						delete(e.keywords, kw)
+					} else {
+						e.keywords[kw] = true
+					}
+				}
+			}
+		}
+		result = map[string]interface{}{}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"methodResponses": []interface{}{[]interface{}{method, result, "0"}}})
+}
+
+func newTestJMAP(t *testing.T, f *fakeJMAP, opts ...JMAPOption) *JMAPClient {
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return NewJMAPClient(srv.URL+"/session", "fm-token", opts...)
+}
+
+func TestJMAPFetchNewEmails(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	f := &fakeJMAP{}
+	f.add("mb-inbox", "one", start)
+	f.add("mb-inbox", "two", start.Add(time.Minute))
+	f.add("mb-inbox", "three", start.Add(2*time.Minute))
+	f.add("mb-go", "list", start)
+	j := newTestJMAP(t, f, WithJMAPBodies())
+
+	if err := j.Authenticate(ctx); err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	if names, err := j.ListMailboxes(ctx); err != nil || !reflect.DeepEqual(names, []string{"INBOX", "Lists", "Lists/go"}) {
+		t.Errorf("ListMailboxes() = %v, %v; want [INBOX Lists Lists/go]", names, err)
+	}
+	status, err := j.Status(ctx, Inbox)
+	if err != nil || status.Messages != 3 {
+		t.Fatalf("Status() = %+v, %v; want 3 messages", status, err)
+	}
+
+	emails, cursor, err := j.FetchNewEmails(ctx, Inbox, Cursor{UIDValidity: status.UIDValidity})
+	if err != nil {
+		t.Fatalf("FetchNewEmails() error = %v", err)
+	}
+	if got := subjects(emails); !reflect.DeepEqual(got, []string{"one", "two", "three"}) {
+		t.Errorf("first fetch = %v; want [one two three]", got)
+	}
+	if e := emails[0]; e.ThreadID != "Tone" || e.TextBody != "body of one" || e.MessageID != "<one@example.com>" || e.From != "Alice <alice@example.com>" {
+		t.Errorf("first message = %+v; want its body, Message-ID and sender", e)
+	}
+	if want := uint32(start.Add(2 * time.Minute).Unix()); cursor.LastUID != want {
+		t.Errorf("cursor = %+v; want LastUID %d", cursor, want)
+	}
+
+	// New mail is found through the query changes
+	f.add("mb-inbox", "four", start.Add(3*time.Minute))
+	emails, cursor, err = j.FetchNewEmails(ctx, Inbox, cursor)
+	if err != nil || !reflect.DeepEqual(subjects(emails), []string{"four"}) {
+		t.Fatalf("incremental fetch = %v, %v; want [four]", subjects(emails), err)
+	}
+
+	// A restarted client resumes by time, including mail received in the
+	// cursor's second
+	f.add("mb-inbox", "five", start.Add(3*time.Minute))
+	restarted := newTestJMAP(t, f)
+	emails, _, err = restarted.FetchNewEmails(ctx, Inbox, cursor)
+	if err != nil || !reflect.DeepEqual(subjects(emails), []string{"four", "five"}) {
+		t.Errorf("fetch after restart = %v, %v; want [four five]", subjects(emails), err)
+	}
+	if want := []Attachment{{Filename: "four.pdf", ContentType: "application/pdf", Size: 100}}; len(emails) > 0 && !reflect.DeepEqual(emails[0].Attachments, want) {
+		t.Errorf("Attachments = %+v; want %+v", emails[0].Attachments, want)
+	}
+
+	// So does a client whose query state expired
+	f.add("mb-inbox", "six", start.Add(4*time.Minute))
+	f.oldest = f.version + 1
+	emails, _, err = j.FetchNewEmails(ctx, Inbox, cursor)
+	if err != nil || !reflect.DeepEqual(subjects(emails), []string{"five", "six"}) {
+		t.Errorf("fetch with expired state = %v, %v; want [five six]", subjects(emails), err)
+	}
+
+	if _, reset, err := j.FetchNewEmails(ctx, Inbox, Cursor{UIDValidity: 2, LastUID: 5}); !errors.Is(err, ErrUIDValidityChanged) || reset.UIDValidity != status.UIDValidity {
+		t.Errorf("fetch with another UIDVALIDITY = %+v, %v; want ErrUIDValidityChanged", reset, err)
+	}
+}
+
+func TestJMAPMessageLimit(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	f := &fakeJMAP{}
+	for i, s := range []string{"one", "two", "three", "four", "five"} {
+		f.add("mb-inbox", s, start.Add(time.Duration(i/2)*time.Minute)) // Two per minute
+	}
+	j := newTestJMAP(t, f, WithJMAPMessageLimit(2))
+
+	var got []string
+	cursor := Cursor{}
+	for i := 0; i < 4; i++ {
+		emails, next, err := j.FetchNewEmails(ctx, Inbox, cursor)
+		if err != nil {
+			t.Fatalf("FetchNewEmails() error = %v", err)
+		}
+		if len(emails) > 2 {
+			t.Fatalf("fetch returned %d messages; want at most 2", len(emails))
+		}
+		got = append(got, subjects(emails)...)
+		cursor = next
+	}
+	if want := []string{"one", "two", "three", "four", "five"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("limited fetches = %v; want %v", got, want)
+	}
+}
+
+func TestJMAPForgetsPastMessages(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	f := &fakeJMAP{}
+	j := newTestJMAP(t, f)
+
+	cursor := Cursor{}
+	for i := 0; i < 5; i++ {
+		f.add("mb-inbox", fmt.Sprintf("m%d", i), start.Add(time.Duration(i)*time.Minute))
+		emails, next, err := j.FetchNewEmails(ctx, Inbox, cursor)
+		if err != nil || len(emails) != 1 {
+			t.Fatalf("fetch %d = %d messages, %v; want 1", i, len(emails), err)
+		}
+		if got := emails[0].ProviderID; got != fmt.Sprintf("m%d", i) {
+			t.Errorf("ProviderID = %q; want the Email ID m%d", got, i)
+		}
+		cursor = next
+	}
+
+	// Messages received before the last fetch's cursor are forgotten
+	j.mu.Lock()
+	tbl := j.table(Inbox)
+	returned, uids := len(tbl.returned), len(tbl.uids)
+	j.mu.Unlock()
+	if returned != 2 || uids != 2 {
+		t.Errorf("table holds %d returned messages and %d UIDs; want 2 and 2", returned, uids)
+	}
+}
+
+func TestJMAPKeywords(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	f := &fakeJMAP{}
+	f.add("mb-inbox", "one", start)
+	f.add("mb-inbox", "two", start.Add(48*time.Hour))
+	j := newTestJMAP(t, f)
+
+	if _, _, err := j.FetchNewEmails(ctx, Inbox, Cursor{}); err != nil {
+		t.Fatalf("FetchNewEmails() error = %v", err)
+	}
+	uids, err := j.SearchMessageID(ctx, Inbox, "<one@example.com>")
+	if err != nil || len(uids) != 1 {
+		t.Fatalf("SearchMessageID() = %v, %v; want one message", uids, err)
+	}
+	if err := j.ApplyLabel(ctx, Inbox, uids[0], "go-tsk/done"); err != nil {
+		t.Fatalf("ApplyLabel() error = %v", err)
+	}
+	if !f.keywords("one")["go-tsk/done"] {
+		t.Errorf("keywords after ApplyLabel() = %v; want go-tsk/done", f.keywords("one"))
+	}
+
+	found, err := j.Search(ctx, Inbox, Filter{Label: "go-tsk/done", Before: start.Add(24 * time.Hour)})
+	if err != nil || !reflect.DeepEqual(found, uids) {
+		t.Errorf("Search() = %v, %v; want %v", found, err, uids)
+	}
+	if err := j.RemoveLabel(ctx, Inbox, uids, "go-tsk/done"); err != nil {
+		t.Fatalf("RemoveLabel() error = %v", err)
+	}
+	if f.keywords("one")["go-tsk/done"] {
+		t.Error("keyword still set after RemoveLabel()")
+	}
+
+	m, err := j.FetchMessage(ctx, Inbox, uids[0])
+	if err != nil || m.TextBody != "body of one" || m.Header.Get("Subject") != "one" {
+		t.Errorf("FetchMessage() = %+v, %v; want the decoded message", m, err)
+	}
+	if _, err := j.FetchMessage(ctx, Inbox, 999); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("FetchMessage() of an unknown UID error = %v; want ErrMessageNotFound", err)
+	}
+}
+
+func TestJMAPAuthenticationRejected(t *testing.T) {
+	srv := httptest.NewServer(&fakeJMAP{})
+	defer srv.Close()
+	j := NewJMAPClient(srv.URL+"/session", "revoked")
+	if err := j.Authenticate(context.Background()); err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Errorf("Authenticate() with a revoked token error = %v; want authentication failed", err)
+	}
+}
@@ -0,0 +1,29 @@
+package email
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+// Link returns a URL opening a message of an account: Gmail's web search
+// for its Message-ID for Gmail accounts, otherwise an RFC 2392 mid: URL,
+// which mail clients such as Thunderbird and Apple Mail open. It is empty
+// for messages without a Message-ID.
+func Link(account config.EmailAccount, messageID string) string {
+	id := strings.Trim(strings.TrimSpace(messageID), "<>")
+	if id == "" {
+		return ""
+	}
+	switch account.Provider {
+	case "gmail", "gmailapi", "":
+		user := account.Address
+		if user == "" {
+			user = "0"
+		}
+		return "https://mail.google.com/mail/u/" + url.PathEscape(user) + "/#search/" +
+			url.QueryEscape("rfc822msgid:"+id)
+	}
+	return "mid:" + url.PathEscape(id)
+}
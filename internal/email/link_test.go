@@ -0,0 +1,28 @@
+package email
+
+import (
+	"testing"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+func TestLink(t *testing.T) {
+	tests := []struct {
+		name      string
+		account   config.EmailAccount
+		messageID string
+		want      string
+	}{
+		{"gmail", config.EmailAccount{Provider: "gmail", Address: "me@gmail.com"}, "<abc+1@mail.example.com>",
+			"https://mail.google.com/mail/u/me@gmail.com/#search/rfc822msgid%3Aabc%2B1%40mail.example.com"},
+		{"gmail api without address", config.EmailAccount{Provider: "gmailapi"}, "<abc@example.com>",
+			"https://mail.google.com/mail/u/0/#search/rfc822msgid%3Aabc%40example.com"},
+		{"other provider", config.EmailAccount{Provider: "jmap"}, "<abc/1@example.com>", "mid:abc%2F1@example.com"},
+		{"no message id", config.EmailAccount{Provider: "gmail"}, "", ""},
+	}
+	for _, tt := range tests {
+		if got := Link(tt.account, tt.messageID); got != tt.want {
+			t.Errorf("%s: Link() = %q; want %q", tt.name, got, tt.want)
+		}
+	}
+}
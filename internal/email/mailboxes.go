@@ -0,0 +1,44 @@
+package email
+
+import (
+	"path"
+	"strings"
+)
+
+// HasPattern reports whether a mailbox setting is a glob pattern rather
+// than a literal mailbox name
+func HasPattern(name string) bool {
+	return strings.ContainsAny(name, `*?[\`)
+}
+
+// ResolveMailboxes expands mailbox settings into mailbox names. Literal
+// names are kept as given; patterns are matched with path.Match against
+// the names the server listed, so "Lists/*" matches one level below Lists.
+// The result keeps the order of the settings and has no duplicates.
+func ResolveMailboxes(settings, listed []string) []string {
+	if len(settings) == 0 {
+		return []string{Inbox}
+	}
+
+	seen := make(map[string]bool)
+	var names []string
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	for _, setting := range settings {
+		if !HasPattern(setting) {
+			add(setting)
+			continue
+		}
+		for _, name := range listed {
+			if ok, _ := path.Match(setting, name); ok {
+				add(name)
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,32 @@
+package email
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveMailboxes(t *testing.T) {
+	listed := []string{"INBOX", "Receipts", "Lists/golang", "Lists/rust", "Lists/archive/2020", "[Gmail]/Spam"}
+
+	tests := []struct {
+		name     string
+		settings []string
+		expected []string
+	}{
+		{"default", nil, []string{"INBOX"}},
+		{"literal names", []string{"INBOX", "Receipts"}, []string{"INBOX", "Receipts"}},
+		{"literal not listed", []string{"Missing"}, []string{"Missing"}},
+		{"one level glob", []string{"Lists/*"}, []string{"Lists/golang", "Lists/rust"}},
+		{"no duplicates", []string{"Lists/golang", "Lists/*"}, []string{"Lists/golang", "Lists/rust"}},
+		{"escaped bracket", []string{`\[Gmail\]/*`}, []string{"[Gmail]/Spam"}},
+		{"no match", []string{"Archive/*"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ResolveMailboxes(tt.settings, listed); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("ResolveMailboxes(%v) = %v; want %v", tt.settings, got, tt.expected)
+			}
+		})
+	}
+}
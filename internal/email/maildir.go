@@ -0,0 +1,505 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maildirValidity is the UIDVALIDITY of every Maildir folder
+const maildirValidity = 1
+
+// maildirFlags maps the IMAP system flags to Maildir info letters
+var maildirFlags = map[string]byte{
+	`\Draft`:    'D',
+	`\Flagged`:  'F',
+	`\Answered`: 'R',
+	`\Seen`:     'S',
+	`\Deleted`:  'T',
+}
+
+// LocalOption customizes a MaildirClient or MboxClient
+type LocalOption func(*localOptions)
+
+type localOptions struct {
+	fetchBodies bool
+	maxMessages int
+}
+
+// WithLocalBodies makes the client decode full message bodies into
+// Email.TextBody and Email.HTMLBody
+func WithLocalBodies() LocalOption {
+	return func(o *localOptions) {
+		o.fetchBodies = true
+	}
+}
+
+// WithLocalMessageLimit makes FetchNewEmails return at most n messages,
+// oldest first, leaving the rest for the next fetch
+func WithLocalMessageLimit(n int) LocalOption {
+	return func(o *localOptions) {
+		o.maxMessages = n
+	}
+}
+
+// MaildirClient reads a local Maildir++ tree, such as one kept in sync by
+// offlineimap or mbsync or delivered to by an MTA. INBOX is the top-level
+// Maildir and other mailboxes are its dot folders, Lists/go being
+// .Lists.go. Labels named after IMAP system flags, like \Seen, set Maildir
+// flags; other labels move the message into the folder of that name.
+//
+// The UID of a message is a hash of the unique part of its file name,
+// which survives flag changes and moves. Cursors hold the modification
+// time, in Unix seconds, of the newest message seen; within a process the
+// UIDs already returned are skipped instead.
+type MaildirClient struct {
+	root string
+	localOptions
+
+	mu   sync.Mutex
+	seen map[string]map[uint32]bool // UIDs returned per mailbox
+}
+
+// NewMaildirClient creates a client for the Maildir at root
+func NewMaildirClient(root string, opts ...LocalOption) *MaildirClient {
+	c := &MaildirClient{root: root, seen: make(map[string]map[uint32]bool)}
+	for _, opt := range opts {
+		opt(&c.localOptions)
+	}
+	return c
+}
+
+// maildirEntry is a message file
+type maildirEntry struct {
+	dir    string // Folder directory
+	sub    string // "new" or "cur"
+	name   string // File name
+	unique string // File name without the info suffix
+	flags  string // Info flag letters
+	mtime  time.Time
+	uid    uint32
+}
+
+func (e maildirEntry) path() string {
+	return filepath.Join(e.dir, e.sub, e.name)
+}
+
+// folder returns the directory of a mailbox
+func (c *MaildirClient) folder(mailbox string) (string, error) {
+	if mailbox == Inbox {
+		return c.root, nil
+	}
+	// Maildir++ separates folder levels with dots, so names cannot have any
+	if mailbox == "" || strings.ContainsAny(mailbox, `.\`) || strings.HasPrefix(mailbox, "/") {
+		return "", fmt.Errorf("invalid Maildir mailbox %q", mailbox)
+	}
+	return filepath.Join(c.root, "."+strings.ReplaceAll(mailbox, "/", ".")), nil
+}
+
+// scan lists the messages of a mailbox, oldest first
+func (c *MaildirClient) scan(mailbox string) ([]maildirEntry, error) {
+	dir, err := c.folder(mailbox)
+	if err != nil {
+		return nil, err
+	}
+	var entries []maildirEntry
+	for _, sub := range []string{"new", "cur"} {
+		files, err := os.ReadDir(filepath.Join(dir, sub))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mailbox %s: %w", mailbox, err)
+		}
+		for _, f := range files {
+			if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+				continue
+			}
+			info, err := f.Info()
+			if err != nil {
+				// Moved or deleted by another program since the listing
+				continue
+			}
+			e := maildirEntry{dir: dir, sub: sub, name: f.Name(), mtime: info.ModTime()}
+			var flags string
+			e.unique, flags, _ = strings.Cut(f.Name(), ":")
+			if strings.HasPrefix(flags, "2,") {
+				e.flags = flags[2:]
+			}
+			e.uid = hashUID(e.unique)
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].mtime.Equal(entries[j].mtime) {
+			return entries[i].mtime.Before(entries[j].mtime)
+		}
+		return entries[i].unique < entries[j].unique
+	})
+	return entries, nil
+}
+
+// find returns the message of a mailbox with the given UID
+func (c *MaildirClient) find(mailbox string, uid uint32) (maildirEntry, error) {
+	entries, err := c.scan(mailbox)
+	if err != nil {
+		return maildirEntry{}, err
+	}
+	for _, e := range entries {
+		if e.uid == uid {
+			return e, nil
+		}
+	}
+	return maildirEntry{}, ErrMessageNotFound
+}
+
+// read decodes a message file, only its header unless full is set
+func (c *MaildirClient) read(mailbox string, e maildirEntry, full bool) (*Message, error) {
+	f, err := os.Open(e.path())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, err
+	}
+	defer f.Close()
+	msg := readMessage(f, full, e.path())
+	msg.Mailbox = mailbox
+	msg.UID = e.uid
+	if msg.Date.IsZero() {
+		msg.Date = e.mtime
+	}
+	for label, letter := range maildirFlags {
+		if strings.IndexByte(e.flags, letter) >= 0 {
+			msg.Flags = append(msg.Flags, label)
+		}
+	}
+	sort.Strings(msg.Flags)
+	return msg, nil
+}
+
+// Connect checks that the root is a Maildir
+func (c *MaildirClient) Connect(ctx context.Context) error {
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		if info, err := os.Stat(filepath.Join(c.root, sub)); err != nil || !info.IsDir() {
+			return fmt.Errorf("%s is not a Maildir: missing %s directory", c.root, sub)
+		}
+	}
+	return nil
+}
+
+// Authenticate does nothing; file permissions guard a Maildir
+func (c *MaildirClient) Authenticate(ctx context.Context) error {
+	return nil
+}
+
+// ListMailboxes returns INBOX and the folders of the Maildir
+func (c *MaildirClient) ListMailboxes(ctx context.Context) ([]string, error) {
+	files, err := os.ReadDir(c.root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list mailboxes: %w", err)
+	}
+	mailboxes := []string{Inbox}
+	for _, f := range files {
+		name := f.Name()
+		if !f.IsDir() || len(name) < 2 || name[0] != '.' || name == ".." {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(c.root, name, "cur")); err != nil {
+			continue
+		}
+		mailboxes = append(mailboxes, strings.ReplaceAll(name[1:], ".", "/"))
+	}
+	return mailboxes, nil
+}
+
+// FetchNewEmails returns the messages of mailbox delivered since cursor,
+// oldest first
+func (c *MaildirClient) FetchNewEmails(ctx context.Context, mailbox string, cursor Cursor) ([]*Email, Cursor, error) {
+	entries, err := c.scan(mailbox)
+	if err != nil {
+		return nil, cursor, err
+	}
+
+	c.mu.Lock()
+	seen := c.seen[mailbox]
+	c.mu.Unlock()
+	var fresh []maildirEntry
+	for _, e := range entries {
+		switch {
+		case seen != nil:
+			if !seen[e.uid] {
+				fresh = append(fresh, e)
+			}
+		case unixSeconds(e.mtime) > cursor.LastUID:
+			fresh = append(fresh, e)
+		}
+	}
+	if c.maxMessages > 0 && len(fresh) > c.maxMessages {
+		fresh = fresh[:c.maxMessages]
+	}
+	next := Cursor{UIDValidity: maildirValidity, LastUID: cursor.LastUID}
+	if len(fresh) > 0 {
+		if last := unixSeconds(fresh[len(fresh)-1].mtime); last > next.LastUID {
+			next.LastUID = last
+		}
+	}
+
+	emails := make([]*Email, 0, len(fresh))
+	for _, e := range fresh {
+		msg, err := c.read(mailbox, e, c.fetchBodies)
+		if err == ErrMessageNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, cursor, err
+		}
+		emails = append(emails, &msg.Email)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.seen[mailbox] == nil {
+		// What the cursor skipped counts as seen from now on
+		c.seen[mailbox] = make(map[uint32]bool)
+		for _, e := range entries {
+			if unixSeconds(e.mtime) <= cursor.LastUID {
+				c.seen[mailbox][e.uid] = true
+			}
+		}
+	}
+	for _, e := range fresh {
+		c.seen[mailbox][e.uid] = true
+	}
+	return emails, next, nil
+}
+
+// unixSeconds returns t as a cursor position
+func unixSeconds(t time.Time) uint32 {
+	if t.Unix() <= 0 {
+		return 0
+	}
+	return uint32(t.Unix())
+}
+
+// FetchBatch returns up to limit messages with UIDs above afterUID, in
+// UID order. As UIDs are hashes the order is arbitrary, but it is stable,
+// so a backfill resumes where it stopped.
+func (c *MaildirClient) FetchBatch(ctx context.Context, mailbox string, afterUID uint32, limit int) ([]*Email, error) {
+	entries, err := c.scan(mailbox)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].uid < entries[j].uid })
+	var emails []*Email
+	for _, e := range entries {
+		if e.uid <= afterUID {
+			continue
+		}
+		if len(emails) == limit {
+			break
+		}
+		msg, err := c.read(mailbox, e, c.fetchBodies)
+		if err == ErrMessageNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, &msg.Email)
+	}
+	return emails, nil
+}
+
+// Status returns the number of messages in mailbox; the head cursor points
+// at the newest of them
+func (c *MaildirClient) Status(ctx context.Context, mailbox string) (MailboxStatus, error) {
+	entries, err := c.scan(mailbox)
+	if err != nil {
+		return MailboxStatus{}, err
+	}
+	status := MailboxStatus{Messages: len(entries), UIDValidity: maildirValidity, UIDNext: 1}
+	if len(entries) > 0 {
+		status.UIDNext = unixSeconds(entries[len(entries)-1].mtime) + 1
+	}
+	return status, nil
+}
+
+// ApplyLabel sets the Maildir flag of a system flag label such as \Seen,
+// and moves the message to the folder named label otherwise
+func (c *MaildirClient) ApplyLabel(ctx context.Context, mailbox string, uid uint32, label string) error {
+	e, err := c.find(mailbox, uid)
+	if err != nil {
+		return err
+	}
+	if letter, ok := maildirFlags[label]; ok {
+		return setMaildirFlag(e, letter, true)
+	}
+	return c.move(e, label)
+}
+
+// setMaildirFlag sets or clears a flag letter, moving the message to cur
+// as flags are only kept there
+func setMaildirFlag(e maildirEntry, letter byte, set bool) error {
+	flags := []byte(strings.ReplaceAll(e.flags, string(letter), ""))
+	if set {
+		flags = append(flags, letter)
+	}
+	sort.Slice(flags, func(i, j int) bool { return flags[i] < flags[j] })
+	name := e.unique + ":2," + string(flags)
+	if e.sub == "cur" && name == e.name {
+		return nil
+	}
+	return os.Rename(e.path(), filepath.Join(e.dir, "cur", name))
+}
+
+// move moves a message to the folder of mailbox, creating the folder if
+// needed
+func (c *MaildirClient) move(e maildirEntry, mailbox string) error {
+	dir, err := c.folder(mailbox)
+	if err != nil {
+		return err
+	}
+	if dir == e.dir {
+		return nil
+	}
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return fmt.Errorf("failed to create mailbox %s: %w", mailbox, err)
+		}
+	}
+	return os.Rename(e.path(), filepath.Join(dir, e.sub, e.name))
+}
+
+// FetchMessage decodes one message with all its headers, bodies and
+// attachment list. It returns ErrMessageNotFound if the message is gone.
+func (c *MaildirClient) FetchMessage(ctx context.Context, mailbox string, uid uint32) (*Message, error) {
+	e, err := c.find(mailbox, uid)
+	if err != nil {
+		return nil, err
+	}
+	return c.read(mailbox, e, true)
+}
+
+// FetchRaw returns the file of one message
+func (c *MaildirClient) FetchRaw(ctx context.Context, mailbox string, uid uint32) ([]byte, error) {
+	e, err := c.find(mailbox, uid)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := os.ReadFile(e.path())
+	if os.IsNotExist(err) {
+		return nil, ErrMessageNotFound
+	}
+	return raw, err
+}
+
+// SearchMessageID returns the UIDs of the messages in mailbox with the
+// given Message-ID, in ascending order
+func (c *MaildirClient) SearchMessageID(ctx context.Context, mailbox, messageID string) ([]uint32, error) {
+	entries, err := c.scan(mailbox)
+	if err != nil {
+		return nil, err
+	}
+	want := strings.Trim(messageID, "<>")
+	var uids []uint32
+	for _, e := range entries {
+		msg, err := c.read(mailbox, e, false)
+		if err != nil {
+			continue
+		}
+		if strings.Trim(msg.MessageID, "<>") == want {
+			uids = append(uids, e.uid)
+		}
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids, nil
+}
+
+// Search returns the UIDs of the messages in mailbox matching filter, in
+// ascending order. Messages count as received when their file was last
+// modified. A message carries a system flag label when it has the flag
+// and any other label when it is in that label's folder.
+func (c *MaildirClient) Search(ctx context.Context, mailbox string, filter Filter) ([]uint32, error) {
+	entries, err := c.scan(mailbox)
+	if err != nil {
+		return nil, err
+	}
+	var uids []uint32
+	for _, e := range entries {
+		if !filter.Before.IsZero() && !e.mtime.Before(filter.Before) {
+			continue
+		}
+		if filter.Label != "" && !c.hasLabel(e, filter.Label) {
+			continue
+		}
+		uids = append(uids, e.uid)
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids, nil
+}
+
+// hasLabel reports whether a message carries a label
+func (c *MaildirClient) hasLabel(e maildirEntry, label string) bool {
+	if letter, ok := maildirFlags[label]; ok {
+		return strings.IndexByte(e.flags, letter) >= 0
+	}
+	dir, err := c.folder(label)
+	return err == nil && dir == e.dir
+}
+
+// RemoveLabel clears the Maildir flag of a system flag label, and moves
+// messages in the folder named label back to INBOX otherwise
+func (c *MaildirClient) RemoveLabel(ctx context.Context, mailbox string, uids []uint32, label string) error {
+	entries, err := c.scan(mailbox)
+	if err != nil {
+		return err
+	}
+	want := make(map[uint32]bool, len(uids))
+	for _, uid := range uids {
+		want[uid] = true
+	}
+	letter, isFlag := maildirFlags[label]
+	for _, e := range entries {
+		if !want[e.uid] || !c.hasLabel(e, label) {
+			continue
+		}
+		if isFlag {
+			err = setMaildirFlag(e, letter, false)
+		} else {
+			err = c.move(e, Inbox)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to remove label from %s: %w", e.name, err)
+		}
+	}
+	return nil
+}
+
+// DeleteMessages deletes the files of messages
+func (c *MaildirClient) DeleteMessages(ctx context.Context, mailbox string, uids []uint32) error {
+	entries, err := c.scan(mailbox)
+	if err != nil {
+		return err
+	}
+	want := make(map[uint32]bool, len(uids))
+	for _, uid := range uids {
+		want[uid] = true
+	}
+	for _, e := range entries {
+		if !want[e.uid] {
+			continue
+		}
+		if err := os.Remove(e.path()); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete %s: %w", e.name, err)
+		}
+	}
+	return nil
+}
+
+// Close does nothing
+func (c *MaildirClient) Close() error {
+	return nil
+}
@@ -0,0 +1,163 @@
+package email
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// deliver writes a message into a Maildir folder with the given file name
+// and modification time
+func deliver(t *testing.T, dir, sub, name, msg string, mtime time.Time) {
+	t.Helper()
+	for _, s := range []string{"cur", "new", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, s), 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(dir, sub, name)
+	if err := os.WriteFile(path, []byte(msg), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMaildirFetchNewEmails(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	base := time.Unix(1700000000, 0)
+	deliver(t, root, "cur", "1.a.host:2,S", rfc822Message("m1", "First"), base)
+	deliver(t, root, "new", "2.b.host", rfc822Message("m2", "Second"), base.Add(time.Minute))
+	c := NewMaildirClient(root, WithLocalBodies())
+
+	if err := c.Connect(ctx); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	status, err := c.Status(ctx, Inbox)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if status.Messages != 2 || status.Head().LastUID != uint32(base.Unix()+60) {
+		t.Fatalf("status = %+v", status)
+	}
+
+	emails, cursor, err := c.FetchNewEmails(ctx, Inbox, Cursor{UIDValidity: maildirValidity})
+	if err != nil {
+		t.Fatalf("FetchNewEmails: %v", err)
+	}
+	if got := subjects(emails); !reflect.DeepEqual(got, []string{"First", "Second"}) {
+		t.Fatalf("subjects = %v", got)
+	}
+	first := emails[0]
+	if first.UID != hashUID("1.a.host") || !contains(first.Flags, `\Seen`) || !strings.Contains(first.TextBody, "Body of First") {
+		t.Errorf("first email = %+v", first)
+	}
+	if cursor.LastUID != uint32(base.Unix()+60) {
+		t.Errorf("cursor = %+v", cursor)
+	}
+
+	// A message delivered with an old modification time is still new to
+	// the running process
+	deliver(t, root, "new", "3.c.host", rfc822Message("m3", "Third"), base)
+	emails, _, err = c.FetchNewEmails(ctx, Inbox, cursor)
+	if err != nil {
+		t.Fatalf("FetchNewEmails: %v", err)
+	}
+	if got := subjects(emails); !reflect.DeepEqual(got, []string{"Third"}) {
+		t.Fatalf("subjects of second fetch = %v", got)
+	}
+
+	// After a restart the cursor skips what was seen
+	restarted := NewMaildirClient(root)
+	deliver(t, root, "new", "4.d.host", rfc822Message("m4", "Fourth"), base.Add(time.Hour))
+	emails, _, err = restarted.FetchNewEmails(ctx, Inbox, cursor)
+	if err != nil {
+		t.Fatalf("FetchNewEmails after restart: %v", err)
+	}
+	if got := subjects(emails); !reflect.DeepEqual(got, []string{"Fourth"}) {
+		t.Fatalf("subjects after restart = %v", got)
+	}
+}
+
+func TestMaildirLabels(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	deliver(t, root, "new", "1.a.host", rfc822Message("m1", "First"), time.Now())
+	c := NewMaildirClient(root)
+	uid := hashUID("1.a.host")
+
+	if err := c.ApplyLabel(ctx, Inbox, uid, `\Flagged`); err != nil {
+		t.Fatalf("ApplyLabel flag: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "cur", "1.a.host:2,F")); err != nil {
+		t.Fatalf("flagged message not in cur: %v", err)
+	}
+	uids, err := c.Search(ctx, Inbox, Filter{Label: `\Flagged`})
+	if err != nil || !reflect.DeepEqual(uids, []uint32{uid}) {
+		t.Fatalf("Search flagged = %v, %v", uids, err)
+	}
+
+	if err := c.ApplyLabel(ctx, Inbox, uid, "Lists/go"); err != nil {
+		t.Fatalf("ApplyLabel folder: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, ".Lists.go", "cur", "1.a.host:2,F")); err != nil {
+		t.Fatalf("message not moved to folder: %v", err)
+	}
+	mailboxes, err := c.ListMailboxes(ctx)
+	if err != nil || !reflect.DeepEqual(mailboxes, []string{Inbox, "Lists/go"}) {
+		t.Fatalf("ListMailboxes = %v, %v", mailboxes, err)
+	}
+	ids, err := c.SearchMessageID(ctx, "Lists/go", "<m1@example.com>")
+	if err != nil || !reflect.DeepEqual(ids, []uint32{uid}) {
+		t.Fatalf("SearchMessageID = %v, %v", ids, err)
+	}
+
+	if err := c.RemoveLabel(ctx, "Lists/go", []uint32{uid}, "Lists/go"); err != nil {
+		t.Fatalf("RemoveLabel folder: %v", err)
+	}
+	if err := c.RemoveLabel(ctx, Inbox, []uint32{uid}, `\Flagged`); err != nil {
+		t.Fatalf("RemoveLabel flag: %v", err)
+	}
+	raw, err := c.FetchRaw(ctx, Inbox, uid)
+	if err != nil || string(raw) != rfc822Message("m1", "First") {
+		t.Fatalf("FetchRaw after removing labels = %q, %v", raw, err)
+	}
+
+	if err := c.DeleteMessages(ctx, Inbox, []uint32{uid}); err != nil {
+		t.Fatalf("DeleteMessages: %v", err)
+	}
+	if _, err := c.FetchMessage(ctx, Inbox, uid); err != ErrMessageNotFound {
+		t.Errorf("FetchMessage after delete = %v, want ErrMessageNotFound", err)
+	}
+	if err := c.ApplyLabel(ctx, Inbox, uid, "a.b"); err != ErrMessageNotFound {
+		t.Errorf("ApplyLabel of a deleted message = %v", err)
+	}
+}
+
+func TestMaildirFolder(t *testing.T) {
+	c := NewMaildirClient("/mail")
+	tests := []struct {
+		mailbox string
+		want    string
+		wantErr bool
+	}{
+		{"INBOX", "/mail", false},
+		{"Lists/go", "/mail/.Lists.go", false},
+		{"Archive", "/mail/.Archive", false},
+		{"../etc", "", true},
+		{"a.b", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		got, err := c.folder(tt.mailbox)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("folder(%q) = %q, %v", tt.mailbox, got, err)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// mboxValidity is the UIDVALIDITY of every mbox file
+const mboxValidity = 1
+
+// MboxClient reads a local mbox file, such as a spool file an MTA delivers
+// to. Other programs rewrite the file, so it is read-only: mbox only has
+// the inbox and messages cannot be labeled or deleted.
+//
+// The UID of a message is a hash of its Message-ID, or of its From_ line
+// and Date header when it has none.
+type MboxClient struct {
+	path string
+	localOptions
+	tracker positionTracker
+}
+
+// NewMboxClient creates a client for the mbox file at path
+func NewMboxClient(path string, opts ...LocalOption) *MboxClient {
+	c := &MboxClient{path: path}
+	for _, opt := range opts {
+		opt(&c.localOptions)
+	}
+	return c
+}
+
+// mboxMessage is a message in the file
+type mboxMessage struct {
+	uid        uint32
+	start, end int64 // Byte range after the From_ line
+}
+
+// scan lists the messages in the file, oldest first
+func (c *MboxClient) scan() ([]mboxMessage, error) {
+	f, err := os.Open(c.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open mbox: %w", err)
+	}
+	defer f.Close()
+
+	var (
+		list       []mboxMessage
+		cur        *mboxMessage
+		fromLine   string
+		messageID  string
+		date       string
+		inHeader   bool
+		blank      = true
+		offset     int64
+		r          = bufio.NewReader(f)
+		finishLast = func(end int64) {
+			if cur == nil {
+				return
+			}
+			cur.end = end
+			if messageID != "" {
+				cur.uid = hashUID(messageID)
+			} else {
+				cur.uid = hashUID(fromLine + "\n" + date)
+			}
+			list = append(list, *cur)
+		}
+	)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			start := offset
+			offset += int64(len(line))
+			switch {
+			case blank && bytes.HasPrefix(line, []byte("From ")):
+				finishLast(start)
+				cur = &mboxMessage{start: offset}
+				fromLine = strings.TrimSpace(string(line))
+				messageID, date, inHeader = "", "", true
+			case inHeader && len(bytes.TrimSpace(line)) == 0:
+				inHeader = false
+			case inHeader:
+				name, value, _ := strings.Cut(string(line), ":")
+				switch strings.ToLower(name) {
+				case "message-id":
+					messageID = strings.TrimSpace(value)
+				case "date":
+					date = strings.TrimSpace(value)
+				}
+			}
+			blank = len(bytes.TrimSpace(line)) == 0
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mbox: %w", err)
+		}
+	}
+	finishLast(offset)
+	return list, nil
+}
+
+// raw reads a message in RFC 5322 form: unquoted and with CRLF line ends
+func (c *MboxClient) raw(m mboxMessage) ([]byte, error) {
+	f, err := os.Open(c.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open mbox: %w", err)
+	}
+	defer f.Close()
+	data := make([]byte, m.end-m.start)
+	if _, err := f.ReadAt(data, m.start); err != nil {
+		return nil, fmt.Errorf("failed to read mbox: %w", err)
+	}
+
+	var buf bytes.Buffer
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	if n := len(lines); len(lines[n-1]) == 0 {
+		lines = lines[:n-1]
+	}
+	// The blank line before the next From_ line separates messages
+	if n := len(lines); n > 0 && len(bytes.TrimSpace(lines[n-1])) == 0 {
+		lines = lines[:n-1]
+	}
+	for _, line := range lines {
+		line = bytes.TrimRight(line, "\r\n")
+		if quoted := bytes.TrimLeft(line, ">"); len(quoted) < len(line) && bytes.HasPrefix(quoted, []byte("From ")) {
+			line = line[1:]
+		}
+		buf.Write(line)
+		buf.WriteString("\r\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// fetch decodes a message, only its header unless full is set
+func (c *MboxClient) fetch(m mboxMessage, full bool) (*Message, error) {
+	raw, err := c.raw(m)
+	if err != nil {
+		return nil, err
+	}
+	msg := readMessage(bytes.NewReader(raw), full, c.path)
+	msg.Mailbox = Inbox
+	msg.UID = m.uid
+	return msg, nil
+}
+
+// find returns the message with the given UID
+func (c *MboxClient) find(uid uint32) (mboxMessage, error) {
+	list, err := c.scan()
+	if err != nil {
+		return mboxMessage{}, err
+	}
+	for _, m := range list {
+		if m.uid == uid {
+			return m, nil
+		}
+	}
+	return mboxMessage{}, ErrMessageNotFound
+}
+
+// Connect checks that the file can be read
+func (c *MboxClient) Connect(ctx context.Context) error {
+	f, err := os.Open(c.path)
+	if err != nil {
+		return fmt.Errorf("failed to open mbox: %w", err)
+	}
+	return f.Close()
+}
+
+// Authenticate does nothing; file permissions guard an mbox
+func (c *MboxClient) Authenticate(ctx context.Context) error {
+	return nil
+}
+
+// ListMailboxes returns INBOX, the only mailbox of an mbox file
+func (c *MboxClient) ListMailboxes(ctx context.Context) ([]string, error) {
+	return []string{Inbox}, nil
+}
+
+// FetchNewEmails returns the messages appended since cursor, oldest first
+func (c *MboxClient) FetchNewEmails(ctx context.Context, mailbox string, cursor Cursor) ([]*Email, Cursor, error) {
+	if mailbox != Inbox {
+		return nil, cursor, fmt.Errorf("mbox has no mailbox %q", mailbox)
+	}
+	list, err := c.scan()
+	if err != nil {
+		return nil, cursor, err
+	}
+	uids := make([]uint32, len(list))
+	for i, m := range list {
+		uids[i] = m.uid
+	}
+
+	fresh, next := c.tracker.pick(uids, cursor, mboxValidity, c.maxMessages)
+	emails := make([]*Email, 0, len(fresh))
+	for _, i := range fresh {
+		msg, err := c.fetch(list[i], c.fetchBodies)
+		if err != nil {
+			return nil, cursor, err
+		}
+		emails = append(emails, &msg.Email)
+	}
+	c.tracker.mark(uids, fresh, next)
+	return emails, next, nil
+}
+
+// FetchBatch fails; backfills resume after the highest UID seen, and mbox
+// UIDs are hashes in no particular order
+func (c *MboxClient) FetchBatch(ctx context.Context, mailbox string, afterUID uint32, limit int) ([]*Email, error) {
+	return nil, fmt.Errorf("mbox cannot backfill mailboxes: %w", ErrNotSupported)
+}
+
+// Status returns the number of messages in the file, which is also where a
+// cursor skipping them points
+func (c *MboxClient) Status(ctx context.Context, mailbox string) (MailboxStatus, error) {
+	if mailbox != Inbox {
+		return MailboxStatus{}, fmt.Errorf("mbox has no mailbox %q", mailbox)
+	}
+	list, err := c.scan()
+	if err != nil {
+		return MailboxStatus{}, err
+	}
+	return MailboxStatus{Messages: len(list), UIDValidity: mboxValidity, UIDNext: uint32(len(list)) + 1}, nil
+}
+
+// FetchMessage decodes one message with all its headers, bodies and
+// attachment list. It returns ErrMessageNotFound if the message is gone.
+func (c *MboxClient) FetchMessage(ctx context.Context, mailbox string, uid uint32) (*Message, error) {
+	m, err := c.find(uid)
+	if err != nil {
+		return nil, err
+	}
+	return c.fetch(m, true)
+}
+
+// FetchRaw returns one message as it was delivered
+func (c *MboxClient) FetchRaw(ctx context.Context, mailbox string, uid uint32) ([]byte, error) {
+	m, err := c.find(uid)
+	if err != nil {
+		return nil, err
+	}
+	return c.raw(m)
+}
+
+// ApplyLabel fails; mbox files are read-only
+func (c *MboxClient) ApplyLabel(ctx context.Context, mailbox string, uid uint32, label string) error {
+	return fmt.Errorf("mbox cannot label messages: %w", ErrNotSupported)
+}
+
+// SearchMessageID fails; mbox files are read-only, so nothing needs it
+func (c *MboxClient) SearchMessageID(ctx context.Context, mailbox, messageID string) ([]uint32, error) {
+	return nil, fmt.Errorf("mbox cannot search messages: %w", ErrNotSupported)
+}
+
+// Search fails; mbox files are read-only, so nothing needs it
+func (c *MboxClient) Search(ctx context.Context, mailbox string, filter Filter) ([]uint32, error) {
+	return nil, fmt.Errorf("mbox cannot search messages: %w", ErrNotSupported)
+}
+
+// RemoveLabel fails; mbox files are read-only
+func (c *MboxClient) RemoveLabel(ctx context.Context, mailbox string, uids []uint32, label string) error {
+	return fmt.Errorf("mbox cannot label messages: %w", ErrNotSupported)
+}
+
+// DeleteMessages fails; mbox files are read-only
+func (c *MboxClient) DeleteMessages(ctx context.Context, mailbox string, uids []uint32) error {
+	return fmt.Errorf("mbox cannot delete messages: %w", ErrNotSupported)
+}
+
+// Close does nothing
+func (c *MboxClient) Close() error {
+	return nil
+}
@@ -0,0 +1,72 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// mboxEntry formats a message for an mbox file, quoting From_ lines
+func mboxEntry(msg string) string {
+	body := strings.ReplaceAll(msg, "\r\n", "\n")
+	body = strings.ReplaceAll(body, "\nFrom ", "\n>From ")
+	return "From alice@example.com Mon Jan  2 15:04:05 2023\n" + body + "\n"
+}
+
+func TestMboxFetchNewEmails(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "me")
+	write := func(msgs ...string) {
+		var b strings.Builder
+		for _, m := range msgs {
+			b.WriteString(mboxEntry(m))
+		}
+		if err := os.WriteFile(path, []byte(b.String()), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	quoted := strings.Replace(rfc822Message("m2", "Second"), "Body of", "From the desk of", 1)
+	quoted = strings.Replace(quoted, "\r\n\r\n", "\r\n\r\nHello\r\nFrom here\r\n", 1)
+	write(rfc822Message("m1", "First"), quoted)
+	c := NewMboxClient(path, WithLocalBodies())
+
+	status, err := c.Status(ctx, Inbox)
+	if err != nil || status.Messages != 2 {
+		t.Fatalf("Status = %+v, %v", status, err)
+	}
+
+	emails, cursor, err := c.FetchNewEmails(ctx, Inbox, Cursor{UIDValidity: mboxValidity, LastUID: 1})
+	if err != nil {
+		t.Fatalf("FetchNewEmails: %v", err)
+	}
+	if got := subjects(emails); !reflect.DeepEqual(got, []string{"Second"}) {
+		t.Fatalf("subjects = %v", got)
+	}
+	second := emails[0]
+	if second.UID != hashUID("<m2@example.com>") || !strings.Contains(second.TextBody, "Hello\r\nFrom here") {
+		t.Errorf("email = %+v", second)
+	}
+
+	raw, err := c.FetchRaw(ctx, Inbox, second.UID)
+	if err != nil || string(raw) != quoted {
+		t.Fatalf("FetchRaw = %q, %v", raw, err)
+	}
+
+	// A mail client removing a message does not hide new ones
+	write(quoted, rfc822Message("m3", "Third"))
+	emails, _, err = c.FetchNewEmails(ctx, Inbox, cursor)
+	if err != nil {
+		t.Fatalf("FetchNewEmails: %v", err)
+	}
+	if got := subjects(emails); !reflect.DeepEqual(got, []string{"Third"}) {
+		t.Fatalf("subjects after rewrite = %v", got)
+	}
+
+	if err := c.ApplyLabel(ctx, Inbox, second.UID, "Done"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("ApplyLabel = %v, want ErrNotSupported", err)
+	}
+}
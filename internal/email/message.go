@@ -0,0 +1,20 @@
+package email
+
+import "errors"
+
+// ErrMessageNotFound is returned by FetchMessage when the mailbox has no
+// message with the requested UID
+var ErrMessageNotFound = errors.New("message not found")
+
+// Message is a single message fetched for display: an Email with its
+// decoded bodies, attachments and every header field
+type Message struct {
+	Email
+}
+
+// Attachment describes one attachment; its content is not kept
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Size        int64 // Decoded size in bytes; estimated from the encoded size while polling IMAP
+}
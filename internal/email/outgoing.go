@@ -0,0 +1,20 @@
+package email
+
+import (
+	"net/textproto"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+// OutgoingHeader returns the extra headers for a message an action sends on
+// behalf of account. Auto-Submitted is always present so that auto-responders
+// and ticketing systems do not answer generated mail (RFC 3834); the
+// account's OutgoingHeaders are added on top and may override it.
+func OutgoingHeader(account config.EmailAccount) textproto.MIMEHeader {
+	h := textproto.MIMEHeader{}
+	h.Set("Auto-Submitted", "auto-generated")
+	for name, value := range account.OutgoingHeaders {
+		h.Set(name, value)
+	}
+	return h
+}
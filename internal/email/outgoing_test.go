@@ -0,0 +1,41 @@
+package email
+
+import (
+	"testing"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+func TestOutgoingHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		expected map[string]string
+	}{
+		{"default", nil, map[string]string{"Auto-Submitted": "auto-generated"}},
+		{
+			"extra headers",
+			map[string]string{"x-ticket-source": "go-tsk"},
+			map[string]string{"Auto-Submitted": "auto-generated", "X-Ticket-Source": "go-tsk"},
+		},
+		{
+			"override",
+			map[string]string{"Auto-Submitted": "auto-replied"},
+			map[string]string{"Auto-Submitted": "auto-replied"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := OutgoingHeader(config.EmailAccount{OutgoingHeaders: tt.headers})
+			if len(h) != len(tt.expected) {
+				t.Errorf("got %d headers %v; want %v", len(h), h, tt.expected)
+			}
+			for name, want := range tt.expected {
+				if got := h.Get(name); got != want {
+					t.Errorf("%s = %q; want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,436 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/proxy"
+)
+
+// ErrNotSupported is returned for operations a provider cannot perform,
+// such as changing mail on a POP3 server
+var ErrNotSupported = errors.New("not supported by this provider")
+
+// pop3Validity is the UIDVALIDITY of every POP3 maildrop
+const pop3Validity = 1
+
+// POP3Client downloads mail from a POP3 server (RFC 1939). POP3 only has
+// the inbox and cannot label or search, so only local actions work on its
+// messages.
+//
+// Every call is a separate session, as servers only show mail that arrived
+// before the session began. The UID of a message is a hash of its UIDL, so
+// it stays the same across sessions and restarts.
+type POP3Client struct {
+	addr        string
+	user        string
+	password    string
+	fetchBodies bool
+	maxMessages int
+	tlsConfig   *tls.Config
+	dialer      proxy.Dialer                                // Connects through a proxy; nil dials directly
+	dial        func(ctx context.Context) (net.Conn, error) // Overrides TLS dialing in tests
+
+	tracker positionTracker
+}
+
+// POP3Option customizes a POP3Client
+type POP3Option func(*POP3Client)
+
+// WithPOP3Bodies makes the client download and decode full message bodies
+// into Email.TextBody and Email.HTMLBody
+func WithPOP3Bodies() POP3Option {
+	return func(c *POP3Client) {
+		c.fetchBodies = true
+	}
+}
+
+// WithPOP3MessageLimit makes FetchNewEmails return at most n messages,
+// oldest first, leaving the rest for the next fetch
+func WithPOP3MessageLimit(n int) POP3Option {
+	return func(c *POP3Client) {
+		c.maxMessages = n
+	}
+}
+
+// WithPOP3TLS sets the TLS settings of the connection, instead of verifying
+// the server against the system's root CAs
+func WithPOP3TLS(tlsConfig *tls.Config) POP3Option {
+	return func(c *POP3Client) {
+		c.tlsConfig = tlsConfig
+	}
+}
+
+// WithPOP3Dialer makes the client connect with dialer, such as a
+// proxy.Dialer
+func WithPOP3Dialer(dialer proxy.Dialer) POP3Option {
+	return func(c *POP3Client) {
+		c.dialer = dialer
+	}
+}
+
+// NewPOP3Client creates a client for the POP3 server at addr (host:port).
+// Port 995 uses implicit TLS; other ports must offer STLS, as the password
+// is never sent in the clear.
+func NewPOP3Client(addr, user, password string, opts ...POP3Option) *POP3Client {
+	host, _, _ := net.SplitHostPort(addr)
+	c := &POP3Client{
+		addr:      addr,
+		user:      user,
+		password:  password,
+		tlsConfig: &tls.Config{ServerName: host},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// pop3Conn is one POP3 session
+type pop3Conn struct {
+	conn net.Conn
+	text *textproto.Conn
+}
+
+// cmd sends a command and returns the text after +OK
+func (p *pop3Conn) cmd(format string, args ...interface{}) (string, error) {
+	if err := p.text.PrintfLine(format, args...); err != nil {
+		return "", err
+	}
+	return p.reply()
+}
+
+// reply reads a status line and returns the text after +OK
+func (p *pop3Conn) reply() (string, error) {
+	line, err := p.text.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(line, "+OK") {
+		return strings.TrimSpace(strings.TrimPrefix(line, "+OK")), nil
+	}
+	return "", fmt.Errorf("pop3: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+}
+
+// lines sends a command with a multi-line response and returns its lines
+func (p *pop3Conn) lines(format string, args ...interface{}) ([]string, error) {
+	if _, err := p.cmd(format, args...); err != nil {
+		return nil, err
+	}
+	return p.text.ReadDotLines()
+}
+
+// raw sends a command with a multi-line response and returns it as bytes
+func (p *pop3Conn) raw(format string, args ...interface{}) ([]byte, error) {
+	if _, err := p.cmd(format, args...); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, p.text.DotReader()); err != nil {
+		return nil, err
+	}
+	return bytes.ReplaceAll(buf.Bytes(), []byte("\n"), []byte("\r\n")), nil
+}
+
+// session opens a session, logs in, runs fn and logs out
+func (c *POP3Client) session(ctx context.Context, fn func(p *pop3Conn) error) error {
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", c.addr, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(commandTimeout))
+	}
+
+	p := &pop3Conn{conn: conn, text: textproto.NewConn(conn)}
+	if _, err := p.reply(); err != nil {
+		return err
+	}
+	if c.dial == nil && !isTLS(conn) {
+		if _, err := p.cmd("STLS"); err != nil {
+			return fmt.Errorf("server does not offer TLS: %w", err)
+		}
+		tlsConn := tls.Client(conn, c.tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return fmt.Errorf("TLS handshake failed: %w", err)
+		}
+		p = &pop3Conn{conn: tlsConn, text: textproto.NewConn(tlsConn)}
+	}
+
+	if _, err := p.cmd("USER %s", c.user); err != nil {
+		return fmt.Errorf("authentication failed: %w", err)
+	}
+	if _, err := p.cmd("PASS %s", c.password); err != nil {
+		return fmt.Errorf("authentication failed: %w", err)
+	}
+	if err := fn(p); err != nil {
+		return err
+	}
+	_, err = p.cmd("QUIT")
+	return err
+}
+
+// connect dials the server, with implicit TLS on port 995
+func (c *POP3Client) connect(ctx context.Context) (net.Conn, error) {
+	if c.dial != nil {
+		return c.dial(ctx)
+	}
+	dialer := c.dialer
+	if dialer == nil {
+		dialer = &net.Dialer{Timeout: 30 * time.Second}
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	if _, port, _ := net.SplitHostPort(c.addr); port == "995" {
+		tlsConn := tls.Client(conn, withServerName(c.tlsConfig, c.addr))
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+	return conn, nil
+}
+
+func isTLS(conn net.Conn) bool {
+	_, ok := conn.(*tls.Conn)
+	return ok
+}
+
+// pop3Message is a message in the maildrop
+type pop3Message struct {
+	num  int    // Message number within the session
+	uidl string // Unique ID across sessions
+}
+
+// uidl lists the messages in the maildrop, oldest first
+func (p *pop3Conn) uidl() ([]pop3Message, error) {
+	lines, err := p.lines("UIDL")
+	if err != nil {
+		return nil, fmt.Errorf("UIDL failed: %w", err)
+	}
+	list := make([]pop3Message, 0, len(lines))
+	for _, line := range lines {
+		num, uidl, ok := strings.Cut(strings.TrimSpace(line), " ")
+		n, err := strconv.Atoi(num)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("invalid UIDL line %q", line)
+		}
+		list = append(list, pop3Message{num: n, uidl: uidl})
+	}
+	return list, nil
+}
+
+// hashUID returns the UID of a message with the given unique name, for
+// stores that have no numeric UIDs
+func hashUID(name string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	if uid := h.Sum32(); uid != 0 {
+		return uid
+	}
+	return 1
+}
+
+// fetch retrieves a message, only its headers unless full is set
+func (p *pop3Conn) fetch(m pop3Message, full bool) (*Message, error) {
+	var raw []byte
+	var err error
+	if full {
+		raw, err = p.raw("RETR %d", m.num)
+	} else {
+		raw, err = p.raw("TOP %d 0", m.num)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch message %s: %w", m.uidl, err)
+	}
+
+	msg := readMessage(bytes.NewReader(raw), full, m.uidl)
+	msg.Mailbox = Inbox
+	msg.UID = hashUID(m.uidl)
+	return msg, nil
+}
+
+// Connect does nothing; every call is a separate session
+func (c *POP3Client) Connect(ctx context.Context) error {
+	return nil
+}
+
+// Authenticate checks that the server accepts the credentials
+func (c *POP3Client) Authenticate(ctx context.Context) error {
+	return c.session(ctx, func(p *pop3Conn) error { return nil })
+}
+
+// ListMailboxes returns INBOX, the only mailbox POP3 has
+func (c *POP3Client) ListMailboxes(ctx context.Context) ([]string, error) {
+	return []string{Inbox}, nil
+}
+
+// checkMailbox fails for mailboxes other than INBOX
+func checkMailbox(mailbox string) error {
+	if mailbox != Inbox {
+		return fmt.Errorf("pop3 has no mailbox %q", mailbox)
+	}
+	return nil
+}
+
+// FetchNewEmails retrieves the messages that arrived since cursor, oldest
+// first
+func (c *POP3Client) FetchNewEmails(ctx context.Context, mailbox string, cursor Cursor) ([]*Email, Cursor, error) {
+	if err := checkMailbox(mailbox); err != nil {
+		return nil, cursor, err
+	}
+	var emails []*Email
+	next := cursor
+	err := c.session(ctx, func(p *pop3Conn) error {
+		list, err := p.uidl()
+		if err != nil {
+			return err
+		}
+		uids := make([]uint32, len(list))
+		for i, m := range list {
+			uids[i] = hashUID(m.uidl)
+		}
+
+		var fresh []int
+		fresh, next = c.tracker.pick(uids, cursor, pop3Validity, c.maxMessages)
+		for _, i := range fresh {
+			msg, err := p.fetch(list[i], c.fetchBodies)
+			if err != nil {
+				return err
+			}
+			emails = append(emails, &msg.Email)
+		}
+		c.tracker.mark(uids, fresh, next)
+		return nil
+	})
+	if err != nil {
+		return nil, cursor, err
+	}
+	return emails, next, nil
+}
+
+// FetchBatch fails; backfills resume after the highest UID seen, and POP3
+// UIDs are hashes in no particular order
+func (c *POP3Client) FetchBatch(ctx context.Context, mailbox string, afterUID uint32, limit int) ([]*Email, error) {
+	return nil, fmt.Errorf("pop3 cannot backfill mailboxes: %w", ErrNotSupported)
+}
+
+// Status returns the number of messages in the maildrop, which is also
+// where a cursor skipping them points
+func (c *POP3Client) Status(ctx context.Context, mailbox string) (MailboxStatus, error) {
+	if err := checkMailbox(mailbox); err != nil {
+		return MailboxStatus{}, err
+	}
+	var status MailboxStatus
+	err := c.session(ctx, func(p *pop3Conn) error {
+		reply, err := p.cmd("STAT")
+		if err != nil {
+			return fmt.Errorf("STAT failed: %w", err)
+		}
+		count, _, _ := strings.Cut(reply, " ")
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			return fmt.Errorf("invalid STAT reply %q", reply)
+		}
+		status = MailboxStatus{Messages: n, UIDValidity: pop3Validity, UIDNext: uint32(n) + 1}
+		return nil
+	})
+	return status, err
+}
+
+// FetchMessage downloads one message with all its headers, decoded bodies
+// and attachment list. It returns ErrMessageNotFound if the message is no
+// longer in the maildrop.
+func (c *POP3Client) FetchMessage(ctx context.Context, mailbox string, uid uint32) (*Message, error) {
+	if err := checkMailbox(mailbox); err != nil {
+		return nil, err
+	}
+	var msg *Message
+	err := c.session(ctx, func(p *pop3Conn) error {
+		m, err := p.find(uid)
+		if err != nil {
+			return err
+		}
+		msg, err = p.fetch(m, true)
+		return err
+	})
+	return msg, err
+}
+
+// FetchRaw downloads one message as it was received
+func (c *POP3Client) FetchRaw(ctx context.Context, mailbox string, uid uint32) ([]byte, error) {
+	if err := checkMailbox(mailbox); err != nil {
+		return nil, err
+	}
+	var raw []byte
+	err := c.session(ctx, func(p *pop3Conn) error {
+		m, err := p.find(uid)
+		if err != nil {
+			return err
+		}
+		raw, err = p.raw("RETR %d", m.num)
+		return err
+	})
+	return raw, err
+}
+
+// find returns the message with the given UID
+func (p *pop3Conn) find(uid uint32) (pop3Message, error) {
+	list, err := p.uidl()
+	if err != nil {
+		return pop3Message{}, err
+	}
+	for _, m := range list {
+		if hashUID(m.uidl) == uid {
+			return m, nil
+		}
+	}
+	return pop3Message{}, ErrMessageNotFound
+}
+
+// ApplyLabel fails; POP3 has no labels
+func (c *POP3Client) ApplyLabel(ctx context.Context, mailbox string, uid uint32, label string) error {
+	return fmt.Errorf("pop3 cannot label messages: %w", ErrNotSupported)
+}
+
+// SearchMessageID fails; POP3 cannot search
+func (c *POP3Client) SearchMessageID(ctx context.Context, mailbox, messageID string) ([]uint32, error) {
+	return nil, fmt.Errorf("pop3 cannot search messages: %w", ErrNotSupported)
+}
+
+// Search fails; POP3 cannot search
+func (c *POP3Client) Search(ctx context.Context, mailbox string, filter Filter) ([]uint32, error) {
+	return nil, fmt.Errorf("pop3 cannot search messages: %w", ErrNotSupported)
+}
+
+// RemoveLabel fails; POP3 has no labels
+func (c *POP3Client) RemoveLabel(ctx context.Context, mailbox string, uids []uint32, label string) error {
+	return fmt.Errorf("pop3 cannot label messages: %w", ErrNotSupported)
+}
+
+// DeleteMessages fails; go-tsk leaves POP3 mail on the server for the
+// mail client that downloads it
+func (c *POP3Client) DeleteMessages(ctx context.Context, mailbox string, uids []uint32) error {
+	return fmt.Errorf("pop3 cannot delete messages: %w", ErrNotSupported)
+}
+
+// Close does nothing; sessions end with each call
+func (c *POP3Client) Close() error {
+	return nil
+}
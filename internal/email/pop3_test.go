@@ -0,0 +1,247 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakePOP3 is a maildrop served over in-memory connections
+type fakePOP3 struct {
+	mu       sync.Mutex
+	password string
+	uidls    []string
+	messages []string
+}
+
+func (f *fakePOP3) add(uidl, msg string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.uidls = append(f.uidls, uidl)
+	f.messages = append(f.messages, msg)
+}
+
+func (f *fakePOP3) client(opts ...POP3Option) *POP3Client {
+	c := NewPOP3Client("pop.example.com:110", "me", "secret", opts...)
+	c.dial = func(ctx context.Context) (net.Conn, error) {
+		client, server := net.Pipe()
+		go f.serve(server)
+		return client, nil
+	}
+	return c
+}
+
+func (f *fakePOP3) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("+OK ready")
+
+	// Like real servers, a session sees the maildrop as it was at login
+	f.mu.Lock()
+	uidls := append([]string(nil), f.uidls...)
+	messages := append([]string(nil), f.messages...)
+	f.mu.Unlock()
+
+	message := func(arg string) (string, bool) {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 || n > len(messages) {
+			text.PrintfLine("-ERR no such message")
+			return "", false
+		}
+		return messages[n-1], true
+	}
+	multiline := func(body string) {
+		text.PrintfLine("+OK")
+		w := text.DotWriter()
+		w.Write([]byte(body))
+		w.Close()
+	}
+
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch cmd {
+		case "USER":
+			text.PrintfLine("+OK")
+		case "PASS":
+			if arg != f.password {
+				text.PrintfLine("-ERR invalid password")
+				continue
+			}
+			text.PrintfLine("+OK logged in")
+		case "STAT":
+			text.PrintfLine("+OK %d 0", len(messages))
+		case "UIDL":
+			var b strings.Builder
+			for i, uidl := range uidls {
+				fmt.Fprintf(&b, "%d %s\n", i+1, uidl)
+			}
+			multiline(b.String())
+		case "RETR":
+			if msg, ok := message(arg); ok {
+				multiline(msg)
+			}
+		case "TOP":
+			num, _, _ := strings.Cut(arg, " ")
+			if msg, ok := message(num); ok {
+				header, _, _ := strings.Cut(msg, "\r\n\r\n")
+				multiline(header + "\r\n\r\n")
+			}
+		case "QUIT":
+			text.PrintfLine("+OK bye")
+			return
+		default:
+			text.PrintfLine("-ERR unknown command")
+		}
+	}
+}
+
+func rfc822Message(id, subject string) string {
+	return "Message-ID: <" + id + "@example.com>\r\n" +
+		"From: Alice <alice@example.com>\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Date: Mon, 2 Jan 2023 15:04:05 +0000\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Body of " + subject + "\r\n"
+}
+
+func TestPOP3FetchNewEmails(t *testing.T) {
+	ctx := context.Background()
+	f := &fakePOP3{password: "secret"}
+	f.add("u1", rfc822Message("m1", "First"))
+	f.add("u2", rfc822Message("m2", "Second"))
+	c := f.client(WithPOP3Bodies())
+
+	if err := c.Authenticate(ctx); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	status, err := c.Status(ctx, Inbox)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if status.Messages != 2 || status.Head().LastUID != 2 {
+		t.Fatalf("status = %+v", status)
+	}
+
+	// A cursor from an earlier run skips the first message
+	emails, cursor, err := c.FetchNewEmails(ctx, Inbox, Cursor{UIDValidity: pop3Validity, LastUID: 1})
+	if err != nil {
+		t.Fatalf("FetchNewEmails: %v", err)
+	}
+	if got := subjects(emails); len(got) != 1 || got[0] != "Second" {
+		t.Fatalf("subjects = %v", got)
+	}
+	m := emails[0]
+	if m.MessageID != "<m2@example.com>" || m.UID != hashUID("u2") || m.Date.IsZero() || !strings.Contains(m.TextBody, "Body of Second") {
+		t.Errorf("email = %+v", m)
+	}
+	if cursor.LastUID != 2 {
+		t.Errorf("cursor = %+v", cursor)
+	}
+
+	// Once the UIDLs are known, deletions by other clients do not hide new
+	// mail
+	f.mu.Lock()
+	f.uidls, f.messages = f.uidls[1:], f.messages[1:]
+	f.mu.Unlock()
+	f.add("u3", rfc822Message("m3", "Third"))
+	emails, cursor, err = c.FetchNewEmails(ctx, Inbox, cursor)
+	if err != nil {
+		t.Fatalf("FetchNewEmails: %v", err)
+	}
+	if got := subjects(emails); len(got) != 1 || got[0] != "Third" {
+		t.Fatalf("subjects after deletion = %v", got)
+	}
+	if cursor.LastUID != 2 {
+		t.Errorf("cursor after deletion = %+v", cursor)
+	}
+
+	emails, _, err = c.FetchNewEmails(ctx, Inbox, cursor)
+	if err != nil || len(emails) != 0 {
+		t.Fatalf("FetchNewEmails without new mail = %v, %v", subjects(emails), err)
+	}
+}
+
+func TestPOP3MessageLimit(t *testing.T) {
+	f := &fakePOP3{password: "secret"}
+	for i := 1; i <= 3; i++ {
+		f.add(fmt.Sprintf("u%d", i), rfc822Message(fmt.Sprintf("m%d", i), fmt.Sprintf("Message %d", i)))
+	}
+	c := f.client(WithPOP3MessageLimit(2))
+
+	emails, cursor, err := c.FetchNewEmails(context.Background(), Inbox, Cursor{UIDValidity: pop3Validity})
+	if err != nil {
+		t.Fatalf("FetchNewEmails: %v", err)
+	}
+	if got := subjects(emails); len(got) != 2 || got[0] != "Message 1" {
+		t.Fatalf("subjects = %v", got)
+	}
+	if emails[0].TextBody != "" {
+		t.Errorf("TextBody = %q without WithPOP3Bodies", emails[0].TextBody)
+	}
+	if cursor.LastUID != 2 {
+		t.Errorf("cursor = %+v", cursor)
+	}
+
+	emails, _, err = c.FetchNewEmails(context.Background(), Inbox, cursor)
+	if err != nil {
+		t.Fatalf("FetchNewEmails: %v", err)
+	}
+	if got := subjects(emails); len(got) != 1 || got[0] != "Message 3" {
+		t.Fatalf("subjects of second fetch = %v", got)
+	}
+}
+
+func TestPOP3FetchRaw(t *testing.T) {
+	ctx := context.Background()
+	f := &fakePOP3{password: "secret"}
+	f.add("u1", rfc822Message("m1", "First"))
+	c := f.client()
+
+	raw, err := c.FetchRaw(ctx, Inbox, hashUID("u1"))
+	if err != nil {
+		t.Fatalf("FetchRaw: %v", err)
+	}
+	if string(raw) != rfc822Message("m1", "First") {
+		t.Errorf("raw = %q", raw)
+	}
+	msg, err := c.FetchMessage(ctx, Inbox, hashUID("u1"))
+	if err != nil {
+		t.Fatalf("FetchMessage: %v", err)
+	}
+	if msg.Header.Get("From") != "Alice <alice@example.com>" || !strings.Contains(msg.TextBody, "Body of First") {
+		t.Errorf("message = %+v", msg)
+	}
+	if _, err := c.FetchRaw(ctx, Inbox, hashUID("gone")); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("FetchRaw of a missing message = %v, want ErrMessageNotFound", err)
+	}
+}
+
+func TestPOP3Errors(t *testing.T) {
+	ctx := context.Background()
+	f := &fakePOP3{password: "other"}
+	if err := f.client().Authenticate(ctx); err == nil || !strings.Contains(err.Error(), "invalid password") {
+		t.Errorf("Authenticate with a wrong password = %v", err)
+	}
+
+	c := f.client()
+	if _, _, err := c.FetchNewEmails(ctx, "Archive", Cursor{}); err == nil {
+		t.Error("FetchNewEmails of a mailbox other than INBOX succeeded")
+	}
+	if err := c.ApplyLabel(ctx, Inbox, 1, "Done"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("ApplyLabel = %v, want ErrNotSupported", err)
+	}
+	if err := c.DeleteMessages(ctx, Inbox, []uint32{1}); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("DeleteMessages = %v, want ErrNotSupported", err)
+	}
+}
@@ -0,0 +1,50 @@
+package email
+
+import (
+	"fmt"
+
+	"golang.org/x/oauth2"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+// NewPresetClient creates an IMAP client for an account of a preset
+// provider such as yahoo. Accounts with a Password log in with it as an
+// app password; others authenticate with XOAUTH2 and their Token, unless
+// opts supply a token source.
+func NewPresetClient(account config.EmailAccount, opts ...GmailOption) (*GmailClient, error) {
+	preset, ok := config.LookupPreset(account.Provider)
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q", account.Provider)
+	}
+	server := preset.Server
+	if account.Server != "" {
+		server = account.Server
+	}
+	base, err := imapServerOptions(account, server)
+	if err != nil {
+		return nil, err
+	}
+	if account.Password != "" {
+		base = append(base, WithPassword(account.Password))
+	}
+	if account.FetchBodies {
+		base = append(base, WithBodies())
+	}
+	return NewGmailClient(account.Address, account.ClientID, account.ClientSecret, account.Token, append(base, opts...)...)
+}
+
+// OAuthConfig returns the OAuth2 config for mail access of an account's
+// OAuth client: that of its preset, or Gmail's
+func OAuthConfig(account config.EmailAccount) *oauth2.Config {
+	preset, ok := config.LookupPreset(account.Provider)
+	if !ok {
+		return GmailOAuthConfig(account.ClientID, account.ClientSecret)
+	}
+	return &oauth2.Config{
+		ClientID:     account.ClientID,
+		ClientSecret: account.ClientSecret,
+		Endpoint:     oauth2.Endpoint{AuthURL: preset.AuthURL, TokenURL: preset.TokenURL},
+		Scopes:       preset.Scopes,
+	}
+}
@@ -0,0 +1,56 @@
+package email
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/imaptest"
+)
+
+func TestPresetClientAppPassword(t *testing.T) {
+	srv := imaptest.New(t, fixtures()...)
+	account := config.EmailAccount{ID: "y", Provider: "yahoo", Address: imaptest.Username, Password: imaptest.Token}
+	g, err := NewPresetClient(account, WithServer(srv.Addr(), srv.TLSConfig()))
+	if err != nil {
+		t.Fatalf("NewPresetClient() error = %v", err)
+	}
+	defer g.Close()
+	if g.addr != srv.Addr() || g.password != imaptest.Token {
+		t.Fatalf("client = %+v", g)
+	}
+
+	ctx := context.Background()
+	if err := g.Connect(ctx); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	if err := g.Authenticate(ctx); err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	emails, _, err := g.FetchNewEmails(ctx, Inbox, Cursor{})
+	if err != nil || len(emails) != 3 {
+		t.Fatalf("FetchNewEmails() = %d emails, %v", len(emails), err)
+	}
+}
+
+func TestPresetServer(t *testing.T) {
+	g, err := NewPresetClient(config.EmailAccount{Provider: "aol", Address: "me@aol.com", Password: "p"})
+	if err != nil || g.addr != "imap.aol.com:993" {
+		t.Fatalf("aol client = %v, %v", g, err)
+	}
+	g, err = NewPresetClient(config.EmailAccount{Provider: "yahoo", Server: "localhost:1993"})
+	if err != nil || g.addr != "localhost:1993" {
+		t.Fatalf("yahoo client with Server = %v, %v", g, err)
+	}
+	if _, err := NewPresetClient(config.EmailAccount{Provider: "gmail"}); err == nil {
+		t.Error("NewPresetClient() of a provider without a preset succeeded")
+	}
+
+	conf := OAuthConfig(config.EmailAccount{Provider: "yahoo", ClientID: "id"})
+	if conf.Endpoint.TokenURL != "https://api.login.yahoo.com/oauth2/get_token" || conf.ClientID != "id" {
+		t.Errorf("yahoo OAuth config = %+v", conf)
+	}
+	if conf := OAuthConfig(config.EmailAccount{Provider: "gmail"}); conf.Scopes[0] != "https://mail.google.com/" {
+		t.Errorf("gmail OAuth config = %+v", conf)
+	}
+}
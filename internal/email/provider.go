@@ -0,0 +1,207 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/proxy"
+)
+
+// Inbox is the mailbox polled when an account configures none
+const Inbox = "INBOX"
+
+// Provider is a mail backend that can be polled for new messages and acted
+// on. UIDs are only meaningful within the mailbox they were fetched from.
+type Provider interface {
+	Connect(ctx context.Context) error
+	Authenticate(ctx context.Context) error
+	ListMailboxes(ctx context.Context) ([]string, error)
+	FetchNewEmails(ctx context.Context, mailbox string, cursor Cursor) ([]*Email, Cursor, error)
+	FetchBatch(ctx context.Context, mailbox string, afterUID uint32, limit int) ([]*Email, error)
+	Status(ctx context.Context, mailbox string) (MailboxStatus, error)
+	ApplyLabel(ctx context.Context, mailbox string, uid uint32, label string) error
+	FetchMessage(ctx context.Context, mailbox string, uid uint32) (*Message, error)
+	SearchMessageID(ctx context.Context, mailbox, messageID string) ([]uint32, error)
+	Search(ctx context.Context, mailbox string, filter Filter) ([]uint32, error)
+	RemoveLabel(ctx context.Context, mailbox string, uids []uint32, label string) error
+	DeleteMessages(ctx context.Context, mailbox string, uids []uint32) error
+	Close() error
+}
+
+// Filter selects messages for batch maintenance. Zero fields match
+// everything.
+type Filter struct {
+	Before time.Time // Received before this day
+	Label  string    // Carrying this label
+}
+
+// RawFetcher is a provider that can download messages exactly as they were
+// received, for archiving
+type RawFetcher interface {
+	FetchRaw(ctx context.Context, mailbox string, uid uint32) ([]byte, error)
+}
+
+// Streamer is a provider that hands over new messages while it fetches the
+// rest, so they need not all be held at once
+type Streamer interface {
+	// StreamNewEmails calls fn with each chunk of the messages in mailbox
+	// after cursor, oldest first, and the cursor after the chunk. It stops
+	// with fn's error if fn fails, and returns the cursor after the last
+	// chunk fn accepted, or on ErrUIDValidityChanged the reset cursor.
+	StreamNewEmails(ctx context.Context, mailbox string, cursor Cursor, fn func(emails []*Email, next Cursor) error) (Cursor, error)
+}
+
+// StreamNewEmails hands the new messages in mailbox to fn as p fetches
+// them, or all at once if p cannot stream them. Its results are those of
+// Streamer.StreamNewEmails.
+func StreamNewEmails(ctx context.Context, p Provider, mailbox string, cursor Cursor, fn func(emails []*Email, next Cursor) error) (Cursor, error) {
+	if s, ok := p.(Streamer); ok {
+		return s.StreamNewEmails(ctx, mailbox, cursor, fn)
+	}
+	emails, next, err := p.FetchNewEmails(ctx, mailbox, cursor)
+	if err != nil {
+		if errors.Is(err, ErrUIDValidityChanged) {
+			return next, err
+		}
+		return cursor, err
+	}
+	if len(emails) > 0 {
+		if err := fn(emails, next); err != nil {
+			return cursor, err
+		}
+	}
+	return next, nil
+}
+
+// Pinger is a provider whose connection can be checked before reuse
+type Pinger interface {
+	// Ping fails if the connection is no longer usable
+	Ping(ctx context.Context) error
+}
+
+// Mover is a provider that can move messages between mailboxes
+type Mover interface {
+	MoveMessage(ctx context.Context, mailbox string, uid uint32, dest string) error
+}
+
+// BatchLabeler is a provider that can label many messages of a mailbox
+// with one command
+type BatchLabeler interface {
+	ApplyLabels(ctx context.Context, mailbox string, uids []uint32, label string) error
+}
+
+// BatchMover is a provider that can move many messages of a mailbox with
+// one command
+type BatchMover interface {
+	MoveMessages(ctx context.Context, mailbox string, uids []uint32, dest string) error
+}
+
+// ApplyLabels adds a label to messages of a mailbox, with one command if
+// the provider can batch them and one per message otherwise
+func ApplyLabels(ctx context.Context, p Provider, mailbox string, uids []uint32, label string) error {
+	if batch, ok := p.(BatchLabeler); ok {
+		return batch.ApplyLabels(ctx, mailbox, uids, label)
+	}
+	for _, uid := range uids {
+		if err := p.ApplyLabel(ctx, mailbox, uid, label); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MoveMessages moves messages of a mailbox to dest, with one command if
+// the provider can batch them and one per message otherwise
+func MoveMessages(ctx context.Context, m Mover, mailbox string, uids []uint32, dest string) error {
+	if batch, ok := m.(BatchMover); ok {
+		return batch.MoveMessages(ctx, mailbox, uids, dest)
+	}
+	for _, uid := range uids {
+		if err := m.MoveMessage(ctx, mailbox, uid, dest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewProvider creates the provider configured for an account
+func NewProvider(account config.EmailAccount) (Provider, error) {
+	switch account.Provider {
+	case "gmail", "":
+		opts, err := imapServerOptions(account, gmailIMAPAddr)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, fetchOption(account))
+		if account.FetchBodies {
+			opts = append(opts, WithBodies())
+		}
+		return NewGmailClient(account.Address, account.ClientID, account.ClientSecret, account.Token, opts...)
+	case "gmailapi":
+		transport, err := proxy.Transport(account.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("account %s: %w", account.ID, err)
+		}
+		opts := []GmailAPIOption{WithMessageLimit(account.Limits.MaxMessages), WithAPITransport(transport)}
+		if account.FetchBodies {
+			opts = append(opts, WithAPIBodies())
+		}
+		return NewGmailAPIClient(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: account.Token}), opts...), nil
+	case "jmap":
+		transport, err := proxy.Transport(account.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("account %s: %w", account.ID, err)
+		}
+		opts := []JMAPOption{WithJMAPMessageLimit(account.Limits.MaxMessages), WithJMAPTransport(transport)}
+		if account.FetchBodies {
+			opts = append(opts, WithJMAPBodies())
+		}
+		return NewJMAPClient(account.SessionURL, account.Token, opts...), nil
+	case "pop3":
+		tlsConfig, err := NewTLSConfig(account.TLS, account.Server)
+		if err != nil {
+			return nil, fmt.Errorf("account %s: %w", account.ID, err)
+		}
+		dialer, err := proxy.New(account.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("account %s: %w", account.ID, err)
+		}
+		opts := []POP3Option{WithPOP3MessageLimit(account.Limits.MaxMessages), WithPOP3TLS(tlsConfig), WithPOP3Dialer(dialer)}
+		if account.FetchBodies {
+			opts = append(opts, WithPOP3Bodies())
+		}
+		return NewPOP3Client(account.Server, account.Address, account.Password, opts...), nil
+	case "maildir", "mbox":
+		opts := []LocalOption{WithLocalMessageLimit(account.Limits.MaxMessages)}
+		if account.FetchBodies {
+			opts = append(opts, WithLocalBodies())
+		}
+		if account.Provider == "mbox" {
+			return NewMboxClient(account.Path, opts...), nil
+		}
+		return NewMaildirClient(account.Path, opts...), nil
+	case "fake":
+		return NewFakeProvider(1), nil
+	default:
+		if _, ok := config.LookupPreset(account.Provider); ok {
+			return NewPresetClient(account, fetchOption(account))
+		}
+		return nil, fmt.Errorf("unknown provider %q", account.Provider)
+	}
+}
+
+// fetchOption returns the fetch settings of an IMAP account. A smaller
+// Limits.MaxMessages caps the fetch too, so messages the poll would defer
+// are not downloaded.
+func fetchOption(account config.EmailAccount) GmailOption {
+	fetch := account.Fetch
+	if limit := account.Limits.MaxMessages; limit > 0 && (fetch.MaxMessages <= 0 || limit < fetch.MaxMessages) {
+		fetch.MaxMessages = limit
+	}
+	return WithFetch(fetch)
+}
@@ -0,0 +1,108 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+// isConnectionError reports whether err indicates the IMAP connection is gone
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	switch {
+	case errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, net.ErrClosed),
+		errors.Is(err, syscall.EPIPE),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNABORTED),
+		errors.Is(err, client.ErrAlreadyLoggedOut),
+		errors.Is(err, client.ErrNotLoggedIn):
+		return true
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+
+	// go-imap reports a connection closed mid-command with an unexported error
+	return strings.Contains(err.Error(), "connection closed")
+}
+
+// connectionLost reports whether a failed operation was caused by the
+// connection dropping rather than by the server rejecting the command
+func (g *GmailClient) connectionLost(err error) bool {
+	if isConnectionError(err) {
+		return true
+	}
+	if g.client == nil {
+		return false
+	}
+	select {
+	case <-g.client.LoggedOut():
+		return true
+	default:
+	}
+	return g.client.State() == imap.LogoutState
+}
+
+// reconnect discards the current connection and establishes a new
+// authenticated one
+func (g *GmailClient) reconnect(ctx context.Context) error {
+	if g.client != nil {
+		// The connection is already unusable, so just release the socket
+		_ = g.client.Terminate()
+		g.client = nil
+	}
+
+	if err := g.Connect(ctx); err != nil {
+		return err
+	}
+	if err := g.Authenticate(ctx); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Ping checks the connection with NOOP. It does not reconnect, so a
+// connection pool can replace a dead connection instead.
+func (g *GmailClient) Ping(ctx context.Context) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.run(ctx, commandTimeout, func(c *client.Client) error {
+		return c.Noop()
+	})
+}
+
+// withReconnect runs op and, if it failed because the connection dropped,
+// re-dials, re-authenticates and retries it exactly once. Nothing is retried
+// once ctx is done. Operations are serialized, because the poller and
+// message lookups share one connection and each operation selects the
+// mailbox it needs.
+func (g *GmailClient) withReconnect(ctx context.Context, op func() error) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	err := op()
+	if err == nil || ctx.Err() != nil || !g.connectionLost(err) {
+		return err
+	}
+
+	log.Printf("IMAP connection lost (%v), reconnecting", err)
+	if rerr := g.reconnect(ctx); rerr != nil {
+		return fmt.Errorf("reconnect after %v failed: %w", err, rerr)
+	}
+	return op()
+}
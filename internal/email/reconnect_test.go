@@ -0,0 +1,34 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestIsConnectionError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"nil", nil, false},
+		{"eof", io.EOF, true},
+		{"wrapped eof", fmt.Errorf("fetch failed: %w", io.EOF), true},
+		{"broken pipe", &net.OpError{Op: "write", Err: syscall.EPIPE}, true},
+		{"connection reset", syscall.ECONNRESET, true},
+		{"closed connection", errors.New("imap: connection closed"), true},
+		{"server rejection", errors.New("NO [AUTHENTICATIONFAILED] Invalid credentials"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isConnectionError(tt.err); got != tt.expected {
+				t.Errorf("isConnectionError(%v) = %v; want %v", tt.err, got, tt.expected)
+			}
+		})
+	}
+}
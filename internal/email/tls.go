@@ -0,0 +1,74 @@
+package email
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/proxy"
+)
+
+// NewTLSConfig builds the TLS settings of connections to server (host:port)
+// from an account's TLS config, loading its CA and client certificate files
+func NewTLSConfig(cfg config.TLSConfig, server string) (*tls.Config, error) {
+	version, err := cfg.Version()
+	if err != nil {
+		return nil, err
+	}
+	host, _, err := net.SplitHostPort(server)
+	if err != nil {
+		host = server
+	}
+	tc := &tls.Config{
+		ServerName:         host,
+		MinVersion:         version,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in CA file %s", cfg.CAFile)
+		}
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return tc, nil
+}
+
+// useStartTLS reports whether IMAP connections to server start in the
+// clear and upgrade with STARTTLS: if cfg asks for it or server is on
+// port 143
+func useStartTLS(cfg config.TLSConfig, server string) bool {
+	_, port, _ := net.SplitHostPort(server)
+	return cfg.StartTLS || port == "143"
+}
+
+// imapServerOptions returns the options connecting an IMAP client to
+// server with the account's TLS and proxy settings
+func imapServerOptions(account config.EmailAccount, server string) ([]GmailOption, error) {
+	tlsConfig, err := NewTLSConfig(account.TLS, server)
+	if err != nil {
+		return nil, fmt.Errorf("account %s: %w", account.ID, err)
+	}
+	dialer, err := proxy.New(account.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("account %s: %w", account.ID, err)
+	}
+	opts := []GmailOption{WithServer(server, tlsConfig), WithDialer(dialer)}
+	if useStartTLS(account.TLS, server) {
+		opts = append(opts, WithStartTLS())
+	}
+	return opts, nil
+}
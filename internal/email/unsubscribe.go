@@ -0,0 +1,56 @@
+package email
+
+import (
+	"net/textproto"
+	"net/url"
+	"strings"
+)
+
+// Unsubscribe holds the ways a mailing list message offers to unsubscribe
+// from its list, from its List-Unsubscribe header (RFC 2369)
+type Unsubscribe struct {
+	URLs    []string // http and https URLs, to be opened in a browser unless OneClick
+	Mailtos []string // mailto: URIs, whose message unsubscribes
+	// OneClick reports whether the first https URL unsubscribes on a
+	// single POST (RFC 8058)
+	OneClick bool
+}
+
+// ParseUnsubscribe reads the unsubscribe methods of a message header.
+// Entries that are not http, https or mailto URIs are ignored.
+func ParseUnsubscribe(h textproto.MIMEHeader) Unsubscribe {
+	var u Unsubscribe
+	for _, value := range h.Values("List-Unsubscribe") {
+		for _, entry := range bracketed(value) {
+			uri := strings.TrimSpace(strings.Trim(entry, "<>"))
+			parsed, err := url.Parse(uri)
+			if err != nil {
+				continue
+			}
+			switch strings.ToLower(parsed.Scheme) {
+			case "http", "https":
+				if parsed.Host != "" {
+					u.URLs = append(u.URLs, uri)
+				}
+			case "mailto":
+				if parsed.Opaque != "" {
+					u.Mailtos = append(u.Mailtos, uri)
+				}
+			}
+		}
+	}
+	post := strings.TrimSpace(h.Get("List-Unsubscribe-Post"))
+	u.OneClick = strings.EqualFold(post, "List-Unsubscribe=One-Click") && u.OneClickURL() != ""
+	return u
+}
+
+// OneClickURL returns the URL a one-click unsubscription POSTs to, the
+// first https URL; RFC 8058 does not allow plain http
+func (u Unsubscribe) OneClickURL() string {
+	for _, uri := range u.URLs {
+		if strings.HasPrefix(strings.ToLower(uri), "https://") {
+			return uri
+		}
+	}
+	return ""
+}
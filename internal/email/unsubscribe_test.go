@@ -0,0 +1,50 @@
+package email
+
+import (
+	"net/textproto"
+	"reflect"
+	"testing"
+)
+
+func TestParseUnsubscribe(t *testing.T) {
+	tests := []struct {
+		name   string
+		header textproto.MIMEHeader
+		want   Unsubscribe
+	}{
+		{"none", textproto.MIMEHeader{}, Unsubscribe{}},
+		{
+			"one-click",
+			textproto.MIMEHeader{
+				"List-Unsubscribe":      {"<mailto:leave@lists.example.com?subject=unsubscribe>, <https://lists.example.com/u/123>"},
+				"List-Unsubscribe-Post": {"List-Unsubscribe=One-Click"},
+			},
+			Unsubscribe{URLs: []string{"https://lists.example.com/u/123"}, Mailtos: []string{"mailto:leave@lists.example.com?subject=unsubscribe"}, OneClick: true},
+		},
+		{
+			"one-click over http",
+			textproto.MIMEHeader{
+				"List-Unsubscribe":      {"<http://lists.example.com/u/123>"},
+				"List-Unsubscribe-Post": {"List-Unsubscribe=One-Click"},
+			},
+			Unsubscribe{URLs: []string{"http://lists.example.com/u/123"}},
+		},
+		{
+			"mailto only",
+			textproto.MIMEHeader{"List-Unsubscribe": {"<mailto:leave@lists.example.com>"}},
+			Unsubscribe{Mailtos: []string{"mailto:leave@lists.example.com"}},
+		},
+		{
+			"unsupported schemes",
+			textproto.MIMEHeader{"List-Unsubscribe": {"<ftp://lists.example.com/leave>, <javascript:alert(1)>, <mailto:>"}},
+			Unsubscribe{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseUnsubscribe(tt.header); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseUnsubscribe() = %+v; want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/metrics"
+)
+
+// defaultTimeout bounds a single publish when the sink sets no timeout
+const defaultTimeout = 10 * time.Second
+
+// defaultQueueSize bounds the events waiting for the sinks when the config
+// sets no size
+const defaultQueueSize = 1000
+
+// drainTimeout bounds how long Close waits for queued events to be
+// published
+const drainTimeout = 10 * time.Second
+
+// ErrQueueFull is returned by Enqueue when the sinks are too far behind to
+// take the event
+var ErrQueueFull = errors.New("event queue full")
+
+// Sink delivers events to one destination
+type Sink interface {
+	Name() string
+	Publish(ctx context.Context, e Event) error
+	Close() error
+}
+
+// Emitter fans events out to all configured sinks and to in-process
+// subscribers. Queued events are published to the sinks by a worker
+// goroutine, so a slow sink never holds up the caller.
+type Emitter struct {
+	sinks []Sink
+
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+
+	queueMu sync.Mutex
+	queue   chan Event    // nil without sinks
+	closed  bool          // Set by Close; guarded by queueMu
+	drained chan struct{} // Closed when the worker has published the queue
+}
+
+// New creates an emitter from the sink configuration
+func New(cfg config.EventsConfig) (*Emitter, error) {
+	e := &Emitter{}
+	for _, sinkCfg := range cfg.Sinks {
+		if !sinkCfg.Enabled {
+			continue
+		}
+
+		sink, err := newSink(sinkCfg)
+		if err != nil {
+			e.Close()
+			return nil, fmt.Errorf("sink %s: %w", sinkCfg.Name, err)
+		}
+		e.sinks = append(e.sinks, sink)
+	}
+
+	if len(e.sinks) > 0 {
+		size := cfg.QueueSize
+		if size <= 0 {
+			size = defaultQueueSize
+		}
+		e.queue = make(chan Event, size)
+		e.drained = make(chan struct{})
+		go e.work()
+	}
+	return e, nil
+}
+
+// newSink creates a sink for the given configuration
+func newSink(cfg config.SinkConfig) (Sink, error) {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	switch cfg.Type {
+	case "http":
+		return newHTTPSink(cfg, timeout)
+	case "nats":
+		return newNATSSink(cfg, timeout)
+	case "kafka":
+		return newKafkaSink(cfg, timeout)
+	case "eventbridge":
+		return newEventBridgeSink(cfg, timeout)
+	case "pubsub":
+		return newPubSubSink(cfg, timeout)
+	default:
+		return nil, fmt.Errorf("unknown sink type %q", cfg.Type)
+	}
+}
+
+// Subscribe returns a channel receiving every event emitted from now on
+// and a function that ends the subscription. Events are dropped for a
+// subscriber whose buffer is full rather than holding up polling.
+func (e *Emitter) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+	e.mu.Lock()
+	if e.subs == nil {
+		e.subs = make(map[chan Event]struct{})
+	}
+	e.subs[ch] = struct{}{}
+	e.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			e.mu.Lock()
+			delete(e.subs, ch)
+			e.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Broadcast publishes the event to subscribers only
+func (e *Emitter) Broadcast(ev Event) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for ch := range e.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// Emit publishes the event to every subscriber and sink, returning the
+// first sink error
+func (e *Emitter) Emit(ctx context.Context, ev Event) error {
+	e.Broadcast(ev)
+	return e.publish(ctx, ev)
+}
+
+// Enqueue publishes the event to every subscriber and queues it for the
+// sinks, without waiting for them. If the queue is full, or the emitter
+// closed, the sinks miss the event and ErrQueueFull is returned.
+func (e *Emitter) Enqueue(ev Event) error {
+	e.Broadcast(ev)
+
+	e.queueMu.Lock()
+	defer e.queueMu.Unlock()
+	if e.queue == nil {
+		return nil
+	}
+	if e.closed {
+		return ErrQueueFull
+	}
+	select {
+	case e.queue <- ev:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// work publishes queued events until the queue is closed. Failures are
+// logged and counted in the event's account's event_failures metric.
+func (e *Emitter) work() {
+	defer close(e.drained)
+	for ev := range e.queue {
+		if err := e.publish(context.Background(), ev); err != nil {
+			account := strings.TrimPrefix(ev.Source, AccountSource(""))
+			metrics.Add(account, "event_failures", 1)
+			log.Printf("Failed to emit event for account %s: %v", account, err)
+		}
+	}
+}
+
+// publish publishes the event to every sink, returning the first error
+func (e *Emitter) publish(ctx context.Context, ev Event) error {
+	var firstErr error
+	for _, sink := range e.sinks {
+		if err := sink.Publish(ctx, ev); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to publish %s to %s: %w", ev.Type, sink.Name(), err)
+		}
+	}
+	return firstErr
+}
+
+// Close publishes the queued events, waiting up to drainTimeout, and
+// releases all sink connections
+func (e *Emitter) Close() error {
+	e.queueMu.Lock()
+	queue := e.queue
+	if queue != nil && !e.closed {
+		e.closed = true
+		close(queue)
+	}
+	e.queueMu.Unlock()
+	if queue != nil {
+		select {
+		case <-e.drained:
+		case <-time.After(drainTimeout):
+			log.Printf("Closing event sinks with %d events unpublished", len(queue))
+		}
+	}
+
+	var firstErr error
+	for _, sink := range e.sinks {
+		if err := sink.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to close %s: %w", sink.Name(), err)
+		}
+	}
+	return firstErr
+}
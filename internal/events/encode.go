@@ -0,0 +1,139 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Supported event formats
+const (
+	FormatJSON        = "json"
+	FormatCloudEvents = "cloudevents"
+)
+
+// CloudEvents content modes
+const (
+	ModeStructured = "structured"
+	ModeBinary     = "binary"
+)
+
+// Content types used on the wire
+const (
+	contentTypeJSON        = "application/json"
+	contentTypeCloudEvents = "application/cloudevents+json"
+)
+
+// specVersion is the CloudEvents specification version emitted
+const specVersion = "1.0"
+
+// message is an event encoded for a transport: headers to set alongside
+// the body. Transports without headers only support structured payloads.
+type message struct {
+	headers     map[string]string
+	contentType string
+	body        []byte
+}
+
+// encoder turns events into transport messages
+type encoder struct {
+	format string
+	mode   string
+	prefix string // attribute header prefix for binary mode, e.g. "ce-"
+}
+
+// newEncoder validates format and mode for a transport using the given
+// binary-mode header prefix
+func newEncoder(format, mode, prefix string) (encoder, error) {
+	switch format {
+	case FormatJSON, "":
+		if mode != "" {
+			return encoder{}, fmt.Errorf("mode only applies to the %s format", FormatCloudEvents)
+		}
+		return encoder{format: FormatJSON}, nil
+	case FormatCloudEvents:
+		switch mode {
+		case ModeStructured, "":
+			return encoder{format: format, mode: ModeStructured}, nil
+		case ModeBinary:
+			return encoder{format: format, mode: mode, prefix: prefix}, nil
+		default:
+			return encoder{}, fmt.Errorf("unknown mode %q", mode)
+		}
+	default:
+		return encoder{}, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// jsonEvent is the plain JSON representation of an event
+type jsonEvent struct {
+	ID      string      `json:"id"`
+	Type    string      `json:"type"`
+	Source  string      `json:"source"`
+	Subject string      `json:"subject,omitempty"`
+	Time    string      `json:"time"`
+	Data    interface{} `json:"data"`
+}
+
+// cloudEvent is the CloudEvents 1.0 JSON event format used in structured
+// mode
+type cloudEvent struct {
+	SpecVersion     string      `json:"specversion"`
+	ID              string      `json:"id"`
+	Type            string      `json:"type"`
+	Source          string      `json:"source"`
+	Subject         string      `json:"subject,omitempty"`
+	Time            string      `json:"time"`
+	DataContentType string      `json:"datacontenttype"`
+	Data            interface{} `json:"data"`
+}
+
+// MarshalJSON encodes the event in the plain JSON format
+func (e Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonEvent{
+		ID:      e.ID,
+		Type:    e.Type,
+		Source:  e.Source,
+		Subject: e.Subject,
+		Time:    e.Time.UTC().Format(time.RFC3339Nano),
+		Data:    e.Data,
+	})
+}
+
+func (enc encoder) encode(e Event) (message, error) {
+	ts := e.Time.UTC().Format(time.RFC3339Nano)
+
+	switch {
+	case enc.format == FormatJSON:
+		body, err := json.Marshal(e)
+		return message{contentType: contentTypeJSON, body: body}, err
+
+	case enc.mode == ModeBinary:
+		// The body is the data alone; context attributes travel as headers
+		body, err := json.Marshal(e.Data)
+		headers := map[string]string{
+			enc.prefix + "specversion": specVersion,
+			enc.prefix + "id":          e.ID,
+			enc.prefix + "type":        e.Type,
+			enc.prefix + "source":      e.Source,
+			enc.prefix + "time":        ts,
+		}
+		if e.Subject != "" {
+			headers[enc.prefix+"subject"] = e.Subject
+		}
+		return message{headers: headers, contentType: contentTypeJSON, body: body}, err
+
+	default:
+		body, err := json.Marshal(cloudEvent{
+			SpecVersion:     specVersion,
+			ID:              e.ID,
+			Type:            e.Type,
+			Source:          e.Source,
+			Subject:         e.Subject,
+			Time:            ts,
+			DataContentType: contentTypeJSON,
+			Data:            e.Data,
+		})
+		return message{contentType: contentTypeCloudEvents, body: body}, err
+	}
+}
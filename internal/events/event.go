@@ -0,0 +1,110 @@
+// Package events publishes machine-readable match and action events to
+// external sinks such as webhooks, NATS and Kafka, and all of the
+// scheduler's events to in-process subscribers.
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// Event types emitted by the scheduler
+const (
+	TypeRuleMatched   = "io.gotsk.rule.matched"
+	TypeActionApplied = "io.gotsk.action.applied"
+	TypeLoopDetected  = "io.gotsk.loop.detected"
+	TypeRuleSuspended = "io.gotsk.rule.suspended"
+)
+
+// Event types only broadcast to subscribers, as sinks would otherwise
+// receive every fetched message and failure
+const (
+	TypeEmailFetched = "io.gotsk.email.fetched"
+	TypePollFailed   = "io.gotsk.poll.failed"
+
+	TypeCircuitOpened = "io.gotsk.circuit.opened"
+	TypeCircuitClosed = "io.gotsk.circuit.closed"
+)
+
+// Event is a single occurrence worth telling downstream systems about. Its
+// fields map one-to-one onto the CloudEvents context attributes.
+type Event struct {
+	ID      string
+	Type    string
+	Source  string // URI reference identifying the account
+	Subject string // Message the event is about
+	Time    time.Time
+	Data    interface{} // JSON-encodable payload
+}
+
+// MessageData describes the message, rule and action an event is about
+type MessageData struct {
+	Account   string    `json:"account"`
+	Mailbox   string    `json:"mailbox"`
+	UID       uint32    `json:"uid"`
+	MessageID string    `json:"message_id,omitempty"`
+	Subject   string    `json:"subject"`
+	From      string    `json:"from"`
+	Date      time.Time `json:"date"`
+	Rule      string    `json:"rule"`
+	Action    string    `json:"action"`
+	Label     string    `json:"label,omitempty"`
+}
+
+// ErrorData describes a failed poll
+type ErrorData struct {
+	Account string `json:"account"`
+	Error   string `json:"error"`
+	Attempt int    `json:"attempt"` // Consecutive failures so far
+}
+
+// Filter selects events by account and type. Zero fields match every
+// event.
+type Filter struct {
+	Account string
+	Types   []string
+}
+
+// Match reports whether the filter selects ev
+func (f Filter) Match(ev Event) bool {
+	if f.Account != "" && ev.Source != AccountSource(f.Account) {
+		return false
+	}
+	if len(f.Types) == 0 {
+		return true
+	}
+	for _, t := range f.Types {
+		if ev.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+// NewEvent creates an event with a fresh ID, stamped with the current time
+func NewEvent(eventType, accountID, subject string, data interface{}) Event {
+	return Event{
+		ID:      newID(),
+		Type:    eventType,
+		Source:  AccountSource(accountID),
+		Subject: subject,
+		Time:    time.Now().UTC(),
+		Data:    data,
+	}
+}
+
+// AccountSource returns the Source of events about an account
+func AccountSource(accountID string) string {
+	return "/go-tsk/accounts/" + accountID
+}
+
+// newID returns a random 128-bit hex identifier
+func newID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// crypto/rand only fails if the OS entropy source is broken
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
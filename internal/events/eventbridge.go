@@ -0,0 +1,101 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+// eventBridgeSource is the EventBridge source of every event; rules on the
+// bus match on it together with the detail type
+const eventBridgeSource = "go-tsk"
+
+// putEventsAPI is the part of the EventBridge client the sink uses
+type putEventsAPI interface {
+	PutEvents(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error)
+}
+
+// eventBridgeSink puts each event on an EventBridge bus. Credentials come
+// from the AWS default chain: environment, shared config, web identity
+// (EKS IRSA) or the instance/task role.
+type eventBridgeSink struct {
+	name    string
+	bus     string
+	enc     encoder
+	timeout time.Duration
+	client  putEventsAPI
+}
+
+func newEventBridgeSink(cfg config.SinkConfig, timeout time.Duration) (*eventBridgeSink, error) {
+	if cfg.Mode == ModeBinary {
+		return nil, fmt.Errorf("eventbridge sinks only support structured events")
+	}
+	enc, err := newEncoder(cfg.Format, cfg.Mode, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var opts []func(*awsconfig.LoadOptions) error
+	if cfg.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(cfg.Region))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
+	}
+
+	bus := cfg.Topic
+	if bus == "" {
+		bus = "default"
+	}
+	return &eventBridgeSink{
+		name:    cfg.Name,
+		bus:     bus,
+		enc:     enc,
+		timeout: timeout,
+		client:  eventbridge.NewFromConfig(awsCfg),
+	}, nil
+}
+
+func (s *eventBridgeSink) Name() string { return s.name }
+
+func (s *eventBridgeSink) Publish(ctx context.Context, e Event) error {
+	msg, err := s.enc.encode(e)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	out, err := s.client.PutEvents(ctx, &eventbridge.PutEventsInput{
+		Entries: []types.PutEventsRequestEntry{{
+			EventBusName: aws.String(s.bus),
+			Source:       aws.String(eventBridgeSource),
+			DetailType:   aws.String(e.Type),
+			Detail:       aws.String(string(msg.body)),
+			Time:         aws.Time(e.Time),
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// PutEvents succeeds as a call even when entries are rejected
+	if out.FailedEntryCount > 0 && len(out.Entries) > 0 {
+		entry := out.Entries[0]
+		return fmt.Errorf("event rejected: %s: %s", aws.ToString(entry.ErrorCode), aws.ToString(entry.ErrorMessage))
+	}
+	return nil
+}
+
+func (s *eventBridgeSink) Close() error { return nil }
@@ -0,0 +1,470 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/metrics"
+	"github.com/mshan/go-tsk/pkg/webhook"
+)
+
+func testEvent() Event {
+	return Event{
+		ID:      "abc123",
+		Type:    TypeRuleMatched,
+		Source:  "/go-tsk/accounts/primary",
+		Subject: "mid:<m1@example.com>",
+		Time:    time.Date(2024, 3, 5, 9, 15, 0, 0, time.UTC),
+		Data:    MessageData{Account: "primary", UID: 7, Subject: "Job opportunity", Rule: "subject contains job", Action: "label", Label: "imp"},
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name        string
+		format      string
+		mode        string
+		contentType string
+		headers     map[string]string
+		bodyKeys    []string
+		wantErr     bool
+	}{
+		{
+			name:        "plain json",
+			contentType: contentTypeJSON,
+			bodyKeys:    []string{"id", "type", "source", "subject", "time", "data"},
+		},
+		{
+			name:        "structured",
+			format:      FormatCloudEvents,
+			contentType: contentTypeCloudEvents,
+			bodyKeys:    []string{"specversion", "id", "type", "source", "subject", "time", "datacontenttype", "data"},
+		},
+		{
+			name:        "binary",
+			format:      FormatCloudEvents,
+			mode:        ModeBinary,
+			contentType: contentTypeJSON,
+			headers: map[string]string{
+				"ce-specversion": "1.0",
+				"ce-id":          "abc123",
+				"ce-type":        TypeRuleMatched,
+				"ce-source":      "/go-tsk/accounts/primary",
+				"ce-subject":     "mid:<m1@example.com>",
+				"ce-time":        "2024-03-05T09:15:00Z",
+			},
+			bodyKeys: []string{"account", "uid", "subject", "rule", "action", "label"},
+		},
+		{name: "mode without cloudevents", mode: ModeBinary, wantErr: true},
+		{name: "unknown mode", format: FormatCloudEvents, mode: "batched", wantErr: true},
+		{name: "unknown format", format: "avro", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enc, err := newEncoder(tt.format, tt.mode, "ce-")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newEncoder(%q, %q) error = %v; wantErr %v", tt.format, tt.mode, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			msg, err := enc.encode(testEvent())
+			if err != nil {
+				t.Fatalf("encode() error = %v", err)
+			}
+			if msg.contentType != tt.contentType {
+				t.Errorf("content type = %q; want %q", msg.contentType, tt.contentType)
+			}
+			if len(msg.headers) != len(tt.headers) {
+				t.Errorf("headers = %v; want %v", msg.headers, tt.headers)
+			}
+			for k, v := range tt.headers {
+				if msg.headers[k] != v {
+					t.Errorf("header %s = %q; want %q", k, msg.headers[k], v)
+				}
+			}
+
+			var body map[string]interface{}
+			if err := json.Unmarshal(msg.body, &body); err != nil {
+				t.Fatalf("body is not JSON: %v", err)
+			}
+			for _, key := range tt.bodyKeys {
+				if _, ok := body[key]; !ok {
+					t.Errorf("body missing %q: %s", key, msg.body)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPSinkBinary(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	emitter, err := New(config.EventsConfig{Sinks: []config.SinkConfig{
+		{Name: "hook", Type: "http", URL: srv.URL, Format: FormatCloudEvents, Mode: ModeBinary, Enabled: true},
+	}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer emitter.Close()
+
+	if err := emitter.Emit(context.Background(), testEvent()); err != nil {
+		t.Fatalf("Emit() error = %v", err)
+	}
+	if got.Header.Get("Ce-Specversion") != "1.0" || got.Header.Get("Ce-Type") != TypeRuleMatched {
+		t.Errorf("missing CloudEvents headers: %v", got.Header)
+	}
+	if ct := got.Header.Get("Content-Type"); ct != contentTypeJSON {
+		t.Errorf("Content-Type = %q; want %q", ct, contentTypeJSON)
+	}
+	if !strings.Contains(string(body), `"label":"imp"`) {
+		t.Errorf("body = %s; want event data", body)
+	}
+}
+
+func TestHTTPSinkRejected(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	emitter, err := New(config.EventsConfig{Sinks: []config.SinkConfig{
+		{Name: "hook", Type: "http", URL: srv.URL, Enabled: true},
+	}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := emitter.Emit(context.Background(), testEvent()); err == nil {
+		t.Error("Emit() error = nil; want error for 400 response")
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	received := make(chan string, 10)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- string(body)
+		<-release
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	emitter, err := New(config.EventsConfig{QueueSize: 1, Sinks: []config.SinkConfig{
+		{Name: "hook", Type: "http", URL: srv.URL, Enabled: true},
+	}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	sub, cancel := emitter.Subscribe(10)
+	defer cancel()
+	metrics.Set("queued", "event_failures", 0)
+	event := func(id string) Event {
+		ev := testEvent()
+		ev.ID, ev.Source = id, AccountSource("queued")
+		return ev
+	}
+
+	// The worker is held up by the first event, the second waits in the
+	// queue, and the third is dropped; none of them blocks
+	if err := emitter.Enqueue(event("first")); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the sink did not receive the first event")
+	}
+	if err := emitter.Enqueue(event("second")); err != nil {
+		t.Fatalf("Enqueue() of the second event error = %v", err)
+	}
+	if err := emitter.Enqueue(event("third")); err != ErrQueueFull {
+		t.Errorf("Enqueue() into a full queue error = %v; want ErrQueueFull", err)
+	}
+	if len(sub) != 3 {
+		t.Errorf("subscriber got %d events; want all 3", len(sub))
+	}
+
+	// Close publishes the queued event; the sink's failures are counted
+	close(release)
+	if err := emitter.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if len(received) != 1 || !strings.Contains(<-received, `"second"`) {
+		t.Error("the queued event was not published before Close returned")
+	}
+	if n := metrics.Get("queued", "event_failures"); n != 2 {
+		t.Errorf("event_failures = %d; want 2", n)
+	}
+	if err := emitter.Enqueue(event("fourth")); err != ErrQueueFull {
+		t.Errorf("Enqueue() after Close error = %v; want ErrQueueFull", err)
+	}
+}
+
+func TestHTTPSinkSigned(t *testing.T) {
+	var verifyErr error
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The receiver has only rolled the new secret out so far
+		v := webhook.Verifier{Secrets: []string{"new-secret"}}
+		_, verifyErr = v.VerifyRequest(r)
+	}))
+	defer srv.Close()
+
+	emitter, err := New(config.EventsConfig{Sinks: []config.SinkConfig{
+		{Name: "hook", Type: "http", URL: srv.URL, Secrets: []string{"old-secret", "new-secret"}, Enabled: true},
+	}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer emitter.Close()
+
+	if err := emitter.Emit(context.Background(), testEvent()); err != nil {
+		t.Fatalf("Emit() error = %v", err)
+	}
+	if verifyErr != nil {
+		t.Errorf("receiver failed to verify the request: %v", verifyErr)
+	}
+}
+
+// fakeNATS accepts one connection and records the first published message
+func fakeNATS(t *testing.T) (addr string, published <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	ch := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		io.WriteString(conn, "INFO {\"headers\":true}\r\n")
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			switch {
+			case len(fields) == 0:
+			case fields[0] == "PING":
+				io.WriteString(conn, "PONG\r\n")
+			case fields[0] == "PUB" || fields[0] == "HPUB":
+				n, _ := strconv.Atoi(fields[len(fields)-1])
+				payload := make([]byte, n+2)
+				if _, err := io.ReadFull(r, payload); err != nil {
+					return
+				}
+				ch <- line + string(payload[:n])
+			}
+		}
+	}()
+	return ln.Addr().String(), ch
+}
+
+func TestNATSSink(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		contains []string
+	}{
+		{"structured", ModeStructured, []string{"PUB tsk.events ", `"specversion":"1.0"`}},
+		{"binary", ModeBinary, []string{"HPUB tsk.events ", "NATS/1.0\r\n", "ce-type: " + TypeRuleMatched + "\r\n", `"uid":7`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, published := fakeNATS(t)
+			emitter, err := New(config.EventsConfig{Sinks: []config.SinkConfig{
+				{Name: "nats", Type: "nats", URL: "nats://" + addr, Topic: "tsk.events", Format: FormatCloudEvents, Mode: tt.mode, Enabled: true},
+			}})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer emitter.Close()
+
+			if err := emitter.Emit(context.Background(), testEvent()); err != nil {
+				t.Fatalf("Emit() error = %v", err)
+			}
+			msg := <-published
+			for _, want := range tt.contains {
+				if !strings.Contains(msg, want) {
+					t.Errorf("published message missing %q:\n%s", want, msg)
+				}
+			}
+		})
+	}
+}
+
+func TestNewSinkValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.SinkConfig
+	}{
+		{"unknown type", config.SinkConfig{Type: "smtp"}},
+		{"http without url", config.SinkConfig{Type: "http"}},
+		{"http empty secret", config.SinkConfig{Type: "http", URL: "http://localhost", Secrets: []string{""}}},
+		{"nats wrong scheme", config.SinkConfig{Type: "nats", URL: "http://localhost", Topic: "x"}},
+		{"nats without subject", config.SinkConfig{Type: "nats", URL: "nats://localhost"}},
+		{"kafka without brokers", config.SinkConfig{Type: "kafka", Topic: "x"}},
+		{"kafka without topic", config.SinkConfig{Type: "kafka", Brokers: []string{"localhost:9092"}}},
+		{"pubsub short topic", config.SinkConfig{Type: "pubsub", Topic: "events"}},
+		{"eventbridge binary", config.SinkConfig{Type: "eventbridge", Format: FormatCloudEvents, Mode: ModeBinary}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Enabled = true
+			if _, err := New(config.EventsConfig{Sinks: []config.SinkConfig{tt.cfg}}); err == nil {
+				t.Errorf("New(%+v) error = nil; want error", tt.cfg)
+			}
+		})
+	}
+}
+
+func TestPubSubSink(t *testing.T) {
+	var path string
+	var req struct {
+		Messages []pubSubMessage `json:"messages"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&req)
+		io.WriteString(w, `{"messageIds":["1"]}`)
+	}))
+	defer srv.Close()
+
+	enc, _ := newEncoder(FormatCloudEvents, ModeBinary, "ce-")
+	sink := &pubSubSink{name: "gcp", topic: "projects/p/topics/t", enc: enc, endpoint: srv.URL + "/v1/", client: srv.Client()}
+	if err := sink.Publish(context.Background(), testEvent()); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	if path != "/v1/projects/p/topics/t:publish" {
+		t.Errorf("request path = %q", path)
+	}
+	if len(req.Messages) != 1 {
+		t.Fatalf("got %d messages; want 1", len(req.Messages))
+	}
+	msg := req.Messages[0]
+	if msg.Attributes["ce-type"] != TypeRuleMatched || msg.Attributes["content-type"] != contentTypeJSON {
+		t.Errorf("attributes = %v", msg.Attributes)
+	}
+	data, err := base64.StdEncoding.DecodeString(msg.Data)
+	if err != nil || !strings.Contains(string(data), `"uid":7`) {
+		t.Errorf("data = %q (%v); want event data", data, err)
+	}
+}
+
+// fakePutEvents records PutEvents calls and fails entries on request
+type fakePutEvents struct {
+	input  *eventbridge.PutEventsInput
+	reject bool
+}
+
+func (f *fakePutEvents) PutEvents(ctx context.Context, in *eventbridge.PutEventsInput, _ ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
+	f.input = in
+	if f.reject {
+		return &eventbridge.PutEventsOutput{
+			FailedEntryCount: 1,
+			Entries:          []types.PutEventsResultEntry{{ErrorCode: aws.String("AccessDenied"), ErrorMessage: aws.String("no")}},
+		}, nil
+	}
+	return &eventbridge.PutEventsOutput{Entries: []types.PutEventsResultEntry{{EventId: aws.String("1")}}}, nil
+}
+
+func TestEventBridgeSink(t *testing.T) {
+	tests := []struct {
+		name    string
+		reject  bool
+		wantErr bool
+	}{
+		{"accepted", false, false},
+		{"entry rejected", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &fakePutEvents{reject: tt.reject}
+			enc, _ := newEncoder(FormatCloudEvents, "", "")
+			sink := &eventBridgeSink{name: "aws", bus: "tsk", enc: enc, timeout: time.Second, client: api}
+
+			err := sink.Publish(context.Background(), testEvent())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Publish() error = %v; wantErr %v", err, tt.wantErr)
+			}
+
+			entry := api.input.Entries[0]
+			if aws.ToString(entry.EventBusName) != "tsk" || aws.ToString(entry.DetailType) != TypeRuleMatched ||
+				aws.ToString(entry.Source) != eventBridgeSource {
+				t.Errorf("entry = %+v", entry)
+			}
+			if !strings.Contains(aws.ToString(entry.Detail), `"specversion":"1.0"`) {
+				t.Errorf("detail = %s; want a structured CloudEvent", aws.ToString(entry.Detail))
+			}
+		})
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	e, err := New(config.EventsConfig{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ctx := context.Background()
+
+	events, cancel := e.Subscribe(1)
+	slow, cancelSlow := e.Subscribe(0)
+	defer cancelSlow()
+
+	// A subscriber that can't keep up doesn't block Emit
+	if err := e.Emit(ctx, testEvent()); err != nil {
+		t.Fatalf("Emit() error = %v", err)
+	}
+	if got := <-events; got.ID != "abc123" {
+		t.Errorf("received event %q; want abc123", got.ID)
+	}
+	select {
+	case ev := <-slow:
+		t.Errorf("unbuffered subscriber received %q; want it dropped", ev.ID)
+	default:
+	}
+
+	cancel()
+	cancel()
+	if err := e.Emit(ctx, testEvent()); err != nil {
+		t.Fatalf("Emit() after cancel error = %v", err)
+	}
+	if _, ok := <-events; ok {
+		t.Error("channel still open after cancel")
+	}
+}
@@ -0,0 +1,86 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/pkg/webhook"
+)
+
+// httpSink POSTs each event to a URL using the CloudEvents HTTP binding,
+// signing requests when secrets are configured
+type httpSink struct {
+	name   string
+	url    string
+	enc    encoder
+	signer *webhook.Signer // nil when requests are not signed
+	client *http.Client
+}
+
+func newHTTPSink(cfg config.SinkConfig, timeout time.Duration) (*httpSink, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid HTTP URL %q", cfg.URL)
+	}
+	enc, err := newEncoder(cfg.Format, cfg.Mode, "ce-")
+	if err != nil {
+		return nil, err
+	}
+	var signer *webhook.Signer
+	if len(cfg.Secrets) > 0 {
+		if signer, err = webhook.NewSigner(cfg.Secrets...); err != nil {
+			return nil, err
+		}
+	}
+	return &httpSink{
+		name:   cfg.Name,
+		url:    cfg.URL,
+		enc:    enc,
+		signer: signer,
+		client: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (s *httpSink) Name() string { return s.name }
+
+func (s *httpSink) Publish(ctx context.Context, e Event) error {
+	msg, err := s.enc.encode(e)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(msg.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", msg.contentType)
+	for k, v := range msg.headers {
+		req.Header.Set(k, v)
+	}
+	if s.signer != nil {
+		s.signer.Sign(req.Header, msg.body, time.Now())
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *httpSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
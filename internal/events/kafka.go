@@ -0,0 +1,67 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+// kafkaSink produces each event as a record using the CloudEvents Kafka
+// binding. Records are keyed by source so one account's events stay ordered.
+type kafkaSink struct {
+	name   string
+	enc    encoder
+	writer *kafka.Writer
+}
+
+func newKafkaSink(cfg config.SinkConfig, timeout time.Duration) (*kafkaSink, error) {
+	if len(cfg.Brokers) == 0 {
+		return nil, fmt.Errorf("kafka sink requires at least one broker")
+	}
+	if cfg.Topic == "" {
+		return nil, fmt.Errorf("kafka sink requires a topic")
+	}
+	enc, err := newEncoder(cfg.Format, cfg.Mode, "ce_")
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaSink{
+		name: cfg.Name,
+		enc:  enc,
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(cfg.Brokers...),
+			Topic:        cfg.Topic,
+			Balancer:     &kafka.Hash{},
+			WriteTimeout: timeout,
+			RequiredAcks: kafka.RequireAll,
+		},
+	}, nil
+}
+
+func (s *kafkaSink) Name() string { return s.name }
+
+func (s *kafkaSink) Publish(ctx context.Context, e Event) error {
+	msg, err := s.enc.encode(e)
+	if err != nil {
+		return err
+	}
+
+	headers := []kafka.Header{{Key: "content-type", Value: []byte(msg.contentType)}}
+	for k, v := range msg.headers {
+		headers = append(headers, kafka.Header{Key: k, Value: []byte(v)})
+	}
+
+	return s.writer.WriteMessages(ctx, kafka.Message{
+		Key:     []byte(e.Source),
+		Value:   msg.body,
+		Headers: headers,
+	})
+}
+
+func (s *kafkaSink) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,213 @@
+package events
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+// natsSink publishes each event to a subject using the CloudEvents NATS
+// binding. It speaks the NATS text protocol directly and confirms every
+// publish with a PING/PONG round trip, so a nil error means the server
+// accepted the message.
+type natsSink struct {
+	name    string
+	addr    string
+	user    *url.Userinfo
+	subject string
+	enc     encoder
+	timeout time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newNATSSink(cfg config.SinkConfig, timeout time.Duration) (*natsSink, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || u.Scheme != "nats" || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid NATS URL %q", cfg.URL)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	if cfg.Topic == "" || strings.ContainsAny(cfg.Topic, " \t\r\n") {
+		return nil, fmt.Errorf("invalid NATS subject %q", cfg.Topic)
+	}
+	enc, err := newEncoder(cfg.Format, cfg.Mode, "ce-")
+	if err != nil {
+		return nil, err
+	}
+	return &natsSink{
+		name:    cfg.Name,
+		addr:    addr,
+		user:    u.User,
+		subject: cfg.Topic,
+		enc:     enc,
+		timeout: timeout,
+	}, nil
+}
+
+func (s *natsSink) Name() string { return s.name }
+
+func (s *natsSink) Publish(ctx context.Context, e Event) error {
+	msg, err := s.enc.encode(e)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		if err := s.connect(ctx); err != nil {
+			return fmt.Errorf("failed to connect to %s: %w", s.addr, err)
+		}
+	}
+	if err := s.publish(ctx, msg); err != nil {
+		// Start over with a fresh connection next time
+		s.conn.Close()
+		s.conn, s.r = nil, nil
+		return err
+	}
+	return nil
+}
+
+// deadline returns the earlier of ctx's deadline and the sink timeout
+func (s *natsSink) deadline(ctx context.Context) time.Time {
+	d := time.Now().Add(s.timeout)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(d) {
+		d = dl
+	}
+	return d
+}
+
+// connect dials the server, reads its INFO and sends CONNECT. Protocol
+// errors from CONNECT surface on the first publish's PING.
+func (s *natsSink) connect(ctx context.Context) error {
+	d := net.Dialer{Timeout: s.timeout}
+	conn, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(s.deadline(ctx))
+
+	r := bufio.NewReader(conn)
+	line, err := readLine(r)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("unexpected greeting %q", line)
+	}
+
+	opts := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"headers":  true,
+		"name":     "go-tsk",
+		"lang":     "go",
+	}
+	if s.user != nil {
+		opts["user"] = s.user.Username()
+		if pass, ok := s.user.Password(); ok {
+			opts["pass"] = pass
+		}
+	}
+	connect, err := json.Marshal(opts)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\n", connect); err != nil {
+		conn.Close()
+		return err
+	}
+
+	s.conn, s.r = conn, r
+	return nil
+}
+
+// publish sends one message followed by a PING and waits for the PONG
+func (s *natsSink) publish(ctx context.Context, msg message) error {
+	s.conn.SetDeadline(s.deadline(ctx))
+
+	var buf bytes.Buffer
+	if len(msg.headers) == 0 {
+		fmt.Fprintf(&buf, "PUB %s %d\r\n", s.subject, len(msg.body))
+	} else {
+		var hdr bytes.Buffer
+		hdr.WriteString("NATS/1.0\r\n")
+		fmt.Fprintf(&hdr, "Content-Type: %s\r\n", msg.contentType)
+		keys := make([]string, 0, len(msg.headers))
+		for k := range msg.headers {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&hdr, "%s: %s\r\n", k, msg.headers[k])
+		}
+		hdr.WriteString("\r\n")
+
+		fmt.Fprintf(&buf, "HPUB %s %d %d\r\n", s.subject, hdr.Len(), hdr.Len()+len(msg.body))
+		buf.Write(hdr.Bytes())
+	}
+	buf.Write(msg.body)
+	buf.WriteString("\r\nPING\r\n")
+
+	if _, err := s.conn.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	for {
+		line, err := readLine(s.r)
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := s.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("server error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+		// +OK and INFO updates need no reply
+	}
+}
+
+func (s *natsSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn, s.r = nil, nil
+	return err
+}
+
+// readLine reads one CRLF-terminated protocol line
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,113 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"golang.org/x/oauth2/google"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+// pubSubEndpoint is the Pub/Sub REST API root
+const pubSubEndpoint = "https://pubsub.googleapis.com/v1/"
+
+// pubSubTopic matches fully qualified topic names
+var pubSubTopic = regexp.MustCompile(`^projects/[^/]+/topics/[^/]+$`)
+
+// pubSubSink publishes each event to a Pub/Sub topic using the CloudEvents
+// Pub/Sub binding. Credentials come from Application Default Credentials,
+// so workload identity and attached service accounts work without keys.
+type pubSubSink struct {
+	name     string
+	topic    string
+	enc      encoder
+	endpoint string
+	client   *http.Client
+}
+
+func newPubSubSink(cfg config.SinkConfig, timeout time.Duration) (*pubSubSink, error) {
+	if !pubSubTopic.MatchString(cfg.Topic) {
+		return nil, fmt.Errorf("pubsub topic must look like projects/PROJECT/topics/TOPIC, got %q", cfg.Topic)
+	}
+	enc, err := newEncoder(cfg.Format, cfg.Mode, "ce-")
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	client, err := google.DefaultClient(ctx, "https://www.googleapis.com/auth/pubsub")
+	if err != nil {
+		return nil, fmt.Errorf("failed to find Google credentials: %w", err)
+	}
+	client.Timeout = timeout
+
+	return &pubSubSink{
+		name:     cfg.Name,
+		topic:    cfg.Topic,
+		enc:      enc,
+		endpoint: pubSubEndpoint,
+		client:   client,
+	}, nil
+}
+
+func (s *pubSubSink) Name() string { return s.name }
+
+// pubSubMessage is a message in a topics.publish request
+type pubSubMessage struct {
+	Data       string            `json:"data"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+func (s *pubSubSink) Publish(ctx context.Context, e Event) error {
+	msg, err := s.enc.encode(e)
+	if err != nil {
+		return err
+	}
+
+	attrs := map[string]string{"content-type": msg.contentType}
+	for k, v := range msg.headers {
+		attrs[k] = v
+	}
+	req := struct {
+		Messages []pubSubMessage `json:"messages"`
+	}{
+		Messages: []pubSubMessage{{Data: base64.StdEncoding.EncodeToString(msg.body), Attributes: attrs}},
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint+s.topic+":publish", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, bytes.TrimSpace(detail))
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	return nil
+}
+
+func (s *pubSubSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,248 @@
+package gmailfilters
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/rules"
+)
+
+// IMAP flags the system labels of filters become in rules
+const (
+	flagSeen    = `\Seen`
+	flagFlagged = `\Flagged`
+	flagDeleted = `\Deleted`
+)
+
+// Issue is a filter or rule, or part of one, that could not be converted
+type Issue struct {
+	Index int // Of the filter or rule
+	Msg   string
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("%d: %s", i.Index, i.Msg)
+}
+
+// ToRules converts filters to rules. It supports
+//
+//   - the From and Subject criteria, as contained text
+//   - size criteria
+//   - Has attachment, as HasAttachment
+//   - applying labels, starring, marking as read and trashing, as the
+//     labels \Flagged, \Seen and \Deleted
+//
+// A filter with any other criterion is left out, as the rule would match
+// more than the filter did; other actions are left out of the rule. Both
+// are reported as issues. Gmail matches Subject and From by words, which
+// contained text approximates.
+func ToRules(filters []email.GmailFilter) ([]config.Rule, []Issue) {
+	var converted []config.Rule
+	var issues []Issue
+	for i, f := range filters {
+		issue := func(format string, args ...interface{}) {
+			issues = append(issues, Issue{Index: i, Msg: fmt.Sprintf(format, args...)})
+		}
+
+		var unsupported []string
+		if f.To != "" {
+			unsupported = append(unsupported, "To")
+		}
+		if f.Query != "" {
+			unsupported = append(unsupported, "Has the words")
+		}
+		if f.NegatedQuery != "" {
+			unsupported = append(unsupported, "Doesn't have")
+		}
+		unsupported = append(unsupported, f.Unknown...)
+		if len(unsupported) > 0 {
+			issue("skipped, %s not supported", strings.Join(unsupported, ", "))
+			continue
+		}
+
+		var rule config.Rule
+		var conds []string
+		rule.SubjectContains = f.Subject
+		rule.HasAttachment = f.HasAttachment
+		if f.From != "" {
+			conds = append(conds, rules.ContainsCondition("from", f.From))
+		}
+		if f.Size > 0 {
+			op := ">"
+			if f.SizeComparison == "smaller" {
+				op = "<"
+			}
+			conds = append(conds, fmt.Sprintf("email.size %s %d", op, f.Size))
+		}
+		rule.Condition = strings.Join(conds, " && ")
+
+		var acts []config.RuleAction
+		label := func(l string) {
+			acts = append(acts, config.RuleAction{Action: "label", Label: l})
+		}
+		for _, l := range f.AddLabels {
+			switch {
+			case l == labelStarred:
+				label(flagFlagged)
+			case l == labelTrash:
+				label(flagDeleted)
+			case isSystemLabel(l):
+				issue("adding %s not supported", l)
+			default:
+				label(l)
+			}
+		}
+		for _, l := range f.RemoveLabels {
+			if l == labelUnread {
+				label(flagSeen)
+			} else {
+				issue("removing %s not supported", l)
+			}
+		}
+		if f.Forward != "" {
+			issue("forwarding not supported")
+		}
+		switch len(acts) {
+		case 0:
+			issue("skipped, no supported actions")
+			continue
+		case 1:
+			rule.Action, rule.Label = acts[0].Action, acts[0].Label
+		default:
+			rule.Actions = acts
+		}
+		converted = append(converted, rule)
+	}
+	return converted, issues
+}
+
+// isSystemLabel reports whether a label is one of Gmail's, such as
+// IMPORTANT, CATEGORY_SOCIAL or, in exports, ^smartlabel_social
+func isSystemLabel(label string) bool {
+	switch label {
+	case labelInbox, labelUnread, labelStarred, labelTrash, labelSpam, labelImportant:
+		return true
+	}
+	return strings.HasPrefix(label, "CATEGORY_") || strings.HasPrefix(label, "^")
+}
+
+// FromRules converts rules to filters, the reverse of ToRules. A rule
+// whose match cannot be expressed as a filter is left out, as are actions
+// other than labels. Both are reported as issues.
+func FromRules(ruleList []config.Rule) ([]email.GmailFilter, []Issue) {
+	var filters []email.GmailFilter
+	var issues []Issue
+	for i, rule := range ruleList {
+		issue := func(format string, args ...interface{}) {
+			issues = append(issues, Issue{Index: i, Msg: fmt.Sprintf(format, args...)})
+		}
+
+		f, err := criteria(rule)
+		if err != nil {
+			issue("skipped, %v", err)
+			continue
+		}
+		for _, step := range config.Steps(rule) {
+			switch step.Action {
+			case "label", "":
+				switch step.Label {
+				case flagSeen:
+					f.RemoveLabels = append(f.RemoveLabels, labelUnread)
+				case flagFlagged:
+					f.AddLabels = append(f.AddLabels, labelStarred)
+				case flagDeleted:
+					f.AddLabels = append(f.AddLabels, labelTrash)
+				default:
+					f.AddLabels = append(f.AddLabels, step.Label)
+				}
+			default:
+				issue("action %s not supported", step.Action)
+			}
+		}
+		if len(f.AddLabels) == 0 && len(f.RemoveLabels) == 0 {
+			issue("skipped, no supported actions")
+			continue
+		}
+		filters = append(filters, f)
+	}
+	return filters, issues
+}
+
+// criteria returns a filter matching what rule does
+func criteria(rule config.Rule) (email.GmailFilter, error) {
+	var f email.GmailFilter
+	switch {
+	case rule.Script != "":
+		return f, fmt.Errorf("scripts not supported")
+	case rule.Plugin != "":
+		return f, fmt.Errorf("plugins not supported")
+	case rule.Mailbox != "" && rule.Mailbox != email.Inbox:
+		return f, fmt.Errorf("mailbox %s not supported", rule.Mailbox)
+	case rule.SampleRate > 0 || rule.SampleEvery > 0:
+		return f, fmt.Errorf("sampling not supported")
+	case rule.AttachmentNameMatches != "" || len(rule.AttachmentTypeIn) > 0 || rule.AttachmentLargerThan > 0:
+		return f, fmt.Errorf("attachment name, type and size conditions not supported")
+	case rule.OlderThan > 0 || rule.NewerThan > 0:
+		return f, fmt.Errorf("age conditions not supported")
+	case len(rule.HeaderMatches) > 0:
+		return f, fmt.Errorf("header conditions not supported")
+	case rule.ToContains != "" || rule.CcContains != "":
+		return f, fmt.Errorf("recipient conditions not supported")
+	case rule.ThreadAlreadyLabeled != "" || rule.ApplyToThread:
+		return f, fmt.Errorf("thread options not supported")
+	case rule.FromInList != "" || rule.FromNotInList != "":
+		return f, fmt.Errorf("address list conditions not supported")
+	case rule.LargerThan > 0 && rule.SmallerThan > 0:
+		return f, fmt.Errorf("size ranges not supported")
+	}
+
+	f.Subject = rule.SubjectContains
+	f.HasAttachment = rule.HasAttachment
+	switch {
+	case rule.LargerThan > 0:
+		f.Size, f.SizeComparison = int(rule.LargerThan), "larger"
+	case rule.SmallerThan > 0:
+		f.Size, f.SizeComparison = int(rule.SmallerThan), "smaller"
+	}
+	if rule.Condition != "" {
+		for _, term := range strings.Split(rule.Condition, " && ") {
+			if err := addTerm(&f, term); err != nil {
+				return f, err
+			}
+		}
+	}
+	if f.Subject == "" && f.From == "" && f.Size == 0 && !f.HasAttachment {
+		return f, fmt.Errorf("filters need criteria")
+	}
+	return f, nil
+}
+
+// addTerm adds a term of a condition ToRules wrote to f
+func addTerm(f *email.GmailFilter, term string) error {
+	if field, value, ok := rules.ParseContainsCondition(term); ok {
+		switch {
+		case field == "from" && f.From == "":
+			f.From = value
+			return nil
+		case field == "subject" && f.Subject == "":
+			f.Subject = value
+			return nil
+		}
+	}
+	if rest := strings.TrimPrefix(term, "email.size "); rest != term && f.Size == 0 {
+		op, n, _ := strings.Cut(rest, " ")
+		size, err := strconv.Atoi(n)
+		if err == nil && size > 0 && (op == ">" || op == "<") {
+			f.Size = size
+			f.SizeComparison = "larger"
+			if op == "<" {
+				f.SizeComparison = "smaller"
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("condition %s not supported", term)
+}
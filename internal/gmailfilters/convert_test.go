@@ -0,0 +1,84 @@
+package gmailfilters
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+func issueIndexes(issues []Issue) []int {
+	var indexes []int
+	for _, issue := range issues {
+		indexes = append(indexes, issue.Index)
+	}
+	return indexes
+}
+
+func TestToRules(t *testing.T) {
+	got, issues := ToRules(exported)
+	want := []config.Rule{
+		{
+			SubjectContains: "invoice",
+			Condition:       `email.from.matches("(?i)billing@example\\.com")`,
+			Actions:         []config.RuleAction{{Action: "label", Label: "Bills"}, {Action: "label", Label: `\Seen`}},
+		},
+		{
+			Condition: `email.from.matches("(?i)boss@example\\.com") && email.size < 2048`,
+			Action:    "label",
+			Label:     `\Flagged`,
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ToRules() = %+v; want %+v", got, want)
+	}
+	// Archiving, the unsupported search of the second filter and
+	// forwarding
+	if indexes := issueIndexes(issues); !reflect.DeepEqual(indexes, []int{0, 1, 2}) {
+		t.Errorf("issues = %v", issues)
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.Poll.Rules = got
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("converted rules are invalid: %v", err)
+	}
+}
+
+func TestFromRules(t *testing.T) {
+	ruleList := []config.Rule{
+		{SubjectContains: "invoice", Condition: `email.from.matches("(?i)billing@example\\.com")`,
+			Actions: []config.RuleAction{{Action: "label", Label: "Bills"}, {Action: "ntfy"}, {Label: `\Seen`}}},
+		{Condition: `email.size > 1048576`, Label: `\Deleted`},
+		{SubjectContains: "x", Condition: `email.from.endsWith("@example.com")`, Label: "X"},
+		{Label: "Everything"},
+		{SubjectContains: "y", Action: "notify"},
+		{SubjectContains: "z", Mailbox: "Lists/*", Label: "Z"},
+		{HasAttachment: true, Label: "Attachments"},
+		{AttachmentNameMatches: "*.pdf", Label: "PDFs"},
+		{LargerThan: 5000000, Label: "Large"},
+		{OlderThan: 720 * time.Hour, Label: "Old"},
+	}
+	got, issues := FromRules(ruleList)
+	want := []email.GmailFilter{
+		{Subject: "invoice", From: "billing@example.com", AddLabels: []string{"Bills"}, RemoveLabels: []string{"UNREAD"}},
+		{Size: 1048576, SizeComparison: "larger", AddLabels: []string{"TRASH"}},
+		{HasAttachment: true, AddLabels: []string{"Attachments"}},
+		{Size: 5000000, SizeComparison: "larger", AddLabels: []string{"Large"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FromRules() = %+v; want %+v", got, want)
+	}
+	if indexes := issueIndexes(issues); !reflect.DeepEqual(indexes, []int{0, 2, 3, 4, 4, 5, 7, 9}) {
+		t.Errorf("issues = %v", issues)
+	}
+
+	// Converting back gives the rules without their unsupported parts
+	back, _ := ToRules(got)
+	if len(back) != 4 || back[0].SubjectContains != "invoice" || back[0].Condition != ruleList[0].Condition ||
+		back[1].Condition != ruleList[1].Condition || !back[2].HasAttachment {
+		t.Errorf("ToRules(FromRules()) = %+v", back)
+	}
+}
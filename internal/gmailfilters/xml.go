@@ -0,0 +1,246 @@
+// Package gmailfilters converts between Gmail filters and go-tsk rules, to
+// ease moving filters from Gmail to go-tsk and back. Filters come from the
+// XML file Gmail exports under Settings > Filters, or from the Gmail API.
+package gmailfilters
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+// XML namespaces of the export, an Atom feed
+const (
+	atomNS = "http://www.w3.org/2005/Atom"
+	appsNS = "http://schemas.google.com/apps/2006"
+)
+
+// Gmail system labels, as filter actions refer to them
+const (
+	labelInbox     = "INBOX"
+	labelUnread    = "UNREAD"
+	labelStarred   = "STARRED"
+	labelTrash     = "TRASH"
+	labelSpam      = "SPAM"
+	labelImportant = "IMPORTANT"
+)
+
+// flagProperties are the boolean properties of the export that add or
+// remove a system label
+var flagProperties = map[string]struct {
+	label  string
+	remove bool
+}{
+	"shouldArchive":               {labelInbox, true},
+	"shouldMarkAsRead":            {labelUnread, true},
+	"shouldStar":                  {labelStarred, false},
+	"shouldTrash":                 {labelTrash, false},
+	"shouldNeverSpam":             {labelSpam, true},
+	"shouldAlwaysMarkAsImportant": {labelImportant, false},
+	"shouldNeverMarkAsImportant":  {labelImportant, true},
+}
+
+// sizeUnits are the units of the size property in bytes
+var sizeUnits = map[string]int{"s_sb": 1, "s_skb": 1 << 10, "s_smb": 1 << 20}
+
+type property struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// ReadXML reads the filters of a Gmail export
+func ReadXML(r io.Reader) ([]email.GmailFilter, error) {
+	var feed struct {
+		XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+		Entries []struct {
+			Properties []property `xml:"http://schemas.google.com/apps/2006 property"`
+		} `xml:"entry"`
+	}
+	if err := xml.NewDecoder(r).Decode(&feed); err != nil {
+		return nil, fmt.Errorf("invalid filter export: %w", err)
+	}
+
+	filters := make([]email.GmailFilter, len(feed.Entries))
+	for i, entry := range feed.Entries {
+		f := &filters[i]
+		size, unit := 0, 1
+		for _, p := range entry.Properties {
+			switch p.Name {
+			case "from":
+				f.From = p.Value
+			case "to":
+				f.To = p.Value
+			case "subject":
+				f.Subject = p.Value
+			case "hasTheWord":
+				f.Query = p.Value
+			case "doesNotHaveTheWord":
+				f.NegatedQuery = p.Value
+			case "hasAttachment":
+				f.HasAttachment = p.Value == "true"
+			case "size":
+				n, err := strconv.Atoi(p.Value)
+				if err != nil {
+					return nil, fmt.Errorf("filter %d: invalid size %q", i, p.Value)
+				}
+				size = n
+			case "sizeUnit":
+				u, ok := sizeUnits[p.Value]
+				if !ok {
+					return nil, fmt.Errorf("filter %d: unknown size unit %q", i, p.Value)
+				}
+				unit = u
+			case "sizeOperator":
+				f.SizeComparison = "larger"
+				if p.Value == "s_ss" {
+					f.SizeComparison = "smaller"
+				}
+			case "label":
+				f.AddLabels = append(f.AddLabels, p.Value)
+			case "forwardTo":
+				f.Forward = p.Value
+			case "excludeChats":
+				// Chats are not mail go-tsk sees
+			default:
+				flag, ok := flagProperties[p.Name]
+				switch {
+				case !ok:
+					f.Unknown = append(f.Unknown, p.Name)
+				case p.Value != "true":
+				case flag.remove:
+					f.RemoveLabels = append(f.RemoveLabels, flag.label)
+				default:
+					f.AddLabels = append(f.AddLabels, flag.label)
+				}
+			}
+		}
+		// Exports name an operator and unit even without a size
+		if f.Size = size * unit; f.Size == 0 {
+			f.SizeComparison = ""
+		}
+	}
+	return filters, nil
+}
+
+// WriteXML writes filters as a Gmail export, to be imported under
+// Settings > Filters. As an exported filter applies at most one user
+// label, a filter with several becomes one per label. updated dates the
+// filters.
+func WriteXML(w io.Writer, filters []email.GmailFilter, updated time.Time) error {
+	type entry struct {
+		Category struct {
+			Term string `xml:"term,attr"`
+		} `xml:"category"`
+		Title      string     `xml:"title"`
+		ID         string     `xml:"id"`
+		Updated    string     `xml:"updated"`
+		Content    string     `xml:"content"`
+		Properties []property `xml:"apps:property"`
+	}
+	feed := struct {
+		XMLName xml.Name `xml:"feed"`
+		Xmlns   string   `xml:"xmlns,attr"`
+		Apps    string   `xml:"xmlns:apps,attr"`
+		Title   string   `xml:"title"`
+		ID      string   `xml:"id"`
+		Updated string   `xml:"updated"`
+		Entries []entry  `xml:"entry"`
+	}{Xmlns: atomNS, Apps: appsNS, Title: "Mail Filters"}
+
+	stamp := updated.UTC().Format(time.RFC3339)
+	id := updated.UnixNano() / int64(time.Millisecond)
+	feed.ID = fmt.Sprintf("tag:mail.google.com,2008:filters:%d", id)
+	feed.Updated = stamp
+	for _, f := range filters {
+		for _, props := range properties(f) {
+			e := entry{Title: "Mail Filter", Updated: stamp, Properties: props}
+			e.Category.Term = "filter"
+			e.ID = fmt.Sprintf("tag:mail.google.com,2008:filter:%d", id+int64(len(feed.Entries)))
+			feed.Entries = append(feed.Entries, e)
+		}
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	if err := enc.Encode(feed); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// properties returns the properties of the entries of a filter, one entry
+// per user label
+func properties(f email.GmailFilter) [][]property {
+	var criteria []property
+	add := func(name, value string) {
+		if value != "" {
+			criteria = append(criteria, property{name, value})
+		}
+	}
+	add("from", f.From)
+	add("to", f.To)
+	add("subject", f.Subject)
+	add("hasTheWord", f.Query)
+	add("doesNotHaveTheWord", f.NegatedQuery)
+	if f.HasAttachment {
+		add("hasAttachment", "true")
+	}
+	if f.Size > 0 {
+		op := "s_sl"
+		if f.SizeComparison == "smaller" {
+			op = "s_ss"
+		}
+		add("size", strconv.Itoa(f.Size))
+		add("sizeOperator", op)
+		add("sizeUnit", "s_sb")
+	}
+
+	actions := []property{}
+	var labels []string
+	for _, label := range f.AddLabels {
+		if name, ok := flagProperty(label, false); ok {
+			actions = append(actions, property{name, "true"})
+		} else {
+			labels = append(labels, label)
+		}
+	}
+	for _, label := range f.RemoveLabels {
+		if name, ok := flagProperty(label, true); ok {
+			actions = append(actions, property{name, "true"})
+		}
+	}
+	if f.Forward != "" {
+		actions = append(actions, property{"forwardTo", f.Forward})
+	}
+
+	if len(labels) == 0 {
+		return [][]property{append(criteria, actions...)}
+	}
+	entries := make([][]property, len(labels))
+	for i, label := range labels {
+		props := append(append([]property{}, criteria...), property{"label", label})
+		if i == 0 {
+			props = append(props, actions...)
+		}
+		entries[i] = props
+	}
+	return entries
+}
+
+// flagProperty returns the property adding or removing a system label
+func flagProperty(label string, remove bool) (string, bool) {
+	for name, flag := range flagProperties {
+		if flag.label == label && flag.remove == remove {
+			return name, true
+		}
+	}
+	return "", false
+}
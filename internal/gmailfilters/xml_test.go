@@ -0,0 +1,88 @@
+package gmailfilters
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+// exported are the filters of testdata/mailFilters.xml
+var exported = []email.GmailFilter{
+	{
+		From:         "billing@example.com",
+		Subject:      "invoice",
+		AddLabels:    []string{"Bills"},
+		RemoveLabels: []string{"UNREAD", "INBOX"},
+	},
+	{
+		Query:     "list:announce.example.com",
+		AddLabels: []string{"TRASH"},
+		Unknown:   []string{"smartLabelToApply"},
+	},
+	{
+		From:           "boss@example.com",
+		Size:           2048,
+		SizeComparison: "smaller",
+		AddLabels:      []string{"STARRED"},
+		Forward:        "me@example.org",
+	},
+}
+
+func TestReadXML(t *testing.T) {
+	f, err := os.Open("testdata/mailFilters.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	filters, err := ReadXML(f)
+	if err != nil {
+		t.Fatalf("ReadXML: %v", err)
+	}
+	if !reflect.DeepEqual(filters, exported) {
+		t.Errorf("ReadXML() = %+v; want %+v", filters, exported)
+	}
+
+	if _, err := ReadXML(strings.NewReader("<rss></rss>")); err == nil {
+		t.Error("ReadXML of another document succeeded")
+	}
+}
+
+func TestWriteXML(t *testing.T) {
+	filters := []email.GmailFilter{
+		{Subject: "invoice", AddLabels: []string{"Bills", "STARRED", "Finance"}, RemoveLabels: []string{"UNREAD"}},
+		{From: "boss@example.com", Size: 2048, SizeComparison: "smaller", AddLabels: []string{"TRASH"}},
+	}
+	var buf bytes.Buffer
+	if err := WriteXML(&buf, filters, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("WriteXML: %v", err)
+	}
+	for _, want := range []string{
+		`xmlns:apps="http://schemas.google.com/apps/2006"`,
+		`<apps:property name="shouldStar" value="true"></apps:property>`,
+		`<updated>2024-05-01T12:00:00Z</updated>`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("WriteXML() lacks %s:\n%s", want, buf.String())
+		}
+	}
+
+	// Each user label gets an entry of its own
+	got, err := ReadXML(&buf)
+	if err != nil {
+		t.Fatalf("ReadXML of written filters: %v", err)
+	}
+	want := []email.GmailFilter{
+		{Subject: "invoice", AddLabels: []string{"Bills", "STARRED"}, RemoveLabels: []string{"UNREAD"}},
+		{Subject: "invoice", AddLabels: []string{"Finance"}},
+		filters[1],
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("written filters read back as %+v; want %+v", got, want)
+	}
+}
@@ -0,0 +1,220 @@
+// Package grpcapi serves the daemon's control surface over gRPC, using the
+// service defined in proto/tsk/v1/control.proto, so other services can
+// drive go-tsk with typed clients.
+package grpcapi
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/mshan/go-tsk/internal/events"
+	"github.com/mshan/go-tsk/internal/scheduler"
+	"github.com/mshan/go-tsk/pkg/tskv1"
+)
+
+// streamBuffer is the number of events buffered per stream; a client that
+// falls further behind misses events
+const streamBuffer = 64
+
+// Controller drives a running daemon; *scheduler.EmailPoller implements it
+type Controller interface {
+	Accounts() []scheduler.AccountStatus
+	Account(accountID string) (scheduler.AccountStatus, error)
+	Pause(accountID string) error
+	Resume(accountID string) error
+	PollNow(accountID string) error
+	Subscribe(buffer int) (<-chan events.Event, func())
+}
+
+// Server implements tskv1.ControlServiceServer on top of a Controller
+type Server struct {
+	tskv1.UnimplementedControlServiceServer
+	ctl Controller
+}
+
+// NewServer creates a control service backed by ctl
+func NewServer(ctl Controller) *Server {
+	return &Server{ctl: ctl}
+}
+
+// NewGRPCServer returns a gRPC server with the control service registered.
+// Every call must carry token as a bearer token in its metadata.
+func NewGRPCServer(ctl Controller, token string) *grpc.Server {
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := authorize(ctx, token); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := authorize(ss.Context(), token); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	)
+	tskv1.RegisterControlServiceServer(s, NewServer(ctl))
+	return s
+}
+
+// ListenAndServe serves the control service on addr
+func ListenAndServe(addr, token string, ctl Controller) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return NewGRPCServer(ctl, token).Serve(lis)
+}
+
+// authorize checks the bearer token in the call's metadata
+func authorize(ctx context.Context, token string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	want := []byte("Bearer " + token)
+	for _, got := range md.Get("authorization") {
+		if token != "" && subtle.ConstantTimeCompare([]byte(got), want) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or invalid token")
+}
+
+// ListAccounts returns the status of every configured account
+func (s *Server) ListAccounts(ctx context.Context, req *tskv1.ListAccountsRequest) (*tskv1.ListAccountsResponse, error) {
+	resp := &tskv1.ListAccountsResponse{}
+	for _, a := range s.ctl.Accounts() {
+		resp.Accounts = append(resp.Accounts, newAccount(a))
+	}
+	return resp, nil
+}
+
+// GetAccount returns the status of one account
+func (s *Server) GetAccount(ctx context.Context, req *tskv1.AccountRequest) (*tskv1.Account, error) {
+	return s.account(req.GetAccountId())
+}
+
+// PollNow polls a running account immediately
+func (s *Server) PollNow(ctx context.Context, req *tskv1.AccountRequest) (*tskv1.Account, error) {
+	return s.control(req.GetAccountId(), "poll", s.ctl.PollNow)
+}
+
+// PauseAccount skips an account's scheduled polls until it is resumed
+func (s *Server) PauseAccount(ctx context.Context, req *tskv1.AccountRequest) (*tskv1.Account, error) {
+	return s.control(req.GetAccountId(), "pause", s.ctl.Pause)
+}
+
+// ResumeAccount resumes a paused account
+func (s *Server) ResumeAccount(ctx context.Context, req *tskv1.AccountRequest) (*tskv1.Account, error) {
+	return s.control(req.GetAccountId(), "resume", s.ctl.Resume)
+}
+
+// StreamEvents sends the daemon's events, filtered by the request, until
+// the client goes away
+func (s *Server) StreamEvents(req *tskv1.StreamEventsRequest, stream tskv1.ControlService_StreamEventsServer) error {
+	filter := events.Filter{Account: req.GetAccountId(), Types: req.GetTypes()}
+	if filter.Account != "" {
+		if _, err := s.ctl.Account(filter.Account); err != nil {
+			return statusError(err)
+		}
+	}
+
+	ch, cancel := s.ctl.Subscribe(streamBuffer)
+	defer cancel()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case ev, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			if !filter.Match(ev) {
+				continue
+			}
+			msg, err := newEvent(ev)
+			if err != nil {
+				log.Printf("gRPC: failed to encode event %s: %v", ev.ID, err)
+				continue
+			}
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// account returns the status of one account as a message
+func (s *Server) account(id string) (*tskv1.Account, error) {
+	a, err := s.ctl.Account(id)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return newAccount(a), nil
+}
+
+// control applies an account action and returns the resulting status
+func (s *Server) control(id, verb string, action func(string) error) (*tskv1.Account, error) {
+	if err := action(id); err != nil {
+		return nil, statusError(err)
+	}
+	log.Printf("gRPC: %s account %s", verb, id)
+	return s.account(id)
+}
+
+// statusError maps a control error to its gRPC status
+func statusError(err error) error {
+	switch {
+	case errors.Is(err, scheduler.ErrUnknownAccount):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, scheduler.ErrPaused), errors.Is(err, scheduler.ErrNotRunning):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// newAccount converts an account status to its message form
+func newAccount(s scheduler.AccountStatus) *tskv1.Account {
+	a := &tskv1.Account{
+		Id:        s.ID,
+		Name:      s.Name,
+		Enabled:   s.Enabled,
+		Running:   s.Running,
+		Connected: s.Connected,
+		Paused:    s.Paused,
+	}
+	if !s.LastSync.IsZero() {
+		a.LastSync = timestamppb.New(s.LastSync)
+	}
+	if s.LastError != nil {
+		a.LastError = s.LastError.Err.Error()
+		a.LastErrorTime = timestamppb.New(s.LastError.Time)
+	}
+	return a
+}
+
+// newEvent converts an event to its message form
+func newEvent(ev events.Event) (*tskv1.Event, error) {
+	data, err := json.Marshal(ev.Data)
+	if err != nil {
+		return nil, err
+	}
+	return &tskv1.Event{
+		Id:      ev.ID,
+		Type:    ev.Type,
+		Source:  ev.Source,
+		Subject: ev.Subject,
+		Time:    timestamppb.New(ev.Time),
+		Data:    data,
+	}, nil
+}
@@ -0,0 +1,210 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/mshan/go-tsk/internal/events"
+	"github.com/mshan/go-tsk/internal/scheduler"
+	"github.com/mshan/go-tsk/pkg/tskv1"
+)
+
+var syncTime = time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+
+// fakeController knows account "primary", which is running, and "idle",
+// which is paused
+type fakeController struct {
+	events *events.Emitter
+	paused map[string]bool
+}
+
+func newFakeController() *fakeController {
+	return &fakeController{events: &events.Emitter{}, paused: map[string]bool{"idle": true}}
+}
+
+func (c *fakeController) Accounts() []scheduler.AccountStatus {
+	primary, _ := c.Account("primary")
+	idle, _ := c.Account("idle")
+	return []scheduler.AccountStatus{primary, idle}
+}
+
+func (c *fakeController) Account(accountID string) (scheduler.AccountStatus, error) {
+	switch accountID {
+	case "primary":
+		return scheduler.AccountStatus{ID: "primary", Running: true, Paused: c.paused["primary"], LastSync: syncTime}, nil
+	case "idle":
+		return scheduler.AccountStatus{ID: "idle", Running: true, Paused: c.paused["idle"],
+			LastError: &scheduler.AccountError{AccountID: "idle", Err: errors.New("auth failed"), Time: syncTime}}, nil
+	}
+	return scheduler.AccountStatus{}, scheduler.ErrUnknownAccount
+}
+
+func (c *fakeController) Pause(accountID string) error {
+	if _, err := c.Account(accountID); err != nil {
+		return err
+	}
+	c.paused[accountID] = true
+	return nil
+}
+
+func (c *fakeController) Resume(accountID string) error {
+	if _, err := c.Account(accountID); err != nil {
+		return err
+	}
+	c.paused[accountID] = false
+	return nil
+}
+
+func (c *fakeController) PollNow(accountID string) error {
+	if _, err := c.Account(accountID); err != nil {
+		return err
+	}
+	if c.paused[accountID] {
+		return scheduler.ErrPaused
+	}
+	return nil
+}
+
+func (c *fakeController) Subscribe(buffer int) (<-chan events.Event, func()) {
+	return c.events.Subscribe(buffer)
+}
+
+func TestControlService(t *testing.T) {
+	ctl := newFakeController()
+	s := NewServer(ctl)
+	ctx := context.Background()
+
+	list, err := s.ListAccounts(ctx, &tskv1.ListAccountsRequest{})
+	if err != nil || len(list.GetAccounts()) != 2 {
+		t.Fatalf("ListAccounts() = %v, %v; want 2 accounts", list, err)
+	}
+	if a := list.GetAccounts()[0]; !a.GetLastSync().AsTime().Equal(syncTime) || a.GetLastErrorTime() != nil {
+		t.Errorf("primary = %+v; want synced without errors", a)
+	}
+	if a := list.GetAccounts()[1]; a.GetLastSync() != nil || a.GetLastError() != "auth failed" {
+		t.Errorf("idle = %+v; want never synced with an error", a)
+	}
+
+	tests := []struct {
+		name string
+		call func(context.Context, *tskv1.AccountRequest) (*tskv1.Account, error)
+		id   string
+		code codes.Code
+	}{
+		{"get", s.GetAccount, "primary", codes.OK},
+		{"get unknown", s.GetAccount, "nosuch", codes.NotFound},
+		{"poll", s.PollNow, "primary", codes.OK},
+		{"poll paused", s.PollNow, "idle", codes.FailedPrecondition},
+		{"resume", s.ResumeAccount, "idle", codes.OK},
+		{"poll resumed", s.PollNow, "idle", codes.OK},
+		{"pause unknown", s.PauseAccount, "nosuch", codes.NotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := tt.call(ctx, &tskv1.AccountRequest{AccountId: tt.id})
+			if code := status.Code(err); code != tt.code {
+				t.Fatalf("error = %v; want code %v", err, tt.code)
+			}
+			if err == nil && a.GetId() != tt.id {
+				t.Errorf("account = %q; want %q", a.GetId(), tt.id)
+			}
+		})
+	}
+
+	a, err := s.PauseAccount(ctx, &tskv1.AccountRequest{AccountId: "primary"})
+	if err != nil || !a.GetPaused() {
+		t.Errorf("PauseAccount() = %+v, %v; want paused", a, err)
+	}
+}
+
+// fakeStream collects the events a stream sends
+type fakeStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent chan *tskv1.Event
+}
+
+func (s *fakeStream) Context() context.Context { return s.ctx }
+
+func (s *fakeStream) Send(ev *tskv1.Event) error {
+	s.sent <- ev
+	return nil
+}
+
+func TestStreamEvents(t *testing.T) {
+	ctl := newFakeController()
+	s := NewServer(ctl)
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &fakeStream{ctx: ctx, sent: make(chan *tskv1.Event, 10)}
+
+	if err := s.StreamEvents(&tskv1.StreamEventsRequest{AccountId: "nosuch"}, stream); status.Code(err) != codes.NotFound {
+		t.Fatalf("StreamEvents(nosuch) error = %v; want NotFound", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.StreamEvents(&tskv1.StreamEventsRequest{
+			AccountId: "primary",
+			Types:     []string{events.TypeActionApplied},
+		}, stream)
+	}()
+
+	// Wait for the stream to subscribe, then emit one event it wants
+	// between two it filters out
+	want := events.NewEvent(events.TypeActionApplied, "primary", "mid:<a@example.com>", events.MessageData{Subject: "Invoice"})
+	deadline := time.After(5 * time.Second)
+	for received := false; !received; {
+		ctl.events.Emit(ctx, events.NewEvent(events.TypeRuleMatched, "primary", "", nil))
+		ctl.events.Emit(ctx, events.NewEvent(events.TypeActionApplied, "idle", "", nil))
+		ctl.events.Emit(ctx, want)
+		select {
+		case ev := <-stream.sent:
+			if ev.GetId() != want.ID || ev.GetType() != events.TypeActionApplied || ev.GetSubject() != want.Subject {
+				t.Errorf("streamed %+v; want %s", ev, want.ID)
+			}
+			received = true
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("no event streamed")
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("StreamEvents() error = %v", err)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	tests := []struct {
+		name string
+		md   metadata.MD
+		ok   bool
+	}{
+		{"valid", metadata.Pairs("authorization", "Bearer secret"), true},
+		{"wrong", metadata.Pairs("authorization", "Bearer guess"), false},
+		{"missing", metadata.MD{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+			err := authorize(ctx, "secret")
+			if (err == nil) != tt.ok {
+				t.Errorf("authorize() error = %v; want ok %v", err, tt.ok)
+			}
+			if err != nil && status.Code(err) != codes.Unauthenticated {
+				t.Errorf("code = %v; want Unauthenticated", status.Code(err))
+			}
+		})
+	}
+	if err := authorize(context.Background(), ""); err == nil {
+		t.Error("authorize() with no token configured = nil; want an error")
+	}
+}
@@ -0,0 +1,584 @@
+package imaptest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+)
+
+// Message is a fixture message. The envelope is built from the header
+// fields; Raw, when set, is served as the full message instead of one
+// assembled from the fields and Body.
+type Message struct {
+	UID       uint32 // assigned on append when zero
+	MessageID string
+	InReplyTo string
+	Headers   []string // Further header lines, e.g. "Precedence: bulk"
+	Subject   string
+	From      string // "Name <addr>" or a bare address
+	To        string
+	Cc        string
+	Date      time.Time
+	Flags     []string
+	Body      string
+	Raw       []byte
+
+	// Structure is served as the BODYSTRUCTURE; by default the message is
+	// one text/plain part
+	Structure *imap.BodyStructure
+}
+
+// rfc822 returns the full message served for BODY[]
+func (m *Message) rfc822() []byte {
+	if len(m.Raw) > 0 {
+		return m.Raw
+	}
+	var b bytes.Buffer
+	if m.MessageID != "" {
+		fmt.Fprintf(&b, "Message-ID: %s\r\n", m.MessageID)
+	}
+	if m.InReplyTo != "" {
+		fmt.Fprintf(&b, "In-Reply-To: %s\r\n", m.InReplyTo)
+	}
+	fmt.Fprintf(&b, "From: %s\r\n", m.From)
+	if m.To != "" {
+		fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	}
+	if m.Cc != "" {
+		fmt.Fprintf(&b, "Cc: %s\r\n", m.Cc)
+	}
+	fmt.Fprintf(&b, "Subject: %s\r\n", m.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", m.Date.Format(time.RFC1123Z))
+	for _, h := range m.Headers {
+		b.WriteString(h + "\r\n")
+	}
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(m.Body)
+	return b.Bytes()
+}
+
+// envelope builds the IMAP envelope from the fixture's header fields
+func (m *Message) envelope() *imap.Envelope {
+	return &imap.Envelope{
+		Date:      m.Date,
+		Subject:   m.Subject,
+		From:      addressList(m.From),
+		To:        addressList(m.To),
+		Cc:        addressList(m.Cc),
+		MessageId: m.MessageID,
+		InReplyTo: m.InReplyTo,
+	}
+}
+
+// headerFields returns the header of a message, limited to the given
+// fields, or all but them if not is set, as HEADER.FIELDS serves it
+func headerFields(raw []byte, fields []string, not bool) []byte {
+	want := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		want[strings.ToLower(f)] = true
+	}
+	var b bytes.Buffer
+	keep := false
+	for _, line := range strings.SplitAfter(string(raw), "\n") {
+		if strings.TrimRight(line, "\r\n") == "" {
+			break
+		}
+		// Continuation lines belong to the field before them
+		if line[0] != ' ' && line[0] != '\t' {
+			name, _, _ := strings.Cut(line, ":")
+			keep = want[strings.ToLower(strings.TrimSpace(name))] != not
+		}
+		if keep {
+			b.WriteString(line)
+		}
+	}
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+// addressList parses a header address list, keeping unparsable input as the
+// mailbox name so odd fixtures still round-trip
+func addressList(s string) []*imap.Address {
+	if s == "" {
+		return nil
+	}
+	addrs, err := mail.ParseAddressList(s)
+	if err != nil {
+		return []*imap.Address{{MailboxName: s}}
+	}
+	list := make([]*imap.Address, 0, len(addrs))
+	for _, a := range addrs {
+		local, host := a.Address, ""
+		if i := strings.LastIndex(a.Address, "@"); i >= 0 {
+			local, host = a.Address[:i], a.Address[i+1:]
+		}
+		list = append(list, &imap.Address{PersonalName: a.Name, MailboxName: local, HostName: host})
+	}
+	return list
+}
+
+// memBackend is the single-user backend behind Server. All state is guarded
+// by mu because the server handles each connection on its own goroutine.
+type memBackend struct {
+	username string
+	token    string
+
+	mu        sync.Mutex
+	mailboxes map[string]*mailbox
+	searches  int // SEARCH commands served
+}
+
+func newBackend(username, token string) *memBackend {
+	be := &memBackend{username: username, token: token, mailboxes: make(map[string]*mailbox)}
+	be.mailboxes["INBOX"] = &mailbox{be: be, name: "INBOX", uidValidity: 1, uidNext: 1}
+	return be
+}
+
+// Login supports LOGIN with the token as the password; XOAUTH2 goes through
+// xoauth2Server instead
+func (be *memBackend) Login(_ *imap.ConnInfo, username, password string) (backend.User, error) {
+	if username != be.username || password != be.token {
+		return nil, backend.ErrInvalidCredentials
+	}
+	return &user{be: be}, nil
+}
+
+// mailboxLocked returns the named mailbox, creating it if create is set.
+// be.mu must be held.
+func (be *memBackend) mailboxLocked(name string, create bool) (*mailbox, error) {
+	if strings.EqualFold(name, "INBOX") {
+		name = "INBOX"
+	}
+	mbox, ok := be.mailboxes[name]
+	if !ok {
+		if !create {
+			return nil, backend.ErrNoSuchMailbox
+		}
+		mbox = &mailbox{be: be, name: name, uidValidity: 1, uidNext: 1}
+		be.mailboxes[name] = mbox
+	}
+	return mbox, nil
+}
+
+// user is the authenticated view of the backend
+type user struct {
+	be *memBackend
+}
+
+func (u *user) Username() string { return u.be.username }
+
+func (u *user) ListMailboxes(subscribed bool) ([]backend.Mailbox, error) {
+	u.be.mu.Lock()
+	defer u.be.mu.Unlock()
+
+	names := make([]string, 0, len(u.be.mailboxes))
+	for name := range u.be.mailboxes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := make([]backend.Mailbox, 0, len(names))
+	for _, name := range names {
+		list = append(list, u.be.mailboxes[name])
+	}
+	return list, nil
+}
+
+func (u *user) GetMailbox(name string) (backend.Mailbox, error) {
+	u.be.mu.Lock()
+	defer u.be.mu.Unlock()
+	return u.be.mailboxLocked(name, false)
+}
+
+func (u *user) CreateMailbox(name string) error {
+	u.be.mu.Lock()
+	defer u.be.mu.Unlock()
+	if _, ok := u.be.mailboxes[name]; ok {
+		return backend.ErrMailboxAlreadyExists
+	}
+	_, err := u.be.mailboxLocked(name, true)
+	return err
+}
+
+func (u *user) DeleteMailbox(name string) error {
+	u.be.mu.Lock()
+	defer u.be.mu.Unlock()
+	if name == "INBOX" {
+		return errors.New("cannot delete INBOX")
+	}
+	if _, ok := u.be.mailboxes[name]; !ok {
+		return backend.ErrNoSuchMailbox
+	}
+	delete(u.be.mailboxes, name)
+	return nil
+}
+
+func (u *user) RenameMailbox(existingName, newName string) error {
+	return errors.New("rename is not supported")
+}
+
+func (u *user) Logout() error { return nil }
+
+// mailbox holds messages in ascending UID order
+type mailbox struct {
+	be          *memBackend
+	name        string
+	uidValidity uint32
+	uidNext     uint32
+	modSeq      uint64 // Bumped by every change, reported as HIGHESTMODSEQ
+	messages    []*Message
+}
+
+// appendLocked adds a copy of msg, assigning the next UID if it has none.
+// be.mu must be held.
+func (mbox *mailbox) appendLocked(msg Message) *Message {
+	if msg.UID == 0 || msg.UID < mbox.uidNext {
+		msg.UID = mbox.uidNext
+	}
+	mbox.uidNext = msg.UID + 1
+	if msg.Date.IsZero() {
+		msg.Date = time.Now()
+	}
+	msg.Flags = append([]string(nil), msg.Flags...)
+	mbox.messages = append(mbox.messages, &msg)
+	mbox.modSeq++
+	return &msg
+}
+
+func (mbox *mailbox) Name() string { return mbox.name }
+
+func (mbox *mailbox) Info() (*imap.MailboxInfo, error) {
+	return &imap.MailboxInfo{Delimiter: "/", Name: mbox.name}, nil
+}
+
+func (mbox *mailbox) Status(items []imap.StatusItem) (*imap.MailboxStatus, error) {
+	mbox.be.mu.Lock()
+	defer mbox.be.mu.Unlock()
+
+	status := imap.NewMailboxStatus(mbox.name, items)
+	status.PermanentFlags = []string{"\\*"}
+	for _, item := range items {
+		switch item {
+		case imap.StatusMessages:
+			status.Messages = uint32(len(mbox.messages))
+		case imap.StatusUidNext:
+			status.UidNext = mbox.uidNext
+		case imap.StatusUidValidity:
+			status.UidValidity = mbox.uidValidity
+		case imap.StatusRecent, imap.StatusUnseen:
+			// Not tracked; reported as zero
+		case statusHighestModSeq:
+			status.Items[item] = imap.RawString(strconv.FormatUint(mbox.modSeq, 10))
+		}
+	}
+	return status, nil
+}
+
+func (mbox *mailbox) SetSubscribed(subscribed bool) error { return nil }
+
+func (mbox *mailbox) Check() error { return nil }
+
+// id returns the UID or sequence number used to address a message
+func id(uid bool, seqNum uint32, msg *Message) uint32 {
+	if uid {
+		return msg.UID
+	}
+	return seqNum
+}
+
+func (mbox *mailbox) ListMessages(uid bool, seqSet *imap.SeqSet, items []imap.FetchItem, ch chan<- *imap.Message) error {
+	defer close(ch)
+
+	// Build responses under the lock, send them without it so a slow
+	// client cannot block other connections
+	mbox.be.mu.Lock()
+	var out []*imap.Message
+	for i, msg := range mbox.messages {
+		seqNum := uint32(i + 1)
+		if !seqSet.Contains(id(uid, seqNum, msg)) {
+			continue
+		}
+		m, err := fetch(seqNum, msg, items)
+		if err != nil {
+			mbox.be.mu.Unlock()
+			return err
+		}
+		out = append(out, m)
+	}
+	mbox.be.mu.Unlock()
+
+	for _, m := range out {
+		ch <- m
+	}
+	return nil
+}
+
+// fetch builds the response for one message
+func fetch(seqNum uint32, msg *Message, items []imap.FetchItem) (*imap.Message, error) {
+	m := imap.NewMessage(seqNum, items)
+	for _, item := range items {
+		switch item {
+		case imap.FetchEnvelope:
+			m.Envelope = msg.envelope()
+		case imap.FetchFlags:
+			m.Flags = append([]string(nil), msg.Flags...)
+		case imap.FetchUid:
+			m.Uid = msg.UID
+		case imap.FetchInternalDate:
+			m.InternalDate = msg.Date
+		case imap.FetchRFC822Size:
+			m.Size = uint32(len(msg.rfc822()))
+		case imap.FetchBodyStructure:
+			m.BodyStructure = msg.Structure
+			if m.BodyStructure == nil {
+				m.BodyStructure = &imap.BodyStructure{MIMEType: "text", MIMESubType: "plain", Size: uint32(len(msg.Body))}
+			}
+			// Dispositions are extension data, only sent for extended parts
+			m.BodyStructure.Walk(func(_ []int, part *imap.BodyStructure) bool {
+				part.Extended = true
+				return true
+			})
+		default:
+			section, err := imap.ParseBodySectionName(item)
+			if err != nil {
+				return nil, fmt.Errorf("unsupported fetch item %s", item)
+			}
+			switch {
+			case len(section.Path) > 0:
+				return nil, fmt.Errorf("unsupported body section %s", item)
+			case section.Specifier == imap.EntireSpecifier:
+				m.Body[section] = bytes.NewReader(section.ExtractPartial(msg.rfc822()))
+			case section.Specifier == imap.HeaderSpecifier:
+				// HEADER without a field list is the whole header
+				header := headerFields(msg.rfc822(), section.Fields, section.NotFields || len(section.Fields) == 0)
+				m.Body[section] = bytes.NewReader(section.ExtractPartial(header))
+			default:
+				return nil, fmt.Errorf("unsupported body section %s", item)
+			}
+		}
+	}
+	return m, nil
+}
+
+func (mbox *mailbox) SearchMessages(uid bool, criteria *imap.SearchCriteria) ([]uint32, error) {
+	mbox.be.mu.Lock()
+	defer mbox.be.mu.Unlock()
+	mbox.be.searches++
+
+	var ids []uint32
+	for i, msg := range mbox.messages {
+		seqNum := uint32(i + 1)
+		ok, err := match(seqNum, msg, criteria)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			ids = append(ids, id(uid, seqNum, msg))
+		}
+	}
+	return ids, nil
+}
+
+// match evaluates the subset of SEARCH the clients under test use. Of the
+// header criteria only Message-ID is supported; others, and criteria that
+// need body parsing, are rejected rather than silently ignored.
+func match(seqNum uint32, msg *Message, c *imap.SearchCriteria) (bool, error) {
+	for key, values := range c.Header {
+		if !strings.EqualFold(key, "Message-Id") {
+			return false, errors.New("unsupported search criteria")
+		}
+		for _, v := range values {
+			if !strings.Contains(msg.MessageID, v) {
+				return false, nil
+			}
+		}
+	}
+	if len(c.Body) > 0 || len(c.Text) > 0 || c.Larger > 0 || c.Smaller > 0 ||
+		!c.SentSince.IsZero() || !c.SentBefore.IsZero() {
+		return false, errors.New("unsupported search criteria")
+	}
+
+	if c.SeqNum != nil && !c.SeqNum.Contains(seqNum) {
+		return false, nil
+	}
+	if c.Uid != nil && !c.Uid.Contains(msg.UID) {
+		return false, nil
+	}
+	day := time.Date(msg.Date.Year(), msg.Date.Month(), msg.Date.Day(), 0, 0, 0, 0, time.UTC)
+	if !c.Since.IsZero() && day.Before(c.Since) {
+		return false, nil
+	}
+	if !c.Before.IsZero() && !day.Before(c.Before) {
+		return false, nil
+	}
+	for _, f := range c.WithFlags {
+		if !hasFlag(msg.Flags, f) {
+			return false, nil
+		}
+	}
+	for _, f := range c.WithoutFlags {
+		if hasFlag(msg.Flags, f) {
+			return false, nil
+		}
+	}
+	for _, not := range c.Not {
+		ok, err := match(seqNum, msg, not)
+		if err != nil || ok {
+			return false, err
+		}
+	}
+	for _, or := range c.Or {
+		a, err := match(seqNum, msg, or[0])
+		if err != nil {
+			return false, err
+		}
+		b, err := match(seqNum, msg, or[1])
+		if err != nil {
+			return false, err
+		}
+		if !a && !b {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// hasFlag reports whether flags contains flag, ignoring case
+func hasFlag(flags []string, flag string) bool {
+	for _, f := range flags {
+		if strings.EqualFold(f, flag) {
+			return true
+		}
+	}
+	return false
+}
+
+func (mbox *mailbox) CreateMessage(flags []string, date time.Time, body imap.Literal) error {
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	mbox.be.mu.Lock()
+	defer mbox.be.mu.Unlock()
+	mbox.appendLocked(Message{Flags: flags, Date: date, Raw: raw})
+	return nil
+}
+
+func (mbox *mailbox) UpdateMessagesFlags(uid bool, seqSet *imap.SeqSet, op imap.FlagsOp, flags []string) error {
+	mbox.be.mu.Lock()
+	defer mbox.be.mu.Unlock()
+
+	for i, msg := range mbox.messages {
+		if !seqSet.Contains(id(uid, uint32(i+1), msg)) {
+			continue
+		}
+		switch op {
+		case imap.SetFlags:
+			msg.Flags = append([]string(nil), flags...)
+		case imap.AddFlags:
+			for _, f := range flags {
+				if !hasFlag(msg.Flags, f) {
+					msg.Flags = append(msg.Flags, f)
+				}
+			}
+		case imap.RemoveFlags:
+			kept := msg.Flags[:0]
+			for _, f := range msg.Flags {
+				if !hasFlag(flags, f) {
+					kept = append(kept, f)
+				}
+			}
+			msg.Flags = kept
+		}
+		mbox.modSeq++
+	}
+	return nil
+}
+
+func (mbox *mailbox) CopyMessages(uid bool, seqSet *imap.SeqSet, destName string) error {
+	mbox.be.mu.Lock()
+	defer mbox.be.mu.Unlock()
+
+	dest, err := mbox.be.mailboxLocked(destName, false)
+	if err != nil {
+		return err
+	}
+	for i, msg := range mbox.messages {
+		if seqSet.Contains(id(uid, uint32(i+1), msg)) {
+			cp := *msg
+			cp.UID = 0
+			dest.appendLocked(cp)
+		}
+	}
+	return nil
+}
+
+// MoveMessages implements the MOVE extension
+func (mbox *mailbox) MoveMessages(uid bool, seqSet *imap.SeqSet, destName string) error {
+	mbox.be.mu.Lock()
+	defer mbox.be.mu.Unlock()
+
+	dest, err := mbox.be.mailboxLocked(destName, false)
+	if err != nil {
+		return err
+	}
+	kept := mbox.messages[:0]
+	for i, msg := range mbox.messages {
+		if seqSet.Contains(id(uid, uint32(i+1), msg)) {
+			cp := *msg
+			cp.UID = 0
+			dest.appendLocked(cp)
+			continue
+		}
+		kept = append(kept, msg)
+	}
+	mbox.messages = kept
+	mbox.modSeq++
+	return nil
+}
+
+func (mbox *mailbox) Expunge() error {
+	mbox.be.mu.Lock()
+	defer mbox.be.mu.Unlock()
+
+	kept := mbox.messages[:0]
+	for _, msg := range mbox.messages {
+		if !hasFlag(msg.Flags, imap.DeletedFlag) {
+			kept = append(kept, msg)
+		}
+	}
+	mbox.messages = kept
+	mbox.modSeq++
+	return nil
+}
+
+// expungeUIDs removes the messages among uids marked as deleted and returns
+// their sequence numbers, in ascending order
+func (mbox *mailbox) expungeUIDs(uids *imap.SeqSet) []uint32 {
+	mbox.be.mu.Lock()
+	defer mbox.be.mu.Unlock()
+
+	var seqNums []uint32
+	kept := mbox.messages[:0]
+	for i, msg := range mbox.messages {
+		if uids.Contains(msg.UID) && hasFlag(msg.Flags, imap.DeletedFlag) {
+			seqNums = append(seqNums, uint32(i+1))
+			continue
+		}
+		kept = append(kept, msg)
+	}
+	mbox.messages = kept
+	mbox.modSeq++
+	return seqNums
+}
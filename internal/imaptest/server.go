@@ -0,0 +1,355 @@
+// Package imaptest runs an in-memory IMAP server preloaded with fixture
+// messages, so mail clients and the scheduler can be integration tested
+// without touching a real provider.
+package imaptest
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/responses"
+	"github.com/emersion/go-imap/server"
+	"github.com/emersion/go-sasl"
+)
+
+// Credentials accepted by every test server, via XOAUTH2 or LOGIN
+const (
+	Username = "tester@example.com"
+	Token    = "test-access-token"
+)
+
+// Server is a TLS IMAP server on a loopback port, serving one account
+type Server struct {
+	srv       *server.Server
+	ln        net.Listener
+	be        *memBackend
+	clientTLS *tls.Config
+	certDER   []byte
+}
+
+// Options are the server features a test needs beyond the defaults
+type Options struct {
+	// StartTLS accepts connections in the clear and offers STARTTLS, as
+	// servers on port 143 do
+	StartTLS bool
+	// CondStore advertises CONDSTORE. Only STATUS reports mod-sequences.
+	CondStore bool
+	// NoUIDPlus leaves out UIDPLUS, which servers offer by default
+	NoUIDPlus bool
+}
+
+// New starts a server with the fixtures in INBOX. It is shut down when the
+// test finishes.
+func New(tb testing.TB, fixtures ...Message) *Server {
+	tb.Helper()
+	return NewWithOptions(tb, Options{}, fixtures...)
+}
+
+// NewStartTLS starts a server that accepts connections in the clear and
+// offers STARTTLS, as servers on port 143 do
+func NewStartTLS(tb testing.TB, fixtures ...Message) *Server {
+	tb.Helper()
+	return NewWithOptions(tb, Options{StartTLS: true}, fixtures...)
+}
+
+// NewWithOptions starts a server with opts and the fixtures in INBOX
+func NewWithOptions(tb testing.TB, opts Options, fixtures ...Message) *Server {
+	tb.Helper()
+
+	cert, pool, err := selfSignedCert()
+	if err != nil {
+		tb.Fatalf("imaptest: %v", err)
+	}
+	serverTLS := &tls.Config{Certificates: []tls.Certificate{cert}}
+	var ln net.Listener
+	if opts.StartTLS {
+		ln, err = net.Listen("tcp", "127.0.0.1:0")
+	} else {
+		ln, err = tls.Listen("tcp", "127.0.0.1:0", serverTLS)
+	}
+	if err != nil {
+		tb.Fatalf("imaptest: %v", err)
+	}
+
+	be := newBackend(Username, Token)
+	srv := server.New(be)
+	srv.ErrorLog = log.New(io.Discard, "", 0)
+	if opts.StartTLS {
+		srv.TLSConfig = serverTLS
+	}
+	srv.EnableAuth("XOAUTH2", func(conn server.Conn) sasl.Server {
+		return &xoauth2Server{be: be, conn: conn}
+	})
+	// Extensions must be enabled before Serve reads them
+	if opts.CondStore {
+		srv.Enable(condStore{})
+	}
+	if !opts.NoUIDPlus {
+		srv.Enable(uidPlus{})
+	}
+
+	s := &Server{
+		srv:       srv,
+		ln:        ln,
+		be:        be,
+		clientTLS: &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"},
+		certDER:   cert.Certificate[0],
+	}
+	s.Append("INBOX", fixtures...)
+
+	go srv.Serve(ln)
+	tb.Cleanup(func() { srv.Close() })
+	return s
+}
+
+// Addr returns the host:port clients should dial
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// TLSConfig returns a client TLS configuration that trusts the server
+func (s *Server) TLSConfig() *tls.Config {
+	return s.clientTLS.Clone()
+}
+
+// CAFile writes the server's certificate to a PEM file in a temporary
+// directory and returns its path, for clients configured with a CA file
+func (s *Server) CAFile(tb testing.TB) string {
+	tb.Helper()
+
+	path := filepath.Join(tb.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.certDER})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		tb.Fatalf("imaptest: %v", err)
+	}
+	return path
+}
+
+// Append adds messages to a mailbox, creating it if needed. Messages without
+// a UID, or with one not above the mailbox's highest, get the next UID.
+func (s *Server) Append(name string, msgs ...Message) {
+	s.be.mu.Lock()
+	defer s.be.mu.Unlock()
+
+	mbox, _ := s.be.mailboxLocked(name, true)
+	for _, msg := range msgs {
+		mbox.appendLocked(msg)
+	}
+}
+
+// Messages returns a snapshot of a mailbox's messages, including flags set
+// by clients
+func (s *Server) Messages(name string) []Message {
+	s.be.mu.Lock()
+	defer s.be.mu.Unlock()
+
+	mbox, err := s.be.mailboxLocked(name, false)
+	if err != nil {
+		return nil
+	}
+	msgs := make([]Message, len(mbox.messages))
+	for i, msg := range mbox.messages {
+		msgs[i] = *msg
+		msgs[i].Flags = append([]string(nil), msg.Flags...)
+	}
+	return msgs
+}
+
+// Flags returns the flags of the message with the given UID in a mailbox
+func (s *Server) Flags(name string, uid uint32) []string {
+	for _, msg := range s.Messages(name) {
+		if msg.UID == uid {
+			return msg.Flags
+		}
+	}
+	return nil
+}
+
+// ResetUIDValidity simulates the server rebuilding a mailbox: it changes
+// UIDVALIDITY and renumbers every message from 1
+func (s *Server) ResetUIDValidity(name string, uidValidity uint32) {
+	s.be.mu.Lock()
+	defer s.be.mu.Unlock()
+
+	mbox, err := s.be.mailboxLocked(name, false)
+	if err != nil {
+		return
+	}
+	mbox.uidValidity = uidValidity
+	mbox.uidNext = 1
+	for _, msg := range mbox.messages {
+		msg.UID = mbox.uidNext
+		mbox.uidNext++
+	}
+}
+
+// statusHighestModSeq is the STATUS item of CONDSTORE
+const statusHighestModSeq imap.StatusItem = "HIGHESTMODSEQ"
+
+// condStore advertises the CONDSTORE capability
+type condStore struct{}
+
+func (condStore) Capabilities(server.Conn) []string { return []string{"CONDSTORE"} }
+
+func (condStore) Command(string) server.HandlerFactory { return nil }
+
+// uidPlus adds UID EXPUNGE (RFC 4315)
+type uidPlus struct{}
+
+func (uidPlus) Capabilities(server.Conn) []string { return []string{"UIDPLUS"} }
+
+func (uidPlus) Command(name string) server.HandlerFactory {
+	if name != "EXPUNGE" {
+		return nil
+	}
+	return func() server.Handler { return &uidExpunge{} }
+}
+
+// uidExpunge handles EXPUNGE as usual and UID EXPUNGE, which only removes
+// the deleted messages among the given UIDs
+type uidExpunge struct {
+	server.Expunge
+	uids *imap.SeqSet
+}
+
+func (cmd *uidExpunge) Parse(fields []interface{}) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	set, ok := fields[0].(string)
+	if !ok {
+		return errors.New("UID EXPUNGE takes a UID set")
+	}
+	var err error
+	cmd.uids, err = imap.ParseSeqSet(set)
+	return err
+}
+
+func (cmd *uidExpunge) UidHandle(conn server.Conn) error {
+	ctx := conn.Context()
+	mbox, ok := ctx.Mailbox.(*mailbox)
+	if !ok {
+		return server.ErrNoMailboxSelected
+	}
+	if ctx.MailboxReadOnly {
+		return server.ErrMailboxReadOnly
+	}
+	if cmd.uids == nil {
+		return errors.New("UID EXPUNGE takes a UID set")
+	}
+
+	// Later sequence numbers go first, so each is still valid when sent
+	seqNums := mbox.expungeUIDs(cmd.uids)
+	ch := make(chan uint32, len(seqNums))
+	for i := len(seqNums) - 1; i >= 0; i-- {
+		ch <- seqNums[i]
+	}
+	close(ch)
+	return conn.WriteResp(&responses.Expunge{SeqNums: ch})
+}
+
+// Searches returns the number of SEARCH commands served so far
+func (s *Server) Searches() int {
+	s.be.mu.Lock()
+	defer s.be.mu.Unlock()
+	return s.be.searches
+}
+
+// DropConnections closes every client connection without a BYE, as a
+// network failure would
+func (s *Server) DropConnections() {
+	var conns []server.Conn
+	s.srv.ForEachConn(func(c server.Conn) {
+		conns = append(conns, c)
+	})
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+// xoauth2Server accepts the XOAUTH2 initial response carrying Token
+type xoauth2Server struct {
+	be   *memBackend
+	conn server.Conn
+	done bool
+}
+
+// Next checks the "user=...\x01auth=Bearer ...\x01\x01" response
+func (a *xoauth2Server) Next(response []byte) ([]byte, bool, error) {
+	if response == nil {
+		if a.done {
+			return nil, true, errors.New("authentication failed")
+		}
+		// Ask for the initial response
+		return []byte{}, false, nil
+	}
+	a.done = true
+
+	parts := bytes.Split(response, []byte{0x01})
+	var user, token string
+	for _, p := range parts {
+		switch {
+		case bytes.HasPrefix(p, []byte("user=")):
+			user = string(p[len("user="):])
+		case bytes.HasPrefix(p, []byte("auth=Bearer ")):
+			token = string(p[len("auth=Bearer "):])
+		}
+	}
+
+	u, err := a.be.Login(nil, user, token)
+	if err != nil {
+		return nil, true, err
+	}
+	ctx := a.conn.Context()
+	ctx.State = imap.AuthenticatedState
+	ctx.User = u
+	return nil, true, nil
+}
+
+// selfSignedCert creates a certificate for 127.0.0.1 and a pool trusting it
+func selfSignedCert() (tls.Certificate, *x509.CertPool, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "imaptest"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool, nil
+}
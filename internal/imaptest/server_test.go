@@ -0,0 +1,91 @@
+package imaptest
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+func dial(t *testing.T, srv *Server) *client.Client {
+	t.Helper()
+
+	c, err := client.DialTLS(srv.Addr(), srv.TLSConfig())
+	if err != nil {
+		t.Fatalf("DialTLS() error = %v", err)
+	}
+	t.Cleanup(func() { c.Logout() })
+	if err := c.Login(Username, Token); err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	return c
+}
+
+func TestMove(t *testing.T) {
+	srv := New(t, Message{Subject: "a"}, Message{Subject: "b"}, Message{Subject: "c"})
+	srv.Append("Archive")
+	c := dial(t, srv)
+
+	if _, err := c.Select("INBOX", false); err != nil {
+		t.Fatalf("Select() error = %v", err)
+	}
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(2)
+	if err := c.UidMove(seqSet, "Archive"); err != nil {
+		t.Fatalf("UidMove() error = %v", err)
+	}
+
+	inbox := srv.Messages("INBOX")
+	if len(inbox) != 2 || inbox[0].Subject != "a" || inbox[1].Subject != "c" {
+		t.Errorf("INBOX after move = %+v", inbox)
+	}
+	archive := srv.Messages("Archive")
+	if len(archive) != 1 || archive[0].Subject != "b" || archive[0].UID != 1 {
+		t.Errorf("Archive after move = %+v", archive)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	srv := New(t, Message{Subject: "a", Flags: []string{imap.SeenFlag}}, Message{Subject: "b"})
+	c := dial(t, srv)
+
+	if _, err := c.Select("INBOX", false); err != nil {
+		t.Fatalf("Select() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		criteria func() *imap.SearchCriteria
+		expected []uint32
+	}{
+		{"all", imap.NewSearchCriteria, []uint32{1, 2}},
+		{"unseen", func() *imap.SearchCriteria {
+			c := imap.NewSearchCriteria()
+			c.WithoutFlags = []string{imap.SeenFlag}
+			return c
+		}, []uint32{2}},
+		{"uid range", func() *imap.SearchCriteria {
+			c := imap.NewSearchCriteria()
+			c.Uid = new(imap.SeqSet)
+			c.Uid.AddRange(2, 0)
+			return c
+		}, []uint32{2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uids, err := c.UidSearch(tt.criteria())
+			if err != nil {
+				t.Fatalf("UidSearch() error = %v", err)
+			}
+			if len(uids) != len(tt.expected) {
+				t.Fatalf("UidSearch() = %v; want %v", uids, tt.expected)
+			}
+			for i := range uids {
+				if uids[i] != tt.expected[i] {
+					t.Errorf("UidSearch() = %v; want %v", uids, tt.expected)
+				}
+			}
+		})
+	}
+}
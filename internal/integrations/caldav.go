@@ -0,0 +1,89 @@
+package integrations
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/calendar"
+)
+
+// CalDAVClient keeps events in one calendar collection of a CalDAV server
+// (RFC 4791)
+type CalDAVClient struct {
+	calendarURL string
+	username    string
+	password    string
+	client      *http.Client
+}
+
+// NewCalDAVClient creates a client for the calendar collection at
+// calendarURL, logging in with basic auth if username is set
+func NewCalDAVClient(calendarURL, username, password string) *CalDAVClient {
+	return &CalDAVClient{
+		calendarURL: strings.TrimSuffix(calendarURL, "/") + "/",
+		username:    username,
+		password:    password,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// PutEvent stores a calendar object under its event UID, replacing any
+// earlier version such as the invite an update supersedes
+func (c *CalDAVClient) PutEvent(ctx context.Context, uid string, object []byte) error {
+	resp, err := c.do(ctx, http.MethodPut, uid, object)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return caldavError(resp)
+	}
+	return nil
+}
+
+// DeleteEvent removes the event with the given UID. An event that is not
+// there is not an error.
+func (c *CalDAVClient) DeleteEvent(ctx context.Context, uid string) error {
+	resp, err := c.do(ctx, http.MethodDelete, uid, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return caldavError(resp)
+	}
+	return nil
+}
+
+// do sends a request for the resource of the event with the given UID
+func (c *CalDAVClient) do(ctx context.Context, method, uid string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.calendarURL+calendar.Filename(uid), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "text/calendar; charset=utf-8")
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("caldav request failed: %w", err)
+	}
+	return resp, nil
+}
+
+// caldavError describes a failed response
+func caldavError(resp *http.Response) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("caldav server returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+}
@@ -0,0 +1,90 @@
+package integrations
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/mshan/go-tsk/internal/calendar"
+)
+
+// fakeCalDAV is a calendar collection that stores resources in memory
+type fakeCalDAV struct {
+	mu        sync.Mutex
+	resources map[string]string // key is the request path
+	fail      bool
+}
+
+func (f *fakeCalDAV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if user, pass, ok := r.BasicAuth(); !ok || user != "me" || pass != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if f.fail {
+		http.Error(w, "storage full", http.StatusInsufficientStorage)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		if r.Header.Get("Content-Type") != "text/calendar; charset=utf-8" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if _, ok := f.resources[r.URL.Path]; ok {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			w.WriteHeader(http.StatusCreated)
+		}
+		f.resources[r.URL.Path] = string(body)
+	case http.MethodDelete:
+		if _, ok := f.resources[r.URL.Path]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.resources, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestCalDAVClient(t *testing.T) {
+	fake := &fakeCalDAV{resources: make(map[string]string)}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	c := NewCalDAVClient(srv.URL+"/calendars/me/work", "me", "secret")
+	ctx := context.Background()
+	path := "/calendars/me/work/" + calendar.Filename("abc@example.com")
+
+	if err := c.PutEvent(ctx, "abc@example.com", []byte("v1")); err != nil {
+		t.Fatalf("PutEvent() error = %v", err)
+	}
+	// An update replaces the event
+	if err := c.PutEvent(ctx, "abc@example.com", []byte("v2")); err != nil {
+		t.Fatalf("PutEvent() update error = %v", err)
+	}
+	if got := fake.resources[path]; got != "v2" || len(fake.resources) != 1 {
+		t.Errorf("resources = %v; want v2 at %s", fake.resources, path)
+	}
+
+	if err := c.DeleteEvent(ctx, "abc@example.com"); err != nil {
+		t.Fatalf("DeleteEvent() error = %v", err)
+	}
+	if err := c.DeleteEvent(ctx, "abc@example.com"); err != nil {
+		t.Errorf("DeleteEvent() of a missing event = %v; want nil", err)
+	}
+
+	fake.fail = true
+	if err := c.PutEvent(ctx, "abc@example.com", []byte("v3")); err == nil {
+		t.Error("PutEvent() succeeded on a failing server")
+	}
+	if err := NewCalDAVClient(srv.URL, "me", "wrong").DeleteEvent(ctx, "abc@example.com"); err == nil {
+		t.Error("DeleteEvent() succeeded with wrong credentials")
+	}
+}
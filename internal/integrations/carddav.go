@@ -0,0 +1,121 @@
+package integrations
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// addressBookQuery asks a CardDAV server for the vCards of every contact
+const addressBookQuery = `<?xml version="1.0" encoding="utf-8"?>
+<C:addressbook-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:carddav">
+  <D:prop><C:address-data/></D:prop>
+</C:addressbook-query>`
+
+// AddressBookClient reads the contacts of a CardDAV address book (RFC 6352)
+type AddressBookClient struct {
+	url      string
+	username string
+	password string
+	token    string
+	client   *http.Client
+}
+
+// NewAddressBookClient creates a client for the address book collection
+// at url. It authenticates with token as a bearer token if set, otherwise
+// with basic auth if username is set.
+func NewAddressBookClient(url, username, password, token string) *AddressBookClient {
+	return &AddressBookClient{
+		url:      strings.TrimSuffix(url, "/") + "/",
+		username: username,
+		password: password,
+		token:    token,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// multistatus is the part of a REPORT response holding the vCards
+type multistatus struct {
+	Responses []struct {
+		Propstats []struct {
+			AddressData string `xml:"prop>address-data"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+// Addresses returns the email addresses of every contact in the address
+// book
+func (c *AddressBookClient) Addresses(ctx context.Context) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, "REPORT", c.url, strings.NewReader(addressBookQuery))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	req.Header.Set("Depth", "1")
+	switch {
+	case c.token != "":
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	case c.username != "":
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("carddav request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("carddav server returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var ms multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("invalid carddav response: %w", err)
+	}
+	var addrs []string
+	for _, r := range ms.Responses {
+		for _, ps := range r.Propstats {
+			addrs = append(addrs, vCardEmails(ps.AddressData)...)
+		}
+	}
+	return addrs, nil
+}
+
+// vCardEmails returns the EMAIL values of a vCard (RFC 6350), including
+// grouped ones such as "item1.EMAIL"
+func vCardEmails(card string) []string {
+	var emails []string
+	for _, line := range unfoldVCard(card) {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		name, _, _ = strings.Cut(name, ";")
+		if i := strings.LastIndexByte(name, '.'); i >= 0 {
+			name = name[i+1:]
+		}
+		if strings.EqualFold(name, "EMAIL") && strings.TrimSpace(value) != "" {
+			emails = append(emails, strings.TrimSpace(value))
+		}
+	}
+	return emails
+}
+
+// unfoldVCard splits a vCard into its logical lines, joining continuation
+// lines that begin with a space or tab
+func unfoldVCard(card string) []string {
+	var lines []string
+	for _, line := range strings.Split(strings.ReplaceAll(card, "\r\n", "\n"), "\n") {
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,71 @@
+package integrations
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const addressBookResponse = `<?xml version="1.0" encoding="utf-8"?>
+<d:multistatus xmlns:d="DAV:" xmlns:card="urn:ietf:params:xml:ns:carddav">
+  <d:response>
+    <d:href>/contacts/jane.vcf</d:href>
+    <d:propstat>
+      <d:prop><card:address-data>BEGIN:VCARD
+VERSION:3.0
+FN:Jane Doe
+EMAIL;TYPE=INTERNET;TYPE=WORK:jane@example.com
+item1.EMAIL;TYPE=INTERNET:jane.doe@personal.exa
+ mple.org
+END:VCARD
+</card:address-data></d:prop>
+      <d:status>HTTP/1.1 200 OK</d:status>
+    </d:propstat>
+  </d:response>
+  <d:response>
+    <d:href>/contacts/bob.vcf</d:href>
+    <d:propstat>
+      <d:prop><card:address-data>BEGIN:VCARD
+VERSION:4.0
+FN:Bob
+TEL:+1 555 0100
+END:VCARD
+</card:address-data></d:prop>
+      <d:status>HTTP/1.1 200 OK</d:status>
+    </d:propstat>
+  </d:response>
+</d:multistatus>`
+
+func TestAddressBookAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.Header.Get("Authorization") != "Bearer tok":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.Method != "REPORT" || r.URL.Path != "/contacts/" || !strings.Contains(string(body), "addressbook-query"):
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			w.WriteHeader(http.StatusMultiStatus)
+			io.WriteString(w, addressBookResponse)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewAddressBookClient(srv.URL+"/contacts", "", "", "tok")
+	got, err := c.Addresses(context.Background())
+	if err != nil {
+		t.Fatalf("Addresses() error = %v", err)
+	}
+	if want := []string{"jane@example.com", "jane.doe@personal.example.org"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Addresses() = %q; want %q", got, want)
+	}
+
+	c = NewAddressBookClient(srv.URL+"/contacts", "me", "secret", "")
+	if _, err := c.Addresses(context.Background()); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Addresses() with wrong credentials error = %v; want 401", err)
+	}
+}
@@ -0,0 +1,122 @@
+package integrations
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// desktopAppName is the application name shown on notifications
+const desktopAppName = "go-tsk"
+
+// DesktopNotification is a notification shown on the local desktop
+type DesktopNotification struct {
+	Title   string
+	Message string
+	URL     string // Opened when the notification is clicked, where supported; may be empty
+}
+
+// Desktop shows OS-native notifications by running the platform's notifier:
+// notify-send on Linux and the BSDs, terminal-notifier or osascript on macOS
+// and a PowerShell toast on Windows
+type Desktop struct {
+	goos     string
+	lookPath func(string) (string, error)
+	run      func(ctx context.Context, name string, args ...string) error
+}
+
+// NewDesktop creates a notifier for the current operating system
+func NewDesktop() *Desktop {
+	return &Desktop{
+		goos:     runtime.GOOS,
+		lookPath: exec.LookPath,
+		run: func(ctx context.Context, name string, args ...string) error {
+			out, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+			if err != nil {
+				return fmt.Errorf("%s failed: %w: %s", name, err, strings.TrimSpace(string(out)))
+			}
+			return nil
+		},
+	}
+}
+
+// Notify shows n
+func (d *Desktop) Notify(ctx context.Context, n DesktopNotification) error {
+	name, args, err := d.command(n)
+	if err != nil {
+		return err
+	}
+	return d.run(ctx, name, args...)
+}
+
+// command builds the notifier command line for n
+func (d *Desktop) command(n DesktopNotification) (string, []string, error) {
+	switch d.goos {
+	case "darwin":
+		// Only terminal-notifier supports click-through URLs
+		if path, err := d.lookPath("terminal-notifier"); err == nil {
+			args := []string{"-title", desktopAppName, "-subtitle", n.Title, "-message", n.Message}
+			if n.URL != "" {
+				args = append(args, "-open", n.URL)
+			}
+			return path, args, nil
+		}
+		script := fmt.Sprintf("display notification %s with title %s subtitle %s",
+			appleScriptString(n.Message), appleScriptString(desktopAppName), appleScriptString(n.Title))
+		return "osascript", []string{"-e", script}, nil
+	case "windows":
+		return "powershell", []string{"-NoProfile", "-NonInteractive", "-Command", windowsToastScript(n)}, nil
+	case "linux", "freebsd", "openbsd", "netbsd":
+		path, err := d.lookPath("notify-send")
+		if err != nil {
+			return "", nil, fmt.Errorf("notify-send not found: %w", err)
+		}
+		// Notification servers that support body markup make the link
+		// clickable; the body is escaped as they parse it
+		body := html.EscapeString(n.Message)
+		if n.URL != "" {
+			body += fmt.Sprintf("\n<a href=\"%s\">%s</a>", html.EscapeString(n.URL), html.EscapeString(n.URL))
+		}
+		// A title starting with "-" must not be taken for an option
+		return path, []string{"--app-name=" + desktopAppName, "--", n.Title, body}, nil
+	default:
+		return "", nil, fmt.Errorf("desktop notifications are not supported on %s", d.goos)
+	}
+}
+
+// appleScriptString quotes s as an AppleScript string literal
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// windowsToastScript returns a PowerShell script showing n as a toast. A
+// URL makes the toast a protocol activation, so clicking it opens the URL.
+// The toast XML carries text from the mail, so it is passed base64 encoded
+// rather than quoted: PowerShell takes several Unicode quotes for ', and
+// a literal could be closed by one of them.
+func windowsToastScript(n DesktopNotification) string {
+	activation := ""
+	if n.URL != "" {
+		activation = fmt.Sprintf(` activationType="protocol" launch="%s"`, html.EscapeString(n.URL))
+	}
+	xml := fmt.Sprintf(`<toast%s><visual><binding template="ToastGeneric"><text>%s</text><text>%s</text></binding></visual></toast>`,
+		activation, html.EscapeString(n.Title), html.EscapeString(n.Message))
+	return strings.Join([]string{
+		`[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] | Out-Null`,
+		`[Windows.Data.Xml.Dom.XmlDocument, Windows.Data.Xml.Dom.XmlDocument, ContentType = WindowsRuntime] | Out-Null`,
+		`$xml = New-Object Windows.Data.Xml.Dom.XmlDocument`,
+		`$xml.LoadXml([Text.Encoding]::UTF8.GetString([Convert]::FromBase64String(` + powerShellString(base64.StdEncoding.EncodeToString([]byte(xml))) + `)))`,
+		`[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier(` + powerShellString(desktopAppName) + `).Show([Windows.UI.Notifications.ToastNotification]::new($xml))`,
+	}, "; ")
+}
+
+// powerShellString quotes s as a PowerShell verbatim string literal,
+// doubling the ASCII and Unicode single quotes PowerShell accepts
+func powerShellString(s string) string {
+	return "'" + strings.NewReplacer("'", "''", "\u2018", "\u2018\u2018", "\u2019", "\u2019\u2019",
+		"\u201a", "\u201a\u201a", "\u201b", "\u201b\u201b").Replace(s) + "'"
+}
@@ -0,0 +1,136 @@
+package integrations
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestDesktopNotify(t *testing.T) {
+	n := DesktopNotification{Title: `Build "broken"`, Message: "CI <failed>", URL: "https://mail.google.com/mail/#inbox"}
+
+	tests := []struct {
+		name      string
+		goos      string
+		installed []string
+		cmd       string
+		want      []string // Substrings of the joined arguments
+		wantErr   bool
+	}{
+		{"linux", "linux", []string{"notify-send"}, "/usr/bin/notify-send",
+			[]string{`Build "broken"`, "CI &lt;failed&gt;", `<a href="https://mail.google.com/mail/#inbox">`}, false},
+		{"linux without notify-send", "linux", nil, "", nil, true},
+		{"macos terminal-notifier", "darwin", []string{"terminal-notifier"}, "/usr/bin/terminal-notifier",
+			[]string{"-subtitle " + `Build "broken"`, "-open https://mail.google.com/mail/#inbox"}, false},
+		{"macos osascript", "darwin", nil, "osascript",
+			[]string{`display notification "CI <failed>" with title "go-tsk" subtitle "Build \"broken\""`}, false},
+		{"windows", "windows", nil, "powershell", []string{"FromBase64String"}, false},
+		{"unsupported", "plan9", nil, "", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var name string
+			var args []string
+			d := &Desktop{
+				goos: tt.goos,
+				lookPath: func(file string) (string, error) {
+					for _, f := range tt.installed {
+						if f == file {
+							return "/usr/bin/" + file, nil
+						}
+					}
+					return "", errors.New("not found")
+				},
+				run: func(ctx context.Context, n string, a ...string) error {
+					name, args = n, a
+					return nil
+				},
+			}
+
+			err := d.Notify(context.Background(), n)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Notify() error = %v; wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if name != tt.cmd {
+				t.Errorf("command = %q; want %q", name, tt.cmd)
+			}
+			joined := strings.Join(args, " ")
+			for _, want := range tt.want {
+				if !strings.Contains(joined, want) {
+					t.Errorf("arguments %q do not contain %q", joined, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDesktopNotifyUntrustedText(t *testing.T) {
+	var args []string
+	d := &Desktop{
+		lookPath: func(file string) (string, error) { return "/usr/bin/" + file, nil },
+		run: func(ctx context.Context, n string, a ...string) error {
+			args = a
+			return nil
+		},
+	}
+	n := DesktopNotification{
+		Title:   "-u critical \u2019); Remove-Item -Recurse C:\\ #",
+		Message: "it's <b>",
+		URL:     "https://example.com/?a='b'",
+	}
+
+	d.goos = "linux"
+	if err := d.Notify(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+	if len(args) < 3 || args[1] != "--" || args[2] != n.Title {
+		t.Errorf("notify-send arguments = %q; want the title after --", args)
+	}
+
+	d.goos = "windows"
+	if err := d.Notify(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+	script := args[len(args)-1]
+	if strings.Contains(script, "Remove-Item") || strings.ContainsRune(script, '\u2019') {
+		t.Fatalf("script contains the title as code: %s", script)
+	}
+	m := regexp.MustCompile(`FromBase64String\('([A-Za-z0-9+/=]+)'\)`).FindStringSubmatch(script)
+	if m == nil {
+		t.Fatalf("script does not decode the toast: %s", script)
+	}
+	xml, err := base64.StdEncoding.DecodeString(m[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<text>-u critical \u2019); Remove-Item -Recurse C:\\ #</text>",
+		"<text>it&#39;s &lt;b&gt;</text>",
+		`launch="https://example.com/?a=&#39;b&#39;"`,
+	} {
+		if !strings.Contains(string(xml), want) {
+			t.Errorf("toast %s does not contain %s", xml, want)
+		}
+	}
+}
+
+func TestPowerShellString(t *testing.T) {
+	tests := map[string]string{
+		"plain":          "'plain'",
+		"it's":           "'it''s'",
+		"\u2018a\u2019b": "'\u2018\u2018a\u2019\u2019b'",
+		"\u201ac\u201b":  "'\u201a\u201ac\u201b\u201b'",
+	}
+	for in, want := range tests {
+		if got := powerShellString(in); got != want {
+			t.Errorf("powerShellString(%q) = %q; want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package integrations
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	defaultExecConcurrency = 4
+	defaultExecTimeout     = 30 * time.Second
+
+	// maxExecOutput bounds the output of a command kept for error messages
+	maxExecOutput = 4 << 10
+)
+
+// ExecCommand is one run of a local command
+type ExecCommand struct {
+	Args    []string      // Command and its arguments
+	Stdin   []byte        // Written to the command's standard input
+	Env     []string      // Names of environment variables passed through; others are withheld
+	Timeout time.Duration // Kills the command after this long; 0 uses 30s
+}
+
+// Exec runs local commands, at most a fixed number at once
+type Exec struct {
+	slots   chan struct{}
+	environ func() []string
+}
+
+// NewExec creates a runner of at most maxConcurrent commands at once; more
+// wait for a free slot. maxConcurrent 0 allows 4.
+func NewExec(maxConcurrent int) *Exec {
+	if maxConcurrent <= 0 {
+		maxConcurrent = defaultExecConcurrency
+	}
+	return &Exec{slots: make(chan struct{}, maxConcurrent), environ: os.Environ}
+}
+
+// Run runs cmd and waits for it. It fails if the command cannot start,
+// exits with an error or outlives its timeout.
+func (e *Exec) Run(ctx context.Context, cmd ExecCommand) error {
+	if len(cmd.Args) == 0 {
+		return fmt.Errorf("no command to run")
+	}
+	timeout := cmd.Timeout
+	if timeout <= 0 {
+		timeout = defaultExecTimeout
+	}
+
+	select {
+	case e.slots <- struct{}{}:
+		defer func() { <-e.slots }()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	c := exec.CommandContext(ctx, cmd.Args[0], cmd.Args[1:]...)
+	c.Stdin = bytes.NewReader(cmd.Stdin)
+	c.Env = e.allowedEnv(cmd.Env)
+	out := &tailBuffer{max: maxExecOutput}
+	c.Stdout, c.Stderr = out, out
+
+	if err := c.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("%s timed out after %s", cmd.Args[0], timeout)
+		}
+		return fmt.Errorf("%s failed: %w: %s", cmd.Args[0], err, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
+
+// allowedEnv returns the variables of the environment named in allow
+func (e *Exec) allowedEnv(allow []string) []string {
+	names := make(map[string]bool, len(allow))
+	for _, name := range allow {
+		names[name] = true
+	}
+	// An empty, non-nil environment keeps exec from passing on ours
+	env := []string{}
+	for _, kv := range e.environ() {
+		if name, _, ok := strings.Cut(kv, "="); ok && names[name] {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// tailBuffer keeps the last max bytes written to it
+type tailBuffer struct {
+	max int
+	buf []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.max {
+		t.buf = t.buf[len(t.buf)-t.max:]
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	return string(t.buf)
+}
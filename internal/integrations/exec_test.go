@@ -0,0 +1,89 @@
+package integrations
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExecRun(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	e := NewExec(0)
+	e.environ = func() []string { return []string{"HOME=/home/me", "SECRET=hunter2", "PATH=" + os.Getenv("PATH")} }
+
+	tests := []struct {
+		name    string
+		cmd     ExecCommand
+		want    string // Contents of out afterwards
+		wantErr string
+	}{
+		{"stdin", ExecCommand{Args: []string{"sh", "-c", `cat > "$0"`, out}, Stdin: []byte(`{"subject": "Hi"}`)}, `{"subject": "Hi"}`, ""},
+		{"env allowlist", ExecCommand{Args: []string{"/bin/sh", "-c", `echo "$HOME:$SECRET" > "$0"`, out}, Env: []string{"HOME"}}, "/home/me:\n", ""},
+		{"failure output", ExecCommand{Args: []string{"sh", "-c", "echo broken >&2; exit 3"}}, "", "exit status 3: broken"},
+		{"timeout", ExecCommand{Args: []string{"sleep", "5"}, Timeout: 50 * time.Millisecond}, "", "timed out after 50ms"},
+		{"missing command", ExecCommand{Args: []string{filepath.Join(dir, "missing")}}, "", "no such file"},
+		{"no command", ExecCommand{}, "", "no command"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(out)
+			err := e.Run(context.Background(), tt.cmd)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Run error = %v; want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			got, err := os.ReadFile(out)
+			if err != nil || string(got) != tt.want {
+				t.Errorf("output = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecConcurrency(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	// Each command appends to a log while holding a lock file; with one
+	// slot none may find the lock taken
+	dir := t.TempDir()
+	script := `if ! mkdir "$0/lock" 2>/dev/null; then echo overlap >> "$0/log"; fi; sleep 0.05; rmdir "$0/lock"`
+	e := NewExec(1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := e.Run(context.Background(), ExecCommand{Args: []string{"sh", "-c", script, dir}, Env: []string{"PATH"}}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if _, err := os.Stat(filepath.Join(dir, "log")); err == nil {
+		t.Error("commands ran concurrently beyond the cap")
+	}
+
+	// Waiting for a slot ends with the context
+	e.slots <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := e.Run(ctx, ExecCommand{Args: []string{"true"}}); err != context.DeadlineExceeded {
+		t.Errorf("Run with no free slot = %v; want DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,92 @@
+package integrations
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// githubAPI is the GitHub REST API base URL
+const githubAPI = "https://api.github.com"
+
+// GitHubClient opens issues through the GitHub REST API
+type GitHubClient struct {
+	token   string
+	baseURL string
+	client  *http.Client
+}
+
+// GitHubOption customizes a GitHubClient
+type GitHubOption func(*GitHubClient)
+
+// WithGitHubURL points the client at another API base URL, such as GitHub
+// Enterprise ("https://github.example.com/api/v3") or a test server
+func WithGitHubURL(baseURL string) GitHubOption {
+	return func(c *GitHubClient) {
+		c.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// NewGitHubClient creates a GitHub client authenticating with a token that
+// can create issues in the target repositories
+func NewGitHubClient(token string, opts ...GitHubOption) *GitHubClient {
+	c := &GitHubClient{
+		token:   token,
+		baseURL: githubAPI,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// GitHubIssue is the body of a create-issue request
+type GitHubIssue struct {
+	Title  string   `json:"title"`
+	Body   string   `json:"body,omitempty"`
+	Labels []string `json:"labels,omitempty"`
+}
+
+// CreateIssue opens an issue in repo ("owner/name") and returns its number
+// and web URL
+func (c *GitHubClient) CreateIssue(ctx context.Context, repo string, issue GitHubIssue) (int, string, error) {
+	body, err := json.Marshal(issue)
+	if err != nil {
+		return 0, "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/repos/"+repo+"/issues", bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, "", fmt.Errorf("github request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return 0, "", fmt.Errorf("github returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var created struct {
+		Number  int    `json:"number"`
+		HTMLURL string `json:"html_url"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&created); err != nil {
+		return 0, "", fmt.Errorf("invalid github response: %w", err)
+	}
+	return created.Number, created.HTMLURL, nil
+}
@@ -0,0 +1,51 @@
+package integrations
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestGitHubCreateIssue(t *testing.T) {
+	var got GitHubIssue
+	var path, auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"number": 1347, "html_url": "https://github.com/octo/app/issues/1347"}`))
+	}))
+	defer srv.Close()
+
+	c := NewGitHubClient("ghp_token", WithGitHubURL(srv.URL+"/"))
+	issue := GitHubIssue{Title: "Bug: crash on login", Body: "From: a@example.com", Labels: []string{"bug", "from-email"}}
+	number, url, err := c.CreateIssue(context.Background(), "octo/app", issue)
+	if err != nil {
+		t.Fatalf("CreateIssue() error = %v", err)
+	}
+	if number != 1347 || url != "https://github.com/octo/app/issues/1347" {
+		t.Errorf("CreateIssue() = %d, %q", number, url)
+	}
+	if path != "/repos/octo/app/issues" || auth != "Bearer ghp_token" {
+		t.Errorf("request to %s with Authorization %q", path, auth)
+	}
+	if !reflect.DeepEqual(got, issue) {
+		t.Errorf("request body = %+v; want %+v", got, issue)
+	}
+}
+
+func TestGitHubCreateIssueError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message": "Not Found"}`, http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	c := NewGitHubClient("token", WithGitHubURL(srv.URL))
+	if _, _, err := c.CreateIssue(context.Background(), "octo/missing", GitHubIssue{Title: "x"}); err == nil {
+		t.Error("CreateIssue() error = nil; want error for 404 response")
+	}
+}
@@ -0,0 +1,92 @@
+package integrations
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// JiraClient files issues through the Jira REST API (version 2, which takes
+// plain-text descriptions on both Jira Cloud and Data Center)
+type JiraClient struct {
+	baseURL string
+	email   string
+	token   string
+	client  *http.Client
+}
+
+// NewJiraClient creates a Jira client for the site at baseURL. With an
+// email the token is sent as a Jira Cloud API token using basic auth;
+// without one it is sent as a Data Center personal access token.
+func NewJiraClient(baseURL, email, token string) *JiraClient {
+	return &JiraClient{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		email:   email,
+		token:   token,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// JiraIssue is an issue to create
+type JiraIssue struct {
+	Project     string // Project key
+	IssueType   string // Issue type name, e.g. "Task"
+	Summary     string
+	Description string
+	Fields      map[string]string // Further fields by ID, e.g. "customfield_10042"
+}
+
+// CreateIssue files issue and returns its key and browse URL
+func (c *JiraClient) CreateIssue(ctx context.Context, issue JiraIssue) (string, string, error) {
+	fields := map[string]interface{}{
+		"project":   map[string]string{"key": issue.Project},
+		"issuetype": map[string]string{"name": issue.IssueType},
+		"summary":   issue.Summary,
+	}
+	if issue.Description != "" {
+		fields["description"] = issue.Description
+	}
+	for id, value := range issue.Fields {
+		fields[id] = value
+	}
+	body, err := json.Marshal(map[string]interface{}{"fields": fields})
+	if err != nil {
+		return "", "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/rest/api/2/issue", bytes.NewReader(body))
+	if err != nil {
+		return "", "", err
+	}
+	if c.email != "" {
+		req.SetBasicAuth(c.email, c.token)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("jira request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", "", fmt.Errorf("jira returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var created struct {
+		Key string `json:"key"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&created); err != nil {
+		return "", "", fmt.Errorf("invalid jira response: %w", err)
+	}
+	return created.Key, c.baseURL + "/browse/" + created.Key, nil
+}
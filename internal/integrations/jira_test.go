@@ -0,0 +1,79 @@
+package integrations
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestJiraCreateIssue(t *testing.T) {
+	tests := []struct {
+		name  string
+		email string
+		check func(r *http.Request) bool
+	}{
+		{"cloud", "bot@example.com", func(r *http.Request) bool {
+			user, pass, ok := r.BasicAuth()
+			return ok && user == "bot@example.com" && pass == "secret"
+		}},
+		{"data center", "", func(r *http.Request) bool {
+			return r.Header.Get("Authorization") == "Bearer secret"
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got struct {
+				Fields map[string]interface{} `json:"fields"`
+			}
+			var path string
+			var authorized bool
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				path = r.URL.Path
+				authorized = tt.check(r)
+				json.NewDecoder(r.Body).Decode(&got)
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"id": "10001", "key": "OPS-42", "self": "ignored"}`))
+			}))
+			defer srv.Close()
+
+			c := NewJiraClient(srv.URL+"/", tt.email, "secret")
+			key, url, err := c.CreateIssue(context.Background(), JiraIssue{
+				Project:     "OPS",
+				IssueType:   "Bug",
+				Summary:     "Disk full on db-1",
+				Description: "From: alerts@example.com",
+				Fields:      map[string]string{"customfield_10042": "alerts@example.com"},
+			})
+			if err != nil {
+				t.Fatalf("CreateIssue() error = %v", err)
+			}
+			if key != "OPS-42" || url != srv.URL+"/browse/OPS-42" {
+				t.Errorf("CreateIssue() = %q, %q", key, url)
+			}
+			if path != "/rest/api/2/issue" || !authorized {
+				t.Errorf("request to %s authorized = %v", path, authorized)
+			}
+			project, _ := got.Fields["project"].(map[string]interface{})
+			issueType, _ := got.Fields["issuetype"].(map[string]interface{})
+			if project["key"] != "OPS" || issueType["name"] != "Bug" || got.Fields["summary"] != "Disk full on db-1" ||
+				got.Fields["description"] != "From: alerts@example.com" || got.Fields["customfield_10042"] != "alerts@example.com" {
+				t.Errorf("request fields = %v", got.Fields)
+			}
+		})
+	}
+}
+
+func TestJiraCreateIssueError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"errors": {"project": "project is required"}}`, http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	c := NewJiraClient(srv.URL, "", "token")
+	if _, _, err := c.CreateIssue(context.Background(), JiraIssue{Summary: "x"}); err == nil {
+		t.Error("CreateIssue() error = nil; want error for 400 response")
+	}
+}
@@ -0,0 +1,73 @@
+package integrations
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ntfyServer is the public ntfy server
+const ntfyServer = "https://ntfy.sh"
+
+// NtfyClient publishes notifications to ntfy topics
+type NtfyClient struct {
+	server string
+	token  string
+	client *http.Client
+}
+
+// NewNtfyClient creates a client for server, or ntfy.sh if it is empty.
+// token is an access token for protected topics and may be empty.
+func NewNtfyClient(server, token string) *NtfyClient {
+	if server == "" {
+		server = ntfyServer
+	}
+	return &NtfyClient{
+		server: strings.TrimSuffix(server, "/"),
+		token:  token,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Publish sends msg to topic
+func (c *NtfyClient) Publish(ctx context.Context, topic string, msg PushMessage) error {
+	priority, _, err := pushLevels(msg.Priority)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"topic":    topic,
+		"title":    msg.Title,
+		"message":  msg.Message,
+		"priority": priority,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.server, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("ntfy request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("ntfy returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
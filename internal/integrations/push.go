@@ -0,0 +1,42 @@
+package integrations
+
+import "fmt"
+
+// Push notification priorities, shared by ntfy and Pushover
+const (
+	PriorityMin     = "min"
+	PriorityLow     = "low"
+	PriorityDefault = "default"
+	PriorityHigh    = "high"
+	PriorityUrgent  = "urgent"
+)
+
+// pushPriorities maps each priority to its ntfy (1-5) and Pushover (-2-2)
+// level
+var pushPriorities = map[string][2]int{
+	PriorityMin:     {1, -2},
+	PriorityLow:     {2, -1},
+	PriorityDefault: {3, 0},
+	PriorityHigh:    {4, 1},
+	PriorityUrgent:  {5, 2},
+}
+
+// pushLevels returns the ntfy and Pushover levels of a priority; empty
+// means PriorityDefault
+func pushLevels(priority string) (ntfy, pushover int, err error) {
+	if priority == "" {
+		priority = PriorityDefault
+	}
+	levels, ok := pushPriorities[priority]
+	if !ok {
+		return 0, 0, fmt.Errorf("unknown push priority %q", priority)
+	}
+	return levels[0], levels[1], nil
+}
+
+// PushMessage is a phone push notification
+type PushMessage struct {
+	Title    string
+	Message  string
+	Priority string // One of the Priority constants; empty means PriorityDefault
+}
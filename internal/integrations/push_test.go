@@ -0,0 +1,90 @@
+package integrations
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNtfyPublish(t *testing.T) {
+	tests := []struct {
+		priority string
+		want     float64
+		wantErr  bool
+	}{
+		{"", 3, false},
+		{PriorityMin, 1, false},
+		{PriorityUrgent, 5, false},
+		{"loud", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.priority, func(t *testing.T) {
+			var got map[string]interface{}
+			var auth string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				auth = r.Header.Get("Authorization")
+				json.NewDecoder(r.Body).Decode(&got)
+			}))
+			defer srv.Close()
+
+			c := NewNtfyClient(srv.URL+"/", "tk_token")
+			err := c.Publish(context.Background(), "alerts", PushMessage{Title: "Disk full", Message: "db-1", Priority: tt.priority})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Publish() error = %v; wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got["topic"] != "alerts" || got["title"] != "Disk full" || got["message"] != "db-1" || got["priority"] != tt.want {
+				t.Errorf("request = %v", got)
+			}
+			if auth != "Bearer tk_token" {
+				t.Errorf("Authorization = %q", auth)
+			}
+		})
+	}
+}
+
+func TestPushoverSend(t *testing.T) {
+	tests := []struct {
+		priority string
+		want     string
+		retry    bool
+	}{
+		{"", "0", false},
+		{PriorityLow, "-1", false},
+		{PriorityUrgent, "2", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			var form map[string][]string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				r.ParseForm()
+				form = r.PostForm
+				w.Write([]byte(`{"status": 1}`))
+			}))
+			defer srv.Close()
+
+			c := NewPushoverClient("app", "user", WithPushoverURL(srv.URL))
+			if err := c.Send(context.Background(), PushMessage{Title: "t", Message: "m", Priority: tt.priority}); err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+			get := func(k string) string {
+				if v := form[k]; len(v) > 0 {
+					return v[0]
+				}
+				return ""
+			}
+			if get("token") != "app" || get("user") != "user" || get("priority") != tt.want {
+				t.Errorf("form = %v", form)
+			}
+			if (get("retry") != "") != tt.retry || (get("expire") != "") != tt.retry {
+				t.Errorf("retry = %q, expire = %q; want set %v", get("retry"), get("expire"), tt.retry)
+			}
+		})
+	}
+}
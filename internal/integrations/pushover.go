@@ -0,0 +1,93 @@
+package integrations
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// pushoverAPI is the Pushover messages endpoint
+	pushoverAPI = "https://api.pushover.net/1/messages.json"
+
+	// Emergency (urgent) notifications repeat every pushoverRetry until
+	// acknowledged, for at most pushoverExpire
+	pushoverRetry  = time.Minute
+	pushoverExpire = time.Hour
+)
+
+// PushoverClient sends notifications through Pushover
+type PushoverClient struct {
+	appToken string
+	userKey  string
+	endpoint string
+	client   *http.Client
+}
+
+// PushoverOption customizes a PushoverClient
+type PushoverOption func(*PushoverClient)
+
+// WithPushoverURL points the client at another endpoint, such as a test
+// server
+func WithPushoverURL(endpoint string) PushoverOption {
+	return func(c *PushoverClient) {
+		c.endpoint = endpoint
+	}
+}
+
+// NewPushoverClient creates a client sending as the application appToken to
+// the user or group userKey
+func NewPushoverClient(appToken, userKey string, opts ...PushoverOption) *PushoverClient {
+	c := &PushoverClient{
+		appToken: appToken,
+		userKey:  userKey,
+		endpoint: pushoverAPI,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Send delivers msg
+func (c *PushoverClient) Send(ctx context.Context, msg PushMessage) error {
+	_, priority, err := pushLevels(msg.Priority)
+	if err != nil {
+		return err
+	}
+	form := url.Values{
+		"token":    {c.appToken},
+		"user":     {c.userKey},
+		"title":    {msg.Title},
+		"message":  {msg.Message},
+		"priority": {strconv.Itoa(priority)},
+	}
+	if priority == 2 {
+		form.Set("retry", strconv.Itoa(int(pushoverRetry.Seconds())))
+		form.Set("expire", strconv.Itoa(int(pushoverExpire.Seconds())))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("pushover request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("pushover returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
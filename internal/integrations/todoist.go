@@ -0,0 +1,132 @@
+package integrations
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/tasks"
+)
+
+// todoistAPI is the Todoist REST API base URL
+const todoistAPI = "https://api.todoist.com/rest/v2"
+
+// TodoistClient creates tasks in Todoist through its REST API
+type TodoistClient struct {
+	token   string
+	baseURL string
+	client  *http.Client
+}
+
+// TodoistOption customizes a TodoistClient
+type TodoistOption func(*TodoistClient)
+
+// WithTodoistURL points the client at another API base URL, such as a test
+// server
+func WithTodoistURL(baseURL string) TodoistOption {
+	return func(c *TodoistClient) {
+		c.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// NewTodoistClient creates a Todoist client authenticating with an API token
+func NewTodoistClient(token string, opts ...TodoistOption) *TodoistClient {
+	c := &TodoistClient{
+		token:   token,
+		baseURL: todoistAPI,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// TodoistTask is the body of a Todoist create-task request
+type TodoistTask struct {
+	Content     string   `json:"content"`
+	Description string   `json:"description,omitempty"`
+	ProjectID   string   `json:"project_id,omitempty"`
+	Labels      []string `json:"labels,omitempty"`
+	DueString   string   `json:"due_string,omitempty"`
+	DueDate     string   `json:"due_date,omitempty"`
+}
+
+// NewTodoistTask maps a task created from an email onto a Todoist task.
+// dueString is a Todoist natural-language date such as "tomorrow"; when
+// empty the task's own due date is used, if any.
+func NewTodoistTask(t tasks.Task, projectID, dueString string) TodoistTask {
+	tt := TodoistTask{
+		Content:     t.Title,
+		Description: describeSource(t.Source),
+		ProjectID:   projectID,
+		Labels:      t.Labels,
+		DueString:   dueString,
+	}
+	if dueString == "" && !t.Due.IsZero() {
+		tt.DueDate = t.Due.Format("2006-01-02")
+	}
+	return tt
+}
+
+// describeSource summarizes the source email for the task description
+func describeSource(src tasks.Source) string {
+	var lines []string
+	if src.From != "" {
+		lines = append(lines, "From: "+src.From)
+	}
+	if !src.Date.IsZero() {
+		lines = append(lines, "Date: "+src.Date.Format(time.RFC1123Z))
+	}
+	if src.MessageID != "" {
+		lines = append(lines, "Message-ID: "+src.MessageID)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// CreateTask creates a task and returns its Todoist ID. requestKey makes the
+// request idempotent: Todoist ignores a repeated request with the same key,
+// so retrying after a timeout does not create a duplicate.
+func (c *TodoistClient) CreateTask(ctx context.Context, task TodoistTask, requestKey string) (string, error) {
+	body, err := json.Marshal(task)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/tasks", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+	if requestKey != "" {
+		sum := sha256.Sum256([]byte(requestKey))
+		req.Header.Set("X-Request-Id", hex.EncodeToString(sum[:16]))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("todoist request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("todoist returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&created); err != nil {
+		return "", fmt.Errorf("invalid todoist response: %w", err)
+	}
+	return created.ID, nil
+}
@@ -0,0 +1,96 @@
+package integrations
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/tasks"
+)
+
+func TestNewTodoistTask(t *testing.T) {
+	due := time.Date(2024, 3, 8, 9, 0, 0, 0, time.UTC)
+	task := tasks.Task{
+		Title:  "Pay invoice #42",
+		Labels: []string{"billing"},
+		Due:    due,
+		Source: tasks.Source{From: "billing@example.com", MessageID: "<42@example.com>"},
+	}
+
+	tests := []struct {
+		name      string
+		dueString string
+		expected  TodoistTask
+	}{
+		{
+			"rule due string",
+			"tomorrow",
+			TodoistTask{Content: "Pay invoice #42", ProjectID: "p1", Labels: []string{"billing"}, DueString: "tomorrow",
+				Description: "From: billing@example.com\nMessage-ID: <42@example.com>"},
+		},
+		{
+			"task due date",
+			"",
+			TodoistTask{Content: "Pay invoice #42", ProjectID: "p1", Labels: []string{"billing"}, DueDate: "2024-03-08",
+				Description: "From: billing@example.com\nMessage-ID: <42@example.com>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewTodoistTask(task, "p1", tt.dueString); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("NewTodoistTask() = %+v; want %+v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTodoistCreateTask(t *testing.T) {
+	var got TodoistTask
+	var auth, requestID string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/tasks" {
+			http.NotFound(w, r)
+			return
+		}
+		auth = r.Header.Get("Authorization")
+		requestID = r.Header.Get("X-Request-Id")
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"id": "2995104339", "content": "ignored"}`))
+	}))
+	defer srv.Close()
+
+	c := NewTodoistClient("secret-token", WithTodoistURL(srv.URL))
+	id, err := c.CreateTask(context.Background(), TodoistTask{Content: "Review contract", DueString: "friday"}, "mid:<1@example.com>")
+	if err != nil {
+		t.Fatalf("CreateTask() error = %v", err)
+	}
+	if id != "2995104339" {
+		t.Errorf("ID = %q; want 2995104339", id)
+	}
+	if auth != "Bearer secret-token" {
+		t.Errorf("Authorization = %q", auth)
+	}
+	if got.Content != "Review contract" || got.DueString != "friday" {
+		t.Errorf("request body = %+v", got)
+	}
+	if len(requestID) != 32 {
+		t.Errorf("X-Request-Id = %q; want a stable 32-character key", requestID)
+	}
+}
+
+func TestTodoistCreateTaskError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Invalid project", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	c := NewTodoistClient("token", WithTodoistURL(srv.URL))
+	if _, err := c.CreateTask(context.Background(), TodoistTask{Content: "x"}, ""); err == nil {
+		t.Error("CreateTask() error = nil; want error for 400 response")
+	}
+}
@@ -0,0 +1,43 @@
+package integrations
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUnsubscriber(t *testing.T) {
+	var body, contentType, cookie string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body, contentType, cookie = string(b), r.Header.Get("Content-Type"), r.Header.Get("Cookie")
+		if r.Method != http.MethodPost || r.URL.Path != "/u/123" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	u := NewUnsubscriber(WithUnsubscribeClient(srv.Client()))
+	if err := u.Unsubscribe(ctx, srv.URL+"/u/123"); err != nil {
+		t.Fatalf("Unsubscribe() error = %v", err)
+	}
+	if body != "List-Unsubscribe=One-Click" || contentType != "application/x-www-form-urlencoded" || cookie != "" {
+		t.Errorf("request body %q, content type %q, cookie %q", body, contentType, cookie)
+	}
+	if err := u.Unsubscribe(ctx, srv.URL+"/unknown"); err == nil {
+		t.Error("Unsubscribe() succeeded on a 404")
+	}
+	if err := u.Unsubscribe(ctx, "http://lists.example.com/u/123"); err == nil {
+		t.Error("Unsubscribe() accepted a plain http URL")
+	}
+
+	// Without a custom client, the test server's loopback address is off limits
+	err := NewUnsubscriber().Unsubscribe(ctx, srv.URL+"/u/123")
+	if err == nil || !strings.Contains(err.Error(), "non-public address") {
+		t.Errorf("Unsubscribe() of a loopback URL = %v; want it refused", err)
+	}
+}
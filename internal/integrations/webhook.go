@@ -0,0 +1,137 @@
+package integrations
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mshan/go-tsk/pkg/webhook"
+)
+
+const (
+	// DeliveryHeader carries an ID that stays the same across retries of
+	// one delivery, so receivers can drop duplicates
+	DeliveryHeader = "X-Tsk-Delivery"
+
+	defaultWebhookTimeout    = 10 * time.Second
+	defaultWebhookRetryDelay = time.Second
+)
+
+// Webhook POSTs JSON payloads to a fixed URL
+type Webhook struct {
+	url        string
+	headers    map[string]string
+	signer     *webhook.Signer // nil when requests are not signed
+	retries    int
+	retryDelay time.Duration
+	client     *http.Client
+}
+
+// WebhookOption customizes a Webhook
+type WebhookOption func(*Webhook)
+
+// WithWebhookHeaders adds headers to every request
+func WithWebhookHeaders(headers map[string]string) WebhookOption {
+	return func(w *Webhook) {
+		w.headers = headers
+	}
+}
+
+// WithWebhookRetries retries a failed delivery up to n times, waiting delay
+// before the first retry and doubling it after each one
+func WithWebhookRetries(n int, delay time.Duration) WebhookOption {
+	return func(w *Webhook) {
+		w.retries = n
+		w.retryDelay = delay
+	}
+}
+
+// WithWebhookTimeout bounds each attempt
+func WithWebhookTimeout(d time.Duration) WebhookOption {
+	return func(w *Webhook) {
+		w.client.Timeout = d
+	}
+}
+
+// NewWebhook creates a webhook for url. With secrets, requests are signed
+// as described in package webhook.
+func NewWebhook(url string, secrets []string, opts ...WebhookOption) (*Webhook, error) {
+	w := &Webhook{
+		url:        url,
+		retryDelay: defaultWebhookRetryDelay,
+		client:     &http.Client{Timeout: defaultWebhookTimeout},
+	}
+	if len(secrets) > 0 {
+		var err error
+		if w.signer, err = webhook.NewSigner(secrets...); err != nil {
+			return nil, err
+		}
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w, nil
+}
+
+// Post delivers payload, retrying on network errors, 429 and 5xx
+// responses. deliveryKey identifies the delivery; its hash is sent in
+// DeliveryHeader.
+func (w *Webhook) Post(ctx context.Context, payload []byte, deliveryKey string) error {
+	sum := sha256.Sum256([]byte(deliveryKey))
+	delivery := hex.EncodeToString(sum[:16])
+
+	delay := w.retryDelay
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(ctx, payload, delivery)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= w.retries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%v; giving up: %w", err, ctx.Err())
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// post makes one attempt and reports whether a failure is worth retrying
+func (w *Webhook) post(ctx context.Context, payload []byte, delivery string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	for k, v := range w.headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(DeliveryHeader, delivery)
+	if w.signer != nil {
+		// Re-signed on every attempt, so retries carry a fresh timestamp
+		w.signer.Sign(req.Header, payload, time.Now())
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retry, fmt.Errorf("webhook returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	return false, nil
+}
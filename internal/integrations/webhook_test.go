@@ -0,0 +1,88 @@
+package integrations
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/pkg/webhook"
+)
+
+func TestWebhookPost(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int // Response status of each attempt; the last repeats
+		retries  int
+		attempts int
+		wantErr  bool
+	}{
+		{"success", []int{http.StatusNoContent}, 2, 1, false},
+		{"retried 5xx", []int{http.StatusBadGateway, http.StatusOK}, 2, 2, false},
+		{"retried 429", []int{http.StatusTooManyRequests, http.StatusOK}, 1, 2, false},
+		{"retries exhausted", []int{http.StatusInternalServerError}, 2, 3, true},
+		{"no retries", []int{http.StatusInternalServerError}, 0, 1, true},
+		{"4xx not retried", []int{http.StatusBadRequest}, 3, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			deliveries := make(map[string]bool)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				deliveries[r.Header.Get(DeliveryHeader)] = true
+				status := tt.statuses[len(tt.statuses)-1]
+				if attempts < len(tt.statuses) {
+					status = tt.statuses[attempts]
+				}
+				attempts++
+				w.WriteHeader(status)
+			}))
+			defer srv.Close()
+
+			w, err := NewWebhook(srv.URL, nil, WithWebhookRetries(tt.retries, time.Millisecond))
+			if err != nil {
+				t.Fatalf("NewWebhook() error = %v", err)
+			}
+			err = w.Post(context.Background(), []byte(`{}`), "primary/mid:<1@example.com>/0")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Post() error = %v; wantErr %v", err, tt.wantErr)
+			}
+			if attempts != tt.attempts {
+				t.Errorf("made %d attempts; want %d", attempts, tt.attempts)
+			}
+			if len(deliveries) != 1 {
+				t.Errorf("delivery IDs = %v; want one ID across retries", deliveries)
+			}
+		})
+	}
+}
+
+func TestWebhookSignedWithHeaders(t *testing.T) {
+	verifier := &webhook.Verifier{Secrets: []string{"s3cret"}}
+	var verifyErr error
+	var token, contentType string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, verifyErr = verifier.VerifyRequest(r)
+		io.Copy(io.Discard, r.Body)
+		token = r.Header.Get("X-Api-Token")
+		contentType = r.Header.Get("Content-Type")
+	}))
+	defer srv.Close()
+
+	w, err := NewWebhook(srv.URL, []string{"s3cret"}, WithWebhookHeaders(map[string]string{"X-Api-Token": "abc"}))
+	if err != nil {
+		t.Fatalf("NewWebhook() error = %v", err)
+	}
+	if err := w.Post(context.Background(), []byte(`{"subject": "hi"}`), "key"); err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	if verifyErr != nil {
+		t.Errorf("signature did not verify: %v", verifyErr)
+	}
+	if token != "abc" || contentType != "application/json" {
+		t.Errorf("headers = %q, %q", token, contentType)
+	}
+}
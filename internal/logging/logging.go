@@ -0,0 +1,131 @@
+// Package logging keeps mailbox contents and credentials out of the log
+package logging
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// Mode is how much of sensitive values the log shows
+type Mode int32
+
+const (
+	// None logs values verbatim
+	None Mode = iota
+	// Hashed logs a short hash instead of each value, so log lines about
+	// the same message or sender can still be correlated
+	Hashed
+	// Full logs a placeholder instead of each value
+	Full
+)
+
+// mode is the Mode of the standard logger
+var mode atomic.Int32
+
+// ParseMode parses a Redact setting; empty means None
+func ParseMode(s string) (Mode, error) {
+	switch s {
+	case "", "none":
+		return None, nil
+	case "hashed":
+		return Hashed, nil
+	case "full":
+		return Full, nil
+	default:
+		return None, fmt.Errorf("unknown redaction mode %q", s)
+	}
+}
+
+// Setup sets the redaction mode and routes the standard logger through a
+// Writer, which also catches addresses and tokens inside error messages
+func Setup(m Mode, w io.Writer) {
+	mode.Store(int32(m))
+	log.SetOutput(NewWriter(w))
+}
+
+// current returns the redaction mode in effect
+func current() Mode {
+	return Mode(mode.Load())
+}
+
+// Value is a sensitive value to be logged; it formats according to the
+// redaction mode
+type Value struct {
+	kind  string
+	value string
+}
+
+// Subject marks a message subject, or text derived from it such as a task
+// title, for redaction
+func Subject(s string) Value { return Value{"subject", s} }
+
+// Address marks an email address for redaction
+func Address(s string) Value { return Value{"address", s} }
+
+// Text marks message or notification text for redaction
+func Text(s string) Value { return Value{"text", s} }
+
+// String returns the value as the redaction mode allows
+func (v Value) String() string {
+	return redact(current(), v.kind, v.value)
+}
+
+// redact renders a value of kind under m
+func redact(m Mode, kind, value string) string {
+	switch m {
+	case Hashed:
+		if kind == "address" {
+			// Addresses are case-insensitive in practice
+			value = strings.ToLower(value)
+		}
+		sum := sha256.Sum256([]byte(value))
+		return kind + ":" + hex.EncodeToString(sum[:4])
+	case Full:
+		return "[" + kind + "]"
+	default:
+		return value
+	}
+}
+
+var (
+	// addressPattern matches email addresses
+	addressPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`)
+
+	// tokenPattern matches bearer credentials and the token formats of the
+	// services go-tsk talks to: Google access and refresh tokens, GitHub
+	// tokens and Vault tokens
+	tokenPattern = regexp.MustCompile(`(?i:bearer)\s+[A-Za-z0-9._~+/\-]+=*|ya29\.[A-Za-z0-9._\-]+|1//[A-Za-z0-9._\-]+|gh[pousr]_[A-Za-z0-9]+|github_pat_[A-Za-z0-9_]+|hv[sbr]\.[A-Za-z0-9._\-]+`)
+)
+
+// Writer scrubs addresses and tokens from log lines written to it, unless
+// the redaction mode is None. Tokens are always replaced by a placeholder,
+// never hashed.
+type Writer struct {
+	w io.Writer
+}
+
+// NewWriter creates a Writer writing scrubbed lines to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (s *Writer) Write(p []byte) (int, error) {
+	m := current()
+	if m == None {
+		return s.w.Write(p)
+	}
+	line := tokenPattern.ReplaceAll(p, []byte("[token]"))
+	line = addressPattern.ReplaceAllFunc(line, func(addr []byte) []byte {
+		return []byte(redact(m, "address", string(addr)))
+	})
+	if _, err := s.w.Write(line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
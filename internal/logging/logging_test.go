@@ -0,0 +1,81 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRedaction(t *testing.T) {
+	defer func() {
+		mode.Store(int32(None))
+		log.SetOutput(os.Stderr)
+	}()
+
+	line := func(m Mode) string {
+		var buf bytes.Buffer
+		Setup(m, &buf)
+		log.SetFlags(0)
+		defer log.SetFlags(log.LstdFlags)
+		log.Printf("Created task for %s from %s: auth failed for Bearer abc.def (token ya29.a0AfH6SM)",
+			Subject("Invoice 42"), Address("Alice@Example.com"))
+		return strings.TrimSpace(buf.String())
+	}
+
+	tests := []struct {
+		mode     Mode
+		want     []string
+		wantMiss []string
+	}{
+		{None, []string{"Invoice 42", "Alice@Example.com", "Bearer abc.def", "ya29.a0AfH6SM"}, nil},
+		{Hashed, []string{"subject:", "address:", "[token]"}, []string{"Invoice", "Alice", "abc.def", "ya29"}},
+		{Full, []string{"[subject]", "[address]", "[token]"}, []string{"Invoice", "Alice", "abc.def", "ya29"}},
+	}
+	for _, tt := range tests {
+		got := line(tt.mode)
+		for _, s := range tt.want {
+			if !strings.Contains(got, s) {
+				t.Errorf("mode %d: %q does not contain %q", tt.mode, got, s)
+			}
+		}
+		for _, s := range tt.wantMiss {
+			if strings.Contains(got, s) {
+				t.Errorf("mode %d: %q leaks %q", tt.mode, got, s)
+			}
+		}
+	}
+}
+
+func TestHashedValuesCorrelate(t *testing.T) {
+	defer mode.Store(int32(None))
+	mode.Store(int32(Hashed))
+
+	a := Address("alice@example.com").String()
+	if b := Address("ALICE@example.com").String(); a != b {
+		t.Errorf("hashes of the same address differ: %s, %s", a, b)
+	}
+	if c := Address("bob@example.com").String(); a == c {
+		t.Errorf("hashes of different addresses are equal: %s", a)
+	}
+
+	// An address found by the writer hashes like a marked one
+	var buf bytes.Buffer
+	fmt.Fprintf(NewWriter(&buf), "from alice@example.com")
+	if got := buf.String(); got != "from "+a {
+		t.Errorf("writer logged %q; want %q", got, "from "+a)
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for s, want := range map[string]Mode{"": None, "none": None, "hashed": Hashed, "full": Full} {
+		if got, err := ParseMode(s); err != nil || got != want {
+			t.Errorf("ParseMode(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+	if _, err := ParseMode("partial"); err == nil {
+		t.Error("ParseMode(partial) succeeded")
+	}
+}
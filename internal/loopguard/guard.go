@@ -0,0 +1,196 @@
+package loopguard
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+const (
+	// ChainHeader counts how many go-tsk actions a message has passed
+	// through; forward and reply actions copy it incremented
+	ChainHeader = "X-Tsk-Chain"
+
+	defaultMaxChain      = 3
+	defaultMaxLabelFlips = 4
+	defaultFlipWindow    = time.Hour
+)
+
+var (
+	// ErrSelfGenerated is returned for messages go-tsk sent itself
+	ErrSelfGenerated = errors.New("message was generated by go-tsk")
+	// ErrChainTooLong is returned when acting on a message would exceed
+	// the configured chain length
+	ErrChainTooLong = errors.New("action chain too long")
+	// ErrOscillation is returned when rules keep adding and removing the
+	// same label on a message
+	ErrOscillation = errors.New("label oscillating between rules")
+)
+
+// Registry remembers the Message-IDs of messages go-tsk generated
+type Registry interface {
+	IsGenerated(messageID string) (bool, error)
+	RecordGenerated(accountID, messageID string, at time.Time) error
+}
+
+// Guard stops go-tsk from acting on its own mail, from forwarding or
+// replying in endless chains, and from rules fighting over a label
+type Guard struct {
+	registry      Registry
+	maxChain      int
+	maxLabelFlips int
+	flipWindow    time.Duration
+	now           func() time.Time
+
+	mu    sync.Mutex
+	flips map[string][]labelOp // key is message key + "\x00" + label
+}
+
+// labelOp is one recorded add or removal of a label
+type labelOp struct {
+	added bool
+	at    time.Time
+}
+
+// New creates a guard. registry may be nil, in which case generated
+// Message-IDs are only remembered in memory.
+func New(cfg config.LoopConfig, registry Registry) *Guard {
+	if registry == nil {
+		registry = newMemRegistry()
+	}
+	g := &Guard{
+		registry:      registry,
+		maxChain:      cfg.MaxChain,
+		maxLabelFlips: cfg.MaxLabelFlips,
+		flipWindow:    cfg.FlipWindow,
+		now:           time.Now,
+		flips:         make(map[string][]labelOp),
+	}
+	if g.maxChain <= 0 {
+		g.maxChain = defaultMaxChain
+	}
+	if g.maxLabelFlips <= 0 {
+		g.maxLabelFlips = defaultMaxLabelFlips
+	}
+	if g.flipWindow <= 0 {
+		g.flipWindow = defaultFlipWindow
+	}
+	return g
+}
+
+// NewMessageID returns a fresh Message-ID for a message an action sends
+// and records it, so the message is ignored if it comes back
+func (g *Guard) NewMessageID(accountID, host string) (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := fmt.Sprintf("<%s.tsk@%s>", hex.EncodeToString(b), host)
+	if err := g.registry.RecordGenerated(accountID, id, g.now()); err != nil {
+		return "", fmt.Errorf("failed to record generated message: %w", err)
+	}
+	return id, nil
+}
+
+// CheckMessage returns ErrSelfGenerated if go-tsk sent msg
+func (g *Guard) CheckMessage(msg *email.Email) error {
+	if msg.MessageID == "" {
+		return nil
+	}
+	generated, err := g.registry.IsGenerated(msg.MessageID)
+	if err != nil {
+		return fmt.Errorf("failed to check generated messages: %w", err)
+	}
+	if generated {
+		return ErrSelfGenerated
+	}
+	return nil
+}
+
+// NextChain returns the chain length for a message sent in response to one
+// with header h, or ErrChainTooLong if sending it would exceed the limit
+func (g *Guard) NextChain(h textproto.MIMEHeader) (int, error) {
+	n, _ := strconv.Atoi(h.Get(ChainHeader))
+	if n < 0 {
+		n = 0
+	}
+	if n+1 > g.maxChain {
+		return n, ErrChainTooLong
+	}
+	return n + 1, nil
+}
+
+// RecordLabel records that a rule added (or removed) label on the message
+// with the given key, and returns ErrOscillation once the label has
+// flipped more than the allowed number of times within the window
+func (g *Guard) RecordLabel(key, label string, added bool) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	id := key + "\x00" + label
+	ops := g.flips[id]
+
+	// Drop operations that fell out of the window
+	i := 0
+	for i < len(ops) && now.Sub(ops[i].at) > g.flipWindow {
+		i++
+	}
+	ops = append(ops[i:], labelOp{added: added, at: now})
+	g.flips[id] = ops
+
+	flips := 0
+	for j := 1; j < len(ops); j++ {
+		if ops[j].added != ops[j-1].added {
+			flips++
+		}
+	}
+	if flips > g.maxLabelFlips {
+		return ErrOscillation
+	}
+	return nil
+}
+
+// Prune forgets label history older than the window, bounding memory
+func (g *Guard) Prune() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	for id, ops := range g.flips {
+		if now.Sub(ops[len(ops)-1].at) > g.flipWindow {
+			delete(g.flips, id)
+		}
+	}
+}
+
+// memRegistry is the Registry used without a state store
+type memRegistry struct {
+	mu  sync.Mutex
+	ids map[string]bool
+}
+
+func newMemRegistry() *memRegistry {
+	return &memRegistry{ids: make(map[string]bool)}
+}
+
+func (r *memRegistry) IsGenerated(messageID string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ids[messageID], nil
+}
+
+func (r *memRegistry) RecordGenerated(accountID, messageID string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ids[messageID] = true
+	return nil
+}
@@ -0,0 +1,105 @@
+package loopguard
+
+import (
+	"errors"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+func TestCheckMessage(t *testing.T) {
+	g := New(config.LoopConfig{}, nil)
+	id, err := g.NewMessageID("primary", "example.com")
+	if err != nil {
+		t.Fatalf("NewMessageID() error = %v", err)
+	}
+	if !strings.HasSuffix(id, "@example.com>") {
+		t.Errorf("NewMessageID() = %q", id)
+	}
+
+	tests := []struct {
+		name      string
+		messageID string
+		expected  error
+	}{
+		{"generated", id, ErrSelfGenerated},
+		{"other", "<other@example.com>", nil},
+		{"no message id", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := g.CheckMessage(&email.Email{MessageID: tt.messageID}); !errors.Is(err, tt.expected) {
+				t.Errorf("CheckMessage() error = %v; want %v", err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNextChain(t *testing.T) {
+	g := New(config.LoopConfig{MaxChain: 2}, nil)
+
+	tests := []struct {
+		header   string
+		expected int
+		wantErr  bool
+	}{
+		{"", 1, false},
+		{"1", 2, false},
+		{"2", 2, true},
+		{"garbage", 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			h := textproto.MIMEHeader{}
+			if tt.header != "" {
+				h.Set(ChainHeader, tt.header)
+			}
+			got, err := g.NextChain(h)
+			if (err != nil) != tt.wantErr || (err == nil && got != tt.expected) {
+				t.Errorf("NextChain(%q) = %d, %v; want %d, wantErr %v", tt.header, got, err, tt.expected, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRecordLabelOscillation(t *testing.T) {
+	now := time.Date(2024, 3, 5, 9, 0, 0, 0, time.UTC)
+	g := New(config.LoopConfig{MaxLabelFlips: 2, FlipWindow: time.Hour}, nil)
+	g.now = func() time.Time { return now }
+
+	// Repeated adds are not flips
+	for i := 0; i < 5; i++ {
+		if err := g.RecordLabel("k", "imp", true); err != nil {
+			t.Fatalf("RecordLabel(add) #%d error = %v", i, err)
+		}
+	}
+
+	// add, remove, add: 2 flips are tolerated, the third trips the guard
+	if err := g.RecordLabel("k", "imp", false); err != nil {
+		t.Fatalf("first flip error = %v", err)
+	}
+	if err := g.RecordLabel("k", "imp", true); err != nil {
+		t.Fatalf("second flip error = %v", err)
+	}
+	if err := g.RecordLabel("k", "imp", false); !errors.Is(err, ErrOscillation) {
+		t.Fatalf("third flip error = %v; want ErrOscillation", err)
+	}
+
+	// Other labels and messages are tracked separately
+	if err := g.RecordLabel("k", "other", false); err != nil {
+		t.Errorf("other label error = %v", err)
+	}
+
+	// Flips outside the window are forgotten
+	now = now.Add(2 * time.Hour)
+	g.Prune()
+	if err := g.RecordLabel("k", "imp", true); err != nil {
+		t.Errorf("flip after window error = %v", err)
+	}
+}
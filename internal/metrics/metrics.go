@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"expvar"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// accounts holds one expvar map per account ID, published as "accounts"
+var (
+	accounts = expvar.NewMap("accounts")
+	mu       sync.Mutex
+)
+
+// rules holds one expvar map per rule, keyed by the rule's index in the
+// config, published as "rules"
+var rules = expvar.NewMap("rules")
+
+// accountMap returns the metrics map for an account, creating it if needed
+func accountMap(accountID string) *expvar.Map {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if m, ok := accounts.Get(accountID).(*expvar.Map); ok {
+		return m
+	}
+	m := new(expvar.Map).Init()
+	accounts.Set(accountID, m)
+	return m
+}
+
+// Add increments a counter for the given account
+func Add(accountID, name string, delta int64) {
+	accountMap(accountID).Add(name, delta)
+}
+
+// Set sets a gauge for the given account
+func Set(accountID, name string, value int64) {
+	m := accountMap(accountID)
+	v, ok := m.Get(name).(*expvar.Int)
+	if !ok {
+		v = new(expvar.Int)
+		m.Set(name, v)
+	}
+	v.Set(value)
+}
+
+// Get returns the current value of a counter or gauge, or 0 if unset
+func Get(accountID, name string) int64 {
+	if v, ok := accountMap(accountID).Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+// AddRule increments a counter for the rule with the given index
+func AddRule(index int, name string, delta int64) {
+	key := strconv.Itoa(index)
+	mu.Lock()
+	m, ok := rules.Get(key).(*expvar.Map)
+	if !ok {
+		m = new(expvar.Map).Init()
+		rules.Set(key, m)
+	}
+	mu.Unlock()
+	m.Add(name, delta)
+}
+
+// GetRule returns the current value of a rule's counter, or 0 if unset
+func GetRule(index int, name string) int64 {
+	m, ok := rules.Get(strconv.Itoa(index)).(*expvar.Map)
+	if !ok {
+		return 0
+	}
+	if v, ok := m.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+// ResetRules clears the rule counters, as when the rules are reloaded and
+// indexes no longer mean the same rules
+func ResetRules() {
+	mu.Lock()
+	defer mu.Unlock()
+	rules.Init()
+}
+
+// ListenAndServe exposes all metrics as JSON on /debug/vars
+func ListenAndServe(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	return http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,53 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/logging"
+)
+
+// newChannel creates a channel for the given configuration
+func newChannel(cfg config.ChannelConfig) (Channel, error) {
+	switch cfg.Type {
+	case "log", "":
+		return &logChannel{name: cfg.Name}, nil
+	case "stdout":
+		return &writerChannel{name: cfg.Name, w: os.Stdout}, nil
+	case "smtp":
+		return newSMTPChannel(cfg)
+	case "slack":
+		return newSlackChannel(cfg)
+	default:
+		return nil, fmt.Errorf("unknown channel type %q", cfg.Type)
+	}
+}
+
+// logChannel writes notifications to the standard logger
+type logChannel struct {
+	name string
+}
+
+func (c *logChannel) Name() string { return c.name }
+
+func (c *logChannel) Send(ctx context.Context, msg Message) error {
+	log.Printf("[%s] %s\n%s", c.name, logging.Subject(msg.Subject), logging.Text(msg.Body))
+	return nil
+}
+
+// writerChannel writes notifications to an io.Writer
+type writerChannel struct {
+	name string
+	w    io.Writer
+}
+
+func (c *writerChannel) Name() string { return c.name }
+
+func (c *writerChannel) Send(ctx context.Context, msg Message) error {
+	_, err := fmt.Fprintf(c.w, "%s\n\n%s\n", msg.Subject, msg.Body)
+	return err
+}
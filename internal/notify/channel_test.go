@@ -0,0 +1,139 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+// fakeSMTP serves one SMTP session without extensions on a local port and
+// returns its address and the message data it receives
+func fakeSMTP(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	got := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tp := textproto.NewConn(conn)
+		tp.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			switch verb := strings.ToUpper(strings.Fields(line)[0]); verb {
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				data, err := tp.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				got <- string(data)
+				tp.PrintfLine("250 queued")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				return
+			default:
+				tp.PrintfLine("250 ok")
+			}
+		}
+	}()
+	return ln.Addr().String(), got
+}
+
+func TestSMTPChannel(t *testing.T) {
+	addr, got := fakeSMTP(t)
+	ch, err := newChannel(config.ChannelConfig{
+		Name:       "mail",
+		Type:       "smtp",
+		SMTPServer: addr,
+		From:       "tsk@example.com",
+		To:         []string{"me@example.com", "ops@example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := Message{Subject: "Primary: 2 messages matched – ünïcode", Body: "First\nSecond", ContentType: "text/plain; charset=utf-8"}
+	if err := ch.Send(context.Background(), msg); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	data := <-got
+	for _, want := range []string{
+		"From: tsk@example.com\n",
+		"To: me@example.com, ops@example.com\n",
+		"Subject: =?utf-8?q?",
+		"Auto-Submitted: auto-generated\n",
+		"Content-Type: text/plain; charset=utf-8\n",
+		"\n\nFirst\nSecond",
+	} {
+		if !strings.Contains(data, want) {
+			t.Errorf("message lacks %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestSlackChannel(t *testing.T) {
+	var text string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ Text string }
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		text = body.Text
+	}))
+	defer srv.Close()
+
+	ch, err := newChannel(config.ChannelConfig{Name: "team", Type: "slack", SlackWebhookURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.Send(context.Background(), Message{Subject: "Primary: 1 message matched", Body: "1. Invoice"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if want := "*Primary: 1 message matched*\n\n1. Invoice"; text != want {
+		t.Errorf("text = %q; want %q", text, want)
+	}
+}
+
+func TestNewChannelErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.ChannelConfig
+	}{
+		{"smtp without port", config.ChannelConfig{Type: "smtp", SMTPServer: "smtp.example.com", From: "a@example.com", To: []string{"b@example.com"}}},
+		{"smtp without recipients", config.ChannelConfig{Type: "smtp", SMTPServer: "smtp.example.com:587", From: "a@example.com"}},
+		{"smtp address with newline", config.ChannelConfig{Type: "smtp", SMTPServer: "smtp.example.com:587", From: "a@example.com\nBcc: x@example.com", To: []string{"b@example.com"}}},
+		{"slack without URL", config.ChannelConfig{Type: "slack"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newChannel(tt.cfg); err == nil {
+				t.Error("newChannel() succeeded")
+			}
+		})
+	}
+
+	if _, err := New(config.NotifyConfig{Channels: []config.ChannelConfig{
+		{Name: "team", Type: "slack", Format: FormatHTML, SlackWebhookURL: "https://hooks.slack.com/services/x", Enabled: true},
+	}}); err == nil {
+		t.Error("New() accepted a slack channel with the html format")
+	}
+}
@@ -0,0 +1,134 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"sort"
+	"strings"
+)
+
+// Supported notification formats
+const (
+	FormatHTML  = "html"
+	FormatPlain = "plain"
+)
+
+// Verbosity levels for the plain-text format
+const (
+	VerbosityBrief   = "brief"
+	VerbosityNormal  = "normal"
+	VerbosityVerbose = "verbose"
+)
+
+// Formatter renders a digest into a deliverable message
+type Formatter interface {
+	Format(d Digest) (Message, error)
+}
+
+// NewFormatter returns the formatter for the given format and verbosity
+func NewFormatter(format, verbosity string) (Formatter, error) {
+	switch format {
+	case FormatHTML, "":
+		return htmlFormatter{}, nil
+	case FormatPlain:
+		switch verbosity {
+		case VerbosityBrief, VerbosityNormal, VerbosityVerbose:
+		case "":
+			verbosity = VerbosityNormal
+		default:
+			return nil, fmt.Errorf("unknown verbosity %q", verbosity)
+		}
+		return plainFormatter{verbosity: verbosity}, nil
+	case FormatJSON:
+		return NewJSONFormatter(CurrentSchemaVersion)
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// digestSubject builds the subject line shared by all formats
+func digestSubject(d Digest) string {
+	return fmt.Sprintf("%s: %s matched", d.Account, countNoun(len(d.Entries), "message", "messages"))
+}
+
+// countNoun formats a count with the correct singular or plural noun
+func countNoun(n int, singular, plural string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s", singular)
+	}
+	return fmt.Sprintf("%d %s", n, plural)
+}
+
+// sortedEntries returns the entries in the order they were received
+func sortedEntries(d Digest) []Entry {
+	entries := make([]Entry, len(d.Entries))
+	copy(entries, d.Entries)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Date.Before(entries[j].Date)
+	})
+	return entries
+}
+
+var htmlTemplate = template.Must(template.New("digest").Parse(`<h2>{{.Account}}</h2>
+<table>
+<tr><th>Received</th><th>From</th><th>Subject</th><th>Rule</th></tr>
+{{range .Entries}}<tr><td>{{.Date.Format "2006-01-02 15:04"}}</td><td>{{.From}}</td><td>{{.Subject}}</td><td>{{.Rule}}</td></tr>
+{{end}}</table>
+`))
+
+// htmlFormatter renders digests as an HTML table
+type htmlFormatter struct{}
+
+func (htmlFormatter) Format(d Digest) (Message, error) {
+	var buf bytes.Buffer
+	data := Digest{Account: d.Account, Entries: sortedEntries(d)}
+	if err := htmlTemplate.Execute(&buf, data); err != nil {
+		return Message{}, err
+	}
+	return Message{
+		Subject:     digestSubject(d),
+		Body:        buf.String(),
+		ContentType: "text/html; charset=utf-8",
+	}, nil
+}
+
+// plainFormatter renders digests as linear plain text suited to screen
+// readers: no tables or decorative characters, a summary first, then one
+// labelled field per line in the order messages were received.
+type plainFormatter struct {
+	verbosity string
+}
+
+func (f plainFormatter) Format(d Digest) (Message, error) {
+	entries := sortedEntries(d)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s matched for %s.\n", countNoun(len(entries), "message", "messages"), d.Account)
+
+	for i, e := range entries {
+		b.WriteString("\n")
+		if f.verbosity == VerbosityBrief {
+			fmt.Fprintf(&b, "%d. %s\n", i+1, e.Subject)
+			continue
+		}
+
+		fmt.Fprintf(&b, "Message %d of %d.\n", i+1, len(entries))
+		fmt.Fprintf(&b, "Subject: %s\n", e.Subject)
+		fmt.Fprintf(&b, "From: %s\n", e.From)
+		fmt.Fprintf(&b, "Received: %s\n", e.Date.Format("Monday 2 January 2006 at 15:04"))
+		if f.verbosity == VerbosityVerbose {
+			fmt.Fprintf(&b, "Account: %s\n", e.Account)
+			fmt.Fprintf(&b, "Rule: %s\n", e.Rule)
+			if e.Label != "" {
+				fmt.Fprintf(&b, "Label: %s\n", e.Label)
+			}
+		}
+	}
+
+	return Message{
+		Subject:     digestSubject(d),
+		Body:        b.String(),
+		ContentType: "text/plain; charset=utf-8",
+	}, nil
+}
@@ -0,0 +1,110 @@
+package notify
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func testDigest() Digest {
+	return Digest{
+		Account: "Primary Gmail",
+		Entries: []Entry{
+			{
+				Account:   "Primary Gmail",
+				MessageID: "<second@example.com>",
+				Subject:   "Second",
+				From:      "bob@example.com",
+				Date:      time.Date(2024, 3, 5, 10, 30, 0, 0, time.UTC),
+				Rule:      "subject contains second",
+			},
+			{
+				Account: "Primary Gmail",
+				Subject: "First",
+				From:    "alice@example.com",
+				Date:    time.Date(2024, 3, 5, 9, 15, 0, 0, time.UTC),
+				Rule:    "subject contains first",
+				Label:   "imp",
+			},
+		},
+	}
+}
+
+func TestPlainFormatter(t *testing.T) {
+	tests := []struct {
+		name      string
+		verbosity string
+		contains  []string
+		excludes  []string
+	}{
+		{
+			"brief",
+			VerbosityBrief,
+			[]string{"2 messages matched for Primary Gmail.", "1. First\n", "2. Second\n"},
+			[]string{"From:", "Rule:"},
+		},
+		{
+			"normal",
+			VerbosityNormal,
+			[]string{"Message 1 of 2.\nSubject: First\nFrom: alice@example.com\nReceived: Tuesday 5 March 2024 at 09:15\n"},
+			[]string{"Rule:", "<"},
+		},
+		{
+			"verbose",
+			VerbosityVerbose,
+			[]string{"Rule: subject contains first\nLabel: imp\n", "Account: Primary Gmail\n"},
+			[]string{"<table>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewFormatter(FormatPlain, tt.verbosity)
+			if err != nil {
+				t.Fatalf("NewFormatter() error = %v", err)
+			}
+			msg, err := f.Format(testDigest())
+			if err != nil {
+				t.Fatalf("Format() error = %v", err)
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(msg.Body, want) {
+					t.Errorf("body missing %q:\n%s", want, msg.Body)
+				}
+			}
+			for _, unwanted := range tt.excludes {
+				if strings.Contains(msg.Body, unwanted) {
+					t.Errorf("body unexpectedly contains %q:\n%s", unwanted, msg.Body)
+				}
+			}
+			if strings.Index(msg.Body, "First") > strings.Index(msg.Body, "Second") {
+				t.Errorf("entries not in received order:\n%s", msg.Body)
+			}
+		})
+	}
+}
+
+func TestNewFormatter(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    string
+		verbosity string
+		wantErr   bool
+	}{
+		{"default", "", "", false},
+		{"html", FormatHTML, "", false},
+		{"plain", FormatPlain, "", false},
+		{"json", FormatJSON, "", false},
+		{"unknown format", "markdown", "", true},
+		{"unknown verbosity", FormatPlain, "chatty", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewFormatter(tt.format, tt.verbosity)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewFormatter(%q, %q) error = %v; wantErr %v", tt.format, tt.verbosity, err, tt.wantErr)
+			}
+		})
+	}
+}
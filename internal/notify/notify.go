@@ -0,0 +1,114 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+// Entry is a single matched email included in a notification
+type Entry struct {
+	Account   string
+	MessageID string
+	Subject   string
+	From      string
+	Date      time.Time
+	Rule      string
+	Label     string
+}
+
+// Digest groups the entries produced by one account
+type Digest struct {
+	Account string
+	Entries []Entry
+}
+
+// Message is a rendered notification ready to be delivered
+type Message struct {
+	Subject     string
+	Body        string
+	ContentType string
+}
+
+// Channel delivers rendered messages to a destination
+type Channel interface {
+	Name() string
+	Send(ctx context.Context, msg Message) error
+}
+
+// route pairs a channel with the formatter selected for it
+type route struct {
+	channel   Channel
+	formatter Formatter
+}
+
+// Notifier fans digests out to all configured channels
+type Notifier struct {
+	routes []route
+}
+
+// New creates a notifier from the channel configuration
+func New(cfg config.NotifyConfig) (*Notifier, error) {
+	n := &Notifier{}
+	for _, chCfg := range cfg.Channels {
+		if !chCfg.Enabled {
+			continue
+		}
+
+		formatter, err := newFormatter(chCfg)
+		if err != nil {
+			return nil, fmt.Errorf("channel %s: %w", chCfg.Name, err)
+		}
+
+		channel, err := newChannel(chCfg)
+		if err != nil {
+			return nil, fmt.Errorf("channel %s: %w", chCfg.Name, err)
+		}
+
+		n.routes = append(n.routes, route{channel: channel, formatter: formatter})
+	}
+	return n, nil
+}
+
+// newFormatter returns the formatter configured for a channel
+func newFormatter(cfg config.ChannelConfig) (Formatter, error) {
+	// Slack shows text, not HTML
+	if cfg.Type == "slack" {
+		switch cfg.Format {
+		case "":
+			cfg.Format = FormatPlain
+		case FormatHTML:
+			return nil, fmt.Errorf("slack channels take the %s or %s format", FormatPlain, FormatJSON)
+		}
+	}
+	if cfg.Format == FormatJSON {
+		return NewJSONFormatter(cfg.SchemaVersion)
+	}
+	if cfg.SchemaVersion != 0 {
+		return nil, fmt.Errorf("schema version only applies to the %s format", FormatJSON)
+	}
+	return NewFormatter(cfg.Format, cfg.Verbosity)
+}
+
+// Send renders the digest for every channel and delivers it
+func (n *Notifier) Send(ctx context.Context, d Digest) error {
+	if len(d.Entries) == 0 {
+		return nil
+	}
+
+	var firstErr error
+	for _, r := range n.routes {
+		msg, err := r.formatter.Format(d)
+		if err != nil {
+			err = fmt.Errorf("failed to format digest for %s: %w", r.channel.Name(), err)
+		} else if err = r.channel.Send(ctx, msg); err != nil {
+			err = fmt.Errorf("failed to send digest to %s: %w", r.channel.Name(), err)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
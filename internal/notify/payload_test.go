@@ -0,0 +1,101 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden payload files for new schema versions")
+
+// TestJSONPayloadCompatibility pins every published schema version to its
+// golden file. A failure means a change would break consumers of that
+// version: add a new version instead of editing an existing encoder.
+func TestJSONPayloadCompatibility(t *testing.T) {
+	for version := 1; version <= CurrentSchemaVersion; version++ {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			f, err := NewJSONFormatter(version)
+			if err != nil {
+				t.Fatalf("NewJSONFormatter(%d) error = %v", version, err)
+			}
+			msg, err := f.Format(testDigest())
+			if err != nil {
+				t.Fatalf("Format() error = %v", err)
+			}
+
+			var indented bytes.Buffer
+			if err := json.Indent(&indented, []byte(msg.Body), "", "  "); err != nil {
+				t.Fatalf("payload is not valid JSON: %v", err)
+			}
+			indented.WriteByte('\n')
+
+			golden := filepath.Join("testdata", fmt.Sprintf("payload_v%d.json", version))
+			if *update {
+				if _, err := os.Stat(golden); err == nil {
+					t.Fatalf("%s already exists; published versions must not change", golden)
+				}
+				if err := os.WriteFile(golden, indented.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("missing golden file for schema version %d: %v", version, err)
+			}
+			if !bytes.Equal(indented.Bytes(), want) {
+				t.Errorf("schema version %d payload changed:\ngot:\n%s\nwant:\n%s", version, indented.Bytes(), want)
+			}
+		})
+	}
+}
+
+func TestJSONPayloadSchemaVersion(t *testing.T) {
+	for version := 1; version <= CurrentSchemaVersion; version++ {
+		f, err := NewJSONFormatter(version)
+		if err != nil {
+			t.Fatalf("NewJSONFormatter(%d) error = %v", version, err)
+		}
+		msg, err := f.Format(testDigest())
+		if err != nil {
+			t.Fatalf("Format() error = %v", err)
+		}
+
+		var payload struct {
+			SchemaVersion int `json:"schema_version"`
+		}
+		if err := json.Unmarshal([]byte(msg.Body), &payload); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if payload.SchemaVersion != version {
+			t.Errorf("v%d payload has schema_version %d", version, payload.SchemaVersion)
+		}
+	}
+}
+
+func TestNewJSONFormatter(t *testing.T) {
+	tests := []struct {
+		name    string
+		version int
+		wantErr bool
+	}{
+		{"latest by default", 0, false},
+		{"oldest", 1, false},
+		{"current", CurrentSchemaVersion, false},
+		{"future", CurrentSchemaVersion + 1, true},
+		{"negative", -1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewJSONFormatter(tt.version)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewJSONFormatter(%d) error = %v; wantErr %v", tt.version, err, tt.wantErr)
+			}
+		})
+	}
+}
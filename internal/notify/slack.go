@@ -0,0 +1,66 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+// slackChannel posts notifications to a Slack incoming webhook
+type slackChannel struct {
+	name   string
+	url    string
+	client *http.Client
+}
+
+// newSlackChannel creates a slack channel, checking its webhook URL
+func newSlackChannel(cfg config.ChannelConfig) (*slackChannel, error) {
+	u, err := url.Parse(cfg.SlackWebhookURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("slack channels need an http(s) SlackWebhookURL")
+	}
+	return &slackChannel{
+		name:   cfg.Name,
+		url:    cfg.SlackWebhookURL,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (c *slackChannel) Name() string { return c.name }
+
+func (c *slackChannel) Send(ctx context.Context, msg Message) error {
+	text := "*" + msg.Subject + "*\n\n" + msg.Body
+	if strings.HasPrefix(msg.ContentType, "application/json") {
+		text = "*" + msg.Subject + "*\n```" + msg.Body + "```"
+	}
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("slack request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("slack returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+// smtpTimeout bounds one delivery, from connecting to QUIT
+const smtpTimeout = 30 * time.Second
+
+// smtpChannel emails notifications through an SMTP server
+type smtpChannel struct {
+	name     string
+	server   string // host:port
+	host     string
+	username string
+	password string
+	from     string
+	to       []string
+	now      func() time.Time
+}
+
+// newSMTPChannel creates an smtp channel, checking its settings
+func newSMTPChannel(cfg config.ChannelConfig) (*smtpChannel, error) {
+	host, port, err := net.SplitHostPort(cfg.SMTPServer)
+	if err != nil || host == "" || port == "" {
+		return nil, fmt.Errorf("smtp channels need SMTPServer as host:port, got %q", cfg.SMTPServer)
+	}
+	if cfg.From == "" || len(cfg.To) == 0 {
+		return nil, fmt.Errorf("smtp channels need From and To")
+	}
+	for _, addr := range append([]string{cfg.From}, cfg.To...) {
+		if strings.ContainsAny(addr, "\r\n") {
+			return nil, fmt.Errorf("invalid address %q", addr)
+		}
+	}
+	return &smtpChannel{
+		name:     cfg.Name,
+		server:   cfg.SMTPServer,
+		host:     host,
+		username: cfg.SMTPUsername,
+		password: cfg.SMTPPassword,
+		from:     cfg.From,
+		to:       cfg.To,
+		now:      time.Now,
+	}, nil
+}
+
+func (c *smtpChannel) Name() string { return c.name }
+
+// Send delivers msg to every recipient, upgrading to TLS when the server
+// offers STARTTLS. Logging in needs TLS, unless the server is local.
+func (c *smtpChannel) Send(ctx context.Context, msg Message) error {
+	data, err := c.compose(msg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, smtpTimeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", c.server)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", c.server, err)
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, c.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: c.host}); err != nil {
+			return fmt.Errorf("STARTTLS failed: %w", err)
+		}
+	}
+	if c.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", c.username, c.password, c.host)); err != nil {
+			return fmt.Errorf("login failed: %w", err)
+		}
+	}
+	if err := client.Mail(c.from); err != nil {
+		return err
+	}
+	for _, to := range c.to {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s rejected: %w", to, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// compose renders msg as an email. Auto-Submitted keeps auto-responders
+// from answering it (RFC 3834).
+func (c *smtpChannel) compose(msg Message) ([]byte, error) {
+	var buf bytes.Buffer
+	header := func(name, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+	header("From", c.from)
+	header("To", strings.Join(c.to, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", c.now().Format(time.RFC1123Z))
+	header("Auto-Submitted", "auto-generated")
+	header("MIME-Version", "1.0")
+	header("Content-Type", msg.ContentType)
+	header("Content-Transfer-Encoding", "quoted-printable")
+	buf.WriteString("\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	if _, err := qp.Write([]byte(msg.Body)); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
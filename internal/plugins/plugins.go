@@ -0,0 +1,292 @@
+// Package plugins loads WebAssembly plugins that match and act on mail, so
+// extensions can be distributed as .wasm files without rebuilding go-tsk.
+//
+// A plugin exports its memory and tsk_alloc(size) returning a buffer of
+// size bytes, and at least one of:
+//
+//	tsk_match(ptr, len) -> 1 if the rule matches, 0 if not
+//	tsk_act(ptr, len)   -> 0 on success, anything else on failure
+//
+// Both get an Input as JSON at ptr. Plugins may import from the "go_tsk"
+// module:
+//
+//	log(ptr, len)   writes a message to the go-tsk log
+//	error(ptr, len) sets the error message of a failing call
+//
+// Plugins run sandboxed in wazero, with WASI but no file system, network
+// or clock access beyond what WASI grants without mounts, and within the
+// memory and time limits of their config.
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+// Defaults for unset limits of config.PluginConfig
+const (
+	defaultTimeout  = time.Second
+	defaultMemoryMB = 16
+)
+
+// wasmPageSize is the size of a WebAssembly memory page
+const wasmPageSize = 64 << 10
+
+// hostModule is the module plugins import host functions from
+const hostModule = "go_tsk"
+
+// Input is what a plugin gets for a message
+type Input struct {
+	Account string            `json:"account"`
+	Key     string            `json:"key,omitempty"` // Journal key of the message, for actions
+	Email   Email             `json:"email"`
+	Params  map[string]string `json:"params,omitempty"` // Params of the rule, for actions
+}
+
+// Email is a message as plugins see it
+type Email struct {
+	Mailbox   string    `json:"mailbox"`
+	UID       uint32    `json:"uid"`
+	MessageID string    `json:"message_id,omitempty"`
+	Subject   string    `json:"subject"`
+	From      string    `json:"from"`
+	Date      time.Time `json:"date"`
+	Flags     []string  `json:"flags"`
+	Size      uint32    `json:"size,omitempty"`
+	TextBody  string    `json:"text_body,omitempty"`
+	HTMLBody  string    `json:"html_body,omitempty"`
+}
+
+// NewEmail converts a message for plugins
+func NewEmail(e *email.Email) Email {
+	return Email{
+		Mailbox:   e.Mailbox,
+		UID:       e.UID,
+		MessageID: e.MessageID,
+		Subject:   e.Subject,
+		From:      e.From,
+		Date:      e.Date,
+		Flags:     e.Flags,
+		Size:      e.Size,
+		TextBody:  e.TextBody,
+		HTMLBody:  e.HTMLBody,
+	}
+}
+
+// Plugin is a loaded plugin. Calls are serialized, as a module instance
+// runs one call at a time.
+type Plugin struct {
+	name     string
+	timeout  time.Duration
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+
+	mu     sync.Mutex
+	module api.Module // nil until first use and after a call was cut short
+}
+
+// callState collects what a plugin reports during one call
+type callState struct {
+	err string
+}
+
+type callStateKey struct{}
+
+// Load compiles the plugin of cfg and instantiates it once to check its
+// exports
+func Load(ctx context.Context, cfg config.PluginConfig) (*Plugin, error) {
+	wasm, err := os.ReadFile(cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s: %w", cfg.Name, err)
+	}
+	memoryMB := cfg.MemoryMB
+	if memoryMB <= 0 {
+		memoryMB = defaultMemoryMB
+	}
+	p := &Plugin{name: cfg.Name, timeout: cfg.Timeout}
+	if p.timeout <= 0 {
+		p.timeout = defaultTimeout
+	}
+
+	// Closing modules when their context ends is what enforces timeouts
+	p.runtime = wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32(memoryMB*(1<<20)/wasmPageSize)).
+		WithCloseOnContextDone(true))
+	if err := p.init(ctx, wasm); err != nil {
+		p.runtime.Close(ctx)
+		return nil, fmt.Errorf("plugin %s: %w", cfg.Name, err)
+	}
+	return p, nil
+}
+
+// init sets up the host modules, compiles wasm and checks its exports
+func (p *Plugin) init(ctx context.Context, wasm []byte) error {
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, p.runtime); err != nil {
+		return err
+	}
+	_, err := p.runtime.NewHostModuleBuilder(hostModule).
+		NewFunctionBuilder().WithFunc(p.hostLog).Export("log").
+		NewFunctionBuilder().WithFunc(hostError).Export("error").
+		Instantiate(ctx)
+	if err != nil {
+		return err
+	}
+	if p.compiled, err = p.runtime.CompileModule(ctx, wasm); err != nil {
+		return err
+	}
+	exports := p.compiled.ExportedFunctions()
+	if _, ok := exports["tsk_alloc"]; !ok {
+		return errors.New("module does not export tsk_alloc")
+	}
+	if !p.Matches() && !p.Acts() {
+		return errors.New("module exports neither tsk_match nor tsk_act")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err = p.instance(ctx)
+	return err
+}
+
+// Name returns the name of the plugin
+func (p *Plugin) Name() string {
+	return p.name
+}
+
+// Matches reports whether the plugin exports tsk_match
+func (p *Plugin) Matches() bool {
+	_, ok := p.compiled.ExportedFunctions()["tsk_match"]
+	return ok
+}
+
+// Acts reports whether the plugin exports tsk_act
+func (p *Plugin) Acts() bool {
+	_, ok := p.compiled.ExportedFunctions()["tsk_act"]
+	return ok
+}
+
+// Match calls tsk_match
+func (p *Plugin) Match(ctx context.Context, in Input) (bool, error) {
+	result, err := p.call(ctx, "tsk_match", in)
+	if err != nil {
+		return false, err
+	}
+	switch result {
+	case 0:
+		return false, nil
+	case 1:
+		return true, nil
+	}
+	return false, fmt.Errorf("plugin %s: tsk_match returned %d", p.name, result)
+}
+
+// Act calls tsk_act
+func (p *Plugin) Act(ctx context.Context, in Input) error {
+	result, err := p.call(ctx, "tsk_act", in)
+	if err != nil {
+		return err
+	}
+	if result != 0 {
+		return fmt.Errorf("plugin %s: tsk_act failed with %d", p.name, result)
+	}
+	return nil
+}
+
+// Close releases the plugin's runtime
+func (p *Plugin) Close(ctx context.Context) error {
+	return p.runtime.Close(ctx)
+}
+
+// call writes in to the plugin's memory and calls fn with it
+func (p *Plugin) call(ctx context.Context, fn string, in Input) (uint32, error) {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return 0, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	state := &callState{}
+	ctx = context.WithValue(ctx, callStateKey{}, state)
+
+	mod, err := p.instance(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("plugin %s: %w", p.name, err)
+	}
+	res, err := mod.ExportedFunction("tsk_alloc").Call(ctx, uint64(len(data)))
+	if err != nil {
+		return 0, p.failed(ctx, err)
+	}
+	ptr := uint32(res[0])
+	if !mod.Memory().Write(ptr, data) {
+		return 0, fmt.Errorf("plugin %s: tsk_alloc returned a buffer outside its memory", p.name)
+	}
+	res, err = mod.ExportedFunction(fn).Call(ctx, uint64(ptr), uint64(len(data)))
+	if err != nil {
+		return 0, p.failed(ctx, err)
+	}
+	if state.err != "" {
+		return 0, fmt.Errorf("plugin %s: %s", p.name, state.err)
+	}
+	return uint32(res[0]), nil
+}
+
+// instance returns the plugin's module, instantiating it if needed. p.mu
+// must be held.
+func (p *Plugin) instance(ctx context.Context) (api.Module, error) {
+	if p.module != nil {
+		return p.module, nil
+	}
+	mod, err := p.runtime.InstantiateModule(ctx, p.compiled, wazero.NewModuleConfig().
+		WithName("").WithStartFunctions("_initialize"))
+	if err != nil {
+		return nil, err
+	}
+	p.module = mod
+	return mod, nil
+}
+
+// failed handles a call that trapped or ran out of time. The instance may
+// be left inconsistent, so the next call starts a fresh one. p.mu must be
+// held.
+func (p *Plugin) failed(ctx context.Context, err error) error {
+	p.module.Close(context.Background())
+	p.module = nil
+	if ctx.Err() != nil {
+		return fmt.Errorf("plugin %s: %w", p.name, ctx.Err())
+	}
+	return fmt.Errorf("plugin %s: %w", p.name, err)
+}
+
+// hostLog implements log(ptr, len)
+func (p *Plugin) hostLog(ctx context.Context, m api.Module, ptr, size uint32) {
+	if msg, ok := m.Memory().Read(ptr, size); ok {
+		log.Printf("Plugin %s: %s", p.name, msg)
+	}
+}
+
+// hostError implements error(ptr, len)
+func hostError(ctx context.Context, m api.Module, ptr, size uint32) {
+	state, ok := ctx.Value(callStateKey{}).(*callState)
+	if !ok {
+		return
+	}
+	if msg, ok := m.Memory().Read(ptr, size); ok {
+		state.err = string(msg)
+	}
+}
@@ -0,0 +1,97 @@
+package plugins
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+// load loads testdata/plugin.wasm, built from plugin.wat
+func load(t *testing.T, timeout time.Duration) *Plugin {
+	t.Helper()
+	p, err := Load(context.Background(), config.PluginConfig{Name: "test", Path: "testdata/plugin.wasm", Timeout: timeout})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	t.Cleanup(func() { p.Close(context.Background()) })
+	return p
+}
+
+func input(subject string) Input {
+	return Input{Account: "a", Key: "k", Email: Email{Mailbox: "INBOX", UID: 1, Subject: subject}}
+}
+
+func TestMatch(t *testing.T) {
+	p := load(t, 0)
+	if !p.Matches() || !p.Acts() {
+		t.Fatalf("Matches() = %v, Acts() = %v; want both", p.Matches(), p.Acts())
+	}
+
+	tests := []struct {
+		subject string
+		want    bool
+	}{
+		{"Urgent!", true},
+		{"Hello", false},
+	}
+	for _, tt := range tests {
+		got, err := p.Match(context.Background(), input(tt.subject))
+		if err != nil {
+			t.Fatalf("Match(%q): %v", tt.subject, err)
+		}
+		if got != tt.want {
+			t.Errorf("Match(%q) = %v; want %v", tt.subject, got, tt.want)
+		}
+	}
+}
+
+func TestAct(t *testing.T) {
+	p := load(t, 100*time.Millisecond)
+
+	tests := []struct {
+		name    string
+		subject string
+		wantErr string
+	}{
+		{"success", "Hello", ""},
+		{"reported error", "Ticket #1", "bad params"},
+		{"timeout", "~", "deadline exceeded"},
+		{"success after timeout", "Hello", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Act(context.Background(), input(tt.subject))
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Act() = %v; want success", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Act() = %v; want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.wasm")
+	if err := os.WriteFile(invalid, []byte("not wasm"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// A valid module with no exports
+	empty := filepath.Join(dir, "empty.wasm")
+	if err := os.WriteFile(empty, []byte("\x00asm\x01\x00\x00\x00"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{filepath.Join(dir, "missing.wasm"), invalid, empty} {
+		if p, err := Load(context.Background(), config.PluginConfig{Name: "test", Path: path}); err == nil {
+			p.Close(context.Background())
+			t.Errorf("Load(%s) succeeded; want an error", filepath.Base(path))
+		}
+	}
+}
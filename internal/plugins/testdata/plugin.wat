@@ -0,0 +1,76 @@
+;; Test plugin for the plugins package. Assemble with
+;;   wat2wasm plugin.wat -o plugin.wasm
+;;
+;; tsk_match accepts input containing "!". tsk_act logs "acted", then fails
+;; with "bad params" if its input contains "#" and never returns if it
+;; contains "~".
+(module
+  (import "go_tsk" "log" (func $log (param i32 i32)))
+  (import "go_tsk" "error" (func $error (param i32 i32)))
+  (memory (export "memory") 1)
+  (data (i32.const 16) "acted")
+  (data (i32.const 32) "bad params")
+
+  (func $alloc (export "tsk_alloc") (param $size i32) (result i32)
+    i32.const 1024)
+
+  (func $contains (param $ptr i32) (param $len i32) (param $byte i32) (result i32)
+    (local $end i32)
+    local.get $ptr
+    local.get $len
+    i32.add
+    local.set $end
+    block
+      loop
+        local.get $ptr
+        local.get $end
+        i32.ge_u
+        br_if 1
+        local.get $ptr
+        i32.load8_u
+        local.get $byte
+        i32.eq
+        if
+          i32.const 1
+          return
+        end
+        local.get $ptr
+        i32.const 1
+        i32.add
+        local.set $ptr
+        br 0
+      end
+    end
+    i32.const 0)
+
+  (func $match (export "tsk_match") (param $ptr i32) (param $len i32) (result i32)
+    local.get $ptr
+    local.get $len
+    i32.const 33
+    call $contains)
+
+  (func $act (export "tsk_act") (param $ptr i32) (param $len i32) (result i32)
+    i32.const 16
+    i32.const 5
+    call $log
+    local.get $ptr
+    local.get $len
+    i32.const 35
+    call $contains
+    if
+      i32.const 32
+      i32.const 10
+      call $error
+      i32.const 1
+      return
+    end
+    local.get $ptr
+    local.get $len
+    i32.const 126
+    call $contains
+    if
+      loop
+        br 0
+      end
+    end
+    i32.const 0))
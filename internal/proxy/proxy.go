@@ -0,0 +1,413 @@
+// Package proxy dials connections through SOCKS5 and HTTP CONNECT proxies,
+// for networks where mail and API servers are only reachable through an
+// egress proxy.
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Direct is the proxy URL that connects without a proxy, overriding a
+// global proxy or ALL_PROXY
+const Direct = "direct"
+
+// dialTimeout bounds connecting to the proxy when the caller's context has
+// no deadline
+const dialTimeout = 30 * time.Second
+
+// Dialer connects to addresses, directly or through a proxy
+type Dialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// Parse checks a proxy URL: socks5://, socks5h://, http:// or https://,
+// with optional user:password. Empty and Direct parse as nil.
+func Parse(raw string) (*url.URL, error) {
+	if raw == "" || raw == Direct {
+		return nil, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h", "http", "https":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q; use socks5, http or https", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy URL %q has no host", raw)
+	}
+	return u, nil
+}
+
+// FromEnvironment returns the proxy of the ALL_PROXY (or all_proxy)
+// environment variable, or "" if neither is set
+func FromEnvironment() string {
+	if v := os.Getenv("ALL_PROXY"); v != "" {
+		return v
+	}
+	return os.Getenv("all_proxy")
+}
+
+// New returns a dialer connecting through the proxy at raw. Empty raw uses
+// ALL_PROXY; Direct, or no proxy in the environment, dials directly. Hosts
+// listed in NO_PROXY are dialed directly too.
+func New(raw string) (Dialer, error) {
+	direct := &net.Dialer{Timeout: dialTimeout}
+	if raw == "" {
+		raw = FromEnvironment()
+	}
+	u, err := Parse(raw)
+	if err != nil || u == nil {
+		return direct, err
+	}
+	return &dialer{proxy: u, forward: direct, noProxy: noProxyList()}, nil
+}
+
+// Install makes the HTTP clients of the process that don't set their own
+// transport use the proxy at raw. Empty raw falls back to ALL_PROXY when
+// HTTP_PROXY and HTTPS_PROXY are unset, so one variable covers mail and
+// HTTP traffic; Direct ignores every proxy variable. Call it once at
+// startup, before any request is sent.
+func Install(raw string) error {
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return errors.New("the default HTTP transport has been replaced")
+	}
+	fn, err := ProxyFunc(raw)
+	if err != nil {
+		return err
+	}
+	transport.Proxy = fn
+	return nil
+}
+
+// Configured reports whether HTTP calls go through a proxy, set at raw or
+// in the environment, resolving an empty raw like Install
+func Configured(raw string) bool {
+	if raw == Direct {
+		return false
+	}
+	if raw != "" {
+		return true
+	}
+	for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy"} {
+		if os.Getenv(name) != "" {
+			return true
+		}
+	}
+	return FromEnvironment() != ""
+}
+
+// ProxyFunc returns the http.Transport.Proxy function of the proxy at raw,
+// resolving an empty raw like Install
+func ProxyFunc(raw string) (func(*http.Request) (*url.URL, error), error) {
+	if raw == Direct {
+		return nil, nil
+	}
+	if raw == "" {
+		if os.Getenv("HTTP_PROXY") != "" || os.Getenv("HTTPS_PROXY") != "" ||
+			os.Getenv("http_proxy") != "" || os.Getenv("https_proxy") != "" {
+			return http.ProxyFromEnvironment, nil
+		}
+		raw = FromEnvironment()
+	}
+	u, err := Parse(raw)
+	if err != nil || u == nil {
+		return http.ProxyFromEnvironment, err
+	}
+	noProxy := noProxyList()
+	return func(req *http.Request) (*url.URL, error) {
+		if bypass(noProxy, req.URL.Hostname()) {
+			return nil, nil
+		}
+		return u, nil
+	}, nil
+}
+
+// Transport returns a copy of the default HTTP transport using the proxy
+// at raw, for clients whose proxy differs from the process's
+func Transport(raw string) (*http.Transport, error) {
+	fn, err := ProxyFunc(raw)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = fn
+	return transport, nil
+}
+
+// dialer connects through a SOCKS5 or HTTP CONNECT proxy
+type dialer struct {
+	proxy   *url.URL
+	forward *net.Dialer
+	noProxy []string
+}
+
+func (d *dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if bypass(d.noProxy, host) {
+		return d.forward.DialContext(ctx, network, addr)
+	}
+
+	conn, err := d.forward.DialContext(ctx, "tcp", d.proxy.Host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to proxy %s: %w", d.proxy.Host, err)
+	}
+	if d.proxy.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: d.proxy.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to connect to proxy %s: %w", d.proxy.Host, err)
+		}
+		conn = tlsConn
+	}
+
+	// The handshake honors ctx through the connection's deadline
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if d.proxy.Scheme == "socks5" || d.proxy.Scheme == "socks5h" {
+		err = socksConnect(conn, d.proxy.User, addr)
+	} else {
+		conn, err = httpConnect(conn, d.proxy.User, addr)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: %w", d.proxy.Host, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// SOCKS5 protocol constants (RFC 1928, RFC 1929)
+const (
+	socksVersion      = 5
+	socksNoAuth       = 0
+	socksPasswordAuth = 2
+	socksNoAcceptable = 0xff
+	socksConnectCmd   = 1
+	socksIPv4         = 1
+	socksDomain       = 3
+	socksIPv6         = 4
+)
+
+// socksConnect asks a SOCKS5 server to connect to addr. Host names are
+// resolved by the proxy, as hosts behind it may not resolve locally.
+func socksConnect(conn net.Conn, user *url.Userinfo, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 0xffff {
+		return fmt.Errorf("invalid port in %q", addr)
+	}
+
+	method := byte(socksNoAuth)
+	if user != nil {
+		method = socksPasswordAuth
+	}
+	if _, err := conn.Write([]byte{socksVersion, 1, method}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socksVersion {
+		return fmt.Errorf("not a SOCKS5 server")
+	}
+	switch reply[1] {
+	case socksNoAuth:
+	case socksPasswordAuth:
+		if err := socksLogin(conn, user); err != nil {
+			return err
+		}
+	case socksNoAcceptable:
+		return errors.New("SOCKS5 server refused the authentication method")
+	default:
+		return fmt.Errorf("SOCKS5 server chose unsupported method %d", reply[1])
+	}
+
+	req := []byte{socksVersion, socksConnectCmd, 0}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		req = append(req, socksIPv4)
+		req = append(req, ip.To4()...)
+	} else if ip != nil {
+		req = append(req, socksIPv6)
+		req = append(req, ip.To16()...)
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("host name %q too long for SOCKS5", host)
+		}
+		req = append(req, socksDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return err
+	}
+	if head[1] != 0 {
+		return fmt.Errorf("SOCKS5 connect to %s failed: %s", addr, socksError(head[1]))
+	}
+	// Skip the bound address and port
+	var skip int
+	switch head[3] {
+	case socksIPv4:
+		skip = net.IPv4len + 2
+	case socksIPv6:
+		skip = net.IPv6len + 2
+	case socksDomain:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return err
+		}
+		skip = int(n[0]) + 2
+	default:
+		return fmt.Errorf("SOCKS5 reply has unknown address type %d", head[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip))
+	return err
+}
+
+// socksLogin authenticates with a user name and password (RFC 1929)
+func socksLogin(conn net.Conn, user *url.Userinfo) error {
+	name := user.Username()
+	password, _ := user.Password()
+	if len(name) > 255 || len(password) > 255 {
+		return errors.New("SOCKS5 user name or password too long")
+	}
+	req := []byte{1, byte(len(name))}
+	req = append(req, name...)
+	req = append(req, byte(len(password)))
+	req = append(req, password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return errors.New("SOCKS5 authentication failed")
+	}
+	return nil
+}
+
+// socksError describes a SOCKS5 reply code
+func socksError(code byte) string {
+	switch code {
+	case 1:
+		return "general failure"
+	case 2:
+		return "connection not allowed by ruleset"
+	case 3:
+		return "network unreachable"
+	case 4:
+		return "host unreachable"
+	case 5:
+		return "connection refused"
+	case 6:
+		return "TTL expired"
+	default:
+		return fmt.Sprintf("error %d", code)
+	}
+}
+
+// httpConnect opens a tunnel to addr with an HTTP CONNECT request. The
+// returned connection replays anything the server sent after the response.
+func httpConnect(conn net.Conn, user *url.Userinfo, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user != nil {
+		password, _ := user.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		return conn, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return conn, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return conn, fmt.Errorf("CONNECT to %s failed: %s", addr, resp.Status)
+	}
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn reads what a bufio.Reader already took from a connection
+// before reading from the connection
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// noProxyList returns the hosts and domains of NO_PROXY (or no_proxy)
+func noProxyList() []string {
+	v := os.Getenv("NO_PROXY")
+	if v == "" {
+		v = os.Getenv("no_proxy")
+	}
+	var list []string
+	for _, entry := range strings.Split(v, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, strings.ToLower(entry))
+		}
+	}
+	return list
+}
+
+// bypass reports whether host is dialed without the proxy: "*" matches
+// every host, "example.com" or ".example.com" it and its subdomains
+func bypass(noProxy []string, host string) bool {
+	host = strings.ToLower(host)
+	for _, entry := range noProxy {
+		if entry == "*" {
+			return true
+		}
+		domain := strings.TrimPrefix(entry, ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,269 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// echoServer accepts connections and writes a greeting, then echoes
+func echoServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.Write([]byte("* OK hello\r\n"))
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// serveProxy runs handle for every connection to a loopback listener
+func serveProxy(t *testing.T, handle func(conn net.Conn)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// tunnel connects client to addr and copies between them
+func tunnel(client net.Conn, addr string) {
+	target, err := net.Dial("tcp", addr)
+	if err != nil {
+		client.Close()
+		return
+	}
+	go io.Copy(target, client)
+	io.Copy(client, target)
+	client.Close()
+	target.Close()
+}
+
+// socksProxy is a SOCKS5 server accepting user:pw, or no
+// authentication if auth is false
+func socksProxy(t *testing.T, auth bool) string {
+	return serveProxy(t, func(conn net.Conn) {
+		buf := make([]byte, 262)
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			conn.Close()
+			return
+		}
+		io.ReadFull(conn, buf[:buf[1]])
+		if !auth {
+			conn.Write([]byte{5, socksNoAuth})
+		} else {
+			conn.Write([]byte{5, socksPasswordAuth})
+			io.ReadFull(conn, buf[:2])
+			user := make([]byte, buf[1])
+			io.ReadFull(conn, user)
+			io.ReadFull(conn, buf[:1])
+			pass := make([]byte, buf[0])
+			io.ReadFull(conn, pass)
+			if string(user) != "user" || string(pass) != "pw" {
+				conn.Write([]byte{1, 1})
+				conn.Close()
+				return
+			}
+			conn.Write([]byte{1, 0})
+		}
+
+		io.ReadFull(conn, buf[:4])
+		var host string
+		switch buf[3] {
+		case socksIPv4:
+			io.ReadFull(conn, buf[:4])
+			host = net.IP(buf[:4]).String()
+		case socksDomain:
+			io.ReadFull(conn, buf[:1])
+			name := make([]byte, buf[0])
+			io.ReadFull(conn, name)
+			host = string(name)
+		}
+		io.ReadFull(conn, buf[:2])
+		port := int(buf[0])<<8 | int(buf[1])
+		if host == "localhost" {
+			host = "127.0.0.1"
+		}
+		conn.Write([]byte{5, 0, 0, socksIPv4, 0, 0, 0, 0, 0, 0})
+		tunnel(conn, net.JoinHostPort(host, strconv.Itoa(port)))
+	})
+}
+
+// connectProxy is an HTTP proxy serving CONNECT, requiring user:pw
+func connectProxy(t *testing.T) string {
+	return serveProxy(t, func(conn net.Conn) {
+		br := bufio.NewReader(conn)
+		req, err := http.ReadRequest(br)
+		if err != nil || req.Method != http.MethodConnect {
+			conn.Close()
+			return
+		}
+		want := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:pw"))
+		if req.Header.Get("Proxy-Authorization") != want {
+			conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+			conn.Close()
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		tunnel(conn, req.Host)
+	})
+}
+
+func TestDialer(t *testing.T) {
+	target := echoServer(t)
+	_, port, _ := net.SplitHostPort(target)
+
+	tests := []struct {
+		name    string
+		proxy   func(t *testing.T) string
+		addr    string
+		wantErr bool
+	}{
+		{"direct", func(t *testing.T) string { return Direct }, target, false},
+		{"socks5", func(t *testing.T) string { return "socks5://" + socksProxy(t, false) }, target, false},
+		{"socks5 resolves host names", func(t *testing.T) string { return "socks5h://" + socksProxy(t, false) }, "localhost:" + port, false},
+		{"socks5 login", func(t *testing.T) string { return "socks5://user:pw@" + socksProxy(t, true) }, target, false},
+		{"socks5 wrong password", func(t *testing.T) string { return "socks5://user:no@" + socksProxy(t, true) }, target, true},
+		{"http connect", func(t *testing.T) string { return "http://user:pw@" + connectProxy(t) }, target, false},
+		{"http connect refused", func(t *testing.T) string { return "http://" + connectProxy(t) }, target, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := New(tt.proxy(t))
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			conn, err := d.DialContext(context.Background(), "tcp", tt.addr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DialContext() error = %v; wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			// The greeting must survive the proxy handshake
+			r := bufio.NewReader(conn)
+			if line, err := r.ReadString('\n'); err != nil || line != "* OK hello\r\n" {
+				t.Fatalf("greeting = %q, %v", line, err)
+			}
+			conn.Write([]byte("ping\n"))
+			if line, err := r.ReadString('\n'); err != nil || line != "ping\n" {
+				t.Errorf("echo = %q, %v", line, err)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		raw     string
+		wantNil bool
+		wantErr bool
+	}{
+		{"", true, false},
+		{Direct, true, false},
+		{"socks5://proxy:1080", false, false},
+		{"https://user:pw@proxy:3128", false, false},
+		{"ftp://proxy:21", false, true},
+		{"socks5://", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			u, err := Parse(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v; wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (u == nil) != tt.wantNil {
+				t.Errorf("Parse() = %v; want nil %v", u, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestProxyFuncEnvironment(t *testing.T) {
+	for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy", "all_proxy", "no_proxy"} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("ALL_PROXY", "socks5://proxy:1080")
+	t.Setenv("NO_PROXY", "internal.example.com,.corp")
+
+	fn, err := ProxyFunc("")
+	if err != nil {
+		t.Fatalf("ProxyFunc() error = %v", err)
+	}
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://oauth2.googleapis.com/token", "socks5://proxy:1080"},
+		{"https://internal.example.com/hook", ""},
+		{"https://api.internal.example.com/hook", ""},
+		{"http://jira.corp/rest", ""},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, tt.url, nil)
+		u, err := fn(req)
+		if err != nil {
+			t.Fatalf("proxy of %s: %v", tt.url, err)
+		}
+		got := ""
+		if u != nil {
+			got = u.String()
+		}
+		if got != tt.want {
+			t.Errorf("proxy of %s = %q; want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestConfigured(t *testing.T) {
+	tests := []struct {
+		raw  string
+		env  map[string]string
+		want bool
+	}{
+		{"", nil, false},
+		{"direct", map[string]string{"ALL_PROXY": "socks5://proxy:1080"}, false},
+		{"http://proxy:3128", nil, true},
+		{"", map[string]string{"HTTPS_PROXY": "http://proxy:3128"}, true},
+		{"", map[string]string{"ALL_PROXY": "socks5://proxy:1080"}, true},
+	}
+	for _, tt := range tests {
+		for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy", "ALL_PROXY", "all_proxy"} {
+			t.Setenv(name, tt.env[name])
+		}
+		if got := Configured(tt.raw); got != tt.want {
+			t.Errorf("Configured(%q) with %v = %v; want %v", tt.raw, tt.env, got, tt.want)
+		}
+	}
+}
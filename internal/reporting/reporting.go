@@ -0,0 +1,121 @@
+// Package reporting sends persistent failures to Sentry or an error
+// webhook, so breakage that only shows in the log gets noticed
+package reporting
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+// sendTimeout bounds each delivery of a report
+const sendTimeout = 10 * time.Second
+
+// Kind is the kind of failure a report is about
+type Kind string
+
+const (
+	// KindAuthRejected reports an account whose login the server rejected
+	KindAuthRejected Kind = "auth_rejected"
+	// KindPollFailing reports an account whose polls keep failing
+	KindPollFailing Kind = "poll_failing"
+	// KindCircuitOpen reports an account whose polls were suspended after
+	// failing too often
+	KindCircuitOpen Kind = "circuit_open"
+	// KindRuleSuspended reports a rule suspended for repeatedly overrunning
+	// its time budget
+	KindRuleSuspended Kind = "rule_suspended"
+	// KindActionPanic reports a rule action that panicked
+	KindActionPanic Kind = "action_panic"
+	// KindAccountPanic reports a panic in an account's polling outside its
+	// actions
+	KindAccountPanic Kind = "account_panic"
+)
+
+// Report is a failure along with the context needed to act on it
+type Report struct {
+	Kind     Kind              `json:"kind"`
+	Account  string            `json:"account"`
+	Error    string            `json:"error"`
+	Attempts int               `json:"attempts,omitempty"` // Failed polls in a row
+	Context  map[string]string `json:"context,omitempty"`  // E.g. the mailbox, rule and UID of a panic
+	Stack    string            `json:"stack,omitempty"`
+	Time     time.Time         `json:"time"`
+}
+
+// sink delivers reports to one service
+type sink interface {
+	name() string
+	send(ctx context.Context, r Report) error
+}
+
+// Reporter delivers reports to the configured services in the background.
+// The zero Reporter, and a nil one, drop every report.
+type Reporter struct {
+	sinks    []sink
+	inFlight sync.WaitGroup
+}
+
+// New creates a reporter for cfg; without a Sentry DSN or webhook it
+// drops every report
+func New(cfg config.ErrorReportingConfig) (*Reporter, error) {
+	r := &Reporter{}
+	if cfg.SentryDSN != "" {
+		s, err := newSentry(cfg.SentryDSN, cfg.Environment)
+		if err != nil {
+			return nil, err
+		}
+		r.sinks = append(r.sinks, s)
+	}
+	if cfg.WebhookURL != "" {
+		s, err := newWebhook(cfg.WebhookURL, cfg.WebhookSecrets)
+		if err != nil {
+			return nil, err
+		}
+		r.sinks = append(r.sinks, s)
+	}
+	return r, nil
+}
+
+// Report sends rep to every service without waiting for delivery;
+// failures to deliver are logged
+func (r *Reporter) Report(rep Report) {
+	if r == nil || len(r.sinks) == 0 {
+		return
+	}
+	if rep.Time.IsZero() {
+		rep.Time = time.Now()
+	}
+	for _, s := range r.sinks {
+		r.inFlight.Add(1)
+		go func(s sink) {
+			defer r.inFlight.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+			defer cancel()
+			if err := s.send(ctx, rep); err != nil {
+				log.Printf("Failed to report %s of account %s to %s: %v", rep.Kind, rep.Account, s.name(), err)
+			}
+		}(s)
+	}
+}
+
+// Flush waits for reports still being delivered, bounded by ctx
+func (r *Reporter) Flush(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		r.inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
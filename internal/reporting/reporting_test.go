@@ -0,0 +1,94 @@
+package reporting
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/pkg/webhook"
+)
+
+// recorder records the requests of a test server
+type recorder struct {
+	mu       sync.Mutex
+	paths    []string
+	headers  []http.Header
+	payloads []map[string]interface{}
+}
+
+func (rec *recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var payload map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&payload)
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.paths = append(rec.paths, r.URL.Path)
+	rec.headers = append(rec.headers, r.Header)
+	rec.payloads = append(rec.payloads, payload)
+}
+
+func TestReporter(t *testing.T) {
+	rec := &recorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	dsn := strings.Replace(srv.URL, "://", "://publickey@", 1) + "/sentry/42"
+	r, err := New(config.ErrorReportingConfig{
+		SentryDSN:      dsn,
+		Environment:    "production",
+		WebhookURL:     srv.URL + "/hook",
+		WebhookSecrets: []string{"s3cret"},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	r.Report(Report{Kind: KindAuthRejected, Account: "primary", Error: "authentication failed"})
+	if err := r.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	if len(rec.paths) != 2 {
+		t.Fatalf("got %d requests; want one to Sentry and one to the webhook", len(rec.paths))
+	}
+	for i, path := range rec.paths {
+		h, payload := rec.headers[i], rec.payloads[i]
+		switch path {
+		case "/sentry/api/42/store/":
+			if auth := h.Get("X-Sentry-Auth"); !strings.Contains(auth, "sentry_key=publickey") {
+				t.Errorf("X-Sentry-Auth = %q; want the DSN key", auth)
+			}
+			tags, _ := payload["tags"].(map[string]interface{})
+			if tags["account"] != "primary" || tags["kind"] != "auth_rejected" || payload["environment"] != "production" {
+				t.Errorf("Sentry event = %v; want the account, kind and environment", payload)
+			}
+		case "/hook":
+			if payload["kind"] != "auth_rejected" || payload["account"] != "primary" || payload["error"] != "authentication failed" {
+				t.Errorf("webhook payload = %v", payload)
+			}
+			if h.Get(webhook.SignatureHeader) == "" {
+				t.Error("webhook request is not signed")
+			}
+		default:
+			t.Errorf("unexpected request to %s", path)
+		}
+	}
+}
+
+func TestNewInvalidDSN(t *testing.T) {
+	for _, dsn := range []string{"https://sentry.io/42", "https://key@sentry.io/", "::"} {
+		if _, err := New(config.ErrorReportingConfig{SentryDSN: dsn}); err == nil {
+			t.Errorf("New() with DSN %q succeeded", dsn)
+		}
+	}
+
+	// Without services, reports are dropped
+	var r *Reporter
+	r.Report(Report{Kind: KindPollFailing})
+	if err := r.Flush(context.Background()); err != nil {
+		t.Errorf("Flush() of a nil reporter error = %v", err)
+	}
+}
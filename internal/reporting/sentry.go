@@ -0,0 +1,124 @@
+package reporting
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// sentryClient identifies go-tsk to Sentry
+const sentryClient = "go-tsk/1.0"
+
+// sentrySink sends reports to Sentry's store endpoint, authenticated by
+// the key in the project's DSN
+type sentrySink struct {
+	endpoint    string
+	key         string
+	environment string
+	client      *http.Client
+}
+
+// newSentry creates a sink for a DSN such as
+// "https://<key>@o1.ingest.sentry.io/<project>"
+func newSentry(dsn, environment string) (*sentrySink, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("invalid Sentry DSN")
+	}
+	dir, project := path.Split(strings.TrimSuffix(u.Path, "/"))
+	if project == "" {
+		return nil, fmt.Errorf("invalid Sentry DSN: no project")
+	}
+	endpoint := url.URL{Scheme: u.Scheme, Host: u.Host, Path: path.Join(dir, "api", project, "store") + "/"}
+	return &sentrySink{
+		endpoint:    endpoint.String(),
+		key:         u.User.Username(),
+		environment: environment,
+		client:      &http.Client{Timeout: sendTimeout},
+	}, nil
+}
+
+func (s *sentrySink) name() string { return "Sentry" }
+
+// sentryEvent is the part of Sentry's event payload go-tsk fills in
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Message     string            `json:"message"`
+	Fingerprint []string          `json:"fingerprint"`
+	Tags        map[string]string `json:"tags"`
+	Extra       map[string]string `json:"extra,omitempty"`
+}
+
+func (s *sentrySink) send(ctx context.Context, r Report) error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	host, _ := os.Hostname()
+	level := "error"
+	if r.Kind == KindActionPanic {
+		level = "fatal"
+	}
+	extra := make(map[string]string, len(r.Context)+2)
+	for k, v := range r.Context {
+		extra[k] = v
+	}
+	if r.Attempts > 0 {
+		extra["attempts"] = fmt.Sprint(r.Attempts)
+	}
+	if r.Stack != "" {
+		extra["stack"] = r.Stack
+	}
+
+	body, err := json.Marshal(sentryEvent{
+		EventID:     hex.EncodeToString(id),
+		Timestamp:   r.Time.UTC().Format("2006-01-02T15:04:05Z"),
+		Level:       level,
+		Platform:    "go",
+		Logger:      "go-tsk",
+		ServerName:  host,
+		Environment: s.environment,
+		Message:     fmt.Sprintf("%s for account %s: %s", r.Kind, r.Account, r.Error),
+		// Group by failure and account rather than by the error text, which
+		// varies from attempt to attempt
+		Fingerprint: []string{string(r.Kind), r.Account},
+		Tags:        map[string]string{"account": r.Account, "kind": string(r.Kind)},
+		Extra:       extra,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=%s, sentry_key=%s", sentryClient, s.key))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("sentry returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package reporting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/mshan/go-tsk/pkg/webhook"
+)
+
+// webhookSink POSTs reports as JSON, signing them like event sinks when
+// secrets are configured
+type webhookSink struct {
+	url    string
+	signer *webhook.Signer // nil when requests are not signed
+	client *http.Client
+}
+
+func newWebhook(url string, secrets []string) (*webhookSink, error) {
+	s := &webhookSink{url: url, client: &http.Client{Timeout: sendTimeout}}
+	if len(secrets) > 0 {
+		signer, err := webhook.NewSigner(secrets...)
+		if err != nil {
+			return nil, err
+		}
+		s.signer = signer
+	}
+	return s, nil
+}
+
+func (s *webhookSink) name() string { return "the error webhook" }
+
+func (s *webhookSink) send(ctx context.Context, r Report) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.signer != nil {
+		s.signer.Sign(req.Header, body, time.Now())
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+// Package retry runs an operation again after failures worth retrying,
+// waiting a doubling delay between attempts.
+package retry
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// defaultDelay is the wait before the first retry when a policy leaves it
+// unset
+const defaultDelay = time.Second
+
+// Policy says how often and how patiently an operation is retried. The
+// zero Policy tries once.
+type Policy struct {
+	Attempts int           // Tries in total; 0 tries once
+	Delay    time.Duration // Wait before the first retry, doubling after each; 0 uses 1s
+	MaxDelay time.Duration // Longest wait; 0 leaves it unbounded
+
+	// Retryable reports whether a failure is worth retrying; nil retries
+	// every failure. Permanent errors are never retried.
+	Retryable func(error) bool
+	// OnRetry, if set, is called before waiting for each retry, e.g. to
+	// log the failure
+	OnRetry func(attempt int, delay time.Duration, err error)
+}
+
+// permanentError is a failure no retry will fix
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying, whatever a policy's Retryable
+// says. It returns nil for a nil error.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+// IsPermanent reports whether err was marked with Permanent
+func IsPermanent(err error) bool {
+	return errors.As(err, &permanentError{})
+}
+
+// Do runs fn until it succeeds, fails in a way not worth retrying or has
+// used up the policy's attempts, and returns its last error. fn is passed
+// the number of the attempt, starting at 1. Once ctx is done no further
+// attempt is made; if that happens while waiting, Do returns ctx.Err().
+func (p Policy) Do(ctx context.Context, fn func(ctx context.Context, attempt int) error) error {
+	delay := p.Delay
+	if delay <= 0 {
+		delay = defaultDelay
+	}
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx, attempt)
+		if err == nil {
+			return nil
+		}
+		if attempt >= p.Attempts || IsPermanent(err) || ctx.Err() != nil ||
+			(p.Retryable != nil && !p.Retryable(err)) {
+			return err
+		}
+		if p.MaxDelay > 0 && delay > p.MaxDelay {
+			delay = p.MaxDelay
+		}
+		if p.OnRetry != nil {
+			p.OnRetry(attempt, delay, err)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,105 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var errTransient = errors.New("connection reset")
+
+func TestDo(t *testing.T) {
+	errFatal := errors.New("no such mailbox")
+	tests := []struct {
+		name         string
+		policy       Policy
+		errs         []error // Returned by successive attempts, then nil
+		wantErr      error
+		wantAttempts int
+		wantDelays   []time.Duration
+	}{
+		{
+			name:         "zero policy tries once",
+			errs:         []error{errTransient},
+			wantErr:      errTransient,
+			wantAttempts: 1,
+		},
+		{
+			name:         "succeeds after retries",
+			policy:       Policy{Attempts: 3, Delay: time.Millisecond},
+			errs:         []error{errTransient, errTransient},
+			wantAttempts: 3,
+			wantDelays:   []time.Duration{time.Millisecond, 2 * time.Millisecond},
+		},
+		{
+			name:         "gives up after the attempts",
+			policy:       Policy{Attempts: 2, Delay: time.Millisecond},
+			errs:         []error{errTransient, errTransient, errTransient},
+			wantErr:      errTransient,
+			wantAttempts: 2,
+			wantDelays:   []time.Duration{time.Millisecond},
+		},
+		{
+			name:         "delay capped",
+			policy:       Policy{Attempts: 4, Delay: 2 * time.Millisecond, MaxDelay: 3 * time.Millisecond},
+			errs:         []error{errTransient, errTransient, errTransient},
+			wantAttempts: 4,
+			wantDelays:   []time.Duration{2 * time.Millisecond, 3 * time.Millisecond, 3 * time.Millisecond},
+		},
+		{
+			name: "not retryable",
+			policy: Policy{Attempts: 3, Delay: time.Millisecond, Retryable: func(err error) bool {
+				return !errors.Is(err, errFatal)
+			}},
+			errs:         []error{errFatal},
+			wantErr:      errFatal,
+			wantAttempts: 1,
+		},
+		{
+			name:         "permanent",
+			policy:       Policy{Attempts: 3, Delay: time.Millisecond},
+			errs:         []error{Permanent(errTransient)},
+			wantErr:      errTransient,
+			wantAttempts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var delays []time.Duration
+			tt.policy.OnRetry = func(attempt int, delay time.Duration, err error) {
+				delays = append(delays, delay)
+			}
+			attempts := 0
+			err := tt.policy.Do(context.Background(), func(ctx context.Context, attempt int) error {
+				attempts++
+				if attempt != attempts {
+					t.Errorf("attempt = %d; want %d", attempt, attempts)
+				}
+				if attempt <= len(tt.errs) {
+					return tt.errs[attempt-1]
+				}
+				return nil
+			})
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("Do() = %v; want %v", err, tt.wantErr)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d; want %d", attempts, tt.wantAttempts)
+			}
+			if !reflect.DeepEqual(delays, tt.wantDelays) {
+				t.Errorf("delays = %v; want %v", delays, tt.wantDelays)
+			}
+		})
+	}
+}
+
+func TestDoCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	policy := Policy{Attempts: 3, Delay: time.Hour, OnRetry: func(int, time.Duration, error) { cancel() }}
+	err := policy.Do(ctx, func(ctx context.Context, attempt int) error { return errTransient })
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Do() = %v; want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,152 @@
+package rules
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+// conditionCostLimit bounds the work one evaluation of a condition may do,
+// so a runaway expression cannot stall a poll
+const conditionCostLimit = 1_000_000
+
+// conditionEnv declares the email variable conditions are written against
+var conditionEnv, conditionEnvErr = cel.NewEnv(
+	cel.Variable("email", cel.MapType(cel.StringType, cel.DynType)),
+)
+
+// Condition is a compiled CEL expression over an email, such as
+// email.from.endsWith("@bank.com") && email.size < 5000000
+type Condition struct {
+	expr string
+	prg  cel.Program
+}
+
+// CompileCondition parses and type-checks a rule's Condition. An empty
+// expression compiles to a nil Condition, which always holds.
+func CompileCondition(expr string) (*Condition, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	if conditionEnvErr != nil {
+		return nil, conditionEnvErr
+	}
+	ast, issues := conditionEnv.Compile(expr)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if t := ast.OutputType(); t != cel.BoolType && t != cel.DynType {
+		return nil, fmt.Errorf("condition must be a boolean expression, not %s", t)
+	}
+	prg, err := conditionEnv.Program(ast, cel.CostLimit(conditionCostLimit))
+	if err != nil {
+		return nil, err
+	}
+	return &Condition{expr: expr, prg: prg}, nil
+}
+
+// ContainsCondition returns a condition that holds when a string field of
+// the email, such as from, contains value, ignoring case as SubjectContains
+// does. Converters of other filter formats use it.
+func ContainsCondition(field, value string) string {
+	return fmt.Sprintf("email.%s.matches(%s)", field, strconv.Quote("(?i)"+regexp.QuoteMeta(value)))
+}
+
+// containsPattern matches what ContainsCondition returns
+var containsPattern = regexp.MustCompile(`^email\.(\w+)\.matches\(("(?:[^"\\]|\\.)*")\)$`)
+
+// ParseContainsCondition reverses ContainsCondition, reporting false for
+// any other condition
+func ParseContainsCondition(cond string) (field, value string, ok bool) {
+	m := containsPattern.FindStringSubmatch(cond)
+	if m == nil {
+		return "", "", false
+	}
+	pattern, err := strconv.Unquote(m[2])
+	if err != nil || !strings.HasPrefix(pattern, "(?i)") {
+		return "", "", false
+	}
+	value = unquoteMeta.Replace(strings.TrimPrefix(pattern, "(?i)"))
+	if ContainsCondition(m[1], value) != cond {
+		return "", "", false
+	}
+	return m[1], value, true
+}
+
+// unquoteMeta undoes regexp.QuoteMeta
+var unquoteMeta = func() *strings.Replacer {
+	var pairs []string
+	for _, c := range `\.+*?()|[]{}^$` {
+		pairs = append(pairs, `\`+string(c), string(c))
+	}
+	return strings.NewReplacer(pairs...)
+}()
+
+// Holds evaluates the condition against an email
+func (c *Condition) Holds(e *email.Email) (bool, error) {
+	if c == nil {
+		return true, nil
+	}
+	out, _, err := c.prg.Eval(map[string]any{"email": conditionVars(e)})
+	if err != nil {
+		return false, err
+	}
+	holds, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("condition %q evaluated to %v, not a boolean", c.expr, out.Value())
+	}
+	return holds, nil
+}
+
+// addresses returns a recipient list as conditions see it, empty rather
+// than nil
+func addresses(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
+
+// conditionVars returns the fields of an email as conditions see them
+func conditionVars(e *email.Email) map[string]any {
+	flags := e.Flags
+	if flags == nil {
+		flags = []string{}
+	}
+	attachments := make([]map[string]any, 0, len(e.Attachments))
+	for _, a := range e.Attachments {
+		attachments = append(attachments, map[string]any{
+			"filename":     a.Filename,
+			"content_type": a.ContentType,
+			"size":         a.Size,
+		})
+	}
+	return map[string]any{
+		"mailbox":           e.Mailbox,
+		"uid":               int64(e.UID),
+		"message_id":        e.MessageID,
+		"thread_id":         e.ThreadID,
+		"subject":           e.Subject,
+		"from":              e.From,
+		"to":                addresses(e.To),
+		"cc":                addresses(e.Cc),
+		"date":              e.Date,
+		"flags":             flags,
+		"text_body":         e.TextBody,
+		"html_body":         e.HTMLBody,
+		"size":              int64(e.Size),
+		"has_calendar":      e.HasCalendar,
+		"is_bulk":           e.Bulk,
+		"is_auto_generated": e.AutoGenerated,
+		"spf":               e.Auth.SPF,
+		"dkim":              e.Auth.DKIM,
+		"dmarc":             e.Auth.DMARC,
+		"auth_failed":       e.Auth.Failed(),
+		"attachments":       attachments,
+	}
+}
@@ -0,0 +1,87 @@
+package rules
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+func TestCondition(t *testing.T) {
+	e := &email.Email{
+		Mailbox: "INBOX",
+		UID:     7,
+		Subject: "Your March statement",
+		From:    "Bank <alerts@bank.com>",
+		Date:    time.Date(2024, 3, 5, 9, 15, 0, 0, time.UTC),
+		Flags:   []string{`\Seen`},
+		Size:    48_000,
+		To:      []string{"me@example.com"},
+		Bulk:    true,
+		Auth:    email.AuthResults{SPF: "pass", DKIM: "fail"},
+		Attachments: []email.Attachment{
+			{Filename: "statement.pdf", ContentType: "application/pdf", Size: 40_000},
+		},
+	}
+	tests := []struct {
+		name       string
+		expr       string
+		want       bool
+		compileErr bool
+		evalErr    bool
+	}{
+		{"empty", "", true, false, false},
+		{"bank statement", `email.from.endsWith("@bank.com>") && email.subject.matches("(?i)statement") && email.size < 5000000`, true, false, false},
+		{"other sender", `email.from.endsWith("@example.com")`, false, false, false},
+		{"flags", `"\\Seen" in email.flags && email.mailbox == "INBOX"`, true, false, false},
+		{"date", `email.date > timestamp("2024-01-01T00:00:00Z")`, true, false, false},
+		{"uid", `email.uid == 7`, true, false, false},
+		{"calendar invite", `email.has_calendar`, false, false, false},
+		{"bulk", `email.is_bulk && !email.is_auto_generated`, true, false, false},
+		{"authentication", `email.auth_failed && email.dkim == "fail" && email.dmarc == ""`, true, false, false},
+		{"attachments", `email.attachments.exists(a, a.filename.endsWith(".pdf") && a.size > 10000)`, true, false, false},
+		{"only cc", `email.cc.exists(a, a.contains("me@")) && !email.to.exists(a, a.contains("me@"))`, false, false, false},
+		{"no images", `email.attachments.exists(a, a.content_type.startsWith("image/"))`, false, false, false},
+		{"syntax error", `email.from.endsWith(`, false, true, false},
+		{"not boolean", `"statement"`, false, true, false},
+		{"unknown variable", `message.subject == ""`, false, true, false},
+		{"unknown field", `email.sender == ""`, false, false, true},
+		{"dynamic non-boolean", `email.subject`, false, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cond, err := CompileCondition(tt.expr)
+			if (err != nil) != tt.compileErr {
+				t.Fatalf("CompileCondition(%q) error = %v; wantErr %v", tt.expr, err, tt.compileErr)
+			}
+			if err != nil {
+				return
+			}
+			got, err := cond.Holds(e)
+			if (err != nil) != tt.evalErr {
+				t.Fatalf("Holds() error = %v; wantErr %v", err, tt.evalErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("Holds() = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContainsCondition(t *testing.T) {
+	for _, value := range []string{"boss@example.com", `a.b+c*(d)|[e]{f}^$\g "quoted"`, "Ünïcode", ""} {
+		cond := ContainsCondition("from", value)
+		if _, err := CompileCondition(cond); err != nil {
+			t.Errorf("CompileCondition(%s): %v", cond, err)
+		}
+		field, got, ok := ParseContainsCondition(cond)
+		if !ok || field != "from" || got != value {
+			t.Errorf("ParseContainsCondition(%s) = %q, %q, %v; want from, %q", cond, field, got, ok, value)
+		}
+	}
+	for _, cond := range []string{`email.from.endsWith("x")`, `email.from.matches("x")`, `email.from.matches("(?i)a.b")`} {
+		if _, _, ok := ParseContainsCondition(cond); ok {
+			t.Errorf("ParseContainsCondition(%s) succeeded", cond)
+		}
+	}
+}
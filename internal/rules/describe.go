@@ -0,0 +1,84 @@
+package rules
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+// Describe returns a human-readable description of the rule's match
+// conditions, as used in notifications, events and logs
+func Describe(rule config.Rule) string {
+	var parts []string
+	add := func(format string, args ...interface{}) {
+		parts = append(parts, fmt.Sprintf(format, args...))
+	}
+	if rule.SubjectContains != "" {
+		add("subject contains %s", rule.SubjectContains)
+	}
+	if rule.ToContains != "" {
+		add("to contains %s", rule.ToContains)
+	}
+	if rule.CcContains != "" {
+		add("cc contains %s", rule.CcContains)
+	}
+	if rule.FromInList != "" {
+		add("from in list %s", rule.FromInList)
+	}
+	if rule.FromNotInList != "" {
+		add("from not in list %s", rule.FromNotInList)
+	}
+	for _, h := range rule.HeaderMatches {
+		switch {
+		case h.Regex != "" && h.Contains != "":
+			add("header %s contains %s and matches %s", h.Name, h.Contains, h.Regex)
+		case h.Regex != "":
+			add("header %s matches %s", h.Name, h.Regex)
+		case h.Contains != "":
+			add("header %s contains %s", h.Name, h.Contains)
+		default:
+			add("header %s present", h.Name)
+		}
+	}
+	if rule.HasAttachment {
+		add("has attachment")
+	}
+	if rule.AttachmentNameMatches != "" {
+		add("attachment named %s", rule.AttachmentNameMatches)
+	}
+	if len(rule.AttachmentTypeIn) > 0 {
+		add("attachment of type %s", strings.Join(rule.AttachmentTypeIn, ", "))
+	}
+	if rule.AttachmentLargerThan > 0 {
+		add("attachment larger than %d bytes", rule.AttachmentLargerThan)
+	}
+	if rule.LargerThan > 0 {
+		add("larger than %d bytes", rule.LargerThan)
+	}
+	if rule.SmallerThan > 0 {
+		add("smaller than %d bytes", rule.SmallerThan)
+	}
+	if rule.OlderThan > 0 {
+		add("older than %s", rule.OlderThan)
+	}
+	if rule.NewerThan > 0 {
+		add("newer than %s", rule.NewerThan)
+	}
+	if rule.ThreadAlreadyLabeled != "" {
+		add("thread labeled %s", rule.ThreadAlreadyLabeled)
+	}
+	if rule.Mailbox != "" {
+		add("mailbox %s", rule.Mailbox)
+	}
+	if rule.Condition != "" {
+		add("condition %s", rule.Condition)
+	}
+	if rule.Plugin != "" {
+		add("plugin %s", rule.Plugin)
+	}
+	if len(parts) == 0 {
+		return "every message"
+	}
+	return strings.Join(parts, " and ")
+}
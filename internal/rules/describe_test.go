@@ -0,0 +1,37 @@
+package rules
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+func TestDescribe(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     config.Rule
+		expected string
+	}{
+		{"no conditions", config.Rule{Action: "label"}, "every message"},
+		{"subject", config.Rule{SubjectContains: "invoice"}, "subject contains invoice"},
+		{"sender list", config.Rule{FromInList: "vips"}, "from in list vips"},
+		{"header regex", config.Rule{HeaderMatches: []config.HeaderMatch{{Name: "X-Priority", Regex: "^[12]"}}},
+			"header X-Priority matches ^[12]"},
+		{"header present", config.Rule{HeaderMatches: []config.HeaderMatch{{Name: "List-Id"}}}, "header List-Id present"},
+		{"several", config.Rule{
+			SubjectContains: "report",
+			FromNotInList:   "bots",
+			OlderThan:       48 * time.Hour,
+			Condition:       `email.from.endsWith("@bank.com")`,
+		}, `subject contains report and from not in list bots and older than 48h0m0s and condition email.from.endsWith("@bank.com")`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Describe(tt.rule); got != tt.expected {
+				t.Errorf("Describe() = %q; want %q", got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package rules
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+func FuzzCompileTemplate(f *testing.F) {
+	f.Add("New mail: {{.Subject}}")
+	f.Add(`{"subject": {{json .Subject}}, "from": {{json .From}}}`)
+	f.Add("{{normalize .Subject | upper}} from {{lower .From}}")
+	f.Add("{{range .Flags}}{{.}},{{end}}")
+	f.Add("{{.Missing}}")
+	f.Add("{{define \"a\"}}{{template \"a\"}}{{end}}{{template \"a\"}}")
+
+	e := &email.Email{
+		UID:     42,
+		Subject: "Re: Job opportunity",
+		From:    "Recruiter <jobs@example.com>",
+		Date:    time.Date(2024, 3, 5, 9, 15, 0, 0, time.UTC),
+		Flags:   []string{`\Seen`, "imp"},
+	}
+
+	f.Fuzz(func(t *testing.T, text string) {
+		tmpl, err := CompileTemplate(text)
+		if err != nil {
+			return
+		}
+		// Execution errors are fine; panics are not
+		_, _ = tmpl.Render(e)
+	})
+}
+
+func FuzzCompileCondition(f *testing.F) {
+	f.Add(`email.from.endsWith("@bank.com") && email.size < 5000000`)
+	f.Add(`email.subject.matches("(?i)invoice|receipt")`)
+	f.Add(`"\\Seen" in email.flags || email.date < timestamp("2024-01-01T00:00:00Z")`)
+	f.Add(`email.missing`)
+	f.Add(`email.subject.(`)
+
+	e := &email.Email{
+		UID:     42,
+		Subject: "Re: Job opportunity",
+		From:    "Recruiter <jobs@example.com>",
+		Date:    time.Date(2024, 3, 5, 9, 15, 0, 0, time.UTC),
+		Flags:   []string{`\Seen`, "imp"},
+	}
+
+	f.Fuzz(func(t *testing.T, expr string) {
+		cond, err := CompileCondition(expr)
+		if err != nil {
+			return
+		}
+		// Evaluation errors are fine; panics are not
+		_, _ = cond.Holds(e)
+	})
+}
+
+func FuzzNormalizeSubject(f *testing.F) {
+	f.Add("Re: Fwd: Job opportunity")
+	f.Add("AW: WG: Angebot")
+	f.Add("Re[2]:Re(3): ")
+	f.Add("\xff\xfeRe:")
+
+	f.Fuzz(func(t *testing.T, subject string) {
+		n := NormalizeSubject(subject)
+		if again := NormalizeSubject(n); again != n {
+			t.Errorf("NormalizeSubject not idempotent: %q -> %q -> %q", subject, n, again)
+		}
+	})
+}
@@ -0,0 +1,183 @@
+package rules
+
+import (
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+// Matches reports whether the email satisfies the rule's conditions
+func Matches(rule config.Rule, e *email.Email) bool {
+	if rule.Mailbox != "" {
+		if ok, _ := path.Match(rule.Mailbox, e.Mailbox); !ok {
+			return false
+		}
+	}
+	if !containsFold(e.Subject, rule.SubjectContains) || !sizeMatches(rule, e) || !ageMatches(rule, e) {
+		return false
+	}
+	if !anyContains(e.To, rule.ToContains) || !anyContains(e.Cc, rule.CcContains) {
+		return false
+	}
+	for _, h := range rule.HeaderMatches {
+		if !headerMatches(h, e) {
+			return false
+		}
+	}
+	if !hasAttachmentConditions(rule) {
+		return true
+	}
+	for _, a := range e.Attachments {
+		if attachmentMatches(rule, a) {
+			return true
+		}
+	}
+	return false
+}
+
+// anyContains reports whether one of the addresses contains substr under
+// case folding; an empty substr holds even without addresses
+func anyContains(addrs []string, substr string) bool {
+	if substr == "" {
+		return true
+	}
+	for _, a := range addrs {
+		if containsFold(a, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+// sizeMatches reports whether the email's size meets the rule's size
+// conditions. A size of 0 means the provider did not report it.
+func sizeMatches(rule config.Rule, e *email.Email) bool {
+	if rule.LargerThan == 0 && rule.SmallerThan == 0 {
+		return true
+	}
+	size := int64(e.Size)
+	return size > 0 && size > rule.LargerThan && (rule.SmallerThan == 0 || size < rule.SmallerThan)
+}
+
+// ageMatches reports whether the time since the email's date meets the
+// rule's age conditions. Mail without a date meets none.
+func ageMatches(rule config.Rule, e *email.Email) bool {
+	if rule.OlderThan == 0 && rule.NewerThan == 0 {
+		return true
+	}
+	if e.Date.IsZero() {
+		return false
+	}
+	age := time.Since(e.Date)
+	return (rule.OlderThan == 0 || age > rule.OlderThan) && (rule.NewerThan == 0 || age < rule.NewerThan)
+}
+
+// headerPatterns caches the compiled HeaderMatch regular expressions, as
+// rules are matched far more often than configs change
+var headerPatterns sync.Map
+
+// headerMatches reports whether one value of the header field meets the
+// condition. An invalid regular expression, which config validation
+// rejects, matches nothing.
+func headerMatches(h config.HeaderMatch, e *email.Email) bool {
+	var re *regexp.Regexp
+	if h.Regex != "" {
+		if cached, ok := headerPatterns.Load(h.Regex); ok {
+			re = cached.(*regexp.Regexp)
+		} else {
+			var err error
+			if re, err = regexp.Compile(h.Regex); err != nil {
+				return false
+			}
+			headerPatterns.Store(h.Regex, re)
+		}
+	}
+	for _, v := range e.Header.Values(h.Name) {
+		if containsFold(v, h.Contains) && (re == nil || re.MatchString(v)) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAttachmentConditions reports whether the rule sets any attachment
+// condition
+func hasAttachmentConditions(rule config.Rule) bool {
+	return rule.HasAttachment || rule.AttachmentNameMatches != "" || len(rule.AttachmentTypeIn) > 0 || rule.AttachmentLargerThan > 0
+}
+
+// attachmentMatches reports whether one attachment meets all of the rule's
+// attachment conditions
+func attachmentMatches(rule config.Rule, a email.Attachment) bool {
+	if rule.AttachmentNameMatches != "" {
+		if ok, _ := path.Match(strings.ToLower(rule.AttachmentNameMatches), strings.ToLower(a.Filename)); !ok {
+			return false
+		}
+	}
+	if len(rule.AttachmentTypeIn) > 0 && !typeIn(a.ContentType, rule.AttachmentTypeIn) {
+		return false
+	}
+	return rule.AttachmentLargerThan == 0 || a.Size > rule.AttachmentLargerThan
+}
+
+// typeIn reports whether contentType is one of types, where "type/*"
+// stands for every subtype
+func typeIn(contentType string, types []string) bool {
+	for _, t := range types {
+		t = strings.ToLower(t)
+		if t == contentType || strings.HasSuffix(t, "/*") && strings.HasPrefix(contentType, t[:len(t)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsFold reports whether substr is within s under Unicode simple case
+// folding, so that e.g. "STRASSE" matches "strasse" and "Σ" matches "ς"
+func containsFold(s, substr string) bool {
+	if substr == "" {
+		return true
+	}
+
+	hay := []rune(s)
+	needle := []rune(substr)
+	for i := 0; i+len(needle) <= len(hay); i++ {
+		if runesEqualFold(hay[i:i+len(needle)], needle) {
+			return true
+		}
+	}
+	return false
+}
+
+// runesEqualFold compares two equal-length rune slices under simple folding
+func runesEqualFold(a, b []rune) bool {
+	for i := range a {
+		if !runeEqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// runeEqualFold reports whether r1 and r2 are in the same case-folding orbit
+func runeEqualFold(r1, r2 rune) bool {
+	if r1 == r2 {
+		return true
+	}
+	if r1 == utf8.RuneError || r2 == utf8.RuneError {
+		return false
+	}
+	for r := unicode.SimpleFold(r1); r != r1; r = unicode.SimpleFold(r) {
+		if r == r2 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,326 @@
+package rules
+
+import (
+	"encoding/json"
+	"net/textproto"
+	"strings"
+	"testing"
+	"testing/quick"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+var quickConfig = &quick.Config{MaxCount: 2000}
+
+// foldRunes replaces every rune with the next rune in its case-folding orbit
+func foldRunes(s string) string {
+	return strings.Map(unicode.SimpleFold, s)
+}
+
+func TestContainsFoldProperties(t *testing.T) {
+	properties := []struct {
+		name string
+		f    interface{}
+	}{
+		{"contains own substring", func(a, b, c string) bool {
+			return containsFold(a+b+c, b)
+		}},
+		{"empty needle always matches", func(s string) bool {
+			return containsFold(s, "")
+		}},
+		{"case-folded needle matches", func(a, b, c string) bool {
+			return containsFold(a+b+c, foldRunes(b))
+		}},
+		{"case-folded haystack matches", func(a, b, c string) bool {
+			return containsFold(foldRunes(a+b+c), b)
+		}},
+		{"agrees with EqualFold on equal lengths", func(s string, fold bool) bool {
+			other := s
+			if fold {
+				other = foldRunes(s)
+			}
+			return containsFold(s, other) == strings.EqualFold(s, other)
+		}},
+		{"longer needle never matches", func(s string, r rune) bool {
+			return !containsFold(s, s+string(r))
+		}},
+	}
+
+	for _, p := range properties {
+		t.Run(p.name, func(t *testing.T) {
+			if err := quick.Check(p.f, quickConfig); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestMatchesProperties(t *testing.T) {
+	f := func(subject, needle string) bool {
+		rule := config.Rule{SubjectContains: needle}
+		e := &email.Email{Subject: subject}
+		return Matches(rule, e) == containsFold(subject, needle)
+	}
+	if err := quick.Check(f, quickConfig); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMatchesMailbox(t *testing.T) {
+	tests := []struct {
+		pattern string
+		mailbox string
+		want    bool
+	}{
+		{"", "Receipts", true},
+		{"INBOX", "INBOX", true},
+		{"INBOX", "Receipts", false},
+		{"Lists/*", "Lists/golang", true},
+		{"Lists/*", "Lists/archive/2020", false},
+	}
+
+	for _, tt := range tests {
+		rule := config.Rule{SubjectContains: "release", Mailbox: tt.pattern}
+		e := &email.Email{Mailbox: tt.mailbox, Subject: "Go release"}
+		if got := Matches(rule, e); got != tt.want {
+			t.Errorf("Matches(Mailbox %q, %q) = %v; want %v", tt.pattern, tt.mailbox, got, tt.want)
+		}
+	}
+}
+
+func TestMatchesSizeAndAge(t *testing.T) {
+	e := &email.Email{Subject: "Build failed", Size: 6_000_000, Date: time.Now().Add(-45 * 24 * time.Hour)}
+	tests := []struct {
+		name string
+		rule config.Rule
+		want bool
+	}{
+		{"no conditions", config.Rule{}, true},
+		{"larger", config.Rule{LargerThan: 5_000_000}, true},
+		{"not larger", config.Rule{LargerThan: 10_000_000}, false},
+		{"smaller", config.Rule{SmallerThan: 10_000_000}, true},
+		{"not smaller", config.Rule{SmallerThan: 1_000_000}, false},
+		{"older", config.Rule{OlderThan: 30 * 24 * time.Hour}, true},
+		{"not older", config.Rule{OlderThan: 60 * 24 * time.Hour}, false},
+		{"newer", config.Rule{NewerThan: 7 * 24 * time.Hour}, false},
+		{"window", config.Rule{OlderThan: 30 * 24 * time.Hour, NewerThan: 60 * 24 * time.Hour}, true},
+		{"old and large", config.Rule{SubjectContains: "build", OlderThan: 30 * 24 * time.Hour, LargerThan: 5_000_000}, true},
+	}
+	for _, tt := range tests {
+		if got := Matches(tt.rule, e); got != tt.want {
+			t.Errorf("%s: Matches() = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Unknown sizes and dates meet no condition on them
+	unknown := &email.Email{Subject: "Build failed"}
+	if Matches(config.Rule{SmallerThan: 1_000_000}, unknown) || Matches(config.Rule{OlderThan: time.Hour}, unknown) {
+		t.Error("conditions matched an email without size or date")
+	}
+}
+
+func TestMatchesRecipients(t *testing.T) {
+	e := &email.Email{Subject: "Planning", To: []string{"Team <team@example.com>"}, Cc: []string{"Me <me@example.com>"}}
+	tests := []struct {
+		name string
+		rule config.Rule
+		want bool
+	}{
+		{"none", config.Rule{}, true},
+		{"to", config.Rule{ToContains: "TEAM@example.com"}, true},
+		{"not to", config.Rule{ToContains: "me@example.com"}, false},
+		{"cc", config.Rule{CcContains: "me@example.com"}, true},
+		{"both", config.Rule{ToContains: "team@", CcContains: "me@"}, true},
+	}
+	for _, tt := range tests {
+		if got := Matches(tt.rule, e); got != tt.want {
+			t.Errorf("%s: Matches() = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+	if Matches(config.Rule{CcContains: "me@"}, &email.Email{Subject: "Hi"}) {
+		t.Error("CcContains matched an email without Cc")
+	}
+}
+
+func TestMatchesHeaders(t *testing.T) {
+	e := &email.Email{Subject: "New ticket", Header: textproto.MIMEHeader{
+		"X-Priority":   {"1 (Highest)"},
+		"Delivered-To": {"me@example.com", "support@example.com"},
+		"X-Ticket-Id":  {"OPS-1234"},
+	}}
+	tests := []struct {
+		name    string
+		matches []config.HeaderMatch
+		want    bool
+	}{
+		{"none", nil, true},
+		{"present", []config.HeaderMatch{{Name: "x-ticket-id"}}, true},
+		{"absent", []config.HeaderMatch{{Name: "List-Id"}}, false},
+		{"contains", []config.HeaderMatch{{Name: "X-Priority", Contains: "HIGHEST"}}, true},
+		{"contains not", []config.HeaderMatch{{Name: "X-Priority", Contains: "low"}}, false},
+		{"regex", []config.HeaderMatch{{Name: "X-Ticket-Id", Regex: `^OPS-\d+$`}}, true},
+		{"regex not", []config.HeaderMatch{{Name: "X-Ticket-Id", Regex: `^DEV-`}}, false},
+		{"any value", []config.HeaderMatch{{Name: "Delivered-To", Contains: "support@"}}, true},
+		{"all conditions", []config.HeaderMatch{{Name: "X-Priority", Regex: "^[12]"}, {Name: "Delivered-To", Contains: "sales@"}}, false},
+		{"invalid regex", []config.HeaderMatch{{Name: "X-Priority", Regex: "("}}, false},
+	}
+	for _, tt := range tests {
+		if got := Matches(config.Rule{HeaderMatches: tt.matches}, e); got != tt.want {
+			t.Errorf("%s: Matches() = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMatchesAttachments(t *testing.T) {
+	e := &email.Email{
+		Subject: "Invoice 42",
+		Attachments: []email.Attachment{
+			{Filename: "logo.png", ContentType: "image/png", Size: 2_000},
+			{Filename: "Invoice-42.PDF", ContentType: "application/pdf", Size: 80_000},
+		},
+	}
+	tests := []struct {
+		name string
+		rule config.Rule
+		want bool
+	}{
+		{"no conditions", config.Rule{}, true},
+		{"has attachment", config.Rule{HasAttachment: true}, true},
+		{"name", config.Rule{AttachmentNameMatches: "invoice-*.pdf"}, true},
+		{"other name", config.Rule{AttachmentNameMatches: "*.docx"}, false},
+		{"type", config.Rule{AttachmentTypeIn: []string{"application/pdf", "text/csv"}}, true},
+		{"type wildcard", config.Rule{AttachmentTypeIn: []string{"image/*"}}, true},
+		{"larger than", config.Rule{AttachmentLargerThan: 50_000}, true},
+		{"too small", config.Rule{AttachmentLargerThan: 100_000}, false},
+		// Both conditions hold, but for different attachments
+		{"same attachment", config.Rule{AttachmentTypeIn: []string{"image/*"}, AttachmentLargerThan: 50_000}, false},
+		{"subject fails", config.Rule{SubjectContains: "receipt", HasAttachment: true}, false},
+	}
+	for _, tt := range tests {
+		if got := Matches(tt.rule, e); got != tt.want {
+			t.Errorf("%s: Matches() = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if Matches(config.Rule{HasAttachment: true}, &email.Email{Subject: "Hi"}) {
+		t.Error("HasAttachment matched an email without attachments")
+	}
+}
+
+func TestNormalizeSubjectProperties(t *testing.T) {
+	prefixes := []string{"Re: ", "RE:", "re : ", "Fwd: ", "FW:", "Aw: ", "WG:", "Re[2]: ", "Re(3):", "SV: Re: "}
+
+	properties := []struct {
+		name string
+		f    interface{}
+	}{
+		{"idempotent", func(s string) bool {
+			n := NormalizeSubject(s)
+			return NormalizeSubject(n) == n
+		}},
+		{"reply prefixes are removed", func(s string, i uint8) bool {
+			prefix := prefixes[int(i)%len(prefixes)]
+			return NormalizeSubject(prefix+s) == NormalizeSubject(s)
+		}},
+		{"no surrounding or repeated whitespace", func(s string) bool {
+			n := NormalizeSubject(s)
+			return n == strings.TrimSpace(n) && !strings.Contains(n, "  ") &&
+				strings.Join(strings.Fields(n), " ") == n
+		}},
+		{"never longer than input", func(s string) bool {
+			return len(NormalizeSubject(s)) <= len(s)
+		}},
+		{"keeps valid UTF-8 valid", func(s string) bool {
+			return !utf8.ValidString(s) || utf8.ValidString(NormalizeSubject(s))
+		}},
+	}
+
+	for _, p := range properties {
+		t.Run(p.name, func(t *testing.T) {
+			if err := quick.Check(p.f, quickConfig); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestNormalizeSubject(t *testing.T) {
+	tests := []struct {
+		name     string
+		subject  string
+		expected string
+	}{
+		{"plain", "Job opportunity", "Job opportunity"},
+		{"reply", "Re: Job opportunity", "Job opportunity"},
+		{"nested", "RE: Fwd: re[2]:  Job   opportunity ", "Job opportunity"},
+		{"non-breaking space", "Re:\u00a0Re:\u00a0Job opportunity", "Job opportunity"},
+		{"prefix only", "Re:", ""},
+		{"not a prefix", "Regarding: the job", "Regarding: the job"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NormalizeSubject(tt.subject); got != tt.expected {
+				t.Errorf("NormalizeSubject(%q) = %q; want %q", tt.subject, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTemplateProperties(t *testing.T) {
+	subject := mustCompile(t, "{{.Subject}}")
+	jsonSubject := mustCompile(t, "{{json .Subject}}")
+
+	properties := []struct {
+		name string
+		f    interface{}
+	}{
+		{"renders subject verbatim", func(s string) bool {
+			out, err := subject.Render(&email.Email{Subject: s})
+			return err == nil && out == s
+		}},
+		{"json round-trips subject", func(s string) bool {
+			out, err := jsonSubject.Render(&email.Email{Subject: s})
+			if err != nil {
+				return false
+			}
+			var decoded string
+			if err := json.Unmarshal([]byte(out), &decoded); err != nil {
+				return false
+			}
+			return decoded == strings.ToValidUTF8(s, "\uFFFD")
+		}},
+		{"literal text is unchanged", func(s string) bool {
+			if strings.Contains(s, "{{") {
+				return true
+			}
+			tmpl, err := CompileTemplate(s)
+			if err != nil {
+				return false
+			}
+			out, err := tmpl.Render(&email.Email{})
+			return err == nil && out == s
+		}},
+	}
+
+	for _, p := range properties {
+		t.Run(p.name, func(t *testing.T) {
+			if err := quick.Check(p.f, quickConfig); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func mustCompile(t *testing.T, text string) *Template {
+	t.Helper()
+	tmpl, err := CompileTemplate(text)
+	if err != nil {
+		t.Fatalf("CompileTemplate(%q) error = %v", text, err)
+	}
+	return tmpl
+}
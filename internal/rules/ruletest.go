@@ -0,0 +1,61 @@
+package rules
+
+import (
+	"fmt"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+// TestResult is the outcome of one rule test
+type TestResult struct {
+	Rule int    // Index of the rule in the config
+	Name string // Test name, or its position if unnamed
+	Want bool   // Whether the rule was expected to match
+	Got  bool   // Whether the rule matched
+}
+
+// Passed reports whether the rule behaved as the test expected
+func (r TestResult) Passed() bool {
+	return r.Want == r.Got
+}
+
+// RunTests checks every rule against its example messages. It fails if a
+// rule's condition does not compile.
+func RunTests(ruleList []config.Rule) ([]TestResult, error) {
+	var results []TestResult
+	for i, rule := range ruleList {
+		cond, err := CompileCondition(rule.Condition)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: invalid condition: %w", i, err)
+		}
+		for j, tc := range rule.Tests {
+			name := tc.Name
+			if name == "" {
+				name = fmt.Sprintf("#%d", j)
+			}
+			mailbox := tc.Mailbox
+			if mailbox == "" {
+				mailbox = email.Inbox
+			}
+			msg := &email.Email{
+				Mailbox:  mailbox,
+				Subject:  tc.Subject,
+				From:     tc.From,
+				TextBody: tc.Body,
+			}
+			got := Matches(rule, msg)
+			if got {
+				// A condition failing on an example message is a mismatch
+				got, _ = cond.Holds(msg)
+			}
+			results = append(results, TestResult{
+				Rule: i,
+				Name: name,
+				Want: tc.Match,
+				Got:  got,
+			})
+		}
+	}
+	return results, nil
+}
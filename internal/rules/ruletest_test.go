@@ -0,0 +1,63 @@
+package rules
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+func TestRunTests(t *testing.T) {
+	ruleList := []config.Rule{
+		{
+			SubjectContains: "invoice",
+			Label:           "billing",
+			Tests: []config.RuleTest{
+				{Name: "invoice", Subject: "Invoice #42", Match: true},
+				{Name: "newsletter", Subject: "Weekly newsletter", Match: false},
+				{Subject: "Your receipt", Match: true},
+			},
+		},
+		{
+			SubjectContains: "release",
+			Mailbox:         "Lists/*",
+			Tests: []config.RuleTest{
+				{Name: "inbox", Subject: "Go release"},
+				{Name: "list", Subject: "Go release", Mailbox: "Lists/golang", Match: true},
+			},
+		},
+		{
+			SubjectContains: "statement",
+			Condition:       `email.from.endsWith("@bank.com")`,
+			Tests: []config.RuleTest{
+				{Name: "bank", Subject: "Your statement", From: "alerts@bank.com", Match: true},
+				{Name: "phish", Subject: "Your statement", From: "alerts@bank.com.example"},
+			},
+		},
+	}
+
+	results, err := RunTests(ruleList)
+	if err != nil {
+		t.Fatalf("RunTests: %v", err)
+	}
+	if len(results) != 7 {
+		t.Fatalf("got %d results; want 7", len(results))
+	}
+
+	var failed []string
+	for _, r := range results {
+		if !r.Passed() {
+			failed = append(failed, fmt.Sprintf("%d/%s", r.Rule, r.Name))
+		}
+	}
+	if len(failed) != 1 || failed[0] != "0/#2" {
+		t.Errorf("failed tests = %v; want [0/#2]", failed)
+	}
+}
+
+func TestRunTestsInvalidCondition(t *testing.T) {
+	_, err := RunTests([]config.Rule{{Condition: "email.subject.("}})
+	if err == nil {
+		t.Fatal("RunTests accepted an invalid condition")
+	}
+}
@@ -0,0 +1,34 @@
+package rules
+
+import (
+	"hash/fnv"
+	"strings"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+// sampleBuckets is the resolution of SampleRate
+const sampleBuckets = 1000000
+
+// Sampled reports whether a message matched by rule falls in the rule's
+// sample. The choice hashes the Message-ID (or the UID key for messages
+// without one), so it is the same on every run and in every process.
+func Sampled(rule config.Rule, e *email.Email, key string) bool {
+	if rule.SampleRate <= 0 && rule.SampleEvery <= 1 {
+		return true
+	}
+
+	h := fnv.New64a()
+	if id := strings.TrimSpace(e.MessageID); id != "" {
+		h.Write([]byte(id))
+	} else {
+		h.Write([]byte(key))
+	}
+	sum := h.Sum64()
+
+	if rule.SampleEvery > 1 {
+		return sum%uint64(rule.SampleEvery) == 0
+	}
+	return sum%sampleBuckets < uint64(rule.SampleRate*sampleBuckets)
+}
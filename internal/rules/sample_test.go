@@ -0,0 +1,53 @@
+package rules
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+func TestSampled(t *testing.T) {
+	const n = 20000
+
+	tests := []struct {
+		name     string
+		rule     config.Rule
+		expected float64 // fraction of messages sampled
+	}{
+		{"no sampling", config.Rule{}, 1},
+		{"rate 10%", config.Rule{SampleRate: 0.1}, 0.1},
+		{"rate 100%", config.Rule{SampleRate: 1}, 1},
+		{"one in 50", config.Rule{SampleEvery: 50}, 0.02},
+		{"one in 1", config.Rule{SampleEvery: 1}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sampled := 0
+			for i := 0; i < n; i++ {
+				msg := &email.Email{MessageID: fmt.Sprintf("<%d@example.com>", i)}
+				got := Sampled(tt.rule, msg, "")
+				if got != Sampled(tt.rule, msg, "") {
+					t.Fatalf("Sampled() not deterministic for %s", msg.MessageID)
+				}
+				if got {
+					sampled++
+				}
+			}
+			if frac := float64(sampled) / n; math.Abs(frac-tt.expected) > 0.01 {
+				t.Errorf("sampled %.3f of messages; want %.3f", frac, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSampledWithoutMessageID(t *testing.T) {
+	rule := config.Rule{SampleEvery: 2}
+	a := Sampled(rule, &email.Email{}, "uid:INBOX:1:7")
+	if a != Sampled(rule, &email.Email{}, "uid:INBOX:1:7") {
+		t.Error("Sampled() not deterministic for messages without a Message-ID")
+	}
+}
@@ -0,0 +1,25 @@
+package rules
+
+import (
+	"regexp"
+	"strings"
+)
+
+// replyPrefix matches one reply or forward marker at the start of a subject,
+// including common localized forms ("AW:", "SV:", "WG:") and counters
+// such as "Re[2]:"
+var replyPrefix = regexp.MustCompile(`(?i)^(re|fwd?|aw|wg|sv|vs|antw|tr)(\[\d+\]|\(\d+\))? ?: ?`)
+
+// NormalizeSubject returns the canonical form of a subject: whitespace runs
+// collapsed to single spaces and all leading reply/forward markers removed,
+// so that every message in a thread normalizes to the same string
+func NormalizeSubject(subject string) string {
+	s := strings.Join(strings.Fields(subject), " ")
+	for {
+		loc := replyPrefix.FindStringIndex(s)
+		if loc == nil {
+			return s
+		}
+		s = strings.TrimLeft(s[loc[1]:], " ")
+	}
+}
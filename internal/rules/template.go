@@ -0,0 +1,49 @@
+package rules
+
+import (
+	"encoding/json"
+	"strings"
+	"text/template"
+
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+// templateFuncs are available to every rule template
+var templateFuncs = template.FuncMap{
+	"json":      toJSON,
+	"lower":     strings.ToLower,
+	"upper":     strings.ToUpper,
+	"normalize": NormalizeSubject,
+}
+
+// Template is a compiled text template rendered against an email
+type Template struct {
+	tmpl *template.Template
+}
+
+// CompileTemplate parses a template such as "New mail: {{.Subject}}"
+func CompileTemplate(text string) (*Template, error) {
+	tmpl, err := template.New("rule").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{tmpl: tmpl}, nil
+}
+
+// Render executes the template with the email's fields as data
+func (t *Template) Render(e *email.Email) (string, error) {
+	var b strings.Builder
+	if err := t.tmpl.Execute(&b, e); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// toJSON encodes v as a JSON value for use inside JSON payload templates
+func toJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,139 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/mshan/go-tsk/internal/actions"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/logging"
+)
+
+// newActionRegistry returns a registry of the built-in actions and those
+// of extensions and plugins. notify is not among them: its matches are collected into
+// one digest per poll instead.
+func (p *EmailPoller) newActionRegistry() (*actions.Registry, error) {
+	registry := actions.NewRegistry()
+	builtins := map[string]actions.Func{
+		"label":              p.labelAction,
+		"digest":             p.digestAction,
+		"snooze":             p.snoozeAction,
+		"remind-if-no-reply": p.remindAction,
+		"add-to-calendar":    p.calendarAction,
+		"unsubscribe":        p.unsubscribeAction,
+		"create-task":        p.taskAction,
+		"create-issue":       p.issueAction,
+		"create-jira":        p.jiraAction,
+		"ntfy":               p.pushAction,
+		"pushover":           p.pushAction,
+		"notify-desktop":     p.desktopAction,
+		"webhook":            p.webhookAction,
+		"archive":            p.archiveAction,
+		"exec":               p.execAction,
+	}
+	for name, action := range builtins {
+		if err := registry.Register(name, action); err != nil {
+			return nil, err
+		}
+	}
+	extensions := actions.Extensions()
+	for _, name := range extensions.Names() {
+		action, _ := extensions.Lookup(name)
+		if err := registry.Register(name, action); err != nil {
+			return nil, fmt.Errorf("extension action: %w", err)
+		}
+	}
+	for name, plugin := range p.plugins {
+		if !plugin.Acts() {
+			continue
+		}
+		if err := registry.Register(name, pluginAction(plugin)); err != nil {
+			return nil, fmt.Errorf("plugin action: %w", err)
+		}
+	}
+	return registry, nil
+}
+
+// labelAction applies the rule's label and records it with the loop guard.
+// As the last step of a chain in a batch, the label is queued and applied
+// with the batch's other messages. A message that already carries the label is
+// left alone, so processing it again does not label it twice.
+func (p *EmailPoller) labelAction(ctx context.Context, msg *email.Email, a actions.Params) error {
+	if hasLabel(msg, a.Rule.Label) {
+		return nil
+	}
+	applied := func() {
+		log.Printf("Applied label '%s' to email with subject: %s", a.Rule.Label, logging.Subject(msg.Subject))
+		p.recordThreadLabel(a.Account, msg, a.Rule.Label, a.Rule.ApplyToThread)
+		if err := p.guard.RecordLabel(a.Key, a.Rule.Label, true); err != nil {
+			p.loopDetected(a.Account, a.Key, msg, err)
+		}
+	}
+	if step := batchStepFrom(ctx); step != nil {
+		step.label(msg.Mailbox, a.Rule.Label, batchEntry{key: a.Key, uid: msg.UID, done: applied})
+		return nil
+	}
+
+	if err := a.Provider.ApplyLabel(ctx, msg.Mailbox, msg.UID, a.Rule.Label); err != nil {
+		return fmt.Errorf("failed to apply label: %w", err)
+	}
+	applied()
+	return nil
+}
+
+func (p *EmailPoller) taskAction(ctx context.Context, msg *email.Email, a actions.Params) error {
+	if err := p.createTask(ctx, a.Account, a.Rule, msg, a.Key); err != nil {
+		return fmt.Errorf("failed to create task: %w", err)
+	}
+	return nil
+}
+
+func (p *EmailPoller) issueAction(ctx context.Context, msg *email.Email, a actions.Params) error {
+	if err := p.createIssue(ctx, a.Account, a.RuleIndex, a.Rule, msg); err != nil {
+		return fmt.Errorf("failed to open issue: %w", err)
+	}
+	return nil
+}
+
+func (p *EmailPoller) jiraAction(ctx context.Context, msg *email.Email, a actions.Params) error {
+	if err := p.createJiraIssue(ctx, a.Account, a.RuleIndex, a.Rule, msg); err != nil {
+		return fmt.Errorf("failed to file Jira issue: %w", err)
+	}
+	return nil
+}
+
+func (p *EmailPoller) pushAction(ctx context.Context, msg *email.Email, a actions.Params) error {
+	if err := p.sendPush(ctx, a.Account, a.RuleIndex, a.Rule, msg); err != nil {
+		return fmt.Errorf("failed to send push: %w", err)
+	}
+	return nil
+}
+
+func (p *EmailPoller) desktopAction(ctx context.Context, msg *email.Email, a actions.Params) error {
+	if err := p.notifyDesktop(ctx, a.RuleIndex, msg); err != nil {
+		return fmt.Errorf("failed to show desktop notification: %w", err)
+	}
+	return nil
+}
+
+func (p *EmailPoller) webhookAction(ctx context.Context, msg *email.Email, a actions.Params) error {
+	if err := p.postWebhook(ctx, a.Account, a.RuleIndex, msg, a.Key); err != nil {
+		return fmt.Errorf("failed to post webhook: %w", err)
+	}
+	return nil
+}
+
+func (p *EmailPoller) archiveAction(ctx context.Context, msg *email.Email, a actions.Params) error {
+	if err := p.archive(ctx, a.Account, a.Provider, a.Rule, a.Key, msg); err != nil {
+		return fmt.Errorf("failed to archive message: %w", err)
+	}
+	return nil
+}
+
+func (p *EmailPoller) execAction(ctx context.Context, msg *email.Email, a actions.Params) error {
+	if err := p.runCommand(ctx, a.Account, a.Rule, a.Key, msg); err != nil {
+		return fmt.Errorf("failed to run command: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/actions"
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/loopguard"
+	"github.com/mshan/go-tsk/internal/store"
+)
+
+// labelProvider records the labels applied to messages, failing those in
+// reject
+type labelProvider struct {
+	email.Provider
+	labels []string
+	reject map[string]bool
+}
+
+func (l *labelProvider) ApplyLabel(ctx context.Context, mailbox string, uid uint32, label string) error {
+	l.labels = append(l.labels, label)
+	if l.reject[label] {
+		return errors.New("rejected")
+	}
+	return nil
+}
+
+func TestApplyActionRegistry(t *testing.T) {
+	p := &EmailPoller{guard: loopguard.New(config.LoopConfig{}, nil)}
+	registry, err := p.newActionRegistry()
+	if err != nil {
+		t.Fatalf("newActionRegistry: %v", err)
+	}
+	var got actions.Params
+	if err := registry.Register("record", actions.Func(func(ctx context.Context, msg *email.Email, params actions.Params) error {
+		got = params
+		return nil
+	})); err != nil {
+		t.Fatal(err)
+	}
+	p.actions = registry
+	provider := &labelProvider{}
+	account := config.EmailAccount{ID: "work"}
+	msg := &email.Email{Mailbox: "INBOX", UID: 3, Subject: "Hi"}
+
+	if err := p.applyAction(context.Background(), account, provider, 2, config.Rule{Label: "Done"}, "k1", msg); err != nil {
+		t.Fatalf("applyAction label: %v", err)
+	}
+	if len(provider.labels) != 1 || provider.labels[0] != "Done" {
+		t.Errorf("labels = %v", provider.labels)
+	}
+
+	rule := config.Rule{Action: "record", Params: map[string]string{"to": "ops"}}
+	if err := p.applyAction(context.Background(), account, provider, 1, rule, "k2", msg); err != nil {
+		t.Fatalf("applyAction record: %v", err)
+	}
+	if got.Account.ID != "work" || got.RuleIndex != 1 || got.Key != "k2" || got.Rule.Params["to"] != "ops" || got.Provider != provider {
+		t.Errorf("params = %+v", got)
+	}
+
+	if err := p.applyAction(context.Background(), account, provider, 0, config.Rule{Action: "missing"}, "k3", msg); err == nil {
+		t.Error("applyAction of an unknown action succeeded")
+	}
+}
+
+func TestApplyRulesChain(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Poll.Rules = []config.Rule{{
+		SubjectContains: "Invoice",
+		Label:           "Bills",
+		Actions: []config.RuleAction{
+			{Label: "Unread", OnError: "continue"},
+			{Action: "notify"},
+			{},
+			{Label: "Later"},
+		},
+	}}
+	p, err := NewEmailPoller(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewEmailPoller: %v", err)
+	}
+	t.Cleanup(p.Stop)
+	msg := &email.Email{Mailbox: "INBOX", UID: 1, Subject: "Invoice 42"}
+
+	tests := []struct {
+		name       string
+		reject     map[string]bool
+		wantLabels []string
+		wantFailed bool
+	}{
+		{"all succeed", nil, []string{"Unread", "Bills", "Later"}, false},
+		{"continue past a failure", map[string]bool{"Unread": true}, []string{"Unread", "Bills", "Later"}, true},
+		{"abort on a failure", map[string]bool{"Bills": true}, []string{"Unread", "Bills"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &labelProvider{reject: tt.reject}
+			p.rulesMu.Lock()
+			entries, failed := p.applyRules(context.Background(), cfg.EmailAccounts[0], provider, tt.name, msg)
+			p.rulesMu.Unlock()
+			if !reflect.DeepEqual(provider.labels, tt.wantLabels) || failed != tt.wantFailed {
+				t.Errorf("labels = %v, failed = %v; want %v, %v", provider.labels, failed, tt.wantLabels, tt.wantFailed)
+			}
+			if len(entries) != 1 || entries[0].Label != "Bills" {
+				t.Errorf("notify entries = %+v", entries)
+			}
+		})
+	}
+}
+
+// journalStore keeps the processed journal in memory
+type journalStore struct {
+	store.Store
+	keys map[string]bool
+}
+
+func (s *journalStore) IsProcessed(accountID, key string) (bool, error) {
+	return s.keys[accountID+" "+key], nil
+}
+
+func (s *journalStore) MarkProcessed(accountID, key string, at time.Time) error {
+	s.keys[accountID+" "+key] = true
+	return nil
+}
+
+func (s *journalStore) SaveRuleStats(key string, rs store.RuleStats) error { return nil }
+
+func TestApplyRuleRetriesOnce(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Poll.Retry = config.RetryConfig{Attempts: 3, Delay: time.Millisecond}
+	cfg.Poll.Rules = []config.Rule{{
+		SubjectContains: "Invoice",
+		Actions:         []config.RuleAction{{Label: "Bills"}, {Action: "record"}},
+	}}
+	p, err := NewEmailPoller(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewEmailPoller: %v", err)
+	}
+	t.Cleanup(p.Stop)
+	p.store = &journalStore{keys: make(map[string]bool)}
+
+	calls := 0
+	if err := p.actions.Register("record", actions.Func(func(ctx context.Context, msg *email.Email, params actions.Params) error {
+		calls++
+		if calls == 1 {
+			return errors.New("connection reset")
+		}
+		return nil
+	})); err != nil {
+		t.Fatal(err)
+	}
+	provider := &labelProvider{}
+	msg := &email.Email{Mailbox: "INBOX", UID: 1, Subject: "Invoice 42"}
+
+	// The first try fails and is retried; processing the message again
+	// repeats neither the label, now on the message, nor the recorded action
+	for i := 0; i < 2; i++ {
+		p.rulesMu.Lock()
+		_, failed := p.applyRules(context.Background(), cfg.EmailAccounts[0], provider, "k1", msg)
+		p.rulesMu.Unlock()
+		if failed {
+			t.Errorf("pass %d failed", i+1)
+		}
+		msg.Flags = provider.labels
+	}
+	if calls != 2 {
+		t.Errorf("record ran %d times; want 2, one failing", calls)
+	}
+	if !reflect.DeepEqual(provider.labels, []string{"Bills"}) {
+		t.Errorf("labels = %v; want [Bills]", provider.labels)
+	}
+}
+
+func TestProcessEmailsSkipsLegacyKeys(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Poll.Rules = []config.Rule{{
+		SubjectContains: "Invoice",
+		Actions:         []config.RuleAction{{Action: "record"}},
+	}}
+	p, err := NewEmailPoller(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewEmailPoller: %v", err)
+	}
+	t.Cleanup(p.Stop)
+	account := cfg.EmailAccounts[0]
+	// Journaled before keys named the mailbox
+	p.store = &journalStore{keys: map[string]bool{account.ID + " uid:9:1": true}}
+
+	var recorded []uint32
+	if err := p.actions.Register("record", actions.Func(func(ctx context.Context, msg *email.Email, params actions.Params) error {
+		recorded = append(recorded, msg.UID)
+		return nil
+	})); err != nil {
+		t.Fatal(err)
+	}
+	p.processEmails(context.Background(), account, &labelProvider{}, 9, []*email.Email{
+		{Mailbox: "INBOX", UID: 1, Subject: "Invoice 1"},
+		{Mailbox: "Receipts", UID: 1, Subject: "Invoice 2"},
+		{Mailbox: "INBOX", UID: 2, Subject: "Invoice 3"},
+	})
+	if !reflect.DeepEqual(recorded, []uint32{1, 2}) {
+		t.Errorf("recorded UIDs = %v; want [1 2], from Receipts and INBOX", recorded)
+	}
+}
@@ -0,0 +1,66 @@
+package scheduler
+
+import (
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+// defaultEmptyPolls is how many empty polls in a row lengthen the adaptive
+// interval when the config leaves it unset
+const defaultEmptyPolls = 3
+
+// adaptiveInterval adjusts an account's poll interval to its mail volume
+type adaptiveInterval struct {
+	min, max   time.Duration
+	emptyPolls int
+	current    time.Duration
+	empty      int // Empty polls in a row since the interval last changed
+}
+
+// newAdaptiveInterval creates an adaptive interval starting at the poll
+// interval, or returns nil if adaptive polling is disabled
+func newAdaptiveInterval(cfg config.PollConfig) *adaptiveInterval {
+	if !cfg.Adaptive.Enabled {
+		return nil
+	}
+	a := &adaptiveInterval{
+		min:        cfg.Adaptive.Min,
+		max:        cfg.Adaptive.Max,
+		emptyPolls: cfg.Adaptive.EmptyPolls,
+		current:    cfg.Interval,
+	}
+	if a.min <= 0 {
+		a.min = cfg.Interval / 4
+	}
+	if a.max <= 0 {
+		a.max = cfg.Interval * 4
+	}
+	if a.max < a.min {
+		a.max = a.min
+	}
+	if a.emptyPolls <= 0 {
+		a.emptyPolls = defaultEmptyPolls
+	}
+	return a
+}
+
+// Next returns the interval after a poll that fetched the given number of
+// messages
+func (a *adaptiveInterval) Next(fetched int) time.Duration {
+	if fetched > 0 {
+		a.empty = 0
+		a.current /= 2
+	} else if a.empty++; a.empty >= a.emptyPolls {
+		a.empty = 0
+		a.current *= 2
+	}
+
+	if a.current < a.min {
+		a.current = a.min
+	}
+	if a.current > a.max {
+		a.current = a.max
+	}
+	return a.current
+}
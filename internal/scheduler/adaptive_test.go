@@ -0,0 +1,46 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+func TestAdaptiveIntervalNext(t *testing.T) {
+	a := newAdaptiveInterval(config.PollConfig{
+		Interval: 4 * time.Minute,
+		Adaptive: config.AdaptiveConfig{Enabled: true, EmptyPolls: 2},
+	})
+
+	tests := []struct {
+		fetched int
+		want    time.Duration
+	}{
+		{5, 2 * time.Minute},
+		{1, time.Minute},
+		{3, time.Minute}, // Min is a quarter of the interval
+		{0, time.Minute},
+		{0, 2 * time.Minute},
+		{0, 2 * time.Minute},
+		{0, 4 * time.Minute},
+		{1, 2 * time.Minute}, // Mail resets the empty streak
+		{0, 2 * time.Minute},
+		{0, 4 * time.Minute},
+		{0, 4 * time.Minute},
+		{0, 8 * time.Minute},
+		{0, 8 * time.Minute},
+		{0, 16 * time.Minute},
+		{0, 16 * time.Minute},
+		{0, 16 * time.Minute}, // Max is four times the interval
+	}
+	for i, tt := range tests {
+		if got := a.Next(tt.fetched); got != tt.want {
+			t.Errorf("Next(%d) #%d = %s; want %s", tt.fetched, i+1, got, tt.want)
+		}
+	}
+
+	if newAdaptiveInterval(config.PollConfig{Interval: time.Minute}) != nil {
+		t.Error("newAdaptiveInterval() without Enabled is not nil")
+	}
+}
@@ -0,0 +1,74 @@
+package scheduler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/logging"
+	"github.com/mshan/go-tsk/internal/metrics"
+)
+
+// errNoRawFetch is returned when archiving from a provider that cannot
+// download raw messages
+var errNoRawFetch = errors.New("the provider cannot download raw messages")
+
+// archive saves a matched message to the rule's archive directory as
+// <ArchiveDir>/<account>/<YYYY-MM>/<hash>.eml. The file name is derived
+// from the message key, so archiving a message again leaves the first copy
+// alone.
+func (p *EmailPoller) archive(ctx context.Context, account config.EmailAccount, client email.Provider, rule config.Rule, key string, msg *email.Email) error {
+	path := archivePath(rule.ArchiveDir, account.ID, key, msg.Date)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	fetcher, ok := client.(email.RawFetcher)
+	if !ok {
+		return errNoRawFetch
+	}
+	raw, err := fetcher.FetchRaw(ctx, msg.Mailbox, msg.UID)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".archive-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	metrics.Add(account.ID, "messages_archived", 1)
+	log.Printf("Archived email with subject: %s to %s", logging.Subject(msg.Subject), path)
+	return nil
+}
+
+// archivePath returns the file a message is archived to. Messages without
+// a date are filed under the current month.
+func archivePath(dir, accountID, key string, date time.Time) string {
+	if date.IsZero() {
+		date = time.Now()
+	}
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(dir, accountID, date.UTC().Format("2006-01"), hex.EncodeToString(sum[:8])+".eml")
+}
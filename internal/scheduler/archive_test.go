@@ -0,0 +1,62 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+// rawProvider serves one raw message and counts downloads
+type rawProvider struct {
+	email.Provider
+	raw     []byte
+	fetches int
+}
+
+func (r *rawProvider) FetchRaw(ctx context.Context, mailbox string, uid uint32) ([]byte, error) {
+	r.fetches++
+	return r.raw, nil
+}
+
+func TestArchive(t *testing.T) {
+	dir := t.TempDir()
+	p := &EmailPoller{}
+	account := config.EmailAccount{ID: "legacy"}
+	rule := config.Rule{Action: "archive", ArchiveDir: dir}
+	msg := &email.Email{Mailbox: "INBOX", UID: 7, Subject: "Invoice", Date: time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC)}
+	provider := &rawProvider{raw: []byte("Subject: Invoice\r\n\r\nPay up\r\n")}
+
+	if err := p.archive(context.Background(), account, provider, rule, "mid:<a@example.com>", msg); err != nil {
+		t.Fatalf("archive: %v", err)
+	}
+	path := archivePath(dir, "legacy", "mid:<a@example.com>", msg.Date)
+	if filepath.Dir(path) != filepath.Join(dir, "legacy", "2023-01") {
+		t.Errorf("path = %s", path)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if string(got) != string(provider.raw) {
+		t.Errorf("archived %q", got)
+	}
+
+	// Archiving again keeps the first copy without downloading
+	if err := p.archive(context.Background(), account, provider, rule, "mid:<a@example.com>", msg); err != nil {
+		t.Fatalf("second archive: %v", err)
+	}
+	if provider.fetches != 1 {
+		t.Errorf("fetches = %d, want 1", provider.fetches)
+	}
+
+	var plain struct{ email.Provider }
+	if err := p.archive(context.Background(), account, plain, rule, "mid:<b@example.com>", msg); !errors.Is(err, errNoRawFetch) {
+		t.Errorf("archive without FetchRaw = %v, want errNoRawFetch", err)
+	}
+}
@@ -0,0 +1,155 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/store"
+)
+
+// BackfillOptions controls how existing mail is processed
+type BackfillOptions struct {
+	// Mailbox is the mailbox to backfill; empty means INBOX
+	Mailbox string
+	// BatchSize is the number of messages fetched per batch
+	BatchSize int
+	// Pause is the delay between batches, to stay under provider rate limits
+	Pause time.Duration
+	// Restart discards any saved progress and starts from the oldest message
+	Restart bool
+	// Progress, if set, is called after every batch
+	Progress func(BackfillProgress)
+}
+
+// BackfillProgress reports how far a backfill has got
+type BackfillProgress struct {
+	Processed int
+	Total     int
+	LastUID   uint32
+	Elapsed   time.Duration
+}
+
+// Backfill runs the rules over the mail that already exists in one of an
+// account's mailboxes, oldest first, in batches. Progress is checkpointed after every
+// batch so an interrupted backfill resumes where it stopped. Mail that
+// arrives once the backfill started is left to polling.
+func (p *EmailPoller) Backfill(ctx context.Context, accountID string, opts BackfillOptions) error {
+	account, ok := p.account(accountID)
+	if !ok {
+		return fmt.Errorf("unknown account %q", accountID)
+	}
+	if opts.BatchSize <= 0 {
+		return fmt.Errorf("batch size must be positive, got %d", opts.BatchSize)
+	}
+	mailbox := opts.Mailbox
+	if mailbox == "" {
+		mailbox = email.Inbox
+	}
+
+	var cp store.BackfillCheckpoint
+	if p.store == nil {
+		log.Printf("No state store configured; backfill for account %s will not be resumable", account.ID)
+	} else {
+		if opts.Restart {
+			if err := p.store.ResetBackfill(account.ID, mailbox); err != nil {
+				return fmt.Errorf("failed to reset backfill progress: %w", err)
+			}
+		}
+		var err error
+		if cp, err = p.store.BackfillCheckpoint(account.ID, mailbox); err != nil {
+			return fmt.Errorf("failed to load backfill progress: %w", err)
+		}
+	}
+	if cp.Completed {
+		log.Printf("Backfill of %s for account %s already completed; use restart to run it again", mailbox, account.ID)
+		return nil
+	}
+
+	client, err := p.connect(ctx, account)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	status, err := client.Status(ctx, mailbox)
+	if err != nil {
+		return fmt.Errorf("failed to get mailbox status: %w", err)
+	}
+	total := status.Messages
+
+	start := time.Now()
+	for {
+		emails, err := client.FetchBatch(ctx, mailbox, cp.LastUID, opts.BatchSize)
+		if err != nil {
+			return fmt.Errorf("failed to fetch batch after UID %d: %w", cp.LastUID, err)
+		}
+		if status.BatchEnd > 0 {
+			emails = uidsUpTo(emails, status.BatchEnd)
+		}
+		if len(emails) == 0 || total == 0 {
+			cp.Completed = true
+		} else {
+			p.addressLists.refresh(ctx)
+			matched := p.processEmails(ctx, account, client, status.UIDValidity, emails)
+			p.sendDigest(ctx, account, matched)
+			cp.Processed += len(emails)
+			// Servers may return a fetch in any order
+			for _, msg := range emails {
+				if msg.UID > cp.LastUID {
+					cp.LastUID = msg.UID
+				}
+			}
+		}
+
+		if p.store != nil {
+			if err := p.store.SaveBackfillCheckpoint(account.ID, mailbox, cp); err != nil {
+				return fmt.Errorf("failed to save backfill progress: %w", err)
+			}
+		}
+		if opts.Progress != nil {
+			opts.Progress(BackfillProgress{
+				Processed: cp.Processed,
+				Total:     total,
+				LastUID:   cp.LastUID,
+				Elapsed:   time.Since(start),
+			})
+		}
+		if cp.Completed {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(opts.Pause):
+		}
+	}
+}
+
+// uidsUpTo returns the messages of emails with UIDs up to last
+func uidsUpTo(emails []*email.Email, last uint32) []*email.Email {
+	kept := emails[:0]
+	for _, msg := range emails {
+		if msg.UID <= last {
+			kept = append(kept, msg)
+		}
+	}
+	return kept
+}
+
+// account looks up a configured account by ID
+func (p *EmailPoller) account(id string) (config.EmailAccount, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, account := range p.config.EmailAccounts {
+		if account.ID == id {
+			return account, true
+		}
+	}
+	return config.EmailAccount{}, false
+}
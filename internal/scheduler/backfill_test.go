@@ -0,0 +1,126 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mshan/go-tsk/internal/imaptest"
+	"github.com/mshan/go-tsk/internal/store"
+)
+
+// checkpointStore keeps backfill checkpoints in memory
+type checkpointStore struct {
+	journalStore
+	checkpoints map[string]store.BackfillCheckpoint
+}
+
+func (s *checkpointStore) BackfillCheckpoint(accountID, mailbox string) (store.BackfillCheckpoint, error) {
+	return s.checkpoints[accountID+" "+mailbox], nil
+}
+
+func (s *checkpointStore) SaveBackfillCheckpoint(accountID, mailbox string, cp store.BackfillCheckpoint) error {
+	s.checkpoints[accountID+" "+mailbox] = cp
+	return nil
+}
+
+func (s *checkpointStore) ResetBackfill(accountID, mailbox string) error {
+	delete(s.checkpoints, accountID+" "+mailbox)
+	return nil
+}
+
+func TestBackfill(t *testing.T) {
+	srv := imaptest.New(t,
+		imaptest.Message{Subject: "Job opportunity 1", From: "jobs@example.com"},
+		imaptest.Message{Subject: "Weekly newsletter", From: "news@example.com"},
+		imaptest.Message{Subject: "Job opportunity 2", From: "jobs@example.com"},
+	)
+	p, account := newTestPoller(t, srv)
+	st := &checkpointStore{
+		journalStore: journalStore{keys: make(map[string]bool)},
+		checkpoints:  make(map[string]store.BackfillCheckpoint),
+	}
+	p.store = st
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Stop after the first batch, as on Ctrl-C, with new mail arriving
+	var progress []BackfillProgress
+	err := p.Backfill(ctx, account.ID, BackfillOptions{
+		BatchSize: 2,
+		Progress: func(bp BackfillProgress) {
+			progress = append(progress, bp)
+			srv.Append("INBOX", imaptest.Message{Subject: "Job opportunity 3", From: "jobs@example.com"})
+			cancel()
+		},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Backfill() error = %v; want context.Canceled", err)
+	}
+	if len(progress) != 1 || progress[0].Processed != 2 || progress[0].Total != 3 || progress[0].LastUID != 2 {
+		t.Fatalf("progress = %+v; want 2 of 3 processed up to UID 2", progress)
+	}
+	if flags := srv.Flags("INBOX", 3); len(flags) != 0 {
+		t.Errorf("flags of unprocessed message 3 = %v; want none", flags)
+	}
+
+	// Resuming processes the rest, up to the mail there was when it
+	// started
+	srv.Append("INBOX", imaptest.Message{Subject: "Job opportunity 4", From: "jobs@example.com"})
+	progress = nil
+	if err := p.Backfill(context.Background(), account.ID, BackfillOptions{
+		BatchSize: 2,
+		Progress: func(bp BackfillProgress) {
+			progress = append(progress, bp)
+			if len(progress) == 1 {
+				srv.Append("INBOX", imaptest.Message{Subject: "Job opportunity 5", From: "jobs@example.com"})
+			}
+		},
+	}); err != nil {
+		t.Fatalf("resumed Backfill() error = %v", err)
+	}
+	cp := st.checkpoints[account.ID+" INBOX"]
+	if !cp.Completed || cp.Processed != 5 || cp.LastUID != 5 {
+		t.Errorf("checkpoint = %+v; want completed after 5 messages up to UID 5", cp)
+	}
+	for uid, want := range map[uint32]bool{1: true, 2: false, 3: true, 4: true, 5: true, 6: false} {
+		if flags := srv.Flags("INBOX", uid); (len(flags) == 1 && flags[0] == "imp") != want {
+			t.Errorf("flags of message %d = %v; labeled imp should be %v", uid, flags, want)
+		}
+	}
+
+	// A completed backfill only runs again with Restart
+	if err := p.Backfill(context.Background(), account.ID, BackfillOptions{BatchSize: 2}); err != nil {
+		t.Fatalf("completed Backfill() error = %v", err)
+	}
+	if st.checkpoints[account.ID+" INBOX"] != cp {
+		t.Errorf("completed backfill ran again")
+	}
+	if err := p.Backfill(context.Background(), account.ID, BackfillOptions{BatchSize: 10, Restart: true}); err != nil {
+		t.Fatalf("restarted Backfill() error = %v", err)
+	}
+	if cp := st.checkpoints[account.ID+" INBOX"]; !cp.Completed || cp.Processed != 6 || cp.LastUID != 6 {
+		t.Errorf("restarted checkpoint = %+v; want completed after 6 messages up to UID 6", cp)
+	}
+}
+
+func TestBackfillEmptyMailbox(t *testing.T) {
+	srv := imaptest.New(t)
+	p, account := newTestPoller(t, srv)
+
+	err := p.Backfill(context.Background(), account.ID, BackfillOptions{
+		BatchSize: 2,
+		Progress: func(bp BackfillProgress) {
+			if bp.Processed != 0 {
+				t.Errorf("progress = %+v; want nothing processed", bp)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("Backfill() error = %v", err)
+	}
+	srv.Append("INBOX", imaptest.Message{Subject: "Job opportunity", From: "jobs@example.com"})
+	if flags := srv.Flags("INBOX", 1); len(flags) != 0 {
+		t.Errorf("flags of message arriving after the backfill = %v; want none", flags)
+	}
+}
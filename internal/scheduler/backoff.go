@@ -0,0 +1,81 @@
+package scheduler
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+// Default backoff settings used when the config leaves them unset
+const (
+	defaultBackoffInitial    = 30 * time.Second
+	defaultBackoffMax        = 30 * time.Minute
+	defaultBackoffMultiplier = 2.0
+)
+
+// backoff computes exponentially growing, jittered delays between retries
+type backoff struct {
+	initial    time.Duration
+	max        time.Duration
+	multiplier float64
+	jitter     float64
+	attempts   int
+	rand       *rand.Rand
+}
+
+// newBackoff creates a backoff from the config, filling in defaults
+func newBackoff(cfg config.BackoffConfig) *backoff {
+	b := &backoff{
+		initial:    cfg.Initial,
+		max:        cfg.Max,
+		multiplier: cfg.Multiplier,
+		jitter:     cfg.Jitter,
+		rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if b.initial <= 0 {
+		b.initial = defaultBackoffInitial
+	}
+	if b.max < b.initial {
+		b.max = defaultBackoffMax
+		if b.max < b.initial {
+			b.max = b.initial
+		}
+	}
+	if b.multiplier < 1 {
+		b.multiplier = defaultBackoffMultiplier
+	}
+	if b.jitter < 0 || b.jitter > 1 {
+		b.jitter = 0
+	}
+	return b
+}
+
+// Next records a failure and returns how long to wait before retrying
+func (b *backoff) Next() time.Duration {
+	delay := float64(b.initial) * math.Pow(b.multiplier, float64(b.attempts))
+	if delay > float64(b.max) {
+		delay = float64(b.max)
+	}
+	b.attempts++
+
+	// Spread retries by +/- jitter so accounts don't retry in lockstep
+	if b.jitter > 0 {
+		delay *= 1 + b.jitter*(2*b.rand.Float64()-1)
+	}
+	if delay > float64(b.max) {
+		delay = float64(b.max)
+	}
+	return time.Duration(delay)
+}
+
+// Reset clears the failure count after a successful attempt
+func (b *backoff) Reset() {
+	b.attempts = 0
+}
+
+// Attempts returns the number of consecutive failures
+func (b *backoff) Attempts() int {
+	return b.attempts
+}
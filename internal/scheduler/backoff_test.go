@@ -0,0 +1,65 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+func TestBackoffNext(t *testing.T) {
+	b := newBackoff(config.BackoffConfig{
+		Initial:    time.Second,
+		Max:        10 * time.Second,
+		Multiplier: 2,
+	})
+
+	expected := []time.Duration{
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		8 * time.Second,
+		10 * time.Second,
+		10 * time.Second,
+	}
+	for i, want := range expected {
+		if got := b.Next(); got != want {
+			t.Errorf("Next() #%d = %s; want %s", i+1, got, want)
+		}
+	}
+
+	if got := b.Attempts(); got != len(expected) {
+		t.Errorf("Attempts() = %d; want %d", got, len(expected))
+	}
+
+	b.Reset()
+	if got := b.Next(); got != time.Second {
+		t.Errorf("Next() after Reset() = %s; want %s", got, time.Second)
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	b := newBackoff(config.BackoffConfig{
+		Initial:    10 * time.Second,
+		Max:        time.Minute,
+		Multiplier: 2,
+		Jitter:     0.5,
+	})
+
+	for i := 0; i < 100; i++ {
+		b.Reset()
+		if got := b.Next(); got < 5*time.Second || got > 15*time.Second {
+			t.Fatalf("Next() = %s; want within [5s, 15s]", got)
+		}
+	}
+}
+
+func TestBackoffDefaults(t *testing.T) {
+	b := newBackoff(config.BackoffConfig{})
+	if got := b.Next(); got != defaultBackoffInitial {
+		t.Errorf("Next() = %s; want %s", got, defaultBackoffInitial)
+	}
+	if b.max != defaultBackoffMax {
+		t.Errorf("max = %s; want %s", b.max, defaultBackoffMax)
+	}
+}
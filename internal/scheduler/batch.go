@@ -0,0 +1,211 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/metrics"
+	"github.com/mshan/go-tsk/internal/tracing"
+)
+
+// batchKey is the context key of the storeBatch of the messages being
+// processed
+type batchKey struct{}
+
+// batchStepKey is the context key of the batchStep of the action being run
+type batchStepKey struct{}
+
+// errBatchSkipped fails the operations of a message whose earlier
+// operation in the batch failed
+var errBatchSkipped = errors.New("an earlier operation on the message failed")
+
+// storeBatch collects the labels and moves of a batch of messages, so
+// each label and destination costs one command per mailbox rather than
+// one per message. Labels are applied before moves, as a move changes a
+// message's UID.
+type storeBatch struct {
+	ops   map[batchTarget]*batchOp
+	order []batchTarget
+}
+
+// batchTarget identifies the operations sent as one command
+type batchTarget struct {
+	move    bool
+	mailbox string
+	target  string // Label, or destination mailbox of a move
+}
+
+// batchOp is one batched label or move
+type batchOp struct {
+	batchTarget
+	entries []batchEntry
+}
+
+// batchEntry is a message of a batched operation
+type batchEntry struct {
+	key  string
+	uid  uint32
+	done func() // Run once the command succeeded
+	undo func() // Run if it failed
+
+	settle func(err error) // Run with the outcome either way
+}
+
+// withBatch returns a context holding the batch the messages' last chain
+// steps are collected into
+func withBatch(ctx context.Context, batch *storeBatch) context.Context {
+	return context.WithValue(ctx, batchKey{}, batch)
+}
+
+// batchFrom returns the batch of a context, or nil if there is none
+func batchFrom(ctx context.Context) *storeBatch {
+	batch, _ := ctx.Value(batchKey{}).(*storeBatch)
+	return batch
+}
+
+// batchStep queues the operations of one chain step into a batch. The
+// step's outcome is only known once the batch is flushed, when settle is
+// called with it.
+type batchStep struct {
+	batch  *storeBatch
+	settle func(err error)
+	queued bool // Whether the step queued an operation
+}
+
+// withBatchStep returns a context whose label and snooze actions are
+// queued into step instead of being sent at once
+func withBatchStep(ctx context.Context, step *batchStep) context.Context {
+	return context.WithValue(ctx, batchStepKey{}, step)
+}
+
+// batchStepFrom returns the batch step of a context, or nil if actions are
+// to be sent at once
+func batchStepFrom(ctx context.Context) *batchStep {
+	step, _ := ctx.Value(batchStepKey{}).(*batchStep)
+	return step
+}
+
+// label queues adding label to a message of mailbox
+func (s *batchStep) label(mailbox, label string, entry batchEntry) {
+	s.queued = true
+	entry.settle = s.settle
+	s.batch.label(mailbox, label, entry)
+}
+
+// move queues moving a message from mailbox to dest
+func (s *batchStep) move(mailbox, dest string, entry batchEntry) {
+	s.queued = true
+	entry.settle = s.settle
+	s.batch.move(mailbox, dest, entry)
+}
+
+// label queues adding label to a message of mailbox
+func (b *storeBatch) label(mailbox, label string, entry batchEntry) {
+	b.add(batchTarget{mailbox: mailbox, target: label}, entry)
+}
+
+// move queues moving a message from mailbox to dest
+func (b *storeBatch) move(mailbox, dest string, entry batchEntry) {
+	b.add(batchTarget{move: true, mailbox: mailbox, target: dest}, entry)
+}
+
+func (b *storeBatch) add(target batchTarget, entry batchEntry) {
+	if b.ops == nil {
+		b.ops = make(map[batchTarget]*batchOp)
+	}
+	op, ok := b.ops[target]
+	if !ok {
+		op = &batchOp{batchTarget: target}
+		b.ops[target] = op
+		b.order = append(b.order, target)
+	}
+	op.entries = append(op.entries, entry)
+}
+
+// flushBatch sends the queued operations of a batch and returns the keys
+// of the messages whose operations failed. A message whose label failed is
+// not moved, so a retry finds it where it was.
+func (p *EmailPoller) flushBatch(ctx context.Context, account config.EmailAccount, client email.Provider, batch *storeBatch) map[string]bool {
+	failed := make(map[string]bool)
+	for _, move := range []bool{false, true} {
+		for _, target := range batch.order {
+			if target.move != move {
+				continue
+			}
+			op := batch.ops[target]
+			var entries []batchEntry
+			var uids []uint32
+			for _, entry := range op.entries {
+				if failed[entry.key] {
+					entry.finish(errBatchSkipped)
+					continue
+				}
+				entries = append(entries, entry)
+				uids = append(uids, entry.uid)
+			}
+			if len(uids) == 0 {
+				continue
+			}
+
+			err := p.sendBatch(ctx, account, client, op.batchTarget, uids)
+			for _, entry := range entries {
+				if err != nil {
+					failed[entry.key] = true
+				}
+				entry.finish(err)
+			}
+			if err != nil {
+				log.Printf("Failed to %s of account %s: %v", op.describe(len(uids)), account.ID, err)
+				metrics.Add(account.ID, "batch_errors", 1)
+			}
+		}
+	}
+	return failed
+}
+
+// sendBatch sends one batched operation
+func (p *EmailPoller) sendBatch(ctx context.Context, account config.EmailAccount, client email.Provider, target batchTarget, uids []uint32) error {
+	ctx, span := tracing.Start(ctx, "store", tracing.Account(account.ID), tracing.Mailbox(target.mailbox))
+	span.SetAttributes(tracing.Messages(len(uids)))
+	ctx, cancel := withTimeout(ctx, p.config.Poll.ActionTimeout, defaultActionTimeout)
+	defer cancel()
+	var err error
+	if target.move {
+		mover, ok := client.(email.Mover)
+		if !ok {
+			err = errNoMove
+		} else {
+			err = email.MoveMessages(ctx, mover, target.mailbox, uids, target.target)
+		}
+	} else {
+		err = email.ApplyLabels(ctx, client, target.mailbox, uids, target.target)
+	}
+	err = timedOut(ctx, account, "action", err)
+	tracing.End(span, err)
+	metrics.Add(account.ID, "batched_stores", 1)
+	return err
+}
+
+// describe names a batched operation for logs
+func (t batchTarget) describe(n int) string {
+	if t.move {
+		return fmt.Sprintf("move %d messages from %s to %s", n, t.mailbox, t.target)
+	}
+	return fmt.Sprintf("apply label '%s' to %d messages in %s", t.target, n, t.mailbox)
+}
+
+// finish runs the entry's callbacks for the outcome of its operation
+func (e batchEntry) finish(err error) {
+	if err != nil && e.undo != nil {
+		e.undo()
+	} else if err == nil && e.done != nil {
+		e.done()
+	}
+	if e.settle != nil {
+		e.settle(err)
+	}
+}
@@ -0,0 +1,167 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/events"
+)
+
+// batchProvider records the batched commands it receives, failing the
+// labels in reject
+type batchProvider struct {
+	email.Provider
+	commands []string
+	reject   map[string]bool
+}
+
+func (b *batchProvider) ApplyLabels(ctx context.Context, mailbox string, uids []uint32, label string) error {
+	b.commands = append(b.commands, fmt.Sprintf("label %s %s %v", mailbox, label, uids))
+	if b.reject[label] {
+		return errors.New("rejected")
+	}
+	return nil
+}
+
+func (b *batchProvider) ApplyLabel(ctx context.Context, mailbox string, uid uint32, label string) error {
+	return b.ApplyLabels(ctx, mailbox, []uint32{uid}, label)
+}
+
+func (b *batchProvider) MoveMessage(ctx context.Context, mailbox string, uid uint32, dest string) error {
+	return b.MoveMessages(ctx, mailbox, []uint32{uid}, dest)
+}
+
+func (b *batchProvider) MoveMessages(ctx context.Context, mailbox string, uids []uint32, dest string) error {
+	b.commands = append(b.commands, fmt.Sprintf("move %s %s %v", mailbox, dest, uids))
+	return nil
+}
+
+func TestFlushBatch(t *testing.T) {
+	tests := []struct {
+		name         string
+		reject       map[string]bool
+		wantCommands []string
+		wantFailed   map[string]bool
+		wantDone     []string
+		wantUndone   []string
+	}{
+		{
+			name: "one command per label and destination",
+			wantCommands: []string{
+				"label INBOX Work [1 2]",
+				"label INBOX Bills [3]",
+				"move INBOX Snoozed [2 3]",
+			},
+			wantFailed: map[string]bool{},
+			wantDone:   []string{"k1", "k2", "k3", "k2", "k3"},
+		},
+		{
+			name:   "failed label skips the move",
+			reject: map[string]bool{"Work": true},
+			wantCommands: []string{
+				"label INBOX Work [1 2]",
+				"label INBOX Bills [3]",
+				"move INBOX Snoozed [3]",
+			},
+			wantFailed: map[string]bool{"k1": true, "k2": true},
+			wantDone:   []string{"k3", "k3"},
+			wantUndone: []string{"k2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var done, undone []string
+			label := func(key string, uid uint32) batchEntry {
+				return batchEntry{key: key, uid: uid, done: func() { done = append(done, key) }}
+			}
+			move := func(key string, uid uint32) batchEntry {
+				entry := label(key, uid)
+				entry.undo = func() { undone = append(undone, key) }
+				return entry
+			}
+			// Moves are queued first but sent after the labels
+			batch := &storeBatch{}
+			batch.move("INBOX", "Snoozed", move("k2", 2))
+			batch.label("INBOX", "Work", label("k1", 1))
+			batch.label("INBOX", "Bills", label("k3", 3))
+			batch.label("INBOX", "Work", label("k2", 2))
+			batch.move("INBOX", "Snoozed", move("k3", 3))
+
+			provider := &batchProvider{reject: tt.reject}
+			p := &EmailPoller{config: &config.Config{}}
+			failed := p.flushBatch(context.Background(), config.EmailAccount{ID: "work"}, provider, batch)
+			if !reflect.DeepEqual(provider.commands, tt.wantCommands) {
+				t.Errorf("commands = %q; want %q", provider.commands, tt.wantCommands)
+			}
+			if !reflect.DeepEqual(failed, tt.wantFailed) {
+				t.Errorf("failed = %v; want %v", failed, tt.wantFailed)
+			}
+			if !reflect.DeepEqual(undone, tt.wantUndone) {
+				t.Errorf("undone = %v; want %v", undone, tt.wantUndone)
+			}
+			if !reflect.DeepEqual(done, tt.wantDone) {
+				t.Errorf("done = %v; want %v", done, tt.wantDone)
+			}
+		})
+	}
+}
+
+func TestBatchedChainStep(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Poll.Rules = []config.Rule{{
+		SubjectContains: "invoice",
+		Actions:         []config.RuleAction{{Action: "label", Label: "Work"}, {Action: "label", Label: "Bills"}},
+	}}
+	p, err := NewEmailPoller(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewEmailPoller: %v", err)
+	}
+	t.Cleanup(p.Stop)
+	evs, unsubscribe := p.Subscribe(10)
+	defer unsubscribe()
+	applied := func() []string {
+		var labels []string
+		for {
+			select {
+			case ev := <-evs:
+				if ev.Type == events.TypeActionApplied {
+					labels = append(labels, ev.Data.(events.MessageData).Label)
+				}
+			default:
+				return labels
+			}
+		}
+	}
+
+	account := cfg.EmailAccounts[0]
+	provider := &batchProvider{reject: map[string]bool{"Bills": true}}
+	msg := &email.Email{Mailbox: "INBOX", UID: 4, Subject: "Your invoice"}
+	batch := &storeBatch{}
+	ctx := withBatch(context.Background(), batch)
+	if _, failed := p.applyRule(ctx, account, provider, 0, cfg.Poll.Rules[0], "k4", msg); failed {
+		t.Fatal("applyRule() failed before the batch was sent")
+	}
+
+	// The first step is sent at once and the last one queued
+	if want := []string{"label INBOX Work [4]"}; !reflect.DeepEqual(provider.commands, want) {
+		t.Errorf("commands before the flush = %q; want %q", provider.commands, want)
+	}
+	if got := applied(); !reflect.DeepEqual(got, []string{"Work"}) {
+		t.Errorf("applied before the flush = %q; want only Work", got)
+	}
+
+	if failed := p.flushBatch(ctx, account, provider, batch); !failed["k4"] {
+		t.Error("flushBatch() did not fail the rejected label")
+	}
+	if got := applied(); len(got) != 0 {
+		t.Errorf("applied after the failed flush = %q; want none", got)
+	}
+	if stats := p.RuleStats()[0]; stats.Actions != 1 || stats.Errors != 1 {
+		t.Errorf("stats = %d actions, %d errors; want 1 and 1", stats.Actions, stats.Errors)
+	}
+}
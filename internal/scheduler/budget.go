@@ -0,0 +1,101 @@
+package scheduler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+// Default rule budget settings used when the config leaves them unset
+const (
+	defaultBudgetLimit   = 100 * time.Millisecond
+	defaultBudgetStrikes = 5
+	defaultBudgetWindow  = 10 * time.Minute
+	defaultBudgetSuspend = time.Hour
+)
+
+// ruleBudget suspends rules that repeatedly take too long to evaluate, so
+// one pathological matcher cannot stall every poll. Rules are identified by
+// their index in the config.
+type ruleBudget struct {
+	limit   time.Duration
+	strikes int
+	window  time.Duration
+	suspend time.Duration
+	now     func() time.Time
+
+	mu    sync.Mutex
+	rules map[int]*ruleUsage
+}
+
+// ruleUsage is the budget state of one rule
+type ruleUsage struct {
+	overruns       []time.Time
+	suspendedUntil time.Time
+}
+
+// newRuleBudget creates a rule budget from the config, filling in defaults
+func newRuleBudget(cfg config.RuleBudgetConfig) *ruleBudget {
+	b := &ruleBudget{
+		limit:   cfg.Limit,
+		strikes: cfg.Strikes,
+		window:  cfg.Window,
+		suspend: cfg.Suspend,
+		now:     time.Now,
+		rules:   make(map[int]*ruleUsage),
+	}
+	if b.limit <= 0 {
+		b.limit = defaultBudgetLimit
+	}
+	if b.strikes <= 0 {
+		b.strikes = defaultBudgetStrikes
+	}
+	if b.window <= 0 {
+		b.window = defaultBudgetWindow
+	}
+	if b.suspend <= 0 {
+		b.suspend = defaultBudgetSuspend
+	}
+	return b
+}
+
+// Suspended reports whether a rule is currently suspended
+func (b *ruleBudget) Suspended(rule int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	u := b.rules[rule]
+	return u != nil && b.now().Before(u.suspendedUntil)
+}
+
+// Observe records how long a rule took on one message and returns true if
+// this overrun got the rule suspended
+func (b *ruleBudget) Observe(rule int, elapsed time.Duration) bool {
+	if elapsed <= b.limit {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	u := b.rules[rule]
+	if u == nil {
+		u = &ruleUsage{}
+		b.rules[rule] = u
+	}
+
+	now := b.now()
+	i := 0
+	for i < len(u.overruns) && now.Sub(u.overruns[i]) > b.window {
+		i++
+	}
+	u.overruns = append(u.overruns[i:], now)
+
+	if len(u.overruns) < b.strikes {
+		return false
+	}
+	u.overruns = nil
+	u.suspendedUntil = now.Add(b.suspend)
+	return true
+}
@@ -0,0 +1,109 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/events"
+	"github.com/mshan/go-tsk/internal/reporting"
+)
+
+func TestRuleBudget(t *testing.T) {
+	now := time.Date(2024, 3, 5, 9, 0, 0, 0, time.UTC)
+	b := newRuleBudget(config.RuleBudgetConfig{
+		Limit:   10 * time.Millisecond,
+		Strikes: 3,
+		Window:  time.Minute,
+		Suspend: time.Hour,
+	})
+	b.now = func() time.Time { return now }
+
+	steps := []struct {
+		name      string
+		advance   time.Duration
+		elapsed   time.Duration
+		suspended bool // return value of Observe
+	}{
+		{"fast", 0, time.Millisecond, false},
+		{"first overrun", 0, 50 * time.Millisecond, false},
+		{"second overrun", time.Second, 50 * time.Millisecond, false},
+		{"first overrun expired", 2 * time.Minute, 50 * time.Millisecond, false},
+		{"second in window", time.Second, 50 * time.Millisecond, false},
+		{"third in window", time.Second, 50 * time.Millisecond, true},
+	}
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		if got := b.Observe(0, step.elapsed); got != step.suspended {
+			t.Fatalf("%s: Observe() = %v; want %v", step.name, got, step.suspended)
+		}
+	}
+
+	if !b.Suspended(0) {
+		t.Error("rule 0 not suspended after three overruns")
+	}
+	if b.Suspended(1) {
+		t.Error("rule 1 suspended; want only rule 0")
+	}
+
+	now = now.Add(time.Hour + time.Second)
+	if b.Suspended(0) {
+		t.Error("rule 0 still suspended after the suspension ended")
+	}
+}
+
+func TestRuleSuspensionReported(t *testing.T) {
+	reports := make(chan reporting.Report, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var rep reporting.Report
+		json.NewDecoder(r.Body).Decode(&rep)
+		reports <- rep
+	}))
+	defer srv.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.Poll.Rules = []config.Rule{{SubjectContains: "invoice", Action: "label", Label: "bills"}}
+	cfg.Poll.RuleBudget = config.RuleBudgetConfig{Limit: time.Nanosecond, Strikes: 1}
+	cfg.Errors.WebhookURL = srv.URL
+	p, err := NewEmailPoller(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewEmailPoller: %v", err)
+	}
+	t.Cleanup(p.Stop)
+	evs, unsubscribe := p.Subscribe(10)
+	defer unsubscribe()
+
+	account := cfg.EmailAccounts[0]
+	msg := &email.Email{Mailbox: "INBOX", UID: 7, Subject: "Your invoice"}
+	p.matches(context.Background(), account, 0, cfg.Poll.Rules[0], msg)
+	if !p.budget.Suspended(0) {
+		t.Fatal("rule not suspended after overrunning its budget")
+	}
+
+	select {
+	case ev := <-evs:
+		data, _ := ev.Data.(events.MessageData)
+		if ev.Type != events.TypeRuleSuspended || data.UID != 7 || data.Rule != "subject contains invoice" {
+			t.Errorf("event = %s %+v; want the rule suspended on UID 7", ev.Type, ev.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("no event published for the suspension")
+	}
+
+	if err := p.reporter.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case rep := <-reports:
+		if rep.Kind != reporting.KindRuleSuspended || rep.Account != account.ID || rep.Context["rule"] != "0" {
+			t.Errorf("report = %+v; want rule 0 suspended in %s", rep, account.ID)
+		}
+	default:
+		t.Error("suspension not reported")
+	}
+}
@@ -0,0 +1,119 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/mshan/go-tsk/internal/actions"
+	"github.com/mshan/go-tsk/internal/calendar"
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/logging"
+	"github.com/mshan/go-tsk/internal/metrics"
+)
+
+func (p *EmailPoller) calendarAction(ctx context.Context, msg *email.Email, a actions.Params) error {
+	if err := p.addToCalendar(ctx, a.Account, a.Provider, a.Rule, msg); err != nil {
+		return fmt.Errorf("failed to add invite to calendar: %w", err)
+	}
+	return nil
+}
+
+// addToCalendar puts the events of a message's calendar invites on the
+// CalDAV calendar, or in the rule's CalendarDir, and removes those the
+// invites cancel. Updated invites replace the events they update. Replies
+// to the user's own invites are ignored.
+func (p *EmailPoller) addToCalendar(ctx context.Context, account config.EmailAccount, client email.Provider, rule config.Rule, msg *email.Email) error {
+	fetcher, ok := client.(email.RawFetcher)
+	if !ok {
+		return errNoRawFetch
+	}
+	raw, err := fetcher.FetchRaw(ctx, msg.Mailbox, msg.UID)
+	if err != nil {
+		return err
+	}
+	parts, err := email.CalendarParts(raw)
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		log.Printf("Email with subject %s has no calendar invite", logging.Subject(msg.Subject))
+		return nil
+	}
+
+	// Invites often carry the same calendar inline and attached; storing
+	// it twice is harmless
+	for _, part := range parts {
+		cal, err := calendar.Parse(part)
+		if err != nil {
+			return fmt.Errorf("invalid invite: %w", err)
+		}
+		switch cal.Method {
+		case "", calendar.MethodPublish, calendar.MethodRequest:
+			if err := p.putEvent(ctx, account, rule, cal); err != nil {
+				return err
+			}
+			metrics.Add(account.ID, "events_added", 1)
+			log.Printf("Added event %s to the calendar", logging.Subject(cal.Events[0].Summary))
+		case calendar.MethodCancel:
+			if err := p.deleteEvent(ctx, account, rule, cal.UID()); err != nil {
+				return err
+			}
+			metrics.Add(account.ID, "events_cancelled", 1)
+			log.Printf("Removed cancelled event %s from the calendar", logging.Subject(cal.Events[0].Summary))
+		}
+	}
+	return nil
+}
+
+// putEvent stores a calendar's events, replacing their earlier version
+func (p *EmailPoller) putEvent(ctx context.Context, account config.EmailAccount, rule config.Rule, cal *calendar.Calendar) error {
+	if rule.CalendarDir == "" {
+		if p.caldav == nil {
+			return fmt.Errorf("no CalDAV calendar configured")
+		}
+		return p.caldav.PutEvent(ctx, cal.UID(), cal.Object())
+	}
+
+	path := eventPath(rule.CalendarDir, account.ID, cal.UID())
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".event-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(cal.Object()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// deleteEvent removes the events with the given UID, if they were stored
+func (p *EmailPoller) deleteEvent(ctx context.Context, account config.EmailAccount, rule config.Rule, uid string) error {
+	if rule.CalendarDir == "" {
+		if p.caldav == nil {
+			return fmt.Errorf("no CalDAV calendar configured")
+		}
+		return p.caldav.DeleteEvent(ctx, uid)
+	}
+	if err := os.Remove(eventPath(rule.CalendarDir, account.ID, uid)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// eventPath returns the file the events with the given UID are written to
+func eventPath(dir, accountID, uid string) string {
+	return filepath.Join(dir, accountID, calendar.Filename(uid))
+}
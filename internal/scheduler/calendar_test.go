@@ -0,0 +1,66 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+// inviteMail returns a message carrying an invite with the given METHOD
+func inviteMail(method string) []byte {
+	return []byte("From: boss@example.com\r\nSubject: Invitation: Sync\r\n" +
+		"Content-Type: text/calendar; charset=utf-8; method=" + method + "\r\n\r\n" +
+		"BEGIN:VCALENDAR\r\nMETHOD:" + method + "\r\nBEGIN:VEVENT\r\nUID:sync@example.com\r\n" +
+		"DTSTART:20240301T090000Z\r\nSUMMARY:Sync\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n")
+}
+
+func TestAddToCalendar(t *testing.T) {
+	dir := t.TempDir()
+	p := &EmailPoller{}
+	account := config.EmailAccount{ID: "work"}
+	rule := config.Rule{Action: "add-to-calendar", CalendarDir: dir}
+	msg := &email.Email{Mailbox: "INBOX", UID: 3, Subject: "Invitation: Sync"}
+	ctx := context.Background()
+	path := eventPath(dir, "work", "sync@example.com")
+
+	if err := p.addToCalendar(ctx, account, &rawProvider{raw: inviteMail("REQUEST")}, rule, msg); err != nil {
+		t.Fatalf("addToCalendar() error = %v", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if s := string(got); !strings.Contains(s, "UID:sync@example.com") || strings.Contains(s, "METHOD") {
+		t.Errorf("event file = %q; want the event without METHOD", s)
+	}
+
+	// Replies to the user's own invites leave the calendar alone
+	if err := p.addToCalendar(ctx, account, &rawProvider{raw: inviteMail("REPLY")}, rule, msg); err != nil {
+		t.Fatalf("addToCalendar() of a reply error = %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("event gone after a reply: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := p.addToCalendar(ctx, account, &rawProvider{raw: inviteMail("CANCEL")}, rule, msg); err != nil {
+			t.Fatalf("addToCalendar() of a cancellation error = %v", err)
+		}
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("event still there after cancellation: %v", err)
+	}
+
+	plain := &rawProvider{raw: []byte("Subject: Hi\r\n\r\nNo invite here")}
+	if err := p.addToCalendar(ctx, account, plain, rule, msg); err != nil {
+		t.Errorf("addToCalendar() of a message without invite = %v; want nil", err)
+	}
+	if err := p.addToCalendar(ctx, account, &rawProvider{raw: inviteMail("REQUEST")}, config.Rule{}, msg); err == nil {
+		t.Error("addToCalendar() without CalDAV or CalendarDir succeeded")
+	}
+}
@@ -0,0 +1,91 @@
+package scheduler
+
+import (
+	"log"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/events"
+	"github.com/mshan/go-tsk/internal/metrics"
+	"github.com/mshan/go-tsk/internal/reporting"
+)
+
+// defaultCircuitCoolDown is how long an open circuit skips polls when the
+// config leaves it unset
+const defaultCircuitCoolDown = 15 * time.Minute
+
+// circuitBreaker stops an account's polls for a cool-down once they keep
+// failing, so an outage doesn't fill the log or get the account throttled.
+// Once the cool-down is over the circuit is half-open: the next poll is a
+// probe that closes it on success and reopens it on failure.
+type circuitBreaker struct {
+	failures  int // Failed polls in a row that open it; 0 disables it
+	coolDown  time.Duration
+	openUntil time.Time // Zero while closed
+}
+
+// newCircuitBreaker creates a circuit breaker from the config, filling in
+// defaults
+func newCircuitBreaker(cfg config.CircuitConfig) *circuitBreaker {
+	c := &circuitBreaker{failures: cfg.Failures, coolDown: cfg.CoolDown}
+	if c.coolDown <= 0 {
+		c.coolDown = defaultCircuitCoolDown
+	}
+	return c
+}
+
+// failure records the attempts-th failed poll in a row. It returns whether
+// the circuit was closed and opened now, and until when polls are skipped;
+// zero if the circuit stays closed.
+func (c *circuitBreaker) failure(attempts int, now time.Time) (opened bool, until time.Time) {
+	if c.failures <= 0 || attempts < c.failures {
+		return false, time.Time{}
+	}
+	opened = c.openUntil.IsZero()
+	c.openUntil = now.Add(c.coolDown)
+	return opened, c.openUntil
+}
+
+// success records a successful poll and reports whether it closed the
+// circuit
+func (c *circuitBreaker) success() bool {
+	closed := !c.openUntil.IsZero()
+	c.openUntil = time.Time{}
+	return closed
+}
+
+// wait returns how long polls are still skipped; 0 if the circuit is closed
+// or half-open
+func (c *circuitBreaker) wait(now time.Time) time.Duration {
+	if c.openUntil.IsZero() || !now.Before(c.openUntil) {
+		return 0
+	}
+	return c.openUntil.Sub(now)
+}
+
+// circuitOpened reports that an account's circuit opened, in the log, the
+// metrics, as an event and to the error reporter
+func (p *EmailPoller) circuitOpened(account config.EmailAccount, attempts int, coolDown time.Duration, err error) {
+	log.Printf("Circuit opened for account %s after %d failed polls; skipping polls for %s", account.ID, attempts, coolDown.Round(time.Second))
+	metrics.Add(account.ID, "circuit_opens", 1)
+	metrics.Set(account.ID, "circuit_open", 1)
+	p.events.Broadcast(events.NewEvent(events.TypeCircuitOpened, account.ID, "", events.ErrorData{
+		Account: account.ID,
+		Error:   err.Error(),
+		Attempt: attempts,
+	}))
+	p.reporter.Report(reporting.Report{
+		Kind:     reporting.KindCircuitOpen,
+		Account:  account.ID,
+		Error:    err.Error(),
+		Attempts: attempts,
+		Context:  map[string]string{"provider": account.Provider, "address": account.Address, "cool_down": coolDown.String()},
+	})
+}
+
+// circuitClosed reports that a probe poll closed an account's circuit
+func (p *EmailPoller) circuitClosed(account config.EmailAccount) {
+	log.Printf("Circuit closed for account %s", account.ID)
+	metrics.Set(account.ID, "circuit_open", 0)
+	p.events.Broadcast(events.NewEvent(events.TypeCircuitClosed, account.ID, "", nil))
+}
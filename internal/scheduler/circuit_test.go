@@ -0,0 +1,73 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		cfg        config.CircuitConfig
+		failures   int           // Failed polls in a row
+		after      time.Duration // Time since the last failure
+		wantOpened bool          // Whether the last failure opened the circuit
+		wantWait   time.Duration
+	}{
+		{name: "disabled", cfg: config.CircuitConfig{}, failures: 10},
+		{name: "below the threshold", cfg: config.CircuitConfig{Failures: 3, CoolDown: time.Hour}, failures: 2},
+		{
+			name:       "opens at the threshold",
+			cfg:        config.CircuitConfig{Failures: 3, CoolDown: time.Hour},
+			failures:   3,
+			after:      10 * time.Minute,
+			wantOpened: true,
+			wantWait:   50 * time.Minute,
+		},
+		{
+			name:     "failed probe reopens",
+			cfg:      config.CircuitConfig{Failures: 3, CoolDown: time.Hour},
+			failures: 4,
+			wantWait: time.Hour,
+		},
+		{
+			name:       "half-open after the cool-down",
+			cfg:        config.CircuitConfig{Failures: 3, CoolDown: time.Hour},
+			failures:   3,
+			after:      time.Hour,
+			wantOpened: true,
+		},
+		{
+			name:       "default cool-down",
+			cfg:        config.CircuitConfig{Failures: 1},
+			failures:   1,
+			wantOpened: true,
+			wantWait:   defaultCircuitCoolDown,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCircuitBreaker(tt.cfg)
+			var opened bool
+			for i := 1; i <= tt.failures; i++ {
+				opened, _ = c.failure(i, start)
+			}
+			if opened != tt.wantOpened {
+				t.Errorf("opened = %v; want %v", opened, tt.wantOpened)
+			}
+			if got := c.wait(start.Add(tt.after)); got != tt.wantWait {
+				t.Errorf("wait() = %s; want %s", got, tt.wantWait)
+			}
+			wasOpen := tt.cfg.Failures > 0 && tt.failures >= tt.cfg.Failures
+			if closed := c.success(); closed != wasOpen {
+				t.Errorf("success() = %v; want %v", closed, wasOpen)
+			}
+			if got := c.wait(start.Add(tt.after)); got != 0 {
+				t.Errorf("wait() after a success = %s; want 0", got)
+			}
+		})
+	}
+}
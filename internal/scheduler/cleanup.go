@@ -0,0 +1,118 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+// CleanupOptions controls how a cleanup job runs
+type CleanupOptions struct {
+	// DryRun only counts the messages the job would touch
+	DryRun bool
+	// BatchSize is the number of messages changed per command
+	BatchSize int
+	// Pause is the delay between batches, to stay under provider rate limits
+	Pause time.Duration
+	// Now returns the current time, against which OlderThan is measured;
+	// nil uses time.Now
+	Now func() time.Time
+	// Progress, if set, is called once the matching messages are found and
+	// after every batch
+	Progress func(CleanupProgress)
+}
+
+// CleanupProgress reports how far a cleanup job has got
+type CleanupProgress struct {
+	Done    int
+	Total   int
+	Elapsed time.Duration
+}
+
+// Cleanup runs a maintenance job over one mailbox of an account on its own
+// connection, and returns how many messages it changed (or, in a dry run,
+// would change)
+func (p *EmailPoller) Cleanup(ctx context.Context, job config.CleanupJob, opts CleanupOptions) (int, error) {
+	account, ok := p.account(job.Account)
+	if !ok {
+		return 0, fmt.Errorf("unknown account %q", job.Account)
+	}
+	if opts.BatchSize <= 0 {
+		return 0, fmt.Errorf("batch size must be positive, got %d", opts.BatchSize)
+	}
+	mailbox := job.Mailbox
+	if mailbox == "" {
+		mailbox = email.Inbox
+	}
+	now := time.Now
+	if opts.Now != nil {
+		now = opts.Now
+	}
+
+	var filter email.Filter
+	if job.OlderThan > 0 {
+		filter.Before = now().Add(-job.OlderThan)
+	}
+	var apply func(ctx context.Context, client email.Provider, uids []uint32) error
+	switch job.Action {
+	case "remove-label":
+		filter.Label = job.Label
+		apply = func(ctx context.Context, client email.Provider, uids []uint32) error {
+			return client.RemoveLabel(ctx, mailbox, uids, job.Label)
+		}
+	case "expunge":
+		apply = func(ctx context.Context, client email.Provider, uids []uint32) error {
+			return client.DeleteMessages(ctx, mailbox, uids)
+		}
+	default:
+		return 0, fmt.Errorf("unknown cleanup action %q", job.Action)
+	}
+
+	client, err := p.connect(ctx, account)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	uids, err := client.Search(ctx, mailbox, filter)
+	if err != nil {
+		return 0, fmt.Errorf("failed to search %s: %w", mailbox, err)
+	}
+
+	start := time.Now()
+	report := func(done int) {
+		if opts.Progress != nil {
+			opts.Progress(CleanupProgress{Done: done, Total: len(uids), Elapsed: time.Since(start)})
+		}
+	}
+	report(0)
+	if opts.DryRun {
+		return len(uids), nil
+	}
+
+	done := 0
+	for done < len(uids) {
+		end := done + opts.BatchSize
+		if end > len(uids) {
+			end = len(uids)
+		}
+		if err := apply(ctx, client, uids[done:end]); err != nil {
+			return done, fmt.Errorf("failed after %d of %d messages: %w", done, len(uids), err)
+		}
+		done = end
+		report(done)
+		if done == len(uids) {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return done, ctx.Err()
+		case <-time.After(opts.Pause):
+		}
+	}
+	return done, nil
+}
@@ -0,0 +1,24 @@
+package scheduler
+
+import (
+	"fmt"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/rules"
+)
+
+// compileConditions compiles the CEL condition of every rule that has one,
+// keyed by rule index, so bad expressions fail at startup
+func compileConditions(ruleList []config.Rule) (map[int]*rules.Condition, error) {
+	compiled := make(map[int]*rules.Condition)
+	for i, rule := range ruleList {
+		cond, err := rules.CompileCondition(rule.Condition)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: invalid condition: %w", i, err)
+		}
+		if cond != nil {
+			compiled[i] = cond
+		}
+	}
+	return compiled, nil
+}
@@ -0,0 +1,47 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+func TestMatchesCondition(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Poll.Rules = []config.Rule{
+		{SubjectContains: "statement", Label: "Bank", Condition: `email.from.endsWith("@bank.com")`},
+		{Label: "Big", Condition: `email.size > 1000000 && email.unknown`},
+	}
+	p, err := NewEmailPoller(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewEmailPoller: %v", err)
+	}
+	t.Cleanup(p.Stop)
+	account := cfg.EmailAccounts[0]
+
+	tests := []struct {
+		name string
+		rule int
+		msg  email.Email
+		want bool
+	}{
+		{"condition holds", 0, email.Email{Subject: "Your statement", From: "alerts@bank.com"}, true},
+		{"condition fails", 0, email.Email{Subject: "Your statement", From: "alerts@bank.com.example"}, false},
+		{"subject fails", 0, email.Email{Subject: "Hello", From: "alerts@bank.com"}, false},
+		{"evaluation error", 1, email.Email{Size: 2000000}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.matches(context.Background(), account, tt.rule, cfg.Poll.Rules[tt.rule], &tt.msg); got != tt.want {
+				t.Errorf("matches() = %v; want %v", got, tt.want)
+			}
+		})
+	}
+
+	cfg.Poll.Rules = []config.Rule{{Label: "x", Condition: "email.subject.("}}
+	if _, err := NewEmailPoller(cfg, nil); err == nil {
+		t.Error("NewEmailPoller accepted an invalid condition")
+	}
+}
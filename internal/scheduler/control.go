@@ -0,0 +1,287 @@
+package scheduler
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/events"
+	"github.com/mshan/go-tsk/internal/rules"
+)
+
+// maxRecentMatches bounds the number of matches kept per account
+const maxRecentMatches = 100
+
+var (
+	// ErrAlreadyRunning is returned by Start while the poller runs
+	ErrAlreadyRunning = errors.New("poller already running")
+	// ErrPaused is returned when asking a paused account to poll
+	ErrPaused = errors.New("account paused")
+	// ErrNotRunning is returned when asking an account to poll that is
+	// disabled or whose polling has stopped
+	ErrNotRunning = errors.New("account not running")
+	// ErrNoConfigFile is returned when reloading rules of a poller that
+	// was not created from a config file
+	ErrNoConfigFile = errors.New("no config file to reload")
+)
+
+// AccountStatus is a snapshot of one account's polling state
+type AccountStatus struct {
+	ID        string
+	Name      string
+	Enabled   bool
+	Running   bool // Whether the polling goroutine is running
+	Connected bool
+	Paused    bool
+	Degraded  bool          // Whether a panic was recovered since the last successful poll
+	LastSync  time.Time     // Zero before the first successful poll
+	LastError *AccountError // nil if polling never failed
+}
+
+// Match records one message a rule matched
+type Match struct {
+	Account   string
+	Mailbox   string
+	UID       uint32
+	MessageID string
+	Subject   string
+	From      string
+	Rule      string
+	Action    string
+	Time      time.Time
+}
+
+// Accounts returns the status of every configured account, in config order
+func (p *EmailPoller) Accounts() []AccountStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	statuses := make([]AccountStatus, 0, len(p.config.EmailAccounts))
+	for _, account := range p.config.EmailAccounts {
+		statuses = append(statuses, p.status(account))
+	}
+	return statuses
+}
+
+// Account returns the status of one account
+func (p *EmailPoller) Account(accountID string) (AccountStatus, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, account := range p.config.EmailAccounts {
+		if account.ID == accountID {
+			return p.status(account), nil
+		}
+	}
+	return AccountStatus{}, ErrUnknownAccount
+}
+
+// status builds the status of an account; p.mu must be held
+func (p *EmailPoller) status(account config.EmailAccount) AccountStatus {
+	state := p.accountState[account.ID]
+	s := AccountStatus{
+		ID:        account.ID,
+		Name:      account.Name,
+		Enabled:   account.Enabled,
+		Running:   state.isActive,
+		Connected: state.pool != nil && state.pool.connected(),
+		Paused:    state.paused,
+		Degraded:  !state.degradedAt.IsZero(),
+		LastSync:  state.lastSync,
+	}
+	if n := len(state.errors); n > 0 {
+		last := state.errors[n-1]
+		s.LastError = &last
+	}
+	return s
+}
+
+// Pause stops an account's scheduled polls until Resume is called. A poll
+// already running is finished. Pausing is not persisted across restarts.
+func (p *EmailPoller) Pause(accountID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state, ok := p.accountState[accountID]
+	if !ok {
+		return ErrUnknownAccount
+	}
+	if !state.paused {
+		state.paused = true
+		log.Printf("Polling for account %s paused", accountID)
+	}
+	return nil
+}
+
+// Resume restarts the scheduled polls of a paused account, polling it right
+// away
+func (p *EmailPoller) Resume(accountID string) error {
+	p.mu.Lock()
+	state, ok := p.accountState[accountID]
+	if !ok {
+		p.mu.Unlock()
+		return ErrUnknownAccount
+	}
+	wasPaused := state.paused
+	state.paused = false
+	p.mu.Unlock()
+
+	if !wasPaused {
+		return nil
+	}
+	log.Printf("Polling for account %s resumed", accountID)
+	if err := p.PollNow(accountID); err != nil && !errors.Is(err, ErrNotRunning) {
+		return err
+	}
+	return nil
+}
+
+// PollNow asks a running account to poll immediately instead of waiting
+// for its next scheduled poll. A request while another is pending is
+// merged into it.
+func (p *EmailPoller) PollNow(accountID string) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	state, ok := p.accountState[accountID]
+	switch {
+	case !ok:
+		return ErrUnknownAccount
+	case state.paused:
+		return ErrPaused
+	case !state.isActive:
+		return ErrNotRunning
+	}
+	select {
+	case state.pollNow <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// paused reports whether an account is paused
+func (p *EmailPoller) paused(accountID string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.accountState[accountID].paused
+}
+
+// ReloadRules reloads the rules from the config file set with
+// WithConfigPath. Only the rules change; the rest of the file is checked
+// but ignored until restart. Polls in progress finish with the old rules.
+func (p *EmailPoller) ReloadRules() error {
+	if p.configPath == "" {
+		return ErrNoConfigFile
+	}
+	cfg, err := p.loadConfig(p.configPath)
+	if err != nil {
+		return err
+	}
+	return p.SetRules(cfg.Poll.Rules)
+}
+
+// SetRules replaces the rules applied to new mail. The rules are compiled
+// first, so invalid rules leave the current ones in place.
+func (p *EmailPoller) SetRules(ruleList []config.Rule) error {
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+
+	set, err := p.compileRules(p.config.EmailAccounts, ruleList)
+	if err != nil {
+		return err
+	}
+	p.swapRules(set)
+	return nil
+}
+
+// RecentMatches returns up to limit of the latest matches, newest first, of
+// one account or of all accounts if accountID is empty. limit <= 0 returns
+// every match kept.
+func (p *EmailPoller) RecentMatches(accountID string, limit int) ([]Match, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var matches []Match
+	if accountID != "" {
+		state, ok := p.accountState[accountID]
+		if !ok {
+			return nil, ErrUnknownAccount
+		}
+		matches = append(matches, state.matches...)
+	} else {
+		for _, state := range p.accountState {
+			matches = append(matches, state.matches...)
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Time.After(matches[j].Time)
+	})
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+// recordMatch adds a rule match to the account's bounded match history
+func (p *EmailPoller) recordMatch(account config.EmailAccount, rule config.Rule, msg *email.Email) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state := p.accountState[account.ID]
+	state.matches = append(state.matches, Match{
+		Account:   account.ID,
+		Mailbox:   msg.Mailbox,
+		UID:       msg.UID,
+		MessageID: msg.MessageID,
+		Subject:   msg.Subject,
+		From:      msg.From,
+		Rule:      rules.Describe(rule),
+		Action:    ruleAction(rule),
+		Time:      time.Now(),
+	})
+	if len(state.matches) > maxRecentMatches {
+		state.matches = state.matches[len(state.matches)-maxRecentMatches:]
+	}
+}
+
+// ruleAction returns the action of a rule, filling in the default. The
+// actions of a chain are joined with "+".
+func ruleAction(rule config.Rule) string {
+	if len(rule.Actions) > 0 {
+		names := make([]string, len(rule.Actions))
+		for i, step := range config.Steps(rule) {
+			names[i] = ruleAction(step)
+		}
+		return strings.Join(names, "+")
+	}
+	if rule.Script != "" {
+		return "script"
+	}
+	if rule.Action == "" {
+		return "label"
+	}
+	return rule.Action
+}
+
+// hasAction reports whether a rule runs the named action, alone or in its
+// chain
+func hasAction(rule config.Rule, name string) bool {
+	for _, step := range config.Steps(rule) {
+		if step.Action == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscribe returns a channel receiving every event the poller emits from
+// now on and a function that ends the subscription. Events are dropped
+// when the buffer is full.
+func (p *EmailPoller) Subscribe(buffer int) (<-chan events.Event, func()) {
+	return p.events.Subscribe(buffer)
+}
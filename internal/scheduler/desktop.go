@@ -0,0 +1,32 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/integrations"
+)
+
+// notifyDesktop shows the desktop notification of notify-desktop rule i for
+// a matched message
+func (p *EmailPoller) notifyDesktop(ctx context.Context, i int, msg *email.Email) error {
+	tmpl, ok := p.templates[templateKey{i, "notify-desktop"}]
+	if !ok {
+		return fmt.Errorf("rule %d has no compiled templates", i)
+	}
+	var n integrations.DesktopNotification
+	var err error
+	if n.Title, err = tmpl.title.Render(msg); err != nil {
+		return fmt.Errorf("failed to render notification title: %w", err)
+	}
+	if n.Message, err = tmpl.body.Render(msg); err != nil {
+		return fmt.Errorf("failed to render notification message: %w", err)
+	}
+	if tmpl.url != nil {
+		if n.URL, err = tmpl.url.Render(msg); err != nil {
+			return fmt.Errorf("failed to render notification URL: %w", err)
+		}
+	}
+	return p.desktop.Notify(ctx, n)
+}
@@ -0,0 +1,151 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/actions"
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/metrics"
+	"github.com/mshan/go-tsk/internal/notify"
+	"github.com/mshan/go-tsk/internal/store"
+)
+
+// defaultDigestWindow is how often summaries are sent when
+// Notify.Digest.Window is unset
+const defaultDigestWindow = 24 * time.Hour
+
+// digestQueue holds the matches of digest rules until their account's
+// summary is due, a window after the first of them was queued. With a
+// store the matches are kept there too, so a restart does not lose them.
+// An account's digest is only changed by its own polls.
+type digestQueue struct {
+	window time.Duration // 0 uses defaultDigestWindow
+	store  store.Store   // nil when persistence is disabled
+	now    func() time.Time
+
+	mu      sync.Mutex
+	pending map[string]*pendingDigest // key is account ID
+}
+
+// pendingDigest is the summary of one account waiting to be sent
+type pendingDigest struct {
+	entries []notify.Entry
+	since   time.Time // When the first entry was queued
+}
+
+// account returns the pending digest of an account, loading it from the
+// store the first time. q.mu must be held.
+func (q *digestQueue) account(accountID string) (*pendingDigest, error) {
+	if d, ok := q.pending[accountID]; ok {
+		return d, nil
+	}
+	d := &pendingDigest{}
+	if q.store != nil {
+		var err error
+		if d.entries, d.since, err = q.store.DigestEntries(accountID); err != nil {
+			return nil, fmt.Errorf("failed to load digest: %w", err)
+		}
+	}
+	if q.pending == nil {
+		q.pending = make(map[string]*pendingDigest)
+	}
+	q.pending[accountID] = d
+	return d, nil
+}
+
+// clock returns the current time
+func (q *digestQueue) clock() time.Time {
+	if q.now != nil {
+		return q.now()
+	}
+	return time.Now()
+}
+
+// add queues a match for an account's next summary
+func (q *digestQueue) add(accountID string, e notify.Entry) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	d, err := q.account(accountID)
+	if err != nil {
+		return err
+	}
+	now := q.clock()
+	if q.store != nil {
+		if err := q.store.QueueDigestEntry(accountID, e, now); err != nil {
+			return err
+		}
+	}
+	if len(d.entries) == 0 {
+		d.since = now
+	}
+	d.entries = append(d.entries, e)
+	return nil
+}
+
+// due returns the matches of an account's summary if it is due. They stay
+// queued until sent is called.
+func (q *digestQueue) due(accountID string) ([]notify.Entry, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	d, err := q.account(accountID)
+	if err != nil {
+		return nil, err
+	}
+	window := q.window
+	if window <= 0 {
+		window = defaultDigestWindow
+	}
+	if len(d.entries) == 0 || q.clock().Sub(d.since) < window {
+		return nil, nil
+	}
+	return append([]notify.Entry(nil), d.entries...), nil
+}
+
+// sent empties an account's digest after its summary was sent
+func (q *digestQueue) sent(accountID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.pending, accountID)
+	if q.store != nil {
+		return q.store.ClearDigest(accountID)
+	}
+	return nil
+}
+
+// digestAction queues a match for the account's summary
+func (p *EmailPoller) digestAction(ctx context.Context, msg *email.Email, a actions.Params) error {
+	if err := p.digests.add(a.Account.ID, newEntry(a.Account, a.Rule, msg)); err != nil {
+		return fmt.Errorf("failed to queue digest entry: %w", err)
+	}
+	return nil
+}
+
+// sendDueDigest sends an account's summary of digest matches once its
+// window has passed. A summary that fails to send is retried on the next
+// poll.
+func (p *EmailPoller) sendDueDigest(ctx context.Context, account config.EmailAccount) {
+	entries, err := p.digests.due(account.ID)
+	if err != nil {
+		log.Printf("Failed to check digest for account %s: %v", account.ID, err)
+		return
+	}
+	if len(entries) == 0 {
+		return
+	}
+	if err := p.notifier.Send(ctx, notify.Digest{Account: account.Name, Entries: entries}); err != nil {
+		log.Printf("Failed to send digest for account %s: %v", account.ID, err)
+		return
+	}
+	metrics.Add(account.ID, "digests_sent", 1)
+	if err := p.digests.sent(account.ID); err != nil {
+		log.Printf("Failed to clear sent digest for account %s: %v", account.ID, err)
+	}
+}
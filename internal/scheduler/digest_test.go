@@ -0,0 +1,58 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+func TestDigest(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Poll.Rules = []config.Rule{{SubjectContains: "Newsletter", Action: "digest"}}
+	cfg.Notify.Digest.Window = time.Hour
+	p, err := NewEmailPoller(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewEmailPoller: %v", err)
+	}
+	t.Cleanup(p.Stop)
+	now := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	p.digests.now = func() time.Time { return now }
+	account := cfg.EmailAccounts[0]
+
+	for i, subject := range []string{"Newsletter 1", "Invoice", "Newsletter 2"} {
+		msg := &email.Email{Mailbox: "INBOX", UID: uint32(i + 1), Subject: subject}
+		p.rulesMu.RLock()
+		_, failed := p.applyRules(context.Background(), account, &labelProvider{}, subject, msg)
+		p.rulesMu.RUnlock()
+		if failed {
+			t.Fatalf("applyRules(%q) failed", subject)
+		}
+		now = now.Add(10 * time.Minute)
+	}
+
+	entries, err := p.digests.due(account.ID)
+	if err != nil || entries != nil {
+		t.Fatalf("due() before the window = %v, %v; want nothing", entries, err)
+	}
+
+	now = now.Add(time.Hour)
+	entries, err = p.digests.due(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Subject != "Newsletter 1" || entries[1].Subject != "Newsletter 2" {
+		t.Fatalf("due() = %+v; want both newsletters", entries)
+	}
+	// Until sent, a summary that failed is due again
+	if again, _ := p.digests.due(account.ID); len(again) != 2 {
+		t.Errorf("due() again = %d entries; want 2", len(again))
+	}
+
+	p.sendDueDigest(context.Background(), account)
+	if entries, _ := p.digests.due(account.ID); entries != nil {
+		t.Errorf("due() after sending = %+v; want nothing", entries)
+	}
+}
@@ -0,0 +1,64 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/integrations"
+	"github.com/mshan/go-tsk/internal/logging"
+	"github.com/mshan/go-tsk/internal/metrics"
+)
+
+// execInput is the JSON the command of an exec action reads on stdin
+type execInput struct {
+	Account   string    `json:"account"`
+	Key       string    `json:"key"`
+	Mailbox   string    `json:"mailbox"`
+	UID       uint32    `json:"uid"`
+	MessageID string    `json:"message_id,omitempty"`
+	Subject   string    `json:"subject"`
+	From      string    `json:"from"`
+	Date      time.Time `json:"date"`
+	Flags     []string  `json:"flags"`
+	Size      uint32    `json:"size,omitempty"`
+	TextBody  string    `json:"text_body,omitempty"`
+	HTMLBody  string    `json:"html_body,omitempty"`
+}
+
+// runCommand runs the command of an exec rule with a matched message as
+// JSON on stdin
+func (p *EmailPoller) runCommand(ctx context.Context, account config.EmailAccount, rule config.Rule, key string, msg *email.Email) error {
+	input, err := json.Marshal(execInput{
+		Account:   account.ID,
+		Key:       key,
+		Mailbox:   msg.Mailbox,
+		UID:       msg.UID,
+		MessageID: msg.MessageID,
+		Subject:   msg.Subject,
+		From:      msg.From,
+		Date:      msg.Date,
+		Flags:     msg.Flags,
+		Size:      msg.Size,
+		TextBody:  msg.TextBody,
+		HTMLBody:  msg.HTMLBody,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode email: %w", err)
+	}
+	if err := p.exec.Run(ctx, integrations.ExecCommand{
+		Args:    rule.ExecCommand,
+		Stdin:   input,
+		Env:     rule.ExecEnv,
+		Timeout: rule.ExecTimeout,
+	}); err != nil {
+		return err
+	}
+	metrics.Add(account.ID, "commands_run", 1)
+	log.Printf("Ran %s for email with subject %q", rule.ExecCommand[0], logging.Subject(msg.Subject))
+	return nil
+}
@@ -0,0 +1,192 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/metrics"
+)
+
+const (
+	// defaultPushFallback is the poll interval while Gmail push
+	// notifications arrive, unless the account sets one
+	defaultPushFallback = 15 * time.Minute
+
+	// maxWatchRenewal is the longest a Gmail watch goes unrenewed; Google
+	// asks for daily renewal although watches last a week
+	maxWatchRenewal = 24 * time.Hour
+
+	// stopWatchTimeout bounds stopping a watch when the account stops
+	stopWatchTimeout = 10 * time.Second
+)
+
+// gmailWatcher is a provider that can have Gmail publish new-mail
+// notifications
+type gmailWatcher interface {
+	Watch(ctx context.Context, topic string, mailboxes []string) (time.Time, error)
+	StopWatch(ctx context.Context) error
+}
+
+// pushSubscription is a source of Gmail notifications
+type pushSubscription interface {
+	Pull(ctx context.Context) ([]email.GmailNotification, error)
+}
+
+// newPushSubscription opens a Pub/Sub subscription with Application
+// Default Credentials
+func newPushSubscription(ctx context.Context, name string) (pushSubscription, error) {
+	return email.NewPushSubscription(ctx, name)
+}
+
+// runGmailPush keeps a Gmail watch on an account's mailboxes and polls an
+// account as soon as a notification for it arrives, until ctx is done.
+// While notifications arrive the account's scheduled polls slow down to
+// the fallback interval; if the watch or the subscription fails they are
+// back to normal until push recovers.
+func (p *EmailPoller) runGmailPush(ctx context.Context, account config.EmailAccount) {
+	state := p.state(account.ID)
+	defer p.setPushInterval(state, 0)
+
+	provider, err := p.newProvider(account)
+	if err != nil {
+		log.Printf("Gmail push for account %s disabled: %v", account.ID, err)
+		return
+	}
+	defer provider.Close()
+	watcher, ok := provider.(gmailWatcher)
+	if !ok {
+		log.Printf("Gmail push for account %s disabled: the %s provider cannot watch mailboxes", account.ID, account.Provider)
+		return
+	}
+	sub, err := p.subscribe(ctx, account.Push.Subscription)
+	if err != nil {
+		log.Printf("Gmail push for account %s disabled: %v", account.ID, err)
+		return
+	}
+
+	bo := newBackoff(p.config.Poll.Backoff)
+	var renewAt time.Time
+	for ctx.Err() == nil {
+		if !time.Now().Before(renewAt) {
+			expires, err := p.watch(ctx, account, provider, watcher)
+			if err != nil {
+				p.pushFailed(ctx, account, state, bo, err)
+				continue
+			}
+			renewAt = watchRenewal(time.Now(), expires)
+			log.Printf("Watching Gmail account %s through %s until %s", account.ID, account.Push.Topic, expires.Format(time.RFC3339))
+		}
+
+		notes, err := sub.Pull(ctx)
+		p.dispatchNotifications(notes)
+		if err != nil {
+			p.pushFailed(ctx, account, state, bo, err)
+			continue
+		}
+		bo.Reset()
+		p.setPushInterval(state, pushFallback(account))
+	}
+
+	stopCtx, cancel := context.WithTimeout(context.Background(), stopWatchTimeout)
+	defer cancel()
+	if err := watcher.StopWatch(stopCtx); err != nil {
+		log.Printf("Failed to stop watching Gmail account %s: %v", account.ID, err)
+	}
+}
+
+// watch starts or renews the Gmail watch of an account on the mailboxes it
+// polls
+func (p *EmailPoller) watch(ctx context.Context, account config.EmailAccount, provider email.Provider, watcher gmailWatcher) (time.Time, error) {
+	var mailboxes []string
+	if len(account.Mailboxes) > 0 {
+		listed, err := provider.ListMailboxes(ctx)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to list mailboxes: %w", err)
+		}
+		mailboxes = email.ResolveMailboxes(account.Mailboxes, listed)
+	} else {
+		mailboxes = []string{email.Inbox}
+	}
+	return watcher.Watch(ctx, account.Push.Topic, mailboxes)
+}
+
+// pushFailed logs a push failure, returns the account to its normal poll
+// interval and waits out the backoff delay
+func (p *EmailPoller) pushFailed(ctx context.Context, account config.EmailAccount, state *AccountState, bo *backoff, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	p.setPushInterval(state, 0)
+	delay := bo.Next()
+	metrics.Add(account.ID, "push_failures", 1)
+	log.Printf("Gmail push for account %s failed (attempt %d), polling normally and retrying in %s: %v",
+		account.ID, bo.Attempts(), delay.Round(time.Second), err)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// dispatchNotifications polls the accounts the notifications are for.
+// Accounts may share a subscription, so a notification is routed by its
+// address rather than to the account that pulled it.
+func (p *EmailPoller) dispatchNotifications(notes []email.GmailNotification) {
+	for _, n := range notes {
+		found := false
+		for _, account := range p.accounts() {
+			if account.Provider != "gmailapi" || !strings.EqualFold(account.Address, n.EmailAddress) {
+				continue
+			}
+			found = true
+			metrics.Add(account.ID, "push_notifications", 1)
+			if err := p.PollNow(account.ID); err != nil && !errors.Is(err, ErrPaused) && !errors.Is(err, ErrNotRunning) {
+				log.Printf("Failed to poll account %s on push: %v", account.ID, err)
+			}
+		}
+		if !found {
+			log.Printf("Dropped Gmail notification for an address no account uses")
+		}
+	}
+}
+
+// accounts returns the configured accounts
+func (p *EmailPoller) accounts() []config.EmailAccount {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.config.EmailAccounts
+}
+
+// setPushInterval sets the interval scheduled polls of an account slow
+// down to; 0 restores the normal interval
+func (p *EmailPoller) setPushInterval(state *AccountState, interval time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	state.pushInterval = interval
+}
+
+// pushFallback returns the poll interval of an account while push works
+func pushFallback(account config.EmailAccount) time.Duration {
+	if account.Push.FallbackInterval > 0 {
+		return account.Push.FallbackInterval
+	}
+	return defaultPushFallback
+}
+
+// watchRenewal returns when a watch expiring at expires is renewed: half
+// way to expiry, but at least daily
+func watchRenewal(now, expires time.Time) time.Time {
+	renew := now.Add(expires.Sub(now) / 2)
+	if renew.Sub(now) > maxWatchRenewal {
+		renew = now.Add(maxWatchRenewal)
+	}
+	return renew
+}
@@ -0,0 +1,134 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+// watchingProvider records the watches made on it
+type watchingProvider struct {
+	email.Provider
+	mu      sync.Mutex
+	watched []string
+	stopped bool
+}
+
+func (w *watchingProvider) Watch(ctx context.Context, topic string, mailboxes []string) (time.Time, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.watched = append(w.watched, mailboxes...)
+	return time.Now().Add(7 * 24 * time.Hour), nil
+}
+
+func (w *watchingProvider) StopWatch(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stopped = true
+	return nil
+}
+
+func (w *watchingProvider) Close() error { return nil }
+
+// fakeSubscription delivers queued notifications, then blocks
+type fakeSubscription struct {
+	notes chan []email.GmailNotification
+}
+
+func (s *fakeSubscription) Pull(ctx context.Context) ([]email.GmailNotification, error) {
+	select {
+	case notes := <-s.notes:
+		return notes, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestGmailPush(t *testing.T) {
+	push := config.GmailPushConfig{Topic: "projects/p/topics/gmail", Subscription: "projects/p/subscriptions/go-tsk", FallbackInterval: time.Hour}
+	primary := config.EmailAccount{ID: "primary", Provider: "gmailapi", Address: "me@example.com", Push: push}
+	work := config.EmailAccount{ID: "work", Provider: "gmailapi", Address: "work@example.com"}
+	cfg := &config.Config{EmailAccounts: []config.EmailAccount{primary, work}}
+	cfg.Poll.Interval = time.Minute
+
+	provider := &watchingProvider{}
+	sub := &fakeSubscription{notes: make(chan []email.GmailNotification, 1)}
+	p := &EmailPoller{
+		config: cfg,
+		accountState: map[string]*AccountState{
+			"primary": {isActive: true, pollNow: make(chan struct{}, 1)},
+			"work":    {isActive: true, pollNow: make(chan struct{}, 1)},
+		},
+		newProvider: func(config.EmailAccount) (email.Provider, error) { return provider, nil },
+		subscribe: func(ctx context.Context, name string) (pushSubscription, error) {
+			return sub, nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.runGmailPush(ctx, primary)
+	}()
+
+	// The subscription is shared, so the notification for the other
+	// account is routed to it
+	sub.notes <- []email.GmailNotification{{EmailAddress: "Work@example.com", HistoryID: "1234"}}
+	select {
+	case <-p.accountState["work"].pollNow:
+	case <-time.After(5 * time.Second):
+		t.Fatal("notification did not trigger a poll")
+	}
+	select {
+	case <-p.accountState["primary"].pollNow:
+		t.Error("notification for another address polled the pulling account")
+	default:
+	}
+
+	// Once notifications arrive, scheduled polls slow down
+	deadline := time.Now().Add(5 * time.Second)
+	for p.nextInterval("primary") != time.Hour {
+		if time.Now().After(deadline) {
+			t.Fatalf("next interval = %s; want the fallback interval", p.nextInterval("primary"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	<-done
+	if got := p.nextInterval("primary"); got != time.Minute {
+		t.Errorf("next interval after push stopped = %s; want the poll interval", got)
+	}
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	if len(provider.watched) != 1 || provider.watched[0] != email.Inbox {
+		t.Errorf("watched %v; want [INBOX]", provider.watched)
+	}
+	if !provider.stopped {
+		t.Error("watch was not stopped")
+	}
+}
+
+func TestWatchRenewal(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		expires time.Duration
+		want    time.Duration
+	}{
+		{"a week", 7 * 24 * time.Hour, maxWatchRenewal},
+		{"an hour", time.Hour, 30 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := watchRenewal(now, now.Add(tt.expires)).Sub(now); got != tt.want {
+				t.Errorf("watchRenewal() in %s; want %s", got, tt.want)
+			}
+		})
+	}
+}
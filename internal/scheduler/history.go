@@ -0,0 +1,79 @@
+package scheduler
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/metrics"
+	"github.com/mshan/go-tsk/internal/store"
+)
+
+// ErrArchiveDisabled is returned by History, Search and Replay unless
+// Storage.Archive is enabled
+var ErrArchiveDisabled = errors.New("the message archive is disabled")
+
+// keepMessage adds a fetched message to the archive, if enabled. Bodies
+// are left out unless Storage.Archive.Bodies is set.
+func (p *EmailPoller) keepMessage(account config.EmailAccount, key string, msg *email.Email) {
+	if p.store == nil || !p.config.Storage.Archive.Enabled {
+		return
+	}
+	kept := *msg
+	if !p.config.Storage.Archive.Bodies {
+		kept.TextBody, kept.HTMLBody = "", ""
+	}
+	if err := p.store.ArchiveMessage(account.ID, key, &kept, time.Now()); err != nil {
+		log.Printf("Failed to archive message %s for account %s: %v", key, account.ID, err)
+		return
+	}
+	metrics.Add(account.ID, "messages_archived", 1)
+}
+
+// History returns the archived messages q selects, newest first
+func (p *EmailPoller) History(q store.ArchiveQuery) ([]store.ArchivedMessage, error) {
+	if p.store == nil || !p.config.Storage.Archive.Enabled {
+		return nil, ErrArchiveDisabled
+	}
+	if q.AccountID != "" {
+		if _, ok := p.account(q.AccountID); !ok {
+			return nil, ErrUnknownAccount
+		}
+	}
+	return p.store.SearchArchive(q)
+}
+
+// SearchResult is an archived message matching a full-text search, with a
+// link opening it at its provider
+type SearchResult struct {
+	store.SearchResult
+	Link string // Empty if the message has no Message-ID
+}
+
+// Search returns the archived messages containing every word of text,
+// best matches first, optionally of one account. See
+// store.Store.SearchMessages.
+func (p *EmailPoller) Search(accountID, text string, limit int) ([]SearchResult, error) {
+	if p.store == nil || !p.config.Storage.Archive.Enabled {
+		return nil, ErrArchiveDisabled
+	}
+	if accountID != "" {
+		if _, ok := p.account(accountID); !ok {
+			return nil, ErrUnknownAccount
+		}
+	}
+	found, err := p.store.SearchMessages(accountID, text, limit)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]SearchResult, len(found))
+	for i, r := range found {
+		results[i].SearchResult = r
+		if account, ok := p.account(r.AccountID); ok {
+			results[i].Link = email.Link(account, r.Email.MessageID)
+		}
+	}
+	return results, nil
+}
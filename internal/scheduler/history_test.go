@@ -0,0 +1,30 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/store"
+)
+
+func TestHistoryWithoutArchive(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Storage.Archive.Enabled = true
+	p := &EmailPoller{config: cfg}
+
+	// Without a store nothing is archived, and there is no history to
+	// search or replay
+	p.keepMessage(cfg.EmailAccounts[0], "mid:<1@example.com>", &email.Email{Subject: "Invoice"})
+	if _, err := p.History(store.ArchiveQuery{}); !errors.Is(err, ErrArchiveDisabled) {
+		t.Errorf("History() error = %v; want ErrArchiveDisabled", err)
+	}
+	n, err := p.Replay(context.Background(), ReplayOptions{}, func(ReplayMatch) {
+		t.Error("Replay() reported a match")
+	})
+	if n != 0 || !errors.Is(err, ErrArchiveDisabled) {
+		t.Errorf("Replay() = %d, %v; want 0, ErrArchiveDisabled", n, err)
+	}
+}
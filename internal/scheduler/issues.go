@@ -0,0 +1,182 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/integrations"
+	"github.com/mshan/go-tsk/internal/logging"
+	"github.com/mshan/go-tsk/internal/metrics"
+	"github.com/mshan/go-tsk/internal/rules"
+)
+
+// Default issue templates used when a create-issue or create-jira rule
+// leaves them unset
+const (
+	defaultIssueTitle = "{{.Subject}}"
+	defaultIssueBody  = "From: {{.From}}\nDate: {{.Date}}\n\n{{.TextBody}}"
+
+	defaultJiraIssueType = "Task"
+)
+
+// defaultTemplates are the title and body templates of each action that
+// renders them, used when a rule leaves them unset
+var defaultTemplates = map[string][2]string{
+	"create-issue":   {defaultIssueTitle, defaultIssueBody},
+	"create-jira":    {defaultIssueTitle, defaultIssueBody},
+	"ntfy":           {defaultPushTitle, defaultPushBody},
+	"pushover":       {defaultPushTitle, defaultPushBody},
+	"notify-desktop": {defaultPushTitle, defaultPushBody},
+}
+
+// templateKey identifies the templates of one action of a rule, as a
+// chain may run several actions that render them
+type templateKey struct {
+	rule   int
+	action string
+}
+
+// actionTemplates are the compiled templates of one rule whose action
+// renders a title and body
+type actionTemplates struct {
+	title  *rules.Template
+	body   *rules.Template
+	fields map[string]*rules.Template // Jira fields by ID
+	url    *rules.Template            // Desktop click-through URL; nil if unset
+}
+
+// compileActionTemplates compiles the templates of every rule action that
+// renders them, keyed by rule index and action, so bad templates fail at
+// startup
+func compileActionTemplates(ruleList []config.Rule) (map[templateKey]actionTemplates, error) {
+	compiled := make(map[templateKey]actionTemplates)
+	for i, r := range ruleList {
+		steps := config.Steps(r)
+		if r.Script != "" {
+			// A script may return any of the actions
+			for action := range defaultTemplates {
+				step := r
+				step.Action = action
+				steps = append(steps, step)
+			}
+		}
+		for _, rule := range steps {
+			defaults, ok := defaultTemplates[rule.Action]
+			if !ok {
+				continue
+			}
+			title, body := rule.TitleTemplate, rule.BodyTemplate
+			if title == "" {
+				title = defaults[0]
+			}
+			if body == "" {
+				body = defaults[1]
+			}
+
+			var t actionTemplates
+			var err error
+			if t.title, err = rules.CompileTemplate(title); err != nil {
+				return nil, fmt.Errorf("rule %d: invalid title template: %w", i, err)
+			}
+			if t.body, err = rules.CompileTemplate(body); err != nil {
+				return nil, fmt.Errorf("rule %d: invalid body template: %w", i, err)
+			}
+			if rule.DesktopURL != "" {
+				if t.url, err = rules.CompileTemplate(rule.DesktopURL); err != nil {
+					return nil, fmt.Errorf("rule %d: invalid DesktopURL template: %w", i, err)
+				}
+			}
+			for field, text := range rule.JiraFields {
+				tmpl, err := rules.CompileTemplate(text)
+				if err != nil {
+					return nil, fmt.Errorf("rule %d: invalid template for Jira field %s: %w", i, field, err)
+				}
+				if t.fields == nil {
+					t.fields = make(map[string]*rules.Template)
+				}
+				t.fields[field] = tmpl
+			}
+			compiled[templateKey{i, rule.Action}] = t
+		}
+	}
+	return compiled, nil
+}
+
+// createIssue opens a GitHub issue for a message matched by rule i
+func (p *EmailPoller) createIssue(ctx context.Context, account config.EmailAccount, i int, rule config.Rule, msg *email.Email) error {
+	if p.github == nil {
+		return fmt.Errorf("no GitHub token configured")
+	}
+	tmpl, ok := p.templates[templateKey{i, "create-issue"}]
+	if !ok {
+		return fmt.Errorf("rule %d has no compiled templates", i)
+	}
+
+	title, err := tmpl.title.Render(msg)
+	if err != nil {
+		return fmt.Errorf("failed to render issue title: %w", err)
+	}
+	body, err := tmpl.body.Render(msg)
+	if err != nil {
+		return fmt.Errorf("failed to render issue body: %w", err)
+	}
+
+	number, url, err := p.github.CreateIssue(ctx, rule.Repo, integrations.GitHubIssue{
+		Title:  title,
+		Body:   body,
+		Labels: rule.IssueLabels,
+	})
+	if err != nil {
+		return err
+	}
+	metrics.Add(account.ID, "issues_created", 1)
+	log.Printf("Opened issue %s#%d for email with subject %q: %s", rule.Repo, number, logging.Subject(msg.Subject), url)
+	return nil
+}
+
+// createJiraIssue files a Jira issue for a message matched by rule i
+func (p *EmailPoller) createJiraIssue(ctx context.Context, account config.EmailAccount, i int, rule config.Rule, msg *email.Email) error {
+	if p.jira == nil {
+		return fmt.Errorf("no Jira site configured")
+	}
+	tmpl, ok := p.templates[templateKey{i, "create-jira"}]
+	if !ok {
+		return fmt.Errorf("rule %d has no compiled templates", i)
+	}
+
+	issue := integrations.JiraIssue{
+		Project:   rule.JiraProject,
+		IssueType: rule.JiraIssueType,
+	}
+	if issue.IssueType == "" {
+		issue.IssueType = defaultJiraIssueType
+	}
+	var err error
+	if issue.Summary, err = tmpl.title.Render(msg); err != nil {
+		return fmt.Errorf("failed to render issue summary: %w", err)
+	}
+	if issue.Description, err = tmpl.body.Render(msg); err != nil {
+		return fmt.Errorf("failed to render issue description: %w", err)
+	}
+	for field, t := range tmpl.fields {
+		value, err := t.Render(msg)
+		if err != nil {
+			return fmt.Errorf("failed to render Jira field %s: %w", field, err)
+		}
+		if issue.Fields == nil {
+			issue.Fields = make(map[string]string)
+		}
+		issue.Fields[field] = value
+	}
+
+	key, url, err := p.jira.CreateIssue(ctx, issue)
+	if err != nil {
+		return err
+	}
+	metrics.Add(account.ID, "jira_issues_created", 1)
+	log.Printf("Filed Jira issue %s for email with subject %q: %s", key, logging.Subject(msg.Subject), url)
+	return nil
+}
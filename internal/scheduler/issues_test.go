@@ -0,0 +1,50 @@
+package scheduler
+
+import (
+	"testing"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+func TestCompileActionTemplates(t *testing.T) {
+	msg := &email.Email{Subject: "App crashes on login", From: "customer@example.com", TextBody: "Steps: open app"}
+
+	tests := []struct {
+		name    string
+		rule    config.Rule
+		title   string
+		wantErr bool
+	}{
+		{"default title", config.Rule{Action: "create-issue"}, "App crashes on login", false},
+		{"custom title", config.Rule{Action: "create-issue", TitleTemplate: "[email] {{normalize .Subject}}"}, "[email] App crashes on login", false},
+		{"bad title", config.Rule{Action: "create-issue", TitleTemplate: "{{.Subject"}, "", true},
+		{"bad body", config.Rule{Action: "create-issue", BodyTemplate: "{{nosuchfunc .From}}"}, "", true},
+		{"jira", config.Rule{Action: "create-jira", JiraFields: map[string]string{"customfield_1": "{{.From}}"}}, "App crashes on login", false},
+		{"ntfy", config.Rule{Action: "ntfy", NtfyTopic: "alerts", TitleTemplate: "Mail from {{.From}}"}, "Mail from customer@example.com", false},
+		{"desktop url", config.Rule{Action: "notify-desktop", DesktopURL: "https://mail.google.com/mail/#search/{{.MessageID}}"}, "App crashes on login", false},
+		{"bad desktop url", config.Rule{Action: "notify-desktop", DesktopURL: "{{.MessageID"}, "", true},
+		{"bad jira field", config.Rule{Action: "create-jira", JiraFields: map[string]string{"customfield_1": "{{.From"}}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A label rule before it checks templates are keyed by rule index
+			compiled, err := compileActionTemplates([]config.Rule{{Label: "imp"}, tt.rule})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("compileActionTemplates() error = %v; wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			tmpl, ok := compiled[templateKey{1, tt.rule.Action}]
+			if !ok || len(compiled) != 1 {
+				t.Fatalf("compiled templates for rules %v; want only rule 1", compiled)
+			}
+			title, err := tmpl.title.Render(msg)
+			if err != nil || title != tt.title {
+				t.Errorf("title = %q, %v; want %q", title, err, tt.title)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/metrics"
+	"github.com/mshan/go-tsk/internal/store"
+)
+
+// leases holds this instance's account leases when HA is enabled. An
+// account is only polled while its lease is held; leases are renewed a
+// few times per TTL, so a stopped instance's accounts move to another
+// within a TTL.
+type leases struct {
+	leaser   store.Leaser
+	instance string        // Holder name of this instance
+	ttl      time.Duration // Lease length
+
+	mu   sync.Mutex
+	held map[string]bool // key is account ID
+}
+
+// newLeases creates the leases of an HA instance, none of them held yet
+func newLeases(cfg *config.Config, st store.Store) (*leases, error) {
+	leaser, ok := st.(store.Leaser)
+	if !ok {
+		return nil, fmt.Errorf("HA requires a store that supports leases, such as postgres")
+	}
+	instance := cfg.HA.Instance
+	if instance == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to name this instance; set HA.Instance: %w", err)
+		}
+		instance = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	ttl := cfg.HA.LeaseTTL
+	if ttl <= 0 {
+		ttl = 3 * cfg.Poll.Interval
+	}
+	return &leases{leaser: leaser, instance: instance, ttl: ttl, held: make(map[string]bool)}, nil
+}
+
+// holdsLease reports whether this instance may process an account: always
+// without HA, otherwise while it holds the account's lease
+func (p *EmailPoller) holdsLease(accountID string) bool {
+	if p.leases == nil {
+		return true
+	}
+	p.leases.mu.Lock()
+	defer p.leases.mu.Unlock()
+	return p.leases.held[accountID]
+}
+
+// runLeases renews the leases of the enabled accounts every third of the
+// TTL until ctx is done or stopped is closed. It does nothing without HA.
+func (p *EmailPoller) runLeases(ctx context.Context, stopped <-chan struct{}) {
+	if p.leases == nil {
+		return
+	}
+	ticker := time.NewTicker(p.leases.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stopped:
+			return
+		case <-ticker.C:
+		}
+		p.renewLeases()
+	}
+}
+
+// renewLeases takes or renews the lease of each enabled account. An
+// account whose lease was just taken, e.g. from a stopped instance, is
+// polled at once. If the store fails, the lease counts as lost, since
+// another instance may take it once it expires.
+func (p *EmailPoller) renewLeases() {
+	if p.leases == nil {
+		return
+	}
+	p.mu.RLock()
+	accounts := p.config.EmailAccounts
+	p.mu.RUnlock()
+
+	for _, account := range accounts {
+		if !account.Enabled {
+			continue
+		}
+		held, err := p.leases.leaser.AcquireLease(account.ID, p.leases.instance, p.leases.ttl)
+		if err != nil {
+			log.Printf("Failed to renew the lease on account %s: %v", account.ID, err)
+		}
+
+		p.leases.mu.Lock()
+		had := p.leases.held[account.ID]
+		p.leases.held[account.ID] = held
+		p.leases.mu.Unlock()
+
+		switch {
+		case held && !had:
+			log.Printf("Acquired the lease on account %s as %s", account.ID, p.leases.instance)
+			metrics.Set(account.ID, "lease_held", 1)
+			p.mu.RLock()
+			if state, ok := p.accountState[account.ID]; ok {
+				select {
+				case state.pollNow <- struct{}{}:
+				default:
+				}
+			}
+			p.mu.RUnlock()
+		case !held && had:
+			log.Printf("Lost the lease on account %s; another instance processes it", account.ID)
+			metrics.Set(account.ID, "lease_held", 0)
+		}
+	}
+}
+
+// releaseLeases gives up the held leases once polling stopped, so other
+// instances take the accounts over without waiting for them to expire
+func (p *EmailPoller) releaseLeases() {
+	if p.leases == nil {
+		return
+	}
+	p.leases.mu.Lock()
+	defer p.leases.mu.Unlock()
+	for accountID, held := range p.leases.held {
+		if !held {
+			continue
+		}
+		if err := p.leases.leaser.ReleaseLease(accountID, p.leases.instance); err != nil {
+			log.Printf("Failed to release the lease on account %s: %v", accountID, err)
+		}
+		delete(p.leases.held, accountID)
+		metrics.Set(accountID, "lease_held", 0)
+	}
+}
@@ -0,0 +1,87 @@
+package scheduler
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/store"
+)
+
+// fakeLeaseStore keeps leases in memory; leases never expire on their own
+type fakeLeaseStore struct {
+	store.Store
+
+	mu      sync.Mutex
+	holders map[string]string // key is account ID
+}
+
+func (s *fakeLeaseStore) AcquireLease(accountID, holder string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h, ok := s.holders[accountID]; ok && h != holder {
+		return false, nil
+	}
+	s.holders[accountID] = holder
+	return true, nil
+}
+
+func (s *fakeLeaseStore) ReleaseLease(accountID, holder string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.holders[accountID] == holder {
+		delete(s.holders, accountID)
+	}
+	return nil
+}
+
+func newLeaseTestPoller(t *testing.T, st store.Store, instance string) *EmailPoller {
+	t.Helper()
+	cfg := config.DefaultConfig()
+	cfg.HA = config.HAConfig{Enabled: true, Instance: instance}
+	p := &EmailPoller{config: cfg, accountState: map[string]*AccountState{
+		"primary": {pollNow: make(chan struct{}, 1)},
+	}}
+	var err error
+	if p.leases, err = newLeases(cfg, st); err != nil {
+		t.Fatalf("newLeases() error = %v", err)
+	}
+	return p
+}
+
+func TestLeaseFailover(t *testing.T) {
+	st := &fakeLeaseStore{holders: make(map[string]string)}
+	a := newLeaseTestPoller(t, st, "a")
+	b := newLeaseTestPoller(t, st, "b")
+
+	a.renewLeases()
+	b.renewLeases()
+	if !a.holdsLease("primary") || b.holdsLease("primary") {
+		t.Fatalf("holdsLease() = %v, %v; want only the first instance to hold the lease",
+			a.holdsLease("primary"), b.holdsLease("primary"))
+	}
+	select {
+	case <-a.accountState["primary"].pollNow:
+	default:
+		t.Error("acquiring a lease did not request a poll")
+	}
+
+	// Once the holder stops, the other instance takes over
+	a.releaseLeases()
+	b.renewLeases()
+	if a.holdsLease("primary") || !b.holdsLease("primary") {
+		t.Errorf("holdsLease() = %v, %v after release; want only the second instance to hold the lease",
+			a.holdsLease("primary"), b.holdsLease("primary"))
+	}
+}
+
+func TestLeasesWithoutHA(t *testing.T) {
+	p := &EmailPoller{config: config.DefaultConfig()}
+	if !p.holdsLease("primary") {
+		t.Error("holdsLease() = false without HA; want true")
+	}
+	if _, err := newLeases(config.DefaultConfig(), nil); err == nil {
+		t.Error("newLeases() without a lease store succeeded")
+	}
+}
@@ -0,0 +1,131 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/contacts"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/integrations"
+)
+
+// defaultListRefresh is how often address lists are reloaded unless
+// AddressList.Refresh says otherwise
+const defaultListRefresh = time.Hour
+
+// addressLists holds the loaded entries of the configured address lists.
+// Polls of every account refresh them, one at a time; a list that has
+// never loaded matches neither FromInList nor FromNotInList.
+type addressLists struct {
+	lists []config.AddressList
+	now   func() time.Time
+
+	refreshing sync.Mutex // Held while a poll reloads lists
+
+	mu     sync.RWMutex
+	loaded map[string]*contacts.List // key is list name
+	due    map[string]time.Time      // key is list name; when to reload next
+}
+
+// newAddressLists creates the holder of lists, none of them loaded yet
+func newAddressLists(lists []config.AddressList) *addressLists {
+	return &addressLists{
+		lists:  lists,
+		now:    time.Now,
+		loaded: make(map[string]*contacts.List),
+		due:    make(map[string]time.Time),
+	}
+}
+
+// refresh reloads the lists that are due. A list that fails to load keeps
+// its previous entries until its next refresh; one that never loaded is
+// retried on the next poll. If another poll is already refreshing, refresh
+// returns at once.
+func (a *addressLists) refresh(ctx context.Context) {
+	if a == nil || !a.refreshing.TryLock() {
+		return
+	}
+	defer a.refreshing.Unlock()
+
+	for _, list := range a.lists {
+		now := a.now()
+		a.mu.RLock()
+		due, scheduled := a.due[list.Name]
+		a.mu.RUnlock()
+		if scheduled && now.Before(due) {
+			continue
+		}
+
+		l, err := loadAddressList(ctx, list)
+		interval := list.Refresh
+		if interval <= 0 {
+			interval = defaultListRefresh
+		}
+		a.mu.Lock()
+		switch {
+		case err == nil:
+			a.loaded[list.Name] = l
+			a.due[list.Name] = now.Add(interval)
+		case a.loaded[list.Name] != nil:
+			a.due[list.Name] = now.Add(interval)
+		}
+		a.mu.Unlock()
+		if err != nil {
+			log.Printf("Failed to load address list %s: %v", list.Name, err)
+		}
+	}
+}
+
+// list returns the loaded entries of the named list, or nil if it has not
+// loaded
+func (a *addressLists) list(name string) *contacts.List {
+	if a == nil {
+		return nil
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.loaded[name]
+}
+
+// loadAddressList gathers the entries of a list from all its sources
+func loadAddressList(ctx context.Context, list config.AddressList) (*contacts.List, error) {
+	entries := append([]string(nil), list.Addresses...)
+	if list.File != "" {
+		fromFile, err := contacts.ReadFile(list.File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", list.File, err)
+		}
+		entries = append(entries, fromFile...)
+	}
+	if dav := list.CardDAV; dav.URL != "" {
+		book := integrations.NewAddressBookClient(dav.URL, dav.Username, dav.Password, dav.Token)
+		fromBook, err := book.Addresses(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read address book: %w", err)
+		}
+		entries = append(entries, fromBook...)
+	}
+	return contacts.NewList(entries), nil
+}
+
+// fromListsMatch reports whether a message's sender satisfies a rule's
+// FromInList and FromNotInList conditions
+func (p *EmailPoller) fromListsMatch(rule config.Rule, msg *email.Email) bool {
+	if rule.FromInList != "" {
+		l := p.addressLists.list(rule.FromInList)
+		if l == nil || !l.Contains(msg.From) {
+			return false
+		}
+	}
+	if rule.FromNotInList != "" {
+		l := p.addressLists.list(rule.FromNotInList)
+		if l == nil || l.Contains(msg.From) {
+			return false
+		}
+	}
+	return true
+}
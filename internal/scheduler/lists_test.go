@@ -0,0 +1,81 @@
+package scheduler
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+func TestAddressLists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pests.txt")
+	if err := os.WriteFile(path, []byte("spam@example.com\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	lists := newAddressLists([]config.AddressList{
+		{Name: "vips", Addresses: []string{"boss@example.com", "@partner.example"}},
+		{Name: "pests", File: path, Refresh: time.Minute},
+		{Name: "missing", File: filepath.Join(t.TempDir(), "missing.txt")},
+	})
+	lists.now = func() time.Time { return now }
+	p := &EmailPoller{addressLists: lists}
+
+	vip := &email.Email{From: "The Boss <Boss@example.com>"}
+	pest := &email.Email{From: "spam@example.com"}
+	tests := []struct {
+		name string
+		rule config.Rule
+		msg  *email.Email
+		want bool
+	}{
+		{"in list", config.Rule{FromInList: "vips"}, vip, true},
+		{"domain in list", config.Rule{FromInList: "vips"}, &email.Email{From: "ann@partner.example"}, true},
+		{"not in list", config.Rule{FromInList: "vips"}, pest, false},
+		{"not in other list", config.Rule{FromNotInList: "pests"}, vip, true},
+		{"in excluded list", config.Rule{FromNotInList: "pests"}, pest, false},
+		{"both", config.Rule{FromInList: "vips", FromNotInList: "pests"}, vip, true},
+		{"unloaded list", config.Rule{FromInList: "missing"}, vip, false},
+		{"unloaded excluded list", config.Rule{FromNotInList: "missing"}, vip, false},
+	}
+
+	// Nothing matches before the lists load
+	if p.fromListsMatch(tests[0].rule, vip) {
+		t.Error("fromListsMatch() before refresh = true")
+	}
+	lists.refresh(context.Background())
+	for _, tt := range tests {
+		if got := p.fromListsMatch(tt.rule, tt.msg); got != tt.want {
+			t.Errorf("%s: fromListsMatch() = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// A changed file is only reread once the list is due
+	if err := os.WriteFile(path, []byte("other@example.com\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rule := config.Rule{FromInList: "pests"}
+	lists.refresh(context.Background())
+	if !p.fromListsMatch(rule, pest) {
+		t.Error("list reloaded before it was due")
+	}
+	now = now.Add(time.Minute)
+	lists.refresh(context.Background())
+	if p.fromListsMatch(rule, pest) {
+		t.Error("list not reloaded when due")
+	}
+
+	// A list that fails to reload keeps its entries
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Minute)
+	lists.refresh(context.Background())
+	if !p.fromListsMatch(rule, &email.Email{From: "other@example.com"}) {
+		t.Error("list lost its entries after a failed reload")
+	}
+}
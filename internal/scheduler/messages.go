@@ -0,0 +1,80 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+var (
+	// ErrUnknownAccount is returned for lookups in an account that is not
+	// configured
+	ErrUnknownAccount = errors.New("unknown account")
+	// ErrNotConnected is returned for lookups in an account whose first
+	// poll has not connected yet
+	ErrNotConnected = errors.New("account not connected")
+)
+
+// Message fetches one message through the account's connection pool, so
+// callers need no IMAP credentials of their own. An empty mailbox means
+// INBOX.
+func (p *EmailPoller) Message(ctx context.Context, accountID, mailbox string, uid uint32) (*email.Message, error) {
+	_, client, put, err := p.liveClient(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer put()
+	if mailbox == "" {
+		mailbox = email.Inbox
+	}
+	return client.FetchMessage(ctx, mailbox, uid)
+}
+
+// FindMessage fetches the message with the given Message-ID from the first
+// of the account's polled mailboxes that holds it. It returns
+// email.ErrMessageNotFound if none does.
+func (p *EmailPoller) FindMessage(ctx context.Context, accountID, messageID string) (*email.Message, error) {
+	account, client, put, err := p.liveClient(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer put()
+	mailboxes, err := p.mailboxes(ctx, account, client)
+	if err != nil {
+		return nil, err
+	}
+	for _, mailbox := range mailboxes {
+		uids, err := client.SearchMessageID(ctx, mailbox, messageID)
+		if err != nil {
+			return nil, fmt.Errorf("mailbox %s: %w", mailbox, err)
+		}
+		if len(uids) > 0 {
+			return client.FetchMessage(ctx, mailbox, uids[0])
+		}
+	}
+	return nil, email.ErrMessageNotFound
+}
+
+// liveClient returns an account and a connection of its pool, to be given
+// back with put
+func (p *EmailPoller) liveClient(ctx context.Context, accountID string) (account config.EmailAccount, client email.Provider, put func(), err error) {
+	account, ok := p.account(accountID)
+	state := p.state(accountID)
+	if !ok || state == nil {
+		return config.EmailAccount{}, nil, nil, ErrUnknownAccount
+	}
+
+	p.mu.RLock()
+	pool := state.pool
+	p.mu.RUnlock()
+	if pool == nil {
+		return config.EmailAccount{}, nil, nil, ErrNotConnected
+	}
+	if client, err = pool.get(ctx); err != nil {
+		return config.EmailAccount{}, nil, nil, err
+	}
+	return account, client, func() { pool.put(client) }, nil
+}
@@ -0,0 +1,38 @@
+package scheduler
+
+import (
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+// Option customizes an EmailPoller
+type Option func(*EmailPoller)
+
+// ProviderFactory creates the mail provider for an account
+type ProviderFactory func(account config.EmailAccount) (email.Provider, error)
+
+// WithConfigPath sets the config file ReloadRules reads the rules from
+func WithConfigPath(path string) Option {
+	return func(p *EmailPoller) {
+		p.configPath = path
+	}
+}
+
+// ConfigLoader reads the config file at path
+type ConfigLoader func(path string) (*config.Config, error)
+
+// WithConfigLoader sets how Reload and ReloadRules read the config file,
+// e.g. to resolve secret references; config.Load is used by default
+func WithConfigLoader(load ConfigLoader) Option {
+	return func(p *EmailPoller) {
+		p.loadConfig = load
+	}
+}
+
+// WithProviderFactory overrides how providers are created, e.g. to inject
+// fake providers in tests and soak runs
+func WithProviderFactory(f ProviderFactory) Option {
+	return func(p *EmailPoller) {
+		p.newProvider = f
+	}
+}
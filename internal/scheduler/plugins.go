@@ -0,0 +1,90 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/mshan/go-tsk/internal/actions"
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/metrics"
+	"github.com/mshan/go-tsk/internal/plugins"
+)
+
+// loadPlugins loads the configured plugins by name, so broken ones fail at
+// startup
+func loadPlugins(ctx context.Context, cfgs []config.PluginConfig) (map[string]*plugins.Plugin, error) {
+	loaded := make(map[string]*plugins.Plugin, len(cfgs))
+	for _, cfg := range cfgs {
+		plugin, err := plugins.Load(ctx, cfg)
+		if err != nil {
+			closePlugins(ctx, loaded)
+			return nil, err
+		}
+		loaded[cfg.Name] = plugin
+	}
+	return loaded, nil
+}
+
+// closePlugins releases loaded plugins
+func closePlugins(ctx context.Context, loaded map[string]*plugins.Plugin) {
+	for name, plugin := range loaded {
+		if err := plugin.Close(ctx); err != nil {
+			log.Printf("Error closing plugin %s: %v", name, err)
+		}
+	}
+}
+
+// checkPluginRules checks that the plugins rules use export what the rules
+// use them for
+func checkPluginRules(ruleList []config.Rule, loaded map[string]*plugins.Plugin) error {
+	for i, rule := range ruleList {
+		if rule.Plugin != "" {
+			if plugin, ok := loaded[rule.Plugin]; ok && !plugin.Matches() {
+				return fmt.Errorf("rule %d: plugin %s does not export tsk_match", i, rule.Plugin)
+			}
+		}
+		for _, step := range config.Steps(rule) {
+			if plugin, ok := loaded[step.Action]; ok && !plugin.Acts() {
+				return fmt.Errorf("rule %d: plugin %s does not export tsk_act", i, step.Action)
+			}
+		}
+	}
+	return nil
+}
+
+// pluginAction runs a plugin's tsk_act as a rule action
+func pluginAction(plugin *plugins.Plugin) actions.Func {
+	return func(ctx context.Context, msg *email.Email, a actions.Params) error {
+		return plugin.Act(ctx, plugins.Input{
+			Account: a.Account.ID,
+			Key:     a.Key,
+			Email:   plugins.NewEmail(msg),
+			Params:  a.Rule.Params,
+		})
+	}
+}
+
+// pluginMatches reports whether the plugin of rule i, if it has one,
+// accepts a message. Failing plugins do not match.
+func (p *EmailPoller) pluginMatches(ctx context.Context, account config.EmailAccount, i int, rule config.Rule, msg *email.Email) bool {
+	if rule.Plugin == "" {
+		return true
+	}
+	plugin, ok := p.plugins[rule.Plugin]
+	if !ok {
+		log.Printf("Rule %d uses plugin %s, which is not loaded", i, rule.Plugin)
+		return false
+	}
+	matched, err := plugin.Match(ctx, plugins.Input{
+		Account: account.ID,
+		Email:   plugins.NewEmail(msg),
+	})
+	if err != nil {
+		metrics.Add(account.ID, "plugin_errors", 1)
+		log.Printf("Plugin of rule %d failed on email %d in %s: %v", i, msg.UID, msg.Mailbox, err)
+		return false
+	}
+	return matched
+}
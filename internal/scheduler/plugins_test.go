@@ -0,0 +1,48 @@
+package scheduler
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+func TestApplyRulesPlugin(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Plugins = []config.PluginConfig{{Name: "test", Path: "../plugins/testdata/plugin.wasm"}}
+	cfg.Poll.Rules = []config.Rule{{
+		SubjectContains: "Ticket",
+		Plugin:          "test",
+		Actions:         []config.RuleAction{{Label: "Seen"}, {Action: "test"}},
+	}}
+	p, err := NewEmailPoller(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewEmailPoller: %v", err)
+	}
+	t.Cleanup(p.Stop)
+
+	// The test plugin matches subjects with "!" and fails on those with "#"
+	tests := []struct {
+		subject    string
+		wantLabels []string
+		wantFailed bool
+	}{
+		{"Ticket!", []string{"Seen"}, false},
+		{"Ticket", nil, false},
+		{"Ticket #1!", []string{"Seen"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.subject, func(t *testing.T) {
+			provider := &labelProvider{}
+			msg := &email.Email{Mailbox: "INBOX", UID: 1, Subject: tt.subject}
+			p.rulesMu.Lock()
+			_, failed := p.applyRules(context.Background(), cfg.EmailAccounts[0], provider, tt.subject, msg)
+			p.rulesMu.Unlock()
+			if !reflect.DeepEqual(provider.labels, tt.wantLabels) || failed != tt.wantFailed {
+				t.Errorf("labels = %v, failed = %v; want %v, %v", provider.labels, failed, tt.wantLabels, tt.wantFailed)
+			}
+		})
+	}
+}
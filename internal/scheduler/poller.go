@@ -2,191 +2,1124 @@ package scheduler
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"strings"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/mshan/go-tsk/internal/actions"
 	"github.com/mshan/go-tsk/internal/config"
 	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/events"
+	"github.com/mshan/go-tsk/internal/integrations"
+	"github.com/mshan/go-tsk/internal/logging"
+	"github.com/mshan/go-tsk/internal/loopguard"
+	"github.com/mshan/go-tsk/internal/metrics"
+	"github.com/mshan/go-tsk/internal/notify"
+	"github.com/mshan/go-tsk/internal/plugins"
+	"github.com/mshan/go-tsk/internal/proxy"
+	"github.com/mshan/go-tsk/internal/reporting"
+	"github.com/mshan/go-tsk/internal/retry"
+	"github.com/mshan/go-tsk/internal/rules"
+	"github.com/mshan/go-tsk/internal/scripting"
+	"github.com/mshan/go-tsk/internal/store"
+	"github.com/mshan/go-tsk/internal/tasks"
+	"github.com/mshan/go-tsk/internal/tracing"
 )
 
 // AccountState tracks the state for each email account
 type AccountState struct {
-	lastSync  time.Time
-	isActive  bool
-	stopChan  chan struct{}
-	client    *email.GmailClient
+	lastSync time.Time
+	cursors  map[string]email.Cursor // key is mailbox name
+	isActive bool
+	stopChan chan struct{}
+	stopped  bool          // Whether stopChan is closed
+	done     chan struct{} // Closed when the account's supervisor exits; nil if none is running
+	pool     *connPool     // Provider connections; nil until the first poll
+	backoff  *backoff
+	circuit  *circuitBreaker
+	interval *adaptiveInterval // nil unless adaptive polling is enabled
+	fetched  int               // Messages fetched by the current poll
+	errors   []AccountError
+	matches  []Match       // Recent matches, oldest first
+	paused   bool          // Scheduled polls are skipped while set
+	reported bool          // Whether the current run of failed polls was reported
+	pollNow  chan struct{} // Requests an immediate poll
+
+	// degradedAt is when a panic was last recovered in the account's
+	// polling; zero once a poll started later succeeded
+	degradedAt time.Time
+
+	// pushInterval is the poll interval while Gmail push notifications
+	// arrive; 0 polls at the normal interval
+	pushInterval time.Duration
+
+	// savedSync and savedCursors are as last persisted
+	savedSync    time.Time
+	savedCursors map[string]email.Cursor
 }
 
 // EmailPoller handles the email polling logic
 type EmailPoller struct {
-	config       *config.Config
-	accountState map[string]*AccountState // key is account ID
-	mu          sync.RWMutex
+	config        *config.Config
+	accountState  map[string]*AccountState // key is account ID
+	notifier      *notify.Notifier
+	events        *events.Emitter
+	reporter      *reporting.Reporter
+	guard         *loopguard.Guard
+	budget        *ruleBudget
+	todoist       *integrations.TodoistClient // nil unless a Todoist token is configured
+	github        *integrations.GitHubClient  // nil unless a GitHub token is configured
+	jira          *integrations.JiraClient    // nil unless a Jira site is configured
+	ntfy          *integrations.NtfyClient
+	desktop       *integrations.Desktop
+	exec          *integrations.Exec
+	pushover      *integrations.PushoverClient // nil unless Pushover keys are configured
+	caldav        *integrations.CalDAVClient   // nil unless a CalDAV calendar is configured
+	unsubscriber  *integrations.Unsubscriber
+	templates     map[templateKey]actionTemplates
+	webhooks      map[int]ruleWebhook        // key is rule index
+	conditions    map[int]*rules.Condition   // key is rule index
+	scripts       map[int]*scripting.Script  // key is rule index
+	actions       *actions.Registry          // Built-in, extension and plugin actions by name
+	plugins       map[string]*plugins.Plugin // key is plugin name; not reloaded
+	ruleStats     ruleStats
+	digests       digestQueue
+	addressLists  *addressLists // Entries of the configured address lists
+	store         store.Store   // nil when persistence is disabled
+	leases        *leases       // nil unless HA is enabled
+	newProvider   ProviderFactory
+	subscribe     func(ctx context.Context, name string) (pushSubscription, error)
+	configPath    string // File Reload and ReloadRules read; empty if none
+	loadConfig    ConfigLoader
+	rulesMu       sync.RWMutex    // Guards the rules, conditions, scripts, templates, webhooks and budget
+	reloadMu      sync.Mutex      // Serializes config and rule changes
+	run           *pollerRun      // Latest run; nil until Start is called
+	serviceNotify ServiceNotifier // nil unless run as a service
+	watchdog      time.Duration   // Keepalive interval the service manager expects; 0 if none
+	startErrs     Errors          // Accounts that could not be restarted
+	mu            sync.RWMutex
 }
 
-// NewEmailPoller creates a new email poller
-func NewEmailPoller(cfg *config.Config) *EmailPoller {
+// NewEmailPoller creates a new email poller. st may be nil, in which case
+// no state is persisted across restarts.
+func NewEmailPoller(cfg *config.Config, st store.Store, opts ...Option) (*EmailPoller, error) {
+	notifier, err := notify.New(cfg.Notify)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create notifier: %w", err)
+	}
+
+	emitter, err := events.New(cfg.Events)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create event sinks: %w", err)
+	}
+
+	reporter, err := reporting.New(cfg.Errors)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create error reporter: %w", err)
+	}
+
+	templates, err := compileActionTemplates(cfg.Poll.Rules)
+	if err != nil {
+		return nil, err
+	}
+	webhooks, err := compileWebhooks(cfg.Poll.Rules)
+	if err != nil {
+		return nil, err
+	}
+	conditions, err := compileConditions(cfg.Poll.Rules)
+	if err != nil {
+		return nil, err
+	}
+	scripts, err := loadScripts(cfg.Poll.Rules, cfg.Poll.ScriptLimits)
+	if err != nil {
+		return nil, err
+	}
+	loaded, err := loadPlugins(context.Background(), cfg.Plugins)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPluginRules(cfg.Poll.Rules, loaded); err != nil {
+		closePlugins(context.Background(), loaded)
+		return nil, err
+	}
+
+	var registry loopguard.Registry = st
+
 	accountState := make(map[string]*AccountState)
 	for _, account := range cfg.EmailAccounts {
-		accountState[account.ID] = &AccountState{
-			stopChan: make(chan struct{}),
+		state, err := loadAccountState(cfg, st, account.ID)
+		if err != nil {
+			return nil, err
 		}
+		accountState[account.ID] = state
 	}
-	
-	return &EmailPoller{
+
+	p := &EmailPoller{
 		config:       cfg,
 		accountState: accountState,
+		notifier:     notifier,
+		events:       emitter,
+		reporter:     reporter,
+		guard:        loopguard.New(cfg.Loop, registry),
+		budget:       newRuleBudget(cfg.Poll.RuleBudget),
+		store:        st,
+		newProvider:  email.NewProvider,
+		subscribe:    newPushSubscription,
+		loadConfig:   config.Load,
+		templates:    templates,
+		webhooks:     webhooks,
+		conditions:   conditions,
+		scripts:      scripts,
+		plugins:      loaded,
+		digests:      digestQueue{window: cfg.Notify.Digest.Window, store: st},
+		addressLists: newAddressLists(cfg.AddressLists),
+	}
+	if p.actions, err = p.newActionRegistry(); err != nil {
+		closePlugins(context.Background(), loaded)
+		return nil, err
+	}
+	if cfg.HA.Enabled {
+		if p.leases, err = newLeases(cfg, st); err != nil {
+			closePlugins(context.Background(), loaded)
+			return nil, err
+		}
+	}
+	if st != nil {
+		if err := p.ruleStats.load(st); err != nil {
+			closePlugins(context.Background(), loaded)
+			return nil, fmt.Errorf("failed to load rule statistics: %w", err)
+		}
+	}
+	if token := cfg.Integrations.Todoist.Token; token != "" {
+		p.todoist = integrations.NewTodoistClient(token)
+	}
+	if gh := cfg.Integrations.GitHub; gh.Token != "" {
+		var opts []integrations.GitHubOption
+		if gh.BaseURL != "" {
+			opts = append(opts, integrations.WithGitHubURL(gh.BaseURL))
+		}
+		p.github = integrations.NewGitHubClient(gh.Token, opts...)
 	}
+	p.desktop = integrations.NewDesktop()
+	p.unsubscriber = integrations.NewUnsubscriber()
+	warnUnsubscribeProxy(cfg)
+	p.exec = integrations.NewExec(cfg.Integrations.Exec.MaxConcurrent)
+	p.ntfy = integrations.NewNtfyClient(cfg.Integrations.Ntfy.Server, cfg.Integrations.Ntfy.Token)
+	if po := cfg.Integrations.Pushover; po.AppToken != "" && po.UserKey != "" {
+		p.pushover = integrations.NewPushoverClient(po.AppToken, po.UserKey)
+	}
+	if jira := cfg.Integrations.Jira; jira.BaseURL != "" && jira.Token != "" {
+		p.jira = integrations.NewJiraClient(jira.BaseURL, jira.Email, jira.Token)
+	}
+	if dav := cfg.Integrations.CalDAV; dav.URL != "" {
+		p.caldav = integrations.NewCalDAVClient(dav.URL, dav.Username, dav.Password)
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p, nil
 }
 
-// Start begins the polling process for all enabled accounts
+// loadAccountState creates the state of an account, restoring its last
+// sync time and mailbox cursors from st if it is not nil
+func loadAccountState(cfg *config.Config, st store.Store, accountID string) (*AccountState, error) {
+	state := &AccountState{
+		stopChan: make(chan struct{}),
+		pollNow:  make(chan struct{}, 1),
+		backoff:  newBackoff(cfg.Poll.Backoff),
+		circuit:  newCircuitBreaker(cfg.Poll.Circuit),
+		interval: newAdaptiveInterval(cfg.Poll),
+	}
+	if st != nil {
+		lastSync, err := st.LastSync(accountID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load state for account %s: %w", accountID, err)
+		}
+		state.lastSync, state.savedSync = lastSync, lastSync
+
+		if state.cursors, err = st.Cursors(accountID); err != nil {
+			return nil, fmt.Errorf("failed to load cursors for account %s: %w", accountID, err)
+		}
+		state.savedCursors = make(map[string]email.Cursor, len(state.cursors))
+		for mailbox, cursor := range state.cursors {
+			state.savedCursors[mailbox] = cursor
+		}
+	}
+	if state.cursors == nil {
+		state.cursors = make(map[string]email.Cursor)
+	}
+	return state, nil
+}
+
+// Start supervises polling for all enabled accounts. A failing account is
+// restarted with backoff without affecting the others, and accounts added
+// or enabled by Reload are started as they come. Start blocks until ctx is
+// canceled or Shutdown is called, and returns the errors of accounts that
+// could not be restarted. After Shutdown, Start may be called again; it
+// returns ErrAlreadyRunning while a run is in progress.
 func (p *EmailPoller) Start(ctx context.Context) error {
-	var wg sync.WaitGroup
-	errChan := make(chan error, len(p.config.EmailAccounts))
+	p.mu.Lock()
+	prev := p.run
+	if prev != nil && !prev.stopping {
+		p.mu.Unlock()
+		return ErrAlreadyRunning
+	}
+	run := newPollerRun()
+	p.run = run
+	p.startErrs = nil
+	p.mu.Unlock()
+
+	// Accounts of the previous run are started again once its
+	// supervisors have let go of them
+	if prev != nil {
+		prev.supervisors.Wait()
+	}
+	p.mu.Lock()
+	run.ctx = ctx
+	accounts := p.config.EmailAccounts
+	p.mu.Unlock()
 
-	// Start polling for each enabled account
-	for _, account := range p.config.EmailAccounts {
+	// Take the leases first, so accounts of other instances are not polled
+	p.renewLeases()
+
+	// Supervise polling for each enabled account
+	for _, account := range accounts {
 		if !account.Enabled {
 			log.Printf("Account %s (%s) is disabled, skipping", account.ID, account.Name)
 			continue
 		}
-
-		wg.Add(1)
-		go func(acc config.EmailAccount) {
-			defer wg.Done()
-			if err := p.pollAccount(ctx, acc); err != nil {
-				errChan <- fmt.Errorf("error polling account %s: %w", acc.ID, err)
-			}
-		}(account)
+		p.startAccount(account)
 	}
+	p.mu.Lock()
+	run.launched = true
+	run.checkReady()
+	p.mu.Unlock()
 
-	// Wait for all polling goroutines to finish
+	run.supervisors.Add(1)
+	go func() {
+		defer run.supervisors.Done()
+		p.runRetention(ctx, run.stopped)
+	}()
+	run.supervisors.Add(1)
+	go func() {
+		defer run.supervisors.Done()
+		p.runLeases(ctx, run.stopped)
+	}()
+	run.supervisors.Add(1)
 	go func() {
-		wg.Wait()
-		close(errChan)
+		defer run.supervisors.Done()
+		p.runServiceNotifier(ctx, run)
 	}()
 
-	// Return first error if any
-	for err := range errChan {
-		return err
+	select {
+	case <-ctx.Done():
+	case <-run.stopped:
 	}
 
-	return nil
-}
-
-// Stop stops all polling processes and closes connections
-func (p *EmailPoller) Stop() {
+	// No supervisor may be added once the wait begins
 	p.mu.Lock()
-	defer p.mu.Unlock()
+	run.ctx = nil
+	p.mu.Unlock()
+	run.supervisors.Wait()
+	p.releaseLeases()
 
-	for _, state := range p.accountState {
-		if state.isActive {
-			if state.client != nil {
-				if err := state.client.Close(); err != nil {
-					log.Printf("Error closing email client: %v", err)
-				}
-			}
-			close(state.stopChan)
-			state.isActive = false
-		}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if len(p.startErrs) > 0 {
+		return p.startErrs
 	}
+	return nil
+}
+
+// state returns the state of an account, or nil if it is not configured
+func (p *EmailPoller) state(accountID string) *AccountState {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.accountState[accountID]
 }
 
-// pollAccount handles polling for a single account
-func (p *EmailPoller) pollAccount(ctx context.Context, account config.EmailAccount) error {
-	state := p.accountState[account.ID]
-	
+// pollAccount handles polling for a single account in run until ctx is
+// canceled or stop is closed
+func (p *EmailPoller) pollAccount(ctx context.Context, account config.EmailAccount, run *pollerRun, stop <-chan struct{}) error {
+	state := p.state(account.ID)
+
 	p.mu.Lock()
 	if state.isActive {
 		p.mu.Unlock()
-		return fmt.Errorf("polling already active for account %s", account.ID)
+		return errAlreadyActive
 	}
 	state.isActive = true
 	p.mu.Unlock()
 
-	ticker := time.NewTicker(p.config.Poll.Interval)
-	defer ticker.Stop()
+	defer func() {
+		p.mu.Lock()
+		state.isActive = false
+		p.mu.Unlock()
+	}()
+	// Also when the loop panics, so readiness doesn't wait on the account
+	defer p.firstPollDone(run, account.ID)
 
-	// Do initial poll
-	if err := p.poll(ctx, account); err != nil {
-		log.Printf("Initial poll failed for account %s: %v", account.ID, err)
+	if account.Push.Subscription != "" {
+		pushCtx, cancel := context.WithCancel(ctx)
+		pushDone := make(chan struct{})
+		go func() {
+			defer close(pushDone)
+			defer func() {
+				// Polling carries on without push
+				if r := recover(); r != nil {
+					p.accountPanicked(account, "Gmail push", r)
+				}
+			}()
+			p.runGmailPush(pushCtx, account)
+		}()
+		defer func() {
+			cancel()
+			<-pushDone
+		}()
 	}
 
+	// Poll immediately, then wait the poll interval after each success or
+	// an exponentially growing delay after each failure. PollNow cuts the
+	// wait short, unless the account's circuit is open.
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-state.stopChan:
+		case <-stop:
 			return nil
-		case <-ticker.C:
-			if err := p.poll(ctx, account); err != nil {
-				log.Printf("Poll failed for account %s: %v", account.ID, err)
-				continue
+		case <-timer.C:
+		case <-state.pollNow:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
 			}
 		}
+
+		if p.paused(account.ID) || !p.holdsLease(account.ID) {
+			p.firstPollDone(run, account.ID)
+			timer.Reset(p.config.Poll.Interval)
+			continue
+		}
+		if wait := state.circuit.wait(time.Now()); wait > 0 {
+			p.firstPollDone(run, account.ID)
+			timer.Reset(wait)
+			continue
+		}
+		delay, ok, err := p.pollInFlight(ctx, account, run, state)
+		if !ok {
+			return nil
+		}
+		p.firstPollDone(run, account.ID)
+		if n := state.backoff.Attempts(); err != nil && n%restartAfterFailures == 0 {
+			// Let the supervisor restart the loop on fresh connections
+			return &pollsFailingError{Attempts: n, Retry: delay, Err: err}
+		}
+		timer.Reset(delay)
+	}
+}
+
+// pollInFlight runs one poll of account registered as in flight in run, so
+// its shutdown waits for it. It returns false if the shutdown has started.
+func (p *EmailPoller) pollInFlight(ctx context.Context, account config.EmailAccount, run *pollerRun, state *AccountState) (time.Duration, bool, error) {
+	if !p.beginPoll(run) {
+		return 0, false, nil
+	}
+	// Done even if the poll panics, or the shutdown waits forever
+	defer run.inFlight.Done()
+	delay, err := p.pollWithBackoff(ctx, account, state.backoff, state.circuit)
+	return delay, true, err
+}
+
+// pollWithBackoff runs one poll and returns the delay before the next one
+// and the error of the poll, if it failed
+func (p *EmailPoller) pollWithBackoff(ctx context.Context, account config.EmailAccount, bo *backoff, circuit *circuitBreaker) (time.Duration, error) {
+	metrics.Add(account.ID, "polls", 1)
+
+	// Watch for polls that hang, e.g. on a pathological rule or a stuck
+	// connection
+	start := time.Now()
+	watchdog := time.AfterFunc(p.watchdogTimeout(), func() {
+		metrics.Add(account.ID, "slow_polls", 1)
+		log.Printf("Watchdog: poll for account %s still running after %s", account.ID, time.Since(start).Round(time.Second))
+	})
+	pollCtx, span := tracing.Start(ctx, "poll", tracing.Account(account.ID))
+	err := p.pollSafely(pollCtx, account)
+	tracing.End(span, err)
+	watchdog.Stop()
+	metrics.Set(account.ID, "poll_duration_ms", time.Since(start).Milliseconds())
+
+	if err != nil {
+		delay := bo.Next()
+		opened, until := circuit.failure(bo.Attempts(), time.Now())
+		if !until.IsZero() {
+			delay = time.Until(until)
+		}
+		metrics.Add(account.ID, "poll_failures", 1)
+		p.recordError(account.ID, err)
+		metrics.Set(account.ID, "backoff_attempts", int64(bo.Attempts()))
+		metrics.Set(account.ID, "backoff_delay_ms", delay.Milliseconds())
+		log.Printf("Poll failed for account %s (attempt %d), retrying in %s: %v",
+			account.ID, bo.Attempts(), delay.Round(time.Second), err)
+		p.events.Broadcast(events.NewEvent(events.TypePollFailed, account.ID, "", events.ErrorData{
+			Account: account.ID,
+			Error:   err.Error(),
+			Attempt: bo.Attempts(),
+		}))
+		p.reportFailure(account, bo.Attempts(), err)
+		if opened {
+			p.circuitOpened(account, bo.Attempts(), delay, err)
+		}
+		return delay, err
+	}
+
+	if circuit.success() {
+		p.circuitClosed(account)
 	}
+	p.clearDegraded(account.ID, start)
+	if bo.Attempts() > 0 {
+		log.Printf("Poll recovered for account %s after %d failed attempts", account.ID, bo.Attempts())
+		p.resetReported(account.ID)
+		bo.Reset()
+		metrics.Set(account.ID, "backoff_attempts", 0)
+		metrics.Set(account.ID, "backoff_delay_ms", 0)
+	}
+	return p.nextInterval(account.ID), nil
 }
 
-// poll performs a single polling operation for one account
+// nextInterval returns the delay before an account's next poll after a
+// successful one, adapted to the mail it fetched if adaptive polling is on
+// and no shorter than the push fallback while Gmail push works
+func (p *EmailPoller) nextInterval(accountID string) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state := p.accountState[accountID]
+	fetched := state.fetched
+	state.fetched = 0
+	interval := p.config.Poll.Interval
+	if state.interval != nil {
+		interval = state.interval.Next(fetched)
+		metrics.Set(accountID, "poll_interval_ms", interval.Milliseconds())
+	}
+	if state.pushInterval > interval {
+		return state.pushInterval
+	}
+	return interval
+}
+
+// poll performs a single polling operation for one account. Every
+// configured mailbox is polled even if an earlier one fails; the first
+// error is returned.
 func (p *EmailPoller) poll(ctx context.Context, account config.EmailAccount) error {
+	state := p.state(account.ID)
+
+	pool := p.pool(account)
+	connectCtx, span := tracing.Start(ctx, "connect", tracing.Account(account.ID))
+	connectCtx, cancel := withTimeout(connectCtx, p.config.Poll.ConnectTimeout, defaultConnectTimeout)
+	client, err := pool.get(connectCtx)
+	err = timedOut(connectCtx, account, "connect", err)
+	cancel()
+	tracing.End(span, err)
+	if err != nil {
+		return err
+	}
+	defer pool.put(client)
+
+	p.wakeSnoozed(ctx, account, client)
+	p.addressLists.refresh(ctx)
+
+	mailboxes, err := p.mailboxes(ctx, account, client)
+	if err != nil {
+		return err
+	}
+
+	var matched []notify.Entry
+	var firstErr error
+	for _, mailbox := range mailboxes {
+		entries, err := p.pollMailbox(ctx, account, state, client, mailbox)
+		matched = append(matched, entries...)
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("mailbox %s: %w", mailbox, err)
+		}
+	}
+	p.sendDigest(ctx, account, matched)
+	p.sendDueDigest(ctx, account)
+	p.sendDueReminders(ctx, account)
+	p.guard.Prune()
+	p.ruleStats.save(p.store)
+
+	// Cursors of mailboxes that failed may have moved as well
 	p.mu.Lock()
-	state := p.accountState[account.ID]
-	lastSync := state.lastSync
+	defer p.mu.Unlock()
+	if firstErr == nil {
+		state.lastSync = time.Now()
+	}
+	p.persistLocked(account.ID, state)
+	return firstErr
+}
+
+// mailboxes resolves the account's mailbox settings, listing the server's
+// mailboxes only when a setting is a pattern
+func (p *EmailPoller) mailboxes(ctx context.Context, account config.EmailAccount, client email.Provider) ([]string, error) {
+	var listed []string
+	for _, setting := range account.Mailboxes {
+		if email.HasPattern(setting) {
+			var err error
+			if listed, err = client.ListMailboxes(ctx); err != nil {
+				return nil, fmt.Errorf("failed to list mailboxes: %w", err)
+			}
+			break
+		}
+	}
+
+	mailboxes := email.ResolveMailboxes(account.Mailboxes, listed)
+	metrics.Set(account.ID, "mailboxes", int64(len(mailboxes)))
+	return mailboxes, nil
+}
+
+// pollMailbox fetches and processes the new mail in one mailbox, advancing
+// its cursor, and returns the notify matches. Providers that stream are
+// processed a chunk at a time, so memory stays bounded however much mail
+// is new, and the cursor advances after each chunk: a poll cancelled
+// midway keeps the progress made.
+func (p *EmailPoller) pollMailbox(ctx context.Context, account config.EmailAccount, state *AccountState, client email.Provider, mailbox string) ([]notify.Entry, error) {
+	p.mu.Lock()
+	cursor := state.cursors[mailbox]
 	p.mu.Unlock()
 
-	// Initialize client if needed
-	if state.client == nil {
-		client, err := email.NewGmailClient(
-			account.ClientID,
-			account.ClientSecret,
-			account.Token,
-		)
-		if err != nil {
-			return fmt.Errorf("failed to create Gmail client: %w", err)
+	if cursor.IsZero() {
+		var err error
+		if cursor, err = p.initialCursor(ctx, account, client, mailbox); err != nil {
+			return nil, err
 		}
+	}
 
-		if err := client.Connect(); err != nil {
-			return fmt.Errorf("failed to connect to Gmail: %w", err)
+	var matched []notify.Entry
+	fetched := 0
+	handle := func(emails []*email.Email, next email.Cursor) error {
+		if err := ctx.Err(); err != nil {
+			return err
 		}
+		limit := account.Limits.MaxMessages
+		emails, next = limitMessages(account.ID, limit-fetched, emails, next)
+		for _, msg := range emails {
+			p.events.Broadcast(events.NewEvent(events.TypeEmailFetched, account.ID, msg.Key(next.UIDValidity), events.MessageData{
+				Account:   account.ID,
+				Mailbox:   msg.Mailbox,
+				UID:       msg.UID,
+				MessageID: msg.MessageID,
+				Subject:   msg.Subject,
+				From:      msg.From,
+				Date:      msg.Date,
+			}))
+		}
+
+		matched = append(matched, p.processEmails(ctx, account, client, next.UIDValidity, emails)...)
+		p.cancelAnswered(account, emails)
+		fetched += len(emails)
+
+		p.mu.Lock()
+		state.cursors[mailbox] = next
+		state.fetched += len(emails)
+		p.mu.Unlock()
+		if limit > 0 && fetched >= limit {
+			return errPollLimit
+		}
+		return nil
+	}
 
-		if err := client.Authenticate(); err != nil {
-			return fmt.Errorf("failed to authenticate with Gmail: %w", err)
+	// Fetch and process new emails
+	fetchCtx, span := tracing.Start(ctx, "fetch", tracing.Account(account.ID), tracing.Mailbox(mailbox))
+	fetchCtx, cancel := withTimeout(fetchCtx, p.config.Poll.FetchTimeout, defaultFetchTimeout)
+	defer cancel()
+	var next email.Cursor
+	err := p.retryPolicy(account, "Fetching "+mailbox).Do(fetchCtx, func(ctx context.Context, attempt int) error {
+		// A retry resumes after the chunks already processed
+		from := cursor
+		if attempt > 1 {
+			p.mu.Lock()
+			from = state.cursors[mailbox]
+			p.mu.Unlock()
 		}
+		var err error
+		next, err = email.StreamNewEmails(ctx, client, mailbox, from, handle)
+		if errors.Is(err, email.ErrUIDValidityChanged) {
+			log.Printf("UIDVALIDITY of %s for account %s changed from %d to %d; resyncing the whole mailbox",
+				mailbox, account.ID, from.UIDValidity, next.UIDValidity)
+			metrics.Add(account.ID, "uidvalidity_resets", 1)
+			span.AddEvent("uidvalidity reset")
+			next, err = email.StreamNewEmails(ctx, client, mailbox, next, handle)
+		}
+		return err
+	})
+	err = timedOut(fetchCtx, account, "fetch", err)
+	if errors.Is(err, errPollLimit) {
+		// The cursor stopped at the last message processed
+		err = nil
+	} else if err == nil {
+		// Without new mail, the cursor may still have moved, e.g. its
+		// mod-sequence
+		p.mu.Lock()
+		state.cursors[mailbox] = next
+		p.mu.Unlock()
+	}
+	span.SetAttributes(tracing.Messages(fetched))
+	tracing.End(span, err)
+	if err != nil {
+		return matched, fmt.Errorf("failed to fetch emails: %w", err)
+	}
+	return matched, nil
+}
+
+// errPollLimit stops a mailbox's stream once Limits.MaxMessages were
+// processed
+var errPollLimit = errors.New("per-poll message limit reached")
 
-		state.client = client
+// connect creates, connects and authenticates the provider for an account
+func (p *EmailPoller) connect(ctx context.Context, account config.EmailAccount) (email.Provider, error) {
+	return p.connectWith(ctx, account, newThrottler(account))
+}
+
+// connectWith is connect with the provider wrapped by throttle, which the
+// connections of a pool share
+func (p *EmailPoller) connectWith(ctx context.Context, account config.EmailAccount, throttle func(email.Provider) email.Provider) (email.Provider, error) {
+	client, err := p.newProvider(account)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s provider: %w", account.Provider, err)
+	}
+	client = throttle(client)
+
+	if err := client.Connect(ctx); err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", account.Provider, err)
 	}
 
-	// Fetch new emails
-	emails, err := state.client.FetchNewEmails(ctx, lastSync)
+	if err := client.Authenticate(ctx); err != nil {
+		client.Close()
+		return nil, authError{fmt.Errorf("failed to authenticate with %s: %w", account.Provider, err)}
+	}
+
+	return client, nil
+}
+
+// initialCursor picks where the first poll of a mailbox starts. A huge
+// mailbox must not turn into an accidental backfill, so unless the mailbox
+// is within the initial sync limit the poll starts from the newest message.
+func (p *EmailPoller) initialCursor(ctx context.Context, account config.EmailAccount, client email.Provider, mailbox string) (email.Cursor, error) {
+	status, err := client.Status(ctx, mailbox)
 	if err != nil {
-		return fmt.Errorf("failed to fetch emails: %w", err)
+		return email.Cursor{}, fmt.Errorf("failed to get mailbox status: %w", err)
 	}
+	metrics.Set(account.ID, "mailbox_size", int64(status.Messages))
 
-	// Process emails according to rules
-	for _, email := range emails {
-		for _, rule := range p.config.Poll.Rules {
-			if containsIgnoreCase(email.Subject, rule.SubjectContains) {
-				if err := state.client.ApplyLabel(email.UID, rule.Label); err != nil {
-					log.Printf("Failed to apply label to email %d: %v", email.UID, err)
-					continue
-				}
-				log.Printf("Applied label '%s' to email with subject: %s", rule.Label, email.Subject)
+	if status.Messages <= p.config.Poll.InitialSyncLimit {
+		log.Printf("First run of %s for account %s: processing all %d existing messages", mailbox, account.ID, status.Messages)
+		return email.Cursor{UIDValidity: status.UIDValidity}, nil
+	}
+
+	log.Printf("First run of %s for account %s: mailbox has %d messages (limit %d), starting from now; "+
+		"run the backfill command to process existing mail", mailbox, account.ID, status.Messages, p.config.Poll.InitialSyncLimit)
+	return status.Head(), nil
+}
+
+// processEmails applies the configured rules to emails from one mailbox and
+// returns the notify matches. Messages already in the processed journal are
+// skipped, so re-fetched mail is never acted on twice. Labels and snoozes
+// are sent once all messages went through the rules, one command per
+// label or destination.
+func (p *EmailPoller) processEmails(ctx context.Context, account config.EmailAccount, client email.Provider, uidValidity uint32, emails []*email.Email) []notify.Entry {
+	// Hold the rules for the whole batch so a reload can't swap them midway
+	p.rulesMu.RLock()
+	defer p.rulesMu.RUnlock()
+
+	stores := &storeBatch{}
+	ctx = withBatch(ctx, stores)
+
+	var matched []notify.Entry
+	var done []string
+	batch := make(map[string]bool, len(emails))
+	for _, msg := range emails {
+		key := msg.Key(uidValidity)
+		// Mail journaled by earlier versions is found under its old key
+		legacy := msg.LegacyKey(uidValidity)
+		if batch[key] || p.processed(account.ID, key) || (legacy != "" && p.processed(account.ID, legacy)) {
+			metrics.Add(account.ID, "duplicates_skipped", 1)
+			continue
+		}
+		batch[key] = true
+		p.keepMessage(account, key, msg)
+
+		if err := p.guard.CheckMessage(msg); errors.Is(err, loopguard.ErrSelfGenerated) {
+			p.loopDetected(account, key, msg, err)
+			p.markProcessed(account.ID, key)
+			continue
+		} else if err != nil {
+			log.Printf("Loop check failed for account %s: %v", account.ID, err)
+		}
+
+		inheritFailed := p.inheritThreadLabels(ctx, account, client, key, msg)
+		entries, failed := p.applyRules(ctx, account, client, key, msg)
+		matched = append(matched, entries...)
+
+		// Leave failed messages out of the journal so a resync retries them
+		if !failed && !inheritFailed {
+			done = append(done, key)
+		}
+	}
+
+	storeFailed := p.flushBatch(ctx, account, client, stores)
+	for _, key := range done {
+		if !storeFailed[key] {
+			p.markProcessed(account.ID, key)
+		}
+	}
+	return matched
+}
+
+// applyRules runs every matching rule on one message. It returns the
+// notify matches and whether any action failed. p.rulesMu must be held.
+func (p *EmailPoller) applyRules(ctx context.Context, account config.EmailAccount, client email.Provider, key string, msg *email.Email) ([]notify.Entry, bool) {
+	ctx, span := tracing.Start(ctx, "rules", tracing.Account(account.ID), tracing.Mailbox(msg.Mailbox), tracing.UID(msg.UID))
+	defer span.End()
+
+	var matched []notify.Entry
+	failed := false
+	for i, rule := range p.config.Poll.Rules {
+		if !p.matches(ctx, account, i, rule, msg) {
+			continue
+		}
+		if !rules.Sampled(rule, msg, key) {
+			metrics.Add(account.ID, "sampled_out", 1)
+			continue
+		}
+		entries, ruleFailed := p.applyRule(ctx, account, client, i, rule, key, msg)
+		matched = append(matched, entries...)
+		failed = failed || ruleFailed
+	}
+	return matched, failed
+}
+
+// applyRule runs the actions of rule i, which matched a message. It
+// returns the notify matches and whether any action failed. p.rulesMu
+// must be held.
+func (p *EmailPoller) applyRule(ctx context.Context, account config.EmailAccount, client email.Provider, i int, rule config.Rule, key string, msg *email.Email) ([]notify.Entry, bool) {
+	p.recordMatch(account, rule, msg)
+	p.ruleMatched(i, rule)
+	p.emit(account, events.TypeRuleMatched, key, rule, msg)
+
+	// Statistics are kept for the configured rule, not the one its script
+	// returns
+	configured := rule
+	if rule.Script != "" {
+		var err error
+		if rule, err = p.scriptedRule(ctx, i, rule, msg); err != nil {
+			p.ruleActed(i, configured, err)
+			metrics.Add(account.ID, "script_errors", 1)
+			log.Printf("Failed to run script of rule %d on email %d in %s: %v", i, msg.UID, msg.Mailbox, err)
+			return nil, true
+		}
+	}
+
+	// Only a chain's last step is batched: a batched step's outcome is known
+	// once the batch is flushed, and a failed step must stop the ones after
+	// it
+	stores := batchFrom(ctx)
+	steps := config.Steps(rule)
+
+	var matched []notify.Entry
+	failed := false
+	for j, step := range steps {
+		step := step // Captured by finish, which may run after the loop
+		if step.Action == "notify" {
+			matched = append(matched, newEntry(account, step, msg))
+			p.ruleActed(i, configured, nil)
+			continue
+		}
+
+		journalKey := ""
+		if journaled(step) {
+			journalKey = actionJournalKey(key, rule, j)
+			if p.processed(account.ID, journalKey) {
+				// Applied by an earlier try at this message
+				metrics.Add(account.ID, "actions_deduplicated", 1)
+				continue
+			}
+		}
+
+		// finish records the step's outcome
+		finish := func(err error) {
+			p.ruleActed(i, configured, err)
+			if err != nil {
+				log.Printf("Failed to apply rule %d to email %d in %s: %v", i, msg.UID, msg.Mailbox, err)
+				return
+			}
+			if journalKey != "" {
+				p.markProcessed(account.ID, journalKey)
 			}
+			p.emit(account, events.TypeActionApplied, key, step, msg)
+		}
+		stepCtx := ctx
+		var batched *batchStep
+		if stores != nil && j == len(steps)-1 {
+			batched = &batchStep{batch: stores, settle: finish}
+			stepCtx = withBatchStep(ctx, batched)
+		}
+
+		actionCtx, actionSpan := tracing.Start(stepCtx, "action "+ruleAction(step),
+			tracing.Account(account.ID), tracing.UID(msg.UID), tracing.Rule(i))
+		err := p.retryPolicy(account, "Action "+ruleAction(step)).Do(actionCtx, func(ctx context.Context, attempt int) error {
+			ctx, cancel := withTimeout(ctx, p.config.Poll.ActionTimeout, defaultActionTimeout)
+			defer cancel()
+			return timedOut(ctx, account, "action", p.applyActionSafely(ctx, account, client, i, step, key, msg))
+		})
+		tracing.End(actionSpan, err)
+		if err == nil && batched != nil && batched.queued {
+			// Finished when the batch is flushed
+			continue
+		}
+		finish(err)
+		if err != nil {
+			failed = true
+			if j < len(rule.Actions) && rule.Actions[j].OnError == "continue" {
+				continue
+			}
+			break
 		}
 	}
+	return matched, failed
+}
 
-	p.mu.Lock()
-	state.lastSync = time.Now()
-	p.mu.Unlock()
-	
+// applyAction runs the action of rule i, other than notify, on a message
+func (p *EmailPoller) applyAction(ctx context.Context, account config.EmailAccount, client email.Provider, i int, rule config.Rule, key string, msg *email.Email) error {
+	name := ruleAction(rule)
+	action, ok := p.actions.Lookup(name)
+	if !ok {
+		return retry.Permanent(fmt.Errorf("unknown action %q", name))
+	}
+	return action.Execute(ctx, msg, actions.Params{Account: account, Provider: client, Rule: rule, RuleIndex: i, Key: key})
+}
+
+// sendDigest sends one digest for all notify matches
+func (p *EmailPoller) sendDigest(ctx context.Context, account config.EmailAccount, matched []notify.Entry) {
+	digest := notify.Digest{Account: account.Name, Entries: matched}
+	if err := p.notifier.Send(ctx, digest); err != nil {
+		log.Printf("Failed to send notifications for account %s: %v", account.ID, err)
+	}
+}
+
+// newEntry describes a message a rule matched for a notification
+func newEntry(account config.EmailAccount, rule config.Rule, msg *email.Email) notify.Entry {
+	return notify.Entry{
+		Account:   account.Name,
+		MessageID: msg.MessageID,
+		Subject:   msg.Subject,
+		From:      msg.From,
+		Date:      msg.Date,
+		Rule:      rules.Describe(rule),
+		Label:     rule.Label,
+	}
+}
+
+// createTask creates a task for a matching message in the rule's task
+// target. Config validation guarantees a store or a Todoist token for the
+// target a rule uses.
+func (p *EmailPoller) createTask(ctx context.Context, account config.EmailAccount, rule config.Rule, msg *email.Email, key string) error {
+	task := tasks.FromEmail(account.ID, rule, msg, key, time.Now())
+
+	if rule.TaskTarget == "todoist" {
+		if p.todoist == nil {
+			return fmt.Errorf("no Todoist token configured")
+		}
+		id, err := p.todoist.CreateTask(ctx, integrations.NewTodoistTask(task, rule.TodoistProject, rule.DueString), account.ID+"/"+key)
+		if err != nil {
+			return err
+		}
+		metrics.Add(account.ID, "tasks_created", 1)
+		log.Printf("Created Todoist task %s: %s", id, logging.Subject(task.Title))
+		return nil
+	}
+
+	if p.store == nil {
+		return fmt.Errorf("no state store configured")
+	}
+	created, err := p.store.CreateTask(&task)
+	if err != nil {
+		return err
+	}
+	if created {
+		metrics.Add(account.ID, "tasks_created", 1)
+		log.Printf("Created task %d: %s", task.ID, logging.Subject(task.Title))
+	}
 	return nil
 }
 
-// containsIgnoreCase checks if substr is in s, case-insensitive
-func containsIgnoreCase(s, substr string) bool {
-	s, substr = strings.ToLower(s), strings.ToLower(substr)
-	return strings.Contains(s, substr)
+// emit publishes an event about a message and the rule it matched
+func (p *EmailPoller) emit(account config.EmailAccount, eventType, key string, rule config.Rule, msg *email.Email) {
+	action := ruleAction(rule)
+	ev := events.NewEvent(eventType, account.ID, key, events.MessageData{
+		Account:   account.ID,
+		Mailbox:   msg.Mailbox,
+		UID:       msg.UID,
+		MessageID: msg.MessageID,
+		Subject:   msg.Subject,
+		From:      msg.From,
+		Date:      msg.Date,
+		Rule:      rules.Describe(rule),
+		Action:    action,
+		Label:     rule.Label,
+	})
+	p.enqueue(account.ID, ev)
+}
+
+// enqueue hands an event to the sinks without waiting for them, so a slow
+// sink never holds up processing. Sink failures are logged and counted in
+// event_failures; events the full queue can't take in events_dropped.
+func (p *EmailPoller) enqueue(accountID string, ev events.Event) {
+	if err := p.events.Enqueue(ev); err != nil {
+		metrics.Add(accountID, "events_dropped", 1)
+		log.Printf("Dropped %s event for account %s: %v", ev.Type, accountID, err)
+	}
+}
+
+// matches evaluates one rule against a message within the rule budget.
+// Suspended rules never match; a rule that keeps overrunning its budget is
+// suspended and the suspension reported.
+func (p *EmailPoller) matches(ctx context.Context, account config.EmailAccount, i int, rule config.Rule, msg *email.Email) bool {
+	if p.budget.Suspended(i) {
+		metrics.Add(account.ID, "suspended_rule_skips", 1)
+		return false
+	}
+
+	start := time.Now()
+	matched := rules.Matches(rule, msg)
+	if matched && rule.ThreadAlreadyLabeled != "" {
+		matched = p.threadLabeled(account, msg, rule.ThreadAlreadyLabeled)
+	}
+	if matched && (rule.FromInList != "" || rule.FromNotInList != "") {
+		matched = p.fromListsMatch(rule, msg)
+	}
+	if matched {
+		var err error
+		if matched, err = p.conditions[i].Holds(msg); err != nil {
+			metrics.Add(account.ID, "condition_errors", 1)
+			log.Printf("Condition of rule %d failed on email %d in %s: %v", i, msg.UID, msg.Mailbox, err)
+		}
+	}
+	if matched {
+		matched = p.pluginMatches(ctx, account, i, rule, msg)
+	}
+	if p.budget.Observe(i, time.Since(start)) {
+		p.ruleSuspended(account, i, rule, msg)
+	}
+	return matched
+}
+
+// warnUnsubscribeProxy logs at startup that one-click unsubscribes bypass
+// the configured proxy, if any rule unsubscribes
+func warnUnsubscribeProxy(cfg *config.Config) {
+	if !proxy.Configured(cfg.Proxy.URL) {
+		return
+	}
+	for _, rule := range cfg.Poll.Rules {
+		if hasAction(rule, "unsubscribe") {
+			log.Printf("One-click unsubscribe requests connect directly, not through the proxy, so go-tsk can check they go to public addresses")
+			return
+		}
+	}
+}
+
+// ruleSuspended logs, counts, publishes and reports that rule i was
+// suspended while evaluating msg
+func (p *EmailPoller) ruleSuspended(account config.EmailAccount, i int, rule config.Rule, msg *email.Email) {
+	reason := fmt.Sprintf("rule %d (%s) repeatedly exceeded its %s budget", i, rules.Describe(rule), p.budget.limit)
+	metrics.Add(account.ID, "rule_suspensions", 1)
+	log.Printf("Rule %d (%s) repeatedly exceeded its %s budget and is suspended for %s",
+		i, rules.Describe(rule), p.budget.limit, p.budget.suspend)
+
+	p.enqueue(account.ID, events.NewEvent(events.TypeRuleSuspended, account.ID, msg.MessageID, events.MessageData{
+		Account:   account.ID,
+		Mailbox:   msg.Mailbox,
+		UID:       msg.UID,
+		MessageID: msg.MessageID,
+		Subject:   msg.Subject,
+		From:      msg.From,
+		Date:      msg.Date,
+		Rule:      rules.Describe(rule),
+		Action:    "suspend",
+	}))
+	p.reporter.Report(reporting.Report{
+		Kind:    reporting.KindRuleSuspended,
+		Account: account.ID,
+		Error:   reason,
+		Context: map[string]string{
+			"rule":          strconv.Itoa(i),
+			"mailbox":       msg.Mailbox,
+			"uid":           strconv.FormatUint(uint64(msg.UID), 10),
+			"suspended_for": p.budget.suspend.String(),
+		},
+	})
+}
+
+// watchdogTimeout returns how long a poll may run before the watchdog
+// complains
+func (p *EmailPoller) watchdogTimeout() time.Duration {
+	if p.config.Poll.Watchdog > 0 {
+		return p.config.Poll.Watchdog
+	}
+	if p.config.Poll.Interval > 0 {
+		return 5 * p.config.Poll.Interval
+	}
+	return 5 * config.DefaultConfig().Poll.Interval
+}
+
+// loopDetected reports that loop protection stopped go-tsk from acting on
+// a message, in the log, the metrics and as an event
+func (p *EmailPoller) loopDetected(account config.EmailAccount, key string, msg *email.Email, reason error) {
+	metrics.Add(account.ID, "loop_protection_triggered", 1)
+	log.Printf("Loop protection for account %s, message %s: %v", account.ID, key, reason)
+
+	ev := events.NewEvent(events.TypeLoopDetected, account.ID, key, events.MessageData{
+		Account:   account.ID,
+		Mailbox:   msg.Mailbox,
+		UID:       msg.UID,
+		MessageID: msg.MessageID,
+		Subject:   msg.Subject,
+		From:      msg.From,
+		Date:      msg.Date,
+		Rule:      reason.Error(),
+		Action:    "skip",
+	})
+	p.enqueue(account.ID, ev)
+}
+
+// processed reports whether the journal already has the message. Without a
+// store there is no journal and the UID cursor alone prevents repeats.
+func (p *EmailPoller) processed(accountID, key string) bool {
+	if p.store == nil {
+		return false
+	}
+	done, err := p.store.IsProcessed(accountID, key)
+	if err != nil {
+		// Processing twice is better than silently dropping the message
+		log.Printf("Failed to check processed journal for account %s: %v", accountID, err)
+		return false
+	}
+	return done
+}
+
+// markProcessed adds the message to the journal
+func (p *EmailPoller) markProcessed(accountID, key string) {
+	if p.store == nil {
+		return
+	}
+	if err := p.store.MarkProcessed(accountID, key, time.Now()); err != nil {
+		log.Printf("Failed to record processed message for account %s: %v", accountID, err)
+	}
 }
@@ -0,0 +1,401 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/imaptest"
+)
+
+// newTestPoller returns a poller whose single account talks to srv
+func newTestPoller(t *testing.T, srv *imaptest.Server) (*EmailPoller, config.EmailAccount) {
+	t.Helper()
+
+	cfg := config.DefaultConfig()
+	cfg.Poll.InitialSyncLimit = 100
+	account := cfg.EmailAccounts[0]
+
+	factory := func(account config.EmailAccount) (email.Provider, error) {
+		return email.NewGmailClient(imaptest.Username, "", "", imaptest.Token,
+			email.WithServer(srv.Addr(), srv.TLSConfig()))
+	}
+	p, err := NewEmailPoller(cfg, nil, WithProviderFactory(factory))
+	if err != nil {
+		t.Fatalf("NewEmailPoller() error = %v", err)
+	}
+	t.Cleanup(p.Stop)
+	return p, account
+}
+
+func TestPollLabelsMatchingMail(t *testing.T) {
+	srv := imaptest.New(t,
+		imaptest.Message{Subject: "Job opportunity at Example Corp", From: "jobs@example.com"},
+		imaptest.Message{Subject: "Weekly newsletter", From: "news@example.com"},
+	)
+	p, account := newTestPoller(t, srv)
+	ctx := context.Background()
+
+	if err := p.poll(ctx, account); err != nil {
+		t.Fatalf("poll() error = %v", err)
+	}
+	if flags := srv.Flags("INBOX", 1); len(flags) != 1 || flags[0] != "imp" {
+		t.Errorf("flags of matching message = %v; want [imp]", flags)
+	}
+	if flags := srv.Flags("INBOX", 2); len(flags) != 0 {
+		t.Errorf("flags of other message = %v; want none", flags)
+	}
+
+	// New mail is picked up on the next poll, across a dropped connection
+	srv.Append("INBOX", imaptest.Message{Subject: "Another job opportunity", From: "jobs@example.com"})
+	srv.DropConnections()
+	if err := p.poll(ctx, account); err != nil {
+		t.Fatalf("second poll() error = %v", err)
+	}
+	if flags := srv.Flags("INBOX", 3); len(flags) != 1 || flags[0] != "imp" {
+		t.Errorf("flags of new matching message = %v; want [imp]", flags)
+	}
+}
+
+func TestPollStartsLargeMailboxFromNow(t *testing.T) {
+	srv := imaptest.New(t,
+		imaptest.Message{Subject: "Old job opportunity", From: "jobs@example.com"},
+		imaptest.Message{Subject: "Older job opportunity", From: "jobs@example.com"},
+	)
+	p, account := newTestPoller(t, srv)
+	p.config.Poll.InitialSyncLimit = 1
+	ctx := context.Background()
+
+	if err := p.poll(ctx, account); err != nil {
+		t.Fatalf("poll() error = %v", err)
+	}
+	for _, msg := range srv.Messages("INBOX") {
+		if len(msg.Flags) != 0 {
+			t.Errorf("existing message %d labeled %v; want untouched", msg.UID, msg.Flags)
+		}
+	}
+
+	srv.Append("INBOX", imaptest.Message{Subject: "New job opportunity", From: "jobs@example.com"})
+	if err := p.poll(ctx, account); err != nil {
+		t.Fatalf("second poll() error = %v", err)
+	}
+	if flags := srv.Flags("INBOX", 3); len(flags) != 1 {
+		t.Errorf("flags of new message = %v; want [imp]", flags)
+	}
+}
+
+func TestPollMultipleMailboxes(t *testing.T) {
+	srv := imaptest.New(t, imaptest.Message{Subject: "Job opportunity in INBOX", From: "jobs@example.com"})
+	srv.Append("Lists/jobs", imaptest.Message{Subject: "Job opportunity on the list", From: "list@example.com"})
+	srv.Append("Lists/golang", imaptest.Message{Subject: "Job opportunity for gophers", From: "golang@example.com"})
+	srv.Append("Archive", imaptest.Message{Subject: "Old job opportunity", From: "jobs@example.com"})
+	p, account := newTestPoller(t, srv)
+	account.Mailboxes = []string{"INBOX", "Lists/*"}
+	p.config.Poll.Rules = append(p.config.Poll.Rules,
+		config.Rule{SubjectContains: "gophers", Label: "go", Mailbox: "Lists/golang"})
+	ctx := context.Background()
+
+	if err := p.poll(ctx, account); err != nil {
+		t.Fatalf("poll() error = %v", err)
+	}
+
+	tests := []struct {
+		mailbox string
+		want    []string
+	}{
+		{"INBOX", []string{"imp"}},
+		{"Lists/jobs", []string{"imp"}},
+		{"Lists/golang", []string{"imp", "go"}},
+		{"Archive", nil},
+	}
+	for _, tt := range tests {
+		if flags := srv.Flags(tt.mailbox, 1); !reflect.DeepEqual(flags, tt.want) {
+			t.Errorf("flags in %s = %v; want %v", tt.mailbox, flags, tt.want)
+		}
+	}
+
+	if got := len(p.accountState[account.ID].cursors); got != 3 {
+		t.Errorf("tracked %d mailbox cursors; want 3", got)
+	}
+}
+
+func TestMessageLookup(t *testing.T) {
+	srv := imaptest.New(t,
+		imaptest.Message{MessageID: "<a@example.com>", Subject: "Invoice #42", From: "billing@example.com", Body: "Amount due: 10"},
+	)
+	p, account := newTestPoller(t, srv)
+	ctx := context.Background()
+
+	if _, err := p.Message(ctx, account.ID, "", 1); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("Message() before the first poll error = %v; want ErrNotConnected", err)
+	}
+	if err := p.poll(ctx, account); err != nil {
+		t.Fatalf("poll() error = %v", err)
+	}
+
+	m, err := p.Message(ctx, account.ID, "", 1)
+	if err != nil {
+		t.Fatalf("Message() error = %v", err)
+	}
+	if m.Subject != "Invoice #42" || m.TextBody != "Amount due: 10" {
+		t.Errorf("Message() = %+v", m.Email)
+	}
+
+	m, err = p.FindMessage(ctx, account.ID, "<a@example.com>")
+	if err != nil || m.UID != 1 {
+		t.Errorf("FindMessage() = %+v, %v; want UID 1", m, err)
+	}
+	if _, err := p.FindMessage(ctx, account.ID, "<missing@example.com>"); !errors.Is(err, email.ErrMessageNotFound) {
+		t.Errorf("FindMessage(missing) error = %v; want ErrMessageNotFound", err)
+	}
+	if _, err := p.Message(ctx, "nosuch", "", 1); !errors.Is(err, ErrUnknownAccount) {
+		t.Errorf("Message(nosuch) error = %v; want ErrUnknownAccount", err)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	old, recent := now.AddDate(0, 0, -120), now.AddDate(0, 0, -10)
+	srv := imaptest.New(t,
+		imaptest.Message{Subject: "old 1", Date: old, Flags: []string{"imp"}},
+		imaptest.Message{Subject: "old 2", Date: old, Flags: []string{"imp", "keep"}},
+		imaptest.Message{Subject: "old unlabeled", Date: old},
+		imaptest.Message{Subject: "recent", Date: recent, Flags: []string{"imp"}},
+	)
+	srv.Append("Trash", imaptest.Message{Subject: "junk 1", Date: recent}, imaptest.Message{Subject: "junk 2", Date: old})
+	p, account := newTestPoller(t, srv)
+	ctx := context.Background()
+
+	var progress []CleanupProgress
+	opts := CleanupOptions{
+		BatchSize: 1,
+		Now:       func() time.Time { return now },
+		Progress:  func(pr CleanupProgress) { progress = append(progress, pr) },
+	}
+	job := config.CleanupJob{Account: account.ID, Action: "remove-label", Label: "imp", OlderThan: 90 * 24 * time.Hour}
+
+	// A dry run only counts
+	dry := opts
+	dry.DryRun = true
+	if n, err := p.Cleanup(ctx, job, dry); err != nil || n != 2 {
+		t.Fatalf("dry-run Cleanup() = %d, %v; want 2", n, err)
+	}
+	if flags := srv.Flags("INBOX", 1); len(flags) != 1 {
+		t.Errorf("flags after dry run = %v; want unchanged", flags)
+	}
+
+	progress = nil
+	if n, err := p.Cleanup(ctx, job, opts); err != nil || n != 2 {
+		t.Fatalf("Cleanup() = %d, %v; want 2", n, err)
+	}
+	want := map[uint32][]string{1: {}, 2: {"keep"}, 3: {}, 4: {"imp"}}
+	for uid, flags := range want {
+		if got := srv.Flags("INBOX", uid); len(got) != len(flags) || (len(flags) > 0 && got[0] != flags[0]) {
+			t.Errorf("flags of UID %d = %v; want %v", uid, got, flags)
+		}
+	}
+	if len(progress) != 3 || progress[2].Done != 2 || progress[2].Total != 2 {
+		t.Errorf("progress = %+v; want 0/2, 1/2, 2/2", progress)
+	}
+
+	expunge := config.CleanupJob{Account: account.ID, Mailbox: "Trash", Action: "expunge"}
+	if n, err := p.Cleanup(ctx, expunge, opts); err != nil || n != 2 {
+		t.Fatalf("expunge Cleanup() = %d, %v; want 2", n, err)
+	}
+	if left := srv.Messages("Trash"); len(left) != 0 {
+		t.Errorf("Trash still holds %d messages", len(left))
+	}
+}
+
+func TestRuntimeControl(t *testing.T) {
+	srv := imaptest.New(t,
+		imaptest.Message{Subject: "Job opportunity at Example Corp", From: "jobs@example.com"},
+	)
+	p, account := newTestPoller(t, srv)
+	ctx := context.Background()
+
+	if err := p.poll(ctx, account); err != nil {
+		t.Fatalf("poll() error = %v", err)
+	}
+	matches, err := p.RecentMatches(account.ID, 0)
+	if err != nil || len(matches) != 1 || matches[0].UID != 1 || matches[0].Action != "label" {
+		t.Errorf("RecentMatches() = %+v, %v; want UID 1 labeled", matches, err)
+	}
+	if s, err := p.Account(account.ID); err != nil || !s.Connected || s.LastSync.IsZero() {
+		t.Errorf("Account() = %+v, %v; want connected and synced", s, err)
+	}
+
+	if err := p.Pause(account.ID); err != nil {
+		t.Fatalf("Pause() error = %v", err)
+	}
+	if err := p.PollNow(account.ID); !errors.Is(err, ErrPaused) {
+		t.Errorf("PollNow() while paused error = %v; want ErrPaused", err)
+	}
+	if err := p.Resume(account.ID); err != nil {
+		t.Fatalf("Resume() error = %v", err)
+	}
+	if err := p.PollNow(account.ID); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("PollNow() without Start error = %v; want ErrNotRunning", err)
+	}
+	if err := p.Pause("nosuch"); !errors.Is(err, ErrUnknownAccount) {
+		t.Errorf("Pause(nosuch) error = %v; want ErrUnknownAccount", err)
+	}
+
+	// Invalid rules leave the current ones in place
+	if err := p.SetRules([]config.Rule{{SubjectContains: "invoice", Action: "label"}}); err == nil {
+		t.Error("SetRules() with a label rule without a label error = nil")
+	}
+	if err := p.SetRules([]config.Rule{{SubjectContains: "invoice", Label: "billing"}}); err != nil {
+		t.Fatalf("SetRules() error = %v", err)
+	}
+	srv.Append("INBOX",
+		imaptest.Message{Subject: "Another job opportunity", From: "jobs@example.com"},
+		imaptest.Message{Subject: "Your invoice", From: "billing@example.com"},
+	)
+	if err := p.poll(ctx, account); err != nil {
+		t.Fatalf("poll() after SetRules error = %v", err)
+	}
+	if flags := srv.Flags("INBOX", 2); len(flags) != 0 {
+		t.Errorf("flags under the old rule = %v; want none", flags)
+	}
+	if flags := srv.Flags("INBOX", 3); len(flags) != 1 || flags[0] != "billing" {
+		t.Errorf("flags under the new rule = %v; want [billing]", flags)
+	}
+
+	if err := p.ReloadRules(); !errors.Is(err, ErrNoConfigFile) {
+		t.Errorf("ReloadRules() without a config file error = %v; want ErrNoConfigFile", err)
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	srv := imaptest.New(t,
+		imaptest.Message{Subject: "Your invoice", From: "billing@example.com"},
+	)
+	p, account := newTestPoller(t, srv)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- p.Start(ctx)
+	}()
+	waitFor(t, "primary to run", func() bool {
+		s, err := p.Account(account.ID)
+		return err == nil && s.Running && !s.LastSync.IsZero()
+	})
+
+	// Swap the account for a new one and add a rule the new account's
+	// first poll applies
+	cfg := config.DefaultConfig()
+	second := account
+	second.ID = "second"
+	cfg.EmailAccounts = []config.EmailAccount{second}
+	cfg.Poll.Rules = append(cfg.Poll.Rules, config.Rule{SubjectContains: "invoice", Label: "billing"})
+	if err := p.ApplyConfig(cfg); err != nil {
+		t.Fatalf("ApplyConfig() error = %v", err)
+	}
+	if _, err := p.Account(account.ID); !errors.Is(err, ErrUnknownAccount) {
+		t.Errorf("Account(%s) after removal error = %v; want ErrUnknownAccount", account.ID, err)
+	}
+	waitFor(t, "the invoice to be labeled", func() bool {
+		flags := srv.Flags("INBOX", 1)
+		return len(flags) == 1 && flags[0] == "billing"
+	})
+
+	// An invalid config changes nothing
+	bad := *cfg
+	bad.EmailAccounts = []config.EmailAccount{account}
+	bad.Poll.Rules = []config.Rule{{SubjectContains: "invoice", Action: "label"}}
+	if err := p.ApplyConfig(&bad); err == nil {
+		t.Error("ApplyConfig() with a label rule without a label error = nil")
+	}
+	if s := p.Accounts(); len(s) != 1 || s[0].ID != "second" || !s[0].Running {
+		t.Errorf("Accounts() after a failed reload = %+v; want second running", s)
+	}
+
+	disabled := *cfg
+	disabled.EmailAccounts = []config.EmailAccount{second}
+	disabled.EmailAccounts[0].Enabled = false
+	if err := p.ApplyConfig(&disabled); err != nil {
+		t.Fatalf("ApplyConfig() disabling the account error = %v", err)
+	}
+	if s, err := p.Account("second"); err != nil || s.Running || s.Enabled || s.Connected {
+		t.Errorf("Account(second) after disabling = %+v, %v; want stopped", s, err)
+	}
+
+	p.Stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Start() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start() did not return after Stop()")
+	}
+}
+
+func TestStopAndRestart(t *testing.T) {
+	srv := imaptest.New(t,
+		imaptest.Message{Subject: "Job opportunity at Example Corp", From: "jobs@example.com"},
+	)
+	p, account := newTestPoller(t, srv)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := func() chan error {
+		done := make(chan error, 1)
+		go func() {
+			done <- p.Start(ctx)
+		}()
+		return done
+	}
+	stop := func(done chan error) {
+		t.Helper()
+		p.Stop()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Start() error = %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Start() did not return after Stop()")
+		}
+		if s, err := p.Account(account.ID); err != nil || s.Running {
+			t.Errorf("Account() after Stop() = %+v, %v; want stopped", s, err)
+		}
+	}
+
+	done := start()
+	waitFor(t, "the first run to label the message", func() bool {
+		return len(srv.Flags("INBOX", 1)) == 1
+	})
+	if err := p.Start(ctx); !errors.Is(err, ErrAlreadyRunning) {
+		t.Errorf("second Start() error = %v; want ErrAlreadyRunning", err)
+	}
+	stop(done)
+
+	// The restarted poller picks up mail that arrived while it was stopped
+	srv.Append("INBOX", imaptest.Message{Subject: "Another job opportunity", From: "jobs@example.com"})
+	done = start()
+	waitFor(t, "the second run to label the new message", func() bool {
+		flags := srv.Flags("INBOX", 2)
+		return len(flags) == 1 && flags[0] == "imp"
+	})
+	stop(done)
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
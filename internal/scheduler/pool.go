@@ -0,0 +1,287 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/metrics"
+	"github.com/mshan/go-tsk/internal/retry"
+)
+
+// Defaults of Poll.Connections
+const (
+	defaultCheckAfter  = time.Minute
+	defaultDialBackoff = time.Second
+)
+
+// errPoolClosed is returned when taking a connection of an account being
+// stopped
+var errPoolClosed = errors.New("connection pool closed")
+
+// connPool holds the provider connections of an account. Polls and message
+// lookups take a connection and put it back when done; at most MaxOpen are
+// in use at once and further callers wait. A connection unused for
+// CheckAfter is checked with NOOP before reuse and replaced if it died.
+type connPool struct {
+	accountID string
+	cfg       config.ConnectionConfig
+	dial      func(ctx context.Context) (email.Provider, error)
+	now       func() time.Time
+	slots     chan struct{} // One per connection in use
+
+	mu     sync.Mutex
+	idle   []pooledConn // Most recently used last
+	open   int          // Idle and in use
+	closed bool
+}
+
+// pooledConn is an idle connection of a pool
+type pooledConn struct {
+	client   email.Provider
+	lastUsed time.Time
+}
+
+// newConnPool creates an empty pool that opens connections with dial
+func newConnPool(accountID string, cfg config.ConnectionConfig, dial func(ctx context.Context) (email.Provider, error)) *connPool {
+	maxOpen := cfg.MaxOpen
+	if maxOpen <= 0 {
+		maxOpen = 1
+	}
+	return &connPool{
+		accountID: accountID,
+		cfg:       cfg,
+		dial:      dial,
+		now:       time.Now,
+		slots:     make(chan struct{}, maxOpen),
+	}
+}
+
+// pool returns the connection pool of an account, creating it on first
+// use. Its connections share the account's limits.
+func (p *EmailPoller) pool(account config.EmailAccount) *connPool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	state := p.accountState[account.ID]
+	if state.pool == nil {
+		throttle := newThrottler(account)
+		state.pool = newConnPool(account.ID, p.config.Poll.Connections, func(ctx context.Context) (email.Provider, error) {
+			return p.connectWith(ctx, account, throttle)
+		})
+	}
+	return state.pool
+}
+
+// closePool closes the connections of an account, so its next poll opens
+// new ones
+func (p *EmailPoller) closePool(accountID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if state := p.accountState[accountID]; state != nil {
+		closePoolLocked(accountID, state)
+	}
+}
+
+// closePoolLocked closes the connection pool of an account; p.mu must be
+// held
+func closePoolLocked(accountID string, state *AccountState) {
+	if state.pool == nil {
+		return
+	}
+	if err := state.pool.close(); err != nil {
+		log.Printf("Error closing email client for account %s: %v", accountID, err)
+	}
+	state.pool = nil
+}
+
+// get takes a connection, reusing an idle one that is still alive or
+// opening a new one, and waits while MaxOpen are in use. The connection
+// must be given back with put.
+func (c *connPool) get(ctx context.Context) (email.Provider, error) {
+	select {
+	case c.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	for {
+		conn, ok, err := c.takeIdle()
+		if err != nil {
+			<-c.slots
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		if c.alive(ctx, conn) {
+			return conn.client, nil
+		}
+	}
+
+	client, err := c.dialWithRetry(ctx)
+	if err != nil {
+		<-c.slots
+		return nil, err
+	}
+	c.mu.Lock()
+	c.open++
+	open := c.open
+	c.mu.Unlock()
+	metrics.Set(c.accountID, "connections_open", int64(open))
+	return client, nil
+}
+
+// takeIdle removes the most recently used idle connection, if there is one
+func (c *connPool) takeIdle() (pooledConn, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return pooledConn{}, false, errPoolClosed
+	}
+	n := len(c.idle)
+	if n == 0 {
+		return pooledConn{}, false, nil
+	}
+	conn := c.idle[n-1]
+	c.idle = c.idle[:n-1]
+	return conn, true, nil
+}
+
+// alive reports whether an idle connection may be reused, closing it if
+// not: it must not have idled longer than MaxIdle and, after CheckAfter,
+// must answer a ping
+func (c *connPool) alive(ctx context.Context, conn pooledConn) bool {
+	idle := c.now().Sub(conn.lastUsed)
+	if c.cfg.MaxIdle > 0 && idle >= c.cfg.MaxIdle {
+		c.discard(conn.client)
+		return false
+	}
+	checkAfter := c.cfg.CheckAfter
+	if checkAfter <= 0 {
+		checkAfter = defaultCheckAfter
+	}
+	pinger, ok := conn.client.(email.Pinger)
+	if !ok || idle < checkAfter {
+		return true
+	}
+	if err := pinger.Ping(ctx); err != nil {
+		log.Printf("Connection of account %s failed its check, replacing it: %v", c.accountID, err)
+		metrics.Add(c.accountID, "connections_dead", 1)
+		c.discard(conn.client)
+		return false
+	}
+	return true
+}
+
+// dialWithRetry opens a connection, trying up to DialAttempts times with
+// a doubling delay. Rejected logins are not retried.
+func (c *connPool) dialWithRetry(ctx context.Context) (email.Provider, error) {
+	delay := c.cfg.DialBackoff
+	if delay <= 0 {
+		delay = defaultDialBackoff
+	}
+	policy := retry.Policy{
+		Attempts: c.cfg.DialAttempts,
+		Delay:    delay,
+		Retryable: func(err error) bool {
+			return !errors.As(err, &authError{})
+		},
+		OnRetry: func(attempt int, delay time.Duration, err error) {
+			log.Printf("Connecting account %s failed (attempt %d of %d), retrying in %s: %v",
+				c.accountID, attempt, c.cfg.DialAttempts, delay, err)
+		},
+	}
+	var client email.Provider
+	err := policy.Do(ctx, func(ctx context.Context, attempt int) error {
+		var err error
+		client, err = c.dial(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	metrics.Add(c.accountID, "connections_dialed", 1)
+	return client, nil
+}
+
+// put gives back a connection taken with get. Once the pool is closed, the
+// connection is closed instead.
+func (c *connPool) put(client email.Provider) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		c.discard(client)
+		<-c.slots
+		return
+	}
+	c.idle = append(c.idle, pooledConn{client: client, lastUsed: c.now()})
+	c.mu.Unlock()
+	<-c.slots
+
+	if c.cfg.MaxIdle > 0 {
+		time.AfterFunc(c.cfg.MaxIdle, c.reap)
+	}
+}
+
+// reap closes the idle connections unused for MaxIdle
+func (c *connPool) reap() {
+	c.mu.Lock()
+	var expired []email.Provider
+	kept := c.idle[:0]
+	for _, conn := range c.idle {
+		if c.now().Sub(conn.lastUsed) >= c.cfg.MaxIdle {
+			expired = append(expired, conn.client)
+		} else {
+			kept = append(kept, conn)
+		}
+	}
+	c.idle = kept
+	c.mu.Unlock()
+
+	for _, client := range expired {
+		c.discard(client)
+	}
+}
+
+// discard closes a connection taken out of the pool
+func (c *connPool) discard(client email.Provider) {
+	if err := client.Close(); err != nil {
+		log.Printf("Error closing connection of account %s: %v", c.accountID, err)
+	}
+	c.mu.Lock()
+	c.open--
+	open := c.open
+	c.mu.Unlock()
+	metrics.Set(c.accountID, "connections_open", int64(open))
+}
+
+// connected reports whether the pool has an open connection
+func (c *connPool) connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.open > 0
+}
+
+// close closes the idle connections and makes put close the ones in use
+func (c *connPool) close() error {
+	c.mu.Lock()
+	c.closed = true
+	idle := c.idle
+	c.idle = nil
+	c.open -= len(idle)
+	open := c.open
+	c.mu.Unlock()
+
+	var firstErr error
+	for _, conn := range idle {
+		if err := conn.client.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	metrics.Set(c.accountID, "connections_open", int64(open))
+	return firstErr
+}
@@ -0,0 +1,137 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+// pingProvider is a connection whose liveness checks fail once dead is set
+type pingProvider struct {
+	email.Provider
+	dead   bool
+	closed bool
+}
+
+func (c *pingProvider) Ping(ctx context.Context) error {
+	if c.dead {
+		return errors.New("connection reset")
+	}
+	return nil
+}
+
+func (c *pingProvider) Close() error {
+	c.closed = true
+	return nil
+}
+
+// newTestPool returns a pool dialing pingProviders, the dialed ones and a
+// clock the test advances
+func newTestPool(cfg config.ConnectionConfig) (*connPool, *[]*pingProvider, *time.Time) {
+	var dialed []*pingProvider
+	now := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	pool := newConnPool("primary", cfg, func(ctx context.Context) (email.Provider, error) {
+		c := &pingProvider{}
+		dialed = append(dialed, c)
+		return c, nil
+	})
+	pool.now = func() time.Time { return now }
+	return pool, &dialed, &now
+}
+
+func TestConnPoolReuse(t *testing.T) {
+	pool, dialed, now := newTestPool(config.ConnectionConfig{CheckAfter: time.Minute, MaxIdle: time.Hour})
+	ctx := context.Background()
+
+	first, err := pool.get(ctx)
+	if err != nil {
+		t.Fatalf("get() error = %v", err)
+	}
+	pool.put(first)
+	if again, _ := pool.get(ctx); again != first {
+		t.Error("get() did not reuse the idle connection")
+	} else {
+		pool.put(again)
+	}
+
+	// A connection that fails its check is replaced
+	*now = now.Add(2 * time.Minute)
+	first.(*pingProvider).dead = true
+	second, err := pool.get(ctx)
+	if err != nil {
+		t.Fatalf("get() error = %v", err)
+	}
+	if second == first || !first.(*pingProvider).closed {
+		t.Error("get() reused a dead connection")
+	}
+	pool.put(second)
+
+	// One idle past MaxIdle is closed rather than reused
+	*now = now.Add(2 * time.Hour)
+	third, _ := pool.get(ctx)
+	if third == second || !second.(*pingProvider).closed {
+		t.Error("get() reused a connection idle past MaxIdle")
+	}
+	pool.put(third)
+	if len(*dialed) != 3 {
+		t.Errorf("dialed %d connections; want 3", len(*dialed))
+	}
+
+	if err := pool.close(); err != nil || !third.(*pingProvider).closed || pool.connected() {
+		t.Errorf("close() = %v; want the idle connection closed", err)
+	}
+	if _, err := pool.get(ctx); !errors.Is(err, errPoolClosed) {
+		t.Errorf("get() after close error = %v; want errPoolClosed", err)
+	}
+}
+
+func TestConnPoolMaxOpen(t *testing.T) {
+	pool, _, _ := newTestPool(config.ConnectionConfig{MaxOpen: 1})
+	client, err := pool.get(context.Background())
+	if err != nil {
+		t.Fatalf("get() error = %v", err)
+	}
+
+	// A second caller waits for the connection in use
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := pool.get(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("get() beyond MaxOpen error = %v; want it to wait until the deadline", err)
+	}
+	pool.put(client)
+	if again, err := pool.get(context.Background()); err != nil || again != client {
+		t.Errorf("get() after put = %v, %v; want the connection back", again, err)
+	}
+}
+
+func TestConnPoolDialRetry(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialErr error
+		wantErr bool
+		dials   int
+	}{
+		{"transient failure", errors.New("connection refused"), false, 2},
+		{"rejected login", authError{errors.New("invalid credentials")}, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dials := 0
+			pool := newConnPool("primary", config.ConnectionConfig{DialAttempts: 3, DialBackoff: time.Millisecond},
+				func(ctx context.Context) (email.Provider, error) {
+					if dials++; dials == 1 {
+						return nil, tt.dialErr
+					}
+					return &pingProvider{}, nil
+				})
+			_, err := pool.get(context.Background())
+			if (err != nil) != tt.wantErr || dials != tt.dials {
+				t.Errorf("get() error = %v after %d dials; want error %v after %d", err, dials, tt.wantErr, tt.dials)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/integrations"
+	"github.com/mshan/go-tsk/internal/logging"
+	"github.com/mshan/go-tsk/internal/metrics"
+)
+
+// Default notification templates used when an ntfy, pushover or
+// notify-desktop rule leaves them unset
+const (
+	defaultPushTitle = "{{.Subject}}"
+	defaultPushBody  = "From: {{.From}}"
+)
+
+// sendPush sends the phone push notification of ntfy or pushover rule i for
+// a matched message
+func (p *EmailPoller) sendPush(ctx context.Context, account config.EmailAccount, i int, rule config.Rule, msg *email.Email) error {
+	tmpl, ok := p.templates[templateKey{i, rule.Action}]
+	if !ok {
+		return fmt.Errorf("rule %d has no compiled templates", i)
+	}
+	push := integrations.PushMessage{Priority: rule.PushPriority}
+	var err error
+	if push.Title, err = tmpl.title.Render(msg); err != nil {
+		return fmt.Errorf("failed to render push title: %w", err)
+	}
+	if push.Message, err = tmpl.body.Render(msg); err != nil {
+		return fmt.Errorf("failed to render push message: %w", err)
+	}
+
+	switch rule.Action {
+	case "ntfy":
+		err = p.ntfy.Publish(ctx, rule.NtfyTopic, push)
+	case "pushover":
+		if p.pushover == nil {
+			return fmt.Errorf("no Pushover keys configured")
+		}
+		err = p.pushover.Send(ctx, push)
+	default:
+		return fmt.Errorf("rule %d: %q is not a push action", i, rule.Action)
+	}
+	if err != nil {
+		return err
+	}
+	metrics.Add(account.ID, "pushes_sent", 1)
+	log.Printf("Sent %s push for email with subject %q", rule.Action, logging.Subject(msg.Subject))
+	return nil
+}
@@ -0,0 +1,253 @@
+package scheduler
+
+import (
+	"errors"
+	"log"
+	"reflect"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/metrics"
+	"github.com/mshan/go-tsk/internal/rules"
+	"github.com/mshan/go-tsk/internal/scripting"
+)
+
+// ruleSet is a list of rules with its compiled conditions, scripts,
+// templates and webhooks
+type ruleSet struct {
+	rules      []config.Rule
+	conditions map[int]*rules.Condition
+	scripts    map[int]*scripting.Script
+	templates  map[templateKey]actionTemplates
+	webhooks   map[int]ruleWebhook
+}
+
+// compileRules validates and compiles rules to be used alongside
+// accounts. The integrations they are checked against are the running
+// ones, as those are not reloaded. p.reloadMu must be held.
+func (p *EmailPoller) compileRules(accounts []config.EmailAccount, ruleList []config.Rule) (*ruleSet, error) {
+	check := *p.config
+	check.EmailAccounts = accounts
+	check.Poll.Rules = ruleList
+	if err := check.Validate(); err != nil {
+		return nil, err
+	}
+
+	set := &ruleSet{rules: ruleList}
+	var err error
+	if set.conditions, err = compileConditions(ruleList); err != nil {
+		return nil, err
+	}
+	if set.scripts, err = loadScripts(ruleList, p.config.Poll.ScriptLimits); err != nil {
+		return nil, err
+	}
+	if err := checkPluginRules(ruleList, p.plugins); err != nil {
+		return nil, err
+	}
+	if set.templates, err = compileActionTemplates(ruleList); err != nil {
+		return nil, err
+	}
+	if set.webhooks, err = compileWebhooks(ruleList); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// swapRules replaces the rules applied to new mail. Polls processing mail
+// finish with the old rules first.
+func (p *EmailPoller) swapRules(set *ruleSet) {
+	p.rulesMu.Lock()
+	defer p.rulesMu.Unlock()
+	p.config.Poll.Rules = set.rules
+	p.conditions = set.conditions
+	p.scripts = set.scripts
+	p.templates = set.templates
+	p.webhooks = set.webhooks
+	// Budgets are tracked by rule index, which no longer means the same rule
+	p.budget = newRuleBudget(p.config.Poll.RuleBudget)
+	metrics.ResetRules()
+	log.Printf("Loaded %d rules", len(set.rules))
+}
+
+// Reload re-reads the config file set with WithConfigPath and applies its
+// accounts and rules with ApplyConfig
+func (p *EmailPoller) Reload() error {
+	if p.configPath == "" {
+		return ErrNoConfigFile
+	}
+	cfg, err := p.loadConfig(p.configPath)
+	if err != nil {
+		return err
+	}
+	return p.ApplyConfig(cfg)
+}
+
+// ApplyConfig brings the accounts and rules of the poller in line with
+// cfg. Removed and disabled accounts are stopped after their in-flight
+// poll, added and enabled ones are started, and changed ones are
+// restarted, keeping their cursors. The rule set is swapped in one step.
+// Other settings take effect on restart. An invalid cfg changes nothing.
+func (p *EmailPoller) ApplyConfig(cfg *config.Config) error {
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+
+	set, err := p.compileRules(cfg.EmailAccounts, cfg.Poll.Rules)
+	if err != nil {
+		return err
+	}
+	rulesChanged := !reflect.DeepEqual(cfg.Poll.Rules, p.config.Poll.Rules)
+
+	p.mu.RLock()
+	previous := make(map[string]config.EmailAccount, len(p.config.EmailAccounts))
+	for _, account := range p.config.EmailAccounts {
+		previous[account.ID] = account
+	}
+	p.mu.RUnlock()
+
+	// Diff the accounts, loading the state of new ones before anything
+	// changes
+	added := make(map[string]*AccountState)
+	var stop, remove []string
+	var start []config.EmailAccount
+	for _, account := range cfg.EmailAccounts {
+		prev, ok := previous[account.ID]
+		delete(previous, account.ID)
+		switch {
+		case !ok:
+			state, err := loadAccountState(p.config, p.store, account.ID)
+			if err != nil {
+				return err
+			}
+			added[account.ID] = state
+		case reflect.DeepEqual(prev, account):
+			continue
+		default:
+			stop = append(stop, account.ID)
+		}
+		if account.Enabled {
+			start = append(start, account)
+		}
+	}
+	for id := range previous {
+		stop = append(stop, id)
+		remove = append(remove, id)
+	}
+
+	if rulesChanged {
+		p.swapRules(set)
+	}
+
+	p.mu.Lock()
+	for id, state := range added {
+		p.accountState[id] = state
+	}
+	p.config.EmailAccounts = cfg.EmailAccounts
+	p.mu.Unlock()
+
+	for _, id := range stop {
+		p.stopAccount(id)
+	}
+	p.mu.Lock()
+	for _, id := range remove {
+		delete(p.accountState, id)
+	}
+	p.mu.Unlock()
+	for _, account := range start {
+		p.startAccount(account)
+	}
+
+	if otherSettingsChanged(p.config, cfg) {
+		log.Printf("Config settings other than accounts and rules take effect on restart")
+	}
+	log.Printf("Config reloaded: %d accounts added, %d removed, %d changed",
+		len(added), len(remove), len(stop)-len(remove))
+	return nil
+}
+
+// otherSettingsChanged reports whether two configs differ in anything but
+// their accounts and rules
+func otherSettingsChanged(a, b *config.Config) bool {
+	x, y := *a, *b
+	x.EmailAccounts, y.EmailAccounts = nil, nil
+	x.Poll.Rules, y.Poll.Rules = nil, nil
+	return !reflect.DeepEqual(x, y)
+}
+
+// startAccount starts supervising an account if the poller is running and
+// the account is not supervised already
+func (p *EmailPoller) startAccount(account config.EmailAccount) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	run := p.run
+	state := p.accountState[account.ID]
+	if run == nil || run.ctx == nil || run.stopping || state == nil || state.done != nil {
+		return
+	}
+	if state.stopped {
+		state.stopChan = make(chan struct{})
+		state.stopped = false
+	}
+	ctx, stop := run.ctx, state.stopChan
+	if !run.launched {
+		run.pending[account.ID] = true
+	}
+	done := make(chan struct{})
+	state.done = done
+
+	run.supervisors.Add(1)
+	go func() {
+		defer run.supervisors.Done()
+		defer func() {
+			// An account that stops before its first poll doesn't hold up
+			// readiness
+			p.firstPollDone(run, account.ID)
+
+			// The account may be started again, e.g. by the next run
+			p.mu.Lock()
+			if state.done == done {
+				state.done = nil
+			}
+			p.mu.Unlock()
+			close(done)
+		}()
+		if err := p.supervise(ctx, account, run, stop); err != nil {
+			var accErr AccountError
+			if errors.As(err, &accErr) {
+				p.mu.Lock()
+				p.startErrs = append(p.startErrs, accErr)
+				p.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// stopAccount stops supervising an account, waiting for its in-flight
+// poll, then persists its state and logs out
+func (p *EmailPoller) stopAccount(accountID string) {
+	p.mu.Lock()
+	state := p.accountState[accountID]
+	if state == nil {
+		p.mu.Unlock()
+		return
+	}
+	state.stop()
+	done := state.done
+	p.mu.Unlock()
+
+	if done != nil {
+		<-done
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	state.done = nil
+	p.release(accountID, state)
+}
+
+// stop tells the account's supervisor to stop; p.mu must be held
+func (s *AccountState) stop() {
+	if !s.stopped {
+		close(s.stopChan)
+		s.stopped = true
+	}
+}
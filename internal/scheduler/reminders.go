@@ -0,0 +1,112 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/actions"
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/logging"
+	"github.com/mshan/go-tsk/internal/metrics"
+	"github.com/mshan/go-tsk/internal/notify"
+	"github.com/mshan/go-tsk/internal/store"
+)
+
+// remindAction waits for an answer to a message. Unless one arrives within
+// the rule's RemindAfter, its RemindWith follow-up runs.
+func (p *EmailPoller) remindAction(ctx context.Context, msg *email.Email, a actions.Params) error {
+	if p.store == nil {
+		return fmt.Errorf("no state store configured")
+	}
+	// Answers name the message they answer by its Message-ID
+	if strings.TrimSpace(msg.MessageID) == "" {
+		return fmt.Errorf("cannot await an answer to a message without a Message-ID")
+	}
+
+	r := store.Reminder{
+		AccountID: a.Account.ID,
+		Key:       a.Key,
+		Rule:      a.Rule,
+		Email:     *msg,
+		Due:       time.Now().Add(a.Rule.RemindAfter),
+	}
+	created, err := p.store.CreateReminder(&r)
+	if err != nil {
+		return fmt.Errorf("failed to save reminder: %w", err)
+	}
+	if created {
+		metrics.Add(a.Account.ID, "reminders_scheduled", 1)
+		log.Printf("Awaiting an answer to email with subject %s until %s", logging.Subject(msg.Subject), r.Due.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// cancelAnswered drops the reminders of the messages that fetched mail
+// answers. The account's own mail, such as a nudge sent in the same
+// thread, is not an answer.
+func (p *EmailPoller) cancelAnswered(account config.EmailAccount, emails []*email.Email) {
+	if p.store == nil {
+		return
+	}
+	for _, msg := range emails {
+		if len(msg.References) == 0 || fromAccount(account, msg) {
+			continue
+		}
+		n, err := p.store.CancelReminders(account.ID, msg.References)
+		if err != nil {
+			log.Printf("Failed to cancel reminders of account %s: %v", account.ID, err)
+			continue
+		}
+		if n > 0 {
+			metrics.Add(account.ID, "reminders_cancelled", n)
+			log.Printf("Email with subject %s answers %d awaited message(s); cancelled their reminders", logging.Subject(msg.Subject), n)
+		}
+	}
+}
+
+// fromAccount reports whether a message was sent from the account's own
+// address
+func fromAccount(account config.EmailAccount, msg *email.Email) bool {
+	return account.Address != "" && strings.Contains(strings.ToLower(msg.From), strings.ToLower(account.Address))
+}
+
+// sendDueReminders runs the follow-ups of an account's reminders that came
+// due without an answer. A follow-up that fails is retried on the next
+// poll.
+func (p *EmailPoller) sendDueReminders(ctx context.Context, account config.EmailAccount) {
+	if p.store == nil {
+		return
+	}
+	due, err := p.store.DueReminders(account.ID, time.Now())
+	if err != nil {
+		log.Printf("Failed to load reminders of account %s: %v", account.ID, err)
+		return
+	}
+	for _, r := range due {
+		if err := p.remind(ctx, account, r); err != nil {
+			log.Printf("Failed to remind of unanswered message %s of account %s: %v", r.Email.MessageID, account.ID, err)
+			continue
+		}
+		metrics.Add(account.ID, "reminders_sent", 1)
+		if err := p.store.DeleteReminder(r.ID); err != nil {
+			log.Printf("Failed to discard reminder %d: %v", r.ID, err)
+		}
+	}
+}
+
+// remind runs a reminder's follow-up: a notification or a task
+func (p *EmailPoller) remind(ctx context.Context, account config.EmailAccount, r store.Reminder) error {
+	msg := r.Email
+	if r.Rule.RemindWith == "create-task" {
+		msg.Subject = "Follow up: " + msg.Subject
+		// The message may have a task of its own already
+		return p.createTask(ctx, account, r.Rule, &msg, "reminder:"+r.Key)
+	}
+	entry := newEntry(account, r.Rule, &msg)
+	entry.Rule = fmt.Sprintf("no answer within %s", r.Rule.RemindAfter)
+	return p.notifier.Send(ctx, notify.Digest{Account: account.Name, Entries: []notify.Entry{entry}})
+}
@@ -0,0 +1,48 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/actions"
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+func TestFromAccount(t *testing.T) {
+	account := config.EmailAccount{ID: "primary", Address: "Me@example.com"}
+	tests := []struct {
+		from string
+		want bool
+	}{
+		{"me@example.com", true},
+		{"Me <ME@EXAMPLE.COM>", true},
+		{"Client <client@example.com>", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := fromAccount(account, &email.Email{From: tt.from}); got != tt.want {
+			t.Errorf("fromAccount(%q) = %v; want %v", tt.from, got, tt.want)
+		}
+	}
+	if fromAccount(config.EmailAccount{ID: "local"}, &email.Email{From: "me@example.com"}) {
+		t.Error("fromAccount() without an address = true")
+	}
+}
+
+func TestRemindActionErrors(t *testing.T) {
+	p := &EmailPoller{}
+	params := actions.Params{
+		Account: config.EmailAccount{ID: "primary"},
+		Rule:    config.Rule{Action: "remind-if-no-reply", RemindAfter: 72 * time.Hour},
+		Key:     "mid:<1@example.com>",
+	}
+	msg := &email.Email{Mailbox: "Sent", UID: 1, MessageID: "<1@example.com>"}
+	if err := p.remindAction(context.Background(), msg, params); err == nil {
+		t.Error("remindAction() without a store succeeded")
+	}
+	// Without a store, answers are not tracked
+	p.cancelAnswered(params.Account, []*email.Email{{References: []string{"<1@example.com>"}}})
+	p.sendDueReminders(context.Background(), params.Account)
+}
@@ -0,0 +1,199 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/notify"
+	"github.com/mshan/go-tsk/internal/rules"
+	"github.com/mshan/go-tsk/internal/store"
+)
+
+// ReplayOptions controls a replay of the rules against past mail
+type ReplayOptions struct {
+	// Query selects the archived messages to replay, newest first
+	Query store.ArchiveQuery
+	// Fetch replays the mail of one mailbox of Query.AccountID, fetched
+	// from the provider, instead of the archive. Only Query.Since narrows
+	// it.
+	Fetch bool
+	// Mailbox is the mailbox Fetch reads; empty means INBOX
+	Mailbox string
+	// BatchSize is the number of messages fetched at a time; 0 uses 200
+	BatchSize int
+	// Rules lists the indices of the rules to replay; empty replays all
+	Rules []int
+	// Apply runs the actions of matching rules rather than only
+	// reporting the matches
+	Apply bool
+}
+
+// defaultReplayBatch is the number of messages a replay fetches at a time
+// unless ReplayOptions.BatchSize says otherwise
+const defaultReplayBatch = 200
+
+// ReplayMatch is a rule that matched a message in a replay
+type ReplayMatch struct {
+	Message   store.ArchivedMessage
+	RuleIndex int
+	Rule      string // Description of the rule, as in Match
+	Action    string
+	Failed    bool // In apply mode, whether an action failed
+}
+
+// Replay runs the current rules against past mail, from the archive or
+// freshly fetched, and calls fn for every rule that matches. Only in apply
+// mode do the rules' actions run, so a new rule can organize existing
+// mail. Archived messages of accounts no longer configured are skipped.
+// It returns how many messages it checked.
+func (p *EmailPoller) Replay(ctx context.Context, opts ReplayOptions, fn func(ReplayMatch)) (int, error) {
+	for _, i := range opts.Rules {
+		if i < 0 || i >= len(p.config.Poll.Rules) {
+			return 0, fmt.Errorf("no rule %d", i)
+		}
+	}
+	if opts.Fetch {
+		return p.replayFetched(ctx, opts, fn)
+	}
+
+	messages, err := p.History(opts.Query)
+	if err != nil {
+		return 0, err
+	}
+	p.addressLists.refresh(ctx)
+
+	clients := make(map[string]email.Provider)
+	defer func() {
+		for _, client := range clients {
+			client.Close()
+		}
+	}()
+	matched := make(map[string][]notify.Entry)
+	checked := 0
+	for _, m := range messages {
+		if err := ctx.Err(); err != nil {
+			return checked, err
+		}
+		account, ok := p.account(m.AccountID)
+		if !ok {
+			continue
+		}
+		client := clients[account.ID]
+		if opts.Apply && client == nil {
+			if client, err = p.connect(ctx, account); err != nil {
+				return checked, fmt.Errorf("account %s: %w", account.ID, err)
+			}
+			clients[account.ID] = client
+		}
+		msg := m.Email
+		matched[account.ID] = append(matched[account.ID], p.replayMessage(ctx, account, client, m.Key, &msg, opts, fn)...)
+		checked++
+	}
+	for id, entries := range matched {
+		if account, ok := p.account(id); ok && len(entries) > 0 {
+			p.sendDigest(ctx, account, entries)
+		}
+	}
+	return checked, nil
+}
+
+// replayFetched replays the mail of one mailbox fetched from the provider,
+// oldest first
+func (p *EmailPoller) replayFetched(ctx context.Context, opts ReplayOptions, fn func(ReplayMatch)) (int, error) {
+	account, ok := p.account(opts.Query.AccountID)
+	if !ok {
+		return 0, ErrUnknownAccount
+	}
+	mailbox := opts.Mailbox
+	if mailbox == "" {
+		mailbox = email.Inbox
+	}
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultReplayBatch
+	}
+
+	client, err := p.connect(ctx, account)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+	status, err := client.Status(ctx, mailbox)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get mailbox status: %w", err)
+	}
+	p.addressLists.refresh(ctx)
+
+	var matched []notify.Entry
+	checked := 0
+	var lastUID uint32
+	for {
+		emails, err := client.FetchBatch(ctx, mailbox, lastUID, batchSize)
+		if err != nil {
+			return checked, fmt.Errorf("failed to fetch batch after UID %d: %w", lastUID, err)
+		}
+		if len(emails) == 0 {
+			break
+		}
+		for _, msg := range emails {
+			if msg.UID > lastUID {
+				lastUID = msg.UID
+			}
+			if since := opts.Query.Since; !since.IsZero() && msg.Date.Before(since) {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return checked, err
+			}
+			matched = append(matched, p.replayMessage(ctx, account, client, msg.Key(status.UIDValidity), msg, opts, fn)...)
+			checked++
+		}
+	}
+	if len(matched) > 0 {
+		p.sendDigest(ctx, account, matched)
+	}
+	return checked, nil
+}
+
+// replayMessage runs the selected rules against one message, reporting
+// each match to fn, and returns the notify matches of apply mode
+func (p *EmailPoller) replayMessage(ctx context.Context, account config.EmailAccount, client email.Provider, key string, msg *email.Email, opts ReplayOptions, fn func(ReplayMatch)) []notify.Entry {
+	p.rulesMu.RLock()
+	defer p.rulesMu.RUnlock()
+
+	var matched []notify.Entry
+	for i, rule := range p.config.Poll.Rules {
+		if !replayed(opts.Rules, i) || !p.matches(ctx, account, i, rule, msg) || !rules.Sampled(rule, msg, key) {
+			continue
+		}
+		failed := false
+		if opts.Apply {
+			var entries []notify.Entry
+			entries, failed = p.applyRule(ctx, account, client, i, rule, key, msg)
+			matched = append(matched, entries...)
+		}
+		fn(ReplayMatch{
+			Message:   store.ArchivedMessage{AccountID: account.ID, Key: key, Email: *msg},
+			RuleIndex: i,
+			Rule:      rules.Describe(rule),
+			Action:    ruleAction(rule),
+			Failed:    failed,
+		})
+	}
+	return matched
+}
+
+// replayed reports whether rule i is among the rules a replay runs
+func replayed(indices []int, i int) bool {
+	if len(indices) == 0 {
+		return true
+	}
+	for _, j := range indices {
+		if j == i {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/imaptest"
+	"github.com/mshan/go-tsk/internal/store"
+)
+
+func TestReplayFetched(t *testing.T) {
+	now := time.Now()
+	srv := imaptest.New(t,
+		imaptest.Message{Subject: "Job opportunity at Example Corp", From: "jobs@example.com", Date: now.Add(-200 * 24 * time.Hour)},
+		imaptest.Message{Subject: "Another job opportunity", From: "jobs@example.com", Date: now.Add(-time.Hour)},
+		imaptest.Message{Subject: "Weekly newsletter", From: "news@example.com", Date: now.Add(-time.Hour)},
+	)
+	p, account := newTestPoller(t, srv)
+	ctx := context.Background()
+	opts := ReplayOptions{
+		Query:     store.ArchiveQuery{AccountID: account.ID, Since: now.Add(-90 * 24 * time.Hour)},
+		Fetch:     true,
+		BatchSize: 2,
+	}
+
+	// A dry run reports the match without acting on it
+	var matches []ReplayMatch
+	checked, err := p.Replay(ctx, opts, func(m ReplayMatch) { matches = append(matches, m) })
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if checked != 2 || len(matches) != 1 || matches[0].Message.Email.UID != 2 || matches[0].Action != "label" {
+		t.Fatalf("Replay() = %d, %+v; want 2 messages checked and message 2 matched", checked, matches)
+	}
+	if flags := srv.Flags("INBOX", 2); len(flags) != 0 {
+		t.Errorf("dry run labeled message 2 %v", flags)
+	}
+
+	// Applying labels it; the message older than Since stays untouched
+	opts.Apply = true
+	if _, err := p.Replay(ctx, opts, func(m ReplayMatch) {
+		if m.Failed {
+			t.Errorf("applying rule %d failed", m.RuleIndex)
+		}
+	}); err != nil {
+		t.Fatalf("Replay() with Apply error = %v", err)
+	}
+	if flags := srv.Flags("INBOX", 2); len(flags) != 1 || flags[0] != "imp" {
+		t.Errorf("flags of message 2 = %v; want [imp]", flags)
+	}
+	if flags := srv.Flags("INBOX", 1); len(flags) != 0 {
+		t.Errorf("flags of old message 1 = %v; want none", flags)
+	}
+
+	opts.Rules = []int{len(p.config.Poll.Rules)}
+	if _, err := p.Replay(ctx, opts, func(ReplayMatch) {}); err == nil {
+		t.Error("Replay() of an unknown rule succeeded")
+	}
+}
@@ -0,0 +1,165 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/metrics"
+	"github.com/mshan/go-tsk/internal/reporting"
+	"github.com/mshan/go-tsk/internal/retry"
+)
+
+// defaultReportPollFailures is how many polls in a row must fail before
+// they are reported
+const defaultReportPollFailures = 3
+
+// authError is a login the server rejected
+type authError struct {
+	err error
+}
+
+func (e authError) Error() string { return e.err.Error() }
+func (e authError) Unwrap() error { return e.err }
+
+// reportFailure reports a failed poll, once per run of failures: at once
+// if the login was rejected, as that will not fix itself, and otherwise
+// once Errors.PollFailures polls in a row failed
+func (p *EmailPoller) reportFailure(account config.EmailAccount, attempts int, err error) {
+	threshold := p.config.Errors.PollFailures
+	if threshold <= 0 {
+		threshold = defaultReportPollFailures
+	}
+	kind := reporting.KindPollFailing
+	if errors.As(err, &authError{}) {
+		kind = reporting.KindAuthRejected
+	} else if attempts < threshold {
+		return
+	}
+
+	p.mu.Lock()
+	state := p.accountState[account.ID]
+	if state == nil || state.reported {
+		p.mu.Unlock()
+		return
+	}
+	state.reported = true
+	p.mu.Unlock()
+
+	metrics.Add(account.ID, "failures_reported", 1)
+	p.reporter.Report(reporting.Report{
+		Kind:     kind,
+		Account:  account.ID,
+		Error:    err.Error(),
+		Attempts: attempts,
+		Context:  map[string]string{"provider": account.Provider, "address": account.Address},
+	})
+}
+
+// resetReported lets the next run of failures of an account be reported
+func (p *EmailPoller) resetReported(accountID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if state := p.accountState[accountID]; state != nil {
+		state.reported = false
+	}
+}
+
+// applyActionSafely runs applyAction, turning a panic into an error that
+// is logged and reported with its stack and marks the account degraded, so
+// one bad action does not take the account down. The error is permanent: a
+// panic is not retried.
+func (p *EmailPoller) applyActionSafely(ctx context.Context, account config.EmailAccount, client email.Provider, i int, rule config.Rule, key string, msg *email.Email) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = retry.Permanent(fmt.Errorf("action %s panicked: %v", ruleAction(rule), r))
+			stack := debug.Stack()
+			log.Printf("Recovered from panic in action %s of rule %d on email %d in %s of account %s: %v\n%s",
+				ruleAction(rule), i, msg.UID, msg.Mailbox, account.ID, r, stack)
+			metrics.Add(account.ID, "action_panics", 1)
+			p.markDegraded(account.ID)
+			p.reporter.Report(reporting.Report{
+				Kind:    reporting.KindActionPanic,
+				Account: account.ID,
+				Error:   err.Error(),
+				Context: map[string]string{
+					"rule":    strconv.Itoa(i),
+					"action":  ruleAction(rule),
+					"mailbox": msg.Mailbox,
+					"uid":     strconv.FormatUint(uint64(msg.UID), 10),
+				},
+				Stack: string(stack),
+			})
+		}
+	}()
+	return p.applyAction(ctx, account, client, i, rule, key, msg)
+}
+
+// pollSafely runs poll, turning a panic into a failed poll
+func (p *EmailPoller) pollSafely(ctx context.Context, account config.EmailAccount) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = p.accountPanicked(account, "poll", r)
+		}
+	}()
+	return p.poll(ctx, account)
+}
+
+// pollAccountSafely runs pollAccount, turning a panic into an error, so
+// the account's supervisor restarts it and the other accounts keep polling
+func (p *EmailPoller) pollAccountSafely(ctx context.Context, account config.EmailAccount, run *pollerRun, stop <-chan struct{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = p.accountPanicked(account, "polling loop", r)
+		}
+	}()
+	return p.pollAccount(ctx, account, run, stop)
+}
+
+// accountPanicked logs, counts and reports a panic recovered in one of an
+// account's goroutines, marks the account degraded and returns the panic
+// as an error. It must be called from the deferred function that
+// recovered.
+func (p *EmailPoller) accountPanicked(account config.EmailAccount, where string, r interface{}) error {
+	err := fmt.Errorf("%s panicked: %v", where, r)
+	stack := debug.Stack()
+	log.Printf("Recovered from panic in %s of account %s: %v\n%s", where, account.ID, r, stack)
+	metrics.Add(account.ID, "account_panics", 1)
+	p.markDegraded(account.ID)
+	p.reporter.Report(reporting.Report{
+		Kind:    reporting.KindAccountPanic,
+		Account: account.ID,
+		Error:   err.Error(),
+		Context: map[string]string{"provider": account.Provider, "address": account.Address, "in": where},
+		Stack:   string(stack),
+	})
+	return err
+}
+
+// markDegraded marks an account degraded after a panic, until a poll
+// started later succeeds
+func (p *EmailPoller) markDegraded(accountID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if state := p.accountState[accountID]; state != nil {
+		state.degradedAt = time.Now()
+		metrics.Set(accountID, "degraded", 1)
+	}
+}
+
+// clearDegraded clears the degraded mark of an account after a poll that
+// started at start succeeded, unless the poll panicked itself
+func (p *EmailPoller) clearDegraded(accountID string, start time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if state := p.accountState[accountID]; state != nil && !state.degradedAt.IsZero() && state.degradedAt.Before(start) {
+		state.degradedAt = time.Time{}
+		metrics.Set(accountID, "degraded", 0)
+	}
+}
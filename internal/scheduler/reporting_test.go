@@ -0,0 +1,114 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/reporting"
+)
+
+func TestReportFailure(t *testing.T) {
+	var mu sync.Mutex
+	var kinds []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var rep reporting.Report
+		json.NewDecoder(r.Body).Decode(&rep)
+		mu.Lock()
+		kinds = append(kinds, string(rep.Kind))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	cfg := &config.Config{Errors: config.ErrorReportingConfig{WebhookURL: srv.URL, PollFailures: 2}}
+	reporter, err := reporting.New(cfg.Errors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account := config.EmailAccount{ID: "primary"}
+	p := &EmailPoller{
+		config:       cfg,
+		reporter:     reporter,
+		accountState: map[string]*AccountState{"primary": {}},
+	}
+
+	fetchErr := errors.New("fetch failed")
+	p.reportFailure(account, 1, fetchErr) // Below the threshold
+	p.reportFailure(account, 2, fetchErr) // Reported
+	p.reportFailure(account, 3, fetchErr) // Same run of failures
+	p.resetReported("primary")
+	p.reportFailure(account, 1, authError{errors.New("invalid credentials")}) // Reported at once
+
+	// A panicking action is reported and turned into an error
+	p.config.Poll.Rules = []config.Rule{{Action: "label", Label: "imp"}}
+	err = p.applyActionSafely(context.Background(), account, panickingProvider{}, 0, p.config.Poll.Rules[0], "key", &email.Email{Mailbox: "INBOX", UID: 7})
+	if err == nil {
+		t.Error("applyActionSafely() of a panicking action returned nil")
+	}
+
+	if err := reporter.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"poll_failing": true, "auth_rejected": true, "action_panic": true}
+	if len(kinds) != len(want) {
+		t.Fatalf("reported %v; want one report of each of %v", kinds, want)
+	}
+	for _, kind := range kinds {
+		if !want[kind] {
+			t.Errorf("unexpected report %q", kind)
+		}
+		delete(want, kind)
+	}
+}
+
+// panickingProvider panics when a label is applied
+type panickingProvider struct {
+	email.Provider
+}
+
+func (panickingProvider) ApplyLabel(ctx context.Context, mailbox string, uid uint32, label string) error {
+	panic("boom")
+}
+
+func TestPollPanicDegradesAccount(t *testing.T) {
+	cfg := config.DefaultConfig()
+	factory := func(account config.EmailAccount) (email.Provider, error) {
+		panic("boom")
+	}
+	p, err := NewEmailPoller(cfg, nil, WithProviderFactory(factory))
+	if err != nil {
+		t.Fatalf("NewEmailPoller: %v", err)
+	}
+	t.Cleanup(p.Stop)
+	account := cfg.EmailAccounts[0]
+	state := p.state(account.ID)
+
+	// The panic fails the poll instead of the process
+	p.pollWithBackoff(context.Background(), account, state.backoff, state.circuit)
+	if state.backoff.Attempts() != 1 {
+		t.Errorf("failed polls = %d; want 1", state.backoff.Attempts())
+	}
+	status, err := p.Account(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Degraded {
+		t.Error("account not degraded after a panic")
+	}
+
+	p.clearDegraded(account.ID, time.Now().Add(-time.Hour))
+	if status, _ := p.Account(account.ID); !status.Degraded {
+		t.Error("a poll started before the panic cleared the degraded mark")
+	}
+	p.clearDegraded(account.ID, time.Now())
+	if status, _ := p.Account(account.ID); status.Degraded {
+		t.Error("a later successful poll left the account degraded")
+	}
+}
@@ -0,0 +1,71 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/store"
+)
+
+// defaultRetentionInterval is how often old state is pruned unless
+// Storage.Retention.Interval says otherwise
+const defaultRetentionInterval = 24 * time.Hour
+
+// runRetention prunes state older than Storage.Retention allows, once at
+// start and then every interval, until ctx is done or stopped is closed.
+// It does nothing without a store or without a retention age.
+func (p *EmailPoller) runRetention(ctx context.Context, stopped <-chan struct{}) {
+	r := p.config.Storage.Retention
+	if p.store == nil || (r.Archive == 0 && r.Processed == 0 && r.Events == 0) {
+		return
+	}
+	interval := r.Interval
+	if interval <= 0 {
+		interval = defaultRetentionInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		p.prune(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-stopped:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// prune deletes the state that is too old at now
+func (p *EmailPoller) prune(now time.Time) {
+	n, err := p.store.Prune(pruneCutoffs(p.config.Storage.Retention, now))
+	if err != nil {
+		log.Printf("Failed to prune old state: %v", err)
+	}
+	if n.Archive+n.Processed+n.Events > 0 {
+		log.Printf("Pruned %d archived messages, %d processed journal entries and %d event records",
+			n.Archive, n.Processed, n.Events)
+	}
+}
+
+// pruneCutoffs converts retention ages to the times before which state is
+// deleted
+func pruneCutoffs(r config.RetentionConfig, now time.Time) store.PruneCutoffs {
+	var c store.PruneCutoffs
+	for _, age := range []struct {
+		age    time.Duration
+		cutoff *time.Time
+	}{
+		{r.Archive, &c.Archive},
+		{r.Processed, &c.Processed},
+		{r.Events, &c.Events},
+	} {
+		if age.age > 0 {
+			*age.cutoff = now.Add(-age.age)
+		}
+	}
+	return c
+}
@@ -0,0 +1,37 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/store"
+)
+
+func TestPruneCutoffs(t *testing.T) {
+	now := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	got := pruneCutoffs(config.RetentionConfig{Archive: 365 * 24 * time.Hour, Events: time.Hour}, now)
+	want := store.PruneCutoffs{Archive: now.Add(-365 * 24 * time.Hour), Events: now.Add(-time.Hour)}
+	if got != want {
+		t.Errorf("pruneCutoffs() = %+v; want %+v", got, want)
+	}
+}
+
+func TestRetentionWithoutStore(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Storage.Retention.Processed = time.Hour
+	p := &EmailPoller{config: cfg}
+
+	// Without a store there is nothing to prune, so it returns at once
+	done := make(chan struct{})
+	go func() {
+		p.runRetention(context.Background(), nil)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("runRetention() without a store did not return")
+	}
+}
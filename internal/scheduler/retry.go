@@ -0,0 +1,59 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/metrics"
+	"github.com/mshan/go-tsk/internal/retry"
+)
+
+// retryPolicy returns the policy an account's fetches and actions are
+// retried with; what names the operation in the log
+func (p *EmailPoller) retryPolicy(account config.EmailAccount, what string) retry.Policy {
+	cfg := p.config.Poll.Retry
+	return retry.Policy{
+		Attempts:  cfg.Attempts,
+		Delay:     cfg.Delay,
+		MaxDelay:  cfg.MaxDelay,
+		Retryable: retryable,
+		OnRetry: func(attempt int, delay time.Duration, err error) {
+			metrics.Add(account.ID, "retries", 1)
+			log.Printf("%s for account %s failed (attempt %d of %d), retrying in %s: %v",
+				what, account.ID, attempt, cfg.Attempts, delay, err)
+		},
+	}
+}
+
+// retryable reports whether a failed fetch or action may succeed if tried
+// again
+func retryable(err error) bool {
+	return !errors.As(err, &authError{}) &&
+		!errors.Is(err, errPollLimit) &&
+		!errors.Is(err, errNoMove) &&
+		!errors.Is(err, email.ErrUIDValidityChanged)
+}
+
+// journaled reports whether an action is recorded in the processed journal
+// once applied, so that processing the message again, e.g. after a later
+// action failed, doesn't repeat it. Labels and snoozes are not: a label
+// already on the message is not applied again, and a snoozed message has
+// left the mailbox. Both are also applied in batches after the action
+// returned.
+func journaled(step config.Rule) bool {
+	switch ruleAction(step) {
+	case "label", "snooze":
+		return false
+	}
+	return true
+}
+
+// actionJournalKey identifies step j of rule, applied to the message key,
+// in the processed journal
+func actionJournalKey(key string, rule config.Rule, j int) string {
+	return fmt.Sprintf("%s#%s/%d", key, ruleKey(rule), j)
+}
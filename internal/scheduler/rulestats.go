@@ -0,0 +1,149 @@
+package scheduler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/metrics"
+	"github.com/mshan/go-tsk/internal/rules"
+	"github.com/mshan/go-tsk/internal/store"
+)
+
+// RuleStats is how often one configured rule matched and acted. Counts
+// follow the rule's settings rather than its position, so reordering
+// rules keeps them, while editing a rule starts it over.
+type RuleStats struct {
+	Index     int
+	Rule      string
+	Action    string
+	Matches   int64
+	Actions   int64     // Actions applied, counting each step of a chain
+	Errors    int64     // Failed actions and scripts
+	LastMatch time.Time // Zero if the rule never matched
+}
+
+// ruleStats counts the hits of each rule by rule key. With a store the
+// counts are loaded on start and saved after every poll.
+type ruleStats struct {
+	mu    sync.Mutex
+	stats map[string]store.RuleStats
+	dirty map[string]bool // Keys changed since the last save
+}
+
+// ruleKey identifies a rule by its settings
+func ruleKey(rule config.Rule) string {
+	// Marshaling a config.Rule cannot fail
+	data, _ := json.Marshal(rule)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
+// load restores the counts saved in st
+func (s *ruleStats) load(st store.Store) error {
+	saved, err := st.RuleStats()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats = saved
+	return nil
+}
+
+// update changes the counts of a rule
+func (s *ruleStats) update(key string, change func(*store.RuleStats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stats == nil {
+		s.stats = make(map[string]store.RuleStats)
+	}
+	if s.dirty == nil {
+		s.dirty = make(map[string]bool)
+	}
+	rs := s.stats[key]
+	change(&rs)
+	s.stats[key] = rs
+	s.dirty[key] = true
+}
+
+// get returns the counts of a rule
+func (s *ruleStats) get(key string) store.RuleStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats[key]
+}
+
+// save persists the counts changed since the last save. Counts that fail
+// to save are kept for the next attempt.
+func (s *ruleStats) save(st store.Store) {
+	if st == nil {
+		return
+	}
+	s.mu.Lock()
+	changed := make(map[string]store.RuleStats, len(s.dirty))
+	for key := range s.dirty {
+		changed[key] = s.stats[key]
+	}
+	s.dirty = nil
+	s.mu.Unlock()
+
+	for key, rs := range changed {
+		if err := st.SaveRuleStats(key, rs); err != nil {
+			log.Printf("Failed to save rule statistics: %v", err)
+			s.mu.Lock()
+			if s.dirty == nil {
+				s.dirty = make(map[string]bool)
+			}
+			s.dirty[key] = true
+			s.mu.Unlock()
+		}
+	}
+}
+
+// ruleMatched counts a match of rule i
+func (p *EmailPoller) ruleMatched(i int, rule config.Rule) {
+	now := time.Now()
+	p.ruleStats.update(ruleKey(rule), func(rs *store.RuleStats) {
+		rs.Matches++
+		rs.LastMatch = now
+	})
+	metrics.AddRule(i, "matches", 1)
+}
+
+// ruleActed counts an action rule i applied, or failed to if err is not nil
+func (p *EmailPoller) ruleActed(i int, rule config.Rule, err error) {
+	if err != nil {
+		p.ruleStats.update(ruleKey(rule), func(rs *store.RuleStats) { rs.Errors++ })
+		metrics.AddRule(i, "errors", 1)
+		return
+	}
+	p.ruleStats.update(ruleKey(rule), func(rs *store.RuleStats) { rs.Actions++ })
+	metrics.AddRule(i, "actions", 1)
+}
+
+// RuleStats returns the statistics of every configured rule, in config
+// order
+func (p *EmailPoller) RuleStats() []RuleStats {
+	p.rulesMu.RLock()
+	defer p.rulesMu.RUnlock()
+
+	out := make([]RuleStats, len(p.config.Poll.Rules))
+	for i, rule := range p.config.Poll.Rules {
+		rs := p.ruleStats.get(ruleKey(rule))
+		out[i] = RuleStats{
+			Index:     i,
+			Rule:      rules.Describe(rule),
+			Action:    ruleAction(rule),
+			Matches:   rs.Matches,
+			Actions:   rs.Actions,
+			Errors:    rs.Errors,
+			LastMatch: rs.LastMatch,
+		}
+	}
+	return out
+}
@@ -0,0 +1,71 @@
+package scheduler
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/metrics"
+)
+
+func TestRuleStats(t *testing.T) {
+	cfg := config.DefaultConfig()
+	invoice := config.Rule{
+		SubjectContains: "Invoice",
+		Label:           "Bills",
+		Actions:         []config.RuleAction{{Action: "notify"}, {Label: "Later"}},
+	}
+	outage := config.Rule{SubjectContains: "Outage", Label: "Ops"}
+	cfg.Poll.Rules = []config.Rule{invoice, outage}
+	p, err := NewEmailPoller(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewEmailPoller: %v", err)
+	}
+	t.Cleanup(p.Stop)
+	account := cfg.EmailAccounts[0]
+	// Other tests count rule matches too
+	metrics.ResetRules()
+
+	// The first message is labeled, the second fails its last step
+	for i, reject := range []map[string]bool{nil, {"Later": true}} {
+		provider := &labelProvider{reject: reject}
+		msg := &email.Email{Mailbox: "INBOX", UID: uint32(i + 1), Subject: "Invoice 42"}
+		p.rulesMu.RLock()
+		p.applyRules(context.Background(), account, provider, "k"+strconv.Itoa(i), msg)
+		p.rulesMu.RUnlock()
+	}
+
+	stats := p.RuleStats()
+	if len(stats) != 2 {
+		t.Fatalf("RuleStats() returned %d rules; want 2", len(stats))
+	}
+	got := stats[0]
+	if got.Index != 0 || got.Matches != 2 || got.Actions != 3 || got.Errors != 1 || got.LastMatch.IsZero() {
+		t.Errorf("stats of the invoice rule = %+v; want 2 matches, 3 actions, 1 error", got)
+	}
+	if got := stats[1]; got.Matches != 0 || !got.LastMatch.IsZero() {
+		t.Errorf("stats of the outage rule = %+v; want no matches", got)
+	}
+	if n := metrics.GetRule(0, "matches"); n != 2 {
+		t.Errorf("matches metric = %d; want 2", n)
+	}
+
+	// Reordering keeps the counts with the rule, editing starts it over
+	edited := outage
+	edited.Label = "Incidents"
+	if err := p.SetRules([]config.Rule{edited, invoice}); err != nil {
+		t.Fatalf("SetRules: %v", err)
+	}
+	stats = p.RuleStats()
+	if stats[1].Index != 1 || stats[1].Matches != 2 {
+		t.Errorf("stats of the moved invoice rule = %+v; want 2 matches", stats[1])
+	}
+	if stats[0].Matches != 0 {
+		t.Errorf("stats of the edited rule = %+v; want none", stats[0])
+	}
+	if n := metrics.GetRule(0, "matches"); n != 0 {
+		t.Errorf("matches metric after a reload = %d; want 0", n)
+	}
+}
@@ -0,0 +1,50 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/scripting"
+)
+
+// loadScripts loads the script of every rule that has one, keyed by rule
+// index, so broken scripts fail at startup
+func loadScripts(ruleList []config.Rule, limits config.ScriptLimits) (map[int]*scripting.Script, error) {
+	loaded := make(map[int]*scripting.Script)
+	for i, rule := range ruleList {
+		if rule.Script == "" {
+			continue
+		}
+		script, err := scripting.Load(rule.Script, limits)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		loaded[i] = script
+	}
+	return loaded, nil
+}
+
+// scriptedRule runs the script of rule i on a message and returns the rule
+// with the actions the script returned, checked as configured actions are
+func (p *EmailPoller) scriptedRule(ctx context.Context, i int, rule config.Rule, msg *email.Email) (config.Rule, error) {
+	script, ok := p.scripts[i]
+	if !ok {
+		return rule, fmt.Errorf("rule %d has no loaded script", i)
+	}
+	acts, err := script.OnMessage(ctx, msg)
+	if err != nil {
+		return rule, err
+	}
+	check := rule
+	check.Script = ""
+	check.Actions = acts
+	if len(acts) > 0 {
+		if err := p.config.ValidateRule(i, check); err != nil {
+			return rule, fmt.Errorf("script returned an invalid action: %w", err)
+		}
+	}
+	rule.Actions = acts
+	return rule, nil
+}
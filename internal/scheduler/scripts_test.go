@@ -0,0 +1,64 @@
+package scheduler
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+func TestApplyRulesScript(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hook.star")
+	src := `
+def on_message(email):
+    if "invoice" in email.subject.lower():
+        return [{"action": "label", "label": "Bills"}, "notify"]
+    if "broken" in email.subject:
+        return ["label"]
+    return []
+`
+	if err := os.WriteFile(path, []byte(src), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.DefaultConfig()
+	cfg.Poll.Rules = []config.Rule{{Script: path}}
+	p, err := NewEmailPoller(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewEmailPoller: %v", err)
+	}
+	t.Cleanup(p.Stop)
+
+	tests := []struct {
+		subject     string
+		wantLabels  []string
+		wantEntries int
+		wantFailed  bool
+	}{
+		{"Invoice 42", []string{"Bills"}, 1, false},
+		{"Hello", nil, 0, false},
+		// A label action without a label is rejected like a configured one
+		{"broken", nil, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.subject, func(t *testing.T) {
+			provider := &labelProvider{}
+			msg := &email.Email{Mailbox: "INBOX", UID: 1, Subject: tt.subject}
+			p.rulesMu.Lock()
+			entries, failed := p.applyRules(context.Background(), cfg.EmailAccounts[0], provider, tt.subject, msg)
+			p.rulesMu.Unlock()
+			if !reflect.DeepEqual(provider.labels, tt.wantLabels) || len(entries) != tt.wantEntries || failed != tt.wantFailed {
+				t.Errorf("labels = %v, entries = %d, failed = %v; want %v, %d, %v",
+					provider.labels, len(entries), failed, tt.wantLabels, tt.wantEntries, tt.wantFailed)
+			}
+		})
+	}
+
+	cfg.Poll.Rules = []config.Rule{{Script: filepath.Join(t.TempDir(), "missing.star")}}
+	if _, err := NewEmailPoller(cfg, nil); err == nil {
+		t.Error("NewEmailPoller accepted a missing script")
+	}
+}
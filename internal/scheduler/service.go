@@ -0,0 +1,129 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/metrics"
+)
+
+// serviceStatusInterval is how often the service manager is sent the
+// accounts' status when no watchdog asks for more frequent updates
+const serviceStatusInterval = 30 * time.Second
+
+// ServiceNotifier tells a service manager such as systemd about the
+// poller: state is one or more newline separated assignments such as
+// "READY=1" or "STATUS=..." (see sd_notify(3))
+type ServiceNotifier func(state string) error
+
+// WithServiceNotifier makes each run of the poller send READY=1 once every
+// enabled account finished its first poll, successful or not, and a
+// STATUS= summary of the accounts every 30s. With a watchdog interval, the
+// summary is sent at half of it, along with WATCHDOG=1, for as long as the
+// poller's state can be read.
+func WithServiceNotifier(notify ServiceNotifier, watchdog time.Duration) Option {
+	return func(p *EmailPoller) {
+		p.serviceNotify = notify
+		p.watchdog = watchdog
+	}
+}
+
+// firstPollDone records that an account started by run finished its first
+// poll, or will not poll
+func (p *EmailPoller) firstPollDone(run *pollerRun, accountID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if run.pending[accountID] {
+		delete(run.pending, accountID)
+		run.checkReady()
+	}
+}
+
+// checkReady closes r.ready once the first poll of every account Start
+// launched finished; p.mu must be held
+func (r *pollerRun) checkReady() {
+	if r.launched && len(r.pending) == 0 && !r.isReady {
+		r.isReady = true
+		close(r.ready)
+	}
+}
+
+// runServiceNotifier keeps the service manager informed until ctx is
+// canceled or stopped is closed
+func (p *EmailPoller) runServiceNotifier(ctx context.Context, run *pollerRun) {
+	if p.serviceNotify == nil {
+		return
+	}
+	interval := serviceStatusInterval
+	if p.watchdog > 0 && p.watchdog/2 < interval {
+		interval = p.watchdog / 2
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	ready := run.ready
+	for {
+		var state string
+		select {
+		case <-ctx.Done():
+			return
+		case <-run.stopped:
+			return
+		case <-ready:
+			ready = nil
+			log.Println("All accounts polled, ready")
+			state = "READY=1\n" + p.serviceStatus()
+		case <-ticker.C:
+			// Reading the status takes the poller's lock, so a deadlocked
+			// poller stops the keepalives and the watchdog restarts it
+			state = p.serviceStatus()
+			if p.watchdog > 0 {
+				state = "WATCHDOG=1\n" + state
+			}
+		}
+		if err := p.serviceNotify(state); err != nil {
+			log.Printf("Failed to notify the service manager: %v", err)
+		}
+	}
+}
+
+// serviceStatus summarizes the enabled accounts as a STATUS= assignment,
+// e.g. "STATUS=2/3 accounts polling; work: polling, home: failing, lab: paused"
+func (p *EmailPoller) serviceStatus() string {
+	var polling int
+	var parts []string
+	for _, s := range p.Accounts() {
+		if !s.Enabled {
+			continue
+		}
+		state := s.serviceState()
+		if state == "polling" {
+			polling++
+		}
+		parts = append(parts, s.ID+": "+state)
+	}
+	if len(parts) == 0 {
+		return "STATUS=No accounts enabled"
+	}
+	return fmt.Sprintf("STATUS=%d/%d accounts polling; %s", polling, len(parts), strings.Join(parts, ", "))
+}
+
+// serviceState describes what an enabled account is doing in one word
+func (s AccountStatus) serviceState() string {
+	switch {
+	case s.Paused:
+		return "paused"
+	case !s.Running:
+		return "stopped"
+	case s.Degraded:
+		return "degraded"
+	case metrics.Get(s.ID, "backoff_attempts") > 0:
+		return "failing"
+	case s.LastSync.IsZero():
+		return "starting"
+	}
+	return "polling"
+}
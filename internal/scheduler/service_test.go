@@ -0,0 +1,51 @@
+package scheduler
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/imaptest"
+	"github.com/mshan/go-tsk/internal/metrics"
+)
+
+func TestServiceNotifier(t *testing.T) {
+	srv := imaptest.New(t, imaptest.Message{Subject: "Weekly newsletter", From: "news@example.com"})
+	p, account := newTestPoller(t, srv)
+	// Other tests fail polls of the same account ID
+	metrics.Set(account.ID, "backoff_attempts", 0)
+	states := make(chan string, 100)
+	WithServiceNotifier(func(state string) error {
+		states <- state
+		return nil
+	}, 40*time.Millisecond)(p)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Start(ctx)
+
+	// Keepalives may come before READY, which is sent once the account
+	// has polled
+	var ready string
+	for !strings.HasPrefix(ready, "READY=1") {
+		select {
+		case ready = <-states:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no READY=1 sent")
+		}
+	}
+	want := "READY=1\nSTATUS=1/1 accounts polling; " + account.ID + ": polling"
+	if ready != want {
+		t.Errorf("ready state = %q; want %q", ready, want)
+	}
+
+	select {
+	case state := <-states:
+		if !strings.HasPrefix(state, "WATCHDOG=1\nSTATUS=") {
+			t.Errorf("keepalive = %q; want WATCHDOG=1 and the status", state)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no keepalive sent")
+	}
+}
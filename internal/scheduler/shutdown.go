@@ -0,0 +1,165 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+// stopTimeout bounds how long Stop waits for in-flight polls
+const stopTimeout = 30 * time.Second
+
+// pollerRun is one run of the poller, from Start to Shutdown. Each run has
+// its own stop channel and wait groups, so a poller that was shut down can
+// be started again while polls of the previous run that outlived Shutdown
+// finish.
+type pollerRun struct {
+	ctx         context.Context // Context passed to Start; nil until accounts start and once Start returns
+	stopped     chan struct{}   // Closed by Shutdown
+	stopping    bool            // Set by Shutdown; guarded by p.mu
+	supervisors sync.WaitGroup
+	inFlight    sync.WaitGroup
+
+	// pending holds the accounts Start launched whose first poll has not
+	// finished; ready is closed once they all have. Guarded by p.mu.
+	pending  map[string]bool
+	launched bool // Start launched every account
+	ready    chan struct{}
+	isReady  bool
+}
+
+func newPollerRun() *pollerRun {
+	return &pollerRun{
+		stopped: make(chan struct{}),
+		pending: make(map[string]bool),
+		ready:   make(chan struct{}),
+	}
+}
+
+// Shutdown stops scheduling new polls, waits for in-flight polls to finish,
+// persists each account's last sync time and mailbox cursors and the rule
+// statistics, and logs out of every account. If ctx expires before the
+// polls finish, their connections are closed anyway and ctx's error is
+// returned; the caller should then cancel the context passed to Start to
+// abort them. The poller may be started again afterwards; Close releases
+// it for good.
+func (p *EmailPoller) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	run := p.run
+	if run != nil && run.stopping {
+		p.mu.Unlock()
+		return nil
+	}
+	if run != nil {
+		run.stopping = true
+		close(run.stopped)
+	}
+	for _, state := range p.accountState {
+		state.stop()
+	}
+	p.mu.Unlock()
+
+	var waitErr error
+	if run != nil {
+		// Wait for in-flight polls, bounded by ctx
+		done := make(chan struct{})
+		go func() {
+			run.inFlight.Wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			waitErr = fmt.Errorf("timed out waiting for in-flight polls: %w", ctx.Err())
+		}
+	}
+
+	p.mu.Lock()
+	for id, state := range p.accountState {
+		p.release(id, state)
+	}
+	p.ruleStats.save(p.store)
+	p.mu.Unlock()
+
+	if err := p.reporter.Flush(ctx); err != nil {
+		log.Printf("Error reports not delivered: %v", err)
+	}
+	return waitErr
+}
+
+// Close shuts the poller down like Shutdown, then closes its event sinks
+// and plugins. The poller cannot be started again.
+func (p *EmailPoller) Close(ctx context.Context) error {
+	err := p.Shutdown(ctx)
+	if err := p.events.Close(); err != nil {
+		log.Printf("Error closing event sinks: %v", err)
+	}
+	// Polls still running after a timeout fail their plugin calls
+	closePlugins(context.Background(), p.plugins)
+	return err
+}
+
+// release persists an account's last sync time and mailbox cursors and
+// logs out of it; p.mu must be held
+func (p *EmailPoller) release(id string, state *AccountState) {
+	p.persistLocked(id, state)
+	closePoolLocked(id, state)
+}
+
+// persistLocked saves an account's last sync time and mailbox cursors
+// where they changed since they were last saved, so a crash replays no
+// more than the poll it interrupted; p.mu must be held
+func (p *EmailPoller) persistLocked(id string, state *AccountState) {
+	if p.store == nil {
+		return
+	}
+	if !state.lastSync.IsZero() && !state.lastSync.Equal(state.savedSync) {
+		if err := p.store.SaveLastSync(id, state.lastSync); err != nil {
+			log.Printf("Failed to persist last sync for account %s: %v", id, err)
+		} else {
+			state.savedSync = state.lastSync
+		}
+	}
+	for mailbox, cursor := range state.cursors {
+		if cursor.IsZero() || state.savedCursors[mailbox] == cursor {
+			continue
+		}
+		if err := p.store.SaveCursor(id, mailbox, cursor); err != nil {
+			log.Printf("Failed to persist cursor of %s for account %s: %v", mailbox, id, err)
+			continue
+		}
+		if state.savedCursors == nil {
+			state.savedCursors = make(map[string]email.Cursor)
+		}
+		state.savedCursors[mailbox] = cursor
+	}
+}
+
+// Stop stops all polling and closes connections, waiting up to stopTimeout
+// for in-flight polls. The poller may be started again.
+func (p *EmailPoller) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+	defer cancel()
+
+	if err := p.Shutdown(ctx); err != nil {
+		log.Printf("Stop: %v", err)
+	}
+}
+
+// beginPoll registers an in-flight poll of run, returning false once its
+// shutdown has started
+func (p *EmailPoller) beginPoll(run *pollerRun) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if run.stopping {
+		return false
+	}
+	run.inFlight.Add(1)
+	return true
+}
@@ -0,0 +1,174 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/imaptest"
+	"github.com/mshan/go-tsk/internal/store"
+)
+
+// gatedProvider holds fetches while its gate is closed, until released or
+// their context ends
+type gatedProvider struct {
+	email.Provider
+	gate chan chan struct{} // Holds the release channel while closed
+	held chan struct{}      // Receives when a fetch is held
+}
+
+func (g *gatedProvider) FetchNewEmails(ctx context.Context, mailbox string, cursor email.Cursor) ([]*email.Email, email.Cursor, error) {
+	select {
+	case release := <-g.gate:
+		g.gate <- release
+		g.held <- struct{}{}
+		select {
+		case <-release:
+		case <-ctx.Done():
+			return nil, cursor, ctx.Err()
+		}
+	default:
+	}
+	return g.Provider.FetchNewEmails(ctx, mailbox, cursor)
+}
+
+// newGatedPoller returns a poller of one account of srv, saving its state
+// in a bolt store, and the gate of its fetches
+func newGatedPoller(t *testing.T, srv *imaptest.Server) (*EmailPoller, config.EmailAccount, store.Store, *gatedProvider) {
+	t.Helper()
+	st, err := store.OpenBolt(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+
+	cfg := config.DefaultConfig()
+	cfg.Poll.InitialSyncLimit = 100
+	gated := &gatedProvider{gate: make(chan chan struct{}, 1), held: make(chan struct{}, 10)}
+	factory := func(account config.EmailAccount) (email.Provider, error) {
+		client, err := email.NewGmailClient(imaptest.Username, "", "", imaptest.Token,
+			email.WithServer(srv.Addr(), srv.TLSConfig()))
+		gated.Provider = client
+		return gated, err
+	}
+	p, err := NewEmailPoller(cfg, st, WithProviderFactory(factory))
+	if err != nil {
+		t.Fatalf("NewEmailPoller() error = %v", err)
+	}
+	t.Cleanup(p.Stop)
+	return p, cfg.EmailAccounts[0], st, gated
+}
+
+// waitHeld waits for a fetch to be held at the gate
+func waitHeld(t *testing.T, gated *gatedProvider) {
+	t.Helper()
+	select {
+	case <-gated.held:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no fetch reached the gate")
+	}
+}
+
+// savedCursor returns the persisted INBOX cursor of an account
+func savedCursor(t *testing.T, st store.Store, accountID string) email.Cursor {
+	t.Helper()
+	cursor, err := st.Cursor(accountID, email.Inbox)
+	if err != nil {
+		t.Fatalf("Cursor() error = %v", err)
+	}
+	return cursor
+}
+
+func TestShutdownDrainsInFlightPoll(t *testing.T) {
+	srv := imaptest.New(t,
+		imaptest.Message{Subject: "Weekly newsletter", From: "news@example.com"},
+		imaptest.Message{Subject: "Job opportunity", From: "jobs@example.com"},
+	)
+	p, account, st, gated := newGatedPoller(t, srv)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Start(ctx)
+
+	// Each successful poll persists its state, before any shutdown
+	deadline := time.Now().Add(5 * time.Second)
+	for savedCursor(t, st, account.ID).LastUID != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("cursor after the first poll = %+v; want LastUID 2", savedCursor(t, st, account.ID))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if lastSync, err := st.LastSync(account.ID); err != nil || lastSync.IsZero() {
+		t.Errorf("LastSync() = %v, %v; want the time of the first poll", lastSync, err)
+	}
+
+	// Shutdown waits for the poll in flight and saves its progress
+	release := make(chan struct{})
+	gated.gate <- release
+	srv.Append("INBOX", imaptest.Message{Subject: "Another newsletter", From: "news@example.com"})
+	if err := p.PollNow(account.ID); err != nil {
+		t.Fatal(err)
+	}
+	waitHeld(t, gated)
+	done := make(chan error, 1)
+	go func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		done <- p.Shutdown(shutdownCtx)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown() returned %v with a poll in flight", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	<-gated.gate
+	close(release)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Shutdown() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown() did not return once the poll finished")
+	}
+	if cursor := savedCursor(t, st, account.ID); cursor.LastUID != 3 {
+		t.Errorf("cursor after shutdown = %+v; want LastUID 3", cursor)
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	srv := imaptest.New(t, imaptest.Message{Subject: "Weekly newsletter", From: "news@example.com"})
+	p, account, st, gated := newGatedPoller(t, srv)
+	gated.gate <- make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	started := make(chan error, 1)
+	go func() { started <- p.Start(ctx) }()
+	waitHeld(t, gated)
+
+	// A poll that outlives the deadline fails Shutdown, which still
+	// releases the account
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelShutdown()
+	err := p.Shutdown(shutdownCtx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown() error = %v; want context.DeadlineExceeded", err)
+	}
+	if status, err := p.Account(account.ID); err != nil || status.Connected {
+		t.Errorf("Account() = %+v, %v; want disconnected", status, err)
+	}
+
+	// Canceling Start's context aborts the poll, without moving the cursor
+	cancel()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start() did not return")
+	}
+	if cursor := savedCursor(t, st, account.ID); !cursor.IsZero() {
+		t.Errorf("cursor = %+v; want none saved", cursor)
+	}
+}
@@ -0,0 +1,141 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/actions"
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/logging"
+	"github.com/mshan/go-tsk/internal/metrics"
+	"github.com/mshan/go-tsk/internal/store"
+)
+
+// defaultSnoozeMailbox is where snoozed messages wait when the rule does
+// not name a mailbox
+const defaultSnoozeMailbox = "Snoozed"
+
+// errNoMove is returned when snoozing with a provider that cannot move
+// messages
+var errNoMove = errors.New("the provider cannot move messages")
+
+// errSnoozedGone is returned when a snoozed message is no longer in its
+// mailbox, having been moved or deleted meanwhile
+var errSnoozedGone = errors.New("snoozed message is gone")
+
+// snoozeAction moves a message out of INBOX until the rule's SnoozeFor has
+// passed. The snooze is saved before the move, so a crash in between
+// leaves a snooze of a message that never left rather than losing track of
+// one that did. As the last step of a chain in a batch, the move is queued
+// and sent with the batch's other moves.
+func (p *EmailPoller) snoozeAction(ctx context.Context, msg *email.Email, a actions.Params) error {
+	mover, ok := a.Provider.(email.Mover)
+	if !ok {
+		return errNoMove
+	}
+	if p.store == nil {
+		return fmt.Errorf("no state store configured")
+	}
+	// The message gets a new UID when it moves, so it is found again by
+	// its Message-ID
+	if strings.TrimSpace(msg.MessageID) == "" {
+		return fmt.Errorf("cannot snooze a message without a Message-ID")
+	}
+
+	sn := store.Snooze{
+		AccountID: a.Account.ID,
+		MessageID: msg.MessageID,
+		Mailbox:   snoozeMailbox(a.Rule),
+		Until:     time.Now().Add(a.Rule.SnoozeFor),
+	}
+	if err := p.store.CreateSnooze(&sn); err != nil {
+		return fmt.Errorf("failed to save snooze: %w", err)
+	}
+	discard := func() {
+		if err := p.store.DeleteSnooze(sn.ID); err != nil {
+			log.Printf("Failed to discard snooze %d: %v", sn.ID, err)
+		}
+	}
+	snoozed := func() {
+		metrics.Add(a.Account.ID, "messages_snoozed", 1)
+		log.Printf("Snoozed email with subject %s until %s", logging.Subject(msg.Subject), sn.Until.Format(time.RFC3339))
+	}
+	if step := batchStepFrom(ctx); step != nil {
+		step.move(msg.Mailbox, sn.Mailbox, batchEntry{key: a.Key, uid: msg.UID, done: snoozed, undo: discard})
+		return nil
+	}
+
+	if err := mover.MoveMessage(ctx, msg.Mailbox, msg.UID, sn.Mailbox); err != nil {
+		discard()
+		return fmt.Errorf("failed to snooze message: %w", err)
+	}
+	snoozed()
+	return nil
+}
+
+// snoozeMailbox returns the mailbox a rule snoozes messages in
+func snoozeMailbox(rule config.Rule) string {
+	if rule.SnoozeMailbox != "" {
+		return rule.SnoozeMailbox
+	}
+	return defaultSnoozeMailbox
+}
+
+// wakeSnoozed moves an account's messages whose snooze has ended back to
+// INBOX, unread. Messages that cannot be moved yet are retried on the
+// next poll. Having been processed before, they are not run through the
+// rules again.
+func (p *EmailPoller) wakeSnoozed(ctx context.Context, account config.EmailAccount, client email.Provider) {
+	if p.store == nil {
+		return
+	}
+	due, err := p.store.DueSnoozes(account.ID, time.Now())
+	if err != nil {
+		log.Printf("Failed to load snoozes of account %s: %v", account.ID, err)
+		return
+	}
+	for _, sn := range due {
+		err := resurface(ctx, client, sn)
+		switch {
+		case errors.Is(err, errSnoozedGone):
+			log.Printf("Snoozed message %s of account %s left %s; not resurfacing it", sn.MessageID, account.ID, sn.Mailbox)
+		case err != nil:
+			log.Printf("Failed to resurface snoozed message %s of account %s: %v", sn.MessageID, account.ID, err)
+			continue
+		default:
+			metrics.Add(account.ID, "messages_resurfaced", 1)
+		}
+		if err := p.store.DeleteSnooze(sn.ID); err != nil {
+			log.Printf("Failed to discard snooze %d: %v", sn.ID, err)
+		}
+	}
+}
+
+// resurface marks a snoozed message unread and moves it back to INBOX
+func resurface(ctx context.Context, client email.Provider, sn store.Snooze) error {
+	mover, ok := client.(email.Mover)
+	if !ok {
+		return errNoMove
+	}
+	uids, err := client.SearchMessageID(ctx, sn.Mailbox, sn.MessageID)
+	if err != nil {
+		return err
+	}
+	if len(uids) == 0 {
+		return errSnoozedGone
+	}
+	if err := client.RemoveLabel(ctx, sn.Mailbox, uids, `\Seen`); err != nil {
+		return fmt.Errorf("failed to mark unread: %w", err)
+	}
+	for _, uid := range uids {
+		if err := mover.MoveMessage(ctx, sn.Mailbox, uid, email.Inbox); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/actions"
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/imaptest"
+	"github.com/mshan/go-tsk/internal/store"
+)
+
+func TestResurface(t *testing.T) {
+	srv := imaptest.New(t, imaptest.Message{MessageID: "<1@example.com>", Subject: "Unrelated"})
+	srv.Append("Snoozed", imaptest.Message{MessageID: "<2@example.com>", Subject: "Later", Flags: []string{`\Seen`}})
+	client, err := email.NewGmailClient(imaptest.Username, "", "", imaptest.Token, email.WithServer(srv.Addr(), srv.TLSConfig()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Authenticate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	sn := store.Snooze{AccountID: "primary", MessageID: "<2@example.com>", Mailbox: "Snoozed", Until: time.Now()}
+	if err := resurface(ctx, client, sn); err != nil {
+		t.Fatalf("resurface() error = %v", err)
+	}
+	inbox := srv.Messages("INBOX")
+	if len(inbox) != 2 || inbox[1].MessageID != "<2@example.com>" || len(inbox[1].Flags) != 0 {
+		t.Errorf("INBOX = %+v; want the snoozed message back, unread", inbox)
+	}
+	if snoozed := srv.Messages("Snoozed"); len(snoozed) != 0 {
+		t.Errorf("Snoozed still holds %+v", snoozed)
+	}
+
+	if err := resurface(ctx, client, sn); !errors.Is(err, errSnoozedGone) {
+		t.Errorf("resurface() of a message already back = %v; want errSnoozedGone", err)
+	}
+}
+
+func TestSnoozeActionErrors(t *testing.T) {
+	p := &EmailPoller{}
+	account := config.EmailAccount{ID: "primary"}
+	rule := config.Rule{Action: "snooze", SnoozeFor: time.Hour}
+	msg := &email.Email{Mailbox: "INBOX", UID: 1, MessageID: "<1@example.com>"}
+
+	params := actions.Params{Account: account, Provider: &labelProvider{}, Rule: rule}
+	if err := p.snoozeAction(context.Background(), msg, params); !errors.Is(err, errNoMove) {
+		t.Errorf("snoozeAction() with a provider that cannot move = %v; want errNoMove", err)
+	}
+	params.Provider = throttle(account, &labelProvider{})
+	if err := p.snoozeAction(context.Background(), msg, params); err == nil {
+		t.Error("snoozeAction() without a store succeeded")
+	}
+}
@@ -0,0 +1,144 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/metrics"
+)
+
+// maxErrorHistory bounds the number of errors kept per account
+const maxErrorHistory = 10
+
+// restartAfterFailures is how many polls in a row may fail before the
+// account's polling is restarted on fresh connections
+const restartAfterFailures = 5
+
+// errAlreadyActive is returned when an account is already being polled
+var errAlreadyActive = errors.New("polling already active")
+
+// AccountError records a failure of one account's polling goroutine
+type AccountError struct {
+	AccountID string
+	Err       error
+	Time      time.Time
+}
+
+func (e AccountError) Error() string {
+	return fmt.Sprintf("account %s: %v", e.AccountID, e.Err)
+}
+
+func (e AccountError) Unwrap() error {
+	return e.Err
+}
+
+// pollsFailingError stops an account's polling once its polls keep failing
+type pollsFailingError struct {
+	Attempts int           // Failed polls in a row
+	Retry    time.Duration // Delay before the next poll
+	Err      error         // Error of the last poll
+}
+
+func (e *pollsFailingError) Error() string {
+	return fmt.Sprintf("%d polls in a row failed: %v", e.Attempts, e.Err)
+}
+
+func (e *pollsFailingError) Unwrap() error {
+	return e.Err
+}
+
+// Errors aggregates failures from several accounts
+type Errors []AccountError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// supervise runs pollAccount for one account, restarting it with backoff
+// after failures until ctx is canceled or stop is closed. It returns an
+// error only if the account cannot be restarted.
+func (p *EmailPoller) supervise(ctx context.Context, account config.EmailAccount, run *pollerRun, stop <-chan struct{}) error {
+	restarts := newBackoff(p.config.Poll.Backoff)
+
+	for {
+		started := time.Now()
+		err := p.pollAccountSafely(ctx, account, run, stop)
+		if err == nil || ctx.Err() != nil {
+			return nil
+		}
+
+		// Failed polls are in the history already
+		var failing *pollsFailingError
+		if !errors.As(err, &failing) {
+			p.recordError(account.ID, err)
+		}
+		if errors.Is(err, errAlreadyActive) {
+			return AccountError{AccountID: account.ID, Err: err, Time: time.Now()}
+		}
+		p.closePool(account.ID)
+
+		// A run that survived a full interval was healthy, so start the
+		// restart delay over
+		if time.Since(started) >= p.config.Poll.Interval {
+			restarts.Reset()
+		}
+		delay := restarts.Next()
+		// Restarting doesn't cut the wait after a failed poll short
+		if failing != nil && failing.Retry > delay {
+			delay = failing.Retry
+		}
+		metrics.Add(account.ID, "restarts", 1)
+		log.Printf("Polling for account %s stopped: %v; restarting in %s",
+			account.ID, err, delay.Round(time.Second))
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-stop:
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// recordError appends err to the account's bounded error history
+func (p *EmailPoller) recordError(accountID string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state := p.accountState[accountID]
+	state.errors = append(state.errors, AccountError{
+		AccountID: accountID,
+		Err:       err,
+		Time:      time.Now(),
+	})
+	if len(state.errors) > maxErrorHistory {
+		state.errors = state.errors[len(state.errors)-maxErrorHistory:]
+	}
+	metrics.Add(accountID, "errors", 1)
+}
+
+// Errors returns the recent errors of all accounts, oldest first
+func (p *EmailPoller) Errors() Errors {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var all Errors
+	for _, state := range p.accountState {
+		all = append(all, state.errors...)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Time.Before(all[j].Time)
+	})
+	return all
+}
@@ -0,0 +1,86 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/imaptest"
+	"github.com/mshan/go-tsk/internal/metrics"
+)
+
+func TestSuperviseRestartsFailingAccount(t *testing.T) {
+	srv := imaptest.New(t, imaptest.Message{Subject: "Weekly newsletter", From: "news@example.com"})
+	cfg := config.DefaultConfig()
+	cfg.Poll.Interval = 10 * time.Millisecond
+	cfg.Poll.Backoff = config.BackoffConfig{Initial: time.Millisecond, Max: 5 * time.Millisecond, Multiplier: 2}
+	cfg.EmailAccounts = []config.EmailAccount{
+		{ID: "supervised-broken", Enabled: true},
+		{ID: "supervised-healthy", Enabled: true},
+	}
+	for _, account := range cfg.EmailAccounts {
+		metrics.Set(account.ID, "polls", 0)
+		metrics.Set(account.ID, "restarts", 0)
+	}
+
+	dialErr := errors.New("connection refused")
+	factory := func(account config.EmailAccount) (email.Provider, error) {
+		if account.ID == "supervised-broken" {
+			return nil, dialErr
+		}
+		return email.NewGmailClient(imaptest.Username, "", "", imaptest.Token,
+			email.WithServer(srv.Addr(), srv.TLSConfig()))
+	}
+	p, err := NewEmailPoller(cfg, nil, WithProviderFactory(factory))
+	if err != nil {
+		t.Fatalf("NewEmailPoller() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- p.Start(ctx) }()
+
+	// The broken account is restarted after every restartAfterFailures
+	// failed polls, and the healthy one keeps polling meanwhile
+	deadline := time.Now().Add(10 * time.Second)
+	for metrics.Get("supervised-broken", "restarts") < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("restarts = %d; want 2", metrics.Get("supervised-broken", "restarts"))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	healthyPolls := metrics.Get("supervised-healthy", "polls")
+	for metrics.Get("supervised-healthy", "polls") <= healthyPolls+1 {
+		if time.Now().After(deadline) {
+			t.Fatal("the healthy account stopped polling")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if polls := metrics.Get("supervised-broken", "polls"); polls < 2*restartAfterFailures {
+		t.Errorf("broken account polled %d times; want at least %d", polls, 2*restartAfterFailures)
+	}
+
+	// Failed polls are in the error history
+	errs := p.Errors()
+	if len(errs) == 0 {
+		t.Fatal("Errors() is empty")
+	}
+	for _, e := range errs {
+		if e.AccountID != "supervised-broken" || !errors.Is(e, dialErr) {
+			t.Errorf("error %v; want dial errors of the broken account", e)
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Start() error = %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Start() did not return after cancel")
+	}
+	p.Stop()
+}
@@ -0,0 +1,83 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/actions"
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/logging"
+	"github.com/mshan/go-tsk/internal/metrics"
+)
+
+// recordThreadLabel notes that a message's thread got a label, for
+// ThreadAlreadyLabeled and, if inherit is set, for the thread's later
+// messages. Without a store or a thread ID nothing is recorded.
+func (p *EmailPoller) recordThreadLabel(account config.EmailAccount, msg *email.Email, label string, inherit bool) {
+	if p.store == nil || msg.ThreadID == "" {
+		return
+	}
+	if err := p.store.RecordThreadLabel(account.ID, msg.ThreadID, label, inherit, time.Now()); err != nil {
+		log.Printf("Failed to record label %s of thread %s for account %s: %v", label, msg.ThreadID, account.ID, err)
+	}
+}
+
+// threadLabeled reports whether an earlier message of the message's thread
+// got label
+func (p *EmailPoller) threadLabeled(account config.EmailAccount, msg *email.Email, label string) bool {
+	if p.store == nil || msg.ThreadID == "" {
+		return false
+	}
+	labels, err := p.store.ThreadLabels(account.ID, msg.ThreadID)
+	if err != nil {
+		log.Printf("Failed to look up labels of thread %s for account %s: %v", msg.ThreadID, account.ID, err)
+		return false
+	}
+	for _, l := range labels {
+		if l.Label == label {
+			return true
+		}
+	}
+	return false
+}
+
+// inheritThreadLabels gives a message the labels its thread's earlier
+// messages got from ApplyToThread rules, and reports whether applying any
+// failed
+func (p *EmailPoller) inheritThreadLabels(ctx context.Context, account config.EmailAccount, client email.Provider, key string, msg *email.Email) bool {
+	if p.store == nil || msg.ThreadID == "" {
+		return false
+	}
+	labels, err := p.store.ThreadLabels(account.ID, msg.ThreadID)
+	if err != nil {
+		log.Printf("Failed to look up labels of thread %s for account %s: %v", msg.ThreadID, account.ID, err)
+		return false
+	}
+
+	failed := false
+	for _, l := range labels {
+		if !l.Inherit || hasLabel(msg, l.Label) {
+			continue
+		}
+		rule := config.Rule{Action: "label", Label: l.Label, ApplyToThread: true}
+		if err := p.labelAction(ctx, msg, actions.Params{Account: account, Provider: client, Rule: rule, Key: key}); err != nil {
+			log.Printf("Failed to apply label %s of its thread to email with subject %s: %v", l.Label, logging.Subject(msg.Subject), err)
+			failed = true
+			continue
+		}
+		metrics.Add(account.ID, "thread_labels_inherited", 1)
+	}
+	return failed
+}
+
+// hasLabel reports whether a message already carries label
+func hasLabel(msg *email.Email, label string) bool {
+	for _, f := range msg.Flags {
+		if f == label {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,29 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+func TestThreadsWithoutStore(t *testing.T) {
+	p := &EmailPoller{}
+	account := config.EmailAccount{ID: "primary"}
+	msg := &email.Email{Mailbox: email.Inbox, UID: 2, ThreadID: "<1@example.com>", Flags: []string{"Project"}}
+
+	// Without a store threads are not tracked: nothing is labeled and no
+	// thread condition holds
+	p.recordThreadLabel(account, msg, "Project", true)
+	if p.threadLabeled(account, msg, "Project") {
+		t.Error("threadLabeled() without a store = true")
+	}
+	if p.inheritThreadLabels(context.Background(), account, nil, "mid:<2@example.com>", msg) {
+		t.Error("inheritThreadLabels() without a store failed")
+	}
+
+	if !hasLabel(msg, "Project") || hasLabel(msg, "Other") {
+		t.Errorf("hasLabel() misreads flags %v", msg.Flags)
+	}
+}
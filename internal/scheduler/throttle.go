@@ -0,0 +1,372 @@
+package scheduler
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/metrics"
+)
+
+// tokenBucket spaces out operations to rate per second, allowing bursts of
+// up to burst operations
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64 // Negative while callers wait for reserved tokens
+	last   time.Time
+}
+
+// newTokenBucket creates a full bucket; burst < 1 uses 1
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// reserve takes a token and returns how long the caller must wait before
+// using it
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel returns a reserved token that was not used
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens++
+}
+
+// wait takes a token, blocking until it may be used, and reports whether
+// the caller was held back
+func (b *tokenBucket) wait(ctx context.Context) (bool, error) {
+	delay := b.reserve(time.Now())
+	if delay <= 0 {
+		return false, nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		b.cancel()
+		return true, ctx.Err()
+	case <-timer.C:
+		return true, nil
+	}
+}
+
+// throttledProvider applies an account's limits to its provider
+type throttledProvider struct {
+	email.Provider
+	accountID string
+	slots     chan struct{} // One per command in flight; nil without a concurrency limit
+	stores    *tokenBucket  // nil without a store rate
+}
+
+// throttle wraps client in the account's limits, if it has any
+func throttle(account config.EmailAccount, client email.Provider) email.Provider {
+	return newThrottler(account)(client)
+}
+
+// newThrottler returns a func wrapping providers in the account's limits,
+// if it has any. The providers it wraps share the limits, so the pooled
+// connections of an account stay under them together.
+func newThrottler(account config.EmailAccount) func(email.Provider) email.Provider {
+	limits := account.Limits
+	if limits.MaxConcurrent <= 0 && limits.StoreRate <= 0 {
+		return func(client email.Provider) email.Provider { return client }
+	}
+	var slots chan struct{}
+	if limits.MaxConcurrent > 0 {
+		slots = make(chan struct{}, limits.MaxConcurrent)
+	}
+	var stores *tokenBucket
+	if limits.StoreRate > 0 {
+		stores = newTokenBucket(limits.StoreRate, limits.StoreBurst)
+	}
+	return func(client email.Provider) email.Provider {
+		return &throttledProvider{Provider: client, accountID: account.ID, slots: slots, stores: stores}
+	}
+}
+
+// acquire waits for a command slot; the returned func releases it
+func (t *throttledProvider) acquire(ctx context.Context) (func(), error) {
+	if t.slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case t.slots <- struct{}{}:
+	default:
+		metrics.Add(t.accountID, "imap_throttled", 1)
+		select {
+		case t.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return func() { <-t.slots }, nil
+}
+
+// acquireStore waits for the store rate and then a command slot
+func (t *throttledProvider) acquireStore(ctx context.Context) (func(), error) {
+	if t.stores != nil {
+		waited, err := t.stores.wait(ctx)
+		if waited {
+			metrics.Add(t.accountID, "stores_throttled", 1)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t.acquire(ctx)
+}
+
+func (t *throttledProvider) Connect(ctx context.Context) error {
+	release, err := t.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return t.Provider.Connect(ctx)
+}
+
+func (t *throttledProvider) Authenticate(ctx context.Context) error {
+	release, err := t.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return t.Provider.Authenticate(ctx)
+}
+
+func (t *throttledProvider) ListMailboxes(ctx context.Context) ([]string, error) {
+	release, err := t.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return t.Provider.ListMailboxes(ctx)
+}
+
+func (t *throttledProvider) FetchNewEmails(ctx context.Context, mailbox string, cursor email.Cursor) ([]*email.Email, email.Cursor, error) {
+	release, err := t.acquire(ctx)
+	if err != nil {
+		return nil, cursor, err
+	}
+	defer release()
+	return t.Provider.FetchNewEmails(ctx, mailbox, cursor)
+}
+
+// StreamNewEmails streams new messages if the wrapped provider can. The
+// slot is given back while fn runs, so fn's commands are not held up by
+// the stream.
+func (t *throttledProvider) StreamNewEmails(ctx context.Context, mailbox string, cursor email.Cursor, fn func(emails []*email.Email, next email.Cursor) error) (email.Cursor, error) {
+	streamer, ok := t.Provider.(email.Streamer)
+	if !ok {
+		return email.StreamNewEmails(ctx, providerOnly{t}, mailbox, cursor, fn)
+	}
+	release, err := t.acquire(ctx)
+	if err != nil {
+		return cursor, err
+	}
+	defer func() { release() }()
+	return streamer.StreamNewEmails(ctx, mailbox, cursor, func(emails []*email.Email, next email.Cursor) error {
+		release()
+		release = func() {}
+		err := fn(emails, next)
+		again, acquireErr := t.acquire(ctx)
+		if acquireErr != nil {
+			return acquireErr
+		}
+		release = again
+		return err
+	})
+}
+
+// providerOnly hides the optional interfaces of a provider
+type providerOnly struct {
+	email.Provider
+}
+
+func (t *throttledProvider) FetchBatch(ctx context.Context, mailbox string, afterUID uint32, limit int) ([]*email.Email, error) {
+	release, err := t.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return t.Provider.FetchBatch(ctx, mailbox, afterUID, limit)
+}
+
+func (t *throttledProvider) Status(ctx context.Context, mailbox string) (email.MailboxStatus, error) {
+	release, err := t.acquire(ctx)
+	if err != nil {
+		return email.MailboxStatus{}, err
+	}
+	defer release()
+	return t.Provider.Status(ctx, mailbox)
+}
+
+func (t *throttledProvider) ApplyLabel(ctx context.Context, mailbox string, uid uint32, label string) error {
+	release, err := t.acquireStore(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return t.Provider.ApplyLabel(ctx, mailbox, uid, label)
+}
+
+// ApplyLabels labels messages with one command if the wrapped provider can
+// batch them, counting once against the store rate, and one per message
+// otherwise
+func (t *throttledProvider) ApplyLabels(ctx context.Context, mailbox string, uids []uint32, label string) error {
+	batch, ok := t.Provider.(email.BatchLabeler)
+	if !ok {
+		for _, uid := range uids {
+			if err := t.ApplyLabel(ctx, mailbox, uid, label); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	release, err := t.acquireStore(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return batch.ApplyLabels(ctx, mailbox, uids, label)
+}
+
+func (t *throttledProvider) FetchMessage(ctx context.Context, mailbox string, uid uint32) (*email.Message, error) {
+	release, err := t.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return t.Provider.FetchMessage(ctx, mailbox, uid)
+}
+
+// FetchRaw downloads a raw message if the wrapped provider can
+func (t *throttledProvider) FetchRaw(ctx context.Context, mailbox string, uid uint32) ([]byte, error) {
+	fetcher, ok := t.Provider.(email.RawFetcher)
+	if !ok {
+		return nil, errNoRawFetch
+	}
+	release, err := t.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return fetcher.FetchRaw(ctx, mailbox, uid)
+}
+
+// Ping checks the connection of the wrapped provider, if it can be
+// checked. Pings are not throttled, as they stand in for no command of
+// the poll.
+func (t *throttledProvider) Ping(ctx context.Context) error {
+	if pinger, ok := t.Provider.(email.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// MoveMessage moves a message if the wrapped provider can. Moves count
+// against the store rate, as they change mail.
+func (t *throttledProvider) MoveMessage(ctx context.Context, mailbox string, uid uint32, dest string) error {
+	mover, ok := t.Provider.(email.Mover)
+	if !ok {
+		return errNoMove
+	}
+	release, err := t.acquireStore(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return mover.MoveMessage(ctx, mailbox, uid, dest)
+}
+
+// MoveMessages moves messages with one command if the wrapped provider
+// can batch them, and one per message otherwise
+func (t *throttledProvider) MoveMessages(ctx context.Context, mailbox string, uids []uint32, dest string) error {
+	batch, ok := t.Provider.(email.BatchMover)
+	if !ok {
+		for _, uid := range uids {
+			if err := t.MoveMessage(ctx, mailbox, uid, dest); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	release, err := t.acquireStore(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return batch.MoveMessages(ctx, mailbox, uids, dest)
+}
+
+func (t *throttledProvider) SearchMessageID(ctx context.Context, mailbox, messageID string) ([]uint32, error) {
+	release, err := t.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return t.Provider.SearchMessageID(ctx, mailbox, messageID)
+}
+
+func (t *throttledProvider) Search(ctx context.Context, mailbox string, filter email.Filter) ([]uint32, error) {
+	release, err := t.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return t.Provider.Search(ctx, mailbox, filter)
+}
+
+func (t *throttledProvider) RemoveLabel(ctx context.Context, mailbox string, uids []uint32, label string) error {
+	release, err := t.acquireStore(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return t.Provider.RemoveLabel(ctx, mailbox, uids, label)
+}
+
+func (t *throttledProvider) DeleteMessages(ctx context.Context, mailbox string, uids []uint32) error {
+	release, err := t.acquireStore(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return t.Provider.DeleteMessages(ctx, mailbox, uids)
+}
+
+// limitMessages cuts emails down to the oldest limit of them and returns
+// the cursor after the last message kept. A limit of 0 or less keeps all.
+func limitMessages(accountID string, limit int, emails []*email.Email, next email.Cursor) ([]*email.Email, email.Cursor) {
+	if limit <= 0 || len(emails) <= limit {
+		return emails, next
+	}
+	sort.Slice(emails, func(i, j int) bool { return emails[i].UID < emails[j].UID })
+	metrics.Add(accountID, "messages_deferred", int64(len(emails)-limit))
+	emails = emails[:limit]
+	next.LastUID = emails[limit-1].UID
+	next.ModSeq = 0 // It also covers the messages left for later
+	return emails, next
+}
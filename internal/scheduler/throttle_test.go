@@ -0,0 +1,126 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/metrics"
+)
+
+func TestTokenBucketReserve(t *testing.T) {
+	b := newTokenBucket(2, 2)
+	start := b.last
+
+	tests := []struct {
+		at   time.Duration
+		want time.Duration
+	}{
+		// The burst is free, then callers queue for later tokens
+		{0, 0},
+		{0, 0},
+		{0, 500 * time.Millisecond},
+		{0, time.Second},
+		// Four tokens refilled, two of them already reserved
+		{2 * time.Second, 0},
+		{2 * time.Second, 0},
+		{2 * time.Second, 500 * time.Millisecond},
+	}
+	for i, tt := range tests {
+		if got := b.reserve(start.Add(tt.at)); got != tt.want {
+			t.Errorf("reserve() #%d at %s = %s; want %s", i+1, tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestLimitMessages(t *testing.T) {
+	emails := []*email.Email{{UID: 12}, {UID: 10}, {UID: 11}}
+	next := email.Cursor{UIDValidity: 1, LastUID: 12}
+
+	tests := []struct {
+		name     string
+		limit    int
+		wantUIDs []uint32
+		wantLast uint32
+	}{
+		{"no limit", 0, []uint32{12, 10, 11}, 12},
+		{"within limit", 3, []uint32{12, 10, 11}, 12},
+		{"over limit", 2, []uint32{10, 11}, 11},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, cursor := limitMessages("limits-"+tt.name, tt.limit, append([]*email.Email(nil), emails...), next)
+			if len(got) != len(tt.wantUIDs) {
+				t.Fatalf("limitMessages() kept %d messages; want %d", len(got), len(tt.wantUIDs))
+			}
+			for i, msg := range got {
+				if msg.UID != tt.wantUIDs[i] {
+					t.Errorf("message %d UID = %d; want %d", i, msg.UID, tt.wantUIDs[i])
+				}
+			}
+			if cursor.LastUID != tt.wantLast || cursor.UIDValidity != 1 {
+				t.Errorf("cursor = %+v; want LastUID %d", cursor, tt.wantLast)
+			}
+		})
+	}
+}
+
+// slowProvider records how many commands run at once
+type slowProvider struct {
+	*email.FakeProvider
+	running, peak int32
+}
+
+func (s *slowProvider) Status(ctx context.Context, mailbox string) (email.MailboxStatus, error) {
+	n := atomic.AddInt32(&s.running, 1)
+	defer atomic.AddInt32(&s.running, -1)
+	for {
+		peak := atomic.LoadInt32(&s.peak)
+		if n <= peak || atomic.CompareAndSwapInt32(&s.peak, peak, n) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	return email.MailboxStatus{}, nil
+}
+
+func TestThrottleConcurrency(t *testing.T) {
+	account := config.EmailAccount{ID: "throttled", Limits: config.AccountLimits{MaxConcurrent: 2, StoreRate: 1000}}
+	slow := &slowProvider{FakeProvider: email.NewFakeProvider(0)}
+	client := throttle(account, slow)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.Status(context.Background(), email.Inbox)
+		}()
+	}
+	wg.Wait()
+
+	if slow.peak != 2 {
+		t.Errorf("peak concurrent commands = %d; want 2", slow.peak)
+	}
+	if metrics.Get(account.ID, "imap_throttled") == 0 {
+		t.Error("imap_throttled = 0; want throttled commands counted")
+	}
+
+	// A canceled wait for a slot gives up
+	unlimited := throttle(config.EmailAccount{ID: "open"}, slow)
+	if unlimited != email.Provider(slow) {
+		t.Error("throttle() without limits wrapped the provider")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tp := client.(*throttledProvider)
+	tp.slots <- struct{}{}
+	tp.slots <- struct{}{}
+	if _, err := client.Status(ctx, email.Inbox); err != context.Canceled {
+		t.Errorf("Status() with all slots taken and ctx canceled error = %v; want context.Canceled", err)
+	}
+}
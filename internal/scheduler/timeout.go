@@ -0,0 +1,42 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/metrics"
+)
+
+// Default timeouts, used when the config leaves them at 0
+const (
+	defaultConnectTimeout = time.Minute
+	defaultFetchTimeout   = 10 * time.Minute
+	defaultActionTimeout  = 2 * time.Minute
+)
+
+// timeoutKey is the context key of the timeout set by withTimeout
+type timeoutKey struct{}
+
+// withTimeout returns ctx bounded by d, or by def if d is 0, so a hung
+// provider call fails instead of blocking the account's polls forever
+func withTimeout(ctx context.Context, d, def time.Duration) (context.Context, context.CancelFunc) {
+	if d == 0 {
+		d = def
+	}
+	ctx, cancel := context.WithTimeout(ctx, d)
+	return context.WithValue(ctx, timeoutKey{}, d), cancel
+}
+
+// timedOut returns err, saying so and counting it in the <op>_timeouts
+// metric if ctx ran out of time
+func timedOut(ctx context.Context, account config.EmailAccount, op string, err error) error {
+	if err == nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	d, _ := ctx.Value(timeoutKey{}).(time.Duration)
+	metrics.Add(account.ID, op+"_timeouts", 1)
+	return fmt.Errorf("%s timed out after %s: %w", op, d, err)
+}
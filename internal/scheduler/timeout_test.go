@@ -0,0 +1,52 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/metrics"
+)
+
+// hungProvider never answers a label command
+type hungProvider struct {
+	email.Provider
+}
+
+func (hungProvider) ApplyLabels(ctx context.Context, mailbox string, uids []uint32, label string) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestActionTimeout(t *testing.T) {
+	account := config.EmailAccount{ID: "timeout-test"}
+	p := &EmailPoller{config: &config.Config{Poll: config.PollConfig{ActionTimeout: 10 * time.Millisecond}}}
+
+	start := time.Now()
+	err := p.sendBatch(context.Background(), account, hungProvider{}, batchTarget{mailbox: "INBOX", target: "Work"}, []uint32{1})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v; want a deadline error", err)
+	}
+	if !strings.Contains(err.Error(), "action timed out after 10ms") {
+		t.Errorf("err = %q; want it to name the timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("label took %s; want it cut off by the timeout", elapsed)
+	}
+	if n := metrics.Get(account.ID, "action_timeouts"); n != 1 {
+		t.Errorf("action_timeouts = %d; want 1", n)
+	}
+}
+
+func TestTimedOutIgnoresOtherErrors(t *testing.T) {
+	ctx, cancel := withTimeout(context.Background(), 0, time.Minute)
+	defer cancel()
+	want := errors.New("no such mailbox")
+	if err := timedOut(ctx, config.EmailAccount{ID: "timeout-test"}, "fetch", want); err != want {
+		t.Errorf("err = %v; want %v unchanged", err, want)
+	}
+}
@@ -0,0 +1,55 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/mshan/go-tsk/internal/actions"
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/logging"
+	"github.com/mshan/go-tsk/internal/metrics"
+)
+
+func (p *EmailPoller) unsubscribeAction(ctx context.Context, msg *email.Email, a actions.Params) error {
+	if err := p.unsubscribe(ctx, a.Account, a.Provider, a.Rule, a.Key, msg); err != nil {
+		return fmt.Errorf("failed to unsubscribe: %w", err)
+	}
+	return nil
+}
+
+// unsubscribe leaves the mailing list a message came from: with a
+// one-click POST when the list supports it, otherwise by creating a task
+// holding the mailto: or web link to unsubscribe with
+func (p *EmailPoller) unsubscribe(ctx context.Context, account config.EmailAccount, client email.Provider, rule config.Rule, key string, msg *email.Email) error {
+	full, err := client.FetchMessage(ctx, msg.Mailbox, msg.UID)
+	if err != nil {
+		return err
+	}
+	u := email.ParseUnsubscribe(full.Header)
+
+	if u.OneClick {
+		if err := p.unsubscriber.Unsubscribe(ctx, u.OneClickURL()); err != nil {
+			return err
+		}
+		metrics.Add(account.ID, "unsubscribed", 1)
+		log.Printf("Unsubscribed from the list of email with subject: %s", logging.Subject(msg.Subject))
+		return nil
+	}
+
+	var link string
+	switch {
+	case len(u.Mailtos) > 0:
+		link = u.Mailtos[0]
+	case len(u.URLs) > 0:
+		link = u.URLs[0]
+	default:
+		log.Printf("Email with subject %s offers no way to unsubscribe", logging.Subject(msg.Subject))
+		return nil
+	}
+	task := *msg
+	task.Subject = "Unsubscribe: " + link
+	// The message may have a task of its own already
+	return p.createTask(ctx, account, rule, &task, "unsubscribe:"+key)
+}
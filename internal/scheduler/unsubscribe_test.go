@@ -0,0 +1,62 @@
+package scheduler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/integrations"
+)
+
+// headerProvider serves one message with the given header
+type headerProvider struct {
+	email.Provider
+	header textproto.MIMEHeader
+}
+
+func (h *headerProvider) FetchMessage(ctx context.Context, mailbox string, uid uint32) (*email.Message, error) {
+	return &email.Message{Email: email.Email{Header: h.header}}, nil
+}
+
+func TestUnsubscribe(t *testing.T) {
+	posts := 0
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts++
+	}))
+	defer srv.Close()
+
+	p := &EmailPoller{unsubscriber: integrations.NewUnsubscriber(integrations.WithUnsubscribeClient(srv.Client()))}
+	account := config.EmailAccount{ID: "primary"}
+	rule := config.Rule{Action: "unsubscribe"}
+	msg := &email.Email{Mailbox: "INBOX", UID: 9, Subject: "Weekly deals"}
+	ctx := context.Background()
+
+	oneClick := &headerProvider{header: textproto.MIMEHeader{
+		"List-Unsubscribe":      {"<mailto:leave@lists.example.com>, <" + srv.URL + "/u/9>"},
+		"List-Unsubscribe-Post": {"List-Unsubscribe=One-Click"},
+	}}
+	if err := p.unsubscribe(ctx, account, oneClick, rule, "mid:<9@example.com>", msg); err != nil {
+		t.Fatalf("unsubscribe() error = %v", err)
+	}
+	if posts != 1 {
+		t.Errorf("POSTs = %d; want 1", posts)
+	}
+
+	none := &headerProvider{header: textproto.MIMEHeader{}}
+	if err := p.unsubscribe(ctx, account, none, rule, "mid:<9@example.com>", msg); err != nil {
+		t.Errorf("unsubscribe() of a message without List-Unsubscribe = %v; want nil", err)
+	}
+
+	// Without one-click, a task is created, which needs a store
+	mailto := &headerProvider{header: textproto.MIMEHeader{"List-Unsubscribe": {"<mailto:leave@lists.example.com>"}}}
+	if err := p.unsubscribe(ctx, account, mailto, rule, "mid:<9@example.com>", msg); err == nil {
+		t.Error("unsubscribe() by mailto without a store succeeded")
+	}
+	if posts != 1 {
+		t.Errorf("POSTs = %d after lists without one-click; want 1", posts)
+	}
+}
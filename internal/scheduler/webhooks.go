@@ -0,0 +1,87 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/integrations"
+	"github.com/mshan/go-tsk/internal/logging"
+	"github.com/mshan/go-tsk/internal/metrics"
+	"github.com/mshan/go-tsk/internal/rules"
+)
+
+// webhookRetryDelay is the wait before the first retry of a webhook
+var webhookRetryDelay = time.Second
+
+// defaultWebhookPayload is sent when a webhook rule sets no payload template
+const defaultWebhookPayload = `{"mailbox": {{json .Mailbox}}, "uid": {{.UID}}, "message_id": {{json .MessageID}}, ` +
+	`"subject": {{json .Subject}}, "from": {{json .From}}, "date": {{json .Date}}}`
+
+// ruleWebhook is the compiled payload template and endpoint of one webhook
+// rule
+type ruleWebhook struct {
+	payload *rules.Template
+	hook    *integrations.Webhook
+}
+
+// compileWebhooks prepares every webhook rule, keyed by rule index, so bad
+// templates fail at startup
+func compileWebhooks(ruleList []config.Rule) (map[int]ruleWebhook, error) {
+	compiled := make(map[int]ruleWebhook)
+	for i, rule := range ruleList {
+		// A script may return a webhook action if the rule has a URL for it
+		if !hasAction(rule, "webhook") && (rule.Script == "" || rule.WebhookURL == "") {
+			continue
+		}
+		text := rule.WebhookPayload
+		if text == "" {
+			text = defaultWebhookPayload
+		}
+		payload, err := rules.CompileTemplate(text)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: invalid webhook payload template: %w", i, err)
+		}
+
+		opts := []integrations.WebhookOption{integrations.WithWebhookHeaders(rule.WebhookHeaders)}
+		if rule.WebhookRetries > 0 {
+			opts = append(opts, integrations.WithWebhookRetries(rule.WebhookRetries, webhookRetryDelay))
+		}
+		if rule.WebhookTimeout > 0 {
+			opts = append(opts, integrations.WithWebhookTimeout(rule.WebhookTimeout))
+		}
+		hook, err := integrations.NewWebhook(rule.WebhookURL, rule.WebhookSecrets, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		compiled[i] = ruleWebhook{payload: payload, hook: hook}
+	}
+	return compiled, nil
+}
+
+// postWebhook delivers the payload of webhook rule i for a matched message
+func (p *EmailPoller) postWebhook(ctx context.Context, account config.EmailAccount, i int, msg *email.Email, key string) error {
+	wh, ok := p.webhooks[i]
+	if !ok {
+		return fmt.Errorf("rule %d has no compiled webhook", i)
+	}
+
+	payload, err := wh.payload.Render(msg)
+	if err != nil {
+		return fmt.Errorf("failed to render webhook payload: %w", err)
+	}
+	if !json.Valid([]byte(payload)) {
+		return fmt.Errorf("webhook payload is not valid JSON; use the json function for string fields")
+	}
+
+	if err := wh.hook.Post(ctx, []byte(payload), fmt.Sprintf("%s/%s/%d", account.ID, key, i)); err != nil {
+		return err
+	}
+	metrics.Add(account.ID, "webhooks_sent", 1)
+	log.Printf("Posted webhook of rule %d for email with subject %q", i, logging.Subject(msg.Subject))
+	return nil
+}
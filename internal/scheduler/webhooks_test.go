@@ -0,0 +1,60 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+func TestPostWebhook(t *testing.T) {
+	msg := &email.Email{Mailbox: "INBOX", UID: 9, Subject: `Disk "db-1" full`, From: "alerts@example.com"}
+
+	tests := []struct {
+		name       string
+		payload    string
+		want       map[string]interface{}
+		compileErr bool
+		postErr    bool
+	}{
+		{"default payload", "", map[string]interface{}{"mailbox": "INBOX", "uid": 9.0, "subject": `Disk "db-1" full`}, false, false},
+		{"custom payload", `{"text": {{json (printf "%s: %s" .From .Subject)}}}`, map[string]interface{}{"text": `alerts@example.com: Disk "db-1" full`}, false, false},
+		{"unescaped field", `{"text": "{{.Subject}}"}`, nil, false, true},
+		{"bad template", `{"text": {{json .Subject}`, nil, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]interface{}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				json.Unmarshal(body, &got)
+			}))
+			defer srv.Close()
+
+			webhooks, err := compileWebhooks([]config.Rule{{Label: "imp"}, {Action: "webhook", WebhookURL: srv.URL, WebhookPayload: tt.payload}})
+			if (err != nil) != tt.compileErr {
+				t.Fatalf("compileWebhooks() error = %v; wantErr %v", err, tt.compileErr)
+			}
+			if err != nil {
+				return
+			}
+
+			p := &EmailPoller{webhooks: webhooks}
+			err = p.postWebhook(context.Background(), config.EmailAccount{ID: "primary"}, 1, msg, "uid:INBOX:1:9")
+			if (err != nil) != tt.postErr {
+				t.Fatalf("postWebhook() error = %v; wantErr %v", err, tt.postErr)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("payload[%q] = %v; want %v", k, got[k], v)
+				}
+			}
+		})
+	}
+}
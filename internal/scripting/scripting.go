@@ -0,0 +1,260 @@
+// Package scripting runs the Starlark scripts rules can hand their actions
+// to. A script defines on_message(email) and returns the actions to run on
+// a message the rule matched, for logic too complex for declarative rules.
+//
+// Scripts are sandboxed: Starlark has no access to files or the network,
+// and every load and call runs within the time, step and allocation limits
+// of config.ScriptLimits.
+package scripting
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/metrics"
+	"time"
+
+	startime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+// Defaults for zero config.ScriptLimits
+const (
+	defaultTimeout  = time.Second
+	defaultMaxSteps = 1_000_000
+	defaultMaxAlloc = 64 << 20
+)
+
+// allocCheckInterval is how often a running script's allocations are
+// checked against its limit
+const allocCheckInterval = 10 * time.Millisecond
+
+// allocMetric counts the bytes the process has allocated on the heap
+const allocMetric = "/gc/heap/allocs:bytes"
+
+// predeclared are the modules scripts can use besides the Starlark builtins
+var predeclared = starlark.StringDict{
+	"time": startime.Module,
+}
+
+// Script is a loaded script with its on_message handler
+type Script struct {
+	path      string
+	onMessage starlark.Callable
+	limits    config.ScriptLimits
+}
+
+// Load runs the script at path, within limits, and looks up its
+// on_message function
+func Load(path string, limits config.ScriptLimits) (*Script, error) {
+	if limits.Timeout <= 0 {
+		limits.Timeout = defaultTimeout
+	}
+	if limits.MaxSteps == 0 {
+		limits.MaxSteps = defaultMaxSteps
+	}
+	if limits.MaxAlloc == 0 {
+		limits.MaxAlloc = defaultMaxAlloc
+	}
+	s := &Script{path: path, limits: limits}
+
+	var globals starlark.StringDict
+	err := s.run(context.Background(), func(thread *starlark.Thread) error {
+		var err error
+		globals, err = starlark.ExecFile(thread, path, nil, predeclared)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load script %s: %w", path, err)
+	}
+	// Frozen globals make concurrent calls for different accounts safe
+	globals.Freeze()
+
+	fn, ok := globals["on_message"].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("script %s does not define on_message(email)", path)
+	}
+	s.onMessage = fn
+	return s, nil
+}
+
+// OnMessage calls the script's handler for a message and returns the
+// actions it asks for, in order. The handler returns a list whose items
+// are action names or dicts with the keys of config.RuleAction in lower
+// case: action, label, params and on_error. None means no actions.
+func (s *Script) OnMessage(ctx context.Context, e *email.Email) ([]config.RuleAction, error) {
+	var result starlark.Value
+	err := s.run(ctx, func(thread *starlark.Thread) error {
+		var err error
+		result, err = starlark.Call(thread, s.onMessage, starlark.Tuple{emailValue(e)}, nil)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("script %s: %w", s.path, err)
+	}
+	acts, err := toActions(result)
+	if err != nil {
+		return nil, fmt.Errorf("script %s: on_message returned %w", s.path, err)
+	}
+	return acts, nil
+}
+
+// run calls fn on a fresh thread, cancelling it when ctx ends, the timeout
+// passes or the process has allocated MaxAlloc bytes since the start. The
+// allocation count covers the whole process, so it is an upper bound on
+// what the script allocated.
+func (s *Script) run(ctx context.Context, fn func(thread *starlark.Thread) error) error {
+	ctx, cancel := context.WithTimeout(ctx, s.limits.Timeout)
+	defer cancel()
+
+	thread := &starlark.Thread{
+		Name: s.path,
+		Print: func(_ *starlark.Thread, msg string) {
+			log.Printf("Script %s: %s", s.path, msg)
+		},
+	}
+	thread.SetMaxExecutionSteps(s.limits.MaxSteps)
+
+	done := make(chan struct{})
+	defer close(done)
+	start := allocated()
+	go func() {
+		ticker := time.NewTicker(allocCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				thread.Cancel(ctx.Err().Error())
+				return
+			case <-ticker.C:
+				if allocated()-start > s.limits.MaxAlloc {
+					thread.Cancel("allocation limit exceeded")
+					return
+				}
+			}
+		}
+	}()
+	return fn(thread)
+}
+
+// allocated returns the bytes the process has allocated on the heap so far
+func allocated() uint64 {
+	sample := []metrics.Sample{{Name: allocMetric}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return sample[0].Value.Uint64()
+}
+
+// emailValue returns the fields of an email as scripts see them, named as
+// in rule conditions except for from, a keyword in Starlark
+func emailValue(e *email.Email) *starlarkstruct.Struct {
+	flags := make([]starlark.Value, len(e.Flags))
+	for i, f := range e.Flags {
+		flags[i] = starlark.String(f)
+	}
+	return starlarkstruct.FromStringDict(starlark.String("email"), starlark.StringDict{
+		"mailbox":    starlark.String(e.Mailbox),
+		"uid":        starlark.MakeUint64(uint64(e.UID)),
+		"message_id": starlark.String(e.MessageID),
+		"subject":    starlark.String(e.Subject),
+		"sender":     starlark.String(e.From),
+		"date":       startime.Time(e.Date),
+		"flags":      starlark.NewList(flags),
+		"text_body":  starlark.String(e.TextBody),
+		"html_body":  starlark.String(e.HTMLBody),
+		"size":       starlark.MakeUint64(uint64(e.Size)),
+	})
+}
+
+// toActions converts what on_message returned to rule actions
+func toActions(v starlark.Value) ([]config.RuleAction, error) {
+	if v == starlark.None {
+		return nil, nil
+	}
+	var seq starlark.Indexable
+	switch v := v.(type) {
+	case *starlark.List:
+		seq = v
+	case starlark.Tuple:
+		seq = v
+	default:
+		return nil, fmt.Errorf("%s, not a list of actions", v.Type())
+	}
+	acts := make([]config.RuleAction, seq.Len())
+	for i := range acts {
+		switch item := seq.Index(i).(type) {
+		case starlark.String:
+			acts[i].Action = string(item)
+		case *starlark.Dict:
+			a, err := dictAction(item)
+			if err != nil {
+				return nil, fmt.Errorf("action %d: %w", i, err)
+			}
+			acts[i] = a
+		default:
+			return nil, fmt.Errorf("action %d: %s, not a name or dict", i, item.Type())
+		}
+	}
+	return acts, nil
+}
+
+// dictAction converts a dict such as {"action": "label", "label": "Bills"}
+// to a rule action
+func dictAction(d *starlark.Dict) (config.RuleAction, error) {
+	var a config.RuleAction
+	for _, item := range d.Items() {
+		key, ok := starlark.AsString(item[0])
+		if !ok {
+			return a, fmt.Errorf("key %s is not a string", item[0])
+		}
+		if key == "params" {
+			params, err := stringDict(item[1])
+			if err != nil {
+				return a, fmt.Errorf("params: %w", err)
+			}
+			a.Params = params
+			continue
+		}
+		value, ok := starlark.AsString(item[1])
+		if !ok {
+			return a, fmt.Errorf("%s must be a string, not %s", key, item[1].Type())
+		}
+		switch key {
+		case "action":
+			a.Action = value
+		case "label":
+			a.Label = value
+		case "on_error":
+			a.OnError = value
+		default:
+			return a, fmt.Errorf("unknown key %q", key)
+		}
+	}
+	return a, nil
+}
+
+// stringDict converts a dict of strings
+func stringDict(v starlark.Value) (map[string]string, error) {
+	d, ok := v.(*starlark.Dict)
+	if !ok {
+		return nil, fmt.Errorf("%s, not a dict", v.Type())
+	}
+	m := make(map[string]string, d.Len())
+	for _, item := range d.Items() {
+		key, ok1 := starlark.AsString(item[0])
+		value, ok2 := starlark.AsString(item[1])
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("%s: %s is not a string", item[0], item[1])
+		}
+		m[key] = value
+	}
+	return m, nil
+}
@@ -0,0 +1,111 @@
+package scripting
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+// writeScript saves a script in a temporary directory and returns its path
+func writeScript(t *testing.T, src string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hook.star")
+	if err := os.WriteFile(path, []byte(src), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestOnMessage(t *testing.T) {
+	const src = `
+BANKS = ["@bank.com>", "@credit.example>"]
+
+def on_message(email):
+    if not any([email.sender.endswith(b) for b in BANKS]):
+        return None
+    acts = [{"action": "label", "label": "Bank", "on_error": "continue"}]
+    if "overdue" in email.subject.lower() and email.date.year >= 2024:
+        acts.append({"action": "slack", "params": {"channel": "#money"}})
+    if email.size > 1000000:
+        acts.append("archive")
+    return acts
+`
+	s, err := Load(writeScript(t, src), config.ScriptLimits{})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	date := time.Date(2024, 3, 5, 9, 15, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		msg  email.Email
+		want []config.RuleAction
+	}{
+		{"other sender", email.Email{From: "Shop <news@shop.example>"}, nil},
+		{"statement", email.Email{From: "Bank <alerts@bank.com>", Subject: "Statement", Date: date},
+			[]config.RuleAction{{Action: "label", Label: "Bank", OnError: "continue"}}},
+		{"overdue", email.Email{From: "Bank <alerts@bank.com>", Subject: "Payment OVERDUE", Date: date, Size: 2000000},
+			[]config.RuleAction{
+				{Action: "label", Label: "Bank", OnError: "continue"},
+				{Action: "slack", Params: map[string]string{"channel": "#money"}},
+				{Action: "archive"},
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.OnMessage(context.Background(), &tt.msg)
+			if err != nil {
+				t.Fatalf("OnMessage: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("actions = %+v; want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScriptErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		limits  config.ScriptLimits
+		loadErr string
+		callErr string
+	}{
+		{"syntax error", "def on_message(email)\n    return []\n", config.ScriptLimits{}, "hook.star", ""},
+		{"no handler", "x = 1\n", config.ScriptLimits{}, "does not define on_message", ""},
+		{"file access", "load('os.star', 'os')\n", config.ScriptLimits{}, "load not implemented", ""},
+		{"wrong result", "def on_message(email):\n    return 'label'\n", config.ScriptLimits{}, "", "not a list"},
+		{"unknown key", "def on_message(email):\n    return [{'action': 'label', 'labels': 'x'}]\n", config.ScriptLimits{}, "", `unknown key "labels"`},
+		{"step limit", "def on_message(email):\n    for i in range(1000000):\n        pass\n", config.ScriptLimits{MaxSteps: 10000}, "", "too many steps"},
+		{"timeout", "def on_message(email):\n    for i in range(100000000):\n        pass\n", config.ScriptLimits{Timeout: 20 * time.Millisecond, MaxSteps: 1 << 40}, "", "deadline exceeded"},
+		{"allocation limit", "def on_message(email):\n    l = []\n    for i in range(100000000):\n        l.append('x' * 1000 + str(i))\n",
+			config.ScriptLimits{Timeout: time.Minute, MaxSteps: 1 << 40, MaxAlloc: 1 << 20}, "", "allocation limit exceeded"},
+		{"load limit", "x = [i for i in range(1000000)]\ndef on_message(email):\n    return []\n", config.ScriptLimits{MaxSteps: 10000}, "too many steps", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Load(writeScript(t, tt.src), tt.limits)
+			if tt.loadErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.loadErr) {
+					t.Fatalf("Load error = %v; want %q", err, tt.loadErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			_, err = s.OnMessage(context.Background(), &email.Email{Subject: "Hi"})
+			if err == nil || !strings.Contains(err.Error(), tt.callErr) {
+				t.Errorf("OnMessage error = %v; want %q", err, tt.callErr)
+			}
+		})
+	}
+}
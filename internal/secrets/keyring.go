@@ -0,0 +1,105 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/99designs/keyring"
+)
+
+// KeyringScheme prefixes config values stored in the OS keychain, e.g.
+// "keyring:gmail-token"
+const KeyringScheme = "keyring"
+
+// keyringService names the keychain entries go-tsk stores
+const keyringService = "go-tsk"
+
+// ErrSecretNotFound is returned for a secret that is not stored
+var ErrSecretNotFound = errors.New("secret not found")
+
+// Keyring stores secrets in the OS keychain: macOS Keychain, Windows
+// Credential Manager or the Secret Service (GNOME Keyring, KWallet). The
+// keychain is opened on first use, as opening it may prompt the user.
+type Keyring struct {
+	once sync.Once
+	ring keyring.Keyring
+	err  error
+	open func() (keyring.Keyring, error)
+}
+
+// NewKeyring creates a resolver over the OS keychain
+func NewKeyring() *Keyring {
+	return &Keyring{open: func() (keyring.Keyring, error) {
+		return keyring.Open(keyring.Config{
+			ServiceName: keyringService,
+			AllowedBackends: []keyring.BackendType{
+				keyring.KeychainBackend,
+				keyring.WinCredBackend,
+				keyring.SecretServiceBackend,
+				keyring.KWalletBackend,
+			},
+			KeychainTrustApplication: true,
+		})
+	}}
+}
+
+// keyring opens the keychain once
+func (k *Keyring) keyring() (keyring.Keyring, error) {
+	k.once.Do(func() {
+		if k.ring, k.err = k.open(); k.err != nil {
+			k.err = fmt.Errorf("failed to open the OS keychain: %w", k.err)
+		}
+	})
+	return k.ring, k.err
+}
+
+// Lookup returns the secret stored under name
+func (k *Keyring) Lookup(ctx context.Context, name string) (string, error) {
+	ring, err := k.keyring()
+	if err != nil {
+		return "", err
+	}
+	item, err := ring.Get(name)
+	if errors.Is(err, keyring.ErrKeyNotFound) {
+		return "", ErrSecretNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(item.Data), nil
+}
+
+// Set stores a secret under name, replacing any stored before
+func (k *Keyring) Set(name, secret string) error {
+	ring, err := k.keyring()
+	if err != nil {
+		return err
+	}
+	return ring.Set(keyring.Item{
+		Key:   name,
+		Data:  []byte(secret),
+		Label: keyringService + " " + name,
+	})
+}
+
+// Remove deletes the secret stored under name. Backends differ in whether
+// removing a missing key fails, so it is looked up first.
+func (k *Keyring) Remove(name string) error {
+	ring, err := k.keyring()
+	if err != nil {
+		return err
+	}
+	if _, err := ring.Get(name); errors.Is(err, keyring.ErrKeyNotFound) {
+		return ErrSecretNotFound
+	} else if err != nil {
+		return err
+	}
+	if err := ring.Remove(name); errors.Is(err, keyring.ErrKeyNotFound) {
+		return ErrSecretNotFound
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
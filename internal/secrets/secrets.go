@@ -0,0 +1,83 @@
+// Package secrets resolves credentials that the config refers to instead
+// of spelling out
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+// Resolver looks up secrets of one reference scheme
+type Resolver interface {
+	// Lookup returns the secret ref refers to; ref is the reference
+	// without its "scheme:" prefix
+	Lookup(ctx context.Context, ref string) (string, error)
+}
+
+// field is a config setting that may hold a secret reference
+type field struct {
+	name  string
+	value *string
+}
+
+// fields returns the settings of cfg that may hold secret references
+func fields(cfg *config.Config) []field {
+	f := []field{
+		{"Integrations.Todoist.Token", &cfg.Integrations.Todoist.Token},
+		{"Integrations.GitHub.Token", &cfg.Integrations.GitHub.Token},
+		{"Integrations.Jira.Token", &cfg.Integrations.Jira.Token},
+		{"Integrations.CalDAV.Password", &cfg.Integrations.CalDAV.Password},
+		{"Integrations.Ntfy.Token", &cfg.Integrations.Ntfy.Token},
+		{"Integrations.Pushover.AppToken", &cfg.Integrations.Pushover.AppToken},
+		{"Integrations.Pushover.UserKey", &cfg.Integrations.Pushover.UserKey},
+		{"API.Token", &cfg.API.Token},
+	}
+	for i := range cfg.Notify.Channels {
+		channel := &cfg.Notify.Channels[i]
+		f = append(f,
+			field{"channel " + channel.Name + " SMTPPassword", &channel.SMTPPassword},
+			field{"channel " + channel.Name + " SlackWebhookURL", &channel.SlackWebhookURL},
+		)
+	}
+	for i := range cfg.AddressLists {
+		list := &cfg.AddressLists[i]
+		f = append(f,
+			field{"address list " + list.Name + " CardDAV.Password", &list.CardDAV.Password},
+			field{"address list " + list.Name + " CardDAV.Token", &list.CardDAV.Token},
+		)
+	}
+	for i := range cfg.EmailAccounts {
+		account := &cfg.EmailAccounts[i]
+		f = append(f,
+			field{"account " + account.ID + " ClientSecret", &account.ClientSecret},
+			field{"account " + account.ID + " Token", &account.Token},
+			field{"account " + account.ID + " Password", &account.Password},
+		)
+	}
+	return f
+}
+
+// Resolve replaces every credential in cfg written as "scheme:ref", for a
+// scheme in resolvers, with the secret it refers to. Other values are
+// left as they are.
+func Resolve(ctx context.Context, cfg *config.Config, resolvers map[string]Resolver) error {
+	for _, f := range fields(cfg) {
+		scheme, ref, ok := strings.Cut(*f.value, ":")
+		if !ok {
+			continue
+		}
+		r, ok := resolvers[scheme]
+		if !ok {
+			continue
+		}
+		secret, err := r.Lookup(ctx, ref)
+		if err != nil {
+			return fmt.Errorf("%s: failed to resolve %s secret %q: %w", f.name, scheme, ref, err)
+		}
+		*f.value = secret
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/99designs/keyring"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+// newTestKeyring returns a Keyring over an in-memory keychain
+func newTestKeyring(items ...keyring.Item) *Keyring {
+	ring := keyring.NewArrayKeyring(items)
+	return &Keyring{open: func() (keyring.Keyring, error) { return ring, nil }}
+}
+
+func TestResolve(t *testing.T) {
+	ring := newTestKeyring(
+		keyring.Item{Key: "gmail-token", Data: []byte("ya29.secret")},
+		keyring.Item{Key: "github", Data: []byte("ghp_secret")},
+	)
+	resolvers := map[string]Resolver{KeyringScheme: ring}
+
+	tests := []struct {
+		name    string
+		token   string
+		github  string
+		want    string
+		wantErr bool
+	}{
+		{"plain values", "ya29.plain", "ghp_plain", "ya29.plain", false},
+		{"keyring references", "keyring:gmail-token", "keyring:github", "ya29.secret", false},
+		{"unknown scheme", "vault:gmail", "", "vault:gmail", false},
+		{"missing secret", "keyring:nosuch", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.EmailAccounts[0].Token = tt.token
+			cfg.Integrations.GitHub.Token = tt.github
+
+			err := Resolve(context.Background(), cfg, resolvers)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve() error = %v; wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if !errors.Is(err, ErrSecretNotFound) {
+					t.Errorf("Resolve() error = %v; want ErrSecretNotFound", err)
+				}
+				return
+			}
+			if got := cfg.EmailAccounts[0].Token; got != tt.want {
+				t.Errorf("Token = %q; want %q", got, tt.want)
+			}
+			if tt.github == "keyring:github" && cfg.Integrations.GitHub.Token != "ghp_secret" {
+				t.Errorf("GitHub token = %q; want the stored secret", cfg.Integrations.GitHub.Token)
+			}
+		})
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	k := newTestKeyring()
+	ctx := context.Background()
+
+	if err := k.Set("token", "s3cret"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if got, err := k.Lookup(ctx, "token"); err != nil || got != "s3cret" {
+		t.Errorf("Lookup() = %q, %v; want s3cret", got, err)
+	}
+	if err := k.Remove("token"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if _, err := k.Lookup(ctx, "token"); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("Lookup() after Remove() error = %v; want ErrSecretNotFound", err)
+	}
+	if err := k.Remove("token"); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("second Remove() error = %v; want ErrSecretNotFound", err)
+	}
+
+	failing := &Keyring{open: func() (keyring.Keyring, error) { return nil, keyring.ErrNoAvailImpl }}
+	if _, err := failing.Lookup(ctx, "token"); !errors.Is(err, keyring.ErrNoAvailImpl) {
+		t.Errorf("Lookup() without a keychain error = %v; want ErrNoAvailImpl", err)
+	}
+}
@@ -0,0 +1,266 @@
+package secrets
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/oauth2"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+// PassphraseEnv holds the token file passphrase when its key is
+// "passphrase"
+const PassphraseEnv = "GO_TSK_PASSPHRASE"
+
+// tokenFileVersion is the format of the token files written
+const tokenFileVersion = 1
+
+// scrypt parameters deriving the token file key
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// machineIDPaths hold the machine ID on Linux
+var machineIDPaths = []string{"/etc/machine-id", "/var/lib/dbus/machine-id"}
+
+// TokenFile stores OAuth tokens by account ID in a file encrypted with
+// NaCl secretbox, under a key derived with scrypt from a passphrase or
+// from the identity of the machine and user
+type TokenFile struct {
+	path   string
+	secret func() ([]byte, error)
+	mu     sync.Mutex // Serializes read-modify-write cycles
+}
+
+// tokenFileData is the on-disk format of a token file
+type tokenFileData struct {
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Box     []byte `json:"box"` // The sealed JSON map of account ID to token
+}
+
+// NewTokenFile opens the token file in cfg; nothing is read until first
+// use
+func NewTokenFile(cfg config.TokenFileConfig) *TokenFile {
+	secret := machineSecret
+	if cfg.Key == "passphrase" {
+		secret = envPassphrase
+	}
+	return &TokenFile{path: cfg.Path, secret: secret}
+}
+
+// envPassphrase reads the passphrase from $GO_TSK_PASSPHRASE
+func envPassphrase() ([]byte, error) {
+	passphrase := os.Getenv(PassphraseEnv)
+	if passphrase == "" {
+		return nil, fmt.Errorf("the token file passphrase must be set in $%s", PassphraseEnv)
+	}
+	return []byte(passphrase), nil
+}
+
+// machineSecret identifies this machine and user. It keeps a copied token
+// file from being read elsewhere, but not from other programs of the same
+// user.
+func machineSecret() ([]byte, error) {
+	var id string
+	for _, path := range machineIDPaths {
+		if b, err := os.ReadFile(path); err == nil && len(strings.TrimSpace(string(b))) > 0 {
+			id = strings.TrimSpace(string(b))
+			break
+		}
+	}
+	if id == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to identify this machine: %w", err)
+		}
+		id = host
+	}
+	u, err := user.Current()
+	if err != nil {
+		return nil, fmt.Errorf("failed to identify the current user: %w", err)
+	}
+	return []byte("go-tsk\x00" + id + "\x00" + u.Uid), nil
+}
+
+// deriveKey derives the secretbox key from the file's secret and salt
+func (f *TokenFile) deriveKey(salt []byte) (*[32]byte, error) {
+	secret, err := f.secret()
+	if err != nil {
+		return nil, err
+	}
+	b, err := scrypt.Key(secret, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	var key [32]byte
+	copy(key[:], b)
+	return &key, nil
+}
+
+// load decrypts the tokens in the file; a missing file holds none. f.mu
+// must be held.
+func (f *TokenFile) load() (map[string]*oauth2.Token, error) {
+	b, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return make(map[string]*oauth2.Token), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token file: %w", err)
+	}
+
+	var data tokenFileData
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, fmt.Errorf("invalid token file %s: %w", f.path, err)
+	}
+	if data.Version != tokenFileVersion || len(data.Nonce) != 24 {
+		return nil, fmt.Errorf("unsupported token file %s (version %d)", f.path, data.Version)
+	}
+	key, err := f.deriveKey(data.Salt)
+	if err != nil {
+		return nil, err
+	}
+	var nonce [24]byte
+	copy(nonce[:], data.Nonce)
+	plain, ok := secretbox.Open(nil, data.Box, &nonce, key)
+	if !ok {
+		return nil, fmt.Errorf("failed to decrypt token file %s: wrong passphrase, or written on another machine or by another user", f.path)
+	}
+
+	tokens := make(map[string]*oauth2.Token)
+	if err := json.Unmarshal(plain, &tokens); err != nil {
+		return nil, fmt.Errorf("invalid token file %s: %w", f.path, err)
+	}
+	return tokens, nil
+}
+
+// store encrypts tokens with a fresh salt and nonce and replaces the file
+// atomically; f.mu must be held
+func (f *TokenFile) store(tokens map[string]*oauth2.Token) error {
+	plain, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+	data := tokenFileData{Version: tokenFileVersion, Salt: make([]byte, 16), Nonce: make([]byte, 24)}
+	if _, err := rand.Read(data.Salt); err != nil {
+		return err
+	}
+	if _, err := rand.Read(data.Nonce); err != nil {
+		return err
+	}
+	key, err := f.deriveKey(data.Salt)
+	if err != nil {
+		return err
+	}
+	var nonce [24]byte
+	copy(nonce[:], data.Nonce)
+	data.Box = secretbox.Seal(nil, plain, &nonce, key)
+
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write token file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write token file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write token file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return fmt.Errorf("failed to write token file: %w", err)
+	}
+	return nil
+}
+
+// Token returns the token stored for an account
+func (f *TokenFile) Token(accountID string) (*oauth2.Token, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tokens, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	tok, ok := tokens[accountID]
+	if !ok {
+		return nil, fmt.Errorf("token of account %s: %w", accountID, ErrSecretNotFound)
+	}
+	return tok, nil
+}
+
+// Save stores the token of an account, replacing any stored before
+func (f *TokenFile) Save(accountID string, tok *oauth2.Token) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tokens, err := f.load()
+	if err != nil {
+		return err
+	}
+	tokens[accountID] = tok
+	return f.store(tokens)
+}
+
+// TokenSource returns the stored token of an account, refreshed through
+// conf when it expires. Refreshed tokens are saved back to the file.
+func (f *TokenFile) TokenSource(ctx context.Context, conf *oauth2.Config, accountID string) (oauth2.TokenSource, error) {
+	tok, err := f.Token(accountID)
+	if err != nil {
+		return nil, err
+	}
+	return &savingTokenSource{
+		src:       conf.TokenSource(ctx, tok),
+		file:      f,
+		accountID: accountID,
+		last:      tok.AccessToken,
+	}, nil
+}
+
+// savingTokenSource saves the tokens of src to a token file as they change
+type savingTokenSource struct {
+	src       oauth2.TokenSource
+	file      *TokenFile
+	accountID string
+
+	mu   sync.Mutex
+	last string // Access token last saved
+}
+
+func (s *savingTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := s.src.Token()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tok.AccessToken != s.last {
+		// The refreshed token is still good for this run, so a failed save
+		// only costs a refresh after a restart
+		if err := s.file.Save(s.accountID, tok); err != nil {
+			log.Printf("Failed to save the refreshed token of account %s: %v", s.accountID, err)
+		}
+		s.last = tok.AccessToken
+	}
+	return tok, nil
+}
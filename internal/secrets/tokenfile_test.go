@@ -0,0 +1,125 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+func TestTokenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.enc")
+	t.Setenv(PassphraseEnv, "correct horse")
+	f := NewTokenFile(config.TokenFileConfig{Path: path, Key: "passphrase"})
+
+	if _, err := f.Token("primary"); !errors.Is(err, ErrSecretNotFound) {
+		t.Fatalf("Token() before Save() error = %v; want ErrSecretNotFound", err)
+	}
+	tok := &oauth2.Token{AccessToken: "ya29.access", RefreshToken: "1//refresh", Expiry: time.Now().Add(time.Hour).Round(0)}
+	if err := f.Save("primary", tok); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if err := f.Save("work", &oauth2.Token{AccessToken: "ya29.work"}); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	got, err := f.Token("primary")
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if got.AccessToken != tok.AccessToken || got.RefreshToken != tok.RefreshToken || !got.Expiry.Equal(tok.Expiry) {
+		t.Errorf("Token() = %+v; want %+v", got, tok)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "refresh") {
+		t.Error("token file holds the refresh token in the clear")
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("token file mode = %v, %v; want 0600", info.Mode().Perm(), err)
+	}
+
+	t.Setenv(PassphraseEnv, "wrong")
+	if _, err := f.Token("primary"); err == nil {
+		t.Error("Token() with the wrong passphrase succeeded")
+	}
+	t.Setenv(PassphraseEnv, "")
+	if _, err := f.Token("primary"); err == nil {
+		t.Error("Token() without a passphrase succeeded")
+	}
+}
+
+func TestTokenFileMachineKey(t *testing.T) {
+	f := NewTokenFile(config.TokenFileConfig{Path: filepath.Join(t.TempDir(), "tokens.enc")})
+	if err := f.Save("primary", &oauth2.Token{AccessToken: "ya29.access"}); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if got, err := f.Token("primary"); err != nil || got.AccessToken != "ya29.access" {
+		t.Errorf("Token() = %v, %v; want the saved token", got, err)
+	}
+
+	// Another machine derives another key
+	f.secret = func() ([]byte, error) { return []byte("elsewhere"), nil }
+	if _, err := f.Token("primary"); err == nil {
+		t.Error("Token() with another machine's key succeeded")
+	}
+}
+
+func TestTokenSourceSavesRefreshedTokens(t *testing.T) {
+	refreshes := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		refreshes++
+		r.ParseForm()
+		if r.Form.Get("refresh_token") != "1//refresh" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"ya29.fresh%d","token_type":"Bearer","expires_in":3600}`, refreshes)
+	}))
+	defer srv.Close()
+	conf := &oauth2.Config{ClientID: "id", ClientSecret: "secret", Endpoint: oauth2.Endpoint{TokenURL: srv.URL}}
+
+	f := NewTokenFile(config.TokenFileConfig{Path: filepath.Join(t.TempDir(), "tokens.enc")})
+	expired := &oauth2.Token{AccessToken: "ya29.stale", RefreshToken: "1//refresh", Expiry: time.Now().Add(-time.Minute)}
+	if err := f.Save("primary", expired); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	ts, err := f.TokenSource(context.Background(), conf, "primary")
+	if err != nil {
+		t.Fatalf("TokenSource() error = %v", err)
+	}
+	tok, err := ts.Token()
+	if err != nil || tok.AccessToken != "ya29.fresh1" {
+		t.Fatalf("Token() = %v, %v; want the refreshed token", tok, err)
+	}
+	if _, err := ts.Token(); err != nil || refreshes != 1 {
+		t.Errorf("second Token() refreshed again (%d refreshes, error %v)", refreshes, err)
+	}
+
+	saved, err := f.Token("primary")
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if saved.AccessToken != "ya29.fresh1" || saved.RefreshToken != "1//refresh" {
+		t.Errorf("saved token = %+v; want the refreshed token with the refresh token kept", saved)
+	}
+
+	if _, err := f.TokenSource(context.Background(), conf, "nosuch"); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("TokenSource() of an unknown account error = %v; want ErrSecretNotFound", err)
+	}
+}
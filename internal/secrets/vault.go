@@ -0,0 +1,317 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+// VaultScheme prefixes config values stored in HashiCorp Vault, e.g.
+// "vault://secret/data/gmail#token"
+const VaultScheme = "vault"
+
+// serviceAccountTokenPath is where Kubernetes mounts the pod's service
+// account token
+const serviceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// renewCheckInterval bounds how long the renewal loop sleeps, so leases
+// added by a reload are picked up
+const renewCheckInterval = time.Minute
+
+// ErrVaultNotConfigured is returned for vault references without a
+// Secrets.Vault.Address
+var ErrVaultNotConfigured = errors.New("Secrets.Vault.Address is not set")
+
+// lease is a Vault lease kept alive by Renew
+type lease struct {
+	id        string
+	ttl       time.Duration
+	renewable bool
+	expires   time.Time
+	renewAt   time.Time
+}
+
+// newLease starts tracking a lease granted at now; renewal is due after
+// two thirds of its TTL
+func newLease(id string, ttl time.Duration, renewable bool, now time.Time) *lease {
+	return &lease{
+		id:        id,
+		ttl:       ttl,
+		renewable: renewable,
+		expires:   now.Add(ttl),
+		renewAt:   now.Add(ttl * 2 / 3),
+	}
+}
+
+// vaultSecret is a secret read from Vault along with its lease
+type vaultSecret struct {
+	data  map[string]interface{}
+	lease *lease // nil for secrets without a lease, such as KV entries
+}
+
+// Vault reads secrets from HashiCorp Vault over its HTTP API. Secrets with
+// a lease are cached until it expires, so the fields of one dynamic
+// secret stay consistent, and Renew keeps the leases and the token alive.
+type Vault struct {
+	cfg     config.VaultConfig
+	client  *http.Client
+	now     func() time.Time
+	jwtPath string
+
+	mu      sync.Mutex
+	token   string
+	auth    *lease                  // The token's own lease; nil for tokens that do not expire
+	secrets map[string]*vaultSecret // By path
+}
+
+// NewVault creates a resolver over the Vault server in cfg. It logs in on
+// first use.
+func NewVault(cfg config.VaultConfig) *Vault {
+	return &Vault{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		now:     time.Now,
+		jwtPath: serviceAccountTokenPath,
+		secrets: make(map[string]*vaultSecret),
+	}
+}
+
+// Lookup returns a field of a Vault secret; ref is "//path#field", e.g.
+// "//secret/data/gmail#token". KV version 2 secrets are unwrapped.
+func (v *Vault) Lookup(ctx context.Context, ref string) (string, error) {
+	path, key, ok := strings.Cut(strings.TrimPrefix(ref, "//"), "#")
+	if !ok || path == "" || key == "" {
+		return "", fmt.Errorf("invalid vault reference %q: want vault://path#field", ref)
+	}
+	if v.cfg.Address == "" {
+		return "", ErrVaultNotConfigured
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	secret, err := v.read(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	value, ok := secret.data[key]
+	if !ok {
+		return "", fmt.Errorf("field %q: %w", key, ErrSecretNotFound)
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("field %q is not a string", key)
+	}
+	return s, nil
+}
+
+// read returns the secret at path, from the cache while its lease lasts;
+// v.mu must be held
+func (v *Vault) read(ctx context.Context, path string) (*vaultSecret, error) {
+	now := v.now()
+	if cached, ok := v.secrets[path]; ok && cached.lease != nil && now.Before(cached.lease.expires) {
+		return cached, nil
+	}
+	if err := v.login(ctx); err != nil {
+		return nil, err
+	}
+
+	var resp vaultResponse
+	if err := v.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	data := resp.Data
+	// KV version 2 nests the fields under data, next to the metadata
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = inner
+		}
+	}
+
+	secret := &vaultSecret{data: data}
+	if resp.LeaseID != "" {
+		secret.lease = newLease(resp.LeaseID, time.Duration(resp.LeaseDuration)*time.Second, resp.Renewable, now)
+	}
+	// A new lease replaces the one read before, which is left to expire
+	v.secrets[path] = secret
+	return secret, nil
+}
+
+// login gets a token unless there is one still valid; v.mu must be held
+func (v *Vault) login(ctx context.Context) error {
+	if v.token != "" && (v.auth == nil || v.now().Before(v.auth.expires)) {
+		return nil
+	}
+	if v.cfg.KubernetesRole == "" {
+		v.token = v.cfg.Token
+		return nil
+	}
+
+	jwt, err := os.ReadFile(v.jwtPath)
+	if err != nil {
+		return fmt.Errorf("failed to read the service account token: %w", err)
+	}
+	mount := v.cfg.KubernetesMount
+	if mount == "" {
+		mount = "kubernetes"
+	}
+	body := map[string]string{"role": v.cfg.KubernetesRole, "jwt": strings.TrimSpace(string(jwt))}
+
+	v.token = ""
+	var resp vaultResponse
+	if err := v.do(ctx, http.MethodPost, "auth/"+mount+"/login", body, &resp); err != nil {
+		return fmt.Errorf("vault login failed: %w", err)
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return fmt.Errorf("vault login returned no token")
+	}
+	v.token = resp.Auth.ClientToken
+	v.auth = nil
+	if resp.Auth.LeaseDuration > 0 {
+		v.auth = newLease("", time.Duration(resp.Auth.LeaseDuration)*time.Second, resp.Auth.Renewable, v.now())
+	}
+	return nil
+}
+
+// Renew keeps the token and the leases of the secrets read alive until ctx
+// is done. Leases that cannot be renewed any more expire; the secrets they
+// cover are read again on the next reload.
+func (v *Vault) Renew(ctx context.Context) {
+	if v.cfg.Address == "" {
+		return
+	}
+	for {
+		wait := v.renewDue(ctx)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// renewDue renews the leases due for renewal and returns how long until
+// the next one is
+func (v *Vault) renewDue(ctx context.Context) time.Duration {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := v.now()
+	next := now.Add(renewCheckInterval)
+	if v.auth != nil && v.auth.renewable && !now.Before(v.auth.renewAt) {
+		var resp vaultResponse
+		if err := v.do(ctx, http.MethodPost, "auth/token/renew-self", map[string]int{"increment": int(v.auth.ttl.Seconds())}, &resp); err != nil || resp.Auth == nil {
+			log.Printf("Failed to renew the Vault token: %v", err)
+			// Retry on the next check; past its expiry, login starts over
+			v.auth.renewAt = now.Add(renewCheckInterval)
+		} else {
+			v.auth = newLease("", time.Duration(resp.Auth.LeaseDuration)*time.Second, resp.Auth.Renewable, now)
+		}
+	}
+	if v.auth != nil && v.auth.renewable && v.auth.renewAt.Before(next) {
+		next = v.auth.renewAt
+	}
+
+	for path, secret := range v.secrets {
+		l := secret.lease
+		if l == nil || !l.renewable {
+			continue
+		}
+		if !now.Before(l.expires) {
+			log.Printf("Vault lease of %s expired; reload the config to read it again", path)
+			delete(v.secrets, path)
+			continue
+		}
+		if !now.Before(l.renewAt) {
+			var resp vaultResponse
+			body := map[string]interface{}{"lease_id": l.id, "increment": int(l.ttl.Seconds())}
+			if err := v.do(ctx, http.MethodPut, "sys/leases/renew", body, &resp); err != nil {
+				log.Printf("Failed to renew the Vault lease of %s: %v", path, err)
+				l.renewAt = now.Add(renewCheckInterval)
+			} else {
+				secret.lease = newLease(l.id, time.Duration(resp.LeaseDuration)*time.Second, resp.Renewable, now)
+				l = secret.lease
+			}
+		}
+		if l.renewable && l.renewAt.Before(next) {
+			next = l.renewAt
+		}
+	}
+
+	if wait := next.Sub(now); wait > time.Second {
+		return wait
+	}
+	return time.Second
+}
+
+// vaultResponse is the part of Vault's response envelope go-tsk uses
+type vaultResponse struct {
+	LeaseID       string                 `json:"lease_id"`
+	LeaseDuration int                    `json:"lease_duration"`
+	Renewable     bool                   `json:"renewable"`
+	Data          map[string]interface{} `json:"data"`
+	Auth          *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+		Renewable     bool   `json:"renewable"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+// do sends a request to the Vault API at path and decodes the response
+// into out
+func (v *Vault) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	url := strings.TrimSuffix(v.cfg.Address, "/") + "/v1/" + strings.TrimPrefix(path, "/")
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	if v.token != "" {
+		req.Header.Set("X-Vault-Token", v.token)
+	}
+	if v.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.cfg.Namespace)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s: %w", path, ErrSecretNotFound)
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("vault returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out); err != nil {
+		return fmt.Errorf("invalid vault response: %w", err)
+	}
+	return nil
+}
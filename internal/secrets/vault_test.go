@@ -0,0 +1,180 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+// fakeVault serves a KV version 2 secret, a dynamic secret with a lease and
+// Kubernetes logins
+type fakeVault struct {
+	mu       sync.Mutex
+	reads    int
+	renewals []string
+	tokens   []string // X-Vault-Token of each request
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tokens = append(f.tokens, r.Header.Get("X-Vault-Token"))
+
+	var resp interface{}
+	switch r.URL.Path {
+	case "/v1/auth/kubernetes/login":
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["role"] != "go-tsk" || body["jwt"] != "service-account-jwt" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		resp = map[string]interface{}{"auth": map[string]interface{}{
+			"client_token": "s.login", "lease_duration": 3600, "renewable": true,
+		}}
+	case "/v1/secret/data/gmail":
+		resp = map[string]interface{}{"data": map[string]interface{}{
+			"data":     map[string]interface{}{"token": "ya29.vault", "client_secret": "shh"},
+			"metadata": map[string]interface{}{"version": 3},
+		}}
+	case "/v1/database/creds/app":
+		f.reads++
+		resp = map[string]interface{}{
+			"lease_id": "database/creds/app/abc", "lease_duration": 300, "renewable": true,
+			"data": map[string]interface{}{"username": "v-app", "password": "pw"},
+		}
+	case "/v1/sys/leases/renew":
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.renewals = append(f.renewals, body["lease_id"].(string))
+		resp = map[string]interface{}{"lease_id": body["lease_id"], "lease_duration": 300, "renewable": true}
+	case "/v1/auth/token/renew-self":
+		f.renewals = append(f.renewals, "token")
+		resp = map[string]interface{}{"auth": map[string]interface{}{
+			"client_token": "s.login", "lease_duration": 3600, "renewable": true,
+		}}
+	default:
+		http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// newTestVault returns a Vault talking to a fake server through a clock
+// the test controls
+func newTestVault(t *testing.T, cfg config.VaultConfig) (*Vault, *fakeVault, *time.Time) {
+	t.Helper()
+	fake := &fakeVault{}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	cfg.Address = srv.URL
+	v := NewVault(cfg)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v.now = func() time.Time { return now }
+	return v, fake, &now
+}
+
+func TestVaultLookup(t *testing.T) {
+	v, fake, _ := newTestVault(t, config.VaultConfig{Token: "s.static"})
+	ctx := context.Background()
+
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr error
+	}{
+		{"//secret/data/gmail#token", "ya29.vault", nil},
+		{"//secret/data/gmail#client_secret", "shh", nil},
+		{"//database/creds/app#password", "pw", nil},
+		{"//secret/data/gmail#nosuch", "", ErrSecretNotFound},
+		{"//secret/data/nosuch#token", "", ErrSecretNotFound},
+	}
+	for _, tt := range tests {
+		got, err := v.Lookup(ctx, tt.ref)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("Lookup(%q) error = %v; want %v", tt.ref, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("Lookup(%q) = %q; want %q", tt.ref, got, tt.want)
+		}
+	}
+	if _, err := v.Lookup(ctx, "//secret/data/gmail"); err == nil {
+		t.Error("Lookup() without a field succeeded")
+	}
+
+	// The fields of a leased secret come from one read
+	if _, err := v.Lookup(ctx, "//database/creds/app#username"); err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if fake.reads != 1 {
+		t.Errorf("leased secret read %d times; want 1", fake.reads)
+	}
+	for _, token := range fake.tokens {
+		if token != "s.static" {
+			t.Errorf("request sent with token %q; want s.static", token)
+		}
+	}
+
+	if _, err := NewVault(config.VaultConfig{}).Lookup(ctx, "//secret/data/gmail#token"); !errors.Is(err, ErrVaultNotConfigured) {
+		t.Errorf("Lookup() without an address error = %v; want ErrVaultNotConfigured", err)
+	}
+}
+
+func TestVaultRenew(t *testing.T) {
+	v, fake, now := newTestVault(t, config.VaultConfig{KubernetesRole: "go-tsk"})
+	v.jwtPath = filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(v.jwtPath, []byte("service-account-jwt\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if got, err := v.Lookup(ctx, "//database/creds/app#username"); err != nil || got != "v-app" {
+		t.Fatalf("Lookup() = %q, %v; want v-app", got, err)
+	}
+	if v.token != "s.login" {
+		t.Errorf("token = %q; want the login token", v.token)
+	}
+
+	// Nothing is due yet; the loop checks back within renewCheckInterval
+	if wait := v.renewDue(ctx); wait != renewCheckInterval {
+		t.Errorf("renewDue() = %v; want %v", wait, renewCheckInterval)
+	}
+	if len(fake.renewals) != 0 {
+		t.Errorf("renewed %v before they were due", fake.renewals)
+	}
+
+	*now = now.Add(200 * time.Second)
+	v.renewDue(ctx)
+	if len(fake.renewals) != 1 || fake.renewals[0] != "database/creds/app/abc" {
+		t.Errorf("renewals = %v; want the secret's lease", fake.renewals)
+	}
+
+	// Still leased, so the secret is not read again
+	if _, err := v.Lookup(ctx, "//database/creds/app#password"); err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if fake.reads != 1 {
+		t.Errorf("leased secret read %d times; want 1", fake.reads)
+	}
+
+	// The token is renewed after two thirds of its TTL, by which time the
+	// lease, no longer renewed, has expired
+	*now = now.Add(40 * time.Minute)
+	v.renewDue(ctx)
+	if got := fake.renewals[len(fake.renewals)-1]; got != "token" {
+		t.Errorf("last renewal = %q; want the token", got)
+	}
+	if _, ok := v.secrets["database/creds/app"]; ok {
+		t.Error("expired lease still tracked")
+	}
+}
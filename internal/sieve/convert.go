@@ -0,0 +1,242 @@
+package sieve
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/rules"
+)
+
+// Issue is a construct of a script that was left out of the rules
+type Issue struct {
+	Line int
+	Msg  string
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("line %d: %s", i.Line, i.Msg)
+}
+
+// deletedFlag is the IMAP flag discard sets, as rules cannot expunge
+const deletedFlag = `\Deleted`
+
+// term is one header :contains test
+type term struct {
+	header string // "subject" or "from"
+	value  string
+}
+
+// converter collects the rules and issues of a script
+type converter struct {
+	rules  []config.Rule
+	issues []Issue
+}
+
+func (c *converter) unsupported(line int, format string, args ...interface{}) {
+	c.issues = append(c.issues, Issue{Line: line, Msg: fmt.Sprintf(format, args...)})
+}
+
+// Convert translates a Sieve script to rules. It supports
+//
+//   - if with header :contains tests of Subject and From, combined with
+//     allof and anyof
+//   - fileinto, which labels, or moves on servers without labels
+//   - addflag, which sets flags such as \Seen as labels
+//   - discard, which flags messages \Deleted
+//
+// and ignores require and keep. Any other construct, including elsif,
+// else, stop and the tests and actions of extensions, is left out and
+// reported as an issue. A test that lists several headers or keys, or an
+// anyof, becomes one rule per alternative, as rules cannot express "or".
+func Convert(src string) ([]config.Rule, []Issue, error) {
+	cmds, err := parse(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	c := &converter{}
+	for _, cmd := range cmds {
+		c.command(cmd)
+	}
+	return c.rules, c.issues, nil
+}
+
+// command converts a top-level command
+func (c *converter) command(cmd command) {
+	switch cmd.name {
+	case "require", "keep":
+	case "if":
+		if len(cmd.tests) != 1 || cmd.block == nil {
+			c.unsupported(cmd.line, "malformed if")
+			return
+		}
+		alternatives, ok := c.test(cmd.tests[0])
+		if !ok {
+			c.unsupported(cmd.line, "if skipped, its test is not supported")
+			return
+		}
+		acts := c.actions(cmd.block)
+		if len(acts) == 0 {
+			c.unsupported(cmd.line, "if skipped, it has no supported actions")
+			return
+		}
+		for _, terms := range alternatives {
+			c.rules = append(c.rules, rule(terms, acts))
+		}
+	case "elsif", "else":
+		c.unsupported(cmd.line, "%s is not supported; convert it by hand", cmd.name)
+	default:
+		// Actions outside of an if apply to every message
+		if acts := c.actions([]command{cmd}); len(acts) > 0 {
+			c.rules = append(c.rules, rule(nil, acts))
+		}
+	}
+}
+
+// test converts a test to alternatives, any of which must hold, each of
+// terms that must all hold. It reports false for unsupported tests.
+func (c *converter) test(t test) ([][]term, bool) {
+	switch t.name {
+	case "header":
+		return c.header(t)
+	case "allof", "anyof":
+		if len(t.tests) == 0 {
+			c.unsupported(t.line, "%s without tests", t.name)
+			return nil, false
+		}
+		var result [][]term
+		for i, sub := range t.tests {
+			alternatives, ok := c.test(sub)
+			if !ok {
+				return nil, false
+			}
+			if t.name == "anyof" {
+				result = append(result, alternatives...)
+			} else if i == 0 {
+				result = alternatives
+			} else {
+				result = product(result, alternatives)
+			}
+		}
+		return result, true
+	}
+	c.unsupported(t.line, "test %s is not supported", t.name)
+	return nil, false
+}
+
+// header converts header :contains tests of Subject and From
+func (c *converter) header(t test) ([][]term, bool) {
+	var strs [][]string
+	match := ":is"
+	for _, arg := range t.args {
+		switch {
+		case arg.tag == ":contains" || arg.tag == ":is" || arg.tag == ":matches" || arg.tag == ":regex":
+			match = arg.tag
+		case arg.tag == ":comparator":
+			c.unsupported(t.line, "header :comparator is not supported")
+			return nil, false
+		case arg.tag != "":
+			c.unsupported(t.line, "header %s is not supported", arg.tag)
+			return nil, false
+		case arg.strings != nil:
+			strs = append(strs, arg.strings)
+		}
+	}
+	if match != ":contains" {
+		c.unsupported(t.line, "header %s is not supported, only :contains", match)
+		return nil, false
+	}
+	if len(strs) != 2 {
+		c.unsupported(t.line, "malformed header test")
+		return nil, false
+	}
+	var alternatives [][]term
+	for _, name := range strs[0] {
+		name = strings.ToLower(name)
+		if name != "subject" && name != "from" {
+			c.unsupported(t.line, "header %q is not supported, only Subject and From", name)
+			return nil, false
+		}
+		for _, value := range strs[1] {
+			alternatives = append(alternatives, []term{{header: name, value: value}})
+		}
+	}
+	return alternatives, true
+}
+
+// product returns every combination of an alternative of a with one of b
+func product(a, b [][]term) [][]term {
+	result := make([][]term, 0, len(a)*len(b))
+	for _, x := range a {
+		for _, y := range b {
+			terms := append(append([]term{}, x...), y...)
+			result = append(result, terms)
+		}
+	}
+	return result
+}
+
+// actions converts the actions of a block
+func (c *converter) actions(block []command) []config.RuleAction {
+	var acts []config.RuleAction
+	for _, cmd := range block {
+		switch cmd.name {
+		case "keep":
+		case "fileinto":
+			folder, ok := singleString(cmd)
+			if !ok {
+				c.unsupported(cmd.line, "fileinto with options is not supported")
+				continue
+			}
+			acts = append(acts, config.RuleAction{Action: "label", Label: folder})
+		case "addflag":
+			if len(cmd.args) != 1 || cmd.args[0].strings == nil {
+				c.unsupported(cmd.line, "addflag with a variable name is not supported")
+				continue
+			}
+			for _, flags := range cmd.args[0].strings {
+				// A string may hold several flags separated by spaces
+				for _, flag := range strings.Fields(flags) {
+					acts = append(acts, config.RuleAction{Action: "label", Label: flag})
+				}
+			}
+		case "discard":
+			acts = append(acts, config.RuleAction{Action: "label", Label: deletedFlag})
+		case "stop":
+			c.unsupported(cmd.line, "stop is not supported; go-tsk runs every matching rule")
+		default:
+			c.unsupported(cmd.line, "%s is not supported", cmd.name)
+		}
+	}
+	return acts
+}
+
+// singleString returns the argument of a command taking one string
+func singleString(cmd command) (string, bool) {
+	if len(cmd.args) != 1 || cmd.args[0].isList || len(cmd.args[0].strings) != 1 {
+		return "", false
+	}
+	return cmd.args[0].strings[0], true
+}
+
+// rule builds the rule running acts on messages matching all terms. The
+// first Subject term becomes SubjectContains, the others a Condition.
+func rule(terms []term, acts []config.RuleAction) config.Rule {
+	var r config.Rule
+	var conds []string
+	for _, t := range terms {
+		if t.header == "subject" && r.SubjectContains == "" && t.value != "" {
+			r.SubjectContains = t.value
+			continue
+		}
+		// Sieve compares case-insensitively by default, as SubjectContains does
+		conds = append(conds, rules.ContainsCondition(t.header, t.value))
+	}
+	r.Condition = strings.Join(conds, " && ")
+	if len(acts) == 1 {
+		r.Action, r.Label = acts[0].Action, acts[0].Label
+	} else {
+		r.Actions = acts
+	}
+	return r
+}
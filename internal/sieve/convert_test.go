@@ -0,0 +1,140 @@
+package sieve
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/rules"
+)
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name       string
+		src        string
+		want       []config.Rule
+		wantIssues []int // Lines of the reported issues
+	}{
+		{
+			name: "fileinto",
+			src: `require ["fileinto"];
+# Bills go to their own folder
+if header :contains "subject" "invoice" {
+    fileinto "Bills";
+}`,
+			want: []config.Rule{{SubjectContains: "invoice", Action: "label", Label: "Bills"}},
+		},
+		{
+			name: "several actions",
+			src: `require ["fileinto", "imap4flags"];
+if header :contains "Subject" "[list]" { addflag ["\\Seen", "\\Flagged"]; fileinto "Lists"; keep; }`,
+			want: []config.Rule{{SubjectContains: "[list]", Actions: []config.RuleAction{
+				{Action: "label", Label: `\Seen`},
+				{Action: "label", Label: `\Flagged`},
+				{Action: "label", Label: "Lists"},
+			}}},
+		},
+		{
+			name: "alternatives",
+			src:  `if anyof (header :contains "subject" ["sale", "offer"], header :contains "from" "shop.example") { discard; }`,
+			want: []config.Rule{
+				{SubjectContains: "sale", Action: "label", Label: `\Deleted`},
+				{SubjectContains: "offer", Action: "label", Label: `\Deleted`},
+				{Condition: `email.from.matches("(?i)shop\\.example")`, Action: "label", Label: `\Deleted`},
+			},
+		},
+		{
+			name: "allof",
+			src: `if allof (header :contains "from" "boss@", header :contains "subject" "urgent",
+                  header :contains "subject" "today") { addflag "\\Flagged"; }`,
+			want: []config.Rule{{
+				SubjectContains: "urgent",
+				Condition:       `email.from.matches("(?i)boss@") && email.subject.matches("(?i)today")`,
+				Action:          "label",
+				Label:           `\Flagged`,
+			}},
+		},
+		{
+			name: "unsupported",
+			src: `if header :is "subject" "x" { fileinto "X"; }
+if header :contains "to" "me" { fileinto "Me"; }
+if size :over 1M { discard; }
+if header :contains "subject" "y" {
+    redirect "a@example.com";
+    fileinto "Y";
+    stop;
+} else {
+    fileinto "Other";
+}
+vacation "away";`,
+			want:       []config.Rule{{SubjectContains: "y", Action: "label", Label: "Y"}},
+			wantIssues: []int{1, 1, 2, 2, 3, 3, 5, 7, 8, 11},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, issues, err := Convert(tt.src)
+			if err != nil {
+				t.Fatalf("Convert: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rules = %+v; want %+v", got, tt.want)
+			}
+			var lines []int
+			for _, issue := range issues {
+				lines = append(lines, issue.Line)
+			}
+			if !reflect.DeepEqual(lines, tt.wantIssues) {
+				t.Errorf("issues = %v; want on lines %v", issues, tt.wantIssues)
+			}
+
+			cfg := config.DefaultConfig()
+			cfg.Poll.Rules = got
+			if err := cfg.Validate(); err != nil {
+				t.Errorf("converted rules are invalid: %v", err)
+			}
+		})
+	}
+}
+
+func TestConvertConditionsMatch(t *testing.T) {
+	got, _, err := Convert(`if header :contains "from" "Shop.Example" { discard; }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cond, err := rules.CompileCondition(got[0].Condition)
+	if err != nil {
+		t.Fatalf("CompileCondition(%s): %v", got[0].Condition, err)
+	}
+	for from, want := range map[string]bool{
+		"Deals <deals@shop.example>":     true,
+		"Deals <deals@shopxexample.org>": false,
+	} {
+		if holds, err := cond.Holds(&email.Email{From: from}); err != nil || holds != want {
+			t.Errorf("Holds(%q) = %v, %v; want %v", from, holds, err, want)
+		}
+	}
+}
+
+func TestConvertSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		line int
+	}{
+		{`if header :contains "subject" "x" { fileinto "X"`, 1},
+		{"fileinto \"X\"\n\nif", 3},
+		{`fileinto ["a" "b"];`, 1},
+		{"/* never closed", 1},
+		{"if true {\n  fileinto \"unterminated;\n}", 2},
+		{"if true { fileinto text:\nBills\n", 1},
+	}
+	for _, tt := range tests {
+		_, _, err := Convert(tt.src)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) || syntaxErr.Line != tt.line {
+			t.Errorf("Convert(%q) = %v; want a syntax error on line %d", tt.src, err, tt.line)
+		}
+	}
+}
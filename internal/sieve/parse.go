@@ -0,0 +1,403 @@
+// Package sieve converts Sieve filters (RFC 5228) to go-tsk rules, so
+// users can bring the filters they already have. It parses the whole
+// language but converts only a subset; see Convert.
+package sieve
+
+import (
+	"fmt"
+	"strings"
+)
+
+// command is a Sieve command, such as if, fileinto or require
+type command struct {
+	line  int
+	name  string
+	args  []argument
+	tests []test
+	block []command // nil unless the command has a block
+}
+
+// test is a Sieve test, such as header or allof
+type test struct {
+	line  int
+	name  string
+	args  []argument
+	tests []test // Of allof, anyof and not
+}
+
+// argument is a tag such as :contains, a number, a string or a string list
+type argument struct {
+	tag     string
+	number  int
+	strings []string // A single string is a list of one
+	isList  bool
+}
+
+// token kinds
+const (
+	tokIdent = iota
+	tokTag
+	tokNumber
+	tokString
+	tokPunct // One of ; , { } ( ) [ ]
+	tokEOF
+)
+
+type token struct {
+	kind int
+	text string
+	num  int
+	line int
+}
+
+// SyntaxError is a malformed script
+type SyntaxError struct {
+	Line int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// lexer splits a script into tokens
+type lexer struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (l *lexer) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Line: l.line, Msg: fmt.Sprintf(format, args...)}
+}
+
+// next returns the next token, skipping white space and comments
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			l.pos++
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		case strings.HasPrefix(l.src[l.pos:], "/*"):
+			end := strings.Index(l.src[l.pos+2:], "*/")
+			if end < 0 {
+				return token{}, l.errorf("unterminated comment")
+			}
+			l.line += strings.Count(l.src[l.pos:l.pos+2+end], "\n")
+			l.pos += end + 4
+		default:
+			return l.token()
+		}
+	}
+	return token{kind: tokEOF, line: l.line}, nil
+}
+
+// token reads the token at l.pos
+func (l *lexer) token() (token, error) {
+	c := l.src[l.pos]
+	start := l.pos
+	switch {
+	case strings.IndexByte(";,{}()[]", c) >= 0:
+		l.pos++
+		return token{kind: tokPunct, text: string(c), line: l.line}, nil
+	case c == '"':
+		return l.quoted()
+	case strings.HasPrefix(l.src[l.pos:], "text:"):
+		return l.multiline()
+	case c == ':':
+		l.pos++
+		name := l.identifier()
+		if name == "" {
+			return token{}, l.errorf("missing tag name after ':'")
+		}
+		return token{kind: tokTag, text: ":" + name, line: l.line}, nil
+	case c >= '0' && c <= '9':
+		n := 0
+		for l.pos < len(l.src) && l.src[l.pos] >= '0' && l.src[l.pos] <= '9' {
+			n = n*10 + int(l.src[l.pos]-'0')
+			l.pos++
+		}
+		// Quantifiers scale the number
+		if l.pos < len(l.src) {
+			switch l.src[l.pos] {
+			case 'K', 'k':
+				n <<= 10
+				l.pos++
+			case 'M', 'm':
+				n <<= 20
+				l.pos++
+			case 'G', 'g':
+				n <<= 30
+				l.pos++
+			}
+		}
+		return token{kind: tokNumber, num: n, text: l.src[start:l.pos], line: l.line}, nil
+	}
+	if name := l.identifier(); name != "" {
+		return token{kind: tokIdent, text: strings.ToLower(name), line: l.line}, nil
+	}
+	return token{}, l.errorf("unexpected character %q", c)
+}
+
+// identifier reads a name of letters, digits and underscores
+func (l *lexer) identifier() string {
+	start := l.pos
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		if c != '_' && !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(l.pos > start && c >= '0' && c <= '9') {
+			break
+		}
+		l.pos++
+	}
+	return l.src[start:l.pos]
+}
+
+// quoted reads a quoted string, in which a backslash escapes any character
+func (l *lexer) quoted() (token, error) {
+	line := l.line
+	var b strings.Builder
+	for l.pos++; l.pos < len(l.src); l.pos++ {
+		c := l.src[l.pos]
+		switch c {
+		case '"':
+			l.pos++
+			return token{kind: tokString, text: b.String(), line: line}, nil
+		case '\\':
+			l.pos++
+			if l.pos == len(l.src) {
+				return token{}, &SyntaxError{Line: line, Msg: "unterminated string"}
+			}
+			c = l.src[l.pos]
+		case '\n':
+			l.line++
+		}
+		b.WriteByte(c)
+	}
+	return token{}, &SyntaxError{Line: line, Msg: "unterminated string"}
+}
+
+// multiline reads a text: string, which runs until a line holding just a
+// dot; lines starting with a dot have it doubled
+func (l *lexer) multiline() (token, error) {
+	line := l.line
+	eol := strings.IndexByte(l.src[l.pos:], '\n')
+	if eol < 0 {
+		return token{}, l.errorf("unterminated text: string")
+	}
+	l.pos += eol + 1
+	l.line++
+	var b strings.Builder
+	for l.pos < len(l.src) {
+		end := strings.IndexByte(l.src[l.pos:], '\n')
+		if end < 0 {
+			end = len(l.src) - l.pos
+		}
+		text := strings.TrimSuffix(l.src[l.pos:l.pos+end], "\r")
+		l.pos += end + 1
+		l.line++
+		if text == "." {
+			return token{kind: tokString, text: b.String(), line: line}, nil
+		}
+		b.WriteString(strings.TrimPrefix(text, "."))
+		b.WriteByte('\n')
+	}
+	return token{}, &SyntaxError{Line: line, Msg: "unterminated text: string"}
+}
+
+// parser builds commands from tokens with one token of lookahead
+type parser struct {
+	lex lexer
+	tok token
+}
+
+// parse parses a whole script
+func parse(src string) ([]command, error) {
+	p := &parser{lex: lexer{src: src, line: 1}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	cmds, err := p.commands()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %q", p.tok.text)
+	}
+	return cmds, nil
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Line: p.tok.line, Msg: fmt.Sprintf(format, args...)}
+}
+
+// is reports whether the current token is the punctuation s
+func (p *parser) is(s string) bool {
+	return p.tok.kind == tokPunct && p.tok.text == s
+}
+
+// expect consumes the punctuation s
+func (p *parser) expect(s string) error {
+	if !p.is(s) {
+		if p.tok.kind == tokEOF {
+			return p.errorf("expected %q at end of script", s)
+		}
+		return p.errorf("expected %q, got %q", s, p.tok.text)
+	}
+	return p.advance()
+}
+
+// commands parses commands up to the end of the script or block
+func (p *parser) commands() ([]command, error) {
+	var cmds []command
+	for p.tok.kind == tokIdent {
+		cmd, err := p.command()
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, cmd)
+	}
+	return cmds, nil
+}
+
+// command parses a command: a name, its arguments and tests, and either a
+// semicolon or a block
+func (p *parser) command() (command, error) {
+	cmd := command{line: p.tok.line, name: p.tok.text}
+	if err := p.advance(); err != nil {
+		return cmd, err
+	}
+	var err error
+	if cmd.args, err = p.arguments(); err != nil {
+		return cmd, err
+	}
+	if cmd.tests, err = p.testsOf(); err != nil {
+		return cmd, err
+	}
+	if p.is(";") {
+		return cmd, p.advance()
+	}
+	if err := p.expect("{"); err != nil {
+		return cmd, err
+	}
+	if cmd.block, err = p.commands(); err != nil {
+		return cmd, err
+	}
+	if cmd.block == nil {
+		cmd.block = []command{}
+	}
+	return cmd, p.expect("}")
+}
+
+// arguments parses tags, numbers, strings and string lists
+func (p *parser) arguments() ([]argument, error) {
+	var args []argument
+	for {
+		switch {
+		case p.tok.kind == tokTag:
+			args = append(args, argument{tag: p.tok.text})
+		case p.tok.kind == tokNumber:
+			args = append(args, argument{number: p.tok.num})
+		case p.tok.kind == tokString:
+			args = append(args, argument{strings: []string{p.tok.text}})
+		case p.is("["):
+			list, err := p.stringList()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, argument{strings: list, isList: true})
+			continue
+		default:
+			return args, nil
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// stringList parses ["a", "b"]
+func (p *parser) stringList() ([]string, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	var list []string
+	for {
+		if p.tok.kind != tokString {
+			return nil, p.errorf("expected a string in list")
+		}
+		list = append(list, p.tok.text)
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if p.is("]") {
+			return list, p.advance()
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// testsOf parses the test or parenthesized test list of a command or test
+func (p *parser) testsOf() ([]test, error) {
+	if p.tok.kind == tokIdent {
+		t, err := p.test()
+		if err != nil {
+			return nil, err
+		}
+		return []test{t}, nil
+	}
+	if !p.is("(") {
+		return nil, nil
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	var tests []test
+	for {
+		t, err := p.test()
+		if err != nil {
+			return nil, err
+		}
+		tests = append(tests, t)
+		if p.is(")") {
+			return tests, p.advance()
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// test parses one test
+func (p *parser) test() (test, error) {
+	if p.tok.kind != tokIdent {
+		return test{}, p.errorf("expected a test")
+	}
+	t := test{line: p.tok.line, name: p.tok.text}
+	if err := p.advance(); err != nil {
+		return t, err
+	}
+	var err error
+	if t.args, err = p.arguments(); err != nil {
+		return t, err
+	}
+	t.tests, err = p.testsOf()
+	return t, err
+}
@@ -0,0 +1,762 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/notify"
+	"github.com/mshan/go-tsk/internal/tasks"
+)
+
+// Buckets of the bolt backend. Keys made of several parts join them with
+// a NUL byte, so a prefix scan finds an account's entries; values are JSON.
+var (
+	bucketAccountState = []byte("account_state")
+	bucketCursors      = []byte("mailbox_cursor")
+	bucketProcessed    = []byte("processed_messages")
+	bucketGenerated    = []byte("generated_messages")
+	bucketBackfill     = []byte("backfill_progress")
+	bucketRuleStats    = []byte("rule_stats")
+	bucketDigest       = []byte("digest_entries")
+	bucketSnoozes      = []byte("snoozes")
+	bucketReminders    = []byte("reminders")
+	bucketThreadLabels = []byte("thread_labels")
+	bucketMessages     = []byte("messages")
+	bucketTasks        = []byte("tasks")
+)
+
+var boltBuckets = [][]byte{
+	bucketAccountState, bucketCursors, bucketProcessed, bucketGenerated, bucketBackfill, bucketRuleStats,
+	bucketDigest, bucketSnoozes, bucketReminders, bucketThreadLabels, bucketMessages, bucketTasks,
+}
+
+// Bolt is the Store kept in a bbolt file. It needs no CGO, but has no
+// full-text search: SearchMessages returns ErrSearchUnavailable.
+type Bolt struct {
+	db *bolt.DB
+}
+
+// generatedRecord is the value of a generated_messages entry
+type generatedRecord struct {
+	AccountID string
+	At        time.Time
+}
+
+// digestRecord is the value of a digest_entries entry
+type digestRecord struct {
+	Entry    notify.Entry
+	QueuedAt time.Time
+}
+
+// threadLabelRecord is the value of a thread_labels entry
+type threadLabelRecord struct {
+	Inherit   bool
+	LabeledAt time.Time
+}
+
+// OpenBolt opens (creating if needed) the bbolt file at path
+func OpenBolt(path string) (*Bolt, error) {
+	// Another process holding the file blocks Open; fail rather than hang
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range boltBuckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return fmt.Errorf("failed to create bucket %s: %w", name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Bolt{db: db}, nil
+}
+
+// LastSync returns the persisted last sync time for an account, or the zero
+// time if none was saved
+func (b *Bolt) LastSync(accountID string) (time.Time, error) {
+	var t time.Time
+	err := b.get(bucketAccountState, boltKey(accountID), &t)
+	return t, err
+}
+
+// SaveLastSync persists the last sync time for an account
+func (b *Bolt) SaveLastSync(accountID string, t time.Time) error {
+	return b.put(bucketAccountState, boltKey(accountID), t)
+}
+
+// Cursor returns the saved fetch cursor for an account's mailbox, or a zero
+// cursor if none was saved
+func (b *Bolt) Cursor(accountID, mailbox string) (email.Cursor, error) {
+	var c email.Cursor
+	err := b.get(bucketCursors, boltKey(accountID, mailbox), &c)
+	return c, err
+}
+
+// Cursors returns the saved fetch cursors for all of an account's
+// mailboxes, keyed by mailbox name
+func (b *Bolt) Cursors(accountID string) (map[string]email.Cursor, error) {
+	cursors := make(map[string]email.Cursor)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return scanPrefix(tx.Bucket(bucketCursors), boltPrefix(accountID), func(k, v []byte) error {
+			var c email.Cursor
+			if err := json.Unmarshal(v, &c); err != nil {
+				return err
+			}
+			cursors[lastPart(k)] = c
+			return nil
+		})
+	})
+	return cursors, err
+}
+
+// SaveCursor persists the fetch cursor for an account's mailbox
+func (b *Bolt) SaveCursor(accountID, mailbox string, c email.Cursor) error {
+	return b.put(bucketCursors, boltKey(accountID, mailbox), c)
+}
+
+// IsProcessed reports whether the message with the given key was already
+// processed for an account
+func (b *Bolt) IsProcessed(accountID, key string) (bool, error) {
+	return b.has(bucketProcessed, boltKey(accountID, key))
+}
+
+// MarkProcessed records that the message with the given key was processed
+// for an account
+func (b *Bolt) MarkProcessed(accountID, key string, at time.Time) error {
+	return b.putNew(bucketProcessed, boltKey(accountID, key), at)
+}
+
+// IsGenerated reports whether the message with the given Message-ID was
+// sent by go-tsk
+func (b *Bolt) IsGenerated(messageID string) (bool, error) {
+	return b.has(bucketGenerated, []byte(messageID))
+}
+
+// RecordGenerated records the Message-ID of a message go-tsk sent for an
+// account
+func (b *Bolt) RecordGenerated(accountID, messageID string, at time.Time) error {
+	return b.putNew(bucketGenerated, []byte(messageID), generatedRecord{AccountID: accountID, At: at})
+}
+
+// BackfillCheckpoint returns the saved backfill progress for an account's
+// mailbox, or a zero checkpoint if none was saved
+func (b *Bolt) BackfillCheckpoint(accountID, mailbox string) (BackfillCheckpoint, error) {
+	var cp BackfillCheckpoint
+	err := b.get(bucketBackfill, boltKey(accountID, mailbox), &cp)
+	return cp, err
+}
+
+// SaveBackfillCheckpoint persists backfill progress for an account's mailbox
+func (b *Bolt) SaveBackfillCheckpoint(accountID, mailbox string, cp BackfillCheckpoint) error {
+	return b.put(bucketBackfill, boltKey(accountID, mailbox), cp)
+}
+
+// ResetBackfill discards saved backfill progress for an account's mailbox
+func (b *Bolt) ResetBackfill(accountID, mailbox string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketBackfill).Delete(boltKey(accountID, mailbox))
+	})
+}
+
+// RuleStats returns the saved statistics of every rule, keyed by the
+// caller's rule key
+func (b *Bolt) RuleStats() (map[string]RuleStats, error) {
+	stats := make(map[string]RuleStats)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketRuleStats).ForEach(func(k, v []byte) error {
+			var rs RuleStats
+			if err := json.Unmarshal(v, &rs); err != nil {
+				return err
+			}
+			stats[string(k)] = rs
+			return nil
+		})
+	})
+	return stats, err
+}
+
+// SaveRuleStats persists the statistics of a rule
+func (b *Bolt) SaveRuleStats(key string, rs RuleStats) error {
+	return b.put(bucketRuleStats, []byte(key), rs)
+}
+
+// QueueDigestEntry adds a match to an account's pending digest
+func (b *Bolt) QueueDigestEntry(accountID string, e notify.Entry, at time.Time) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketDigest)
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(digestRecord{Entry: e, QueuedAt: at})
+		if err != nil {
+			return err
+		}
+		// The sequence number keeps an account's entries in queue order
+		return bucket.Put(append(boltPrefix(accountID), itob(seq)...), data)
+	})
+}
+
+// DigestEntries returns the matches of an account's pending digest, in
+// the order they were queued, and when the first was queued
+func (b *Bolt) DigestEntries(accountID string) ([]notify.Entry, time.Time, error) {
+	var entries []notify.Entry
+	var first time.Time
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return scanPrefix(tx.Bucket(bucketDigest), boltPrefix(accountID), func(k, v []byte) error {
+			var r digestRecord
+			if err := json.Unmarshal(v, &r); err != nil {
+				return fmt.Errorf("invalid digest entry: %w", err)
+			}
+			if len(entries) == 0 {
+				first = r.QueuedAt
+			}
+			entries = append(entries, r.Entry)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return entries, first, nil
+}
+
+// ClearDigest discards an account's pending digest once it was sent
+func (b *Bolt) ClearDigest(accountID string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketDigest)
+		var keys [][]byte
+		err := scanPrefix(bucket, boltPrefix(accountID), func(k, v []byte) error {
+			keys = append(keys, append([]byte(nil), k...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return deleteKeys(bucket, keys)
+	})
+}
+
+// CreateSnooze saves a snooze and sets its ID
+func (b *Bolt) CreateSnooze(sn *Snooze) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketSnoozes)
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		stored := *sn
+		stored.ID = int64(seq)
+		data, err := json.Marshal(stored)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(itob(seq), data); err != nil {
+			return err
+		}
+		sn.ID = stored.ID
+		return nil
+	})
+}
+
+// DueSnoozes returns an account's snoozes that end at or before now,
+// earliest first
+func (b *Bolt) DueSnoozes(accountID string, now time.Time) ([]Snooze, error) {
+	var snoozes []Snooze
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketSnoozes).ForEach(func(k, v []byte) error {
+			var sn Snooze
+			if err := json.Unmarshal(v, &sn); err != nil {
+				return err
+			}
+			if sn.AccountID == accountID && !sn.Until.After(now) {
+				snoozes = append(snoozes, sn)
+			}
+			return nil
+		})
+	})
+	// Entries come in ID order, so a stable sort keeps ties in it
+	sort.SliceStable(snoozes, func(i, j int) bool { return snoozes[i].Until.Before(snoozes[j].Until) })
+	return snoozes, err
+}
+
+// DeleteSnooze discards a snooze once its message is back or gone
+func (b *Bolt) DeleteSnooze(id int64) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketSnoozes).Delete(itob(uint64(id)))
+	})
+}
+
+// CreateReminder saves a reminder and sets its ID. A message only ever has
+// one reminder per account; if it already has one, created is false and r
+// is left unchanged.
+func (b *Bolt) CreateReminder(r *Reminder) (created bool, err error) {
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketReminders)
+		exists := false
+		err := bucket.ForEach(func(k, v []byte) error {
+			var other Reminder
+			if err := json.Unmarshal(v, &other); err != nil {
+				return err
+			}
+			exists = exists || (other.AccountID == r.AccountID && other.Email.MessageID == r.Email.MessageID)
+			return nil
+		})
+		if err != nil || exists {
+			return err
+		}
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		stored := *r
+		stored.ID = int64(seq)
+		data, err := json.Marshal(stored)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(itob(seq), data); err != nil {
+			return err
+		}
+		r.ID, created = stored.ID, true
+		return nil
+	})
+	return created, err
+}
+
+// CancelReminders discards an account's reminders of the messages with the
+// given Message-IDs, once one of them was answered, and returns how many
+// there were
+func (b *Bolt) CancelReminders(accountID string, messageIDs []string) (int64, error) {
+	if len(messageIDs) == 0 {
+		return 0, nil
+	}
+	answered := make(map[string]bool, len(messageIDs))
+	for _, id := range messageIDs {
+		answered[id] = true
+	}
+	var n int64
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketReminders)
+		var keys [][]byte
+		err := bucket.ForEach(func(k, v []byte) error {
+			var r Reminder
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			if r.AccountID == accountID && answered[r.Email.MessageID] {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		n = int64(len(keys))
+		return deleteKeys(bucket, keys)
+	})
+	return n, err
+}
+
+// DueReminders returns an account's reminders due at or before now,
+// earliest first
+func (b *Bolt) DueReminders(accountID string, now time.Time) ([]Reminder, error) {
+	var reminders []Reminder
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketReminders).ForEach(func(k, v []byte) error {
+			var r Reminder
+			if err := json.Unmarshal(v, &r); err != nil {
+				return fmt.Errorf("invalid reminder: %w", err)
+			}
+			if r.AccountID == accountID && !r.Due.After(now) {
+				reminders = append(reminders, r)
+			}
+			return nil
+		})
+	})
+	sort.SliceStable(reminders, func(i, j int) bool { return reminders[i].Due.Before(reminders[j].Due) })
+	return reminders, err
+}
+
+// DeleteReminder discards a reminder once its follow-up ran
+func (b *Bolt) DeleteReminder(id int64) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketReminders).Delete(itob(uint64(id)))
+	})
+}
+
+// RecordThreadLabel notes that a message of an account's thread got label.
+// Once a label is inherited, it stays so.
+func (b *Bolt) RecordThreadLabel(accountID, threadID, label string, inherit bool, at time.Time) error {
+	key := boltKey(accountID, threadID, label)
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketThreadLabels)
+		var r threadLabelRecord
+		if v := bucket.Get(key); v != nil {
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+		}
+		r.Inherit = r.Inherit || inherit
+		r.LabeledAt = at
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, data)
+	})
+}
+
+// ThreadLabels returns the labels recorded for an account's thread
+func (b *Bolt) ThreadLabels(accountID, threadID string) ([]ThreadLabel, error) {
+	var labels []ThreadLabel
+	err := b.db.View(func(tx *bolt.Tx) error {
+		// Keys sort by label within the thread's prefix
+		return scanPrefix(tx.Bucket(bucketThreadLabels), boltPrefix(accountID, threadID), func(k, v []byte) error {
+			var r threadLabelRecord
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			labels = append(labels, ThreadLabel{Label: lastPart(k), Inherit: r.Inherit})
+			return nil
+		})
+	})
+	return labels, err
+}
+
+// ArchiveMessage keeps a message in an account's archive, replacing the
+// copy of an earlier fetch
+func (b *Bolt) ArchiveMessage(accountID, key string, msg *email.Email, at time.Time) error {
+	return b.put(bucketMessages, boltKey(accountID, key),
+		ArchivedMessage{AccountID: accountID, Key: key, Email: *msg, ArchivedAt: at})
+}
+
+// SearchArchive returns the archived messages q selects, newest first.
+// Without indices it reads the account's whole archive, or every account's.
+func (b *Bolt) SearchArchive(q ArchiveQuery) ([]ArchivedMessage, error) {
+	from, subject := strings.ToLower(q.From), strings.ToLower(q.Subject)
+	var messages []ArchivedMessage
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var prefix []byte
+		if q.AccountID != "" {
+			prefix = boltPrefix(q.AccountID)
+		}
+		return scanPrefix(tx.Bucket(bucketMessages), prefix, func(k, v []byte) error {
+			var m ArchivedMessage
+			if err := json.Unmarshal(v, &m); err != nil {
+				return fmt.Errorf("archived message %s: invalid email: %w", lastPart(k), err)
+			}
+			date := m.Email.Date
+			switch {
+			case from != "" && !strings.Contains(strings.ToLower(m.Email.From), from),
+				subject != "" && !strings.Contains(strings.ToLower(m.Email.Subject), subject),
+				!q.Since.IsZero() && date.Before(q.Since),
+				!q.Before.IsZero() && !date.Before(q.Before):
+				return nil
+			}
+			messages = append(messages, m)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		di, dj := messages[i].Email.Date, messages[j].Email.Date
+		if !di.Equal(dj) {
+			return di.After(dj)
+		}
+		return messages[i].ArchivedAt.After(messages[j].ArchivedAt)
+	})
+	if q.Limit > 0 && len(messages) > q.Limit {
+		messages = messages[:q.Limit]
+	}
+	return messages, nil
+}
+
+// SearchMessages always returns ErrSearchUnavailable; bolt has no
+// full-text index
+func (b *Bolt) SearchMessages(accountID, text string, limit int) ([]SearchResult, error) {
+	return nil, ErrSearchUnavailable
+}
+
+// Prune deletes state older than its cutoff. Bolt reuses the freed pages,
+// so the file stops growing rather than shrinking.
+func (b *Bolt) Prune(c PruneCutoffs) (PruneCounts, error) {
+	var n PruneCounts
+	var err error
+	if !c.Archive.IsZero() {
+		if n.Archive, err = b.deleteOlder(bucketMessages, c.Archive, func(v []byte) (time.Time, error) {
+			var m ArchivedMessage
+			if err := json.Unmarshal(v, &m); err != nil {
+				return time.Time{}, err
+			}
+			if m.Email.Date.IsZero() {
+				return m.ArchivedAt, nil
+			}
+			return m.Email.Date, nil
+		}); err != nil {
+			return n, fmt.Errorf("failed to prune the archive: %w", err)
+		}
+	}
+	if !c.Processed.IsZero() {
+		if n.Processed, err = b.deleteOlder(bucketProcessed, c.Processed, func(v []byte) (time.Time, error) {
+			var at time.Time
+			err := json.Unmarshal(v, &at)
+			return at, err
+		}); err != nil {
+			return n, fmt.Errorf("failed to prune the processed journal: %w", err)
+		}
+	}
+	if !c.Events.IsZero() {
+		labels, err := b.deleteOlder(bucketThreadLabels, c.Events, func(v []byte) (time.Time, error) {
+			var r threadLabelRecord
+			err := json.Unmarshal(v, &r)
+			return r.LabeledAt, err
+		})
+		if err != nil {
+			return n, fmt.Errorf("failed to prune events: %w", err)
+		}
+		generated, err := b.deleteOlder(bucketGenerated, c.Events, func(v []byte) (time.Time, error) {
+			var r generatedRecord
+			err := json.Unmarshal(v, &r)
+			return r.At, err
+		})
+		if err != nil {
+			return n, fmt.Errorf("failed to prune events: %w", err)
+		}
+		n.Events = labels + generated
+	}
+	return n, nil
+}
+
+// deleteOlder deletes the entries of a bucket whose time, as read by at,
+// is before cutoff, and returns how many it deleted
+func (b *Bolt) deleteOlder(name []byte, cutoff time.Time, at func(v []byte) (time.Time, error)) (int64, error) {
+	var n int64
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(name)
+		var keys [][]byte
+		err := bucket.ForEach(func(k, v []byte) error {
+			t, err := at(v)
+			if err != nil {
+				return err
+			}
+			if t.Before(cutoff) {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		n = int64(len(keys))
+		return deleteKeys(bucket, keys)
+	})
+	return n, err
+}
+
+// CreateTask saves a new task and sets its ID. A message only ever yields
+// one task per account; if it already has one, created is false and t is
+// left unchanged.
+func (b *Bolt) CreateTask(t *tasks.Task) (created bool, err error) {
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketTasks)
+		exists := false
+		err := bucket.ForEach(func(k, v []byte) error {
+			var other tasks.Task
+			if err := json.Unmarshal(v, &other); err != nil {
+				return err
+			}
+			exists = exists || (other.AccountID == t.AccountID && other.Source.Key == t.Source.Key)
+			return nil
+		})
+		if err != nil || exists {
+			return err
+		}
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		stored := *t
+		stored.ID = int64(seq)
+		data, err := json.Marshal(stored)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(itob(seq), data); err != nil {
+			return err
+		}
+		t.ID, created = stored.ID, true
+		return nil
+	})
+	return created, err
+}
+
+// Tasks returns an account's tasks, or every account's if accountID is
+// empty, oldest first
+func (b *Bolt) Tasks(accountID string) ([]tasks.Task, error) {
+	var list []tasks.Task
+	err := b.db.View(func(tx *bolt.Tx) error {
+		// Keys are big-endian IDs, so ForEach goes oldest first
+		return tx.Bucket(bucketTasks).ForEach(func(k, v []byte) error {
+			var t tasks.Task
+			if err := json.Unmarshal(v, &t); err != nil {
+				return fmt.Errorf("task %d: %w", binary.BigEndian.Uint64(k), err)
+			}
+			if accountID == "" || t.AccountID == accountID {
+				list = append(list, t)
+			}
+			return nil
+		})
+	})
+	return list, err
+}
+
+// CompleteTask marks a task as done
+func (b *Bolt) CompleteTask(id int64) error {
+	return b.updateTask(id, func(t *tasks.Task) {
+		t.Status = tasks.StatusDone
+		t.SnoozedUntil = time.Time{}
+	})
+}
+
+// SnoozeTask hides an open task until the given time
+func (b *Bolt) SnoozeTask(id int64, until time.Time) error {
+	return b.updateTask(id, func(t *tasks.Task) { t.SnoozedUntil = until })
+}
+
+// updateTask applies update to one task, returning ErrTaskNotFound if no
+// task has the ID
+func (b *Bolt) updateTask(id int64, update func(t *tasks.Task)) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketTasks)
+		v := bucket.Get(itob(uint64(id)))
+		if v == nil {
+			return ErrTaskNotFound
+		}
+		var t tasks.Task
+		if err := json.Unmarshal(v, &t); err != nil {
+			return fmt.Errorf("task %d: %w", id, err)
+		}
+		update(&t)
+		data, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(itob(uint64(id)), data)
+	})
+}
+
+// Close closes the database
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
+
+// get decodes the value of key into v, leaving v unchanged if there is none
+func (b *Bolt) get(name, key []byte, v interface{}) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(name).Get(key)
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, v)
+	})
+}
+
+// put stores v as the value of key
+func (b *Bolt) put(name, key []byte, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(name).Put(key, data)
+	})
+}
+
+// putNew stores v as the value of key unless key already has one
+func (b *Bolt) putNew(name, key []byte, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(name)
+		if bucket.Get(key) != nil {
+			return nil
+		}
+		return bucket.Put(key, data)
+	})
+}
+
+// has reports whether key has a value
+func (b *Bolt) has(name, key []byte) (bool, error) {
+	var found bool
+	err := b.db.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket(name).Get(key) != nil
+		return nil
+	})
+	return found, err
+}
+
+// boltKey joins the parts of a key
+func boltKey(parts ...string) []byte {
+	return []byte(strings.Join(parts, "\x00"))
+}
+
+// boltPrefix is the start of every key whose leading parts are parts
+func boltPrefix(parts ...string) []byte {
+	return append(boltKey(parts...), 0)
+}
+
+// lastPart returns the last part of a key
+func lastPart(k []byte) string {
+	if i := bytes.LastIndexByte(k, 0); i >= 0 {
+		return string(k[i+1:])
+	}
+	return string(k)
+}
+
+// scanPrefix calls fn for each entry of bucket whose key starts with
+// prefix, in key order
+func scanPrefix(bucket *bolt.Bucket, prefix []byte, fn func(k, v []byte) error) error {
+	c := bucket.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteKeys deletes keys from bucket. Deleting while iterating would
+// skip entries, so callers collect the keys first.
+func deleteKeys(bucket *bolt.Bucket, keys [][]byte) error {
+	for _, k := range keys {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// itob encodes an ID as a key that sorts in numeric order
+func itob(id uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, id)
+	return b
+}
@@ -0,0 +1,687 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/notify"
+	"github.com/mshan/go-tsk/internal/tasks"
+)
+
+// postgresDSNEnv names the database the Postgres backend is tested
+// against; the tests are skipped without it. Each test works in a schema
+// of its own, dropped afterwards.
+const postgresDSNEnv = "GO_TSK_TEST_POSTGRES"
+
+// backend opens stores of one kind for the conformance tests
+type backend struct {
+	// location returns where a new, empty store lives
+	location func(t *testing.T) string
+	open     func(location string) (Store, error)
+}
+
+func TestSQLiteConformance(t *testing.T) {
+	testStore(t, backend{
+		location: func(t *testing.T) string { return filepath.Join(t.TempDir(), "state.db") },
+		open: func(path string) (Store, error) {
+			s, err := OpenSQLite(path)
+			if err != nil {
+				return nil, err
+			}
+			return s, nil
+		},
+	})
+}
+
+func TestBoltConformance(t *testing.T) {
+	testStore(t, backend{
+		location: func(t *testing.T) string { return filepath.Join(t.TempDir(), "state.bolt") },
+		open: func(path string) (Store, error) {
+			b, err := OpenBolt(path)
+			if err != nil {
+				return nil, err
+			}
+			return b, nil
+		},
+	})
+}
+
+func TestPostgresConformance(t *testing.T) {
+	dsn := os.Getenv(postgresDSNEnv)
+	if dsn == "" {
+		t.Skipf("set %s to a PostgreSQL connection string to test the postgres backend", postgresDSNEnv)
+	}
+	testStore(t, backend{
+		location: func(t *testing.T) string { return postgresSchema(t, dsn) },
+		open: func(dsn string) (Store, error) {
+			pg, err := OpenPostgres(dsn)
+			if err != nil {
+				return nil, err
+			}
+			return pg, nil
+		},
+	})
+}
+
+// postgresSchema creates an empty schema and returns dsn with the schema
+// as its search path
+func postgresSchema(t *testing.T, dsn string) string {
+	t.Helper()
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	schema := fmt.Sprintf("go_tsk_test_%d", time.Now().UnixNano())
+	if _, err := db.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := db.Exec(`DROP SCHEMA ` + schema + ` CASCADE`); err != nil {
+			t.Errorf("failed to drop schema %s: %v", schema, err)
+		}
+	})
+
+	if strings.Contains(dsn, "://") {
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		return dsn + sep + "search_path=" + schema
+	}
+	return dsn + " search_path=" + schema
+}
+
+// testStore runs every conformance test on a new store of b
+func testStore(t *testing.T, b backend) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s Store)
+	}{
+		{"sync state", testSyncState},
+		{"processed journal", testProcessedJournal},
+		{"generated messages", testGeneratedMessages},
+		{"backfill checkpoints", testBackfillCheckpoints},
+		{"rule stats", testRuleStats},
+		{"digests", testDigests},
+		{"snoozes", testSnoozes},
+		{"reminders", testReminders},
+		{"thread labels", testThreadLabels},
+		{"archive", testArchive},
+		{"prune", testPrune},
+		{"tasks", testTasks},
+		{"leases", testLeases},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := b.open(b.location(t))
+			if err != nil {
+				t.Fatalf("open error = %v", err)
+			}
+			defer s.Close()
+			tt.fn(t, s)
+		})
+	}
+
+	t.Run("reopen", func(t *testing.T) {
+		location := b.location(t)
+		s, err := b.open(location)
+		if err != nil {
+			t.Fatalf("open error = %v", err)
+		}
+		at := time.Unix(1700000000, 0)
+		if err := s.SaveLastSync("work", at); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.MarkProcessed("work", "mid:<a@example.com>", at); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Opening again applies no migration twice and keeps the state
+		s, err = b.open(location)
+		if err != nil {
+			t.Fatalf("second open error = %v", err)
+		}
+		defer s.Close()
+		if got, err := s.LastSync("work"); err != nil || !got.Equal(at) {
+			t.Errorf("LastSync() after reopening = %v, %v; want %v", got, err, at)
+		}
+		if ok, err := s.IsProcessed("work", "mid:<a@example.com>"); err != nil || !ok {
+			t.Errorf("IsProcessed() after reopening = %v, %v; want true", ok, err)
+		}
+	})
+}
+
+func testSyncState(t *testing.T, s Store) {
+	if got, err := s.LastSync("work"); err != nil || !got.IsZero() {
+		t.Errorf("LastSync() of a new account = %v, %v; want the zero time", got, err)
+	}
+	at := time.Unix(1700000000, 0)
+	for _, sync := range []time.Time{at.Add(-time.Hour), at} {
+		if err := s.SaveLastSync("work", sync); err != nil {
+			t.Fatalf("SaveLastSync() error = %v", err)
+		}
+	}
+	if got, err := s.LastSync("work"); err != nil || !got.Equal(at) {
+		t.Errorf("LastSync() = %v, %v; want %v", got, err, at)
+	}
+
+	if got, err := s.Cursor("work", "INBOX"); err != nil || got != (email.Cursor{}) {
+		t.Errorf("Cursor() of a new mailbox = %+v, %v; want a zero cursor", got, err)
+	}
+	want := map[string]email.Cursor{
+		"INBOX":    {UIDValidity: 7, LastUID: 42, ModSeq: 1 << 40},
+		"Receipts": {UIDValidity: 9, LastUID: 3},
+	}
+	if err := s.SaveCursor("work", "INBOX", email.Cursor{UIDValidity: 7, LastUID: 1}); err != nil {
+		t.Fatalf("SaveCursor() error = %v", err)
+	}
+	for mailbox, c := range want {
+		if err := s.SaveCursor("work", mailbox, c); err != nil {
+			t.Fatalf("SaveCursor() error = %v", err)
+		}
+	}
+	if err := s.SaveCursor("home", "INBOX", email.Cursor{UIDValidity: 1, LastUID: 1}); err != nil {
+		t.Fatalf("SaveCursor() error = %v", err)
+	}
+	if got, err := s.Cursor("work", "INBOX"); err != nil || got != want["INBOX"] {
+		t.Errorf("Cursor() = %+v, %v; want %+v", got, err, want["INBOX"])
+	}
+	if got, err := s.Cursors("work"); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Cursors() = %+v, %v; want %+v", got, err, want)
+	}
+}
+
+func testProcessedJournal(t *testing.T, s Store) {
+	key := "mid:<a@example.com>"
+	if ok, err := s.IsProcessed("work", key); err != nil || ok {
+		t.Errorf("IsProcessed() before marking = %v, %v; want false", ok, err)
+	}
+	at := time.Unix(1700000000, 0)
+	for i := 0; i < 2; i++ {
+		if err := s.MarkProcessed("work", key, at); err != nil {
+			t.Fatalf("MarkProcessed() #%d error = %v", i+1, err)
+		}
+	}
+	if ok, err := s.IsProcessed("work", key); err != nil || !ok {
+		t.Errorf("IsProcessed() = %v, %v; want true", ok, err)
+	}
+	if ok, err := s.IsProcessed("home", key); err != nil || ok {
+		t.Errorf("IsProcessed() of another account = %v, %v; want false", ok, err)
+	}
+}
+
+func testGeneratedMessages(t *testing.T, s Store) {
+	id := "<go-tsk.1@example.com>"
+	if ok, err := s.IsGenerated(id); err != nil || ok {
+		t.Errorf("IsGenerated() before recording = %v, %v; want false", ok, err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := s.RecordGenerated("work", id, time.Unix(1700000000, 0)); err != nil {
+			t.Fatalf("RecordGenerated() #%d error = %v", i+1, err)
+		}
+	}
+	if ok, err := s.IsGenerated(id); err != nil || !ok {
+		t.Errorf("IsGenerated() = %v, %v; want true", ok, err)
+	}
+}
+
+func testBackfillCheckpoints(t *testing.T, s Store) {
+	if got, err := s.BackfillCheckpoint("work", "INBOX"); err != nil || got != (BackfillCheckpoint{}) {
+		t.Errorf("BackfillCheckpoint() of a new mailbox = %+v, %v; want a zero checkpoint", got, err)
+	}
+	want := BackfillCheckpoint{LastUID: 500, Processed: 120, Completed: true}
+	if err := s.SaveBackfillCheckpoint("work", "INBOX", BackfillCheckpoint{LastUID: 100, Processed: 20}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveBackfillCheckpoint("work", "INBOX", want); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveBackfillCheckpoint("work", "Archive", BackfillCheckpoint{LastUID: 9}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.BackfillCheckpoint("work", "INBOX"); err != nil || got != want {
+		t.Errorf("BackfillCheckpoint() = %+v, %v; want %+v", got, err, want)
+	}
+
+	if err := s.ResetBackfill("work", "INBOX"); err != nil {
+		t.Fatalf("ResetBackfill() error = %v", err)
+	}
+	if got, err := s.BackfillCheckpoint("work", "INBOX"); err != nil || got != (BackfillCheckpoint{}) {
+		t.Errorf("BackfillCheckpoint() after reset = %+v, %v; want a zero checkpoint", got, err)
+	}
+	if got, err := s.BackfillCheckpoint("work", "Archive"); err != nil || got.LastUID != 9 {
+		t.Errorf("BackfillCheckpoint() of another mailbox after reset = %+v, %v; want it kept", got, err)
+	}
+}
+
+func testRuleStats(t *testing.T, s Store) {
+	if got, err := s.RuleStats(); err != nil || len(got) != 0 {
+		t.Errorf("RuleStats() of a new store = %v, %v; want none", got, err)
+	}
+	at := time.Unix(1700000000, 0)
+	if err := s.SaveRuleStats("invoices", RuleStats{Matches: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveRuleStats("invoices", RuleStats{Matches: 3, Actions: 5, Errors: 1, LastMatch: at}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveRuleStats("never", RuleStats{}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.RuleStats()
+	if err != nil {
+		t.Fatalf("RuleStats() error = %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("RuleStats() = %v; want 2 rules", got)
+	}
+	if rs := got["invoices"]; rs.Matches != 3 || rs.Actions != 5 || rs.Errors != 1 || !rs.LastMatch.Equal(at) {
+		t.Errorf("stats of invoices = %+v; want the last saved", rs)
+	}
+	if rs := got["never"]; !rs.LastMatch.IsZero() {
+		t.Errorf("LastMatch of a rule that never matched = %v; want the zero time", rs.LastMatch)
+	}
+}
+
+func testDigests(t *testing.T, s Store) {
+	at := time.Unix(1700000000, 0)
+	for i, subject := range []string{"first", "second"} {
+		e := notify.Entry{Account: "work", MessageID: fmt.Sprintf("<%d@example.com>", i), Subject: subject, Rule: "r", Label: "l"}
+		if err := s.QueueDigestEntry("work", e, at.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatalf("QueueDigestEntry() error = %v", err)
+		}
+	}
+	if err := s.QueueDigestEntry("home", notify.Entry{Subject: "other"}, at); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, first, err := s.DigestEntries("work")
+	if err != nil {
+		t.Fatalf("DigestEntries() error = %v", err)
+	}
+	var subjects []string
+	for _, e := range entries {
+		subjects = append(subjects, e.Subject)
+	}
+	if !reflect.DeepEqual(subjects, []string{"first", "second"}) || !first.Equal(at) {
+		t.Errorf("DigestEntries() = %v, %v; want [first second] queued first at %v", subjects, first, at)
+	}
+	if entries[0].MessageID != "<0@example.com>" || entries[0].Rule != "r" || entries[0].Label != "l" {
+		t.Errorf("digest entry = %+v; want the queued fields", entries[0])
+	}
+
+	if err := s.ClearDigest("work"); err != nil {
+		t.Fatalf("ClearDigest() error = %v", err)
+	}
+	if entries, first, err := s.DigestEntries("work"); err != nil || len(entries) != 0 || !first.IsZero() {
+		t.Errorf("DigestEntries() after clearing = %v, %v, %v; want none", entries, first, err)
+	}
+	if entries, _, err := s.DigestEntries("home"); err != nil || len(entries) != 1 {
+		t.Errorf("DigestEntries() of another account = %v, %v; want it kept", entries, err)
+	}
+}
+
+func testSnoozes(t *testing.T, s Store) {
+	now := time.Unix(1700000000, 0)
+	later := &Snooze{AccountID: "work", MessageID: "<later@example.com>", Mailbox: "Snoozed", Until: now.Add(time.Hour)}
+	due := &Snooze{AccountID: "work", MessageID: "<due@example.com>", Mailbox: "Snoozed", Until: now}
+	earlier := &Snooze{AccountID: "work", MessageID: "<earlier@example.com>", Mailbox: "Snoozed", Until: now.Add(-time.Hour)}
+	other := &Snooze{AccountID: "home", MessageID: "<home@example.com>", Mailbox: "Snoozed", Until: now.Add(-time.Hour)}
+	for _, sn := range []*Snooze{later, due, earlier, other} {
+		if err := s.CreateSnooze(sn); err != nil {
+			t.Fatalf("CreateSnooze() error = %v", err)
+		}
+	}
+	if later.ID == 0 || later.ID == due.ID || due.ID == earlier.ID {
+		t.Errorf("snooze IDs = %d, %d, %d; want distinct IDs", later.ID, due.ID, earlier.ID)
+	}
+
+	got, err := s.DueSnoozes("work", now)
+	if err != nil {
+		t.Fatalf("DueSnoozes() error = %v", err)
+	}
+	if len(got) != 2 || got[0].ID != earlier.ID || got[1].ID != due.ID {
+		t.Fatalf("DueSnoozes() = %+v; want the earlier and the due snooze, in that order", got)
+	}
+	if sn := got[1]; sn.AccountID != "work" || sn.MessageID != due.MessageID || sn.Mailbox != "Snoozed" || !sn.Until.Equal(now) {
+		t.Errorf("due snooze = %+v; want %+v", sn, *due)
+	}
+
+	if err := s.DeleteSnooze(earlier.ID); err != nil {
+		t.Fatalf("DeleteSnooze() error = %v", err)
+	}
+	if got, err := s.DueSnoozes("work", now); err != nil || len(got) != 1 || got[0].ID != due.ID {
+		t.Errorf("DueSnoozes() after deleting = %+v, %v; want the due snooze", got, err)
+	}
+}
+
+func testReminders(t *testing.T, s Store) {
+	now := time.Unix(1700000000, 0)
+	reminder := func(messageID string, due time.Time) *Reminder {
+		return &Reminder{
+			AccountID: "work",
+			Key:       "mid:" + messageID,
+			Rule:      config.Rule{Label: "waiting"},
+			Email:     email.Email{MessageID: messageID, Subject: "Proposal"},
+			Due:       due,
+		}
+	}
+	first, second := reminder("<a@example.com>", now.Add(-time.Hour)), reminder("<b@example.com>", now)
+	for _, r := range []*Reminder{first, second, reminder("<c@example.com>", now.Add(time.Hour))} {
+		if created, err := s.CreateReminder(r); err != nil || !created || r.ID == 0 {
+			t.Fatalf("CreateReminder() = %v, %v with ID %d; want a new reminder", created, err, r.ID)
+		}
+	}
+	duplicate := reminder("<a@example.com>", now.Add(-2*time.Hour))
+	if created, err := s.CreateReminder(duplicate); err != nil || created || duplicate.ID != 0 {
+		t.Errorf("CreateReminder() of a message with a reminder = %v, %v with ID %d; want none created", created, err, duplicate.ID)
+	}
+
+	got, err := s.DueReminders("work", now)
+	if err != nil {
+		t.Fatalf("DueReminders() error = %v", err)
+	}
+	if len(got) != 2 || got[0].ID != first.ID || got[1].ID != second.ID {
+		t.Fatalf("DueReminders() = %+v; want the first two, in order", got)
+	}
+	if r := got[0]; r.Key != first.Key || r.Rule.Label != "waiting" || r.Email.Subject != "Proposal" || !r.Due.Equal(first.Due) {
+		t.Errorf("due reminder = %+v; want %+v", r, *first)
+	}
+
+	if n, err := s.CancelReminders("work", []string{"<b@example.com>", "<unknown@example.com>"}); err != nil || n != 1 {
+		t.Errorf("CancelReminders() = %d, %v; want 1", n, err)
+	}
+	if n, err := s.CancelReminders("work", nil); err != nil || n != 0 {
+		t.Errorf("CancelReminders() of no messages = %d, %v; want 0", n, err)
+	}
+	if err := s.DeleteReminder(first.ID); err != nil {
+		t.Fatalf("DeleteReminder() error = %v", err)
+	}
+	if got, err := s.DueReminders("work", now); err != nil || len(got) != 0 {
+		t.Errorf("DueReminders() after cancelling and deleting = %+v, %v; want none", got, err)
+	}
+}
+
+func testThreadLabels(t *testing.T, s Store) {
+	at := time.Unix(1700000000, 0)
+	for _, r := range []struct {
+		label   string
+		inherit bool
+	}{{"project", false}, {"project", true}, {"project", false}, {"billing", false}} {
+		if err := s.RecordThreadLabel("work", "t1", r.label, r.inherit, at); err != nil {
+			t.Fatalf("RecordThreadLabel() error = %v", err)
+		}
+	}
+
+	want := []ThreadLabel{{Label: "billing"}, {Label: "project", Inherit: true}}
+	if got, err := s.ThreadLabels("work", "t1"); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("ThreadLabels() = %+v, %v; want %+v", got, err, want)
+	}
+	if got, err := s.ThreadLabels("home", "t1"); err != nil || len(got) != 0 {
+		t.Errorf("ThreadLabels() of another account = %+v, %v; want none", got, err)
+	}
+}
+
+func testArchive(t *testing.T, s Store) {
+	day := time.Unix(1700000000, 0)
+	archive := func(account, key, from, subject string, date time.Time) {
+		t.Helper()
+		msg := &email.Email{Mailbox: "INBOX", UID: 1, MessageID: "<" + key + ">", From: from, Subject: subject,
+			Date: date, TextBody: "quarterly numbers attached"}
+		if err := s.ArchiveMessage(account, key, msg, date.Add(time.Minute)); err != nil {
+			t.Fatalf("ArchiveMessage() error = %v", err)
+		}
+	}
+	archive("work", "k1", "Billing <billing@acme.example>", "Invoice 1", day)
+	archive("work", "k2", "news@example.com", "Weekly newsletter", day.Add(24*time.Hour))
+	archive("work", "k3", "Billing <billing@acme.example>", "Invoice 2", day.Add(48*time.Hour))
+	archive("home", "k4", "billing@acme.example", "Invoice 3", day.Add(72*time.Hour))
+	// A second fetch replaces the first copy
+	archive("work", "k1", "Billing <billing@acme.example>", "Invoice 1 (updated)", day)
+
+	keys := func(messages []ArchivedMessage) []string {
+		var keys []string
+		for _, m := range messages {
+			keys = append(keys, m.Key)
+		}
+		return keys
+	}
+	tests := []struct {
+		name string
+		q    ArchiveQuery
+		want []string
+	}{
+		{"everything", ArchiveQuery{}, []string{"k4", "k3", "k2", "k1"}},
+		{"account", ArchiveQuery{AccountID: "work"}, []string{"k3", "k2", "k1"}},
+		{"sender", ArchiveQuery{AccountID: "work", From: "BILLING@acme"}, []string{"k3", "k1"}},
+		{"subject", ArchiveQuery{Subject: "invoice"}, []string{"k4", "k3", "k1"}},
+		{"since", ArchiveQuery{AccountID: "work", Since: day.Add(24 * time.Hour)}, []string{"k3", "k2"}},
+		{"before", ArchiveQuery{AccountID: "work", Before: day.Add(24 * time.Hour)}, []string{"k1"}},
+		{"limit", ArchiveQuery{Limit: 2}, []string{"k4", "k3"}},
+		{"wildcards are literal", ArchiveQuery{Subject: "%"}, nil},
+	}
+	for _, tt := range tests {
+		got, err := s.SearchArchive(tt.q)
+		if err != nil {
+			t.Fatalf("SearchArchive(%s) error = %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(keys(got), tt.want) {
+			t.Errorf("SearchArchive(%s) = %v; want %v", tt.name, keys(got), tt.want)
+		}
+	}
+
+	got, err := s.SearchArchive(ArchiveQuery{AccountID: "work", Before: day.Add(time.Hour)})
+	if err != nil || len(got) != 1 {
+		t.Fatalf("SearchArchive() = %v, %v; want k1", keys(got), err)
+	}
+	if m := got[0]; m.AccountID != "work" || m.Email.Subject != "Invoice 1 (updated)" || m.Email.TextBody == "" ||
+		!m.Email.Date.Equal(day) || !m.ArchivedAt.Equal(day.Add(time.Minute)) {
+		t.Errorf("archived message = %+v; want the updated copy", m)
+	}
+
+	results, err := s.SearchMessages("work", "quarterly invoice", 0)
+	if errors.Is(err, ErrSearchUnavailable) {
+		return
+	}
+	if err != nil {
+		t.Fatalf("SearchMessages() error = %v", err)
+	}
+	var found []string
+	for _, r := range results {
+		found = append(found, r.Key)
+	}
+	if len(found) != 2 || !strings.Contains(strings.Join(found, " "), "k1") || !strings.Contains(strings.Join(found, " "), "k3") {
+		t.Errorf("SearchMessages() = %v; want k1 and k3", found)
+	}
+	if results, err := s.SearchMessages("", "quart*", 1); err != nil || len(results) != 1 {
+		t.Errorf("SearchMessages() of a prefix with a limit = %d results, %v; want 1", len(results), err)
+	}
+}
+
+func testPrune(t *testing.T, s Store) {
+	cutoff := time.Unix(1700000000, 0)
+	old, recent := cutoff.Add(-time.Hour), cutoff.Add(time.Hour)
+
+	for _, m := range []struct {
+		key         string
+		date, at    time.Time
+		shouldPrune bool
+	}{
+		{"old", old, recent, true},
+		{"recent", recent, recent, false},
+		{"undated old", time.Time{}, old, true},
+		{"undated recent", time.Time{}, recent, false},
+	} {
+		if err := s.ArchiveMessage("work", m.key, &email.Email{Subject: m.key, Date: m.date}, m.at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for key, at := range map[string]time.Time{"old": old, "recent": recent} {
+		if err := s.MarkProcessed("work", key, at); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.RecordGenerated("work", "<"+key+">", at); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.RecordThreadLabel("work", key, "label", false, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Zero cutoffs keep everything
+	if n, err := s.Prune(PruneCutoffs{}); err != nil || n != (PruneCounts{}) {
+		t.Errorf("Prune() without cutoffs = %+v, %v; want nothing deleted", n, err)
+	}
+	n, err := s.Prune(PruneCutoffs{Archive: cutoff, Processed: cutoff, Events: cutoff})
+	if err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+	if want := (PruneCounts{Archive: 2, Processed: 1, Events: 2}); n != want {
+		t.Errorf("Prune() = %+v; want %+v", n, want)
+	}
+
+	archived, err := s.SearchArchive(ArchiveQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var subjects []string
+	for _, m := range archived {
+		subjects = append(subjects, m.Email.Subject)
+	}
+	if len(subjects) != 2 || !strings.Contains(strings.Join(subjects, ","), "undated recent") {
+		t.Errorf("archive after pruning = %v; want the recent messages", subjects)
+	}
+	for key, want := range map[string]bool{"old": false, "recent": true} {
+		if ok, err := s.IsProcessed("work", key); err != nil || ok != want {
+			t.Errorf("IsProcessed(%s) after pruning = %v, %v; want %v", key, ok, err, want)
+		}
+		if ok, err := s.IsGenerated("<" + key + ">"); err != nil || ok != want {
+			t.Errorf("IsGenerated(%s) after pruning = %v, %v; want %v", key, ok, err, want)
+		}
+		if labels, err := s.ThreadLabels("work", key); err != nil || (len(labels) == 1) != want {
+			t.Errorf("ThreadLabels(%s) after pruning = %v, %v; want kept %v", key, labels, err, want)
+		}
+	}
+}
+
+func testTasks(t *testing.T, s Store) {
+	created := time.Unix(1700000000, 0)
+	task := func(account, key string) *tasks.Task {
+		return &tasks.Task{
+			AccountID: account,
+			Title:     "Reply to " + key,
+			Source:    tasks.Source{Key: key, Mailbox: "INBOX", UID: 7, MessageID: "<" + key + ">", From: "a@example.com", Date: created.Add(-time.Hour)},
+			Due:       created.Add(24 * time.Hour),
+			Status:    tasks.StatusOpen,
+			Labels:    []string{"todo", "work"},
+			CreatedAt: created,
+		}
+	}
+	first, second, home := task("work", "k1"), task("work", "k2"), task("home", "k3")
+	second.Due = time.Time{}
+	for _, tk := range []*tasks.Task{first, second, home} {
+		if ok, err := s.CreateTask(tk); err != nil || !ok || tk.ID == 0 {
+			t.Fatalf("CreateTask() = %v, %v with ID %d; want a new task", ok, err, tk.ID)
+		}
+	}
+	duplicate := task("work", "k1")
+	if ok, err := s.CreateTask(duplicate); err != nil || ok || duplicate.ID != 0 {
+		t.Errorf("CreateTask() of a message with a task = %v, %v with ID %d; want none created", ok, err, duplicate.ID)
+	}
+
+	list, err := s.Tasks("work")
+	if err != nil {
+		t.Fatalf("Tasks() error = %v", err)
+	}
+	if len(list) != 2 || list[0].ID != first.ID || list[1].ID != second.ID {
+		t.Fatalf("Tasks(work) = %+v; want the two work tasks, oldest first", list)
+	}
+	got := list[0]
+	if got.Title != first.Title || got.Source.Key != "k1" || got.Source.UID != 7 || got.Source.MessageID != "<k1>" ||
+		got.Source.From != "a@example.com" || !got.Source.Date.Equal(first.Source.Date) || !got.Due.Equal(first.Due) ||
+		got.Status != tasks.StatusOpen || !reflect.DeepEqual(got.Labels, first.Labels) || !got.CreatedAt.Equal(created) ||
+		!got.SnoozedUntil.IsZero() {
+		t.Errorf("task = %+v; want %+v", got, *first)
+	}
+	if !list[1].Due.IsZero() {
+		t.Errorf("Due of a task without a due date = %v; want the zero time", list[1].Due)
+	}
+	if all, err := s.Tasks(""); err != nil || len(all) != 3 {
+		t.Errorf("Tasks() of every account = %d tasks, %v; want 3", len(all), err)
+	}
+
+	until := created.Add(2 * time.Hour)
+	if err := s.SnoozeTask(second.ID, until); err != nil {
+		t.Fatalf("SnoozeTask() error = %v", err)
+	}
+	if err := s.SnoozeTask(first.ID, until); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CompleteTask(first.ID); err != nil {
+		t.Fatalf("CompleteTask() error = %v", err)
+	}
+	list, err = s.Tasks("work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list[0].Status != tasks.StatusDone || !list[0].SnoozedUntil.IsZero() {
+		t.Errorf("completed task = %+v; want done and no longer snoozed", list[0])
+	}
+	if list[1].Status != tasks.StatusOpen || !list[1].SnoozedUntil.Equal(until) {
+		t.Errorf("snoozed task = %+v; want open until %v", list[1], until)
+	}
+
+	if err := s.CompleteTask(9999); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("CompleteTask() of an unknown task error = %v; want ErrTaskNotFound", err)
+	}
+	if err := s.SnoozeTask(9999, until); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("SnoozeTask() of an unknown task error = %v; want ErrTaskNotFound", err)
+	}
+}
+
+func testLeases(t *testing.T, s Store) {
+	l, ok := s.(Leaser)
+	if !ok {
+		t.Skip("the backend cannot be shared")
+	}
+	acquire := func(holder string, ttl time.Duration, want bool) {
+		t.Helper()
+		if got, err := l.AcquireLease("work", holder, ttl); err != nil || got != want {
+			t.Errorf("AcquireLease(%s) = %v, %v; want %v", holder, got, err, want)
+		}
+	}
+	acquire("a", time.Minute, true)
+	acquire("b", time.Minute, false)
+	acquire("a", time.Minute, true) // Renewal
+	if got, err := l.AcquireLease("home", "b", time.Minute); err != nil || !got {
+		t.Errorf("AcquireLease() of another account = %v, %v; want true", got, err)
+	}
+
+	if err := l.ReleaseLease("work", "b"); err != nil {
+		t.Fatalf("ReleaseLease() error = %v", err)
+	}
+	acquire("b", time.Minute, false) // Only the holder releases
+	if err := l.ReleaseLease("work", "a"); err != nil {
+		t.Fatalf("ReleaseLease() error = %v", err)
+	}
+	acquire("b", time.Second, true)
+
+	// An expired lease goes to whoever asks
+	time.Sleep(2100 * time.Millisecond)
+	acquire("a", time.Minute, true)
+}
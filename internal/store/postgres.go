@@ -0,0 +1,868 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/notify"
+	"github.com/mshan/go-tsk/internal/tasks"
+
+	// Register the Postgres driver
+	_ "github.com/lib/pq"
+)
+
+// postgresMigrations are applied in order; append new statements, never
+// edit old ones. Times are Unix seconds, as in SQLite.
+var postgresMigrations = []string{
+	`CREATE TABLE account_state (
+		account_id TEXT PRIMARY KEY,
+		last_sync  BIGINT NOT NULL
+	)`,
+	`CREATE TABLE mailbox_cursor (
+		account_id   TEXT NOT NULL,
+		mailbox      TEXT NOT NULL,
+		uid_validity BIGINT NOT NULL,
+		last_uid     BIGINT NOT NULL,
+		PRIMARY KEY (account_id, mailbox)
+	)`,
+	`CREATE TABLE processed_messages (
+		account_id   TEXT NOT NULL,
+		message_key  TEXT NOT NULL,
+		processed_at BIGINT NOT NULL,
+		PRIMARY KEY (account_id, message_key)
+	);
+	CREATE INDEX processed_messages_at ON processed_messages (processed_at)`,
+	`CREATE TABLE generated_messages (
+		message_id TEXT PRIMARY KEY,
+		account_id TEXT NOT NULL,
+		created_at BIGINT NOT NULL
+	)`,
+	`CREATE TABLE backfill_progress (
+		account_id TEXT NOT NULL,
+		mailbox    TEXT NOT NULL,
+		last_uid   BIGINT NOT NULL,
+		processed  BIGINT NOT NULL,
+		completed  BOOLEAN NOT NULL DEFAULT FALSE,
+		PRIMARY KEY (account_id, mailbox)
+	)`,
+	`CREATE TABLE rule_stats (
+		rule_key   TEXT PRIMARY KEY,
+		matches    BIGINT NOT NULL,
+		actions    BIGINT NOT NULL,
+		errors     BIGINT NOT NULL,
+		last_match BIGINT NOT NULL
+	)`,
+	`CREATE TABLE digest_entries (
+		id         BIGSERIAL PRIMARY KEY,
+		account_id TEXT NOT NULL,
+		entry      TEXT NOT NULL,
+		queued_at  BIGINT NOT NULL
+	)`,
+	`CREATE TABLE snoozes (
+		id         BIGSERIAL PRIMARY KEY,
+		account_id TEXT NOT NULL,
+		message_id TEXT NOT NULL,
+		mailbox    TEXT NOT NULL,
+		until      BIGINT NOT NULL
+	)`,
+	`CREATE TABLE reminders (
+		id          BIGSERIAL PRIMARY KEY,
+		account_id  TEXT NOT NULL,
+		message_id  TEXT NOT NULL,
+		message_key TEXT NOT NULL,
+		rule        TEXT NOT NULL,
+		email       TEXT NOT NULL,
+		due_at      BIGINT NOT NULL,
+		UNIQUE (account_id, message_id)
+	)`,
+	`CREATE TABLE thread_labels (
+		account_id TEXT NOT NULL,
+		thread_id  TEXT NOT NULL,
+		label      TEXT NOT NULL,
+		inherit    BOOLEAN NOT NULL,
+		labeled_at BIGINT NOT NULL,
+		PRIMARY KEY (account_id, thread_id, label)
+	)`,
+	`CREATE TABLE messages (
+		account_id  TEXT NOT NULL,
+		message_key TEXT NOT NULL,
+		mailbox     TEXT NOT NULL,
+		uid         BIGINT NOT NULL,
+		message_id  TEXT NOT NULL,
+		sender      TEXT NOT NULL,
+		subject     TEXT NOT NULL,
+		date        BIGINT NOT NULL,
+		email       TEXT NOT NULL,
+		archived_at BIGINT NOT NULL,
+		search      TSVECTOR NOT NULL,
+		PRIMARY KEY (account_id, message_key)
+	);
+	CREATE INDEX messages_date ON messages (account_id, date);
+	CREATE INDEX messages_sender ON messages (account_id, sender);
+	CREATE INDEX messages_subject ON messages (account_id, subject);
+	CREATE INDEX messages_search ON messages USING GIN (search)`,
+	`CREATE TABLE tasks (
+		id            BIGSERIAL PRIMARY KEY,
+		account_id    TEXT NOT NULL,
+		title         TEXT NOT NULL,
+		message_key   TEXT NOT NULL,
+		mailbox       TEXT NOT NULL,
+		uid           BIGINT NOT NULL,
+		message_id    TEXT NOT NULL,
+		sender        TEXT NOT NULL,
+		received_at   BIGINT NOT NULL,
+		due_at        BIGINT NOT NULL,
+		status        TEXT NOT NULL,
+		labels        TEXT NOT NULL,
+		created_at    BIGINT NOT NULL,
+		snoozed_until BIGINT NOT NULL DEFAULT 0,
+		UNIQUE (account_id, message_key)
+	)`,
+	`CREATE TABLE account_leases (
+		account_id TEXT PRIMARY KEY,
+		holder     TEXT NOT NULL,
+		expires_at BIGINT NOT NULL
+	)`,
+	`ALTER TABLE mailbox_cursor ADD COLUMN mod_seq BIGINT NOT NULL DEFAULT 0`,
+}
+
+// migrationLock is the advisory lock key replicas take while migrating,
+// so that only one of them applies each migration
+const migrationLock = 0x67747363 // "gtsc"
+
+// Postgres is the Store kept in a PostgreSQL database, which several
+// go-tsk instances can share
+type Postgres struct {
+	db *sql.DB
+}
+
+// OpenPostgres connects to the PostgreSQL database at dsn, a URL or
+// key=value connection string, and applies pending migrations
+func OpenPostgres(dsn string) (*Postgres, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	s := &Postgres{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// migrate applies migrations newer than the recorded schema version. Each
+// runs in its own transaction holding migrationLock, so replicas starting
+// together apply it once.
+func (s *Postgres) migrate() error {
+	if _, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)`); err != nil {
+		return fmt.Errorf("failed to create schema_version: %w", err)
+	}
+
+	for {
+		applied, err := s.migrateNext()
+		if err != nil {
+			return err
+		}
+		if !applied {
+			return nil
+		}
+	}
+}
+
+// migrateNext applies the next pending migration, if any, and reports
+// whether it applied one
+func (s *Postgres) migrateNext() (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, migrationLock); err != nil {
+		return false, fmt.Errorf("failed to lock the schema: %w", err)
+	}
+	var version int
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version); err != nil {
+		return false, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if version >= len(postgresMigrations) {
+		return false, nil
+	}
+	if _, err := tx.Exec(postgresMigrations[version]); err != nil {
+		return false, fmt.Errorf("migration %d failed: %w", version+1, err)
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_version (version) VALUES ($1)`, version+1); err != nil {
+		return false, fmt.Errorf("failed to record migration %d: %w", version+1, err)
+	}
+	return true, tx.Commit()
+}
+
+// LastSync returns the persisted last sync time for an account, or the zero
+// time if none was saved
+func (s *Postgres) LastSync(accountID string) (time.Time, error) {
+	var unix int64
+	err := s.db.QueryRow(`SELECT last_sync FROM account_state WHERE account_id = $1`, accountID).Scan(&unix)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(unix, 0), nil
+}
+
+// SaveLastSync persists the last sync time for an account
+func (s *Postgres) SaveLastSync(accountID string, t time.Time) error {
+	_, err := s.db.Exec(`INSERT INTO account_state (account_id, last_sync) VALUES ($1, $2)
+		ON CONFLICT (account_id) DO UPDATE SET last_sync = excluded.last_sync`,
+		accountID, t.Unix())
+	return err
+}
+
+// Cursor returns the saved fetch cursor for an account's mailbox, or a zero
+// cursor if none was saved
+func (s *Postgres) Cursor(accountID, mailbox string) (email.Cursor, error) {
+	var c email.Cursor
+	err := s.db.QueryRow(`SELECT uid_validity, last_uid, mod_seq FROM mailbox_cursor WHERE account_id = $1 AND mailbox = $2`,
+		accountID, mailbox).Scan(&c.UIDValidity, &c.LastUID, &c.ModSeq)
+	if err == sql.ErrNoRows {
+		return email.Cursor{}, nil
+	}
+	return c, err
+}
+
+// Cursors returns the saved fetch cursors for all of an account's
+// mailboxes, keyed by mailbox name
+func (s *Postgres) Cursors(accountID string) (map[string]email.Cursor, error) {
+	rows, err := s.db.Query(`SELECT mailbox, uid_validity, last_uid, mod_seq FROM mailbox_cursor WHERE account_id = $1`, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cursors := make(map[string]email.Cursor)
+	for rows.Next() {
+		var mailbox string
+		var c email.Cursor
+		if err := rows.Scan(&mailbox, &c.UIDValidity, &c.LastUID, &c.ModSeq); err != nil {
+			return nil, err
+		}
+		cursors[mailbox] = c
+	}
+	return cursors, rows.Err()
+}
+
+// SaveCursor persists the fetch cursor for an account's mailbox
+func (s *Postgres) SaveCursor(accountID, mailbox string, c email.Cursor) error {
+	_, err := s.db.Exec(`INSERT INTO mailbox_cursor (account_id, mailbox, uid_validity, last_uid, mod_seq) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (account_id, mailbox) DO UPDATE SET uid_validity = excluded.uid_validity,
+			last_uid = excluded.last_uid, mod_seq = excluded.mod_seq`,
+		accountID, mailbox, c.UIDValidity, c.LastUID, c.ModSeq)
+	return err
+}
+
+// IsProcessed reports whether the message with the given key was already
+// processed for an account
+func (s *Postgres) IsProcessed(accountID, key string) (bool, error) {
+	var found bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM processed_messages WHERE account_id = $1 AND message_key = $2)`,
+		accountID, key).Scan(&found)
+	return found, err
+}
+
+// MarkProcessed records that the message with the given key was processed
+// for an account
+func (s *Postgres) MarkProcessed(accountID, key string, at time.Time) error {
+	_, err := s.db.Exec(`INSERT INTO processed_messages (account_id, message_key, processed_at) VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING`,
+		accountID, key, at.Unix())
+	return err
+}
+
+// IsGenerated reports whether the message with the given Message-ID was
+// sent by go-tsk
+func (s *Postgres) IsGenerated(messageID string) (bool, error) {
+	var found bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM generated_messages WHERE message_id = $1)`, messageID).Scan(&found)
+	return found, err
+}
+
+// RecordGenerated records the Message-ID of a message go-tsk sent for an
+// account
+func (s *Postgres) RecordGenerated(accountID, messageID string, at time.Time) error {
+	_, err := s.db.Exec(`INSERT INTO generated_messages (message_id, account_id, created_at) VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING`,
+		messageID, accountID, at.Unix())
+	return err
+}
+
+// BackfillCheckpoint returns the saved backfill progress for an account's
+// mailbox, or a zero checkpoint if none was saved
+func (s *Postgres) BackfillCheckpoint(accountID, mailbox string) (BackfillCheckpoint, error) {
+	var cp BackfillCheckpoint
+	err := s.db.QueryRow(`SELECT last_uid, processed, completed FROM backfill_progress WHERE account_id = $1 AND mailbox = $2`,
+		accountID, mailbox).Scan(&cp.LastUID, &cp.Processed, &cp.Completed)
+	if err == sql.ErrNoRows {
+		return BackfillCheckpoint{}, nil
+	}
+	return cp, err
+}
+
+// SaveBackfillCheckpoint persists backfill progress for an account's mailbox
+func (s *Postgres) SaveBackfillCheckpoint(accountID, mailbox string, cp BackfillCheckpoint) error {
+	_, err := s.db.Exec(`INSERT INTO backfill_progress (account_id, mailbox, last_uid, processed, completed)
+			VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (account_id, mailbox) DO UPDATE SET last_uid = excluded.last_uid,
+			processed = excluded.processed, completed = excluded.completed`,
+		accountID, mailbox, cp.LastUID, cp.Processed, cp.Completed)
+	return err
+}
+
+// ResetBackfill discards saved backfill progress for an account's mailbox
+func (s *Postgres) ResetBackfill(accountID, mailbox string) error {
+	_, err := s.db.Exec(`DELETE FROM backfill_progress WHERE account_id = $1 AND mailbox = $2`, accountID, mailbox)
+	return err
+}
+
+// RuleStats returns the saved statistics of every rule, keyed by the
+// caller's rule key
+func (s *Postgres) RuleStats() (map[string]RuleStats, error) {
+	rows, err := s.db.Query(`SELECT rule_key, matches, actions, errors, last_match FROM rule_stats`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(map[string]RuleStats)
+	for rows.Next() {
+		var key string
+		var rs RuleStats
+		var lastMatch int64
+		if err := rows.Scan(&key, &rs.Matches, &rs.Actions, &rs.Errors, &lastMatch); err != nil {
+			return nil, err
+		}
+		rs.LastMatch = timeOrZero(lastMatch)
+		stats[key] = rs
+	}
+	return stats, rows.Err()
+}
+
+// SaveRuleStats persists the statistics of a rule. Replicas running the
+// same rules overwrite each other's counts rather than adding to them.
+func (s *Postgres) SaveRuleStats(key string, rs RuleStats) error {
+	_, err := s.db.Exec(`INSERT INTO rule_stats (rule_key, matches, actions, errors, last_match) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (rule_key) DO UPDATE SET matches = excluded.matches, actions = excluded.actions,
+			errors = excluded.errors, last_match = excluded.last_match`,
+		key, rs.Matches, rs.Actions, rs.Errors, unixOrZero(rs.LastMatch))
+	return err
+}
+
+// QueueDigestEntry adds a match to an account's pending digest
+func (s *Postgres) QueueDigestEntry(accountID string, e notify.Entry, at time.Time) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO digest_entries (account_id, entry, queued_at) VALUES ($1, $2, $3)`,
+		accountID, string(data), at.Unix())
+	return err
+}
+
+// DigestEntries returns the matches of an account's pending digest, in
+// the order they were queued, and when the first was queued
+func (s *Postgres) DigestEntries(accountID string) ([]notify.Entry, time.Time, error) {
+	rows, err := s.db.Query(`SELECT entry, queued_at FROM digest_entries WHERE account_id = $1 ORDER BY id`, accountID)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer rows.Close()
+
+	var entries []notify.Entry
+	var first time.Time
+	for rows.Next() {
+		var data string
+		var queuedAt int64
+		if err := rows.Scan(&data, &queuedAt); err != nil {
+			return nil, time.Time{}, err
+		}
+		var e notify.Entry
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			return nil, time.Time{}, fmt.Errorf("invalid digest entry: %w", err)
+		}
+		if len(entries) == 0 {
+			first = time.Unix(queuedAt, 0)
+		}
+		entries = append(entries, e)
+	}
+	return entries, first, rows.Err()
+}
+
+// ClearDigest discards an account's pending digest once it was sent
+func (s *Postgres) ClearDigest(accountID string) error {
+	_, err := s.db.Exec(`DELETE FROM digest_entries WHERE account_id = $1`, accountID)
+	return err
+}
+
+// CreateSnooze saves a snooze and sets its ID
+func (s *Postgres) CreateSnooze(sn *Snooze) error {
+	return s.db.QueryRow(`INSERT INTO snoozes (account_id, message_id, mailbox, until) VALUES ($1, $2, $3, $4)
+		RETURNING id`,
+		sn.AccountID, sn.MessageID, sn.Mailbox, sn.Until.Unix()).Scan(&sn.ID)
+}
+
+// DueSnoozes returns an account's snoozes that end at or before now,
+// earliest first
+func (s *Postgres) DueSnoozes(accountID string, now time.Time) ([]Snooze, error) {
+	rows, err := s.db.Query(`SELECT id, message_id, mailbox, until FROM snoozes
+		WHERE account_id = $1 AND until <= $2 ORDER BY until, id`, accountID, now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snoozes []Snooze
+	for rows.Next() {
+		sn := Snooze{AccountID: accountID}
+		var until int64
+		if err := rows.Scan(&sn.ID, &sn.MessageID, &sn.Mailbox, &until); err != nil {
+			return nil, err
+		}
+		sn.Until = time.Unix(until, 0)
+		snoozes = append(snoozes, sn)
+	}
+	return snoozes, rows.Err()
+}
+
+// DeleteSnooze discards a snooze once its message is back or gone
+func (s *Postgres) DeleteSnooze(id int64) error {
+	_, err := s.db.Exec(`DELETE FROM snoozes WHERE id = $1`, id)
+	return err
+}
+
+// CreateReminder saves a reminder and sets its ID. A message only ever has
+// one reminder per account; if it already has one, created is false and r
+// is left unchanged.
+func (s *Postgres) CreateReminder(r *Reminder) (created bool, err error) {
+	rule, err := json.Marshal(r.Rule)
+	if err != nil {
+		return false, err
+	}
+	msg, err := json.Marshal(r.Email)
+	if err != nil {
+		return false, err
+	}
+	var id int64
+	err = s.db.QueryRow(`INSERT INTO reminders (account_id, message_id, message_key, rule, email, due_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT DO NOTHING RETURNING id`,
+		r.AccountID, r.Email.MessageID, r.Key, string(rule), string(msg), r.Due.Unix()).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	r.ID = id
+	return true, nil
+}
+
+// CancelReminders discards an account's reminders of the messages with the
+// given Message-IDs, once one of them was answered, and returns how many
+// there were
+func (s *Postgres) CancelReminders(accountID string, messageIDs []string) (int64, error) {
+	if len(messageIDs) == 0 {
+		return 0, nil
+	}
+	args := []interface{}{accountID}
+	placeholders := make([]string, len(messageIDs))
+	for i, id := range messageIDs {
+		args = append(args, id)
+		placeholders[i] = "$" + strconv.Itoa(i+2)
+	}
+	res, err := s.db.Exec(`DELETE FROM reminders WHERE account_id = $1 AND message_id IN (`+
+		strings.Join(placeholders, ", ")+`)`, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DueReminders returns an account's reminders due at or before now,
+// earliest first
+func (s *Postgres) DueReminders(accountID string, now time.Time) ([]Reminder, error) {
+	rows, err := s.db.Query(`SELECT id, message_key, rule, email, due_at FROM reminders
+		WHERE account_id = $1 AND due_at <= $2 ORDER BY due_at, id`, accountID, now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reminders []Reminder
+	for rows.Next() {
+		r := Reminder{AccountID: accountID}
+		var rule, msg string
+		var due int64
+		if err := rows.Scan(&r.ID, &r.Key, &rule, &msg, &due); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(rule), &r.Rule); err != nil {
+			return nil, fmt.Errorf("invalid reminder rule: %w", err)
+		}
+		if err := json.Unmarshal([]byte(msg), &r.Email); err != nil {
+			return nil, fmt.Errorf("invalid reminder message: %w", err)
+		}
+		r.Due = time.Unix(due, 0)
+		reminders = append(reminders, r)
+	}
+	return reminders, rows.Err()
+}
+
+// DeleteReminder discards a reminder once its follow-up ran
+func (s *Postgres) DeleteReminder(id int64) error {
+	_, err := s.db.Exec(`DELETE FROM reminders WHERE id = $1`, id)
+	return err
+}
+
+// RecordThreadLabel notes that a message of an account's thread got label.
+// Once a label is inherited, it stays so.
+func (s *Postgres) RecordThreadLabel(accountID, threadID, label string, inherit bool, at time.Time) error {
+	_, err := s.db.Exec(`INSERT INTO thread_labels (account_id, thread_id, label, inherit, labeled_at)
+			VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (account_id, thread_id, label) DO UPDATE SET inherit = thread_labels.inherit OR excluded.inherit,
+			labeled_at = excluded.labeled_at`,
+		accountID, threadID, label, inherit, at.Unix())
+	return err
+}
+
+// ThreadLabels returns the labels recorded for an account's thread
+func (s *Postgres) ThreadLabels(accountID, threadID string) ([]ThreadLabel, error) {
+	rows, err := s.db.Query(`SELECT label, inherit FROM thread_labels
+		WHERE account_id = $1 AND thread_id = $2 ORDER BY label`, accountID, threadID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var labels []ThreadLabel
+	for rows.Next() {
+		var l ThreadLabel
+		if err := rows.Scan(&l.Label, &l.Inherit); err != nil {
+			return nil, err
+		}
+		labels = append(labels, l)
+	}
+	return labels, rows.Err()
+}
+
+// ArchiveMessage keeps a message in an account's archive, replacing the
+// copy of an earlier fetch. The search vector weighs the subject above the
+// sender and recipients, and those above the body.
+func (s *Postgres) ArchiveMessage(accountID, key string, msg *email.Email, at time.Time) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	recipients := strings.Join(append(append([]string(nil), msg.To...), msg.Cc...), " ")
+	_, err = s.db.Exec(`INSERT INTO messages (account_id, message_key, mailbox, uid, message_id, sender, subject, date,
+			email, archived_at, search)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10,
+				setweight(to_tsvector('simple', $7::text), 'A') ||
+				setweight(to_tsvector('simple', $6::text || ' ' || $11::text), 'B') ||
+				setweight(to_tsvector('simple', $12::text), 'C'))
+		ON CONFLICT (account_id, message_key) DO UPDATE SET mailbox = excluded.mailbox, uid = excluded.uid,
+			email = excluded.email, archived_at = excluded.archived_at, search = excluded.search`,
+		accountID, key, msg.Mailbox, msg.UID, msg.MessageID, msg.From, msg.Subject, unixOrZero(msg.Date),
+		string(data), at.Unix(), recipients, msg.TextBody)
+	return err
+}
+
+// SearchArchive returns the archived messages q selects, newest first
+func (s *Postgres) SearchArchive(q ArchiveQuery) ([]ArchivedMessage, error) {
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	if q.AccountID != "" {
+		where = append(where, "account_id = "+arg(q.AccountID))
+	}
+	if q.From != "" {
+		where = append(where, "sender ILIKE "+arg(likePattern(q.From)))
+	}
+	if q.Subject != "" {
+		where = append(where, "subject ILIKE "+arg(likePattern(q.Subject)))
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "date >= "+arg(q.Since.Unix()))
+	}
+	if !q.Before.IsZero() {
+		where = append(where, "date < "+arg(q.Before.Unix()))
+	}
+	query := `SELECT account_id, message_key, email, archived_at FROM messages`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY date DESC, archived_at DESC"
+	if q.Limit > 0 {
+		query += " LIMIT " + arg(q.Limit)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []ArchivedMessage
+	for rows.Next() {
+		var m ArchivedMessage
+		var data string
+		var archivedAt int64
+		if err := rows.Scan(&m.AccountID, &m.Key, &data, &archivedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(data), &m.Email); err != nil {
+			return nil, fmt.Errorf("archived message %s: invalid email: %w", m.Key, err)
+		}
+		m.ArchivedAt = time.Unix(archivedAt, 0)
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// SearchMessages returns the archived messages of an account, or of every
+// account if accountID is empty, that contain every word of text, best
+// matches first. A word ending in "*" matches as a prefix. At most limit
+// results are returned, or all if limit is 0.
+func (s *Postgres) SearchMessages(accountID, text string, limit int) ([]SearchResult, error) {
+	expr := tsQuery(text)
+	if expr == "" {
+		return nil, nil
+	}
+	query := `SELECT m.account_id, m.message_key, m.email, m.archived_at,
+			ts_headline('simple', m.subject || ' ' || COALESCE(m.email::jsonb ->> 'TextBody', ''), q,
+				'StartSel=[, StopSel=], MaxWords=12, MinWords=4, MaxFragments=1, FragmentDelimiter=...')
+		FROM messages m, to_tsquery('simple', $1) q
+		WHERE m.search @@ q`
+	args := []interface{}{expr}
+	if accountID != "" {
+		args = append(args, accountID)
+		query += " AND m.account_id = $" + strconv.Itoa(len(args))
+	}
+	query += " ORDER BY ts_rank(m.search, q) DESC"
+	if limit > 0 {
+		args = append(args, limit)
+		query += " LIMIT $" + strconv.Itoa(len(args))
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var r SearchResult
+		var data string
+		var archivedAt int64
+		if err := rows.Scan(&r.AccountID, &r.Key, &data, &archivedAt, &r.Snippet); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(data), &r.Email); err != nil {
+			return nil, fmt.Errorf("archived message %s: invalid email: %w", r.Key, err)
+		}
+		r.ArchivedAt = time.Unix(archivedAt, 0)
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// tsQuery turns search text into a tsquery requiring each word. Words are
+// quoted, so punctuation such as "acme.com" is searched for rather than
+// parsed as query syntax.
+func tsQuery(text string) string {
+	var terms []string
+	for _, word := range strings.Fields(text) {
+		prefix := strings.HasSuffix(word, "*")
+		word = strings.TrimRight(word, "*")
+		if word == "" {
+			continue
+		}
+		term := "'" + strings.NewReplacer(`\`, `\\`, "'", "''").Replace(word) + "'"
+		if prefix {
+			term += ":*"
+		}
+		terms = append(terms, term)
+	}
+	return strings.Join(terms, " & ")
+}
+
+// Prune deletes state older than its cutoff. Autovacuum makes the freed
+// space reusable, so the database stops growing rather than shrinking.
+func (s *Postgres) Prune(c PruneCutoffs) (PruneCounts, error) {
+	var n PruneCounts
+	var err error
+	if !c.Archive.IsZero() {
+		if n.Archive, err = s.deleteRows(`DELETE FROM messages
+				WHERE (CASE WHEN date > 0 THEN date ELSE archived_at END) < $1`, c.Archive); err != nil {
+			return n, fmt.Errorf("failed to prune the archive: %w", err)
+		}
+	}
+	if !c.Processed.IsZero() {
+		if n.Processed, err = s.deleteRows(`DELETE FROM processed_messages WHERE processed_at < $1`, c.Processed); err != nil {
+			return n, fmt.Errorf("failed to prune the processed journal: %w", err)
+		}
+	}
+	if !c.Events.IsZero() {
+		for _, query := range []string{
+			`DELETE FROM thread_labels WHERE labeled_at < $1`,
+			`DELETE FROM generated_messages WHERE created_at < $1`,
+		} {
+			deleted, err := s.deleteRows(query, c.Events)
+			if err != nil {
+				return n, fmt.Errorf("failed to prune events: %w", err)
+			}
+			n.Events += deleted
+		}
+	}
+	return n, nil
+}
+
+// deleteRows runs a delete of the rows older than cutoff and returns how
+// many it deleted
+func (s *Postgres) deleteRows(query string, cutoff time.Time) (int64, error) {
+	res, err := s.db.Exec(query, cutoff.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// CreateTask saves a new task and sets its ID. A message only ever yields
+// one task per account; if it already has one, created is false and t is
+// left unchanged.
+func (s *Postgres) CreateTask(t *tasks.Task) (created bool, err error) {
+	labels, err := json.Marshal(t.Labels)
+	if err != nil {
+		return false, err
+	}
+	var id int64
+	err = s.db.QueryRow(`INSERT INTO tasks (account_id, title, message_key, mailbox, uid, message_id, sender,
+			received_at, due_at, status, labels, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		ON CONFLICT DO NOTHING RETURNING id`,
+		t.AccountID, t.Title, t.Source.Key, t.Source.Mailbox, t.Source.UID, t.Source.MessageID, t.Source.From,
+		unixOrZero(t.Source.Date), unixOrZero(t.Due), string(t.Status), string(labels), t.CreatedAt.Unix()).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	t.ID = id
+	return true, nil
+}
+
+// Tasks returns an account's tasks, or every account's if accountID is
+// empty, oldest first
+func (s *Postgres) Tasks(accountID string) ([]tasks.Task, error) {
+	rows, err := s.db.Query(`SELECT id, account_id, title, message_key, mailbox, uid, message_id, sender, received_at,
+			due_at, status, labels, created_at, snoozed_until FROM tasks WHERE $1 = '' OR account_id = $1 ORDER BY id`,
+		accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []tasks.Task
+	for rows.Next() {
+		var t tasks.Task
+		var received, due, created, snoozed int64
+		var status, labels string
+		if err := rows.Scan(&t.ID, &t.AccountID, &t.Title, &t.Source.Key, &t.Source.Mailbox, &t.Source.UID,
+			&t.Source.MessageID, &t.Source.From, &received, &due, &status, &labels, &created, &snoozed); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(labels), &t.Labels); err != nil {
+			return nil, fmt.Errorf("task %d: invalid labels: %w", t.ID, err)
+		}
+		t.Source.Date = timeOrZero(received)
+		t.Due = timeOrZero(due)
+		t.Status = tasks.Status(status)
+		t.CreatedAt = time.Unix(created, 0)
+		t.SnoozedUntil = timeOrZero(snoozed)
+		list = append(list, t)
+	}
+	return list, rows.Err()
+}
+
+// CompleteTask marks a task as done
+func (s *Postgres) CompleteTask(id int64) error {
+	return s.updateTask(`UPDATE tasks SET status = $1, snoozed_until = 0 WHERE id = $2`, string(tasks.StatusDone), id)
+}
+
+// SnoozeTask hides an open task until the given time
+func (s *Postgres) SnoozeTask(id int64, until time.Time) error {
+	return s.updateTask(`UPDATE tasks SET snoozed_until = $1 WHERE id = $2`, until.Unix(), id)
+}
+
+// updateTask runs an update of one task, returning ErrTaskNotFound if no
+// task has the ID
+func (s *Postgres) updateTask(query string, args ...interface{}) error {
+	res, err := s.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrTaskNotFound
+	}
+	return nil
+}
+
+// AcquireLease takes or renews holder's lease on an account for ttl and
+// reports whether holder has it. Expiry uses the database's clock, so
+// instances need not agree on the time.
+func (s *Postgres) AcquireLease(accountID, holder string, ttl time.Duration) (bool, error) {
+	var got string
+	err := s.db.QueryRow(`INSERT INTO account_leases (account_id, holder, expires_at)
+			VALUES ($1, $2, EXTRACT(EPOCH FROM now())::BIGINT + $3)
+		ON CONFLICT (account_id) DO UPDATE SET holder = excluded.holder, expires_at = excluded.expires_at
+			WHERE account_leases.holder = excluded.holder
+				OR account_leases.expires_at <= EXTRACT(EPOCH FROM now())::BIGINT
+		RETURNING holder`,
+		accountID, holder, int64((ttl+time.Second-1)/time.Second)).Scan(&got)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ReleaseLease gives up holder's lease on an account, if it has it
+func (s *Postgres) ReleaseLease(accountID, holder string) error {
+	_, err := s.db.Exec(`DELETE FROM account_leases WHERE account_id = $1 AND holder = $2`, accountID, holder)
+	return err
+}
+
+// Close closes the database connections
+func (s *Postgres) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,901 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/notify"
+	"github.com/mshan/go-tsk/internal/tasks"
+
+	// Register the SQLite driver
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// migrations are applied in order; append new statements, never edit old ones
+var migrations = []string{
+	`CREATE TABLE account_state (
+		account_id TEXT PRIMARY KEY,
+		last_sync  INTEGER NOT NULL
+	)`,
+	`CREATE TABLE backfill_progress (
+		account_id TEXT PRIMARY KEY,
+		last_uid   INTEGER NOT NULL,
+		processed  INTEGER NOT NULL,
+		completed  INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE TABLE mailbox_cursor (
+		account_id   TEXT NOT NULL,
+		mailbox      TEXT NOT NULL,
+		uid_validity INTEGER NOT NULL,
+		last_uid     INTEGER NOT NULL,
+		PRIMARY KEY (account_id, mailbox)
+	)`,
+	`CREATE TABLE processed_messages (
+		account_id   TEXT NOT NULL,
+		message_key  TEXT NOT NULL,
+		processed_at INTEGER NOT NULL,
+		PRIMARY KEY (account_id, message_key)
+	)`,
+	`CREATE TABLE backfill_progress_v2 (
+		account_id TEXT NOT NULL,
+		mailbox    TEXT NOT NULL,
+		last_uid   INTEGER NOT NULL,
+		processed  INTEGER NOT NULL,
+		completed  INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (account_id, mailbox)
+	);
+	INSERT INTO backfill_progress_v2 (account_id, mailbox, last_uid, processed, completed)
+		SELECT account_id, 'INBOX', last_uid, processed, completed FROM backfill_progress;
+	DROP TABLE backfill_progress;
+	ALTER TABLE backfill_progress_v2 RENAME TO backfill_progress`,
+	`CREATE TABLE tasks (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		account_id  TEXT NOT NULL,
+		title       TEXT NOT NULL,
+		message_key TEXT NOT NULL,
+		mailbox     TEXT NOT NULL,
+		uid         INTEGER NOT NULL,
+		message_id  TEXT NOT NULL,
+		sender      TEXT NOT NULL,
+		received_at INTEGER NOT NULL,
+		due_at      INTEGER NOT NULL,
+		status      TEXT NOT NULL,
+		labels      TEXT NOT NULL,
+		created_at  INTEGER NOT NULL,
+		UNIQUE (account_id, message_key)
+	)`,
+	`CREATE TABLE generated_messages (
+		message_id TEXT PRIMARY KEY,
+		account_id TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	)`,
+	`ALTER TABLE tasks ADD COLUMN snoozed_until INTEGER NOT NULL DEFAULT 0`,
+	`CREATE TABLE rule_stats (
+		rule_key   TEXT PRIMARY KEY,
+		matches    INTEGER NOT NULL,
+		actions    INTEGER NOT NULL,
+		errors     INTEGER NOT NULL,
+		last_match INTEGER NOT NULL
+	)`,
+	`CREATE TABLE digest_entries (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		account_id TEXT NOT NULL,
+		entry      TEXT NOT NULL,
+		queued_at  INTEGER NOT NULL
+	)`,
+	`CREATE TABLE snoozes (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		account_id TEXT NOT NULL,
+		message_id TEXT NOT NULL,
+		mailbox    TEXT NOT NULL,
+		until      INTEGER NOT NULL
+	)`,
+	`CREATE TABLE reminders (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		account_id  TEXT NOT NULL,
+		message_id  TEXT NOT NULL,
+		message_key TEXT NOT NULL,
+		rule        TEXT NOT NULL,
+		email       TEXT NOT NULL,
+		due_at      INTEGER NOT NULL,
+		UNIQUE (account_id, message_id)
+	)`,
+	`CREATE TABLE thread_labels (
+		account_id TEXT NOT NULL,
+		thread_id  TEXT NOT NULL,
+		label      TEXT NOT NULL,
+		inherit    INTEGER NOT NULL,
+		labeled_at INTEGER NOT NULL,
+		PRIMARY KEY (account_id, thread_id, label)
+	)`,
+	`CREATE TABLE messages (
+		account_id  TEXT NOT NULL,
+		message_key TEXT NOT NULL,
+		mailbox     TEXT NOT NULL,
+		uid         INTEGER NOT NULL,
+		message_id  TEXT NOT NULL,
+		sender      TEXT NOT NULL,
+		subject     TEXT NOT NULL,
+		date        INTEGER NOT NULL,
+		email       TEXT NOT NULL,
+		archived_at INTEGER NOT NULL,
+		PRIMARY KEY (account_id, message_key)
+	);
+	CREATE INDEX messages_date ON messages (account_id, date);
+	CREATE INDEX messages_sender ON messages (account_id, sender);
+	CREATE INDEX messages_subject ON messages (account_id, subject)`,
+	`ALTER TABLE mailbox_cursor ADD COLUMN mod_seq INTEGER NOT NULL DEFAULT 0`,
+}
+
+// searchIndex is the full-text index of archived messages. It is not a
+// migration, because SQLite may lack FTS5 and the rest of the store must
+// work without it. Created on an existing archive, it indexes the messages
+// already there.
+const searchIndex = `CREATE VIRTUAL TABLE message_search USING fts5(
+		account_id UNINDEXED,
+		message_key UNINDEXED,
+		subject,
+		sender,
+		recipients,
+		body,
+		tokenize = 'unicode61 remove_diacritics 2'
+	);
+	INSERT INTO message_search (account_id, message_key, subject, sender, recipients, body)
+		SELECT account_id, message_key, subject, sender,
+			COALESCE(json_extract(email, '$.To'), '') || ' ' || COALESCE(json_extract(email, '$.Cc'), ''),
+			COALESCE(json_extract(email, '$.TextBody'), '')
+		FROM messages`
+
+// SQLite is the Store kept in a SQLite database, the default backend
+type SQLite struct {
+	db     *sql.DB
+	search bool // Whether message_search exists
+}
+
+// OpenSQLite opens (creating if needed) the SQLite database at path and
+// applies pending migrations
+func OpenSQLite(path string) (*SQLite, error) {
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	s := &SQLite{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if s.search, err = s.createSearchIndex(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// createSearchIndex creates the full-text index unless it exists, and
+// reports whether there is one
+func (s *SQLite) createSearchIndex() (bool, error) {
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'message_search'`).Scan(&n); err != nil {
+		return false, fmt.Errorf("failed to look up the search index: %w", err)
+	}
+	if n > 0 {
+		return true, nil
+	}
+	if _, err := s.db.Exec(searchIndex); err != nil {
+		if strings.Contains(err.Error(), "no such module") {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to create the search index: %w", err)
+	}
+	return true, nil
+}
+
+// migrate applies migrations newer than the recorded schema version
+func (s *SQLite) migrate() error {
+	if _, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)`); err != nil {
+		return fmt.Errorf("failed to create schema_version: %w", err)
+	}
+
+	var version int
+	if err := s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d failed: %w", i+1, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_version (version) VALUES (?)`, i+1); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LastSync returns the persisted last sync time for an account, or the zero
+// time if none was saved
+func (s *SQLite) LastSync(accountID string) (time.Time, error) {
+	var unix int64
+	err := s.db.QueryRow(`SELECT last_sync FROM account_state WHERE account_id = ?`, accountID).Scan(&unix)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(unix, 0), nil
+}
+
+// SaveLastSync persists the last sync time for an account
+func (s *SQLite) SaveLastSync(accountID string, t time.Time) error {
+	_, err := s.db.Exec(`INSERT INTO account_state (account_id, last_sync) VALUES (?, ?)
+		ON CONFLICT(account_id) DO UPDATE SET last_sync = excluded.last_sync`,
+		accountID, t.Unix())
+	return err
+}
+
+// Cursor returns the saved fetch cursor for an account's mailbox, or a zero
+// cursor if none was saved
+func (s *SQLite) Cursor(accountID, mailbox string) (email.Cursor, error) {
+	var c email.Cursor
+	err := s.db.QueryRow(`SELECT uid_validity, last_uid, mod_seq FROM mailbox_cursor WHERE account_id = ? AND mailbox = ?`,
+		accountID, mailbox).Scan(&c.UIDValidity, &c.LastUID, &c.ModSeq)
+	if err == sql.ErrNoRows {
+		return email.Cursor{}, nil
+	}
+	return c, err
+}
+
+// Cursors returns the saved fetch cursors for all of an account's
+// mailboxes, keyed by mailbox name
+func (s *SQLite) Cursors(accountID string) (map[string]email.Cursor, error) {
+	rows, err := s.db.Query(`SELECT mailbox, uid_validity, last_uid, mod_seq FROM mailbox_cursor WHERE account_id = ?`, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cursors := make(map[string]email.Cursor)
+	for rows.Next() {
+		var mailbox string
+		var c email.Cursor
+		if err := rows.Scan(&mailbox, &c.UIDValidity, &c.LastUID, &c.ModSeq); err != nil {
+			return nil, err
+		}
+		cursors[mailbox] = c
+	}
+	return cursors, rows.Err()
+}
+
+// SaveCursor persists the fetch cursor for an account's mailbox
+func (s *SQLite) SaveCursor(accountID, mailbox string, c email.Cursor) error {
+	_, err := s.db.Exec(`INSERT INTO mailbox_cursor (account_id, mailbox, uid_validity, last_uid, mod_seq) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(account_id, mailbox) DO UPDATE SET uid_validity = excluded.uid_validity,
+			last_uid = excluded.last_uid, mod_seq = excluded.mod_seq`,
+		accountID, mailbox, c.UIDValidity, c.LastUID, c.ModSeq)
+	return err
+}
+
+// IsProcessed reports whether the message with the given key was already
+// processed for an account
+func (s *SQLite) IsProcessed(accountID, key string) (bool, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM processed_messages WHERE account_id = ? AND message_key = ?`,
+		accountID, key).Scan(&n)
+	return n > 0, err
+}
+
+// MarkProcessed records that the message with the given key was processed
+// for an account
+func (s *SQLite) MarkProcessed(accountID, key string, at time.Time) error {
+	_, err := s.db.Exec(`INSERT OR IGNORE INTO processed_messages (account_id, message_key, processed_at) VALUES (?, ?, ?)`,
+		accountID, key, at.Unix())
+	return err
+}
+
+// IsGenerated reports whether the message with the given Message-ID was
+// sent by go-tsk
+func (s *SQLite) IsGenerated(messageID string) (bool, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM generated_messages WHERE message_id = ?`, messageID).Scan(&n)
+	return n > 0, err
+}
+
+// RecordGenerated records the Message-ID of a message go-tsk sent for an
+// account
+func (s *SQLite) RecordGenerated(accountID, messageID string, at time.Time) error {
+	_, err := s.db.Exec(`INSERT OR IGNORE INTO generated_messages (message_id, account_id, created_at) VALUES (?, ?, ?)`,
+		messageID, accountID, at.Unix())
+	return err
+}
+
+// BackfillCheckpoint returns the saved backfill progress for an account's
+// mailbox, or a zero checkpoint if none was saved
+func (s *SQLite) BackfillCheckpoint(accountID, mailbox string) (BackfillCheckpoint, error) {
+	var cp BackfillCheckpoint
+	err := s.db.QueryRow(`SELECT last_uid, processed, completed FROM backfill_progress WHERE account_id = ? AND mailbox = ?`,
+		accountID, mailbox).Scan(&cp.LastUID, &cp.Processed, &cp.Completed)
+	if err == sql.ErrNoRows {
+		return BackfillCheckpoint{}, nil
+	}
+	return cp, err
+}
+
+// SaveBackfillCheckpoint persists backfill progress for an account's mailbox
+func (s *SQLite) SaveBackfillCheckpoint(accountID, mailbox string, cp BackfillCheckpoint) error {
+	_, err := s.db.Exec(`INSERT INTO backfill_progress (account_id, mailbox, last_uid, processed, completed) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(account_id, mailbox) DO UPDATE SET last_uid = excluded.last_uid,
+			processed = excluded.processed, completed = excluded.completed`,
+		accountID, mailbox, cp.LastUID, cp.Processed, cp.Completed)
+	return err
+}
+
+// ResetBackfill discards saved backfill progress for an account's mailbox
+func (s *SQLite) ResetBackfill(accountID, mailbox string) error {
+	_, err := s.db.Exec(`DELETE FROM backfill_progress WHERE account_id = ? AND mailbox = ?`, accountID, mailbox)
+	return err
+}
+
+// RuleStats returns the saved statistics of every rule, keyed by the
+// caller's rule key
+func (s *SQLite) RuleStats() (map[string]RuleStats, error) {
+	rows, err := s.db.Query(`SELECT rule_key, matches, actions, errors, last_match FROM rule_stats`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(map[string]RuleStats)
+	for rows.Next() {
+		var key string
+		var rs RuleStats
+		var lastMatch int64
+		if err := rows.Scan(&key, &rs.Matches, &rs.Actions, &rs.Errors, &lastMatch); err != nil {
+			return nil, err
+		}
+		rs.LastMatch = timeOrZero(lastMatch)
+		stats[key] = rs
+	}
+	return stats, rows.Err()
+}
+
+// SaveRuleStats persists the statistics of a rule
+func (s *SQLite) SaveRuleStats(key string, rs RuleStats) error {
+	_, err := s.db.Exec(`INSERT INTO rule_stats (rule_key, matches, actions, errors, last_match) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(rule_key) DO UPDATE SET matches = excluded.matches, actions = excluded.actions,
+			errors = excluded.errors, last_match = excluded.last_match`,
+		key, rs.Matches, rs.Actions, rs.Errors, unixOrZero(rs.LastMatch))
+	return err
+}
+
+// QueueDigestEntry adds a match to an account's pending digest
+func (s *SQLite) QueueDigestEntry(accountID string, e notify.Entry, at time.Time) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO digest_entries (account_id, entry, queued_at) VALUES (?, ?, ?)`,
+		accountID, string(data), at.Unix())
+	return err
+}
+
+// DigestEntries returns the matches of an account's pending digest, in
+// the order they were queued, and when the first was queued
+func (s *SQLite) DigestEntries(accountID string) ([]notify.Entry, time.Time, error) {
+	rows, err := s.db.Query(`SELECT entry, queued_at FROM digest_entries WHERE account_id = ? ORDER BY id`, accountID)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer rows.Close()
+
+	var entries []notify.Entry
+	var first time.Time
+	for rows.Next() {
+		var data string
+		var queuedAt int64
+		if err := rows.Scan(&data, &queuedAt); err != nil {
+			return nil, time.Time{}, err
+		}
+		var e notify.Entry
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			return nil, time.Time{}, fmt.Errorf("invalid digest entry: %w", err)
+		}
+		if len(entries) == 0 {
+			first = time.Unix(queuedAt, 0)
+		}
+		entries = append(entries, e)
+	}
+	return entries, first, rows.Err()
+}
+
+// ClearDigest discards an account's pending digest once it was sent
+func (s *SQLite) ClearDigest(accountID string) error {
+	_, err := s.db.Exec(`DELETE FROM digest_entries WHERE account_id = ?`, accountID)
+	return err
+}
+
+// CreateSnooze saves a snooze and sets its ID
+func (s *SQLite) CreateSnooze(sn *Snooze) error {
+	res, err := s.db.Exec(`INSERT INTO snoozes (account_id, message_id, mailbox, until) VALUES (?, ?, ?, ?)`,
+		sn.AccountID, sn.MessageID, sn.Mailbox, sn.Until.Unix())
+	if err != nil {
+		return err
+	}
+	sn.ID, err = res.LastInsertId()
+	return err
+}
+
+// DueSnoozes returns an account's snoozes that end at or before now,
+// earliest first
+func (s *SQLite) DueSnoozes(accountID string, now time.Time) ([]Snooze, error) {
+	rows, err := s.db.Query(`SELECT id, message_id, mailbox, until FROM snoozes
+		WHERE account_id = ? AND until <= ? ORDER BY until, id`, accountID, now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snoozes []Snooze
+	for rows.Next() {
+		sn := Snooze{AccountID: accountID}
+		var until int64
+		if err := rows.Scan(&sn.ID, &sn.MessageID, &sn.Mailbox, &until); err != nil {
+			return nil, err
+		}
+		sn.Until = time.Unix(until, 0)
+		snoozes = append(snoozes, sn)
+	}
+	return snoozes, rows.Err()
+}
+
+// DeleteSnooze discards a snooze once its message is back or gone
+func (s *SQLite) DeleteSnooze(id int64) error {
+	_, err := s.db.Exec(`DELETE FROM snoozes WHERE id = ?`, id)
+	return err
+}
+
+// CreateReminder saves a reminder and sets its ID. A message only ever has
+// one reminder per account; if it already has one, created is false and r
+// is left unchanged.
+func (s *SQLite) CreateReminder(r *Reminder) (created bool, err error) {
+	rule, err := json.Marshal(r.Rule)
+	if err != nil {
+		return false, err
+	}
+	msg, err := json.Marshal(r.Email)
+	if err != nil {
+		return false, err
+	}
+	res, err := s.db.Exec(`INSERT OR IGNORE INTO reminders (account_id, message_id, message_key, rule, email, due_at)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+		r.AccountID, r.Email.MessageID, r.Key, string(rule), string(msg), r.Due.Unix())
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	r.ID, err = res.LastInsertId()
+	return true, err
+}
+
+// CancelReminders discards an account's reminders of the messages with the
+// given Message-IDs, once one of them was answered, and returns how many
+// there were
+func (s *SQLite) CancelReminders(accountID string, messageIDs []string) (int64, error) {
+	if len(messageIDs) == 0 {
+		return 0, nil
+	}
+	args := []interface{}{accountID}
+	for _, id := range messageIDs {
+		args = append(args, id)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(messageIDs)), ", ")
+	res, err := s.db.Exec(`DELETE FROM reminders WHERE account_id = ? AND message_id IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DueReminders returns an account's reminders due at or before now,
+// earliest first
+func (s *SQLite) DueReminders(accountID string, now time.Time) ([]Reminder, error) {
+	rows, err := s.db.Query(`SELECT id, message_key, rule, email, due_at FROM reminders
+		WHERE account_id = ? AND due_at <= ? ORDER BY due_at, id`, accountID, now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reminders []Reminder
+	for rows.Next() {
+		r := Reminder{AccountID: accountID}
+		var rule, msg string
+		var due int64
+		if err := rows.Scan(&r.ID, &r.Key, &rule, &msg, &due); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(rule), &r.Rule); err != nil {
+			return nil, fmt.Errorf("invalid reminder rule: %w", err)
+		}
+		if err := json.Unmarshal([]byte(msg), &r.Email); err != nil {
+			return nil, fmt.Errorf("invalid reminder message: %w", err)
+		}
+		r.Due = time.Unix(due, 0)
+		reminders = append(reminders, r)
+	}
+	return reminders, rows.Err()
+}
+
+// DeleteReminder discards a reminder once its follow-up ran
+func (s *SQLite) DeleteReminder(id int64) error {
+	_, err := s.db.Exec(`DELETE FROM reminders WHERE id = ?`, id)
+	return err
+}
+
+// RecordThreadLabel notes that a message of an account's thread got label.
+// Once a label is inherited, it stays so.
+func (s *SQLite) RecordThreadLabel(accountID, threadID, label string, inherit bool, at time.Time) error {
+	_, err := s.db.Exec(`INSERT INTO thread_labels (account_id, thread_id, label, inherit, labeled_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(account_id, thread_id, label) DO UPDATE SET inherit = MAX(inherit, excluded.inherit), labeled_at = excluded.labeled_at`,
+		accountID, threadID, label, inherit, at.Unix())
+	return err
+}
+
+// ThreadLabels returns the labels recorded for an account's thread
+func (s *SQLite) ThreadLabels(accountID, threadID string) ([]ThreadLabel, error) {
+	rows, err := s.db.Query(`SELECT label, inherit FROM thread_labels
+		WHERE account_id = ? AND thread_id = ? ORDER BY label`, accountID, threadID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var labels []ThreadLabel
+	for rows.Next() {
+		var l ThreadLabel
+		if err := rows.Scan(&l.Label, &l.Inherit); err != nil {
+			return nil, err
+		}
+		labels = append(labels, l)
+	}
+	return labels, rows.Err()
+}
+
+// ArchiveMessage keeps a message in an account's archive, replacing the
+// copy of an earlier fetch
+func (s *SQLite) ArchiveMessage(accountID, key string, msg *email.Email, at time.Time) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO messages (account_id, message_key, mailbox, uid, message_id, sender, subject, date,
+			email, archived_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(account_id, message_key) DO UPDATE SET mailbox = excluded.mailbox, uid = excluded.uid,
+			email = excluded.email, archived_at = excluded.archived_at`,
+		accountID, key, msg.Mailbox, msg.UID, msg.MessageID, msg.From, msg.Subject, unixOrZero(msg.Date),
+		string(data), at.Unix())
+	if err != nil {
+		return err
+	}
+	if s.search {
+		if _, err := tx.Exec(`DELETE FROM message_search WHERE account_id = ? AND message_key = ?`, accountID, key); err != nil {
+			return err
+		}
+		recipients := strings.Join(append(append([]string(nil), msg.To...), msg.Cc...), " ")
+		if _, err := tx.Exec(`INSERT INTO message_search (account_id, message_key, subject, sender, recipients, body)
+				VALUES (?, ?, ?, ?, ?, ?)`,
+			accountID, key, msg.Subject, msg.From, recipients, msg.TextBody); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SearchArchive returns the archived messages q selects, newest first
+func (s *SQLite) SearchArchive(q ArchiveQuery) ([]ArchivedMessage, error) {
+	var where []string
+	var args []interface{}
+	if q.AccountID != "" {
+		where, args = append(where, "account_id = ?"), append(args, q.AccountID)
+	}
+	if q.From != "" {
+		where, args = append(where, "sender LIKE ? ESCAPE '\\'"), append(args, likePattern(q.From))
+	}
+	if q.Subject != "" {
+		where, args = append(where, "subject LIKE ? ESCAPE '\\'"), append(args, likePattern(q.Subject))
+	}
+	if !q.Since.IsZero() {
+		where, args = append(where, "date >= ?"), append(args, q.Since.Unix())
+	}
+	if !q.Before.IsZero() {
+		where, args = append(where, "date < ?"), append(args, q.Before.Unix())
+	}
+	query := `SELECT account_id, message_key, email, archived_at FROM messages`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY date DESC, archived_at DESC"
+	if q.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []ArchivedMessage
+	for rows.Next() {
+		var m ArchivedMessage
+		var data string
+		var archivedAt int64
+		if err := rows.Scan(&m.AccountID, &m.Key, &data, &archivedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(data), &m.Email); err != nil {
+			return nil, fmt.Errorf("archived message %s: invalid email: %w", m.Key, err)
+		}
+		m.ArchivedAt = time.Unix(archivedAt, 0)
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// SearchMessages returns the archived messages of an account, or of every
+// account if accountID is empty, that contain every word of text, best
+// matches first. A word ending in "*" matches as a prefix. At most limit
+// results are returned, or all if limit is 0.
+func (s *SQLite) SearchMessages(accountID, text string, limit int) ([]SearchResult, error) {
+	if !s.search {
+		return nil, ErrSearchUnavailable
+	}
+	expr := matchExpr(text)
+	if expr == "" {
+		return nil, nil
+	}
+	query := `SELECT m.account_id, m.message_key, m.email, m.archived_at,
+			snippet(message_search, -1, '[', ']', '...', 12)
+		FROM message_search JOIN messages m
+			ON m.account_id = message_search.account_id AND m.message_key = message_search.message_key
+		WHERE message_search MATCH ?`
+	args := []interface{}{expr}
+	if accountID != "" {
+		query += " AND message_search.account_id = ?"
+		args = append(args, accountID)
+	}
+	query += " ORDER BY rank"
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var r SearchResult
+		var data string
+		var archivedAt int64
+		if err := rows.Scan(&r.AccountID, &r.Key, &data, &archivedAt, &r.Snippet); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(data), &r.Email); err != nil {
+			return nil, fmt.Errorf("archived message %s: invalid email: %w", r.Key, err)
+		}
+		r.ArchivedAt = time.Unix(archivedAt, 0)
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// matchExpr turns search text into an FTS5 query requiring each word.
+// Words are quoted, so punctuation such as "acme.com" is searched for
+// rather than parsed as query syntax.
+func matchExpr(text string) string {
+	var terms []string
+	for _, word := range strings.Fields(text) {
+		prefix := strings.HasSuffix(word, "*")
+		word = strings.TrimRight(word, "*")
+		if word == "" {
+			continue
+		}
+		term := `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+		if prefix {
+			term += "*"
+		}
+		terms = append(terms, term)
+	}
+	return strings.Join(terms, " ")
+}
+
+// Prune deletes state older than its cutoff. SQLite reuses the freed
+// pages, so the database stops growing rather than shrinking.
+func (s *SQLite) Prune(c PruneCutoffs) (PruneCounts, error) {
+	var n PruneCounts
+	var err error
+	if !c.Archive.IsZero() {
+		if s.search {
+			if _, err := s.db.Exec(`DELETE FROM message_search WHERE rowid IN (
+					SELECT message_search.rowid FROM message_search JOIN messages m
+						ON m.account_id = message_search.account_id AND m.message_key = message_search.message_key
+					WHERE (CASE WHEN m.date > 0 THEN m.date ELSE m.archived_at END) < ?)`, c.Archive.Unix()); err != nil {
+				return n, fmt.Errorf("failed to prune the search index: %w", err)
+			}
+		}
+		if n.Archive, err = s.deleteRows(`DELETE FROM messages
+				WHERE (CASE WHEN date > 0 THEN date ELSE archived_at END) < ?`, c.Archive); err != nil {
+			return n, fmt.Errorf("failed to prune the archive: %w", err)
+		}
+	}
+	if !c.Processed.IsZero() {
+		if n.Processed, err = s.deleteRows(`DELETE FROM processed_messages WHERE processed_at < ?`, c.Processed); err != nil {
+			return n, fmt.Errorf("failed to prune the processed journal: %w", err)
+		}
+	}
+	if !c.Events.IsZero() {
+		for _, query := range []string{
+			`DELETE FROM thread_labels WHERE labeled_at < ?`,
+			`DELETE FROM generated_messages WHERE created_at < ?`,
+		} {
+			deleted, err := s.deleteRows(query, c.Events)
+			if err != nil {
+				return n, fmt.Errorf("failed to prune events: %w", err)
+			}
+			n.Events += deleted
+		}
+	}
+	if n.Archive+n.Processed+n.Events > 0 {
+		// Move the deletions out of the write-ahead log
+		if _, err := s.db.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`); err != nil {
+			return n, fmt.Errorf("failed to checkpoint: %w", err)
+		}
+	}
+	return n, nil
+}
+
+// deleteRows runs a delete of the rows older than cutoff and returns how
+// many it deleted
+func (s *SQLite) deleteRows(query string, cutoff time.Time) (int64, error) {
+	res, err := s.db.Exec(query, cutoff.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// likePattern matches values containing s in a LIKE ... ESCAPE '\'
+// clause, which ignores the case of ASCII letters
+func likePattern(s string) string {
+	return "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s) + "%"
+}
+
+// CreateTask saves a new task and sets its ID. A message only ever yields
+// one task per account; if it already has one, created is false and t is
+// left unchanged.
+func (s *SQLite) CreateTask(t *tasks.Task) (created bool, err error) {
+	labels, err := json.Marshal(t.Labels)
+	if err != nil {
+		return false, err
+	}
+	res, err := s.db.Exec(`INSERT OR IGNORE INTO tasks (account_id, title, message_key, mailbox, uid, message_id, sender,
+			received_at, due_at, status, labels, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.AccountID, t.Title, t.Source.Key, t.Source.Mailbox, t.Source.UID, t.Source.MessageID, t.Source.From,
+		unixOrZero(t.Source.Date), unixOrZero(t.Due), string(t.Status), string(labels), t.CreatedAt.Unix())
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	t.ID, err = res.LastInsertId()
+	return true, err
+}
+
+// Tasks returns an account's tasks, or every account's if accountID is
+// empty, oldest first
+func (s *SQLite) Tasks(accountID string) ([]tasks.Task, error) {
+	rows, err := s.db.Query(`SELECT id, account_id, title, message_key, mailbox, uid, message_id, sender, received_at,
+			due_at, status, labels, created_at, snoozed_until FROM tasks WHERE ? = '' OR account_id = ? ORDER BY id`,
+		accountID, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []tasks.Task
+	for rows.Next() {
+		var t tasks.Task
+		var received, due, created, snoozed int64
+		var status, labels string
+		if err := rows.Scan(&t.ID, &t.AccountID, &t.Title, &t.Source.Key, &t.Source.Mailbox, &t.Source.UID,
+			&t.Source.MessageID, &t.Source.From, &received, &due, &status, &labels, &created, &snoozed); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(labels), &t.Labels); err != nil {
+			return nil, fmt.Errorf("task %d: invalid labels: %w", t.ID, err)
+		}
+		t.Source.Date = timeOrZero(received)
+		t.Due = timeOrZero(due)
+		t.Status = tasks.Status(status)
+		t.CreatedAt = time.Unix(created, 0)
+		t.SnoozedUntil = timeOrZero(snoozed)
+		list = append(list, t)
+	}
+	return list, rows.Err()
+}
+
+// CompleteTask marks a task as done
+func (s *SQLite) CompleteTask(id int64) error {
+	return s.updateTask(`UPDATE tasks SET status = ?, snoozed_until = 0 WHERE id = ?`, string(tasks.StatusDone), id)
+}
+
+// SnoozeTask hides an open task until the given time
+func (s *SQLite) SnoozeTask(id int64, until time.Time) error {
+	return s.updateTask(`UPDATE tasks SET snoozed_until = ? WHERE id = ?`, until.Unix(), id)
+}
+
+// updateTask runs an update of one task, returning ErrTaskNotFound if no
+// task has the ID
+func (s *SQLite) updateTask(query string, args ...interface{}) error {
+	res, err := s.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrTaskNotFound
+	}
+	return nil
+}
+
+// unixOrZero stores the zero time as 0 rather than a large negative number
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// timeOrZero is the inverse of unixOrZero
+func timeOrZero(unix int64) time.Time {
+	if unix == 0 {
+		return time.Time{}
+	}
+	return time.Unix(unix, 0)
+}
+
+// Close closes the database
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,250 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/notify"
+	"github.com/mshan/go-tsk/internal/tasks"
+)
+
+// Store persists scheduler state. SQLite, Bolt and Postgres implement it.
+type Store interface {
+	// LastSync returns the persisted last sync time for an account, or the
+	// zero time if none was saved
+	LastSync(accountID string) (time.Time, error)
+	// SaveLastSync persists the last sync time for an account
+	SaveLastSync(accountID string, t time.Time) error
+
+	// Cursor returns the saved fetch cursor for an account's mailbox, or a
+	// zero cursor if none was saved
+	Cursor(accountID, mailbox string) (email.Cursor, error)
+	// Cursors returns the saved fetch cursors for all of an account's
+	// mailboxes, keyed by mailbox name
+	Cursors(accountID string) (map[string]email.Cursor, error)
+	// SaveCursor persists the fetch cursor for an account's mailbox
+	SaveCursor(accountID, mailbox string, c email.Cursor) error
+
+	// IsProcessed reports whether the message with the given key was
+	// already processed for an account
+	IsProcessed(accountID, key string) (bool, error)
+	// MarkProcessed records that the message with the given key was
+	// processed for an account
+	MarkProcessed(accountID, key string, at time.Time) error
+
+	// IsGenerated reports whether the message with the given Message-ID
+	// was sent by go-tsk
+	IsGenerated(messageID string) (bool, error)
+	// RecordGenerated records the Message-ID of a message go-tsk sent for
+	// an account
+	RecordGenerated(accountID, messageID string, at time.Time) error
+
+	// BackfillCheckpoint returns the saved backfill progress for an
+	// account's mailbox, or a zero checkpoint if none was saved
+	BackfillCheckpoint(accountID, mailbox string) (BackfillCheckpoint, error)
+	// SaveBackfillCheckpoint persists backfill progress for an account's
+	// mailbox
+	SaveBackfillCheckpoint(accountID, mailbox string, cp BackfillCheckpoint) error
+	// ResetBackfill discards saved backfill progress for an account's
+	// mailbox
+	ResetBackfill(accountID, mailbox string) error
+
+	// RuleStats returns the saved statistics of every rule, keyed by the
+	// caller's rule key
+	RuleStats() (map[string]RuleStats, error)
+	// SaveRuleStats persists the statistics of a rule
+	SaveRuleStats(key string, rs RuleStats) error
+
+	// QueueDigestEntry adds a match to an account's pending digest
+	QueueDigestEntry(accountID string, e notify.Entry, at time.Time) error
+	// DigestEntries returns the matches of an account's pending digest, in
+	// the order they were queued, and when the first was queued
+	DigestEntries(accountID string) ([]notify.Entry, time.Time, error)
+	// ClearDigest discards an account's pending digest once it was sent
+	ClearDigest(accountID string) error
+
+	// CreateSnooze saves a snooze and sets its ID
+	CreateSnooze(sn *Snooze) error
+	// DueSnoozes returns an account's snoozes that end at or before now,
+	// earliest first
+	DueSnoozes(accountID string, now time.Time) ([]Snooze, error)
+	// DeleteSnooze discards a snooze once its message is back or gone
+	DeleteSnooze(id int64) error
+
+	// CreateReminder saves a reminder and sets its ID. A message only ever
+	// has one reminder per account; if it already has one, created is
+	// false and r is left unchanged.
+	CreateReminder(r *Reminder) (created bool, err error)
+	// CancelReminders discards an account's reminders of the messages
+	// with the given Message-IDs, once one of them was answered, and
+	// returns how many there were
+	CancelReminders(accountID string, messageIDs []string) (int64, error)
+	// DueReminders returns an account's reminders due at or before now,
+	// earliest first
+	DueReminders(accountID string, now time.Time) ([]Reminder, error)
+	// DeleteReminder discards a reminder once its follow-up ran
+	DeleteReminder(id int64) error
+
+	// RecordThreadLabel notes that a message of an account's thread got
+	// label. Once a label is inherited, it stays so.
+	RecordThreadLabel(accountID, threadID, label string, inherit bool, at time.Time) error
+	// ThreadLabels returns the labels recorded for an account's thread
+	ThreadLabels(accountID, threadID string) ([]ThreadLabel, error)
+
+	// ArchiveMessage keeps a message in an account's archive, replacing
+	// the copy of an earlier fetch
+	ArchiveMessage(accountID, key string, msg *email.Email, at time.Time) error
+	// SearchArchive returns the archived messages q selects, newest first
+	SearchArchive(q ArchiveQuery) ([]ArchivedMessage, error)
+	// SearchMessages returns the archived messages of an account, or of
+	// every account if accountID is empty, that contain every word of
+	// text, best matches first. A word ending in "*" matches as a prefix.
+	// At most limit results are returned, or all if limit is 0.
+	SearchMessages(accountID, text string, limit int) ([]SearchResult, error)
+
+	// Prune deletes state older than its cutoff
+	Prune(c PruneCutoffs) (PruneCounts, error)
+
+	// CreateTask saves a new task and sets its ID. A message only ever
+	// yields one task per account; if it already has one, created is
+	// false and t is left unchanged.
+	CreateTask(t *tasks.Task) (created bool, err error)
+	// Tasks returns an account's tasks, or every account's if accountID is
+	// empty, oldest first
+	Tasks(accountID string) ([]tasks.Task, error)
+	// CompleteTask marks a task as done
+	CompleteTask(id int64) error
+	// SnoozeTask hides an open task until the given time
+	SnoozeTask(id int64, until time.Time) error
+
+	// Close closes the store
+	Close() error
+}
+
+// Leaser is implemented by stores several go-tsk instances can share. An
+// instance only processes an account while it holds the account's lease.
+type Leaser interface {
+	// AcquireLease takes or renews holder's lease on an account for ttl
+	// and reports whether holder has it. An unexpired lease of another
+	// holder is left alone.
+	AcquireLease(accountID, holder string, ttl time.Duration) (bool, error)
+	// ReleaseLease gives up holder's lease on an account, if it has it
+	ReleaseLease(accountID, holder string) error
+}
+
+// Open opens the store cfg configures, creating it if needed
+func Open(cfg config.StorageConfig) (Store, error) {
+	// Return a nil Store, not a nil *SQLite or *Bolt, on failure
+	switch cfg.Backend {
+	case "sqlite", "":
+		s, err := OpenSQLite(cfg.Path)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case "bolt":
+		b, err := OpenBolt(cfg.Path)
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+	case "postgres":
+		pg, err := OpenPostgres(cfg.Path)
+		if err != nil {
+			return nil, err
+		}
+		return pg, nil
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+}
+
+// ErrTaskNotFound is returned when no task has the given ID
+var ErrTaskNotFound = errors.New("task not found")
+
+// ErrSearchUnavailable is returned by SearchMessages of the bolt backend,
+// and of SQLite built without FTS5
+var ErrSearchUnavailable = errors.New("full-text search is unavailable; use the postgres backend, or sqlite built with -tags sqlite_fts5")
+
+// BackfillCheckpoint records how far a backfill has progressed
+type BackfillCheckpoint struct {
+	LastUID   uint32
+	Processed int
+	Completed bool
+}
+
+// RuleStats counts how often a rule matched and acted
+type RuleStats struct {
+	Matches   int64
+	Actions   int64 // Actions applied, counting each step of a chain
+	Errors    int64 // Failed actions and scripts
+	LastMatch time.Time
+}
+
+// Snooze is a message moved out of INBOX until a given time
+type Snooze struct {
+	ID        int64
+	AccountID string
+	MessageID string
+	Mailbox   string // Where the message waits
+	Until     time.Time
+}
+
+// Reminder is a message awaiting an answer. If none arrives by Due, the
+// rule's RemindWith follow-up runs on the saved message.
+type Reminder struct {
+	ID        int64
+	AccountID string
+	Key       string // Message key, as for tasks
+	Rule      config.Rule
+	Email     email.Email
+	Due       time.Time
+}
+
+// ThreadLabel is a label go-tsk applied to a message of a thread. Later
+// messages of the thread inherit it if Inherit is set.
+type ThreadLabel struct {
+	Label   string
+	Inherit bool
+}
+
+// ArchivedMessage is a message kept in the archive
+type ArchivedMessage struct {
+	AccountID  string
+	Key        string // Message key, as for tasks
+	Email      email.Email
+	ArchivedAt time.Time // When the message was last fetched
+}
+
+// ArchiveQuery selects archived messages. Unset fields select everything.
+type ArchiveQuery struct {
+	AccountID string
+	From      string // Part of the sender, ignoring case
+	Subject   string // Part of the subject, ignoring case
+	Since     time.Time
+	Before    time.Time
+	Limit     int // Most messages returned; 0 means all
+}
+
+// SearchResult is an archived message matching a full-text search
+type SearchResult struct {
+	ArchivedMessage
+	Snippet string // Matching text, with the matched terms in [brackets]
+}
+
+// PruneCutoffs says which state Prune deletes: what is older than each
+// time. A zero time keeps that state.
+type PruneCutoffs struct {
+	Archive   time.Time // Archived messages, by date or, without one, archive time
+	Processed time.Time // Processed-message journal entries
+	Events    time.Time // Thread label and sent message records
+}
+
+// PruneCounts reports how many records Prune deleted
+type PruneCounts struct {
+	Archive   int64
+	Processed int64
+	Events    int64
+}
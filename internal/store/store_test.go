@@ -0,0 +1,39 @@
+package store
+
+import "testing"
+
+func TestMatchExpr(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"invoice acme", `"invoice" "acme"`},
+		{"  acme.com  ", `"acme.com"`},
+		{`say "hi"`, `"say" """hi"""`},
+		{"invo* *", `"invo"*`},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := matchExpr(tt.text); got != tt.want {
+			t.Errorf("matchExpr(%q) = %q; want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestTSQuery(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"invoice acme", `'invoice' & 'acme'`},
+		{"  acme.com  ", `'acme.com'`},
+		{`o'brien c:\x`, `'o''brien' & 'c:\\x'`},
+		{"invo* *", `'invo':*`},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := tsQuery(tt.text); got != tt.want {
+			t.Errorf("tsQuery(%q) = %q; want %q", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Package systemd implements the sd_notify protocol, through which a
+// Type=notify service tells systemd it has started, is still alive and
+// what it is doing.
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// States understood by systemd; see sd_notify(3)
+const (
+	Ready     = "READY=1"
+	Reloading = "RELOADING=1"
+	Stopping  = "STOPPING=1"
+	Watchdog  = "WATCHDOG=1"
+)
+
+// Available reports whether the process was started by systemd with a
+// notification socket, e.g. as a Type=notify service
+func Available() bool {
+	return os.Getenv("NOTIFY_SOCKET") != ""
+}
+
+// Notify sends state, one or more newline separated assignments, to the
+// service manager. It reports false without error when the process was
+// not started by systemd with NotifyAccess.
+func Notify(state string) (bool, error) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return false, nil
+	}
+	// A leading @ names a socket in the abstract namespace
+	if strings.HasPrefix(path, "@") {
+		path = "\x00" + path[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns how often systemd expects WATCHDOG=1 keepalives
+// from this process (WatchdogSec= of the unit), or 0 if the watchdog is
+// off
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	// The watchdog may be meant for another process of the service
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
@@ -0,0 +1,65 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", path)
+	sent, err := Notify(Ready + "\nSTATUS=2 accounts polling")
+	if err != nil || !sent {
+		t.Fatalf("Notify() = %v, %v; want true, nil", sent, err)
+	}
+
+	buf := make([]byte, 256)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(buf[:n]), "READY=1\nSTATUS=2 accounts polling"; got != want {
+		t.Errorf("received %q; want %q", got, want)
+	}
+
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := Notify(Ready); sent || err != nil {
+		t.Errorf("Notify() without systemd = %v, %v; want false, nil", sent, err)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	tests := []struct {
+		name string
+		usec string
+		pid  string
+		want time.Duration
+	}{
+		{"off", "", "", 0},
+		{"this process", "30000000", pid, 30 * time.Second},
+		{"any process", "5000000", "", 5 * time.Second},
+		{"other process", "30000000", "1", 0},
+		{"invalid", "soon", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("WATCHDOG_USEC", tt.usec)
+			t.Setenv("WATCHDOG_PID", tt.pid)
+			if got := WatchdogInterval(); got != tt.want {
+				t.Errorf("WatchdogInterval() = %s; want %s", got, tt.want)
+			}
+		})
+	}
+}
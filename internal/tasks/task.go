@@ -0,0 +1,78 @@
+package tasks
+
+import (
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+	"github.com/mshan/go-tsk/internal/rules"
+)
+
+// Status is where a task is in its lifecycle
+type Status string
+
+const (
+	StatusOpen Status = "open"
+	StatusDone Status = "done"
+)
+
+// Task is a to-do item created from a matching email
+type Task struct {
+	ID        int64 // Assigned by the store
+	AccountID string
+	Title     string
+	Source    Source
+	Due       time.Time // Zero when the task has no due date
+	Status    Status
+	Labels    []string
+	CreatedAt time.Time
+
+	// SnoozedUntil hides an open task until then; zero if not snoozed
+	SnoozedUntil time.Time
+}
+
+// Visible reports whether the task belongs on the to-do list at now: it is
+// open and not snoozed past now
+func (t Task) Visible(now time.Time) bool {
+	return t.Status == StatusOpen && !t.SnoozedUntil.After(now)
+}
+
+// Source identifies the email a task was created from
+type Source struct {
+	Key       string // Deduplication key, see email.Email.Key
+	Mailbox   string
+	UID       uint32
+	MessageID string
+	From      string
+	Date      time.Time
+}
+
+// FromEmail builds an open task for a message matched by rule. The title
+// is the message's normalized subject; the due date is rule.DueIn after
+// now, if set.
+func FromEmail(accountID string, rule config.Rule, e *email.Email, key string, now time.Time) Task {
+	t := Task{
+		AccountID: accountID,
+		Title:     rules.NormalizeSubject(e.Subject),
+		Source: Source{
+			Key:       key,
+			Mailbox:   e.Mailbox,
+			UID:       e.UID,
+			MessageID: e.MessageID,
+			From:      e.From,
+			Date:      e.Date,
+		},
+		Status:    StatusOpen,
+		CreatedAt: now,
+	}
+	if t.Title == "" {
+		t.Title = "(no subject)"
+	}
+	if rule.DueIn > 0 {
+		t.Due = now.Add(rule.DueIn)
+	}
+	if rule.Label != "" {
+		t.Labels = []string{rule.Label}
+	}
+	return t
+}
@@ -0,0 +1,71 @@
+package tasks
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mshan/go-tsk/internal/config"
+	"github.com/mshan/go-tsk/internal/email"
+)
+
+func TestFromEmail(t *testing.T) {
+	now := time.Date(2024, 3, 5, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		rule    config.Rule
+		subject string
+		title   string
+		due     time.Time
+		labels  []string
+	}{
+		{"plain", config.Rule{Action: "create-task"}, "Review Q3 budget", "Review Q3 budget", time.Time{}, nil},
+		{"reply prefixes", config.Rule{Action: "create-task"}, "Re: Fwd:  Sign   contract", "Sign contract", time.Time{}, nil},
+		{"due and label", config.Rule{Action: "create-task", DueIn: 48 * time.Hour, Label: "work"}, "Invoice #42", "Invoice #42", now.Add(48 * time.Hour), []string{"work"}},
+		{"no subject", config.Rule{Action: "create-task"}, "", "(no subject)", time.Time{}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &email.Email{Mailbox: "INBOX", UID: 7, MessageID: "<m@example.com>", Subject: tt.subject}
+			task := FromEmail("primary", tt.rule, msg, msg.Key(1), now)
+
+			if task.Title != tt.title {
+				t.Errorf("Title = %q; want %q", task.Title, tt.title)
+			}
+			if !task.Due.Equal(tt.due) {
+				t.Errorf("Due = %v; want %v", task.Due, tt.due)
+			}
+			if !reflect.DeepEqual(task.Labels, tt.labels) {
+				t.Errorf("Labels = %v; want %v", task.Labels, tt.labels)
+			}
+			if task.Status != StatusOpen || task.Source.Key != "mid:<m@example.com>" || task.Source.UID != 7 {
+				t.Errorf("task = %+v", task)
+			}
+		})
+	}
+}
+
+func TestVisible(t *testing.T) {
+	now := time.Date(2024, 3, 5, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		task     Task
+		expected bool
+	}{
+		{"open", Task{Status: StatusOpen}, true},
+		{"done", Task{Status: StatusDone}, false},
+		{"snoozed", Task{Status: StatusOpen, SnoozedUntil: now.Add(time.Hour)}, false},
+		{"snooze over", Task{Status: StatusOpen, SnoozedUntil: now.Add(-time.Hour)}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.task.Visible(now); got != tt.expected {
+				t.Errorf("Visible() = %v; want %v", got, tt.expected)
+			}
+		})
+	}
+}
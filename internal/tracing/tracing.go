@@ -0,0 +1,117 @@
+// Package tracing exports OpenTelemetry spans of poll cycles over OTLP
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+// tracerName identifies the spans go-tsk creates
+const tracerName = "github.com/mshan/go-tsk"
+
+// defaultServiceName is reported when the config leaves it unset
+const defaultServiceName = "go-tsk"
+
+// Setup installs the global tracer provider, exporting to the OTLP
+// collector in cfg. The returned func flushes pending spans and stops the
+// exporter. Without an endpoint, spans are no-ops.
+func Setup(ctx context.Context, cfg config.TracingConfig) (func(context.Context) error, error) {
+	if cfg.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Protocol {
+	case "grpc", "":
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.Endpoint)}
+		if cfg.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		if len(cfg.Headers) > 0 {
+			opts = append(opts, otlptracegrpc.WithHeaders(cfg.Headers))
+		}
+		exporter, err = otlptracegrpc.New(ctx, opts...)
+	case "http":
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		if len(cfg.Headers) > 0 {
+			opts = append(opts, otlptracehttp.WithHeaders(cfg.Headers))
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unknown tracing protocol %q", cfg.Protocol)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	name := cfg.ServiceName
+	if name == "" {
+		name = defaultServiceName
+	}
+	sampler := sdktrace.AlwaysSample()
+	if cfg.SampleRatio > 0 && cfg.SampleRatio < 1 {
+		sampler = sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", name))),
+		sdktrace.WithSampler(sampler),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Start starts a span, as a child of the span in ctx if there is one
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err on span, if it is not nil, and ends the span
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Account is the attribute of the account a span works on
+func Account(id string) attribute.KeyValue {
+	return attribute.String("tsk.account", id)
+}
+
+// Mailbox is the attribute of the mailbox a span works on
+func Mailbox(name string) attribute.KeyValue {
+	return attribute.String("tsk.mailbox", name)
+}
+
+// UID is the attribute of the message a span works on
+func UID(uid uint32) attribute.KeyValue {
+	return attribute.Int64("tsk.uid", int64(uid))
+}
+
+// Rule is the attribute of the index of the rule a span applies
+func Rule(i int) attribute.KeyValue {
+	return attribute.Int("tsk.rule", i)
+}
+
+// Messages is the attribute of the number of messages a span handled
+func Messages(n int) attribute.KeyValue {
+	return attribute.Int("tsk.messages", n)
+}
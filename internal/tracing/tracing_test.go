@@ -0,0 +1,40 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mshan/go-tsk/internal/config"
+)
+
+func TestSetup(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.TracingConfig
+		wantErr bool
+	}{
+		{"disabled", config.TracingConfig{}, false},
+		{"unknown protocol", config.TracingConfig{Endpoint: "localhost:4317", Protocol: "zipkin"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shutdown, err := Setup(context.Background(), tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Setup() error = %v; wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if err := shutdown(context.Background()); err != nil {
+					t.Errorf("shutdown() error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestSpansWithoutExporter(t *testing.T) {
+	ctx, span := Start(context.Background(), "poll", Account("primary"), Mailbox("INBOX"), UID(7))
+	_, child := Start(ctx, "action label", Rule(0), Messages(1))
+	End(child, errors.New("boom"))
+	End(span, nil)
+}
@@ -0,0 +1,6 @@
+// Package tskv1 holds the Go types and gRPC client and server generated
+// from the control API in proto/tsk/v1/control.proto. Other services use
+// NewControlServiceClient to drive a daemon.
+package tskv1
+
+//go:generate protoc -I ../../proto --go_out=../.. --go_opt=module=github.com/mshan/go-tsk --go-grpc_out=../.. --go-grpc_opt=module=github.com/mshan/go-tsk tsk/v1/control.proto
@@ -0,0 +1,90 @@
+package webhook
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	sent := time.Unix(1700000000, 0)
+	body := []byte(`{"type":"io.gotsk.rule.matched"}`)
+
+	signed := func(secrets ...string) http.Header {
+		s, err := NewSigner(secrets...)
+		if err != nil {
+			t.Fatalf("NewSigner() error = %v", err)
+		}
+		h := http.Header{}
+		s.Sign(h, body, sent)
+		return h
+	}
+
+	tests := []struct {
+		name     string
+		header   http.Header
+		secrets  []string
+		body     []byte
+		now      time.Time
+		expected error
+	}{
+		{"valid", signed("s1"), []string{"s1"}, body, sent, nil},
+		{"sender rotating", signed("s1", "s2"), []string{"s2"}, body, sent, nil},
+		{"receiver rotating", signed("s2"), []string{"s1", "s2"}, body, sent, nil},
+		{"wrong secret", signed("s1"), []string{"s2"}, body, sent, ErrSignature},
+		{"tampered body", signed("s1"), []string{"s1"}, []byte(`{}`), sent, ErrSignature},
+		{"clock skew within tolerance", signed("s1"), []string{"s1"}, body, sent.Add(4 * time.Minute), nil},
+		{"replayed later", signed("s1"), []string{"s1"}, body, sent.Add(6 * time.Minute), ErrTimestamp},
+		{"from the future", signed("s1"), []string{"s1"}, body, sent.Add(-6 * time.Minute), ErrTimestamp},
+		{"unsigned", http.Header{}, []string{"s1"}, body, sent, ErrNoSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := Verifier{Secrets: tt.secrets, Now: func() time.Time { return tt.now }}
+			if err := v.Verify(tt.header, tt.body); !errors.Is(err, tt.expected) {
+				t.Errorf("Verify() error = %v; want %v", err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSignHeaders(t *testing.T) {
+	s, err := NewSigner("a", "b")
+	if err != nil {
+		t.Fatalf("NewSigner() error = %v", err)
+	}
+	h := http.Header{}
+	s.Sign(h, []byte("x"), time.Unix(42, 0))
+
+	if ts := h.Get(TimestampHeader); ts != "42" {
+		t.Errorf("%s = %q; want 42", TimestampHeader, ts)
+	}
+	sigs := strings.Split(h.Get(SignatureHeader), ",")
+	if len(sigs) != 2 || !strings.HasPrefix(sigs[0], "v1=") || sigs[0] == sigs[1] {
+		t.Errorf("%s = %q; want two distinct v1 signatures", SignatureHeader, h.Get(SignatureHeader))
+	}
+
+	if _, err := NewSigner(); err == nil {
+		t.Error("NewSigner() error = nil; want error without secrets")
+	}
+}
+
+func TestVerifyRequestKeepsBody(t *testing.T) {
+	s, _ := NewSigner("s1")
+	r := httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader("payload"))
+	s.Sign(r.Header, []byte("payload"), time.Now())
+
+	v := Verifier{Secrets: []string{"s1"}}
+	body, err := v.VerifyRequest(r)
+	if err != nil || string(body) != "payload" {
+		t.Fatalf("VerifyRequest() = %q, %v", body, err)
+	}
+	buf := make([]byte, 16)
+	if n, _ := r.Body.Read(buf); string(buf[:n]) != "payload" {
+		t.Errorf("body after verification = %q; want payload", buf[:n])
+	}
+}